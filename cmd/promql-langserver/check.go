@@ -0,0 +1,306 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	config_util "github.com/prometheus/common/config"
+	"github.com/prometheus/prometheus/config"
+
+	"github.com/prometheus-community/promql-langserver/langserver"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// runCheck implements the check subcommand. It mirrors `promtool check config`
+// and `promtool check rules`, including their output and exit codes, but uses the
+// analyzers of the language server.
+func runCheck(args []string) int {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, "usage: promql-langserver check config|rules [--config-file <file>] <files>...")
+	}
+
+	if len(args) < 1 {
+		usage()
+		return 1
+	}
+
+	kind := args[0]
+
+	flags := flag.NewFlagSet("check "+kind, flag.ContinueOnError)
+	configFilePath := flags.String("config-file", "", "Configuration file for the language server")
+
+	if err := flags.Parse(args[1:]); err != nil {
+		return 1
+	}
+
+	if flags.NArg() < 1 {
+		usage()
+		return 1
+	}
+
+	s, err := newHeadlessServer(*configFilePath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	defer s.Close()
+
+	switch kind {
+	case "config":
+		return checkConfigs(s, flags.Args())
+	case "rules":
+		return checkRuleFiles(s, flags.Args())
+	default:
+		usage()
+		return 1
+	}
+}
+
+func newHeadlessServer(configFilePath string) (langserver.HeadlessServer, error) {
+	return newQueryServer(configFilePath, "")
+}
+
+// newQueryServer is newHeadlessServer for subcommands running queries. server is the name of one of the
+// prometheus_servers to run the queries on, prometheus_url is used if it is empty.
+func newQueryServer(configFilePath string, server string) (langserver.HeadlessServer, error) {
+	lsConfig := &langserver.Config{}
+
+	if configFilePath != "" {
+		var err error
+
+		lsConfig, err = langserver.ParseConfigFile(configFilePath)
+		if err != nil {
+			return langserver.HeadlessServer{}, errors.Wrap(err, "error reading config file")
+		}
+	}
+
+	if server != "" {
+		url, ok := lsConfig.PrometheusServers[server]
+		if !ok {
+			return langserver.HeadlessServer{}, errors.Errorf("the Prometheus server %q is not configured in prometheus_servers", server)
+		}
+
+		lsConfig.PrometheusURL = url
+	}
+
+	return langserver.NewHeadlessServer(context.Background(), lsConfig, os.Stderr)
+}
+
+func checkConfigs(s langserver.HeadlessServer, files []string) int {
+	failed := false
+
+	for _, f := range files {
+		ruleFiles, err := checkConfig(f)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "  FAILED:", err)
+
+			failed = true
+		} else {
+			fmt.Printf("  SUCCESS: %d rule files found\n", len(ruleFiles))
+		}
+
+		fmt.Println()
+
+		if checkRuleFiles(s, ruleFiles) != 0 {
+			failed = true
+		}
+	}
+
+	if failed {
+		return 1
+	}
+
+	return 0
+}
+
+func checkConfig(filename string) ([]string, error) {
+	fmt.Println("Checking", filename)
+
+	cfg, err := config.LoadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var ruleFiles []string
+
+	for _, rf := range cfg.RuleFiles {
+		rfs, err := filepath.Glob(rf)
+		if err != nil {
+			return nil, err
+		}
+		// If an explicit file was given, error if it is not accessible.
+		if !strings.Contains(rf, "*") {
+			if len(rfs) == 0 {
+				return nil, errors.Errorf("%q does not point to an existing file", rf)
+			}
+
+			if err := checkFileExists(rfs[0]); err != nil {
+				return nil, errors.Wrapf(err, "error checking rule file %q", rfs[0])
+			}
+		}
+
+		ruleFiles = append(ruleFiles, rfs...)
+	}
+
+	for _, scfg := range cfg.ScrapeConfigs {
+		if err := checkFileExists(scfg.HTTPClientConfig.BearerTokenFile); err != nil {
+			return nil, errors.Wrapf(err, "error checking bearer token file %q", scfg.HTTPClientConfig.BearerTokenFile)
+		}
+
+		if err := checkTLSConfig(scfg.HTTPClientConfig.TLSConfig); err != nil {
+			return nil, err
+		}
+	}
+
+	return ruleFiles, nil
+}
+
+func checkFileExists(fn string) error {
+	// Nothing set, nothing to error on.
+	if fn == "" {
+		return nil
+	}
+
+	_, err := os.Stat(fn)
+
+	return err
+}
+
+func checkTLSConfig(tlsConfig config_util.TLSConfig) error {
+	if err := checkFileExists(tlsConfig.CertFile); err != nil {
+		return errors.Wrapf(err, "error checking client cert file %q", tlsConfig.CertFile)
+	}
+
+	if err := checkFileExists(tlsConfig.KeyFile); err != nil {
+		return errors.Wrapf(err, "error checking client key file %q", tlsConfig.KeyFile)
+	}
+
+	if len(tlsConfig.CertFile) > 0 && len(tlsConfig.KeyFile) == 0 {
+		return errors.Errorf("client cert file %q specified without client key file", tlsConfig.CertFile)
+	}
+
+	if len(tlsConfig.KeyFile) > 0 && len(tlsConfig.CertFile) == 0 {
+		return errors.Errorf("client key file %q specified without client cert file", tlsConfig.KeyFile)
+	}
+
+	return nil
+}
+
+func checkRuleFiles(s langserver.HeadlessServer, files []string) int {
+	failed := false
+
+	// All files are added before any of them is reported on, so
+	// the checks spanning multiple files see the complete set.
+	addErrs := make(map[string]error)
+
+	for _, f := range files {
+		if addErrs[f] = addFile(s, f); addErrs[f] == nil {
+			defer s.CloseDocument(f) // nolint: errcheck
+		}
+	}
+
+	for _, f := range files {
+		fmt.Println("Checking", f)
+
+		var report *langserver.DocumentReport
+
+		err := addErrs[f]
+		if err == nil {
+			report, err = s.GetReport(f)
+		}
+
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "  FAILED:")
+			fmt.Fprintln(os.Stderr, "    ", err)
+
+			failed = true
+		} else if errs := filterDiagnostics(report.Diagnostics, protocol.SeverityError); len(errs) > 0 {
+			fmt.Fprintln(os.Stderr, "  FAILED:")
+
+			for _, d := range errs {
+				fmt.Fprintln(os.Stderr, "    ", formatDiagnostic(f, d))
+			}
+
+			failed = true
+		} else {
+			fmt.Printf("  SUCCESS: %d rules found\n", report.Queries)
+		}
+
+		if report != nil {
+			for _, d := range filterDiagnostics(report.Diagnostics, protocol.SeverityWarning) {
+				fmt.Println("  WARNING:", formatDiagnostic(f, d))
+			}
+		}
+
+		fmt.Println()
+	}
+
+	if failed {
+		return 1
+	}
+
+	return 0
+}
+
+// addFile reads a file from disk and adds it to the server. Files are
+// assumed to be yaml rule files unless their extension says otherwise.
+func addFile(s langserver.HeadlessServer, filename string) error {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	languageID := "yaml"
+
+	switch filepath.Ext(filename) {
+	case ".promql":
+		languageID = "promql"
+	case ".md":
+		languageID = "markdown"
+	case ".jsonnet", ".libsonnet":
+		languageID = "jsonnet"
+	}
+
+	return s.AddDocument(filename, languageID, string(content))
+}
+
+// filterDiagnostics returns all diagnostics with the given severity
+func filterDiagnostics(diagnostics []protocol.Diagnostic, severity protocol.DiagnosticSeverity) []protocol.Diagnostic {
+	var ret []protocol.Diagnostic
+
+	for _, d := range diagnostics {
+		if d.Severity == severity {
+			ret = append(ret, d)
+		}
+	}
+
+	return ret
+}
+
+func formatPosition(filename string, d protocol.Diagnostic) string {
+	// protocol positions are zero based
+	return fmt.Sprintf("%s:%d:%d", filename, int(d.Range.Start.Line)+1, int(d.Range.Start.Character)+1)
+}
+
+func formatDiagnostic(filename string, d protocol.Diagnostic) string {
+	return fmt.Sprintf("%s: %s", formatPosition(filename, d), d.Message)
+}
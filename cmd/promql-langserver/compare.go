@@ -0,0 +1,140 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/prometheus-community/promql-langserver/langserver"
+)
+
+// runCompare implements the compare subcommand. It prints the semantic difference between
+// the rules of two git revisions of a rule file. Without a second revision, the file is
+// compared with the working tree.
+func runCompare(args []string) int {
+	flags := flag.NewFlagSet("compare", flag.ContinueOnError)
+	configFilePath := flags.String("config-file", "", "Configuration file for the language server")
+	output := flags.String("output", "text", "Output format, text or json")
+
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+
+	if flags.NArg() < 2 || flags.NArg() > 3 || (*output != "text" && *output != "json") {
+		fmt.Fprintln(os.Stderr, "usage: promql-langserver compare [--output text|json] [--config-file <file>] <old-rev> [<new-rev>] <file>")
+		return 1
+	}
+
+	filename := flags.Arg(flags.NArg() - 1)
+
+	oldContent, err := fileAtRevision(flags.Arg(0), filename)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+
+	var newContent string
+
+	if flags.NArg() == 3 {
+		newContent, err = fileAtRevision(flags.Arg(1), filename)
+	} else {
+		var content []byte
+
+		content, err = ioutil.ReadFile(filename)
+		newContent = string(content)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+
+	s, err := newHeadlessServer(*configFilePath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	defer s.Close()
+
+	// The documents need distinct URIs, the names are not shown to the user
+	if err := s.AddDocument("old/"+filename, "yaml", oldContent); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+
+	if err := s.AddDocument("new/"+filename, "yaml", newContent); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+
+	comparison, err := s.CompareRuleFiles("old/"+filename, "new/"+filename)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+
+	if *output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+
+		if err := enc.Encode(comparison); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return 1
+		}
+
+		return 0
+	}
+
+	printComparison(filename, comparison)
+
+	return 0
+}
+
+// fileAtRevision returns the content of a file at a git revision
+func fileAtRevision(rev string, filename string) (string, error) {
+	// The ./ prefix makes git resolve the path relative to the current directory
+	out, err := exec.Command("git", "show", rev+":./"+filename).Output()
+	if err != nil {
+		return "", errors.Wrapf(err, "git show failed for %s at %s", filename, rev)
+	}
+
+	return string(out), nil
+}
+
+func printComparison(filename string, c *langserver.RuleComparison) {
+	for _, r := range c.Added {
+		fmt.Printf("%s:%d: added %s %s in group %s\n", filename, r.Line, r.Kind, r.Name, r.Group)
+	}
+
+	for _, r := range c.Removed {
+		fmt.Printf("%s: removed %s %s from group %s\n", filename, r.Kind, r.Name, r.Group)
+	}
+
+	for _, r := range c.Changed {
+		fmt.Printf("%s:%d: changed %s of %s %s in group %s\n",
+			filename, r.New.Line, strings.Join(r.Fields, ", "), r.New.Kind, r.New.Name, r.New.Group)
+
+		for _, e := range r.ExprChanges {
+			fmt.Printf("\t%d:%d: %s -> %s\n", e.Line, e.Column, e.Old, e.New)
+		}
+	}
+}
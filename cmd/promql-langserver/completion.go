@@ -0,0 +1,340 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/prometheus-community/promql-langserver/langserver"
+)
+
+// The kinds of values flags and arguments are completed with
+const (
+	valueNone = iota
+	valueFile
+	valueRules
+	valueOutput
+	valueServer
+	valueString
+)
+
+// cliFlag is a flag of a subcommand, for shell completion
+type cliFlag struct {
+	name  string
+	help  string
+	value int
+}
+
+// cliCommand is a subcommand, for shell completion. words are the fixed words its first argument
+// is completed with, e.g. config and rules for check, files are completed otherwise.
+type cliCommand struct {
+	name  string
+	help  string
+	words []string
+	flags []cliFlag
+}
+
+// configFileFlag is the flag all subcommands read the configuration from
+var configFileFlag = cliFlag{"config-file", "Configuration file for the language server", valueFile} // nolint: gochecknoglobals
+
+// serverFlag selects the Prometheus server queries are run on
+var serverFlag = cliFlag{"server", "Name of one of the prometheus_servers to run the queries on", valueServer} // nolint: gochecknoglobals
+
+// cliCommands describes the command line interface for shell completion, it has to be kept in sync with the flags of the subcommands
+var cliCommands = []cliCommand{ // nolint: gochecknoglobals
+	{name: "check", help: "Check configuration or rule files like promtool", words: []string{"config", "rules"}, flags: []cliFlag{configFileFlag}},
+	{name: "compare", help: "Compare the rules of two revisions of a rule file", flags: []cliFlag{
+		configFileFlag,
+		{"output", "Output format", valueOutput},
+	}},
+	{name: "completion", help: "Generate shell completions", words: []string{"bash", "zsh", "fish"}},
+	{name: "config", help: "Validate the configuration file and test the connection to the Prometheus servers", words: []string{"check"}, flags: []cliFlag{
+		configFileFlag,
+		{"offline", "Don't connect to the Prometheus servers", valueNone},
+	}},
+	{name: "fix", help: "Apply quick fixes to files", flags: []cliFlag{
+		configFileFlag,
+		{"rules", "Comma separated list of the rules whose fixes are applied", valueRules},
+		{"dry-run", "Only report the fixes, don't change any files", valueNone},
+	}},
+	{name: "lint", help: "Print the diagnostics of files", flags: []cliFlag{
+		configFileFlag,
+		{"diff", "Only report diagnostics on lines changed relative to this git ref", valueString},
+		{"output", "Output format", valueOutput},
+		{"snapshots", "Warn about edited rules whose result shape differs from the snapshot", valueNone},
+	}},
+	{name: "repl", help: "Run queries on an interactive prompt", flags: []cliFlag{configFileFlag, serverFlag}},
+	{name: "snapshot", help: "Record the result shapes of rules", flags: []cliFlag{configFileFlag, serverFlag}},
+	{name: "watch", help: "Validate rule files periodically and post the results to a webhook", flags: []cliFlag{
+		configFileFlag,
+		{"webhook", "URL the validation results are posted to", valueString},
+		{"slack", "Send Slack compatible payloads", valueNone},
+		{"interval", "How often the directories are checked for changes", valueString},
+	}},
+}
+
+// serverFlags are the flags of the language server itself, i.e. without subcommand
+var serverFlags = []cliFlag{ // nolint: gochecknoglobals
+	{"config-file", "Configuration file for the language server", valueFile},
+	{"rest-api", "Serve the REST API on the given address", valueString},
+	{"listen-tcp", "Serve the language server over TCP on the given address", valueString},
+	{"listen-ws", "Serve the language server over WebSocket on the given address", valueString},
+	{"demo-mode", "Harden the server for public playgrounds", valueNone},
+	{"read-only", "Disable query execution and local state", valueNone},
+}
+
+// runCompletion implements the completion subcommand. It prints a completion script for a shell,
+// `completion servers` prints the names of the configured Prometheus servers for the scripts.
+func runCompletion(args []string) int {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, "usage: promql-langserver completion bash|zsh|fish")
+	}
+
+	if len(args) < 1 {
+		usage()
+		return 1
+	}
+
+	switch args[0] {
+	case "bash":
+		writeBashCompletion(os.Stdout)
+	case "zsh":
+		// zsh can run bash completion functions through its bashcompinit emulation
+		fmt.Println("autoload -U +X bashcompinit && bashcompinit")
+		writeBashCompletion(os.Stdout)
+	case "fish":
+		writeFishCompletion(os.Stdout)
+	case "servers":
+		return printServers(args[1:])
+	default:
+		usage()
+		return 1
+	}
+
+	return 0
+}
+
+// printServers prints the names of the prometheus_servers of a configuration file, errors are ignored
+// as the output is only used for completion
+func printServers(args []string) int {
+	flags := flag.NewFlagSet("completion servers", flag.ContinueOnError)
+	configFilePath := flags.String("config-file", "promql-lsp.yaml", "Configuration file for the language server")
+
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+
+	config, err := langserver.ParseConfigFile(*configFilePath)
+	if err != nil {
+		return 0
+	}
+
+	var names []string
+
+	for name := range config.PrometheusServers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Println(name)
+	}
+
+	return 0
+}
+
+// flagNames returns the flags in the form they are typed
+func flagNames(flags []cliFlag) string {
+	var names []string
+
+	for _, f := range flags {
+		names = append(names, "--"+f.name)
+	}
+
+	return strings.Join(names, " ")
+}
+
+// flagsWithValue returns the flags whose value is completed with a kind of value
+func flagsWithValue(value int) string {
+	seen := make(map[string]bool)
+
+	var names []string
+
+	for _, c := range append([]cliCommand{{flags: serverFlags}}, cliCommands...) {
+		for _, f := range c.flags {
+			if f.value == value && !seen[f.name] {
+				seen[f.name] = true
+
+				names = append(names, "--"+f.name)
+			}
+		}
+	}
+
+	return strings.Join(names, "|")
+}
+
+func writeBashCompletion(w io.Writer) {
+	var commands []string
+
+	for _, c := range cliCommands {
+		commands = append(commands, c.name)
+	}
+
+	fmt.Fprintf(w, `# bash completion for promql-langserver, generated by "promql-langserver completion bash"
+
+_promql_langserver_servers() {
+    local i config=()
+    for ((i = 1; i < ${#COMP_WORDS[@]} - 1; i++)); do
+        if [[ "${COMP_WORDS[i]}" == --config-file ]]; then
+            config=(--config-file "${COMP_WORDS[i+1]}")
+        fi
+    done
+    promql-langserver completion servers "${config[@]}" 2>/dev/null
+}
+
+_promql_langserver() {
+    local cur="${COMP_WORDS[COMP_CWORD]}" prev="${COMP_WORDS[COMP_CWORD-1]}" flags="" words=""
+
+    case "$prev" in
+    %s)
+        COMPREPLY=($(compgen -f -- "$cur"))
+        return
+        ;;
+    --rules)
+        COMPREPLY=($(compgen -P "${cur%%${cur##*,}}" -W "%s" -- "${cur##*,}"))
+        return
+        ;;
+    --output)
+        COMPREPLY=($(compgen -W "text json" -- "$cur"))
+        return
+        ;;
+    --server)
+        COMPREPLY=($(compgen -W "$(_promql_langserver_servers)" -- "$cur"))
+        return
+        ;;
+    %s)
+        return
+        ;;
+    esac
+
+    if [[ $COMP_CWORD -eq 1 ]]; then
+        COMPREPLY=($(compgen -W "%s %s" -- "$cur"))
+        return
+    fi
+
+    case "${COMP_WORDS[1]}" in
+`, flagsWithValue(valueFile), strings.Join(langserver.QuickFixRules(), " "), flagsWithValue(valueString),
+		strings.Join(commands, " "), flagNames(serverFlags))
+
+	for _, c := range cliCommands {
+		fmt.Fprintf(w, "    %s)\n        flags=%q\n", c.name, flagNames(c.flags))
+
+		if len(c.words) > 0 {
+			fmt.Fprintf(w, "        [[ $COMP_CWORD -eq 2 ]] && words=%q\n", strings.Join(c.words, " "))
+		}
+
+		fmt.Fprintln(w, "        ;;")
+	}
+
+	fmt.Fprint(w, `    *)
+        flags="`+flagNames(serverFlags)+`"
+        ;;
+    esac
+
+    if [[ "$cur" == -* ]]; then
+        COMPREPLY=($(compgen -W "$flags" -- "$cur"))
+    elif [[ -n "$words" ]]; then
+        COMPREPLY=($(compgen -W "$words" -- "$cur"))
+    else
+        COMPREPLY=($(compgen -f -- "$cur"))
+    fi
+}
+
+complete -o filenames -F _promql_langserver promql-langserver
+`)
+}
+
+func writeFishCompletion(w io.Writer) {
+	fmt.Fprint(w, `# fish completion for promql-langserver, generated by "promql-langserver completion fish"
+
+function __promql_langserver_servers
+    set -l args (commandline -opc)
+    set -l config
+    for i in (seq (count $args))
+        if test "$args[$i]" = --config-file; and test $i -lt (count $args)
+            set config --config-file $args[(math $i + 1)]
+        end
+    end
+    promql-langserver completion servers $config 2>/dev/null
+end
+
+complete -c promql-langserver -f
+`)
+
+	var commands []string
+
+	for _, c := range cliCommands {
+		commands = append(commands, c.name)
+		fmt.Fprintf(w, "complete -c promql-langserver -n __fish_use_subcommand -a %s -d %s\n", c.name, fishQuote(c.help))
+	}
+
+	for _, f := range serverFlags {
+		fmt.Fprintf(w, "complete -c promql-langserver -n __fish_use_subcommand %s\n", fishFlag(f))
+	}
+
+	for _, c := range cliCommands {
+		condition := fishQuote("__fish_seen_subcommand_from " + c.name)
+
+		if len(c.words) > 0 {
+			fmt.Fprintf(w, "complete -c promql-langserver -n %s -a %s\n", condition, fishQuote(strings.Join(c.words, " ")))
+		} else {
+			fmt.Fprintf(w, "complete -c promql-langserver -n %s -F\n", condition)
+		}
+
+		for _, f := range c.flags {
+			fmt.Fprintf(w, "complete -c promql-langserver -n %s %s\n", condition, fishFlag(f))
+		}
+	}
+}
+
+// fishFlag returns the options of the fish complete builtin for a flag
+func fishFlag(f cliFlag) string {
+	ret := fmt.Sprintf("-l %s -d %s", f.name, fishQuote(f.help))
+
+	switch f.value {
+	case valueFile:
+		ret += " -r -F"
+	case valueRules:
+		ret += " -x -a " + fishQuote(strings.Join(langserver.QuickFixRules(), " "))
+	case valueOutput:
+		ret += " -x -a 'text json'"
+	case valueServer:
+		ret += " -x -a '(__promql_langserver_servers)'"
+	case valueString:
+		ret += " -x"
+	}
+
+	return ret
+}
+
+// fishQuote quotes a string for fish
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
@@ -0,0 +1,99 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/prometheus-community/promql-langserver/langserver"
+)
+
+// runConfig implements the config check subcommand. It validates the configuration file of the language server
+// and tests whether the Prometheus servers accept the configured credentials.
+func runConfig(args []string) int {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, "usage: promql-langserver config check [--config-file <file>] [--offline]")
+	}
+
+	if len(args) < 1 || args[0] != "check" {
+		usage()
+		return 1
+	}
+
+	flags := flag.NewFlagSet("config check", flag.ContinueOnError)
+	configFilePath := flags.String("config-file", "promql-lsp.yaml", "Configuration file for the language server")
+	offline := flags.Bool("offline", false, "Don't connect to the Prometheus servers")
+
+	if err := flags.Parse(args[1:]); err != nil {
+		return 1
+	}
+
+	if flags.NArg() != 0 {
+		usage()
+		return 1
+	}
+
+	content, err := ioutil.ReadFile(*configFilePath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+
+	problems := langserver.CheckConfig(content)
+
+	if !langserver.HasConfigErrors(problems) && !*offline {
+		config, err := langserver.ParseConfig(content)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return 1
+		}
+
+		problems = append(problems, config.CheckDatasources(context.Background())...)
+	}
+
+	printConfigProblems(os.Stdout, *configFilePath, problems)
+
+	if langserver.HasConfigErrors(problems) {
+		return 1
+	}
+
+	fmt.Printf("%s is valid\n", *configFilePath)
+
+	return 0
+}
+
+// validateConfigFile reports the mistakes in the configuration file before the language server starts,
+// it returns false if the server can't start. Missing files are left to ParseConfigFile.
+func validateConfigFile(path string) bool {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return true
+	}
+
+	problems := langserver.CheckConfig(content)
+	printConfigProblems(os.Stderr, path, problems)
+
+	return !langserver.HasConfigErrors(problems)
+}
+
+func printConfigProblems(w io.Writer, path string, problems []langserver.ConfigProblem) {
+	for _, p := range problems {
+		fmt.Fprintf(w, "%s: %s\n", path, p)
+	}
+}
@@ -0,0 +1,213 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/prometheus-community/promql-langserver/langserver"
+)
+
+// runFix implements the fix subcommand. It applies the quick fixes of the selected rules to files.
+func runFix(args []string) int {
+	flags := flag.NewFlagSet("fix", flag.ContinueOnError)
+	configFilePath := flags.String("config-file", "", "Configuration file for the language server")
+	rules := flags.String("rules", strings.Join(langserver.QuickFixRules(), ","), "Comma separated list of the rules whose fixes are applied")
+	dryRun := flags.Bool("dry-run", false, "Only report the fixes, don't change any files")
+
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+
+	if flags.NArg() < 1 {
+		fmt.Fprintln(os.Stderr, "usage: promql-langserver fix [--rules=<rule>,...] [--dry-run] [--config-file <file>] <files>...")
+		return 1
+	}
+
+	selected := make(map[string]bool)
+
+	for _, rule := range strings.Split(*rules, ",") {
+		rule = strings.TrimSpace(rule)
+		if !isQuickFixRule(rule) {
+			fmt.Fprintf(os.Stderr, "unknown rule %q, available rules are: %s\n", rule, strings.Join(langserver.QuickFixRules(), ", "))
+			return 1
+		}
+
+		selected[rule] = true
+	}
+
+	s, err := newHeadlessServer(*configFilePath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	defer s.Close()
+
+	failed := false
+
+	for _, f := range flags.Args() {
+		if err := addFile(s, f); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", f, err.Error())
+
+			failed = true
+		}
+	}
+
+	counts := make(map[string]int)
+	changedFiles := 0
+
+	for _, f := range flags.Args() {
+		report, err := s.GetReport(f)
+		if err != nil {
+			continue
+		}
+
+		var fixes []langserver.Fix
+
+		for _, fix := range report.Fixes {
+			if selected[fix.Rule] {
+				fixes = append(fixes, fix)
+			}
+		}
+
+		applied, err := applyFixes(f, fixes, *dryRun)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", f, err.Error())
+
+			failed = true
+
+			continue
+		}
+
+		for _, fix := range applied {
+			fmt.Printf("%s: %s: %s\n", formatPosition(f, fix.Diagnostic), fix.Rule, fix.Title)
+			counts[fix.Rule]++
+		}
+
+		if len(applied) > 0 {
+			changedFiles++
+		}
+	}
+
+	total := 0
+
+	var summary []string
+
+	for _, rule := range langserver.QuickFixRules() {
+		if counts[rule] > 0 {
+			total += counts[rule]
+			summary = append(summary, fmt.Sprintf("%s: %d", rule, counts[rule]))
+		}
+	}
+
+	verb := "Fixed"
+	if *dryRun {
+		verb = "Would fix"
+	}
+
+	fmt.Printf("%s %d issues in %d files", verb, total, changedFiles)
+
+	if len(summary) > 0 {
+		fmt.Printf(" (%s)", strings.Join(summary, ", "))
+	}
+
+	fmt.Println()
+
+	if failed {
+		return 1
+	}
+
+	return 0
+}
+
+func isQuickFixRule(rule string) bool {
+	for _, r := range langserver.QuickFixRules() {
+		if r == rule {
+			return true
+		}
+	}
+
+	return false
+}
+
+// applyFixes applies fixes to a file and returns the fixes that have been applied.
+// Fixes overlapping with another fix are skipped, they can be applied by running the command again.
+func applyFixes(filename string, fixes []langserver.Fix, dryRun bool) ([]langserver.Fix, error) {
+	if len(fixes) == 0 {
+		return nil, nil
+	}
+
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var edits []langserver.FixEdit
+
+	// Select the fixes whose edits don't overlap with earlier fixes
+	var applied []langserver.Fix
+
+	for _, fix := range fixes {
+		overlaps := false
+
+		for _, e := range fix.Edits {
+			for _, other := range edits {
+				if e.Start < other.End && other.Start < e.End || e.Start == other.Start {
+					overlaps = true
+				}
+			}
+		}
+
+		if overlaps {
+			continue
+		}
+
+		for _, e := range fix.Edits {
+			if e.Start < 0 || e.Start > e.End || e.End > len(content) {
+				return nil, errors.Errorf("fix %q contains an invalid edit", fix.Title)
+			}
+
+			edits = append(edits, e)
+		}
+
+		applied = append(applied, fix)
+	}
+
+	if dryRun {
+		return applied, nil
+	}
+
+	// Apply the edits back to front, so the offsets of the remaining edits stay valid
+	sort.Slice(edits, func(i, j int) bool {
+		return edits[i].Start > edits[j].Start
+	})
+
+	for _, e := range edits {
+		content = append(content[:e.Start], append([]byte(e.NewText), content[e.End:]...)...)
+	}
+
+	info, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	return applied, ioutil.WriteFile(filename, content, info.Mode())
+}
@@ -0,0 +1,88 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus-community/promql-langserver/langserver"
+)
+
+// TestApplyFixes checks that overlapping fixes are skipped and the others are applied to the file
+func TestApplyFixes(*testing.T) {
+	dir, err := ioutil.TempDir("", "promql-langserver-fix-")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "rules.yml")
+
+	const content = "abcdefghij"
+
+	if err := ioutil.WriteFile(filename, []byte(content), 0600); err != nil {
+		panic(err)
+	}
+
+	// The mode is set explicitly, since the umask applies to new files
+	if err := os.Chmod(filename, 0640); err != nil {
+		panic(err)
+	}
+
+	fixes := []langserver.Fix{
+		{Title: "replace", Edits: []langserver.FixEdit{{Start: 1, End: 3, NewText: "XY"}}},
+		{Title: "overlapping", Edits: []langserver.FixEdit{{Start: 2, End: 4, NewText: "Z"}}},
+		{Title: "insert", Edits: []langserver.FixEdit{{Start: 6, End: 6, NewText: "++"}}},
+		{Title: "delete", Edits: []langserver.FixEdit{{Start: 8, End: 10}}},
+	}
+
+	titles := func(fixes []langserver.Fix) string {
+		var ret []string
+		for _, fix := range fixes {
+			ret = append(ret, fix.Title)
+		}
+
+		return fmt.Sprint(ret)
+	}
+
+	applied, err := applyFixes(filename, fixes, true)
+	if err != nil || titles(applied) != "[replace insert delete]" {
+		panic(fmt.Sprintf("expected the overlapping fix to be skipped, got %v, %v", titles(applied), err))
+	}
+
+	if written, err := ioutil.ReadFile(filename); err != nil || string(written) != content {
+		panic(fmt.Sprintf("expected a dry run not to change the file, got %q, %v", written, err))
+	}
+
+	applied, err = applyFixes(filename, fixes, false)
+	if err != nil || titles(applied) != "[replace insert delete]" {
+		panic(fmt.Sprintf("expected the overlapping fix to be skipped, got %v, %v", titles(applied), err))
+	}
+
+	if written, err := ioutil.ReadFile(filename); err != nil || string(written) != "aXYdef++gh" {
+		panic(fmt.Sprintf("expected the edits to be applied, got %q, %v", written, err))
+	}
+
+	if info, err := os.Stat(filename); err != nil || info.Mode().Perm() != 0640 {
+		panic(fmt.Sprintf("expected the file mode to be kept, got %v, %v", info.Mode(), err))
+	}
+
+	if _, err := applyFixes(filename, []langserver.Fix{{Title: "invalid", Edits: []langserver.FixEdit{{Start: 5, End: 50}}}}, false); err == nil {
+		panic("expected an edit beyond the end of the file to be rejected")
+	}
+}
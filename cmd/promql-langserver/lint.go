@@ -0,0 +1,313 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/prometheus-community/promql-langserver/langserver"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// hunkHeaderRegexp matches the hunk headers of a unified diff and captures the range of the new file
+var hunkHeaderRegexp = regexp.MustCompile(`^@@ -\d+(?:,\d+)? \+(\d+)(?:,(\d+))? @@`)
+
+// runLint implements the lint subcommand. It prints the diagnostics of the given files,
+// optionally restricted to the lines changed relative to a git ref.
+func runLint(args []string) int {
+	flags := flag.NewFlagSet("lint", flag.ContinueOnError)
+	configFilePath := flags.String("config-file", "", "Configuration file for the language server")
+	diffRef := flags.String("diff", "", "Only report diagnostics on lines changed relative to this git ref")
+	output := flags.String("output", "text", "Output format, text or json. The json output includes fix suggestions")
+	snapshots := flags.Bool("snapshots", false, "Warn about edited rules whose result shape differs from the snapshot recorded by the snapshot subcommand")
+
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+
+	files := flags.Args()
+
+	if len(files) == 0 && *diffRef != "" {
+		var err error
+
+		files, err = changedFiles(*diffRef)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return 1
+		}
+	}
+
+	if len(files) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: promql-langserver lint [--diff <ref>] [--snapshots] [--config-file <file>] <files>...")
+		return 1
+	}
+
+	s, err := newHeadlessServer(*configFilePath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	defer s.Close()
+
+	if *output != "text" && *output != "json" {
+		fmt.Fprintf(os.Stderr, "unknown output format %q\n", *output)
+		return 1
+	}
+
+	failed := false
+
+	findings := []lintFinding{}
+
+	for _, f := range files {
+		if err := addFile(s, f); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: error: %s\n", f, err.Error())
+
+			failed = true
+		}
+	}
+
+	for _, f := range files {
+		report, err := s.GetReport(f)
+		if err != nil {
+			continue
+		}
+
+		if *snapshots {
+			diagnostics, err := snapshotDiagnostics(s, f)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: error: %s\n", f, err.Error())
+
+				failed = true
+			}
+
+			report.Diagnostics = append(report.Diagnostics, diagnostics...)
+		}
+
+		var changed map[int]bool
+
+		if *diffRef != "" {
+			changed, err = changedLines(*diffRef, f)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err.Error())
+				return 1
+			}
+		}
+
+		for _, d := range report.Diagnostics {
+			if changed != nil && !diagnosticChanged(d, changed) {
+				continue
+			}
+
+			var severity string
+
+			switch d.Severity {
+			case protocol.SeverityError:
+				severity = "error"
+				failed = true
+			case protocol.SeverityWarning:
+				severity = "warning"
+			case protocol.SeverityInformation:
+				severity = "info"
+			default:
+				continue
+			}
+
+			if *output == "text" {
+				fmt.Printf("%s: %s: %s\n", formatPosition(f, d), severity, d.Message)
+				continue
+			}
+
+			findings = append(findings, newLintFinding(f, severity, d, report.Fixes))
+		}
+	}
+
+	if *output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+
+		if err := enc.Encode(findings); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return 1
+		}
+	}
+
+	if failed {
+		return 1
+	}
+
+	return 0
+}
+
+// snapshotDiagnostics compares a file with its snapshot, files without a snapshot are skipped
+func snapshotDiagnostics(s langserver.HeadlessServer, filename string) ([]protocol.Diagnostic, error) {
+	path := langserver.SnapshotPath(filename)
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	snapshot, err := langserver.ReadSnapshot(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.SnapshotDiagnostics(filename, snapshot)
+}
+
+// lintFinding is a diagnostic in the json output of the lint subcommand
+type lintFinding struct {
+	File string `json:"file"`
+	// Line and Column are one based
+	Line     int       `json:"line"`
+	Column   int       `json:"column"`
+	Severity string    `json:"severity"`
+	Message  string    `json:"message"`
+	Fixes    []lintFix `json:"fixes,omitempty"`
+}
+
+// lintFix is a suggested change resolving a finding
+type lintFix struct {
+	Title string        `json:"title"`
+	Edits []lintFixEdit `json:"edits"`
+}
+
+// lintFixEdit replaces the bytes between Start and End of the file with NewText
+type lintFixEdit struct {
+	Start   int    `json:"start"`
+	End     int    `json:"end"`
+	NewText string `json:"newText"`
+}
+
+func newLintFinding(filename string, severity string, d protocol.Diagnostic, fixes []langserver.Fix) lintFinding {
+	ret := lintFinding{
+		File: filename,
+		// protocol positions are zero based
+		Line:     int(d.Range.Start.Line) + 1,
+		Column:   int(d.Range.Start.Character) + 1,
+		Severity: severity,
+		Message:  d.Message,
+	}
+
+	for _, fix := range fixes {
+		if !reflect.DeepEqual(fix.Diagnostic, d) {
+			continue
+		}
+
+		f := lintFix{Title: fix.Title}
+
+		for _, e := range fix.Edits {
+			f.Edits = append(f.Edits, lintFixEdit{Start: e.Start, End: e.End, NewText: e.NewText})
+		}
+
+		ret.Fixes = append(ret.Fixes, f)
+	}
+
+	return ret
+}
+
+// diagnosticChanged checks whether a diagnostic touches one of the changed lines
+func diagnosticChanged(d protocol.Diagnostic, changed map[int]bool) bool {
+	for line := int(d.Range.Start.Line) + 1; line <= int(d.Range.End.Line)+1; line++ {
+		if changed[line] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// changedFiles returns the rule, query, Markdown and Jsonnet files that differ from a git ref
+func changedFiles(ref string) ([]string, error) {
+	out, err := exec.Command("git", "diff", "--name-only", "--diff-filter=d", "--relative", ref, "--").Output()
+	if err != nil {
+		return nil, errors.Wrapf(err, "git diff failed")
+	}
+
+	var ret []string
+
+	for _, f := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		switch filepath.Ext(f) {
+		case ".yml", ".yaml", ".promql", ".md", ".jsonnet", ".libsonnet":
+			ret = append(ret, f)
+		}
+	}
+
+	return ret, nil
+}
+
+// changedLines returns the (one based) numbers of the lines of a file that differ from a git ref
+func changedLines(ref string, filename string) (map[int]bool, error) {
+	ret := make(map[int]bool)
+
+	// Files unknown to git are new in their entirety
+	if err := exec.Command("git", "ls-files", "--error-unmatch", "--", filename).Run(); err != nil {
+		content, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := 0; i <= bytes.Count(content, []byte("\n")); i++ {
+			ret[i+1] = true
+		}
+
+		return ret, nil
+	}
+
+	out, err := exec.Command("git", "diff", "-U0", "--no-color", ref, "--", filename).Output()
+	if err != nil {
+		return nil, errors.Wrapf(err, "git diff failed for %s", filename)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+
+	for scanner.Scan() {
+		match := hunkHeaderRegexp.FindStringSubmatch(scanner.Text())
+		if match == nil {
+			continue
+		}
+
+		start, err := strconv.Atoi(match[1])
+		if err != nil {
+			return nil, err
+		}
+
+		count := 1
+
+		if match[2] != "" {
+			if count, err = strconv.Atoi(match[2]); err != nil {
+				return nil, err
+			}
+		}
+
+		for line := start; line < start+count; line++ {
+			ret[line] = true
+		}
+	}
+
+	return ret, scanner.Err()
+}
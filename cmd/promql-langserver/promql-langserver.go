@@ -17,21 +17,94 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
 
 	"github.com/prometheus-community/promql-langserver/langserver"
+	"github.com/prometheus-community/promql-langserver/rest"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "check":
+			os.Exit(runCheck(os.Args[2:]))
+		case "completion":
+			os.Exit(runCompletion(os.Args[2:]))
+		case "config":
+			os.Exit(runConfig(os.Args[2:]))
+		case "compare":
+			os.Exit(runCompare(os.Args[2:]))
+		case "fix":
+			os.Exit(runFix(os.Args[2:]))
+		case "lint":
+			os.Exit(runLint(os.Args[2:]))
+		case "repl":
+			os.Exit(runRepl(os.Args[2:]))
+		case "snapshot":
+			os.Exit(runSnapshot(os.Args[2:]))
+		case "watch":
+			os.Exit(runWatch(os.Args[2:]))
+		}
+	}
+
 	configFilePath := flag.String("config-file", "promql-lsp.yaml", "Configuration file for the language server")
+	restAPI := flag.String("rest-api", "", "Serve the REST API on the given address instead of running a language server on stdio, e.g. :8080")
+	listenTCP := flag.String("listen-tcp", "", "Serve the language server over TCP on the given address instead of stdio, every connection is a separate session, e.g. :8082")
+	listenWS := flag.String("listen-ws", "", "Serve the language server over WebSocket on the given address instead of stdio, e.g. :8081")
+	demoMode := flag.Bool("demo-mode", false, "Harden the server for public playgrounds, same as demo_mode in the configuration file")
+	readOnly := flag.Bool("read-only", false, "Disable query execution and local state for shared deployments, same as read_only in the configuration file")
 
 	flag.Parse()
 
+	if !validateConfigFile(*configFilePath) {
+		os.Exit(1)
+	}
+
 	config, err := langserver.ParseConfigFile(*configFilePath)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "Error reading config file:", err.Error())
 		os.Exit(1)
 	}
+
+	if *demoMode {
+		config.DemoMode = true
+	}
+
+	if *readOnly {
+		config.ReadOnly = true
+	}
+
+	if *restAPI != "" {
+		fmt.Fprintln(os.Stderr, "Serving REST API on", *restAPI)
+
+		err := http.ListenAndServe(*restAPI, rest.CreateHandler(context.Background(), config))
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	if *listenWS != "" {
+		handler, err := langserver.WebSocketHandler(context.Background(), config)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+
+		fmt.Fprintln(os.Stderr, "Serving the language server over WebSocket on", *listenWS)
+
+		err = http.ListenAndServe(*listenWS, http.HandlerFunc(handler))
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	if *listenTCP != "" {
+		fmt.Fprintln(os.Stderr, "Serving the language server over TCP on", *listenTCP)
+
+		err := langserver.RunTCPServers(context.Background(), *listenTCP, config)
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
 	_, s := langserver.StdioServer(context.Background(), config)
 	s.Run()
 }
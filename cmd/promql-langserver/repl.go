@@ -0,0 +1,183 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus-community/promql-langserver/langserver"
+)
+
+// replURI is the document the REPL analyzes the entered queries as
+const replURI = "repl.promql"
+
+// maxReplCompletions is the number of completions listed at once
+const maxReplCompletions = 20
+
+const replHelp = `Enter a query to run it against the configured Prometheus server.
+
+  <query><Tab><Enter>  list the completions at the position of the tab
+  :history             list the previous queries, newest first
+  !<n>                 run the n-th query of the history again
+  :help                show this help
+  :quit                leave the REPL
+`
+
+// runRepl implements the repl subcommand. It runs queries typed on an interactive prompt,
+// e.g. over SSH, where no editor integration is available.
+func runRepl(args []string) int {
+	flags := flag.NewFlagSet("repl", flag.ContinueOnError)
+	configFilePath := flags.String("config-file", "", "Configuration file for the language server")
+	server := flags.String("server", "", "Name of one of the prometheus_servers to run the queries on instead of prometheus_url")
+
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+
+	if flags.NArg() != 0 {
+		fmt.Fprintln(os.Stderr, "usage: promql-langserver repl [--config-file <file>] [--server <name>]")
+		return 1
+	}
+
+	s, err := newQueryServer(*configFilePath, *server)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	defer s.Close()
+
+	s.UseQueryHistory("repl")
+
+	fmt.Println("Type :help for help.")
+
+	repl(s, os.Stdin, os.Stdout)
+
+	return 0
+}
+
+// repl reads queries and commands from in until it is closed or :quit is entered
+func repl(s langserver.HeadlessServer, in io.Reader, out io.Writer) {
+	scanner := bufio.NewScanner(in)
+	// Queries are limited by the maximum document size of the server rather than the default token size
+	scanner.Buffer(nil, 1<<20)
+
+	for {
+		fmt.Fprint(out, "promql> ")
+
+		if !scanner.Scan() {
+			fmt.Fprintln(out)
+			return
+		}
+
+		line := strings.TrimRight(scanner.Text(), " \r\n")
+
+		switch trimmed := strings.TrimSpace(line); {
+		case trimmed == "":
+		case trimmed == ":quit" || trimmed == ":q" || trimmed == ":exit":
+			return
+		case trimmed == ":help":
+			fmt.Fprint(out, replHelp)
+		case trimmed == ":history":
+			for i, query := range s.QueryHistory() {
+				fmt.Fprintf(out, "%4d  %s\n", i+1, query)
+			}
+		case strings.HasPrefix(trimmed, "!"):
+			n, err := strconv.Atoi(trimmed[1:])
+			history := s.QueryHistory()
+
+			if err != nil || n < 1 || n > len(history) {
+				fmt.Fprintf(out, "there is no query %s in the history\n", trimmed[1:])
+				continue
+			}
+
+			fmt.Fprintln(out, history[n-1])
+			runReplQuery(s, out, history[n-1])
+		case strings.Contains(line, "\t"):
+			listReplCompletions(s, out, line)
+		default:
+			runReplQuery(s, out, trimmed)
+		}
+	}
+}
+
+// listReplCompletions prints the completions at the position of the first tab of line
+func listReplCompletions(s langserver.HeadlessServer, out io.Writer, line string) {
+	offset := strings.Index(line, "\t")
+	query := strings.Replace(line, "\t", "", -1)
+
+	if err := s.AddDocument(replURI, "promql", query); err != nil {
+		fmt.Fprintln(out, "error:", err)
+		return
+	}
+	defer s.CloseDocument(replURI) // nolint: errcheck
+
+	completions, err := s.Completion(replURI, offset)
+	if err != nil {
+		fmt.Fprintln(out, "error:", err)
+		return
+	}
+
+	for i, item := range completions.Items {
+		if i == maxReplCompletions {
+			fmt.Fprintf(out, "... %d more\n", len(completions.Items)-maxReplCompletions)
+			break
+		}
+
+		fmt.Fprintln(out, strings.TrimRight(fmt.Sprintf("  %-40s %s", item.Label, item.Detail), " "))
+	}
+
+	fmt.Fprintln(out, query)
+}
+
+// runReplQuery reports the problems the language server finds in a query and runs it if it is valid
+func runReplQuery(s langserver.HeadlessServer, out io.Writer, query string) {
+	report, err := s.AnalyzeDocument(replURI, "promql", query)
+	if err != nil {
+		fmt.Fprintln(out, "error:", err)
+		return
+	}
+
+	s.CloseDocument(replURI) // nolint: errcheck
+
+	invalid := false
+
+	for _, d := range report.Diagnostics {
+		switch d.Severity {
+		case 1: // Error
+			invalid = true
+
+			fmt.Fprintf(out, "%d: error: %s\n", int(d.Range.Start.Character)+1, d.Message)
+		case 2: // Warning
+			fmt.Fprintf(out, "%d: warning: %s\n", int(d.Range.Start.Character)+1, d.Message)
+		}
+	}
+
+	if invalid {
+		return
+	}
+
+	value, err := s.Query(query)
+	if err != nil {
+		fmt.Fprintln(out, "error:", err)
+		return
+	}
+
+	fmt.Fprintln(out, value.String())
+}
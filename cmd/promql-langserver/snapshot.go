@@ -0,0 +1,79 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/prometheus-community/promql-langserver/langserver"
+)
+
+// runSnapshot implements the snapshot subcommand. It evaluates the rules of the given files
+// and records the shapes of their results next to them, for lint --snapshots to compare with.
+func runSnapshot(args []string) int {
+	flags := flag.NewFlagSet("snapshot", flag.ContinueOnError)
+	configFilePath := flags.String("config-file", "", "Configuration file for the language server")
+	server := flags.String("server", "", "Name of one of the prometheus_servers to run the queries on instead of prometheus_url")
+
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: promql-langserver snapshot [--config-file <file>] [--server <name>] <files>...")
+		return 1
+	}
+
+	s, err := newQueryServer(*configFilePath, *server)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	defer s.Close()
+
+	failed := false
+
+	for _, f := range flags.Args() {
+		if err := addFile(s, f); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: error: %s\n", f, err.Error())
+
+			failed = true
+
+			continue
+		}
+
+		snapshot, err := s.RecordSnapshot(f)
+		if err == nil {
+			err = langserver.WriteSnapshot(langserver.SnapshotPath(f), snapshot)
+		}
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: error: %s\n", f, err.Error())
+
+			failed = true
+
+			continue
+		}
+
+		fmt.Printf("%s: recorded %d rules in %s\n", f, len(snapshot.Rules), langserver.SnapshotPath(f))
+	}
+
+	if failed {
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,233 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// finding is a diagnostic reported by the watch subcommand
+type finding struct {
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// webhookPayload is sent to the webhook whenever the findings change
+type webhookPayload struct {
+	Directories []string  `json:"directories"`
+	Files       int       `json:"files"`
+	Errors      int       `json:"errors"`
+	Warnings    int       `json:"warnings"`
+	Findings    []finding `json:"findings"`
+}
+
+// slackPayload is the payload format of Slack incoming webhooks
+type slackPayload struct {
+	Text string `json:"text"`
+}
+
+// runWatch implements the watch subcommand. It periodically validates all rule
+// files in a set of directories and posts the results to a webhook whenever they change.
+func runWatch(args []string) int {
+	flags := flag.NewFlagSet("watch", flag.ContinueOnError)
+	configFilePath := flags.String("config-file", "", "Configuration file for the language server")
+	webhook := flags.String("webhook", "", "URL the validation results are posted to")
+	slack := flags.Bool("slack", false, "Send Slack compatible payloads")
+	interval := flags.Duration("interval", 30*time.Second, "How often the directories are checked for changes")
+
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+
+	if flags.NArg() < 1 || *webhook == "" {
+		fmt.Fprintln(os.Stderr, "usage: promql-langserver watch --webhook <url> [--slack] [--interval 30s] [--config-file <file>] <directories>...")
+		return 1
+	}
+
+	var last []finding
+
+	first := true
+
+	for {
+		findings, files, err := validateDirectories(*configFilePath, flags.Args())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+		} else if first || !reflect.DeepEqual(findings, last) {
+			payload := newWebhookPayload(flags.Args(), files, findings)
+
+			if err := postWebhook(*webhook, payload, *slack); err != nil {
+				fmt.Fprintln(os.Stderr, err.Error())
+			} else {
+				first = false
+				last = findings
+			}
+		}
+
+		time.Sleep(*interval)
+	}
+}
+
+// validateDirectories analyzes all rule files in the given directories and returns the findings
+// together with the number of files found
+func validateDirectories(configFilePath string, dirs []string) ([]finding, int, error) {
+	var files []string
+
+	for _, dir := range dirs {
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if ext := filepath.Ext(path); !info.IsDir() && (ext == ".yml" || ext == ".yaml") {
+				files = append(files, path)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return nil, 0, errors.Wrapf(err, "failed to read directory %q", dir)
+		}
+	}
+
+	s, err := newHeadlessServer(configFilePath)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer s.Close()
+
+	findings := []finding{}
+
+	for _, f := range files {
+		if err := addFile(s, f); err != nil {
+			findings = append(findings, finding{File: f, Severity: "error", Message: err.Error()})
+		}
+	}
+
+	for _, f := range files {
+		report, err := s.GetReport(f)
+		if err != nil {
+			continue
+		}
+
+		for _, d := range report.Diagnostics {
+			severity := "warning"
+			if d.Severity == protocol.SeverityError {
+				severity = "error"
+			} else if d.Severity != protocol.SeverityWarning {
+				continue
+			}
+
+			findings = append(findings, finding{
+				File: f,
+				// protocol positions are zero based
+				Line:     int(d.Range.Start.Line) + 1,
+				Column:   int(d.Range.Start.Character) + 1,
+				Severity: severity,
+				Message:  d.Message,
+			})
+		}
+	}
+
+	sort.Slice(findings, func(i, j int) bool {
+		a, b := findings[i], findings[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+
+		return a.Column < b.Column
+	})
+
+	return findings, len(files), nil
+}
+
+func newWebhookPayload(dirs []string, files int, findings []finding) *webhookPayload {
+	payload := &webhookPayload{
+		Directories: dirs,
+		Files:       files,
+		Findings:    findings,
+	}
+
+	for _, f := range findings {
+		if f.Severity == "error" {
+			payload.Errors++
+		} else {
+			payload.Warnings++
+		}
+	}
+
+	return payload
+}
+
+// slackText summarizes the validation results for humans
+func (p *webhookPayload) slackText() string {
+	var ret strings.Builder
+
+	if len(p.Findings) == 0 {
+		fmt.Fprintf(&ret, "All %d rule files in %s are valid", p.Files, strings.Join(p.Directories, ", "))
+		return ret.String()
+	}
+
+	fmt.Fprintf(&ret, "%d errors and %d warnings in the rule files in %s:\n", p.Errors, p.Warnings, strings.Join(p.Directories, ", "))
+
+	for _, f := range p.Findings {
+		fmt.Fprintf(&ret, "• *%s* `%s:%d:%d`: %s\n", strings.ToUpper(f.Severity), f.File, f.Line, f.Column, f.Message)
+	}
+
+	return ret.String()
+}
+
+func postWebhook(url string, payload *webhookPayload, slack bool) error {
+	var body interface{} = payload
+	if slack {
+		body = &slackPayload{Text: payload.slackText()}
+	}
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.Post(url, "application/json", bytes.NewReader(data)) // nolint: gosec
+	if err != nil {
+		return errors.Wrap(err, "failed to post to webhook")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return errors.Errorf("webhook returned status %s", resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,358 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/prometheus-community/promql-langserver/langserver/cache"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/jsonrpc2"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// alertRoutingPreviewParams are the parameters of the promql.previewAlertRouting command
+type alertRoutingPreviewParams struct {
+	TextDocument protocol.TextDocumentIdentifier `json:"textDocument"`
+	// Position is any position inside the alerting rule
+	Position protocol.Position `json:"position"`
+	// Labels are added to the labels of the rule, e.g. to simulate labels of the alerting series
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// alertRoutingPreview is the result of the promql.previewAlertRouting command
+type alertRoutingPreview struct {
+	Alert  string            `json:"alert"`
+	Labels map[string]string `json:"labels"`
+	// Configs contains the routing result for every open Alertmanager configuration
+	Configs []alertRoutingResult `json:"configs"`
+}
+
+// alertRoutingResult describes how one Alertmanager configuration routes an alert
+type alertRoutingResult struct {
+	URI       string          `json:"uri"`
+	Receivers []alertReceiver `json:"receivers"`
+	// UnknownLabels are labels that matter for routing but whose values
+	// are not known before the alert fires
+	UnknownLabels []string `json:"unknownLabels,omitempty"`
+}
+
+// alertReceiver is a receiver an alert is routed to
+type alertReceiver struct {
+	Name string `json:"name"`
+	// Integrations is the number of notification integrations the receiver has, or -1 if it is not defined
+	Integrations int `json:"integrations"`
+	// Route is the location of the route that selected the receiver
+	Route protocol.Location `json:"route"`
+}
+
+// alertmanagerConfigs returns all Alertmanager configurations defined in open documents
+func (s *server) alertmanagerConfigs() map[*cache.DocumentHandle][]*cache.AlertmanagerConfig {
+	ret := make(map[*cache.DocumentHandle][]*cache.AlertmanagerConfig)
+
+	for _, doc := range s.cache.GetDocuments() {
+		configs, err := doc.GetAlertmanagerConfigs()
+		if err != nil || len(configs) == 0 {
+			continue
+		}
+
+		ret[doc] = configs
+	}
+
+	return ret
+}
+
+// hasAlertmanagerConfig checks whether a cached document contains an Alertmanager configuration
+func (s *server) hasAlertmanagerConfig(uri protocol.DocumentURI) bool {
+	doc, err := s.cache.GetDocument(uri)
+	if err != nil {
+		return false
+	}
+
+	configs, err := doc.GetAlertmanagerConfigs()
+
+	return err == nil && len(configs) > 0
+}
+
+// diagnoseRoutedRules analyzes the open rule files again after an Alertmanager configuration has been
+// changed or closed, since their alert routing diagnostics depend on it
+func (s *server) diagnoseRoutedRules(changed protocol.DocumentURI) {
+	for _, uri := range s.openDocuments() {
+		if uri == changed || s.hasAlertmanagerConfig(uri) {
+			continue
+		}
+
+		doc, err := s.cache.GetDocument(uri)
+		if err != nil {
+			continue
+		}
+
+		if groups, err := doc.GetRuleGroups(); err == nil && len(groups) > 0 {
+			go s.diagnostics(uri)
+		}
+	}
+}
+
+// previewAlertRouting computes the receivers an alert would be sent to by the open Alertmanager configurations
+func (s *server) previewAlertRouting(params *alertRoutingPreviewParams) (*alertRoutingPreview, error) {
+	doc, err := s.cache.GetDocument(params.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+
+	rule, err := findRule(doc, params.Position)
+	if err != nil {
+		return nil, err
+	}
+
+	if rule == nil || rule.Alert == "" {
+		return nil, jsonrpc2.NewErrorf(jsonrpc2.CodeInvalidParams, "there is no alerting rule at this position")
+	}
+
+	labels, known := alertLabels(rule)
+
+	for name, value := range params.Labels {
+		labels[name] = value
+		known[name] = true
+	}
+
+	preview := &alertRoutingPreview{
+		Alert:   rule.Alert,
+		Labels:  labels,
+		Configs: []alertRoutingResult{},
+	}
+
+	for amDoc, configs := range s.alertmanagerConfigs() {
+		for _, config := range configs {
+			result, err := routeAlert(amDoc, config, labels, known)
+			if err != nil {
+				return nil, err
+			}
+
+			preview.Configs = append(preview.Configs, *result)
+		}
+	}
+
+	return preview, nil
+}
+
+// alertRoutingDiagnostics warns about alerting rules that are routed only to receivers that are missing
+// or have no notification integrations by an open Alertmanager configuration
+func (s *server) alertRoutingDiagnostics(doc *cache.DocumentHandle) []protocol.Diagnostic {
+	groups, err := doc.GetRuleGroups()
+	if err != nil || len(groups) == 0 {
+		return nil
+	}
+
+	amConfigs := s.alertmanagerConfigs()
+	if len(amConfigs) == 0 {
+		return nil
+	}
+
+	var ret []protocol.Diagnostic
+
+	for _, group := range groups {
+		for _, rule := range group.Rules {
+			if rule.Alert == "" {
+				continue
+			}
+
+			labels, known := alertLabels(rule)
+
+			for amDoc, configs := range amConfigs {
+				for _, config := range configs {
+					result, err := routeAlert(amDoc, config, labels, known)
+					if err != nil || len(result.UnknownLabels) > 0 || deliversAlert(result.Receivers) {
+						continue
+					}
+
+					rng, err := tokenRange(doc, rule.NamePos, rule.NameEnd)
+					if err != nil {
+						continue
+					}
+
+					names := make([]string, 0, len(result.Receivers))
+					for _, r := range result.Receivers {
+						names = append(names, fmt.Sprintf("%q", r.Name))
+					}
+
+					ret = append(ret, protocol.Diagnostic{
+						Range:    rng,
+						Severity: 2, // Warning
+						Code:     codeUnroutedAlert,
+						Source:   "promql-lsp",
+						Message: fmt.Sprintf("this alert matches no receiver in %s: it is routed to %s, which has no notification configuration",
+							amDoc.GetURI(), strings.Join(names, ", ")),
+					})
+				}
+			}
+		}
+	}
+
+	return ret
+}
+
+// deliversAlert returns whether at least one of the receivers sends out notifications
+func deliversAlert(receivers []alertReceiver) bool {
+	for _, r := range receivers {
+		if r.Integrations > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// alertLabels returns the labels an alert generated by a rule is known to have, and which
+// of them are known. Labels with templated values are only known when the alert fires.
+func alertLabels(rule *cache.Rule) (map[string]string, map[string]bool) {
+	labels := map[string]string{"alertname": rule.Alert}
+	known := map[string]bool{"alertname": true}
+
+	for name, value := range rule.Labels {
+		labels[name] = value
+		known[name] = !strings.Contains(value, "{{")
+	}
+
+	return labels, known
+}
+
+// routeAlert follows the routing tree of an Alertmanager configuration the same way
+// Alertmanager does and returns the receivers an alert with the given labels ends up at.
+// Labels not in known are treated as absent, but are reported in the result if they influence the routing.
+func routeAlert(doc *cache.DocumentHandle, config *cache.AlertmanagerConfig, labels map[string]string, known map[string]bool) (*alertRoutingResult, error) {
+	unknown := make(map[string]bool)
+
+	var match func(route *cache.AlertmanagerRoute) []*cache.AlertmanagerRoute
+
+	match = func(route *cache.AlertmanagerRoute) []*cache.AlertmanagerRoute {
+		if !routeMatches(route, labels, known, unknown) {
+			return nil
+		}
+
+		var all []*cache.AlertmanagerRoute
+
+		for _, child := range route.Routes {
+			matches := match(child)
+
+			all = append(all, matches...)
+
+			if matches != nil && !child.Continue {
+				break
+			}
+		}
+
+		// If no child route matches, the current route is used
+		if len(all) == 0 {
+			all = append(all, route)
+		}
+
+		return all
+	}
+
+	result := &alertRoutingResult{
+		URI:       doc.GetURI(),
+		Receivers: []alertReceiver{},
+	}
+
+	if config.Route == nil {
+		return result, nil
+	}
+
+	// The root route matches all alerts, even if it has matchers
+	root := *config.Route
+	root.Match, root.MatchRE = nil, nil
+
+	for _, route := range match(&root) {
+		rng, err := tokenRange(doc, route.Pos, route.End)
+		if err != nil {
+			return nil, err
+		}
+
+		integrations, ok := config.Receivers[route.Receiver]
+		if !ok {
+			integrations = -1
+		}
+
+		result.Receivers = append(result.Receivers, alertReceiver{
+			Name:         route.Receiver,
+			Integrations: integrations,
+			Route:        protocol.Location{URI: doc.GetURI(), Range: rng},
+		})
+	}
+
+	for name := range unknown {
+		result.UnknownLabels = append(result.UnknownLabels, name)
+	}
+
+	sort.Strings(result.UnknownLabels)
+
+	return result, nil
+}
+
+// routeMatches evaluates the matchers of a route. Labels that are looked at, but
+// not known are added to unknown, unless a known label already decides that
+// the route doesn't match.
+func routeMatches(route *cache.AlertmanagerRoute, labels map[string]string, known map[string]bool, unknown map[string]bool) bool {
+	matches := true
+
+	var looked []string
+
+	for name, value := range route.Match {
+		looked = append(looked, name)
+
+		if labels[name] != value {
+			matches = false
+
+			if known[name] {
+				return false
+			}
+		}
+	}
+
+	for name, value := range route.MatchRE {
+		looked = append(looked, name)
+
+		re, err := regexp.Compile("^(?:" + value + ")$")
+		if err != nil || !re.MatchString(labels[name]) {
+			matches = false
+
+			if known[name] {
+				return false
+			}
+		}
+	}
+
+	for _, m := range route.Matchers {
+		looked = append(looked, m.Name)
+
+		if !m.Matches(labels[m.Name]) {
+			matches = false
+
+			if known[m.Name] {
+				return false
+			}
+		}
+	}
+
+	for _, name := range looked {
+		if !known[name] {
+			unknown[name] = true
+		}
+	}
+
+	return matches
+}
@@ -0,0 +1,127 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// TestAlertRouting checks that alerts are routed like Alertmanager does and that
+// alerts which end up at receivers without integrations are reported
+func TestAlertRouting(*testing.T) {
+	h, err := NewHeadlessServer(context.Background(), &Config{}, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	const alertmanager = `route:
+  receiver: default
+  routes:
+  - match:
+      severity: page
+    receiver: pager
+  - match_re:
+      team: infra|db
+    receiver: blackhole
+  - matchers:
+    - team="web"
+    - severity!~"page|critical"
+    receiver: blackhole
+receivers:
+- name: default
+  email_configs:
+  - to: team@example.com
+- name: pager
+  pagerduty_configs:
+  - service_key: secret
+- name: blackhole
+`
+
+	const rules = `groups:
+- name: example
+  rules:
+  - alert: Paging
+    expr: up == 0
+    labels:
+      severity: page
+  - alert: Dropped
+    expr: up == 0
+    labels:
+      severity: ticket
+      team: infra
+  - alert: Unknown
+    expr: up{team="db"} == 0
+    labels:
+      severity: ticket
+      team: '{{ $labels.team }}'
+  - alert: Matched
+    expr: up == 0
+    labels:
+      severity: ticket
+      team: web
+  - alert: NotMatched
+    expr: up == 0
+    labels:
+      severity: ticket
+      team: api
+`
+
+	if err := h.AddDocument("alertmanager.yml", "yaml", alertmanager); err != nil {
+		panic(err)
+	}
+
+	report, err := h.AnalyzeDocument("rules.yml", "yaml", rules)
+	if err != nil {
+		panic(err)
+	}
+
+	var dropped []float64
+
+	for _, d := range report.Diagnostics {
+		if strings.Contains(d.Message, "matches no receiver") {
+			dropped = append(dropped, d.Range.Start.Line)
+		}
+	}
+
+	if fmt.Sprint(dropped) != "[7 17]" {
+		panic(fmt.Sprintf("expected the Dropped and Matched alerts to be unrouted, got %v: %v", dropped, report.Diagnostics))
+	}
+
+	preview, err := h.server.previewAlertRouting(&alertRoutingPreviewParams{
+		TextDocument: protocol.TextDocumentIdentifier{URI: "rules.yml"},
+		Position:     protocol.Position{Line: 3, Character: 10},
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	if len(preview.Configs) != 1 || len(preview.Configs[0].Receivers) != 1 || preview.Configs[0].Receivers[0].Name != "pager" {
+		panic(fmt.Sprintf("expected the Paging alert to be routed to pager, got %+v", preview.Configs))
+	}
+
+	invalid, err := h.AnalyzeDocument("invalid.yml", "yaml", "route:\n  receiver: default\n  routes:\n  - matchers: ['team web']\n")
+	if err != nil {
+		panic(err)
+	}
+
+	if len(invalid.Diagnostics) != 1 || !strings.Contains(invalid.Diagnostics[0].Message, `invalid matcher "team web"`) {
+		panic(fmt.Sprintf("expected the invalid matcher to be reported, got %v", invalid.Diagnostics))
+	}
+}
@@ -0,0 +1,242 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus-community/promql-langserver/langserver/cache"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/jsonrpc2"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/template"
+	"gopkg.in/yaml.v3"
+)
+
+// alertTemplatePreviewParams are the parameters of the promql.previewAlertTemplates command
+type alertTemplatePreviewParams struct {
+	TextDocument protocol.TextDocumentIdentifier `json:"textDocument"`
+	// Position is any position inside the alerting rule
+	Position protocol.Position `json:"position"`
+	// Labels and Value are the sample data the templates are expanded with.
+	// If neither is given, the alert expression is evaluated on the connected Prometheus server.
+	Labels map[string]string `json:"labels,omitempty"`
+	Value  *float64          `json:"value,omitempty"`
+}
+
+// alertTemplatePreview is the result of the promql.previewAlertTemplates command
+type alertTemplatePreview struct {
+	Alert string `json:"alert"`
+	// Source describes where the sample data comes from, either "live", "user" or "none"
+	Source string            `json:"source"`
+	Labels map[string]string `json:"labels"`
+	Value  float64           `json:"value"`
+	// ExpandedLabels and Annotations contain the expanded templates of the rule
+	ExpandedLabels map[string]string `json:"expandedLabels"`
+	Annotations    map[string]string `json:"annotations"`
+}
+
+// previewAlertTemplates renders the labels and annotations of an alerting rule the way
+// Prometheus does when the alert fires
+func (s *server) previewAlertTemplates(ctx context.Context, params *alertTemplatePreviewParams) (*alertTemplatePreview, error) {
+	doc, err := s.cache.GetDocument(params.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+
+	rule, err := findRule(doc, params.Position)
+	if err != nil {
+		return nil, err
+	}
+
+	if rule == nil || rule.Alert == "" {
+		return nil, jsonrpc2.NewErrorf(jsonrpc2.CodeInvalidParams, "there is no alerting rule at this position")
+	}
+
+	ts := s.evaluationTimeOrNow(rule.Query)
+
+	preview := &alertTemplatePreview{
+		Alert:          rule.Alert,
+		Source:         "none",
+		Labels:         map[string]string{},
+		ExpandedLabels: map[string]string{},
+		Annotations:    map[string]string{},
+	}
+
+	switch {
+	case params.Labels != nil || params.Value != nil:
+		preview.Source = "user"
+
+		for k, v := range params.Labels {
+			preview.Labels[k] = v
+		}
+
+		if params.Value != nil {
+			preview.Value = *params.Value
+		}
+	case rule.Query != nil && s.getQueryAPIFor(params.TextDocument.URI) != nil:
+		vector, err := s.templateQueryFunc(params.TextDocument.URI)(ctx, rule.Query.Content, ts)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to evaluate alert expression")
+		}
+
+		if len(vector) > 0 {
+			preview.Source = "live"
+
+			for _, l := range vector[0].Metric {
+				if l.Name != labels.MetricName {
+					preview.Labels[l.Name] = l.Value
+				}
+			}
+
+			preview.Value = vector[0].V
+		}
+	}
+
+	expand := s.alertTemplateExpander(ctx, params.TextDocument.URI, rule.Alert, preview.Labels, preview.Value, ts)
+
+	for _, field := range []struct {
+		key    string
+		result map[string]string
+	}{
+		{"labels", preview.ExpandedLabels},
+		{"annotations", preview.Annotations},
+	} {
+		mapping := cache.MappingValue(rule.Node, field.key)
+		if mapping == nil || mapping.Kind != yaml.MappingNode {
+			continue
+		}
+
+		for i := 0; i+1 < len(mapping.Content); i += 2 {
+			field.result[mapping.Content[i].Value] = expand(mapping.Content[i+1].Value)
+		}
+	}
+
+	return preview, nil
+}
+
+// alertTemplateExpander returns a function that expands templates of a document
+// with the same data and functions as the Prometheus rule manager
+func (s *server) alertTemplateExpander(ctx context.Context, uri protocol.DocumentURI, alert string, l map[string]string, value float64, ts time.Time) func(string) string {
+	data := template.AlertTemplateData(l, nil, value)
+
+	// Prometheus injects these variables for convenience
+	defs := []string{
+		"{{$labels := .Labels}}",
+		"{{$externalLabels := .ExternalLabels}}",
+		"{{$value := .Value}}",
+	}
+
+	externalURL, err := url.Parse(s.getPrometheusURLFor(uri))
+	if err != nil {
+		externalURL = nil
+	}
+
+	return func(text string) string {
+		expander := template.NewTemplateExpander(
+			ctx,
+			strings.Join(append(defs, text), ""),
+			"__alert_"+alert,
+			data,
+			model.Time(timestamp.FromTime(ts)),
+			s.templateQueryFunc(uri),
+			externalURL,
+		)
+
+		result, err := expander.Expand()
+		if err != nil {
+			return fmt.Sprintf("<error expanding template: %s>", err)
+		}
+
+		return result
+	}
+}
+
+// templateQueryFunc returns the function evaluating queries issued by the templates of a document
+// on the Prometheus server the document is mapped to
+func (s *server) templateQueryFunc(uri protocol.DocumentURI) template.QueryFunc {
+	return func(ctx context.Context, query string, ts time.Time) (promql.Vector, error) {
+		return templateQuery(ctx, s.getQueryAPIFor(uri), query, ts)
+	}
+}
+
+// templateQuery evaluates a query issued by a template and converts the result to the format of the rule manager
+func templateQuery(ctx context.Context, api v1.API, query string, ts time.Time) (promql.Vector, error) {
+	if api == nil {
+		return nil, errors.New("no Prometheus server configured")
+	}
+
+	value, _, err := api.Query(ctx, query, ts)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret promql.Vector
+
+	switch v := value.(type) {
+	case model.Vector:
+		for _, sample := range v {
+			l := make(labels.Labels, 0, len(sample.Metric))
+
+			for name, value := range sample.Metric {
+				l = append(l, labels.Label{Name: string(name), Value: string(value)})
+			}
+
+			ret = append(ret, promql.Sample{
+				Metric: labels.New(l...),
+				Point:  promql.Point{T: int64(sample.Timestamp), V: float64(sample.Value)},
+			})
+		}
+	case *model.Scalar:
+		ret = append(ret, promql.Sample{
+			Point: promql.Point{T: int64(v.Timestamp), V: float64(v.Value)},
+		})
+	default:
+		return nil, errors.Errorf("query %q returned %s, expected an instant vector or scalar", query, value.Type())
+	}
+
+	return ret, nil
+}
+
+// findRule returns the rule at a position, or nil if there is none
+func findRule(doc *cache.DocumentHandle, position protocol.Position) (*cache.Rule, error) {
+	pos, err := doc.ProtocolPositionToTokenPos(position)
+	if err != nil {
+		return nil, err
+	}
+
+	groups, err := doc.GetRuleGroups()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, group := range groups {
+		for _, rule := range group.Rules {
+			if rule.Pos <= pos && pos <= rule.End {
+				return rule, nil
+			}
+		}
+	}
+
+	return nil, nil
+}
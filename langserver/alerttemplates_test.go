@@ -0,0 +1,94 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// TestPreviewAlertTemplates checks that templates are expanded with the sample data of the user, or else with
+// the result of the alert expression on the Prometheus server the rule file is mapped to
+func TestPreviewAlertTemplates(*testing.T) {
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if !strings.HasSuffix(r.URL.Path, "/api/v1/query") {
+			fmt.Fprint(w, `{"status":"success","data":{}}`)
+			return
+		}
+
+		instance, value := "prod", "1"
+		if strings.HasPrefix(r.URL.Path, "/staging/") {
+			instance, value = "staging", "2"
+		}
+
+		fmt.Fprintf(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{"instance":%q},"value":[0,%q]}]}}`,
+			instance, value)
+	}))
+	defer prom.Close()
+
+	h, err := NewHeadlessServer(context.Background(), &Config{
+		PrometheusURL:     prom.URL,
+		PrometheusServers: map[string]string{"staging": prom.URL + "/staging"},
+		PrometheusMapping: []PrometheusMapping{{Path: "/rules/staging", Server: "staging"}},
+	}, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	const uri = "file:///rules/staging/alerts.yml"
+
+	if err := h.AddDocument(uri, "yaml", `groups:
+- name: example
+  rules:
+  - alert: HighLoad
+    expr: load > 0.1
+    annotations:
+      summary: "{{ $labels.instance }} at {{ $value | humanizePercentage }}"
+`); err != nil {
+		panic(err)
+	}
+
+	preview := func(arguments map[string]interface{}) *alertTemplatePreview {
+		arguments["textDocument"] = map[string]interface{}{"uri": uri}
+		arguments["position"] = map[string]interface{}{"line": 3, "character": 4}
+
+		result, err := h.server.ExecuteCommand(context.Background(), &protocol.ExecuteCommandParams{
+			Command:   commandPreviewAlertTemplates,
+			Arguments: []interface{}{arguments},
+		})
+		if err != nil {
+			panic(err)
+		}
+
+		return result.(*alertTemplatePreview)
+	}
+
+	if p := preview(map[string]interface{}{"labels": map[string]string{"instance": "db-1"}, "value": 0.25}); p.Source != "user" ||
+		p.Annotations["summary"] != "db-1 at 25%" {
+		panic(fmt.Sprintf("expected the templates to be expanded with the given sample, got %+v", p))
+	}
+
+	if p := preview(map[string]interface{}{}); p.Source != "live" || p.Annotations["summary"] != "staging at 200%" {
+		panic(fmt.Sprintf("expected the alert expression to be evaluated on the mapped server, got %+v", p))
+	}
+}
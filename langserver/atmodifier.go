@@ -0,0 +1,217 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"fmt"
+	"go/token"
+	"math"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/prometheus-community/promql-langserver/langserver/cache"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+	"github.com/prometheus/common/model"
+)
+
+// atPreprocessorRE matches the text in front of the cursor when it is placed behind an @
+var atPreprocessorRE = regexp.MustCompile(`@\s*([a-z]*)$`) // nolint: gochecknoglobals
+
+// atPreprocessors documents the functions that can be used as argument of the @ modifier
+var atPreprocessors = []struct{ name, doc string }{ // nolint: gochecknoglobals
+	{"start()", "The start of the range query, or the evaluation time of an instant query"},
+	{"end()", "The end of the range query, or the evaluation time of an instant query"},
+}
+
+// atTimestamp converts the timestamp of an @ modifier to a time.Time
+func atTimestamp(modifier cache.AtModifier) time.Time {
+	sec, frac := math.Modf(modifier.Timestamp)
+	return time.Unix(int64(sec), int64(frac*float64(time.Second))).UTC()
+}
+
+// queryAt returns the query containing a position, together with the offset of the position inside of it
+func queryAt(doc *cache.DocumentHandle, position protocol.Position) (*cache.CompiledQuery, int) {
+	pos, err := doc.ProtocolPositionToTokenPos(position)
+	if err != nil {
+		return nil, 0
+	}
+
+	queries, err := doc.GetQueries()
+	if err != nil {
+		return nil, 0
+	}
+
+	for _, q := range queries {
+		if q.Pos <= pos && int(pos-q.Pos) <= len(q.Content) {
+			return q, int(pos - q.Pos)
+		}
+	}
+
+	return nil, 0
+}
+
+// atModifierDiagnostics warns about @ modifiers that pin queries to timestamps for which
+// the Prometheus server has no data anymore
+func (s *server) atModifierDiagnostics(doc *cache.DocumentHandle) []protocol.Diagnostic {
+	retention := s.retention()
+	if retention == 0 {
+		return nil
+	}
+
+	queries, err := doc.GetQueries()
+	if err != nil {
+		return nil
+	}
+
+	var ret []protocol.Diagnostic
+
+	for _, q := range queries {
+		for _, modifier := range q.AtModifiers {
+			if modifier.Preprocessor != "" {
+				continue
+			}
+
+			t := atTimestamp(modifier)
+			oldest := s.evaluationTimeOrNow(q).Add(-retention)
+
+			if !t.Before(oldest) {
+				continue
+			}
+
+			rng, err := tokenRange(doc, q.Pos+token.Pos(modifier.PosRange.Start), q.Pos+token.Pos(modifier.PosRange.End))
+			if err != nil {
+				continue
+			}
+
+			ret = append(ret, protocol.Diagnostic{
+				Range:    rng,
+				Severity: 2, // Warning
+				Code:     codeAtModifierRetention,
+				Source:   "promql-lsp",
+				Message: fmt.Sprintf("@ pins the query to %s, which is older than the retention of %s; the selected series have no data",
+					t.Format(time.RFC3339), model.Duration(retention)),
+			})
+		}
+	}
+
+	return ret
+}
+
+// atModifierHover shows the absolute time an @ modifier pins a query to
+func (s *server) atModifierHover(doc *cache.DocumentHandle, position protocol.Position) *protocol.Hover {
+	q, offset := queryAt(doc, position)
+	if q == nil {
+		return nil
+	}
+
+	for _, modifier := range q.AtModifiers {
+		if offset < int(modifier.PosRange.Start) || offset > int(modifier.PosRange.End) {
+			continue
+		}
+
+		var markdown string
+
+		if modifier.Preprocessor != "" {
+			for _, p := range atPreprocessors {
+				if p.name == modifier.Preprocessor+"()" {
+					markdown = fmt.Sprintf("`@ %s`\n\n%s", p.name, p.doc)
+				}
+			}
+		} else {
+			t := atTimestamp(modifier)
+			markdown = fmt.Sprintf("`@ %s` = %s", strconv.FormatFloat(modifier.Timestamp, 'f', -1, 64), t.Format(time.RFC3339Nano))
+
+			if evalTime := s.evaluationTimeOrNow(q); t.Before(evalTime) {
+				markdown += fmt.Sprintf("\n\n%s before the evaluation time", humanizeAge(evalTime.Sub(t)))
+			}
+		}
+
+		rng, err := tokenRange(doc, q.Pos+token.Pos(modifier.PosRange.Start), q.Pos+token.Pos(modifier.PosRange.End))
+		if err != nil {
+			return nil
+		}
+
+		return &protocol.Hover{
+			Contents: protocol.MarkupContent{
+				Kind:  "markdown",
+				Value: markdown,
+			},
+			Range: rng,
+		}
+	}
+
+	return nil
+}
+
+// humanizeAge formats a (possibly long) time span with a precision suitable for hover texts
+func humanizeAge(d time.Duration) string {
+	switch {
+	case d >= 24*time.Hour:
+		return fmt.Sprintf("%.1f days", d.Hours()/24)
+	case d >= time.Hour:
+		return fmt.Sprintf("%.1f hours", d.Hours())
+	default:
+		return d.Truncate(time.Second).String()
+	}
+}
+
+// atModifierCompletion completes the argument of an @ modifier
+func (s *server) atModifierCompletion(doc *cache.DocumentHandle, position protocol.Position) []protocol.CompletionItem {
+	q, offset := queryAt(doc, position)
+	if q == nil {
+		return nil
+	}
+
+	match := atPreprocessorRE.FindStringSubmatch(q.Content[:offset])
+	if match == nil {
+		return nil
+	}
+
+	// An @ in a label matcher or comment, or one that can't be an @ modifier, is left to the other completions
+	if at := offset - len(match[0]); !cache.IsCode(q.Content, at) || !cache.AtModifierAllowed(q.Content, at) {
+		return nil
+	}
+
+	pos := q.Pos + token.Pos(offset)
+
+	rng, err := tokenRange(doc, pos-token.Pos(len(match[1])), pos)
+	if err != nil {
+		return nil
+	}
+
+	var ret []protocol.CompletionItem
+
+	for _, p := range atPreprocessors {
+		ret = append(ret, protocol.CompletionItem{
+			Label:         p.name,
+			Kind:          protocol.FunctionCompletion,
+			Documentation: p.doc,
+			TextEdit:      &protocol.TextEdit{Range: rng, NewText: p.name},
+		})
+	}
+
+	if match[1] == "" {
+		timestamp := strconv.FormatInt(s.evaluationTimeOrNow(q).Unix(), 10)
+
+		ret = append(ret, protocol.CompletionItem{
+			Label:    timestamp,
+			Kind:     protocol.ValueCompletion,
+			Detail:   "the current evaluation time",
+			TextEdit: &protocol.TextEdit{Range: rng, NewText: timestamp},
+		})
+	}
+
+	return ret
+}
@@ -0,0 +1,90 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// TestAtModifierCompletion checks that the arguments of @ modifiers are only completed where a modifier is allowed
+func TestAtModifierCompletion(*testing.T) {
+	h, err := NewHeadlessServer(context.Background(), &Config{}, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	for i, c := range []struct {
+		query    string
+		expected bool
+	}{
+		{`foo @ `, true},
+		{`rate(foo[5m] @ st`, true},
+		{`foo{email=~".*@`, false},
+		{`sum(foo) @ `, false},
+		{"foo # deployed at @ ", false},
+	} {
+		query, expected := c.query, c.expected
+		uri := fmt.Sprintf("query%d.promql", i)
+
+		if err := h.AddDocument(uri, "promql", query); err != nil {
+			panic(err)
+		}
+
+		completions, err := h.Completion(uri, len(query))
+		if err != nil {
+			panic(err)
+		}
+
+		found := false
+
+		if completions != nil {
+			for _, item := range completions.Items {
+				found = found || item.Label == "start()"
+			}
+		}
+
+		if found != expected {
+			panic(fmt.Sprintf("expected start() to be completed in %q: %v, got %v", query, expected, completions))
+		}
+	}
+}
+
+// TestAtModifierHover checks that hovering @ modifiers shows the time or the preprocessor they pin the query to
+func TestAtModifierHover(*testing.T) {
+	h, err := NewHeadlessServer(context.Background(), &Config{}, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	const query = `rate(foo[5m] @ 1609746000) / rate(foo[5m] @ end())`
+
+	if err := h.AddDocument("query.promql", "promql", query); err != nil {
+		panic(err)
+	}
+
+	for offset, expected := range map[int]string{
+		strings.Index(query, "@ 1609746000") + 3: "2021-01-04T07:40:00Z",
+		strings.Index(query, "@ end()") + 3:      "The end of the range query",
+	} {
+		hover, err := h.Hover("query.promql", offset)
+		if err != nil || hover == nil || !strings.Contains(hover.Contents.Value, expected) {
+			panic(fmt.Sprintf("expected the hover at %d to contain %q, got %v, %v", offset, expected, hover, err))
+		}
+	}
+}
@@ -0,0 +1,42 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"net/http"
+
+	"github.com/pkg/errors"
+
+	"github.com/prometheus/client_golang/api"
+	config_util "github.com/prometheus/common/config"
+)
+
+// roundTripper returns the transport of the requests to a Prometheus server. It adds the credentials
+// and client certificates of the http_config option, bearer token and password files are read for every request,
+// so that rotated credentials are picked up. The transport option of the server replaces the network settings.
+func (c *Config) roundTripper(url string) (http.RoundTripper, error) {
+	if transport := c.transportFor(url); transport != nil {
+		rt, err := transport.roundTripper(c.HTTPConfig)
+
+		return rt, errors.Wrapf(err, "invalid transport of %s", url)
+	}
+
+	if c.HTTPConfig == nil {
+		return api.DefaultRoundTripper, nil
+	}
+
+	rt, err := config_util.NewRoundTripperFromConfig(*c.HTTPConfig, "promql-langserver", false)
+
+	return rt, errors.Wrap(err, "invalid http_config")
+}
@@ -0,0 +1,142 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestHTTPConfig checks that the credentials of http_config are sent to the Prometheus server
+func TestHTTPConfig(*testing.T) {
+	dir, err := ioutil.TempDir("", "promql-langserver")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tokenFile := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("secret\n"), 0600); err != nil {
+		panic(err)
+	}
+
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if r.URL.Path == "/api/v1/label/job/values" {
+			fmt.Fprint(w, `{"status":"success","data":["node"]}`)
+			return
+		}
+
+		fmt.Fprint(w, `{"status":"success","data":{}}`)
+	}))
+	defer prom.Close()
+
+	config, err := ParseConfig([]byte(fmt.Sprintf("prometheus_url: %s\nhttp_config:\n  bearer_token_file: %s\n", prom.URL, tokenFile)))
+	if err != nil {
+		panic(err)
+	}
+
+	h, err := NewHeadlessServer(context.Background(), config, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	if values := h.server.labelValues(context.Background(), "", nil, "", "job"); fmt.Sprint(values) != "[node]" {
+		panic(fmt.Sprintf("expected the label values of the authenticated server, got %v", values))
+	}
+
+	if _, err := ParseConfig([]byte("http_config:\n  bearer_token: a\n  basic_auth:\n    username: b\n")); err == nil {
+		panic("expected conflicting credentials to be rejected")
+	}
+}
+
+// TestTransport checks that the transport option applies to the selected servers and keeps the credentials of http_config
+func TestTransport(*testing.T) {
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if r.URL.Path == "/api/v1/label/job/values" {
+			fmt.Fprint(w, `{"status":"success","data":["node"]}`)
+			return
+		}
+
+		fmt.Fprint(w, `{"status":"success","data":{}}`)
+	}))
+	defer prom.Close()
+
+	config, err := ParseConfig([]byte(fmt.Sprintf(`prometheus_url: %s
+prometheus_servers:
+  thanos: https://thanos.example.com
+http_config:
+  bearer_token: secret
+transport:
+  %s:
+    disable_proxy: true
+    dial_timeout: 2s
+  thanos:
+    idle_conn_timeout: 1m
+`, prom.URL, prom.URL)))
+	if err != nil {
+		panic(err)
+	}
+
+	if direct := config.transportFor(prom.URL); direct == nil || !direct.DisableProxy {
+		panic(fmt.Sprintf("expected the transport of the prometheus_url, got %+v", direct))
+	}
+
+	if thanos := config.transportFor("https://thanos.example.com"); thanos == nil || thanos.IdleConnTimeout != "1m" {
+		panic(fmt.Sprintf("expected the transport to be selected by server name, got %+v", thanos))
+	}
+
+	h, err := NewHeadlessServer(context.Background(), config, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	if values := h.server.labelValues(context.Background(), "", nil, "", "job"); fmt.Sprint(values) != "[node]" {
+		panic(fmt.Sprintf("expected the credentials to be sent with the transport option, got %v", values))
+	}
+
+	rt, err := (&TransportConfig{DisableProxy: true, DialTimeout: "2s"}).roundTripper(nil)
+	if err != nil {
+		panic(err)
+	}
+
+	if transport := rt.(*http.Transport); transport.Proxy != nil {
+		panic("expected the proxy to be disabled")
+	}
+
+	if _, err := (&TransportConfig{KeepAlive: "often"}).roundTripper(nil); err == nil {
+		panic("expected an invalid duration to be rejected")
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+// TestBackendErrors checks that problems with the Prometheus server are classified and throttled
+func TestBackendErrors(*testing.T) {
+	for code, expected := range map[int]string{
+		http.StatusUnauthorized:    backendAuth,
+		http.StatusTooManyRequests: backendRateLimited,
+		http.StatusBadGateway:      backendUnreachable,
+	} {
+		prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(code)
+		}))
+
+		client, err := api.NewClient(api.Config{Address: prom.URL})
+		if err != nil {
+			panic(err)
+		}
+
+		_, _, err = v1.NewAPI(client).LabelNames(context.Background())
+
+		prom.Close()
+
+		if kind := classifyBackendError(err); kind != expected {
+			panic(fmt.Sprintf("Expected %s for status %d, got %s: %v", expected, code, kind, err))
+		}
+	}
+
+	client, err := api.NewClient(api.Config{Address: "http://127.0.0.1:1"})
+	if err != nil {
+		panic(err)
+	}
+
+	_, _, err = v1.NewAPI(client).LabelNames(context.Background())
+	if kind := classifyBackendError(err); kind != backendUnreachable {
+		panic(fmt.Sprintf("Expected %s for a closed port, got %s: %v", backendUnreachable, kind, err))
+	}
+
+	var log bytes.Buffer
+
+	s := &server{client: &headlessClient{log: &log}, lifetime: context.Background()}
+
+	s.reportBackendError(err)
+	s.reportBackendError(err)
+
+	if n := strings.Count(log.String(), "unreachable"); n != 1 {
+		panic(fmt.Sprintf("Expected a single notification, got %d: %s", n, log.String()))
+	}
+}
@@ -0,0 +1,244 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"fmt"
+	"go/token"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"gopkg.in/yaml.v3"
+
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// alertmanagerMatcherRE matches an entry of the matchers list of a route, e.g. `severity=~"page|critical"`
+var alertmanagerMatcherRE = regexp.MustCompile(`^\s*([a-zA-Z_][a-zA-Z0-9_]*)\s*(=~|!~|!=|=)\s*(.*?)\s*$`) // nolint: gochecknoglobals
+
+// alertmanagerMatchTypes maps the operators of matchers to their match types
+var alertmanagerMatchTypes = map[string]labels.MatchType{ // nolint: gochecknoglobals
+	"=":  labels.MatchEqual,
+	"!=": labels.MatchNotEqual,
+	"=~": labels.MatchRegexp,
+	"!~": labels.MatchNotRegexp,
+}
+
+// AlertmanagerConfig describes the routing tree and receivers of an Alertmanager configuration file
+type AlertmanagerConfig struct {
+	Route *AlertmanagerRoute
+	// Receivers maps the names of the defined receivers to the number
+	// of notification integrations they have. Receivers without any integration
+	// silently drop all alerts routed to them.
+	Receivers map[string]int
+}
+
+// AlertmanagerRoute describes a node of the Alertmanager routing tree
+type AlertmanagerRoute struct {
+	// Receiver is the effective receiver of the route, i.e. it is inherited from the parent route if not set
+	Receiver string
+	Match    map[string]string
+	MatchRE  map[string]string
+	// Matchers are the entries of the matchers list, which replaces match and match_re since Alertmanager 0.22
+	Matchers []*labels.Matcher
+	Continue bool
+
+	Routes []*AlertmanagerRoute
+
+	Pos token.Pos
+	End token.Pos
+}
+
+// GetAlertmanagerConfigs returns the Alertmanager configurations found in a document
+// and returns an error if that context has expired, i.e. the Document
+// has changed since
+// It blocks until all compile tasks are finished
+func (d *DocumentHandle) GetAlertmanagerConfigs() ([]*AlertmanagerConfig, error) {
+	if err := d.waitCompiled(); err != nil {
+		return nil, err
+	}
+
+	return d.snap.alertmanagerConfigs, nil
+}
+
+// scanAlertmanagerConfigs extracts the routing configuration of all yaml documents that look like
+// Alertmanager configuration files
+func (d *DocumentHandle) scanAlertmanagerConfigs() error {
+	yamls, err := d.GetYamls()
+	if err != nil {
+		return err
+	}
+
+	var configs []*AlertmanagerConfig
+
+	for _, yamlDoc := range yamls {
+		root := &yamlDoc.AST
+		if root.Kind == yaml.DocumentNode && len(root.Content) > 0 {
+			root = root.Content[0]
+		}
+
+		routeNode := MappingValue(root, "route")
+		if routeNode == nil || routeNode.Kind != yaml.MappingNode {
+			continue
+		}
+
+		config := &AlertmanagerConfig{
+			Receivers: make(map[string]int),
+		}
+
+		if config.Route, err = d.scanAlertmanagerRoute(routeNode, "", yamlDoc.LineOffset); err != nil {
+			return err
+		}
+
+		if receivers := MappingValue(root, "receivers"); receivers != nil && receivers.Kind == yaml.SequenceNode {
+			for _, receiver := range receivers.Content {
+				name := MappingValue(receiver, "name")
+				if name == nil {
+					continue
+				}
+
+				config.Receivers[name.Value] = countIntegrations(receiver)
+			}
+		}
+
+		configs = append(configs, config)
+	}
+
+	d.snap.mu.Lock()
+	defer d.snap.mu.Unlock()
+
+	select {
+	case <-d.snap.ctx.Done():
+		return d.snap.ctx.Err()
+	default:
+		d.snap.alertmanagerConfigs = configs
+		return nil
+	}
+}
+
+func (d *DocumentHandle) scanAlertmanagerRoute(node *yaml.Node, parentReceiver string, lineOffset int) (*AlertmanagerRoute, error) {
+	var err error
+
+	route := &AlertmanagerRoute{
+		Receiver: parentReceiver,
+		Match:    scalarMapping(MappingValue(node, "match")),
+		MatchRE:  scalarMapping(MappingValue(node, "match_re")),
+	}
+
+	if route.Pos, route.End, err = d.YamlNodeRange(node, lineOffset); err != nil {
+		return nil, err
+	}
+
+	if matchers := MappingValue(node, "matchers"); matchers != nil && matchers.Kind == yaml.SequenceNode {
+		for _, m := range matchers.Content {
+			matcher, parseErr := parseAlertmanagerMatcher(m.Value)
+			if parseErr == nil {
+				route.Matchers = append(route.Matchers, matcher)
+				continue
+			}
+
+			pos, end, err := d.YamlNodeRange(m, lineOffset)
+			if err != nil {
+				return nil, err
+			}
+
+			if err := d.addDiagnosticForRange(pos, end, protocol.SeverityError, parseErr.Error()); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if receiver := MappingValue(node, "receiver"); receiver != nil && receiver.Value != "" {
+		route.Receiver = receiver.Value
+	}
+
+	if cont := MappingValue(node, "continue"); cont != nil {
+		route.Continue = cont.Value == "true"
+	}
+
+	if routes := MappingValue(node, "routes"); routes != nil && routes.Kind == yaml.SequenceNode {
+		for _, child := range routes.Content {
+			if child == nil || child.Kind != yaml.MappingNode {
+				continue
+			}
+
+			childRoute, err := d.scanAlertmanagerRoute(child, route.Receiver, lineOffset)
+			if err != nil {
+				return nil, err
+			}
+
+			route.Routes = append(route.Routes, childRoute)
+		}
+	}
+
+	return route, nil
+}
+
+// parseAlertmanagerMatcher parses an entry of the matchers list of a route. The value may be quoted.
+func parseAlertmanagerMatcher(s string) (*labels.Matcher, error) {
+	match := alertmanagerMatcherRE.FindStringSubmatch(s)
+	if match == nil {
+		return nil, fmt.Errorf("invalid matcher %q, expected e.g. severity=\"page\"", s)
+	}
+
+	value := match[3]
+
+	if strings.HasPrefix(value, `"`) {
+		var err error
+
+		if value, err = strconv.Unquote(value); err != nil {
+			return nil, fmt.Errorf("invalid matcher %q: the value isn't quoted correctly", s)
+		}
+	}
+
+	matcher, err := labels.NewMatcher(alertmanagerMatchTypes[match[2]], match[1], value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid matcher %q: %s", s, err)
+	}
+
+	return matcher, nil
+}
+
+// countIntegrations returns the number of notification integrations a receiver has,
+// i.e. the number of entries in all of its *_configs lists
+func countIntegrations(receiver *yaml.Node) int {
+	count := 0
+
+	for i := 0; i+1 < len(receiver.Content); i += 2 {
+		key, value := receiver.Content[i], receiver.Content[i+1]
+
+		if strings.HasSuffix(key.Value, "_configs") && value.Kind == yaml.SequenceNode {
+			count += len(value.Content)
+		}
+	}
+
+	return count
+}
+
+// scalarMapping converts a yaml mapping of scalars into a map, or returns nil if the node isn't a mapping
+func scalarMapping(node *yaml.Node) map[string]string {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	ret := make(map[string]string)
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		ret[node.Content[i].Value] = node.Content[i+1].Value
+	}
+
+	return ret
+}
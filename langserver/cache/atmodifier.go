@@ -0,0 +1,159 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/prometheus/promql"
+)
+
+// AtModifier describes an @ modifier, which pins a selector or subquery to a fixed evaluation time
+type AtModifier struct {
+	// PosRange spans the modifier including the @, relative to the start of the query
+	PosRange promql.PositionRange
+	// Preprocessor is "start" or "end" for `@ start()` and `@ end()`, and empty for timestamps
+	Preprocessor string
+	// Timestamp is the unix timestamp the modifier pins the evaluation to, if Preprocessor is empty
+	Timestamp float64
+}
+
+// atArgumentRE matches the argument of an @ modifier
+var atArgumentRE = regexp.MustCompile(`^\s*(?:(start|end)\s*\(\s*\)|([+-]?[0-9]+(?:\.[0-9]*)?(?:[eE][+-]?[0-9]+)?))`) // nolint: gochecknoglobals
+
+// skipLiteral returns the offset of the closing quote of the string literal or the newline ending the comment
+// that starts at offset i of a query, or i if none starts there
+func skipLiteral(content string, i int) int {
+	switch c := content[i]; c {
+	case '"', '\'', '`':
+		for i++; i < len(content) && content[i] != c; i++ {
+			if content[i] == '\\' && c != '`' {
+				i++
+			}
+		}
+	case '#':
+		for i < len(content) && content[i] != '\n' {
+			i++
+		}
+	}
+
+	return i
+}
+
+// IsCode checks whether an offset of a query is outside of string literals and comments
+func IsCode(content string, offset int) bool {
+	for i := 0; i < len(content) && i < offset; i++ {
+		if i = skipLiteral(content, i); i >= offset {
+			return false
+		}
+	}
+
+	return true
+}
+
+// AtModifierAllowed checks whether an @ at offset i of a query follows a selector, a range or a subquery,
+// possibly with an offset modifier in between
+func AtModifierAllowed(content string, i int) bool {
+	prefix := strings.TrimRight(content[:i], " \t\r\n")
+	if prefix == "" {
+		return false
+	}
+
+	if c := prefix[len(prefix)-1]; c == '}' || c == ']' {
+		return true
+	}
+
+	token := prefix[strings.LastIndexFunc(prefix, func(r rune) bool { return !isNameChar(r) })+1:]
+
+	switch {
+	case token == "" || isKeyword(token):
+		return false
+	case '0' <= token[0] && token[0] <= '9':
+		// A duration is only allowed as argument of an offset modifier
+		return strings.HasSuffix(strings.TrimRight(prefix[:len(prefix)-len(token)], " \t\r\n"), "offset")
+	default:
+		// A metric name
+		return true
+	}
+}
+
+// isNameChar checks whether a character can be part of a metric name or a duration
+func isNameChar(r rune) bool {
+	return r == '_' || r == ':' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9'
+}
+
+// isKeyword checks whether a word is a keyword of PromQL rather than a metric name
+func isKeyword(word string) bool {
+	switch strings.ToLower(word) {
+	case "and", "or", "unless", "by", "without", "on", "ignoring", "group_left", "group_right", "bool", "offset":
+		return true
+	}
+
+	_, ok := promql.Functions[word]
+
+	return ok
+}
+
+// maskAtModifiers finds all complete @ modifiers of a query that follow a selector, a range or a subquery and
+// replaces them by whitespace, so the remainder of the query can be handled by the parser, which doesn't
+// support them. Incomplete or misplaced modifiers are left in place, so they are reported as syntax errors.
+func maskAtModifiers(content string) (string, []AtModifier) {
+	if !strings.Contains(content, "@") {
+		return content, nil
+	}
+
+	masked := []byte(content)
+
+	var modifiers []AtModifier
+
+	for i := 0; i < len(content); i++ {
+		if content[i] != '@' {
+			i = skipLiteral(content, i)
+			continue
+		}
+
+		match := atArgumentRE.FindStringSubmatch(content[i+1:])
+		if match == nil || !AtModifierAllowed(content, i) {
+			continue
+		}
+
+		modifier := AtModifier{
+			PosRange: promql.PositionRange{
+				Start: promql.Pos(i),
+				End:   promql.Pos(i + 1 + len(match[0])),
+			},
+			Preprocessor: match[1],
+		}
+
+		if match[2] != "" {
+			var err error
+
+			if modifier.Timestamp, err = strconv.ParseFloat(match[2], 64); err != nil {
+				continue
+			}
+		}
+
+		for j := modifier.PosRange.Start; j < modifier.PosRange.End; j++ {
+			masked[j] = ' '
+		}
+
+		modifiers = append(modifiers, modifier)
+
+		i = int(modifier.PosRange.End) - 1
+	}
+
+	return string(masked), modifiers
+}
@@ -0,0 +1,63 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/prometheus/prometheus/promql"
+)
+
+func TestMaskAtModifiers(*testing.T) {
+	tests := []struct {
+		input     string
+		modifiers int
+		// misplaced is set if an @ is left in place, so parsing fails
+		misplaced bool
+	}{
+		{input: `foo`, modifiers: 0},
+		{input: `foo @ 1609746000`, modifiers: 1},
+		{input: `rate(foo[5m] @ 1609746000.5) / rate(bar[5m] @ end())`, modifiers: 2},
+		{input: `foo{a="@ 123"} # @ 123`, modifiers: 0},
+		{input: `foo @ `, modifiers: 0},
+		{input: `foo offset 5m @ 1609746000`, modifiers: 1},
+		{input: `max_over_time(foo[1h:5m] @ start())`, modifiers: 1},
+		{input: `sum(foo) @ 1609746000`, modifiers: 0, misplaced: true},
+		{input: `1 @ 2`, modifiers: 0, misplaced: true},
+		{input: `foo @ 1 @ 2`, modifiers: 1, misplaced: true},
+	}
+
+	for _, test := range tests {
+		masked, modifiers := maskAtModifiers(test.input)
+
+		if len(masked) != len(test.input) {
+			panic(fmt.Sprintf("Masking %q changed its length", test.input))
+		}
+
+		if len(modifiers) != test.modifiers {
+			panic(fmt.Sprintf("Expected %d @ modifiers in %q, got %v", test.modifiers, test.input, modifiers))
+		}
+
+		if test.misplaced {
+			if _, err := promql.ParseExpr(masked); err == nil {
+				panic(fmt.Sprintf("Expected the misplaced @ in %q to be a syntax error", test.input))
+			}
+		} else if test.modifiers > 0 {
+			if _, err := promql.ParseExpr(masked); err != nil {
+				panic(fmt.Sprintf("Failed to parse %q after masking: %s", test.input, err))
+			}
+		}
+	}
+}
@@ -18,6 +18,7 @@ import (
 	"errors"
 	"go/token"
 	"sync"
+	"time"
 
 	"github.com/prometheus-community/promql-langserver/vendored/go-tools/jsonrpc2"
 	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
@@ -36,14 +37,61 @@ type DocumentCache struct {
 
 	documents map[protocol.DocumentURI]*document
 	mu        sync.RWMutex
+
+	// storage holds the content of the documents
+	storage Storage
+
+	// scheduler runs the compile tasks of all documents
+	scheduler *compileScheduler
+
+	// compileDebounce is the time compiling a changed document is delayed by, see SetCompileDebounce
+	compileDebounce time.Duration
 }
 
-// Init Initializes a Document cache
+// Init Initializes a Document cache that keeps all documents in memory
 func (c *DocumentCache) Init() {
+	c.InitWithStorage(NewMemoryStorage())
+}
+
+// InitWithStorage Initializes a Document cache that keeps the content of the documents in the given storage
+func (c *DocumentCache) InitWithStorage(storage Storage) {
 	c.fileSet = token.NewFileSet()
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	c.documents = make(map[protocol.DocumentURI]*document)
+	c.storage = storage
+	c.scheduler = newCompileScheduler()
+}
+
+// Close removes all documents from the cache and releases its storage
+func (c *DocumentCache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, d := range c.documents {
+		d.handle().snap.cancel()
+	}
+
+	c.documents = make(map[protocol.DocumentURI]*document)
+
+	return c.storage.Close()
+}
+
+// SetCompileDebounce delays compiling documents after they changed. Changes that arrive within the delay,
+// e.g. while typing quickly, supersede the pending compilation, so only the last version is compiled.
+// Newly added documents are compiled immediately.
+func (c *DocumentCache) SetCompileDebounce(debounce time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.compileDebounce = debounce
+}
+
+func (c *DocumentCache) getCompileDebounce() time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.compileDebounce
 }
 
 // AddDocument adds a Document to the cache
@@ -60,17 +108,23 @@ func (c *DocumentCache) AddDocument(serverLifetime context.Context, doc *protoco
 		}
 	}
 
-	file.SetLinesForContent([]byte(doc.Text))
+	languageID := doc.LanguageID
+	if languageID == "" || languageID == "plaintext" {
+		if guessed := languageFromURI(doc.URI); guessed != "" {
+			languageID = guessed
+		}
+	}
 
 	d := &document{
-		posData:    file,
+		base:       file.Base(),
 		uri:        doc.URI,
-		languageID: doc.LanguageID,
+		languageID: languageID,
+		storage:    c.storage,
+		debounce:   c.getCompileDebounce,
+		scheduler:  c.scheduler,
 	}
 
-	d.compilers.initialize()
-
-	err := (&DocumentHandle{d, context.Background()}).SetContent(serverLifetime, doc.Text, doc.Version, true)
+	err := (&DocumentHandle{doc: d}).SetContent(serverLifetime, doc.Text, doc.Version, true)
 
 	if err != nil {
 		return nil, err
@@ -80,7 +134,7 @@ func (c *DocumentCache) AddDocument(serverLifetime context.Context, doc *protoco
 	defer c.mu.Unlock()
 	c.documents[doc.URI] = d
 
-	return &DocumentHandle{d, d.versionCtx}, nil
+	return d.handle(), nil
 }
 
 // GetDocument retrieve a Document from the cache
@@ -94,10 +148,7 @@ func (c *DocumentCache) GetDocument(uri protocol.DocumentURI) (*DocumentHandle,
 		return nil, jsonrpc2.NewErrorf(jsonrpc2.CodeInternalError, "cache/getDocument: Document not found: %v", uri)
 	}
 
-	ret.mu.RLock()
-	defer ret.mu.RUnlock()
-
-	return &DocumentHandle{ret, ret.versionCtx}, nil
+	return ret.handle(), nil
 }
 
 // RemoveDocument removes a Document from the cache
@@ -110,9 +161,23 @@ func (c *DocumentCache) RemoveDocument(uri protocol.DocumentURI) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	d.doc.obsoleteVersion()
+	d.snap.cancel()
 
 	delete(c.documents, uri)
 
-	return nil
+	return c.storage.Delete(uri)
+}
+
+// GetDocuments returns handles for all documents in the cache
+func (c *DocumentCache) GetDocuments() []*DocumentHandle {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	ret := make([]*DocumentHandle, 0, len(c.documents))
+
+	for _, d := range c.documents {
+		ret = append(ret, d.handle())
+	}
+
+	return ret
 }
@@ -16,7 +16,9 @@ package cache
 import (
 	"context"
 	"fmt"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
 )
@@ -281,3 +283,237 @@ groups:
 		panic("File update without version update should have failed")
 	}
 }
+
+// TestDocumentSnapshots checks that every version of a document keeps its own content and compile results
+func TestDocumentSnapshots(*testing.T) {
+	c := &DocumentCache{}
+
+	c.Init()
+
+	old, err := c.AddDocument(context.Background(), &protocol.TextDocumentItem{
+		URI:        "snapshot.promql",
+		LanguageID: "promql",
+		Version:    1,
+		Text:       "up",
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	if err := old.SetContent(context.Background(), "sum(\nrate(foo[5m]))", 2, false); err != nil {
+		panic(err)
+	}
+
+	if _, err := old.GetContent(); err == nil {
+		panic("expected the content of a replaced version to be unavailable")
+	}
+
+	if _, err := old.GetQueries(); err == nil {
+		panic("expected the compile results of a replaced version to be unavailable")
+	}
+
+	current, err := c.GetDocument("snapshot.promql")
+	if err != nil {
+		panic(err)
+	}
+
+	if version, err := current.GetVersion(); err != nil || version != 2 {
+		panic(fmt.Sprintf("expected the current version, got %v, %v", version, err))
+	}
+
+	queries, err := current.GetQueries()
+	if err != nil || len(queries) != 1 || queries[0].Content != "sum(\nrate(foo[5m]))" {
+		panic("expected the queries of the current version")
+	}
+
+	if pos, err := current.PosToProtocolPosition(queries[0].Pos + 6); err != nil || pos.Line != 1 || pos.Character != 1 {
+		panic(fmt.Sprintf("expected positions to use the lines of the current version, got %v, %v", pos, err))
+	}
+}
+
+// TestIncrementalCompile checks that queries that didn't change are not parsed again, even if they moved,
+// and that their results use the positions of the new version
+func TestIncrementalCompile(*testing.T) {
+	c := &DocumentCache{}
+
+	c.Init()
+
+	const rules = `groups:
+- name: example
+  rules:
+  - record: a
+    expr: sum(foo)
+  - record: b
+    expr: rate(bar[5m]
+`
+
+	doc, err := c.AddDocument(context.Background(), &protocol.TextDocumentItem{
+		URI:        "rules.yml",
+		LanguageID: "yaml",
+		Version:    1,
+		Text:       rules,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	before, err := doc.GetQueries()
+	if err != nil || len(before) != 2 {
+		panic(fmt.Sprintf("expected two queries, got %v, %v", before, err))
+	}
+
+	if err := doc.SetContent(context.Background(), "# rules\n"+strings.Replace(rules, "sum(foo)", "sum(foo) by (job)", 1), 2, false); err != nil {
+		panic(err)
+	}
+
+	doc, err = c.GetDocument("rules.yml")
+	if err != nil {
+		panic(err)
+	}
+
+	after, err := doc.GetQueries()
+	if err != nil || len(after) != 2 {
+		panic(fmt.Sprintf("expected two queries, got %v, %v", after, err))
+	}
+
+	if after[0].Ast == before[0].Ast {
+		panic("expected the changed query to be parsed again")
+	}
+
+	if after[1].Ast != before[1].Ast || after[1].Pos == before[1].Pos || after[1].Record != "b" {
+		panic("expected the moved query to reuse its parse result at its new position")
+	}
+
+	diagnostics, err := doc.GetDiagnostics()
+	if err != nil || len(diagnostics) == 0 || diagnostics[0].Range.Start.Line != 7 {
+		panic(fmt.Sprintf("expected the parse error of the moved query at its new line, got %v, %v", diagnostics, err))
+	}
+}
+
+// TestRapidEdits checks that a document edited faster than it is compiled ends up with the queries of
+// the last version. Run with -race, the compile results of a version are read by the next one.
+func TestRapidEdits(*testing.T) {
+	c := &DocumentCache{}
+
+	c.Init()
+
+	rules := func(version int) string {
+		ret := "groups:\n- name: example\n  rules:\n"
+		for i := 0; i < 5; i++ {
+			ret += fmt.Sprintf("  - record: r%d\n    expr: sum(foo{version=\"%d\"}) by (job%d)\n", i, version%3, i)
+		}
+
+		return ret
+	}
+
+	doc, err := c.AddDocument(context.Background(), &protocol.TextDocumentItem{
+		URI:        "rules.yml",
+		LanguageID: "yaml",
+		Version:    1,
+		Text:       rules(1),
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	const versions = 2000
+
+	for version := 2; version <= versions; version++ {
+		// Varying delays let some versions finish compiling just before they are replaced
+		time.Sleep(time.Duration(version%5) * 20 * time.Microsecond)
+
+		if err := doc.SetContent(context.Background(), rules(version), float64(version), false); err != nil {
+			panic(err)
+		}
+	}
+
+	doc, err = c.GetDocument("rules.yml")
+	if err != nil {
+		panic(err)
+	}
+
+	queries, err := doc.GetQueries()
+	if err != nil || len(queries) != 5 {
+		panic(fmt.Sprintf("expected five queries, got %v, %v", queries, err))
+	}
+
+	for i, query := range queries {
+		if query.Record != fmt.Sprintf("r%d", i) || !strings.Contains(query.Content, fmt.Sprintf(`version="%d"`, versions%3)) {
+			panic(fmt.Sprintf("expected the queries of the last version in order, got %s: %s", query.Record, query.Content))
+		}
+	}
+}
+
+// TestCompileDebounce checks that versions superseded within the debounce delay are never compiled
+func TestCompileDebounce(*testing.T) {
+	c := &DocumentCache{}
+
+	c.Init()
+	c.SetCompileDebounce(50 * time.Millisecond)
+
+	doc, err := c.AddDocument(context.Background(), &protocol.TextDocumentItem{
+		URI:        "query.promql",
+		LanguageID: "promql",
+		Version:    1,
+		Text:       "up",
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	if queries, err := doc.GetQueries(); err != nil || len(queries) != 1 {
+		panic(fmt.Sprintf("expected a new document to be compiled, got %v, %v", queries, err))
+	}
+
+	if err := doc.SetContent(context.Background(), "sum(up", 2, false); err != nil {
+		panic(err)
+	}
+
+	superseded, err := c.GetDocument("query.promql")
+	if err != nil {
+		panic(err)
+	}
+
+	if err := superseded.SetContent(context.Background(), "sum(up)", 3, false); err != nil {
+		panic(err)
+	}
+
+	if _, err := superseded.GetQueries(); err == nil || len(superseded.snap.queries) != 0 {
+		panic("expected the superseded version not to be compiled")
+	}
+
+	doc, err = c.GetDocument("query.promql")
+	if err != nil {
+		panic(err)
+	}
+
+	queries, err := doc.GetQueries()
+	if err != nil || len(queries) != 1 || queries[0].Content != "sum(up)" || queries[0].Ast == nil {
+		panic(fmt.Sprintf("expected the last version to be compiled, got %v, %v", queries, err))
+	}
+}
+
+// TestCompileScheduler checks that changed documents are compiled before the added ones, the most recent change first
+func TestCompileScheduler(*testing.T) {
+	// Without workers, the tasks stay queued
+	s := &compileScheduler{}
+
+	handle := func(version float64) *DocumentHandle {
+		return &DocumentHandle{snap: &snapshot{version: version}}
+	}
+
+	s.schedule(handle(1), false)
+	s.schedule(handle(2), false)
+	s.schedule(handle(3), true)
+	s.schedule(handle(4), true)
+
+	var order []float64
+
+	for d := s.next(); d != nil; d = s.next() {
+		order = append(order, d.snap.version)
+	}
+
+	if fmt.Sprint(order) != "[4 3 1 2]" {
+		panic(fmt.Sprintf("expected the most recent change first and the added documents in order, got %v", order))
+	}
+}
@@ -15,6 +15,7 @@ package cache
 
 import (
 	"go/token"
+	"strings"
 
 	"github.com/prometheus/prometheus/promql"
 )
@@ -26,14 +27,21 @@ type CompiledQuery struct {
 	Err     promql.ParseErrors
 	Content string
 	Record  string
+	// AtModifiers are the @ modifiers found in the query. They are not part of the AST,
+	// since the parser doesn't support them
+	AtModifiers []AtModifier
+	// InJSONString is set for queries of Grafana dashboards and Jsonnet string literals. Their Content is
+	// the raw source of the string, with escape sequences and template variables masked, so text inserted
+	// into them must be escaped.
+	InJSONString bool
 }
 
 func (d *DocumentHandle) compile() error {
-	defer d.doc.compilers.Done()
+	defer d.snap.compilers.Done()
 
 	switch d.GetLanguageID() {
 	case "promql":
-		d.doc.compilers.Add(1)
+		d.snap.compilers.Add(1)
 		return d.compileQuery(true, 0, 0, "")
 	case "yaml":
 		err := d.parseYamls()
@@ -41,13 +49,34 @@ func (d *DocumentHandle) compile() error {
 			return err
 		}
 
-		d.doc.compilers.Add(1)
+		err = d.scanRuleGroups()
+		if err != nil {
+			return err
+		}
+
+		err = d.scanAlertmanagerConfigs()
+		if err != nil {
+			return err
+		}
+
+		d.snap.compilers.Add(1)
 
 		err = d.scanYamlTree()
 		if err != nil {
 			return err
 		}
+	case "json":
+		return d.scanDashboard()
+	case "markdown":
+		return d.scanMarkdown()
+	case "jsonnet":
+		return d.scanJsonnet()
+	case "html":
+		return d.scanConsoleTemplate()
 	default:
+		if d.isExpositionFile() {
+			return d.parseOpenMetrics()
+		}
 	}
 
 	return nil
@@ -57,7 +86,7 @@ func (d *DocumentHandle) compile() error {
 // if fullFile is set, the last two arguments are ignored and the full file is assumed
 // to be one query
 func (d *DocumentHandle) compileQuery(fullFile bool, pos token.Pos, endPos token.Pos, record string) error {
-	defer d.doc.compilers.Done()
+	defer d.snap.compilers.Done()
 
 	var content string
 
@@ -65,7 +94,7 @@ func (d *DocumentHandle) compileQuery(fullFile bool, pos token.Pos, endPos token
 
 	if fullFile {
 		content, expired = d.GetContent()
-		pos = token.Pos(d.doc.posData.Base())
+		pos = token.Pos(d.snap.posData.Base())
 	} else {
 		content, expired = d.GetSubstring(pos, endPos)
 	}
@@ -74,22 +103,61 @@ func (d *DocumentHandle) compileQuery(fullFile bool, pos token.Pos, endPos token
 		return expired
 	}
 
-	ast, err := promql.ParseExpr(content)
+	return d.compileContent(pos, content, false, record)
+}
+
+// compileContent compiles a query starting at pos in the document
+func (d *DocumentHandle) compileContent(pos token.Pos, content string, inJSONString bool, record string) error {
+	// The AST references the query string, it is copied so that the compile results don't keep
+	// the whole content of a spilled document in memory
+	content = string([]byte(content))
+
+	var (
+		ast         promql.Node
+		parseErr    promql.ParseErrors
+		atModifiers []AtModifier
+	)
+
+	// The positions of ASTs and errors are relative to the query, so they can be reused if the query moved
+	if previous, ok := d.snap.parsed[content]; ok {
+		ast, parseErr, atModifiers = previous.Ast, previous.Err, previous.AtModifiers
+	} else {
+		var masked string
 
-	var parseErr promql.ParseErrors
+		masked, atModifiers = maskAtModifiers(content)
 
-	var ok bool
+		var err error
 
-	if parseErr, ok = err.(promql.ParseErrors); !ok {
-		parseErr = nil
+		ast, err = promql.ParseExpr(masked)
+
+		// Other errors than ParseErrors leave parseErr nil
+		parseErr, _ = err.(promql.ParseErrors)
 	}
 
-	err = d.AddCompileResult(pos, ast, parseErr, record, content)
+	err := d.addCompileResult(&CompiledQuery{
+		Pos:          pos,
+		Ast:          ast,
+		Err:          parseErr,
+		Content:      content,
+		Record:       record,
+		AtModifiers:  atModifiers,
+		InJSONString: inJSONString,
+	})
 	if err != nil {
 		return err
 	}
 
 	for _, e := range parseErr {
+		// The parser sometimes reports positions beyond the end of the query,
+		// e.g. for unclosed parentheses. Point to the end of the query text instead,
+		// excluding trailing whitespace
+		if max := promql.Pos(len(strings.TrimRight(content, " \t\r\n"))); e.PositionRange.End > max {
+			e.PositionRange.End = max
+			if e.PositionRange.Start > max {
+				e.PositionRange.Start = max
+			}
+		}
+
 		diagnostic, err := d.promQLErrToProtocolDiagnostic(pos, &e) //nolint:scopelint
 		if err != nil {
 			return err
@@ -106,14 +174,26 @@ func (d *DocumentHandle) compileQuery(fullFile bool, pos token.Pos, endPos token
 
 // AddCompileResult updates the compilation Results of a Document. Discards the Result if the DocumentHandle is expired
 func (d *DocumentHandle) AddCompileResult(pos token.Pos, ast promql.Node, err promql.ParseErrors, record string, content string) error {
-	d.doc.mu.Lock()
-	defer d.doc.mu.Unlock()
+	return d.addCompileResult(&CompiledQuery{
+		Pos:     pos,
+		Ast:     ast,
+		Err:     err,
+		Content: content,
+		Record:  record,
+	})
+}
+
+func (d *DocumentHandle) addCompileResult(query *CompiledQuery) error {
+	d.snap.mu.Lock()
+	defer d.snap.mu.Unlock()
 
 	select {
-	case <-d.ctx.Done():
-		return d.ctx.Err()
+	case <-d.snap.ctx.Done():
+		return d.snap.ctx.Err()
 	default:
-		d.doc.queries = append(d.doc.queries, &CompiledQuery{pos, ast, err, content, record})
+		d.snap.queries = append(d.snap.queries, query)
+		d.linkRule(query)
+
 		return nil
 	}
 }
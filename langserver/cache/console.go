@@ -0,0 +1,267 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"go/token"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template/parse"
+)
+
+// consoleQueryFunctions are the template functions whose string argument is a PromQL expression
+var consoleQueryFunctions = map[string]bool{"query": true, "graphLink": true, "tableLink": true} // nolint: gochecknoglobals
+
+// consoleQueryTemplates are the templates of the console libraries shipped with Prometheus
+// whose first argument is a PromQL expression, e.g. {{ template "prom_query_drilldown" (args "up") }}
+var consoleQueryTemplates = map[string]bool{"prom_query_drilldown": true} // nolint: gochecknoglobals
+
+// consoleFunctions are the functions console templates can use: those Prometheus provides and the
+// builtin functions of Go templates, which the template parser doesn't know on its own
+var consoleFunctions = initializeConsoleFunctions() // nolint: gochecknoglobals
+
+func initializeConsoleFunctions() map[string]interface{} {
+	ret := make(map[string]interface{})
+
+	for _, name := range []string{
+		"query", "first", "label", "value", "strvalue", "sortByLabel", "args", "reReplaceAll", "match", "safeHtml",
+		"title", "toUpper", "toLower", "graphLink", "tableLink", "humanize", "humanize1024", "humanizeDuration",
+		"humanizePercentage", "humanizeTimestamp", "pathPrefix", "externalURL",
+		"and", "or", "not", "len", "index", "slice", "print", "printf", "println", "html", "js", "urlquery", "call",
+		"eq", "ne", "lt", "le", "gt", "ge",
+	} {
+		// The parser only checks that the functions exist
+		ret[name] = func() {}
+	}
+
+	return ret
+}
+
+// consoleGraphExprRegexp matches the expressions of graphs drawn by the console libraries,
+// e.g. new PromConsole.Graph({expr: "rate(http_requests_total[5m])", ...})
+var consoleGraphExprRegexp = regexp.MustCompile(`\bexpr\s*:\s*("(?:[^"\\\n]|\\.)*"|'(?:[^'\\\n]|\\.)*')`)
+
+// templateErrLine matches the line number of template parse errors, e.g. template: console:12: unexpected EOF
+var templateErrLine = regexp.MustCompile(`^template: [^:]*:(\d+):`)
+
+// consoleQuery is a string literal of a console template that contains a query
+type consoleQuery struct {
+	// Start and End span the content of the string, without the quotes
+	Start int
+	End   int
+	// Quote is the quote of the string, escape sequences are only allowed in double and single quoted strings
+	Quote byte
+}
+
+// IsConsoleTemplate checks whether an HTML document is a Prometheus console template, i.e. contains template actions
+func IsConsoleTemplate(languageID string, content string) bool {
+	return languageID == "html" && strings.Contains(content, "{{")
+}
+
+// consoleQueries returns the queries of a console template, i.e. the string arguments of the query, graphLink and
+// tableLink functions, the expressions of prom_query_drilldown and those of graphs of the console libraries.
+// If the template can't be parsed, the error is returned together with the queries of the graphs.
+func consoleQueries(content string) ([]consoleQuery, error) {
+	var ret []consoleQuery
+
+	for _, match := range consoleGraphExprRegexp.FindAllStringSubmatchIndex(content, -1) {
+		ret = append(ret, consoleQuery{Start: match[2] + 1, End: match[3] - 1, Quote: content[match[2]]})
+	}
+
+	trees, err := parse.Parse("console", content, "", "", consoleFunctions)
+	if err != nil {
+		return ret, err
+	}
+
+	addString := func(node parse.Node) {
+		if str, ok := node.(*parse.StringNode); ok && len(str.Quoted) >= 2 {
+			ret = append(ret, consoleQuery{Start: int(str.Pos) + 1, End: int(str.Pos) + len(str.Quoted) - 1, Quote: str.Quoted[0]})
+		}
+	}
+
+	var visit func(node parse.Node)
+
+	visit = func(node parse.Node) {
+		switch n := node.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return
+			}
+
+			for _, child := range n.Nodes {
+				visit(child)
+			}
+		case *parse.ActionNode:
+			visit(n.Pipe)
+		case *parse.IfNode:
+			visit(n.Pipe)
+			visit(n.List)
+			visit(n.ElseList)
+		case *parse.RangeNode:
+			visit(n.Pipe)
+			visit(n.List)
+			visit(n.ElseList)
+		case *parse.WithNode:
+			visit(n.Pipe)
+			visit(n.List)
+			visit(n.ElseList)
+		case *parse.TemplateNode:
+			if consoleQueryTemplates[n.Name] && n.Pipe != nil {
+				addTemplateArgument(n.Pipe, addString)
+			}
+
+			visit(n.Pipe)
+		case *parse.PipeNode:
+			if n == nil {
+				return
+			}
+
+			for i, cmd := range n.Cmds {
+				if len(cmd.Args) == 0 {
+					continue
+				}
+
+				ident, ok := cmd.Args[0].(*parse.IdentifierNode)
+
+				switch {
+				case ok && consoleQueryFunctions[ident.Ident] && len(cmd.Args) > 1:
+					addString(cmd.Args[1])
+				case ok && consoleQueryFunctions[ident.Ident] && i > 0 && len(n.Cmds[i-1].Args) == 1:
+					// The query is piped into the function, e.g. {{ "up" | query }}
+					addString(n.Cmds[i-1].Args[0])
+				}
+
+				for _, arg := range cmd.Args {
+					visit(arg)
+				}
+			}
+		}
+	}
+
+	for _, tree := range trees {
+		visit(tree.Root)
+	}
+
+	return ret, nil
+}
+
+// addTemplateArgument finds the first argument of the args call a console library template is invoked with
+func addTemplateArgument(pipe *parse.PipeNode, add func(parse.Node)) {
+	for _, cmd := range pipe.Cmds {
+		for _, arg := range cmd.Args {
+			inner, ok := arg.(*parse.PipeNode)
+			if !ok {
+				continue
+			}
+
+			for _, innerCmd := range inner.Cmds {
+				if len(innerCmd.Args) > 1 {
+					if ident, ok := innerCmd.Args[0].(*parse.IdentifierNode); ok && ident.Ident == "args" {
+						add(innerCmd.Args[1])
+					}
+				}
+			}
+		}
+	}
+}
+
+// scanConsoleTemplate compiles the queries of a Prometheus console template and reports template syntax errors
+func (d *DocumentHandle) scanConsoleTemplate() error {
+	content, err := d.GetContent()
+	if err != nil {
+		return err
+	}
+
+	if !IsConsoleTemplate(d.GetLanguageID(), content) {
+		return nil
+	}
+
+	base := token.Pos(d.snap.posData.Base())
+
+	queries, parseErr := consoleQueries(content)
+	if parseErr != nil {
+		if err := d.templateErrToDiagnostic(parseErr); err != nil {
+			return err
+		}
+	}
+
+	for _, q := range queries {
+		query := content[q.Start:q.End]
+		if strings.TrimSpace(query) == "" {
+			continue
+		}
+
+		// Raw strings contain no escape sequences
+		inString := q.Quote != '`'
+		if inString {
+			query = maskJSONEscapes(query)
+		}
+
+		query = maskTemplateActions(query)
+
+		d.snap.compilers.Add(1)
+
+		if err := d.compileMaskedQuery(base+token.Pos(q.Start), query, inString); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// maskTemplateActions replaces template actions inside of a query, e.g. the parameters of a console in
+// `rate(node_cpu_seconds_total{instance="{{ .Params.instance }}"}[{{ .Params.range }}])`, by values of the
+// same length that the parser accepts: durations inside of brackets and numbers elsewhere. Actions inside
+// of strings are valid PromQL and are kept.
+func maskTemplateActions(query string) string {
+	return maskPlaceholders(query, func(state *maskState, pos int) (int, string) {
+		if !strings.HasPrefix(query[pos:], "{{") {
+			return pos, ""
+		}
+
+		end := strings.Index(query[pos:], "}}")
+		if end < 0 {
+			return pos, ""
+		}
+
+		end += pos + 2
+
+		return end, maskValue(state, end-pos)
+	})
+}
+
+// templateErrToDiagnostic reports a template syntax error on the line it occurred
+func (d *DocumentHandle) templateErrToDiagnostic(templateErr error) error {
+	line := 1
+
+	if m := templateErrLine.FindStringSubmatch(templateErr.Error()); m != nil {
+		if l, err := strconv.Atoi(m[1]); err == nil && l > 0 {
+			line = l
+		}
+	}
+
+	start, err := d.YamlPositionToTokenPos(line, 1, 0)
+	if err != nil {
+		return err
+	}
+
+	end := start
+
+	if next, err := d.YamlPositionToTokenPos(line+1, 1, 0); err == nil && next > start {
+		end = next - 1
+	}
+
+	return d.addDiagnosticForRange(start, end, 1, templateErr.Error())
+}
@@ -0,0 +1,105 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+func TestConsoleTemplate(t *testing.T) { // nolint:funlen
+	console := `{{ template "head" . }}
+<h1>{{ .Params.instance }}</h1>
+<table>
+  <tr><td>Up</td><td>{{ template "prom_query_drilldown" (args "up{instance=\"{{ .Params.instance }}\"}") }}</td></tr>
+  <tr><td>Load</td><td>{{ with query "node_load1" }}{{ . | first | value | humanize }}{{ end }}</td></tr>
+  <tr><td>Errors</td><td>{{ ` + "`" + `sum(rate(errors_total[5m])` + "`" + ` | query }}</td></tr>
+</table>
+<script>
+new PromConsole.Graph({
+  node: document.querySelector("#cpu"),
+  expr: 'rate(node_cpu_seconds_total{instance="{{ .Params.instance }}"}[{{ .Params.range }}])',
+})
+</script>
+{{ template "tail" }}
+`
+
+	c := &DocumentCache{}
+
+	c.Init()
+
+	doc, err := c.AddDocument(
+		context.Background(),
+		&protocol.TextDocumentItem{
+			URI:        "node.html",
+			LanguageID: "html",
+			Version:    0,
+			Text:       console,
+		})
+	if err != nil {
+		panic("Failed to AddDocument() to cache")
+	}
+
+	queries, err := doc.GetQueries()
+	if err != nil {
+		panic("Failed to get queries")
+	}
+
+	var contents []string
+
+	for _, q := range queries {
+		contents = append(contents, q.Content)
+
+		valid := q.Ast != nil && len(q.Err) == 0
+		if broken := strings.HasPrefix(q.Content, "sum("); valid == broken {
+			panic(fmt.Sprintf("Expected only the unclosed sum to be invalid, got %v for %q", q.Err, q.Content))
+		}
+	}
+
+	if len(queries) != 4 {
+		panic(fmt.Sprintf("Expected 4 queries, got %q", contents))
+	}
+
+	diagnostics, err := doc.GetDiagnostics()
+	if err != nil || len(diagnostics) == 0 {
+		panic("Expected a diagnostic for the unclosed parenthesis")
+	}
+
+	for _, d := range diagnostics {
+		if d.Range.Start.Line != 5 {
+			panic(fmt.Sprintf("Unexpected diagnostic %v", d))
+		}
+	}
+
+	broken, err := c.AddDocument(
+		context.Background(),
+		&protocol.TextDocumentItem{
+			URI:        "broken.html",
+			LanguageID: "html",
+			Version:    0,
+			Text:       "<p>\n{{ if query \"up\" }}up\n</p>\n",
+		})
+	if err != nil {
+		panic("Failed to AddDocument() to cache")
+	}
+
+	diagnostics, err = broken.GetDiagnostics()
+	if err != nil || len(diagnostics) != 1 || !strings.Contains(diagnostics[0].Message, "unexpected EOF") {
+		panic(fmt.Sprintf("Expected the unclosed if to be reported, got %v", diagnostics))
+	}
+}
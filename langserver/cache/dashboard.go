@@ -0,0 +1,240 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"go/token"
+	"strings"
+)
+
+// DashboardTarget is a query of a Grafana dashboard panel
+type DashboardTarget struct {
+	Panel *JSONNode
+	Expr  *JSONNode
+}
+
+// GrafanaDashboard returns the root object of a document if it is a Grafana dashboard
+func (d *DocumentHandle) GrafanaDashboard() *JSONNode {
+	if d.GetLanguageID() != "json" {
+		return nil
+	}
+
+	root, err := d.ParseJSON()
+	if err != nil || root.Kind != JSONObject {
+		return nil
+	}
+
+	if root.Get("panels") == nil && root.Get("rows") == nil {
+		return nil
+	}
+
+	return root
+}
+
+// DashboardTargets returns the queries of all panels of a dashboard, including
+// panels inside of rows
+func DashboardTargets(root *JSONNode) []DashboardTarget {
+	var ret []DashboardTarget
+
+	var walkPanels func(panels *JSONNode)
+
+	walkPanels = func(panels *JSONNode) {
+		if panels == nil || panels.Kind != JSONArray {
+			return
+		}
+
+		for _, panel := range panels.Values {
+			// Collapsed rows contain their panels
+			walkPanels(panel.Get("panels"))
+
+			targets := panel.Get("targets")
+			if targets == nil || targets.Kind != JSONArray {
+				continue
+			}
+
+			for _, target := range targets.Values {
+				if expr := target.Get("expr"); expr != nil && expr.Kind == JSONString {
+					ret = append(ret, DashboardTarget{Panel: panel, Expr: expr})
+				}
+			}
+		}
+	}
+
+	walkPanels(root.Get("panels"))
+
+	// Dashboards created before Grafana 5 organize panels in rows
+	if rows := root.Get("rows"); rows != nil && rows.Kind == JSONArray {
+		for _, row := range rows.Values {
+			walkPanels(row.Get("panels"))
+		}
+	}
+
+	return ret
+}
+
+// scanDashboard compiles the queries of the panels of a Grafana dashboard
+func (d *DocumentHandle) scanDashboard() error {
+	root := d.GrafanaDashboard()
+	if root == nil {
+		return nil
+	}
+
+	for _, target := range DashboardTargets(root) {
+		// The query is the content of the string, without its quotes
+		pos, end := target.Expr.Pos+1, target.Expr.End-1
+		if end <= pos {
+			continue
+		}
+
+		d.snap.compilers.Add(1)
+
+		if err := d.compileJSONString(pos, end); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// compileJSONString compiles a query given by the raw source of a JSON string
+func (d *DocumentHandle) compileJSONString(pos token.Pos, endPos token.Pos) error {
+	defer d.snap.compilers.Done()
+
+	raw, expired := d.GetSubstring(pos, endPos)
+	if expired != nil {
+		return expired
+	}
+
+	return d.compileContent(pos, maskGrafanaVariables(maskJSONEscapes(raw)), true, "")
+}
+
+// maskJSONEscapes replaces the escape sequences in the raw source of a JSON string by PromQL of the
+// same length, so that offsets into the query are offsets into the document. Escaped quotes become
+// quotes padded with a space outside of the PromQL string and escaped whitespace becomes spaces.
+// Escaped backslashes and unicode escapes mean the same in PromQL strings and are kept.
+// Escaped single quotes, which Jsonnet strings can contain, are handled like escaped double quotes.
+func maskJSONEscapes(raw string) string {
+	ret := []byte(raw)
+
+	inString := map[byte]bool{}
+
+	for i := 0; i+1 < len(ret); i++ {
+		if ret[i] != '\\' {
+			continue
+		}
+
+		switch q := ret[i+1]; q {
+		case '"', '\'':
+			if inString[q] {
+				ret[i], ret[i+1] = q, ' '
+			} else {
+				ret[i], ret[i+1] = ' ', q
+			}
+
+			inString[q] = !inString[q]
+		case 'n', 'r', 't':
+			ret[i], ret[i+1] = ' ', ' '
+		case '/':
+			ret[i] = ' '
+		}
+
+		// Skip the escaped character
+		i++
+	}
+
+	return string(ret)
+}
+
+// maskGrafanaVariables replaces references to Grafana variables outside of strings, e.g. `[$__rate_interval]`,
+// by values of the same length that the parser accepts: durations inside of brackets, numbers elsewhere.
+// Variables inside of strings, e.g. in label matchers, are valid PromQL and are kept.
+func maskGrafanaVariables(query string) string {
+	return maskPlaceholders(query, func(state *maskState, pos int) (int, string) {
+		if query[pos] != '$' {
+			return pos, ""
+		}
+
+		end := variableEnd(query, pos)
+		if end-pos < 2 {
+			return pos, ""
+		}
+
+		return end, maskValue(state, end-pos)
+	})
+}
+
+// variableEnd returns the end of a Grafana variable reference starting at pos,
+// i.e. `$name` or `${name}` with an optional format, e.g. `${name:csv}`
+func variableEnd(query string, pos int) int {
+	if strings.HasPrefix(query[pos:], "${") {
+		if end := strings.IndexByte(query[pos:], '}'); end >= 0 {
+			return pos + end + 1
+		}
+
+		return pos
+	}
+
+	end := pos + 1
+
+	for end < len(query) && (query[end] == '_' || query[end] >= 'a' && query[end] <= 'z' ||
+		query[end] >= 'A' && query[end] <= 'Z' || query[end] >= '0' && query[end] <= '9') {
+		end++
+	}
+
+	return end
+}
+
+// ExpandGrafanaVariables replaces the references to Grafana variables in a query, including those inside of strings,
+// by the values lookup returns for their names. References lookup has no value for are kept, their names are returned once.
+func ExpandGrafanaVariables(query string, lookup func(name string) (string, bool)) (string, []string) {
+	var (
+		ret        strings.Builder
+		unresolved []string
+	)
+
+	seen := make(map[string]bool)
+
+	for i := 0; i < len(query); i++ {
+		if query[i] != '$' {
+			ret.WriteByte(query[i])
+			continue
+		}
+
+		end := variableEnd(query, i)
+		if end-i < 2 {
+			ret.WriteByte(query[i])
+			continue
+		}
+
+		name := strings.TrimSuffix(strings.TrimPrefix(query[i+1:end], "{"), "}")
+		if colon := strings.IndexByte(name, ':'); colon >= 0 {
+			name = name[:colon]
+		}
+
+		if value, ok := lookup(name); ok {
+			ret.WriteString(value)
+		} else {
+			ret.WriteString(query[i:end])
+
+			if !seen[name] {
+				seen[name] = true
+				unresolved = append(unresolved, name)
+			}
+		}
+
+		i = end - 1
+	}
+
+	return ret.String(), unresolved
+}
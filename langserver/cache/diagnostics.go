@@ -15,6 +15,8 @@ package cache
 
 import (
 	"go/token"
+	"regexp"
+	"strconv"
 
 	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
 	"github.com/prometheus/prometheus/promql"
@@ -80,16 +82,64 @@ func (d *DocumentHandle) warnQuotedYaml(start token.Pos, end token.Pos) error {
 	return d.AddDiagnostic(message)
 }
 
+// nolint: gochecknoglobals
+var yamlErrLine = regexp.MustCompile(`line (\d+):`)
+
+// yamlErrToDiagnostic reports a yaml syntax error on the line it occurred
+func (d *DocumentHandle) yamlErrToDiagnostic(yamlErr error, lineOffset int) error {
+	line := 1
+
+	if m := yamlErrLine.FindStringSubmatch(yamlErr.Error()); m != nil {
+		if l, err := strconv.Atoi(m[1]); err == nil && l > 0 {
+			line = l
+		}
+	}
+
+	start, err := d.YamlPositionToTokenPos(line, 1, lineOffset)
+	if err != nil {
+		return err
+	}
+
+	end := start
+
+	if next, err := d.YamlPositionToTokenPos(line+1, 1, lineOffset); err == nil && next > start {
+		end = next - 1
+	}
+
+	return d.addDiagnosticForRange(start, end, 1, yamlErr.Error())
+}
+
+// addDiagnosticForRange creates a diagnostic spanning the given token.Pos range and adds it to the document
+func (d *DocumentHandle) addDiagnosticForRange(start token.Pos, end token.Pos, severity protocol.DiagnosticSeverity, message string) error {
+	var err error
+
+	diagnostic := &protocol.Diagnostic{
+		Severity: severity,
+		Source:   "promql-lsp",
+		Message:  message,
+	}
+
+	if diagnostic.Range.Start, err = d.PosToProtocolPosition(start); err != nil {
+		return err
+	}
+
+	if diagnostic.Range.End, err = d.PosToProtocolPosition(end); err != nil {
+		return err
+	}
+
+	return d.AddDiagnostic(diagnostic)
+}
+
 // AddDiagnostic updates the compilation Results of a Document. Discards the Result if the context is expired
 func (d *DocumentHandle) AddDiagnostic(diagnostic *protocol.Diagnostic) error {
-	d.doc.mu.Lock()
-	defer d.doc.mu.Unlock()
+	d.snap.mu.Lock()
+	defer d.snap.mu.Unlock()
 
 	select {
-	case <-d.ctx.Done():
-		return d.ctx.Err()
+	case <-d.snap.ctx.Done():
+		return d.snap.ctx.Err()
 	default:
-		d.doc.diagnostics = append(d.doc.diagnostics, *diagnostic)
+		d.snap.diagnostics = append(d.snap.diagnostics, *diagnostic)
 		return nil
 	}
 }
@@ -18,7 +18,11 @@ import (
 	"context"
 	"errors"
 	"go/token"
+	"sort"
+	"strings"
 	"sync"
+	"time"
+	"unicode/utf8"
 
 	"github.com/prometheus-community/promql-langserver/vendored/go-tools/jsonrpc2"
 	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
@@ -28,49 +32,154 @@ import (
 // document caches content, metadata and compile results of a document
 // All exported access methods should be threadsafe
 type document struct {
-	posData *token.File
+	// base is the start of the range of token.Pos reserved for the document
+	base int
 
 	uri        string
 	languageID string
-	version    float64
-	content    string
 
-	mu sync.RWMutex
+	// storage holds the content of the document
+	storage Storage
+
+	// debounce returns the time compiling is delayed by after a change
+	debounce func() time.Duration
+	// scheduler runs the compile tasks of the document
+	scheduler *compileScheduler
+
+	// mu protects current, it is only held while a new version replaces the current one
+	mu      sync.Mutex
+	current *snapshot
+}
+
+// snapshot is an immutable version of a document. Every change creates a new snapshot, so that requests
+// reading a version never wait for the compilation of another one. The compile results are only
+// written by the compile tasks of the snapshot and must not be read before compiled is closed.
+type snapshot struct {
+	version float64
+	posData *token.File
+
+	// size is the length of the content and wideLines are the lines containing non-ASCII characters by line number.
+	// Positions are converted with them, without loading the content, which might have been spilled to disk.
+	size      int
+	wideLines map[int]string
+
+	// ctx expires as soon as the snapshot is replaced by a newer version
+	ctx    context.Context
+	cancel context.CancelFunc
 
-	versionCtx      context.Context
-	obsoleteVersion context.CancelFunc
+	// mu serializes the compile tasks of the snapshot
+	mu sync.Mutex
 
 	queries []*CompiledQuery
 	yamls   []*YamlDoc
 
+	metricFamilies []*MetricFamily
+	ruleGroups     []*RuleGroup
+
+	alertmanagerConfigs []*AlertmanagerConfig
+
 	diagnostics []protocol.Diagnostic
 
-	// Wait for this before accessing  compileResults
-	compilers waitGroup
+	// parsed are the compile results of the previous versions by query content. Queries that didn't change
+	// are not parsed again, which keeps editing large rule files fast. It is dropped once compiling is finished.
+	parsed map[string]*CompiledQuery
+
+	// compilers counts the running compile tasks, compiled is closed once all of them are finished
+	compilers sync.WaitGroup
+	compiled  chan struct{}
 }
 
-// DocumentHandle bundles a Document together with a context.Context that expires
-// when the document changes
+// DocumentHandle bundles a Document together with the version it was retrieved at.
+// Its methods fail with the error of a context that expires when the document changes.
 type DocumentHandle struct {
-	doc *document
-	ctx context.Context
+	doc  *document
+	snap *snapshot
+}
+
+// handle returns a handle for the current version of a document
+func (d *document) handle() *DocumentHandle {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return &DocumentHandle{d, d.current}
 }
 
 func (d *DocumentHandle) GetContext() context.Context {
-	return d.ctx
+	return d.snap.ctx
+}
+
+// waitCompiled blocks until all compile tasks of the version of the handle are finished
+func (d *DocumentHandle) waitCompiled() error {
+	select {
+	case <-d.snap.compiled:
+		return d.snap.ctx.Err()
+	case <-d.snap.ctx.Done():
+		return d.snap.ctx.Err()
+	}
+}
+
+// content returns the content of the version of the handle
+func (d *DocumentHandle) content() (string, error) {
+	if err := d.snap.ctx.Err(); err != nil {
+		return "", err
+	}
+
+	content, err := d.doc.storage.Load(d.doc.uri)
+	if err != nil {
+		// Loading fails if the content has been replaced by a newer version in the meantime
+		if ctxErr := d.snap.ctx.Err(); ctxErr != nil {
+			return "", ctxErr
+		}
+
+		return "", err
+	}
+
+	// The storage only holds the current version, which is stored after the previous one has expired
+	if err := d.snap.ctx.Err(); err != nil {
+		return "", err
+	}
+
+	return content, nil
+}
+
+// wideLines returns the lines of a content that contain non-ASCII characters by line number
+func wideLines(content string) map[int]string {
+	ret := make(map[int]string)
+
+	for line, start := 1, 0; start <= len(content); line++ {
+		end := strings.IndexByte(content[start:], '\n')
+		if end < 0 {
+			end = len(content) - start
+		}
+
+		text := content[start : start+end]
+
+		if strings.IndexFunc(text, func(r rune) bool { return r >= utf8.RuneSelf }) >= 0 {
+			// Copied, so the snapshot doesn't keep the whole content in memory
+			ret[line] = string([]byte(text))
+		}
+
+		start += end + 1
+	}
+
+	return ret
 }
 
 // ApplyIncrementalChanges applies giver changes to a given Document Content
 // The context in the DocumentHandle is ignored
 func (d *DocumentHandle) ApplyIncrementalChanges(changes []protocol.TextDocumentContentChangeEvent, version float64) (string, error) {
-	d.doc.mu.RLock()
-	defer d.doc.mu.RUnlock()
+	current := d.doc.handle()
 
-	if version <= d.doc.version {
+	if version <= current.snap.version {
 		return "", jsonrpc2.NewErrorf(jsonrpc2.CodeInvalidParams, "Update to file didn't increase version number")
 	}
 
-	content := []byte(d.doc.content)
+	text, err := current.content()
+	if err != nil {
+		return "", err
+	}
+
+	content := []byte(text)
 	uri := d.doc.uri
 
 	for _, change := range changes {
@@ -114,7 +223,7 @@ func (d *DocumentHandle) SetContent(serverLifetime context.Context, content stri
 	d.doc.mu.Lock()
 	defer d.doc.mu.Unlock()
 
-	if !new && version <= d.doc.version {
+	if !new && version <= d.doc.current.version {
 		return jsonrpc2.NewErrorf(jsonrpc2.CodeInvalidParams, "Update to file didn't increase version number")
 	}
 
@@ -122,44 +231,105 @@ func (d *DocumentHandle) SetContent(serverLifetime context.Context, content stri
 		return jsonrpc2.NewErrorf(jsonrpc2.CodeInternalError, "cache/SetContent: Provided.document to large.")
 	}
 
+	// Every version has its own line table, with the position range reserved for the document
+	posData := token.NewFileSet().AddFile(d.doc.uri, d.doc.base, maxDocumentSize)
+
+	// An additional newline is appended, to make sure the last line is indexed
+	posData.SetLinesForContent(append([]byte(content), '\n'))
+
+	var parsed map[string]*CompiledQuery
+
+	// The previous version expires before its content is replaced
 	if !new {
-		d.doc.obsoleteVersion()
+		d.doc.current.cancel()
+
+		parsed = d.doc.current.parsedQueries()
+	}
+
+	if err := d.doc.storage.Store(d.doc.uri, content); err != nil {
+		return err
 	}
 
-	d.doc.versionCtx, d.doc.obsoleteVersion = context.WithCancel(serverLifetime)
+	snap := &snapshot{
+		version:             version,
+		posData:             posData,
+		size:                len(content),
+		wideLines:           wideLines(content),
+		queries:             []*CompiledQuery{},
+		yamls:               []*YamlDoc{},
+		metricFamilies:      []*MetricFamily{},
+		ruleGroups:          []*RuleGroup{},
+		alertmanagerConfigs: []*AlertmanagerConfig{},
+		diagnostics:         []protocol.Diagnostic{},
+		parsed:              parsed,
+		compiled:            make(chan struct{}),
+	}
 
-	d.doc.content = content
-	d.doc.version = version
+	snap.ctx, snap.cancel = context.WithCancel(serverLifetime)
 
-	// An additional newline is appended, to make sure the last line is indexed
-	d.doc.posData.SetLinesForContent(append([]byte(content), '\n'))
+	d.doc.current = snap
+
+	snap.compilers.Add(1)
 
-	d.doc.queries = []*CompiledQuery{}
-	d.doc.yamls = []*YamlDoc{}
-	d.doc.diagnostics = []protocol.Diagnostic{}
+	go func() {
+		snap.compilers.Wait()
 
-	d.doc.compilers.Add(1)
+		snap.mu.Lock()
+		// The queries of rule files are compiled concurrently, they are sorted to be independent of the
+		// order the compile tasks finish in. The next version may read them at the same time.
+		sort.SliceStable(snap.queries, func(i, j int) bool { return snap.queries[i].Pos < snap.queries[j].Pos })
+		snap.parsed = nil
+		snap.mu.Unlock()
 
-	// We need to create a new document handler here since the old one
-	// still carries the deprecated version context
-	go (&DocumentHandle{d.doc, d.doc.versionCtx}).compile() //nolint:errcheck
+		close(snap.compiled)
+	}()
+
+	if debounce := d.doc.debounce(); !new && debounce > 0 {
+		go func() {
+			timer := time.NewTimer(debounce)
+			defer timer.Stop()
+
+			select {
+			case <-snap.ctx.Done():
+				// Superseded by a newer version before compiling started
+				snap.compilers.Done()
+			case <-timer.C:
+				d.doc.scheduler.schedule(&DocumentHandle{d.doc, snap}, true)
+			}
+		}()
+
+		return nil
+	}
+
+	d.doc.scheduler.schedule(&DocumentHandle{d.doc, snap}, !new)
 
 	return nil
 }
 
+// parsedQueries returns the compile results of a snapshot by query content, including those of older
+// versions it hasn't finished compiling, so that quickly typed changes don't lose them
+func (s *snapshot) parsedQueries() map[string]*CompiledQuery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ret := make(map[string]*CompiledQuery, len(s.parsed)+len(s.queries))
+
+	for content, query := range s.parsed {
+		ret[content] = query
+	}
+
+	for _, query := range s.queries {
+		ret[query.Content] = query
+	}
+
+	return ret
+}
+
 // GetContent returns the content of a document
 // and returns an error if that context has expired, i.e. the Document
 // has changed since
 func (d *DocumentHandle) GetContent() (string, error) {
-	d.doc.mu.RLock()
-	defer d.doc.mu.RUnlock()
-
-	select {
-	case <-d.ctx.Done():
-		return "", d.ctx.Err()
-	default:
-		return d.doc.content, nil
-	}
+	return d.content()
 }
 
 // GetSubstring returns a substring of the content of a document
@@ -168,24 +338,20 @@ func (d *DocumentHandle) GetContent() (string, error) {
 // The remaining parameters are the start and end of the substring, encoded
 // as token.Pos
 func (d *DocumentHandle) GetSubstring(pos token.Pos, endPos token.Pos) (string, error) {
-	d.doc.mu.RLock()
-	defer d.doc.mu.RUnlock()
+	content, err := d.content()
+	if err != nil {
+		return "", err
+	}
 
-	select {
-	case <-d.ctx.Done():
-		return "", d.ctx.Err()
-	default:
-		content := d.doc.content
-		base := d.doc.posData.Base()
-		pos -= token.Pos(base)
-		endPos -= token.Pos(base)
-
-		if pos < 0 || pos > endPos || int(endPos) > len(content) {
-			return "", errors.New("invalid range")
-		}
+	base := d.doc.base
+	pos -= token.Pos(base)
+	endPos -= token.Pos(base)
 
-		return content[pos:endPos], nil
+	if pos < 0 || pos > endPos || int(endPos) > len(content) {
+		return "", errors.New("invalid range")
 	}
+
+	return content[pos:endPos], nil
 }
 
 // GetQueries returns the Compilation Results of a document
@@ -193,18 +359,11 @@ func (d *DocumentHandle) GetSubstring(pos token.Pos, endPos token.Pos) (string,
 // has changed since
 // It blocks until all compile tasks are finished
 func (d *DocumentHandle) GetQueries() ([]*CompiledQuery, error) {
-	d.doc.compilers.Wait()
-
-	d.doc.mu.RLock()
-
-	defer d.doc.mu.RUnlock()
-
-	select {
-	case <-d.ctx.Done():
-		return nil, d.ctx.Err()
-	default:
-		return d.doc.queries, nil
+	if err := d.waitCompiled(); err != nil {
+		return nil, err
 	}
+
+	return d.snap.queries, nil
 }
 
 // GetQuery returns a successfully compiled query at the given position, if there is one
@@ -231,15 +390,11 @@ func (d *DocumentHandle) GetQuery(pos token.Pos) (*CompiledQuery, error) {
 // and returns an error if that context has expired, i.e. the Document
 // has changed since
 func (d *DocumentHandle) GetVersion() (float64, error) {
-	d.doc.mu.RLock()
-	defer d.doc.mu.RUnlock()
-
-	select {
-	case <-d.ctx.Done():
-		return 0, d.ctx.Err()
-	default:
-		return d.doc.version, nil
+	if err := d.snap.ctx.Err(); err != nil {
+		return 0, err
 	}
+
+	return d.snap.version, nil
 }
 
 // GetURI returns the content of a document
@@ -257,17 +412,16 @@ func (d *DocumentHandle) GetLanguageID() string {
 // GetYamls returns the yaml documents found in the document
 // and returns an error if that context has expired, i.e. the Document
 // has changed since
-// It blocks until all compile tasks are finished
+// The yaml documents are parsed before the queries are compiled, so it doesn't wait for the compile tasks
 func (d *DocumentHandle) GetYamls() ([]*YamlDoc, error) {
-	d.doc.mu.RLock()
-	defer d.doc.mu.RUnlock()
+	d.snap.mu.Lock()
+	defer d.snap.mu.Unlock()
 
-	select {
-	case <-d.ctx.Done():
-		return nil, d.ctx.Err()
-	default:
-		return d.doc.yamls, nil
+	if err := d.snap.ctx.Err(); err != nil {
+		return nil, err
 	}
+
+	return d.snap.yamls, nil
 }
 
 // GetDiagnostics returns the Compilation Results of a document
@@ -275,15 +429,9 @@ func (d *DocumentHandle) GetYamls() ([]*YamlDoc, error) {
 // has changed since
 // It blocks until all compile tasks are finished
 func (d *DocumentHandle) GetDiagnostics() ([]protocol.Diagnostic, error) {
-	d.doc.compilers.Wait()
-
-	d.doc.mu.RLock()
-	defer d.doc.mu.RUnlock()
-
-	select {
-	case <-d.ctx.Done():
-		return nil, d.ctx.Err()
-	default:
-		return d.doc.diagnostics, nil
+	if err := d.waitCompiled(); err != nil {
+		return nil, err
 	}
+
+	return d.snap.diagnostics, nil
 }
@@ -25,22 +25,21 @@ import (
 // Call the (* Document) Functions with an expired context. Expected behaviour is that all
 // of these calls return an error
 func TestDocumentContext(t *testing.T) { //nolint: funlen
-	doc := &document{}
-
-	doc.posData = token.NewFileSet().AddFile("", -1, 0)
-
-	doc.compilers.initialize()
+	snap := &snapshot{
+		posData:  token.NewFileSet().AddFile("", -1, 0),
+		compiled: make(chan struct{}),
+	}
 
-	expired, cancel := context.WithCancel(context.Background())
+	snap.ctx, snap.cancel = context.WithCancel(context.Background())
 
-	cancel()
+	snap.cancel()
 
-	d := &DocumentHandle{doc, expired}
+	d := &DocumentHandle{&document{current: snap}, snap}
 
 	// From compile.go
 
-	// Necessary since compile() will call d.compilers.Done()
-	d.doc.compilers.Add(1)
+	// Necessary since compile() will call d.snap.compilers.Done()
+	d.snap.compilers.Add(1)
 
 	d.doc.languageID = "promql"
 
@@ -48,8 +47,8 @@ func TestDocumentContext(t *testing.T) { //nolint: funlen
 		panic("Expected compile to fail with expired context (languageID: promql)")
 	}
 
-	// Necessary since compile() will call d.compilers.Done()
-	d.doc.compilers.Add(1)
+	// Necessary since compile() will call d.snap.compilers.Done()
+	d.snap.compilers.Add(1)
 
 	d.doc.languageID = "yaml"
 
@@ -57,15 +56,15 @@ func TestDocumentContext(t *testing.T) { //nolint: funlen
 		panic("Expecexpiredted compile to fail with expired context (languageID: promql)")
 	}
 
-	// Necessary since compileQuery() will call d.compilers.Done()
-	d.doc.compilers.Add(1)
+	// Necessary since compileQuery() will call d.snap.compilers.Done()
+	d.snap.compilers.Add(1)
 
 	if err := d.compileQuery(true, token.NoPos, token.NoPos, ""); err == nil {
 		panic("Expected compileQuery to fail with expired context (fullFile: true)")
 	}
 
-	// Necessary since compileQuery() will call d.compilers.Done()
-	d.doc.compilers.Add(1)
+	// Necessary since compileQuery() will call d.snap.compilers.Done()
+	d.snap.compilers.Add(1)
 
 	if err := d.compileQuery(false, token.NoPos, token.NoPos, ""); err == nil {
 		panic("Expected compileQuery to fail with expired context (fullFile: false)")
@@ -159,8 +158,8 @@ func TestDocumentContext(t *testing.T) { //nolint: funlen
 		panic("Expected addYaml to fail with expired context")
 	}
 
-	// Necessary since scanYamlTree will call d.compilers.Done()
-	d.doc.compilers.Add(1)
+	// Necessary since scanYamlTree will call d.snap.compilers.Done()
+	d.snap.compilers.Add(1)
 
 	if err := d.scanYamlTree(); err == nil {
 		panic("Expected scanYamlTree to fail with expired context")
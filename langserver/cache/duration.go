@@ -0,0 +1,98 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DurationUnit is a unit that can be used in Prometheus durations
+type DurationUnit struct {
+	Name        string
+	Duration    time.Duration
+	Description string
+}
+
+// DurationUnits lists the units of Prometheus durations. Compound
+// durations have to use them in this order, e.g. 1h30m.
+var DurationUnits = []DurationUnit{ // nolint: gochecknoglobals
+	{"y", 365 * 24 * time.Hour, "years, 365 days each"},
+	{"w", 7 * 24 * time.Hour, "weeks"},
+	{"d", 24 * time.Hour, "days"},
+	{"h", time.Hour, "hours"},
+	{"m", time.Minute, "minutes"},
+	{"s", time.Second, "seconds"},
+	{"ms", time.Millisecond, "milliseconds"},
+}
+
+// ParseDuration parses a Prometheus duration, which may be compound, e.g. 1h30m.
+// The returned errors explain what is wrong with the duration.
+func ParseDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, fmt.Errorf("empty duration string")
+	}
+
+	if s == "0" {
+		return 0, nil
+	}
+
+	var ret time.Duration
+
+	lastUnit := -1
+
+	for rest := s; rest != ""; {
+		i := 0
+		for i < len(rest) && '0' <= rest[i] && rest[i] <= '9' {
+			i++
+		}
+
+		if i == 0 {
+			return 0, fmt.Errorf("not a valid duration string: %q, expected a number at %q", s, rest)
+		}
+
+		n, err := strconv.ParseInt(rest[:i], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("not a valid duration string: %q, number %s is too large", s, rest[:i])
+		}
+
+		rest = rest[i:]
+
+		unit := -1
+
+		for j, u := range DurationUnits {
+			if strings.HasPrefix(rest, u.Name) && (unit < 0 || len(u.Name) > len(DurationUnits[unit].Name)) {
+				unit = j
+			}
+		}
+
+		switch {
+		case unit < 0 && rest == "":
+			return 0, fmt.Errorf("not a valid duration string: %q, missing unit after %d", s, n)
+		case unit < 0:
+			return 0, fmt.Errorf("not a valid duration string: %q, unknown unit at %q", s, rest)
+		case unit <= lastUnit:
+			return 0, fmt.Errorf("not a valid duration string: %q, units have to be given in decreasing order and only once, but %q comes after %q",
+				s, DurationUnits[unit].Name, DurationUnits[lastUnit].Name)
+		}
+
+		lastUnit = unit
+		rest = rest[len(DurationUnits[unit].Name):]
+		ret += time.Duration(n) * DurationUnits[unit].Duration
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,53 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestParseDuration(*testing.T) {
+	tests := []struct {
+		input    string
+		duration time.Duration
+		valid    bool
+	}{
+		{input: "0", duration: 0, valid: true},
+		{input: "5m", duration: 5 * time.Minute, valid: true},
+		{input: "1h30m", duration: 90 * time.Minute, valid: true},
+		{input: "1d2h3m4s5ms", duration: 26*time.Hour + 3*time.Minute + 4*time.Second + 5*time.Millisecond, valid: true},
+		{input: "1w", duration: 7 * 24 * time.Hour, valid: true},
+		{input: "", valid: false},
+		{input: "5", valid: false},
+		{input: "30m1h", valid: false},
+		{input: "1m1m", valid: false},
+		{input: "1.5h", valid: false},
+		{input: "5min", valid: false},
+		{input: "h", valid: false},
+	}
+
+	for _, test := range tests {
+		duration, err := ParseDuration(test.input)
+
+		if (err == nil) != test.valid {
+			panic(fmt.Sprintf("Expected %q to be valid: %t, got error %v", test.input, test.valid, err))
+		}
+
+		if duration != test.duration {
+			panic(fmt.Sprintf("Expected %q to be parsed as %s, got %s", test.input, test.duration, duration))
+		}
+	}
+}
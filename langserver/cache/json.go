@@ -0,0 +1,241 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/token"
+	"strings"
+)
+
+// JSONKind is the type of a JSON value
+type JSONKind int
+
+// The possible kinds of JSON values
+const (
+	JSONObject JSONKind = iota
+	JSONArray
+	JSONString
+	// JSONLiteral is used for numbers, booleans and null
+	JSONLiteral
+)
+
+// JSONNode is a value of a JSON document together with its position in the document.
+// The standard library decoder does not provide positions.
+type JSONNode struct {
+	Kind JSONKind
+
+	// Pos and End span the raw source of the value, including quotes and brackets
+	Pos token.Pos
+	End token.Pos
+
+	// Keys holds the keys of an object, Values the corresponding values.
+	// For arrays, Values holds the elements.
+	Keys   []*JSONNode
+	Values []*JSONNode
+
+	// Value is the decoded value of a string or the raw source of a literal
+	Value string
+}
+
+// Get returns the value for a key of a JSON object, or nil if there is none
+func (n *JSONNode) Get(key string) *JSONNode {
+	if n == nil || n.Kind != JSONObject {
+		return nil
+	}
+
+	for i, k := range n.Keys {
+		if k.Value == key {
+			return n.Values[i]
+		}
+	}
+
+	return nil
+}
+
+// ParseJSON parses the content of a document as JSON
+func (d *DocumentHandle) ParseJSON() (*JSONNode, error) {
+	content, err := d.GetContent()
+	if err != nil {
+		return nil, err
+	}
+
+	p := &jsonParser{
+		input: content,
+		base:  token.Pos(d.snap.posData.Base()),
+	}
+
+	node, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipSpace()
+
+	if p.pos < len(p.input) {
+		return nil, p.errorf("unexpected content after the end of the document")
+	}
+
+	return node, nil
+}
+
+type jsonParser struct {
+	input string
+	pos   int
+	base  token.Pos
+}
+
+func (p *jsonParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("invalid JSON at offset %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *jsonParser) skipSpace() {
+	for p.pos < len(p.input) && strings.IndexByte(" \t\r\n", p.input[p.pos]) >= 0 {
+		p.pos++
+	}
+}
+
+func (p *jsonParser) parseValue() (*JSONNode, error) {
+	p.skipSpace()
+
+	if p.pos >= len(p.input) {
+		return nil, p.errorf("unexpected end of input")
+	}
+
+	switch p.input[p.pos] {
+	case '{':
+		return p.parseContainer(JSONObject, '}')
+	case '[':
+		return p.parseContainer(JSONArray, ']')
+	case '"':
+		return p.parseString()
+	default:
+		return p.parseLiteral()
+	}
+}
+
+// parseContainer parses objects and arrays
+func (p *jsonParser) parseContainer(kind JSONKind, closing byte) (*JSONNode, error) {
+	node := &JSONNode{Kind: kind, Pos: p.base + token.Pos(p.pos)}
+
+	// Skip the opening bracket
+	p.pos++
+
+	for first := true; ; first = false {
+		p.skipSpace()
+
+		if p.pos >= len(p.input) {
+			return nil, p.errorf("unexpected end of input")
+		}
+
+		if p.input[p.pos] == closing {
+			p.pos++
+			node.End = p.base + token.Pos(p.pos)
+
+			return node, nil
+		}
+
+		if !first {
+			if p.input[p.pos] != ',' {
+				return nil, p.errorf("expected ',' or %q", closing)
+			}
+
+			p.pos++
+			p.skipSpace()
+		}
+
+		if kind == JSONObject {
+			if p.pos >= len(p.input) || p.input[p.pos] != '"' {
+				return nil, p.errorf("expected object key")
+			}
+
+			key, err := p.parseString()
+			if err != nil {
+				return nil, err
+			}
+
+			p.skipSpace()
+
+			if p.pos >= len(p.input) || p.input[p.pos] != ':' {
+				return nil, p.errorf("expected ':'")
+			}
+
+			p.pos++
+
+			node.Keys = append(node.Keys, key)
+		}
+
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+
+		node.Values = append(node.Values, value)
+	}
+}
+
+func (p *jsonParser) parseString() (*JSONNode, error) {
+	start := p.pos
+
+	// Skip the opening quote
+	p.pos++
+
+	for p.pos < len(p.input) {
+		switch p.input[p.pos] {
+		case '\\':
+			p.pos += 2
+		case '"':
+			p.pos++
+
+			var value string
+			if err := json.Unmarshal([]byte(p.input[start:p.pos]), &value); err != nil {
+				return nil, p.errorf("invalid string: %s", err.Error())
+			}
+
+			return &JSONNode{
+				Kind:  JSONString,
+				Pos:   p.base + token.Pos(start),
+				End:   p.base + token.Pos(p.pos),
+				Value: value,
+			}, nil
+		default:
+			p.pos++
+		}
+	}
+
+	return nil, p.errorf("unterminated string")
+}
+
+func (p *jsonParser) parseLiteral() (*JSONNode, error) {
+	start := p.pos
+
+	for p.pos < len(p.input) && strings.IndexByte(" \t\r\n,]}", p.input[p.pos]) < 0 {
+		p.pos++
+	}
+
+	raw := p.input[start:p.pos]
+
+	var value interface{}
+	if err := json.Unmarshal([]byte(raw), &value); err != nil || raw == "" {
+		return nil, p.errorf("invalid value %q", raw)
+	}
+
+	return &JSONNode{
+		Kind:  JSONLiteral,
+		Pos:   p.base + token.Pos(start),
+		End:   p.base + token.Pos(p.pos),
+		Value: raw,
+	}, nil
+}
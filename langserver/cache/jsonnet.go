@@ -0,0 +1,273 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"go/token"
+	"regexp"
+	"strings"
+)
+
+// The kinds of tokens of a Jsonnet document that matter for finding queries
+const (
+	jsonnetIdentifier = iota
+	jsonnetString
+	jsonnetTextBlock
+	jsonnetOther
+)
+
+// jsonnetToken is a token of a Jsonnet document. For strings, Start and End span their content
+// without the quotes, for text blocks the lines between the ||| markers.
+type jsonnetToken struct {
+	Kind  int
+	Start int
+	End   int
+	Value string
+}
+
+// lexJsonnet splits a Jsonnet document into identifiers, strings and single characters, skipping comments
+// nolint: funlen
+func lexJsonnet(src string) []jsonnetToken {
+	var ret []jsonnetToken
+
+	for i := 0; i < len(src); {
+		c := src[i]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case c == '#' || strings.HasPrefix(src[i:], "//"):
+			if end := strings.IndexByte(src[i:], '\n'); end >= 0 {
+				i += end + 1
+			} else {
+				i = len(src)
+			}
+		case strings.HasPrefix(src[i:], "/*"):
+			if end := strings.Index(src[i+2:], "*/"); end >= 0 {
+				i += end + 4
+			} else {
+				i = len(src)
+			}
+		case strings.HasPrefix(src[i:], "|||"):
+			start := len(src)
+			if nl := strings.IndexByte(src[i:], '\n'); nl >= 0 {
+				start = i + nl + 1
+			}
+
+			end, next := len(src), len(src)
+
+			for lineStart := start; lineStart < len(src); {
+				lineEnd := len(src)
+				if nl := strings.IndexByte(src[lineStart:], '\n'); nl >= 0 {
+					lineEnd = lineStart + nl + 1
+				}
+
+				if trimmed := strings.TrimLeft(src[lineStart:lineEnd], " \t"); strings.HasPrefix(trimmed, "|||") {
+					end, next = lineStart, lineEnd-len(trimmed)+3
+
+					break
+				}
+
+				lineStart = lineEnd
+			}
+
+			ret = append(ret, jsonnetToken{Kind: jsonnetTextBlock, Start: start, End: end})
+			i = next
+		case c == '@' && i+1 < len(src) && (src[i+1] == '"' || src[i+1] == '\''):
+			// Verbatim strings escape quotes by doubling them
+			quote := src[i+1]
+			end := i + 2
+
+			for end < len(src) {
+				if src[end] == quote {
+					if end+1 < len(src) && src[end+1] == quote {
+						end += 2
+						continue
+					}
+
+					break
+				}
+
+				end++
+			}
+
+			ret = append(ret, jsonnetToken{Kind: jsonnetOther, Start: i, End: end})
+			i = end + 1
+		case c == '"' || c == '\'':
+			end := i + 1
+
+			for end < len(src) && src[end] != c {
+				if src[end] == '\\' {
+					end++
+				}
+				end++
+			}
+
+			if end > len(src) {
+				end = len(src)
+			}
+
+			ret = append(ret, jsonnetToken{Kind: jsonnetString, Start: i + 1, End: end, Value: src[i+1 : end]})
+			i = end + 1
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			end := i + 1
+
+			for end < len(src) && (src[end] == '_' || src[end] >= 'a' && src[end] <= 'z' ||
+				src[end] >= 'A' && src[end] <= 'Z' || src[end] >= '0' && src[end] <= '9') {
+				end++
+			}
+
+			ret = append(ret, jsonnetToken{Kind: jsonnetIdentifier, Start: i, End: end, Value: src[i:end]})
+			i = end
+		default:
+			ret = append(ret, jsonnetToken{Kind: jsonnetOther, Start: i, End: i + 1, Value: src[i : i+1]})
+			i++
+		}
+	}
+
+	return ret
+}
+
+// jsonnetQuery is the value of an expr field of a Jsonnet document
+type jsonnetQuery struct {
+	jsonnetToken
+	// Formatted is set if the string is the left operand of the % operator, i.e. a format string
+	Formatted bool
+}
+
+// jsonnetQueries returns the string literals that are assigned to fields named expr
+func jsonnetQueries(src string) []jsonnetQuery {
+	tokens := lexJsonnet(src)
+
+	var ret []jsonnetQuery
+
+	for i, t := range tokens {
+		isKey := (t.Kind == jsonnetIdentifier || t.Kind == jsonnetString) && t.Value == "expr"
+		// References like self.expr aren't fields
+		if !isKey || i > 0 && tokens[i-1].Value == "." {
+			continue
+		}
+
+		// Field operators are :, :: and ::: with an optional +
+		j := i + 1
+		colon := false
+
+		for j < len(tokens) && tokens[j].Kind == jsonnetOther && (tokens[j].Value == ":" || tokens[j].Value == "+") {
+			colon = colon || tokens[j].Value == ":"
+			j++
+		}
+
+		if !colon || j >= len(tokens) || (tokens[j].Kind != jsonnetString && tokens[j].Kind != jsonnetTextBlock) {
+			continue
+		}
+
+		q := jsonnetQuery{jsonnetToken: tokens[j]}
+
+		if j+1 < len(tokens) {
+			switch tokens[j+1].Value {
+			case "+":
+				// Concatenated strings aren't complete queries
+				continue
+			case "%":
+				q.Formatted = true
+			}
+		}
+
+		ret = append(ret, q)
+	}
+
+	return ret
+}
+
+// scanJsonnet compiles the queries of the expr fields of a Jsonnet document, e.g. of a monitoring mixin
+func (d *DocumentHandle) scanJsonnet() error {
+	content, err := d.GetContent()
+	if err != nil {
+		return err
+	}
+
+	base := token.Pos(d.snap.posData.Base())
+
+	for _, q := range jsonnetQueries(content) {
+		query := content[q.Start:q.End]
+		if strings.TrimSpace(query) == "" {
+			continue
+		}
+
+		// Text blocks contain no escape sequences
+		inString := q.Kind == jsonnetString
+		if inString {
+			query = maskJSONEscapes(query)
+		}
+
+		if q.Formatted {
+			query = maskFormatSpecifiers(query)
+		}
+
+		d.snap.compilers.Add(1)
+
+		if err := d.compileMaskedQuery(base+token.Pos(q.Start), query, inString); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// compileMaskedQuery compiles a query whose content has already been masked
+func (d *DocumentHandle) compileMaskedQuery(pos token.Pos, masked string, inString bool) error {
+	defer d.snap.compilers.Done()
+
+	return d.compileContent(pos, masked, inString, "")
+}
+
+// formatSpecifierRegexp matches the format specifiers of Jsonnet format strings, e.g. %(selector)s or %d
+var formatSpecifierRegexp = regexp.MustCompile(`%(\([^)]*\))?[-#0 +]*[0-9*]*(\.[0-9*]+)?[a-zA-Z%]`)
+
+// maskFormatSpecifiers replaces the format specifiers of a Jsonnet format string outside of strings by
+// values of the same length that the parser accepts: label matchers inside of braces, durations inside
+// of brackets, names in label lists, where they are part of a name or followed by a selector and
+// numbers elsewhere.
+func maskFormatSpecifiers(query string) string {
+	return maskPlaceholders(query, func(state *maskState, pos int) (int, string) {
+		if query[pos] != '%' {
+			return pos, ""
+		}
+
+		loc := formatSpecifierRegexp.FindStringIndex(query[pos:])
+		if loc == nil || loc[0] != 0 {
+			return pos, ""
+		}
+
+		end := pos + loc[1]
+		n := end - pos
+
+		switch {
+		case query[pos:end] == "%%":
+			// An escaped percent sign, i.e. the modulo operator
+			return end, " %"
+		case state.braces > 0 && n >= 5:
+			return end, strings.Repeat("_", n-4) + `!=""`
+		case state.braces > 0:
+			return end, strings.Repeat(" ", n)
+		case state.brackets > 0:
+			return end, maskValue(state, n)
+		case state.inLabelList(),
+			pos > 0 && isNameChar(rune(query[pos-1])) || end < len(query) && (isNameChar(rune(query[end])) || query[end] == '{'):
+			return end, strings.Repeat("_", n)
+		default:
+			return end, maskValue(state, n)
+		}
+	})
+}
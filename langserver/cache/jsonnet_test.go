@@ -0,0 +1,105 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+func TestJsonnet(t *testing.T) { // nolint:funlen
+	mixin := `{
+  // expr: 'not a field'
+  prometheusAlerts+:: {
+    groups+: [{
+      name: 'example',
+      rules: [
+        {
+          alert: 'ErrorsHigh',
+          expr: |||
+            sum by (%(clusterLabel)s, job) (rate(errors_total{%(selector)s}[%(interval)s])) > %(threshold)s
+          ||| % $._config,
+        },
+        {
+          record: 'job:up:sum',
+          expr: 'sum by (job) (up{job=\'prometheus\'})',
+        },
+        {
+          record: 'job:requests:rate5m',
+          "expr": "sum by (job) (rate(requests_total{code=~\"5..\"}[5m])",
+        },
+        {
+          record: 'partial',
+          expr: 'sum(' + self.inner + ')',
+        },
+      ],
+    }],
+  },
+}
+`
+
+	c := &DocumentCache{}
+
+	c.Init()
+
+	doc, err := c.AddDocument(
+		context.Background(),
+		&protocol.TextDocumentItem{
+			URI:        "mixin.libsonnet",
+			LanguageID: "jsonnet",
+			Version:    0,
+			Text:       mixin,
+		})
+	if err != nil {
+		panic("Failed to AddDocument() to cache")
+	}
+
+	queries, err := doc.GetQueries()
+	if err != nil {
+		panic("Failed to get queries")
+	}
+
+	if len(queries) != 3 {
+		panic(fmt.Sprintf("Expected 3 queries, got %d", len(queries)))
+	}
+
+	for i, q := range queries[:2] {
+		if q.Ast == nil || len(q.Err) != 0 {
+			panic(fmt.Sprintf("Expected query %d (%q) to be valid, got %v", i, q.Content, q.Err))
+		}
+	}
+
+	if !queries[1].InJSONString || queries[0].InJSONString {
+		panic("Expected only quoted queries to be marked as string literals")
+	}
+
+	diagnostics, err := doc.GetDiagnostics()
+	if err != nil {
+		panic("Failed to get diagnostics")
+	}
+
+	for _, d := range diagnostics {
+		// The unclosed parenthesis of the third query
+		if d.Range.Start.Line != 18 {
+			panic(fmt.Sprintf("Unexpected diagnostic %v", d))
+		}
+	}
+
+	if len(diagnostics) == 0 {
+		panic("Expected a diagnostic for the third query")
+	}
+}
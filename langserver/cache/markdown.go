@@ -0,0 +1,118 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"go/token"
+	"strings"
+)
+
+// codeFence is a fenced code block in a Markdown document
+type codeFence struct {
+	// Info is the first word of the info string, i.e. the language of the block
+	Info string
+	// Start and End are the offsets of the content of the block, excluding the fences
+	Start int
+	End   int
+}
+
+// markdownFences returns the fenced code blocks of a Markdown document. A block without
+// a closing fence extends to the end of the document.
+func markdownFences(content string) []codeFence {
+	var (
+		ret     []codeFence
+		open    *codeFence
+		marker  string
+		lineEnd int
+	)
+
+	for lineStart := 0; lineStart < len(content); lineStart = lineEnd {
+		lineEnd = len(content)
+		if i := strings.IndexByte(content[lineStart:], '\n'); i >= 0 {
+			lineEnd = lineStart + i + 1
+		}
+
+		line := strings.TrimSpace(content[lineStart:lineEnd])
+
+		if open != nil {
+			// The closing fence uses the same character and is at least as long as the opening one
+			if strings.HasPrefix(line, marker) && strings.Trim(line, marker[:1]) == "" {
+				open.End = lineStart
+				ret = append(ret, *open)
+				open = nil
+			}
+
+			continue
+		}
+
+		if length := fenceLength(line); length > 0 {
+			marker = line[:length]
+
+			info := strings.Fields(line[length:])
+
+			open = &codeFence{Start: lineEnd}
+			if len(info) > 0 {
+				open.Info = info[0]
+			}
+		}
+	}
+
+	if open != nil {
+		open.End = len(content)
+		ret = append(ret, *open)
+	}
+
+	return ret
+}
+
+// fenceLength returns the length of the fence a line starts with, or 0 if it doesn't start a fenced code block
+func fenceLength(line string) int {
+	if !strings.HasPrefix(line, "```") && !strings.HasPrefix(line, "~~~") {
+		return 0
+	}
+
+	length := len(line) - len(strings.TrimLeft(line, line[:1]))
+
+	// Backtick fences can't be followed by backticks, which would make them inline code
+	if line[0] == '`' && strings.Contains(line[length:], "`") {
+		return 0
+	}
+
+	return length
+}
+
+// scanMarkdown compiles the contents of the code blocks of a Markdown document
+// that are annotated as promql
+func (d *DocumentHandle) scanMarkdown() error {
+	content, err := d.GetContent()
+	if err != nil {
+		return err
+	}
+
+	base := token.Pos(d.snap.posData.Base())
+
+	for _, fence := range markdownFences(content) {
+		if !strings.EqualFold(fence.Info, "promql") || strings.TrimSpace(content[fence.Start:fence.End]) == "" {
+			continue
+		}
+
+		d.snap.compilers.Add(1)
+
+		if err := d.compileQuery(false, base+token.Pos(fence.Start), base+token.Pos(fence.End), ""); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,97 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+func TestMarkdown(t *testing.T) { // nolint:funlen
+	tests := []struct {
+		input       string
+		queries     []string
+		diagnostics int
+	}{
+		{
+			input:   "# Runbook\n\n```promql\nrate(errors_total[5m])\n```\n\nSome text.\n",
+			queries: []string{"rate(errors_total[5m])\n"},
+		}, {
+			// Other languages and unannotated blocks are ignored
+			input:   "```yaml\nfoo: bar\n```\n```\nfoo(\n```\n~~~ PromQL\nup\n~~~\n",
+			queries: []string{"up\n"},
+		}, {
+			// Indented blocks are part of lists, shorter fences don't close longer ones
+			input:   "* item\n\n  ````promql\n  sum(up)\n  ```\n  ````\n\n```promql\n```\n",
+			queries: []string{"  sum(up)\n  ```\n"},
+			// The inner fence isn't PromQL
+			diagnostics: 2,
+		}, {
+			input:   "```promql\nrate(foo[5m]\n",
+			queries: []string{"rate(foo[5m]\n"},
+			// The parser reports the unclosed parenthesis twice
+			diagnostics: 2,
+		},
+	}
+
+	for i, test := range tests {
+		c := &DocumentCache{}
+
+		c.Init()
+
+		doc, err := c.AddDocument(
+			context.Background(),
+			&protocol.TextDocumentItem{
+				URI:        fmt.Sprintf("test_file_%d.md", i),
+				LanguageID: "markdown",
+				Version:    0,
+				Text:       test.input,
+			})
+		if err != nil {
+			panic("Failed to AddDocument() to cache")
+		}
+
+		queries, err := doc.GetQueries()
+		if err != nil {
+			panic("Failed to get queries")
+		}
+
+		var contents []string
+
+		for _, q := range queries {
+			contents = append(contents, q.Content)
+
+			if !strings.HasPrefix(test.input[doc.ByteOffset(q.Pos):], q.Content) {
+				panic(fmt.Sprintf("Query %q has the wrong position in %q", q.Content, test.input))
+			}
+		}
+
+		if fmt.Sprint(contents) != fmt.Sprint(test.queries) {
+			panic(fmt.Sprintf("Expected the queries %q in %q, got %q", test.queries, test.input, contents))
+		}
+
+		diagnostics, err := doc.GetDiagnostics()
+		if err != nil {
+			panic("Failed to get diagnostics")
+		}
+
+		if len(diagnostics) != test.diagnostics {
+			panic(fmt.Sprintf("Expected %d diagnostics for %q, got %v", test.diagnostics, test.input, diagnostics))
+		}
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"strings"
+)
+
+// labelListKeywords are the keywords that are followed by a list of label names in parentheses
+// nolint: gochecknoglobals
+var labelListKeywords = map[string]bool{
+	"by": true, "without": true, "on": true, "ignoring": true, "group_left": true, "group_right": true,
+}
+
+// maskState is where in a query maskPlaceholders found a placeholder
+type maskState struct {
+	braces   int
+	brackets int
+	// labelLists records for every open parenthesis whether it contains label names
+	labelLists []bool
+}
+
+// inLabelList checks whether the innermost parenthesis contains label names, e.g. after by or on
+func (s *maskState) inLabelList() bool {
+	return len(s.labelLists) > 0 && s.labelLists[len(s.labelLists)-1]
+}
+
+// maskPlaceholders replaces the placeholders of a templating language outside of strings by values of the same
+// length that the parser accepts. Placeholders inside of strings are valid PromQL and are kept.
+// placeholder is called at every position outside of strings and returns the end of the placeholder
+// starting there and its replacement, or an end not after pos if there is none.
+func maskPlaceholders(query string, placeholder func(state *maskState, pos int) (end int, replacement string)) string {
+	ret := []byte(query)
+
+	var quote byte
+
+	state := &maskState{}
+
+	for i := 0; i < len(ret); i++ {
+		c := ret[i]
+
+		if quote != 0 {
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+
+			continue
+		}
+
+		if end, replacement := placeholder(state, i); end > i {
+			copy(ret[i:end], replacement)
+			i = end - 1
+
+			continue
+		}
+
+		switch {
+		case c == '"' || c == '\'' || c == '`':
+			quote = c
+		case c == '{':
+			state.braces++
+		case c == '}' && state.braces > 0:
+			state.braces--
+		case c == '[':
+			state.brackets++
+		case c == ']' && state.brackets > 0:
+			state.brackets--
+		case c == '(':
+			word := strings.TrimRight(query[:i], " \t\r\n")
+			start := len(word)
+
+			for start > 0 && isNameChar(rune(word[start-1])) {
+				start--
+			}
+
+			state.labelLists = append(state.labelLists, labelListKeywords[strings.ToLower(word[start:])])
+		case c == ')' && len(state.labelLists) > 0:
+			state.labelLists = state.labelLists[:len(state.labelLists)-1]
+		}
+	}
+
+	return string(ret)
+}
+
+// maskValue returns a value of length n that the parser accepts where a placeholder is found outside
+// of label lists and selectors: a duration of one minute padded with leading zeros inside of brackets
+// and a number elsewhere
+func maskValue(state *maskState, n int) string {
+	if state.brackets > 0 {
+		return strings.Repeat("0", n-2) + "1m"
+	}
+
+	return strings.Repeat("1", n)
+}
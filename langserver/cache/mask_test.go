@@ -0,0 +1,41 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestMaskPlaceholders(*testing.T) {
+	tests := []struct {
+		mask     func(string) string
+		input    string
+		expected string
+	}{
+		{maskTemplateActions, `rate(foo{instance="{{ .i }}"}[{{ .r }}])`, `rate(foo{instance="{{ .i }}"}[0000001m])`},
+		{maskTemplateActions, `foo > {{ .t }}`, `foo > 11111111`},
+		{maskTemplateActions, `foo > {{ .t`, `foo > {{ .t`},
+		{maskGrafanaVariables, `rate(foo{a="$a"}[$__rate_interval]) > $t`, `rate(foo{a="$a"}[000000000000001m]) > 11`},
+		{maskGrafanaVariables, `rate(foo[${r:raw}])`, `rate(foo[0000001m])`},
+		{maskFormatSpecifiers, `sum by (%s) (rate(foo{%(sel)s}[%s])) > %d`, `sum by (__) (rate(foo{___!=""}[1m])) > 11`},
+		{maskFormatSpecifiers, `foo %% 2 + bar_%s{a="%s"}`, `foo  % 2 + bar___{a="%s"}`},
+	}
+
+	for _, test := range tests {
+		if masked := test.mask(test.input); masked != test.expected {
+			panic(fmt.Sprintf("Expected %q to be masked as %q, got %q", test.input, test.expected, masked))
+		}
+	}
+}
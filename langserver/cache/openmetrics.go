@@ -0,0 +1,376 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"fmt"
+	"go/token"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/prometheus/promql"
+)
+
+// MetricFamily contains the metadata of a metric family found in
+// a Prometheus text format or OpenMetrics exposition file
+type MetricFamily struct {
+	Name string
+	Help string
+	Type string
+	Unit string
+	// The distinct sample names that belong to this family, e.g. foo_bucket, foo_sum
+	// and foo_count for a histogram foo
+	SampleNames []string
+	// The union of all label names used by the samples of this family
+	LabelNames []string
+}
+
+// nolint: gochecknoglobals
+var openMetricsTypes = map[string]bool{
+	"counter":        true,
+	"gauge":          true,
+	"histogram":      true,
+	"gaugehistogram": true,
+	"summary":        true,
+	"info":           true,
+	"stateset":       true,
+	"unknown":        true,
+	"untyped":        true,
+}
+
+// nolint: gochecknoglobals
+var openMetricsSuffixes = []string{"_total", "_bucket", "_count", "_sum", "_created", "_info", "_gcount", "_gsum"}
+
+// isExpositionFile checks whether a document should be treated as
+// Prometheus text format or OpenMetrics exposition file
+func (d *DocumentHandle) isExpositionFile() bool {
+	switch d.GetLanguageID() {
+	case "openmetrics", "prometheus-exposition":
+		return true
+	default:
+		return strings.HasSuffix(URIPath(d.GetURI()), ".prom")
+	}
+}
+
+// openMetricsParser holds the state while parsing an exposition file
+type openMetricsParser struct {
+	d *DocumentHandle
+
+	families map[string]*MetricFamily
+	order    []string
+
+	helpSeen map[string]bool
+	typeSeen map[string]bool
+
+	// label names of the first sample seen for each sample name
+	sampleLabels map[string][]string
+	series       map[string]bool
+
+	eofSeen bool
+}
+
+// nolint: funlen
+func (d *DocumentHandle) parseOpenMetrics() error {
+	content, err := d.GetContent()
+	if err != nil {
+		return err
+	}
+
+	p := &openMetricsParser{
+		d:            d,
+		families:     make(map[string]*MetricFamily),
+		helpSeen:     make(map[string]bool),
+		typeSeen:     make(map[string]bool),
+		sampleLabels: make(map[string][]string),
+		series:       make(map[string]bool),
+	}
+
+	base := token.Pos(d.snap.posData.Base())
+	offset := 0
+
+	for _, line := range strings.Split(content, "\n") {
+		start := base + token.Pos(offset)
+		end := start + token.Pos(len(line))
+
+		offset += len(line) + 1
+
+		line = strings.TrimSuffix(line, "\r")
+
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		if p.eofSeen {
+			if err := d.addDiagnosticForRange(start, end, 1, "content after # EOF"); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if strings.HasPrefix(line, "#") {
+			err = p.parseComment(line, start, end)
+		} else {
+			err = p.parseSample(line, start, end)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	ret := make([]*MetricFamily, 0, len(p.order))
+
+	for _, name := range p.order {
+		family := p.families[name]
+
+		sort.Strings(family.LabelNames)
+
+		ret = append(ret, family)
+	}
+
+	return d.setMetricFamilies(ret)
+}
+
+func (p *openMetricsParser) getFamily(name string) *MetricFamily {
+	family, ok := p.families[name]
+	if !ok {
+		family = &MetricFamily{Name: name}
+		p.families[name] = family
+		p.order = append(p.order, name)
+	}
+
+	return family
+}
+
+// familyName returns the name of the family a sample belongs to
+func (p *openMetricsParser) familyName(sampleName string) string {
+	if _, ok := p.families[sampleName]; ok {
+		return sampleName
+	}
+
+	for _, suffix := range openMetricsSuffixes {
+		if trimmed := strings.TrimSuffix(sampleName, suffix); trimmed != sampleName {
+			if _, ok := p.families[trimmed]; ok {
+				return trimmed
+			}
+		}
+	}
+
+	return sampleName
+}
+
+// nolint: funlen
+func (p *openMetricsParser) parseComment(line string, start, end token.Pos) error {
+	fields := strings.Fields(line)
+
+	if len(fields) == 2 && fields[1] == "EOF" {
+		p.eofSeen = true
+		return nil
+	}
+
+	if len(fields) < 2 {
+		return nil
+	}
+
+	keyword := fields[1]
+
+	switch keyword {
+	case "HELP", "TYPE", "UNIT":
+	default:
+		// Ordinary comment
+		return nil
+	}
+
+	if len(fields) < 3 {
+		return p.d.addDiagnosticForRange(start, end, 1, fmt.Sprintf("# %s without metric name", keyword))
+	}
+
+	name := fields[2]
+	family := p.getFamily(name)
+
+	switch keyword {
+	case "HELP":
+		if p.helpSeen[name] {
+			return p.d.addDiagnosticForRange(start, end, 1, fmt.Sprintf("duplicate HELP for metric %s", name))
+		}
+
+		p.helpSeen[name] = true
+
+		idx := strings.Index(line, name) + len(name)
+		family.Help = strings.TrimSpace(line[idx:])
+	case "TYPE":
+		if p.typeSeen[name] {
+			return p.d.addDiagnosticForRange(start, end, 1, fmt.Sprintf("duplicate TYPE for metric %s", name))
+		}
+
+		p.typeSeen[name] = true
+
+		if len(fields) != 4 || !openMetricsTypes[fields[3]] {
+			return p.d.addDiagnosticForRange(start, end, 1, fmt.Sprintf("invalid TYPE for metric %s", name))
+		}
+
+		if len(family.SampleNames) > 0 {
+			return p.d.addDiagnosticForRange(start, end, 1, fmt.Sprintf("TYPE for metric %s must precede its samples", name))
+		}
+
+		family.Type = fields[3]
+	case "UNIT":
+		if len(fields) == 4 {
+			family.Unit = fields[3]
+		}
+	}
+
+	return nil
+}
+
+// nolint: funlen
+func (p *openMetricsParser) parseSample(line string, start, end token.Pos) error {
+	metricEnd := strings.IndexAny(line, " \t")
+	if brace := strings.Index(line, "{"); brace >= 0 && (metricEnd < 0 || brace < metricEnd) {
+		if metricEnd = closingBrace(line, brace); metricEnd < 0 {
+			return p.d.addDiagnosticForRange(start, end, 1, "unclosed label set")
+		}
+	}
+
+	if metricEnd <= 0 {
+		return p.d.addDiagnosticForRange(start, end, 1, "sample without value")
+	}
+
+	metricEnd++
+
+	if metricEnd > len(line) {
+		metricEnd = len(line)
+	}
+
+	lbls, err := promql.ParseMetric(strings.TrimSpace(line[:metricEnd]))
+	if err != nil {
+		return p.d.addDiagnosticForRange(start, start+token.Pos(metricEnd), 1, fmt.Sprintf("invalid metric: %s", err.Error()))
+	}
+
+	rest := line[metricEnd:]
+
+	// Strip exemplars
+	if idx := strings.Index(rest, "#"); idx >= 0 {
+		rest = rest[:idx]
+	}
+
+	values := strings.Fields(rest)
+
+	if len(values) < 1 || len(values) > 2 {
+		return p.d.addDiagnosticForRange(start+token.Pos(metricEnd), end, 1, "expected a sample value and an optional timestamp")
+	}
+
+	for _, v := range values {
+		if _, err := strconv.ParseFloat(v, 64); err != nil {
+			return p.d.addDiagnosticForRange(start+token.Pos(metricEnd), end, 1, fmt.Sprintf("invalid number: %s", v))
+		}
+	}
+
+	sampleName := lbls.Get("__name__")
+	family := p.getFamily(p.familyName(sampleName))
+
+	if !containsString(family.SampleNames, sampleName) {
+		family.SampleNames = append(family.SampleNames, sampleName)
+	}
+
+	var labelNames []string
+
+	for _, l := range lbls {
+		if l.Name == "__name__" {
+			continue
+		}
+
+		if !containsString(family.LabelNames, l.Name) {
+			family.LabelNames = append(family.LabelNames, l.Name)
+		}
+
+		if l.Name != "le" && l.Name != "quantile" {
+			labelNames = append(labelNames, l.Name)
+		}
+	}
+
+	if p.series[lbls.String()] && len(values) == 1 {
+		return p.d.addDiagnosticForRange(start, end, 2, fmt.Sprintf("duplicate series %s", lbls.String()))
+	}
+
+	p.series[lbls.String()] = true
+
+	if expected, ok := p.sampleLabels[sampleName]; !ok {
+		p.sampleLabels[sampleName] = labelNames
+	} else if strings.Join(expected, ",") != strings.Join(labelNames, ",") {
+		return p.d.addDiagnosticForRange(start, end, 2,
+			fmt.Sprintf("inconsistent label set for %s: expected labels [%s], got [%s]",
+				sampleName, strings.Join(expected, ", "), strings.Join(labelNames, ", ")))
+	}
+
+	return nil
+}
+
+// closingBrace returns the index of the brace closing the one at the given index,
+// respecting quoted label values. Returns -1 if there is none
+func closingBrace(line string, open int) int {
+	inQuotes := false
+
+	for i := open + 1; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '"':
+			inQuotes = !inQuotes
+		case '}':
+			if !inQuotes {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
+
+func containsString(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (d *DocumentHandle) setMetricFamilies(families []*MetricFamily) error {
+	d.snap.mu.Lock()
+	defer d.snap.mu.Unlock()
+
+	select {
+	case <-d.snap.ctx.Done():
+		return d.snap.ctx.Err()
+	default:
+		d.snap.metricFamilies = families
+		return nil
+	}
+}
+
+// GetMetricFamilies returns the metric families found in an exposition file
+// and returns an error if that context has expired, i.e. the Document
+// has changed since
+// It blocks until all compile tasks are finished
+func (d *DocumentHandle) GetMetricFamilies() ([]*MetricFamily, error) {
+	if err := d.waitCompiled(); err != nil {
+		return nil, err
+	}
+
+	return d.snap.metricFamilies, nil
+}
@@ -0,0 +1,92 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+func TestOpenMetrics(t *testing.T) { // nolint:funlen
+	tests := []struct {
+		input       string
+		diagnostics int
+		families    int
+	}{
+		{
+			input:       "# HELP foo Some help\n# TYPE foo counter\nfoo_total{a=\"b\"} 1\nfoo_total{a=\"c\"} 2\n# EOF\n",
+			diagnostics: 0,
+			families:    1,
+		}, {
+			input:       "# HELP foo Some help\n# HELP foo Other help\nfoo 1\n",
+			diagnostics: 1,
+			families:    1,
+		}, {
+			input:       "# TYPE foo gauge\n# TYPE foo counter\nfoo 1\n",
+			diagnostics: 1,
+			families:    1,
+		}, {
+			input:       "foo{a=\"b\"} 1\nfoo{c=\"d\"} 1\n",
+			diagnostics: 1,
+			families:    1,
+		}, {
+			input:       "foo{a=\"b\" 1\nbar 1 2 3\nbaz abc\n",
+			diagnostics: 3,
+			families:    0,
+		}, {
+			input:       "# TYPE foo histogram\nfoo_bucket{le=\"1\"} 1\nfoo_bucket{le=\"+Inf\"} 1\nfoo_sum 1\nfoo_count 1\n",
+			diagnostics: 0,
+			families:    1,
+		},
+	}
+
+	for i, test := range tests {
+		c := &DocumentCache{}
+
+		c.Init()
+
+		doc, err := c.AddDocument(
+			context.Background(),
+			&protocol.TextDocumentItem{
+				URI:        fmt.Sprintf("test_file_%d.prom", i),
+				LanguageID: "plaintext",
+				Version:    0,
+				Text:       test.input,
+			})
+		if err != nil {
+			panic("Failed to AddDocument() to cache")
+		}
+
+		diagnostics, err := doc.GetDiagnostics()
+		if err != nil {
+			panic("Failed to get diagnostics")
+		}
+
+		if len(diagnostics) != test.diagnostics {
+			panic(fmt.Sprintf("Expected %d diagnostics for %q, got %v", test.diagnostics, test.input, diagnostics))
+		}
+
+		families, err := doc.GetMetricFamilies()
+		if err != nil {
+			panic("Failed to get metric families")
+		}
+
+		if len(families) != test.families {
+			panic(fmt.Sprintf("Expected %d metric families for %q, got %d", test.families, test.input, len(families)))
+		}
+	}
+}
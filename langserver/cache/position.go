@@ -17,6 +17,7 @@ import (
 	"errors"
 	"fmt"
 	"go/token"
+	"unicode/utf16"
 
 	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
 	"github.com/prometheus-community/promql-langserver/vendored/go-tools/span"
@@ -24,12 +25,9 @@ import (
 
 // PositionToProtocolPosition converts a token.Position to a protocol.Position
 func (d *DocumentHandle) PositionToProtocolPosition(pos token.Position) (protocol.Position, error) {
-	d.doc.mu.RLock()
-	defer d.doc.mu.RUnlock()
-
 	select {
-	case <-d.ctx.Done():
-		return protocol.Position{}, d.ctx.Err()
+	case <-d.snap.ctx.Done():
+		return protocol.Position{}, d.snap.ctx.Err()
 	default:
 		line := pos.Line
 		char := pos.Column
@@ -48,18 +46,15 @@ func (d *DocumentHandle) PositionToProtocolPosition(pos token.Position) (protoco
 			return protocol.Position{}, err
 		}
 
-		offset := int(lineStart) - d.doc.posData.Base() + char - 1
-		point := span.NewPoint(line, char, offset)
+		char, err = d.utf16Column(line, int(lineStart)-d.snap.posData.Base(), char)
+		if err != nil {
+			return protocol.Position{}, err
+		}
 
-		char, err = span.ToUTF16Column(point, []byte(d.doc.content))
 		// Protocol has zero based positions
 		char--
 		line--
 
-		if err != nil {
-			return protocol.Position{}, err
-		}
-
 		return protocol.Position{
 			Line:      float64(line),
 			Character: float64(char),
@@ -69,18 +64,33 @@ func (d *DocumentHandle) PositionToProtocolPosition(pos token.Position) (protoco
 
 // PosToProtocolPosition converts a token.Pos to a protocol.Position
 func (d *DocumentHandle) PosToProtocolPosition(pos token.Pos) (protocol.Position, error) {
-	ret, err := d.PositionToProtocolPosition(d.doc.posData.Position(pos))
+	ret, err := d.PositionToProtocolPosition(d.snap.posData.Position(pos))
 	return ret, err
 }
 
+// ByteOffset converts a token.Pos to a byte offset into the document content
+func (d *DocumentHandle) ByteOffset(pos token.Pos) int {
+	return d.snap.posData.Offset(pos)
+}
+
+// OffsetToPos converts a byte offset into the document content to a token.Pos, it is the inverse of ByteOffset
+func (d *DocumentHandle) OffsetToPos(offset int) (token.Pos, error) {
+	if err := d.snap.ctx.Err(); err != nil {
+		return token.NoPos, err
+	}
+
+	if offset < 0 || offset > d.snap.size {
+		return token.NoPos, errors.New("offset outside of the document")
+	}
+
+	return token.Pos(d.doc.base + offset), nil
+}
+
 // ProtocolPositionToTokenPos converts a token.Pos to a protocol.Position
 func (d *DocumentHandle) ProtocolPositionToTokenPos(pos protocol.Position) (token.Pos, error) {
-	d.doc.mu.RLock()
-	defer d.doc.mu.RUnlock()
-
 	select {
-	case <-d.ctx.Done():
-		return 0, d.ctx.Err()
+	case <-d.snap.ctx.Done():
+		return 0, d.snap.ctx.Err()
 	default:
 		// protocol.Position is 0 based
 		line := int(pos.Line) + 1
@@ -91,27 +101,83 @@ func (d *DocumentHandle) ProtocolPositionToTokenPos(pos protocol.Position) (toke
 			return token.NoPos, err
 		}
 
-		offset := int(lineStart) - d.doc.posData.Base()
-		point := span.NewPoint(line, 1, offset)
-
-		point, err = span.FromUTF16Column(point, char, []byte(d.doc.content))
+		char, err = d.byteColumn(line, int(lineStart)-d.snap.posData.Base(), char)
 		if err != nil {
 			return token.NoPos, err
 		}
 
-		char = point.Column()
-
 		return lineStart + token.Pos(char), nil
 	}
 }
 
+// lineEnd returns the offset of the end of a line without its line break and whether a line break follows
+func (d *DocumentHandle) lineEnd(line int) (int, bool) {
+	if line < d.snap.posData.LineCount() {
+		return int(d.snap.posData.LineStart(line+1)) - d.snap.posData.Base() - 1, true
+	}
+
+	return d.snap.size, false
+}
+
+// utf16Column converts a byte column of a line to a UTF-16 column, both 1-based. It doesn't load the content,
+// which might have been spilled to disk: every byte of ASCII lines is a UTF-16 code unit and the other lines
+// are kept in the snapshot.
+func (d *DocumentHandle) utf16Column(line int, lineOffset int, col int) (int, error) {
+	if col < 1 || lineOffset+col-1 > d.snap.size {
+		return -1, fmt.Errorf("column %d of line %d is outside of the document", col, line)
+	}
+
+	text, ok := d.snap.wideLines[line]
+	if !ok {
+		return col, nil
+	}
+
+	if col-1 > len(text) {
+		// Past the end of the line, the line break is a single code unit
+		return len(utf16.Encode([]rune(text))) + col - len(text), nil
+	}
+
+	return len(utf16.Encode([]rune(text[:col-1]))) + 1, nil
+}
+
+// byteColumn converts a UTF-16 column of a line to a byte column. Like span.FromUTF16Column, columns past the end
+// of a line are moved to its end and the first code unit is skipped. It doesn't load the content either.
+func (d *DocumentHandle) byteColumn(line int, lineOffset int, chr int) (int, error) {
+	if chr <= 1 {
+		return 1, nil
+	}
+
+	end, lineBreak := d.lineEnd(line)
+
+	if text, ok := d.snap.wideLines[line]; ok {
+		if lineBreak {
+			text += "\n"
+		}
+
+		// The point is relative to the start of the line
+		point, err := span.FromUTF16Column(span.NewPoint(1, 1, 0), chr, []byte(text))
+		if err != nil {
+			return -1, err
+		}
+
+		return point.Column(), nil
+	}
+
+	switch {
+	case chr-1 <= end-lineOffset:
+		return chr, nil
+	case lineBreak:
+		return end - lineOffset + 1, nil
+	default:
+		return -1, errors.New("FromUTF16Column: chr goes beyond the content")
+	}
+}
+
 // YamlPositionToTokenPos converts a position of the format used by the yaml parser to a token.Pos
 func (d *DocumentHandle) YamlPositionToTokenPos(line int, column int, lineOffset int) (token.Pos, error) {
-	d.doc.mu.RLock()
-	defer d.doc.mu.RUnlock()
 	select {
-	case <-d.ctx.Done():
-		return token.NoPos, d.ctx.Err()
+	case <-d.snap.ctx.Done():
+		return token.NoPos, d.snap.ctx.Err()
 	default:
 		if column < 1 {
 			return 0, errors.New("invalid position")
@@ -146,17 +212,15 @@ func (d *DocumentHandle) LineStartSafe(line int) (pos token.Pos, err error) {
 		}
 	}()
 
-	return d.doc.posData.LineStart(line), nil
+	return d.snap.posData.LineStart(line), nil
 }
 
 // TokenPosToTokenPosition converts a token.Pos to a token.Position
 func (d *DocumentHandle) TokenPosToTokenPosition(pos token.Pos) (token.Position, error) {
-	d.doc.mu.RLock()
-	defer d.doc.mu.RUnlock()
 	select {
-	case <-d.ctx.Done():
-		return token.Position{}, d.ctx.Err()
+	case <-d.snap.ctx.Done():
+		return token.Position{}, d.snap.ctx.Err()
 	default:
-		return d.doc.posData.Position(pos), nil
+		return d.snap.posData.Position(pos), nil
 	}
 }
@@ -0,0 +1,134 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"go/token"
+	"strings"
+
+	"github.com/prometheus/prometheus/promql"
+)
+
+// MetricReference is an occurrence of a metric name in a document
+type MetricReference struct {
+	Doc  *DocumentHandle
+	Name string
+	// Pos and End span the metric name
+	Pos token.Pos
+	End token.Pos
+	// Definition is set if the reference is the record field of a recording rule
+	Definition bool
+}
+
+// GetMetricReferences returns the definitions of recording rules and the usages
+// of metric names in the queries of a document.
+// It blocks until all compile tasks are finished
+func (d *DocumentHandle) GetMetricReferences() ([]MetricReference, error) {
+	content, err := d.GetContent()
+	if err != nil {
+		return nil, err
+	}
+
+	groups, err := d.GetRuleGroups()
+	if err != nil {
+		return nil, err
+	}
+
+	queries, err := d.GetQueries()
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []MetricReference
+
+	for _, group := range groups {
+		for _, rule := range group.Rules {
+			if rule.Record == "" {
+				continue
+			}
+
+			// The range of the yaml node doesn't account for quotes
+			start := d.ByteOffset(rule.NamePos)
+			end := d.ByteOffset(rule.NameEnd) + 2
+
+			if end > len(content) {
+				end = len(content)
+			}
+
+			i := strings.Index(content[start:end], rule.Record)
+			if i < 0 {
+				continue
+			}
+
+			pos := rule.NamePos + token.Pos(i)
+
+			ret = append(ret, MetricReference{
+				Doc:        d,
+				Name:       rule.Record,
+				Pos:        pos,
+				End:        pos + token.Pos(len(rule.Record)),
+				Definition: true,
+			})
+		}
+	}
+
+	for _, q := range queries {
+		if q.Ast == nil {
+			continue
+		}
+
+		promql.Inspect(q.Ast, func(node promql.Node, _ []promql.Node) error {
+			vs, ok := node.(*promql.VectorSelector)
+			if !ok || vs.Name == "" {
+				return nil
+			}
+
+			// Selectors that only match the name with a __name__ matcher are left out
+			start, end := int(vs.PosRange.Start), int(vs.PosRange.Start)+len(vs.Name)
+			if end > len(q.Content) || q.Content[start:end] != vs.Name {
+				return nil
+			}
+
+			ret = append(ret, MetricReference{
+				Doc:  d,
+				Name: vs.Name,
+				Pos:  q.Pos + token.Pos(start),
+				End:  q.Pos + token.Pos(end),
+			})
+
+			return nil
+		})
+	}
+
+	return ret, nil
+}
+
+// GetMetricIndex returns the references to metric names in all documents of the cache,
+// indexed by the metric name. Documents that change while the index is built are left out.
+func (c *DocumentCache) GetMetricIndex() map[string][]MetricReference {
+	ret := make(map[string][]MetricReference)
+
+	for _, doc := range c.GetDocuments() {
+		refs, err := doc.GetMetricReferences()
+		if err != nil {
+			continue
+		}
+
+		for _, ref := range refs {
+			ret[ref.Name] = append(ret[ref.Name], ref)
+		}
+	}
+
+	return ret
+}
@@ -0,0 +1,358 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"go/token"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RuleGroup describes a rule group found in a Prometheus rule file
+type RuleGroup struct {
+	Name string
+	// Interval is the evaluation interval of the group, or 0 if it isn't set
+	Interval time.Duration
+
+	// Node is the yaml mapping the group is defined by
+	Node *yaml.Node
+	// LineOffset has to be added to the line numbers of Node before translating into a token.Pos
+	LineOffset int
+
+	Pos token.Pos
+	End token.Pos
+
+	// NamePos and NameEnd span the value of the name field
+	NamePos token.Pos
+	NameEnd token.Pos
+
+	Rules []*Rule
+}
+
+// Rule describes a recording or alerting rule found in a Prometheus rule file
+type Rule struct {
+	Group *RuleGroup
+	// Index is the position of the rule inside its group
+	Index int
+
+	Record string
+	Alert  string
+
+	// Node is the yaml mapping the rule is defined by
+	Node *yaml.Node
+
+	Pos token.Pos
+	End token.Pos
+
+	// NamePos and NameEnd span the value of the record or alert field
+	NamePos token.Pos
+	NameEnd token.Pos
+
+	// For is the value of the for field of an alerting rule, or 0 if it isn't set
+	For time.Duration
+	// ForPos and ForEnd span the value of the for field, if there is one
+	ForPos token.Pos
+	ForEnd token.Pos
+
+	// Labels are the labels added by the rule
+	Labels map[string]string
+
+	// ExprPos is the position the query of the rule starts at
+	ExprPos token.Pos
+	// Query is the compiled expression of the rule. It is nil if the expression
+	// could not be compiled, e.g. because it is quoted
+	Query *CompiledQuery
+}
+
+// Name returns the name of the recorded metric or the alert
+func (r *Rule) Name() string {
+	if r.Record != "" {
+		return r.Record
+	}
+
+	return r.Alert
+}
+
+// GetRuleGroups returns the rule groups found in a document
+// and returns an error if that context has expired, i.e. the Document
+// has changed since
+// It blocks until all compile tasks are finished
+func (d *DocumentHandle) GetRuleGroups() ([]*RuleGroup, error) {
+	if err := d.waitCompiled(); err != nil {
+		return nil, err
+	}
+
+	return d.snap.ruleGroups, nil
+}
+
+// scanRuleGroups extracts the rule groups of all yaml documents that look like Prometheus rule files
+func (d *DocumentHandle) scanRuleGroups() error {
+	yamls, err := d.GetYamls()
+	if err != nil {
+		return err
+	}
+
+	var groups []*RuleGroup
+
+	for _, yamlDoc := range yamls {
+		root := &yamlDoc.AST
+		if root.Kind == yaml.DocumentNode && len(root.Content) > 0 {
+			root = root.Content[0]
+		}
+
+		groupsNode := MappingValue(root, "groups")
+		if groupsNode == nil || groupsNode.Kind != yaml.SequenceNode {
+			continue
+		}
+
+		for _, groupNode := range groupsNode.Content {
+			group, err := d.scanRuleGroup(groupNode, yamlDoc.LineOffset)
+			if err != nil {
+				return err
+			}
+
+			if group != nil {
+				groups = append(groups, group)
+			}
+		}
+	}
+
+	d.snap.mu.Lock()
+	defer d.snap.mu.Unlock()
+
+	select {
+	case <-d.snap.ctx.Done():
+		return d.snap.ctx.Err()
+	default:
+		d.snap.ruleGroups = groups
+		return nil
+	}
+}
+
+func (d *DocumentHandle) scanRuleGroup(node *yaml.Node, lineOffset int) (*RuleGroup, error) {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+
+	var err error
+
+	group := &RuleGroup{
+		Node:       node,
+		LineOffset: lineOffset,
+	}
+
+	if group.Pos, group.End, err = d.YamlNodeRange(node, lineOffset); err != nil {
+		return nil, err
+	}
+
+	if name := MappingValue(node, "name"); name != nil {
+		group.Name = name.Value
+
+		if group.NamePos, group.NameEnd, err = d.YamlNodeRange(name, lineOffset); err != nil {
+			return nil, err
+		}
+	}
+
+	if interval := MappingValue(node, "interval"); interval != nil {
+		if duration, err := ParseDuration(interval.Value); err == nil {
+			group.Interval = duration
+		}
+	}
+
+	rulesNode := MappingValue(node, "rules")
+	if rulesNode == nil || rulesNode.Kind != yaml.SequenceNode {
+		return group, nil
+	}
+
+	for i, ruleNode := range rulesNode.Content {
+		if ruleNode == nil || ruleNode.Kind != yaml.MappingNode {
+			continue
+		}
+
+		rule := &Rule{
+			Group: group,
+			Index: i,
+			Node:  ruleNode,
+		}
+
+		if rule.Pos, rule.End, err = d.YamlNodeRange(ruleNode, lineOffset); err != nil {
+			return nil, err
+		}
+
+		for _, key := range []string{"record", "alert"} {
+			if name := MappingValue(ruleNode, key); name != nil {
+				if key == "record" {
+					rule.Record = name.Value
+				} else {
+					rule.Alert = name.Value
+				}
+
+				if rule.NamePos, rule.NameEnd, err = d.YamlNodeRange(name, lineOffset); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		if forNode := MappingValue(ruleNode, "for"); forNode != nil {
+			if duration, err := ParseDuration(forNode.Value); err == nil {
+				rule.For = duration
+			}
+
+			if rule.ForPos, rule.ForEnd, err = d.YamlNodeRange(forNode, lineOffset); err != nil {
+				return nil, err
+			}
+		}
+
+		if labels := MappingValue(ruleNode, "labels"); labels != nil && labels.Kind == yaml.MappingNode {
+			rule.Labels = make(map[string]string)
+
+			for i := 0; i+1 < len(labels.Content); i += 2 {
+				rule.Labels[labels.Content[i].Value] = labels.Content[i+1].Value
+			}
+		}
+
+		if expr := MappingValue(ruleNode, "expr"); expr != nil {
+			if rule.ExprPos, err = d.yamlQueryPos(expr, lineOffset); err != nil {
+				return nil, err
+			}
+		}
+
+		group.Rules = append(group.Rules, rule)
+	}
+
+	return group, nil
+}
+
+// linkRule attaches a compiled query to the rule it belongs to.
+// The caller must hold the document lock.
+func (d *DocumentHandle) linkRule(query *CompiledQuery) {
+	for _, group := range d.snap.ruleGroups {
+		for _, rule := range group.Rules {
+			if rule.ExprPos == query.Pos {
+				rule.Query = query
+				return
+			}
+		}
+	}
+}
+
+// MappingValue returns the value for a key of a yaml mapping, or nil if there is none
+func MappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if k := node.Content[i]; k != nil && k.Value == key {
+			return node.Content[i+1]
+		}
+	}
+
+	return nil
+}
+
+// YamlNodeRange returns the range of the document covered by a yaml node
+func (d *DocumentHandle) YamlNodeRange(node *yaml.Node, lineOffset int) (token.Pos, token.Pos, error) {
+	start, err := d.YamlPositionToTokenPos(node.Line, node.Column, lineOffset)
+	if err != nil {
+		return token.NoPos, token.NoPos, err
+	}
+
+	end, err := d.yamlNodeEnd(node, lineOffset)
+	if err != nil {
+		return token.NoPos, token.NoPos, err
+	}
+
+	if end < start {
+		end = start
+	}
+
+	return start, end, nil
+}
+
+// yamlNodeEnd approximates the end of a yaml node, since the yaml parser does not provide it
+func (d *DocumentHandle) yamlNodeEnd(node *yaml.Node, lineOffset int) (token.Pos, error) {
+	if len(node.Content) > 0 {
+		return d.yamlNodeEnd(node.Content[len(node.Content)-1], lineOffset)
+	}
+
+	start, err := d.YamlPositionToTokenPos(node.Line, node.Column, lineOffset)
+	if err != nil {
+		return token.NoPos, err
+	}
+
+	switch node.Style {
+	case yaml.LiteralStyle, yaml.FoldedStyle:
+		lines := strings.Count(strings.TrimRight(node.Value, "\n"), "\n") + 1
+
+		next, err := d.YamlPositionToTokenPos(node.Line+lines+1, 1, lineOffset)
+		if err != nil {
+			return start, nil
+		}
+
+		// Exclude the final newline
+		return next - 1, nil
+	case yaml.SingleQuotedStyle, yaml.DoubleQuotedStyle:
+		// Escape sequences make the source longer than the value
+		content, err := d.GetContent()
+		if err != nil {
+			return start + token.Pos(len(node.Value)+2), nil
+		}
+
+		return start + token.Pos(quotedScalarLength(content[d.ByteOffset(start):])), nil
+	default:
+		return start + token.Pos(len(node.Value)), nil
+	}
+}
+
+// quotedScalarLength returns the length of the quoted yaml scalar a text starts with, including the quotes.
+// Double quoted scalars escape characters with backslashes, single quoted ones quotes by doubling them.
+func quotedScalarLength(text string) int {
+	if text == "" {
+		return 0
+	}
+
+	quote := text[0]
+
+	for i := 1; i < len(text); i++ {
+		switch {
+		case quote == '"' && text[i] == '\\':
+			i++
+		case text[i] != quote:
+		case quote == '\'' && i+1 < len(text) && text[i+1] == '\'':
+			i++
+		default:
+			return i + 1
+		}
+	}
+
+	return len(text)
+}
+
+// yamlQueryPos returns the position a query inside a yaml scalar starts at
+func (d *DocumentHandle) yamlQueryPos(node *yaml.Node, lineOffset int) (token.Pos, error) {
+	line := node.Line
+	col := node.Column
+
+	if node.Style == yaml.LiteralStyle || node.Style == yaml.FoldedStyle {
+		// The query starts on the line following the '|' or '>'
+		line++
+
+		col = 1
+	}
+
+	return d.YamlPositionToTokenPos(line, col, lineOffset)
+}
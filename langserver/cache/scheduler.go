@@ -0,0 +1,92 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"runtime"
+	"sync"
+)
+
+// compileScheduler runs the compile tasks of document versions on a bounded number of workers.
+// Changed documents are compiled before newly added ones, the most recently changed first, so that
+// adding hundreds of documents at once, e.g. when indexing a workspace, doesn't delay the documents
+// that are being edited. Workers are only running while there are tasks.
+type compileScheduler struct {
+	mu sync.Mutex
+
+	// edited are the compile tasks of changed documents, the most recent one is last
+	edited []*DocumentHandle
+	// added are the compile tasks of new documents in the order they were added
+	added []*DocumentHandle
+
+	workers    int
+	maxWorkers int
+}
+
+func newCompileScheduler() *compileScheduler {
+	return &compileScheduler{maxWorkers: runtime.GOMAXPROCS(0)}
+}
+
+// schedule queues the compilation of a document version, edited is set for changed documents
+func (s *compileScheduler) schedule(d *DocumentHandle, edited bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if edited {
+		s.edited = append(s.edited, d)
+	} else {
+		s.added = append(s.added, d)
+	}
+
+	if s.workers < s.maxWorkers {
+		s.workers++
+
+		go s.work()
+	}
+}
+
+// next returns the task with the highest priority, or nil if the worker should stop since there is none
+func (s *compileScheduler) next() *DocumentHandle {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var ret *DocumentHandle
+
+	switch {
+	case len(s.edited) > 0:
+		ret = s.edited[len(s.edited)-1]
+		s.edited[len(s.edited)-1] = nil
+		s.edited = s.edited[:len(s.edited)-1]
+	case len(s.added) > 0:
+		ret = s.added[0]
+		s.added[0] = nil
+		s.added = s.added[1:]
+	default:
+		s.workers--
+	}
+
+	return ret
+}
+
+func (s *compileScheduler) work() {
+	for d := s.next(); d != nil; d = s.next() {
+		if d.snap.ctx.Err() != nil {
+			// Versions that have been replaced while they were queued are never compiled
+			d.snap.compilers.Done()
+			continue
+		}
+
+		d.compile() //nolint:errcheck
+	}
+}
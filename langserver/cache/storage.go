@@ -0,0 +1,196 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/jsonrpc2"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// Storage holds the content of the documents of a DocumentCache.
+// All methods must be threadsafe.
+type Storage interface {
+	// Store saves the content of a document, replacing the previous version
+	Store(uri protocol.DocumentURI, content string) error
+	// Load returns the content of a document
+	Load(uri protocol.DocumentURI) (string, error)
+	// Delete removes a document
+	Delete(uri protocol.DocumentURI) error
+	// Close removes all documents and releases the resources of the storage
+	Close() error
+}
+
+// memoryStorage keeps all documents in memory
+type memoryStorage struct {
+	documents map[protocol.DocumentURI]string
+	mu        sync.RWMutex
+}
+
+// NewMemoryStorage returns a Storage that keeps all documents in memory. It is used by default.
+func NewMemoryStorage() Storage {
+	return &memoryStorage{documents: make(map[protocol.DocumentURI]string)}
+}
+
+func (s *memoryStorage) Store(uri protocol.DocumentURI, content string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.documents[uri] = content
+
+	return nil
+}
+
+func (s *memoryStorage) Load(uri protocol.DocumentURI) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	content, ok := s.documents[uri]
+	if !ok {
+		return "", jsonrpc2.NewErrorf(jsonrpc2.CodeInternalError, "cache/storage: Document not found: %v", uri)
+	}
+
+	return content, nil
+}
+
+func (s *memoryStorage) Delete(uri protocol.DocumentURI) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.documents, uri)
+
+	return nil
+}
+
+func (s *memoryStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.documents = make(map[protocol.DocumentURI]string)
+
+	return nil
+}
+
+// spillStorage keeps documents in memory up to a limit and writes the others to files
+type spillStorage struct {
+	memory      *memoryStorage
+	memoryLimit int
+	memoryUsed  int
+
+	dir     string
+	files   map[protocol.DocumentURI]string
+	counter int
+
+	mu sync.Mutex
+}
+
+// NewSpillStorage returns a Storage that keeps documents in memory until their total size exceeds
+// memoryLimit bytes. The content of further documents is written to a temporary directory inside dir,
+// or inside the default directory for temporary files if dir is empty, and read again whenever it is needed.
+//
+// Compile results are kept in memory for all documents.
+func NewSpillStorage(dir string, memoryLimit int) (Storage, error) {
+	tmp, err := ioutil.TempDir(dir, "promql-langserver-")
+	if err != nil {
+		return nil, err
+	}
+
+	return &spillStorage{
+		memory:      NewMemoryStorage().(*memoryStorage),
+		memoryLimit: memoryLimit,
+		dir:         tmp,
+		files:       make(map[protocol.DocumentURI]string),
+	}, nil
+}
+
+func (s *spillStorage) Store(uri protocol.DocumentURI, content string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.delete(uri)
+
+	if s.memoryUsed+len(content) <= s.memoryLimit {
+		s.memoryUsed += len(content)
+		return s.memory.Store(uri, content)
+	}
+
+	s.counter++
+	path := filepath.Join(s.dir, fmt.Sprintf("%d.txt", s.counter))
+
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		return jsonrpc2.NewErrorf(jsonrpc2.CodeInternalError, "cache/storage: failed to spill document: %v", err)
+	}
+
+	s.files[uri] = path
+
+	return nil
+}
+
+func (s *spillStorage) Load(uri protocol.DocumentURI) (string, error) {
+	s.mu.Lock()
+	path, ok := s.files[uri]
+	s.mu.Unlock()
+
+	if !ok {
+		return s.memory.Load(uri)
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", jsonrpc2.NewErrorf(jsonrpc2.CodeInternalError, "cache/storage: failed to read spilled document: %v", err)
+	}
+
+	return string(content), nil
+}
+
+func (s *spillStorage) Delete(uri protocol.DocumentURI) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.delete(uri)
+
+	return nil
+}
+
+// delete removes a document from memory or disk. The caller must hold the lock.
+func (s *spillStorage) delete(uri protocol.DocumentURI) {
+	if path, ok := s.files[uri]; ok {
+		os.Remove(path) // nolint: errcheck
+		delete(s.files, uri)
+
+		return
+	}
+
+	if content, err := s.memory.Load(uri); err == nil {
+		s.memoryUsed -= len(content)
+		s.memory.Delete(uri) // nolint: errcheck
+	}
+}
+
+func (s *spillStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.files = make(map[protocol.DocumentURI]string)
+	s.memoryUsed = 0
+
+	s.memory.Close() // nolint: errcheck
+
+	return os.RemoveAll(s.dir)
+}
@@ -0,0 +1,183 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/span"
+)
+
+// TestSpillStorage checks that documents exceeding the memory limit are written to disk
+// and that the documents of the cache work the same way with both storages
+func TestSpillStorage(*testing.T) {
+	dir, err := ioutil.TempDir("", "promql-langserver")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	storage, err := NewSpillStorage(dir, 20)
+	if err != nil {
+		panic(err)
+	}
+
+	c := &DocumentCache{}
+
+	c.InitWithStorage(storage)
+
+	for _, doc := range []struct{ uri, text string }{
+		{"small.promql", "up"},
+		{"large.promql", `sum(rate(http_requests_total{job="api"}[5m]))`},
+	} {
+		if _, err := c.AddDocument(context.Background(), &protocol.TextDocumentItem{
+			URI:        doc.uri,
+			LanguageID: "promql",
+			Text:       doc.text,
+		}); err != nil {
+			panic(err)
+		}
+	}
+
+	spill := storage.(*spillStorage)
+
+	if _, ok := spill.files["large.promql"]; !ok || len(spill.files) != 1 {
+		panic("expected only the document exceeding the memory limit to be spilled")
+	}
+
+	doc, err := c.GetDocument("large.promql")
+	if err != nil {
+		panic(err)
+	}
+
+	if queries, err := doc.GetQueries(); err != nil || len(queries) != 1 || queries[0].Ast == nil {
+		panic("expected the spilled document to be compiled from its content on disk")
+	}
+
+	if content, err := doc.GetContent(); err != nil || content != `sum(rate(http_requests_total{job="api"}[5m]))` {
+		panic("expected the content of the spilled document to be read from disk")
+	}
+
+	if err := c.RemoveDocument("large.promql"); err != nil {
+		panic(err)
+	}
+
+	if len(spill.files) != 0 {
+		panic("expected the file of a removed document to be deleted")
+	}
+
+	if err := c.Close(); err != nil {
+		panic(err)
+	}
+
+	if _, err := os.Stat(spill.dir); !os.IsNotExist(err) {
+		panic("expected the spill directory to be removed")
+	}
+}
+
+// loadCountingStorage counts the loads of a memory storage
+type loadCountingStorage struct {
+	Storage
+	loads int
+	mu    sync.Mutex
+}
+
+func (s *loadCountingStorage) Load(uri protocol.DocumentURI) (string, error) {
+	s.mu.Lock()
+	s.loads++
+	s.mu.Unlock()
+
+	return s.Storage.Load(uri)
+}
+
+// TestPositionsWithoutLoading checks that positions are converted like with the content, but without loading it
+func TestPositionsWithoutLoading(*testing.T) { // nolint: funlen
+	storage := &loadCountingStorage{Storage: NewMemoryStorage()}
+
+	c := &DocumentCache{}
+
+	c.InitWithStorage(storage)
+
+	content := "up\r\nfoo{a=\"ä😀\"} > 1\n\nbar{b=\"é\"}"
+
+	if _, err := c.AddDocument(context.Background(), &protocol.TextDocumentItem{
+		URI:        "positions.promql",
+		LanguageID: "promql",
+		Text:       content,
+	}); err != nil {
+		panic(err)
+	}
+
+	doc, err := c.GetDocument("positions.promql")
+	if err != nil {
+		panic(err)
+	}
+
+	if _, err := doc.GetQueries(); err != nil {
+		panic(err)
+	}
+
+	storage.mu.Lock()
+	loads := storage.loads
+	storage.mu.Unlock()
+
+	for line := 1; line <= doc.snap.posData.LineCount(); line++ {
+		lineStart := doc.snap.posData.LineStart(line)
+		lineOffset := int(lineStart) - doc.snap.posData.Base()
+
+		lineLength := strings.IndexByte(content[lineOffset:]+"\n", '\n')
+
+		// Up to the line break, the columns after it are on the next line
+		for col := 1; col <= lineLength+1 && lineOffset+col-1 <= len(content); col++ {
+			expected, expectedErr := span.ToUTF16Column(span.NewPoint(line, col, lineOffset+col-1), []byte(content))
+
+			if actual, err := doc.utf16Column(line, lineOffset, col); (err != nil) != (expectedErr != nil) || err == nil && actual != expected {
+				panic(fmt.Sprintf("expected byte column %d of line %d to be UTF-16 column %d, got %d (%v)", col, line, expected, actual, err))
+			}
+		}
+
+		for chr := 0; chr < 16; chr++ {
+			expected, expectedErr := span.FromUTF16Column(span.NewPoint(line, 1, lineOffset), chr, []byte(content))
+
+			actual, err := doc.byteColumn(line, lineOffset, chr)
+
+			if (err != nil) != (expectedErr != nil) || err == nil && actual != expected.Column() {
+				panic(fmt.Sprintf("expected UTF-16 column %d of line %d to be converted like %v (%v), got %d (%v)",
+					chr, line, expected, expectedErr, actual, err))
+			}
+		}
+	}
+
+	if _, err := doc.OffsetToPos(len(content)); err != nil {
+		panic(err)
+	}
+
+	if _, err := doc.OffsetToPos(len(content) + 1); err == nil {
+		panic("expected offsets after the end of the document to fail")
+	}
+
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	if storage.loads != loads {
+		panic(fmt.Sprintf("expected converting positions not to load the content, got %d loads", storage.loads-loads))
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"net/url"
+	"path"
+	"strings"
+)
+
+// URIPath returns the path of a document URI of any scheme, e.g. /org/repo/rules.yml for
+// vscode-vfs://github/org/repo/rules.yml or Untitled-1 for untitled:Untitled-1. The query and fragment,
+// which some editors use to identify versions of a file, are left out.
+func URIPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return strings.SplitN(strings.SplitN(uri, "?", 2)[0], "#", 2)[0]
+	}
+
+	if u.Opaque != "" {
+		return u.Opaque
+	}
+
+	return u.Path
+}
+
+// languageFromURI guesses the language of a document from its file extension. It is used for documents
+// that were opened without a language ID the server knows, e.g. by web based editors that send plaintext
+// for files in remote repositories.
+func languageFromURI(uri string) string {
+	switch path.Ext(URIPath(uri)) {
+	case ".yml", ".yaml":
+		return "yaml"
+	case ".promql":
+		return "promql"
+	case ".json":
+		return "json"
+	case ".jsonnet", ".libsonnet":
+		return "jsonnet"
+	case ".md":
+		return "markdown"
+	case ".html":
+		return "html"
+	default:
+		return ""
+	}
+}
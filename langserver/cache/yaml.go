@@ -51,17 +51,23 @@ func (d *DocumentHandle) parseYamls() error {
 
 		unread = reader.Len()
 
-		yamlDoc.End = token.Pos(d.doc.posData.Base() + len(content) - unread)
+		yamlDoc.End = token.Pos(d.snap.posData.Base() + len(content) - unread)
 		yamlDoc.LineOffset = lineOffset
 
 		// Update Line Offset for the next document
-		lineOffset = d.doc.posData.Line(yamlDoc.End) - 1
+		lineOffset = d.snap.posData.Line(yamlDoc.End) - 1
 
 		err := d.addYaml(&yamlDoc)
 		if err != nil {
 			return err
 		}
 
+		if yamlDoc.Err != nil && !errors.Is(yamlDoc.Err, io.EOF) {
+			if err := d.yamlErrToDiagnostic(yamlDoc.Err, yamlDoc.LineOffset); err != nil {
+				return err
+			}
+		}
+
 		if errors.Is(yamlDoc.Err, io.EOF) {
 			return yamlDoc.Err
 		}
@@ -71,21 +77,21 @@ func (d *DocumentHandle) parseYamls() error {
 }
 
 func (d *DocumentHandle) addYaml(yaml *YamlDoc) error {
-	d.doc.mu.Lock()
-	defer d.doc.mu.Unlock()
+	d.snap.mu.Lock()
+	defer d.snap.mu.Unlock()
 
 	select {
-	case <-d.ctx.Done():
-		return d.ctx.Err()
+	case <-d.snap.ctx.Done():
+		return d.snap.ctx.Err()
 	default:
-		d.doc.yamls = append(d.doc.yamls, yaml)
+		d.snap.yamls = append(d.snap.yamls, yaml)
 
 		return nil
 	}
 }
 
 func (d *DocumentHandle) scanYamlTree() error {
-	defer d.doc.compilers.Done()
+	defer d.snap.compilers.Done()
 
 	yamls, err := d.GetYamls()
 	if err != nil {
@@ -235,17 +241,7 @@ OUTER:
 }
 
 func (d *DocumentHandle) foundQuery(node *yaml.Node, endPos token.Pos, record *yaml.Node, lineOffset int) error {
-	line := node.Line
-	col := node.Column
-
-	if node.Style == yaml.LiteralStyle || node.Style == yaml.FoldedStyle {
-		// The query starts on the line following the '|' or '>'
-		line++
-
-		col = 1
-	}
-
-	pos, err := d.YamlPositionToTokenPos(line, col, lineOffset)
+	pos, err := d.yamlQueryPos(node, lineOffset)
 	if err != nil {
 		return err
 	}
@@ -255,7 +251,7 @@ func (d *DocumentHandle) foundQuery(node *yaml.Node, endPos token.Pos, record *y
 		return err
 	}
 
-	d.doc.compilers.Add(1)
+	d.snap.compilers.Add(1)
 
 	var recordValue string
 
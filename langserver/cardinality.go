@@ -0,0 +1,249 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"go/token"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus-community/promql-langserver/langserver/cache"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql"
+)
+
+// seriesCountTTL is how long series counts are reused for code lenses
+const seriesCountTTL = time.Minute
+
+// seriesCountKey identifies a series request to the Prometheus server
+type seriesCountKey struct {
+	url string
+	// selectors are the sorted selectors of a query, joined by newlines
+	selectors string
+	// evaluationTime is the configured evaluation time, the zero time stands for now
+	evaluationTime time.Time
+}
+
+type seriesCountEntry struct {
+	count   int
+	fetched time.Time
+}
+
+// querySelectors returns the distinct selectors of a query, without offsets
+func querySelectors(query *cache.CompiledQuery) []string {
+	seen := make(map[string]bool)
+
+	var ret []string
+
+	promql.Inspect(query.Ast, func(node promql.Node, _ []promql.Node) error {
+		vs, ok := node.(*promql.VectorSelector)
+		if !ok {
+			return nil
+		}
+
+		selector := *vs
+		selector.Offset = 0
+
+		if key := selector.String(); !seen[key] {
+			seen[key] = true
+
+			ret = append(ret, key)
+		}
+
+		return nil
+	})
+
+	sort.Strings(ret)
+
+	return ret
+}
+
+// cardinalityLens shows the number of series the selectors of a query match together.
+// ok is false for queries without selectors and if the series couldn't be fetched.
+func (s *server) cardinalityLens(ctx context.Context, api v1.API, uri protocol.DocumentURI, query *cache.CompiledQuery, rng protocol.Range) (protocol.CodeLens, bool) {
+	selectors := querySelectors(query)
+	if len(selectors) == 0 {
+		return protocol.CodeLens{}, false
+	}
+
+	count, ok := s.seriesCount(ctx, api, uri, query, selectors)
+	if !ok {
+		return protocol.CodeLens{}, false
+	}
+
+	title := fmt.Sprintf("selects %d series", count)
+	if len(selectors) > 1 {
+		title = fmt.Sprintf("%d selectors select %d series", len(selectors), count)
+	}
+
+	return protocol.CodeLens{
+		Range: rng,
+		// A lens without a command ID is shown as plain text
+		Command: protocol.Command{Title: title},
+	}, true
+}
+
+// seriesCount returns the number of series the sorted selectors match together in the hour before the
+// evaluation time. Counts are cached for seriesCountTTL. ok is false if the series couldn't be fetched.
+func (s *server) seriesCount(ctx context.Context, api v1.API, uri protocol.DocumentURI, query *cache.CompiledQuery, selectors []string) (int, bool) {
+	key := seriesCountKey{
+		url:            s.getPrometheusURLFor(uri),
+		selectors:      strings.Join(selectors, "\n"),
+		evaluationTime: s.getEvaluationTime(query),
+	}
+
+	s.seriesCountMu.Lock()
+	entry, ok := s.seriesCountCache[key]
+	s.seriesCountMu.Unlock()
+
+	if ok && time.Since(entry.fetched) < seriesCountTTL {
+		return entry.count, true
+	}
+
+	end := s.evaluationTimeOrNow(query)
+
+	series, _, err := api.Series(ctx, selectors, end.Add(-seriesLookback), end)
+	if err != nil {
+		s.reportBackendError(err)
+		return 0, false
+	}
+
+	entry = seriesCountEntry{count: len(series), fetched: time.Now()}
+
+	s.seriesCountMu.Lock()
+	defer s.seriesCountMu.Unlock()
+
+	if s.seriesCountCache == nil {
+		s.seriesCountCache = make(map[seriesCountKey]seriesCountEntry)
+	}
+
+	// Drop expired entries, so that the cache doesn't grow while the user edits rules
+	for k, e := range s.seriesCountCache {
+		if time.Since(e.fetched) >= seriesCountTTL {
+			delete(s.seriesCountCache, k)
+		}
+	}
+
+	s.seriesCountCache[key] = entry
+
+	return entry.count, true
+}
+
+// maxNarrowingLabels is the number of labels suggested for narrowing down a selector
+const maxNarrowingLabels = 3
+
+// cardinalityDiagnostics warns about selectors that match more series than the cardinality_threshold option,
+// e.g. {instance="a:9100"} without metric name. The hint lists labels of the selected series the selector
+// doesn't match on yet. Nothing is reported if the option isn't set or no Prometheus server is connected.
+func (s *server) cardinalityDiagnostics(doc *cache.DocumentHandle) []protocol.Diagnostic {
+	threshold := s.getConfig().CardinalityThreshold
+	if threshold <= 0 {
+		return nil
+	}
+
+	api := s.getQueryAPIFor(doc.GetURI())
+	if api == nil {
+		return nil
+	}
+
+	queries, err := doc.GetQueries()
+	if err != nil {
+		return nil
+	}
+
+	var ret []protocol.Diagnostic
+
+	for _, q := range queries {
+		if q.Ast == nil {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(s.lifetime, 5*time.Second)
+
+		promql.Inspect(q.Ast, func(node promql.Node, _ []promql.Node) error {
+			vs, ok := node.(*promql.VectorSelector)
+			if !ok {
+				return nil
+			}
+
+			selector := *vs
+			selector.Offset = 0
+
+			count, ok := s.seriesCount(ctx, api, doc.GetURI(), q, []string{selector.String()})
+			if !ok || count <= threshold {
+				return nil
+			}
+
+			rng, err := tokenRange(doc, q.Pos+token.Pos(vs.PosRange.Start), q.Pos+token.Pos(vs.PosRange.End))
+			if err != nil {
+				return nil
+			}
+
+			msg := fmt.Sprintf("this selector matches about %d series, more than the cardinality_threshold of %d", count, threshold)
+
+			if narrowing := s.narrowingLabels(ctx, doc.GetURI(), q, vs, selector.String()); len(narrowing) > 0 {
+				msg += fmt.Sprintf("; add matchers to narrow it down, e.g. on %s", strings.Join(narrowing, ", "))
+			} else {
+				msg += "; add matchers to narrow it down"
+			}
+
+			ret = append(ret, protocol.Diagnostic{
+				Range:    rng,
+				Severity: 2, // Warning
+				Code:     codeHighCardinality,
+				Source:   "promql-lsp",
+				Message:  msg,
+			})
+
+			return nil
+		})
+
+		cancel()
+	}
+
+	return ret
+}
+
+// narrowingLabels returns labels of the series a selector matches that it has no matcher for yet,
+// starting with the metric name
+func (s *server) narrowingLabels(ctx context.Context, uri protocol.DocumentURI, q *cache.CompiledQuery, vs *promql.VectorSelector, selector string) []string {
+	matched := make(map[string]bool)
+
+	for _, m := range vs.LabelMatchers {
+		matched[m.Name] = true
+	}
+
+	var ret []string
+
+	for _, name := range s.labelValues(ctx, uri, q, selector, "") {
+		if !matched[string(name)] {
+			ret = append(ret, string(name))
+		}
+	}
+
+	sort.SliceStable(ret, func(i int, j int) bool {
+		return ret[i] == labels.MetricName && ret[j] != labels.MetricName
+	})
+
+	if len(ret) > maxNarrowingLabels {
+		ret = ret[:maxNarrowingLabels]
+	}
+
+	return ret
+}
@@ -0,0 +1,78 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestCardinalityDiagnostics checks that selectors matching more series than the threshold are reported
+func TestCardinalityDiagnostics(*testing.T) {
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.URL.Path != "/api/v1/series" {
+			fmt.Fprint(w, `{"status":"success","data":{}}`)
+			return
+		}
+
+		var series []string
+
+		if r.FormValue("match[]") == `{instance="a:9100"}` {
+			for i := 0; i < 5; i++ {
+				series = append(series, fmt.Sprintf(`{"__name__":"metric_%d","instance":"a:9100","job":"node"}`, i))
+			}
+		} else {
+			series = []string{`{"__name__":"up","instance":"a:9100","job":"node"}`}
+		}
+
+		fmt.Fprintf(w, `{"status":"success","data":[%s]}`, strings.Join(series, ","))
+	}))
+	defer prom.Close()
+
+	h, err := NewHeadlessServer(context.Background(), &Config{PrometheusURL: prom.URL, CardinalityThreshold: 3}, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	const query = `{instance="a:9100"} and up{instance="a:9100"}`
+
+	report, err := h.AnalyzeDocument("cardinality.promql", "promql", query)
+	if err != nil {
+		panic(err)
+	}
+
+	var found []string
+
+	for _, d := range report.Diagnostics {
+		if d.Code == codeHighCardinality {
+			found = append(found, d.Message)
+
+			if d.Range.Start.Character != 0 || int(d.Range.End.Character) != strings.Index(query, " and") {
+				panic(fmt.Sprintf("expected the first selector to be marked, got %v", d.Range))
+			}
+		}
+	}
+
+	if len(found) != 1 || !strings.Contains(found[0], "matches about 5 series, more than the cardinality_threshold of 3") ||
+		!strings.Contains(found[0], "e.g. on __name__, job") {
+		panic(fmt.Sprintf("expected the selector without metric name to be reported, got %q", found))
+	}
+}
@@ -0,0 +1,280 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"go/token"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/promql"
+
+	"github.com/prometheus-community/promql-langserver/langserver/cache"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// catalogEntry describes the information a metric catalog provides about a single metric
+type catalogEntry struct {
+	Owner              string   `json:"owner"`
+	Description        string   `json:"description"`
+	SLOLinks           []string `json:"slo_links"`
+	Deprecated         bool     `json:"deprecated"`
+	DeprecationMessage string   `json:"deprecation_message"`
+	// ReplacedBy is the name of the metric that should be used instead of a deprecated one
+	ReplacedBy string `json:"replaced_by"`
+}
+
+// metricCatalog maps metric names to ownership and SLO information
+//
+// The expected JSON format is
+//
+//	{"metrics": {"<metric name>": {"owner": "...", "description": "...", "slo_links": ["..."], "deprecated": false, "deprecation_message": "...", "replaced_by": "..."}}}
+type metricCatalog struct {
+	Metrics map[string]catalogEntry `json:"metrics"`
+}
+
+// catalogTimeout limits the time fetching a metric catalog from a http(s) URL may take
+const catalogTimeout = 30 * time.Second
+
+// loadMetricCatalog reads a metric catalog either from a http(s) URL or from a local file
+func loadMetricCatalog(ctx context.Context, location string) (*metricCatalog, error) {
+	var data []byte
+
+	var err error
+
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		var req *http.Request
+
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid metric catalog URL %s", location)
+		}
+
+		var resp *http.Response
+
+		resp, err = (&http.Client{Timeout: catalogTimeout}).Do(req)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to fetch metric catalog from %s", location)
+		}
+
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("failed to fetch metric catalog from %s: %s", location, resp.Status)
+		}
+
+		data, err = ioutil.ReadAll(resp.Body)
+	} else {
+		data, err = ioutil.ReadFile(location)
+	}
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read metric catalog %s", location)
+	}
+
+	var catalog metricCatalog
+
+	if err = json.Unmarshal(data, &catalog); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse metric catalog %s", location)
+	}
+
+	return &catalog, nil
+}
+
+// connectMetricCatalog replaces the metric catalog. The catalog is loaded without holding the lock,
+// so hovers and diagnostics don't wait for a slow catalog host.
+func (s *server) connectMetricCatalog(location string) error {
+	var catalog *metricCatalog
+
+	defer func() {
+		s.catalogMu.Lock()
+		s.catalog = catalog
+		s.catalogMu.Unlock()
+	}()
+
+	if strings.TrimSpace(location) == "" {
+		return nil
+	}
+
+	if s.getConfig().DemoMode && !strings.HasPrefix(location, "http://") && !strings.HasPrefix(location, "https://") {
+		return fmt.Errorf("the metric catalog %s can't be loaded in demo mode, which doesn't allow reading local files", location)
+	}
+
+	var err error
+
+	catalog, err = loadMetricCatalog(s.lifetime, location)
+
+	return err
+}
+
+// getCatalogEntry returns the catalog information for a metric, if there is any
+func (s *server) getCatalogEntry(metric string) (catalogEntry, bool) {
+	s.catalogMu.RLock()
+	defer s.catalogMu.RUnlock()
+
+	if s.catalog == nil {
+		return catalogEntry{}, false
+	}
+
+	entry, ok := s.catalog.Metrics[metric]
+
+	return entry, ok
+}
+
+func (s *server) getCatalogDocs(metric string) string {
+	entry, ok := s.getCatalogEntry(metric)
+	if !ok {
+		return ""
+	}
+
+	var ret strings.Builder
+
+	if entry.Deprecated {
+		fmt.Fprintf(&ret, "__Deprecated:__ %s\n\n", deprecationMessage(metric, entry))
+	}
+
+	if entry.Description != "" {
+		fmt.Fprintf(&ret, "__Description:__ %s\n\n", entry.Description)
+	}
+
+	if entry.Owner != "" {
+		fmt.Fprintf(&ret, "__Owner:__ %s\n\n", entry.Owner)
+	}
+
+	for _, link := range entry.SLOLinks {
+		fmt.Fprintf(&ret, "[SLO](%s)\n\n", link)
+	}
+
+	return ret.String()
+}
+
+func deprecationMessage(metric string, entry catalogEntry) string {
+	msg := fmt.Sprintf("metric %s is deprecated", metric)
+
+	if entry.Owner != "" {
+		msg = fmt.Sprintf("%s by %s", msg, entry.Owner)
+	}
+
+	if entry.DeprecationMessage != "" {
+		msg = fmt.Sprintf("%s: %s", msg, entry.DeprecationMessage)
+	}
+
+	if entry.ReplacedBy != "" {
+		msg = fmt.Sprintf("%s, use %s instead", msg, entry.ReplacedBy)
+	}
+
+	return msg
+}
+
+// forDeprecatedMetrics calls fn for every selector of a deprecated metric in a document
+func (s *server) forDeprecatedMetrics(doc *cache.DocumentHandle, fn func(*cache.CompiledQuery, *promql.VectorSelector, catalogEntry)) {
+	queries, err := doc.GetQueries()
+	if err != nil {
+		return
+	}
+
+	for _, q := range queries {
+		if q.Ast == nil {
+			continue
+		}
+
+		promql.Inspect(q.Ast, func(node promql.Node, _ []promql.Node) error {
+			vs, ok := node.(*promql.VectorSelector)
+			if !ok {
+				return nil
+			}
+
+			if entry, ok := s.getCatalogEntry(vs.Name); ok && entry.Deprecated {
+				fn(q, vs, entry)
+			}
+
+			return nil
+		})
+	}
+}
+
+// catalogDiagnostics warns about metrics that have been deprecated by their owner.
+// Metrics with a known replacement are reported by catalogFixes instead.
+func (s *server) catalogDiagnostics(doc *cache.DocumentHandle) []protocol.Diagnostic {
+	var ret []protocol.Diagnostic
+
+	s.forDeprecatedMetrics(doc, func(q *cache.CompiledQuery, vs *promql.VectorSelector, entry catalogEntry) {
+		if entry.ReplacedBy != "" {
+			return
+		}
+
+		loc := &cache.Location{Doc: doc, Query: q, Node: vs}
+
+		// Only the metric name is marked, not the label matchers
+		rng, err := getEditRange(loc, vs.Name)
+		if err != nil {
+			return
+		}
+
+		ret = append(ret, protocol.Diagnostic{
+			Range:    rng,
+			Severity: 2, // Warning
+			Code:     fixDeprecatedMetric,
+			Source:   "promql-lsp",
+			Message:  deprecationMessage(vs.Name, entry),
+		})
+	})
+
+	return ret
+}
+
+// catalogFixes offers to replace deprecated metrics by their replacement
+func (s *server) catalogFixes(doc *cache.DocumentHandle) []quickFix {
+	var ret []quickFix
+
+	s.forDeprecatedMetrics(doc, func(q *cache.CompiledQuery, vs *promql.VectorSelector, entry catalogEntry) {
+		if entry.ReplacedBy == "" {
+			return
+		}
+
+		pos := q.Pos + token.Pos(vs.PositionRange().Start)
+		end := pos + token.Pos(len(vs.Name))
+
+		// The metric name might be given as __name__ matcher
+		if name, err := doc.GetSubstring(pos, end); err != nil || name != vs.Name {
+			return
+		}
+
+		rng, err := tokenRange(doc, pos, end)
+		if err != nil {
+			return
+		}
+
+		ret = append(ret, quickFix{
+			Rule:  fixDeprecatedMetric,
+			Title: fmt.Sprintf("Replace %s with %s", vs.Name, entry.ReplacedBy),
+			Diagnostic: protocol.Diagnostic{
+				Range:    rng,
+				Severity: 2, // Warning
+				Code:     fixDeprecatedMetric,
+				Source:   "promql-lsp",
+				Message:  deprecationMessage(vs.Name, entry),
+			},
+			Edits: []tokenEdit{{Pos: pos, End: end, NewText: entry.ReplacedBy}},
+		})
+	})
+
+	return ret
+}
@@ -0,0 +1,105 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus-community/promql-langserver/langserver/cache"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// TestMetricCatalog checks that a metric catalog is shown in hovers and warns about deprecated metrics
+func TestMetricCatalog(*testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"metrics": {
+			"api_requests_total": {"owner": "team-api", "description": "Requests served", "slo_links": ["https://slo.example.com/api"]},
+			"api_calls_total": {"owner": "team-api", "deprecated": true, "deprecation_message": "use api_requests_total"}}}`)
+	}))
+	defer srv.Close()
+
+	catalog, err := loadMetricCatalog(context.Background(), srv.URL)
+	if err != nil {
+		panic(err)
+	}
+
+	if _, err := loadMetricCatalog(context.Background(), "/nonexistent/catalog.json"); err == nil {
+		panic("expected a missing catalog file to be reported")
+	}
+
+	s := &server{catalog: catalog}
+
+	docs := s.getCatalogDocs("api_requests_total")
+	for _, expected := range []string{"__Description:__ Requests served", "__Owner:__ team-api", "[SLO](https://slo.example.com/api)"} {
+		if !strings.Contains(docs, expected) {
+			panic(fmt.Sprintf("expected the catalog docs to contain %q, got %q", expected, docs))
+		}
+	}
+
+	if docs := s.getCatalogDocs("unknown_metric"); docs != "" {
+		panic("expected no catalog docs for an unknown metric, got " + docs)
+	}
+
+	c := &cache.DocumentCache{}
+	c.Init()
+
+	doc, err := c.AddDocument(context.Background(), &protocol.TextDocumentItem{
+		URI:        "test.promql",
+		LanguageID: "promql",
+		Text:       `rate(api_calls_total[5m]) / rate(api_requests_total[5m])`,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	diagnostics := s.catalogDiagnostics(doc)
+	if len(diagnostics) != 1 ||
+		diagnostics[0].Message != "metric api_calls_total is deprecated by team-api: use api_requests_total" ||
+		diagnostics[0].Range.Start.Character != 5 || diagnostics[0].Range.End.Character != 20 {
+		panic(fmt.Sprintf("expected a single warning about api_calls_total, got %v", diagnostics))
+	}
+}
+
+// TestLoadMetricCatalogCancel checks that fetching a metric catalog from a host that doesn't answer can be aborted
+func TestLoadMetricCatalogCancel(*testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+
+	if _, err := loadMetricCatalog(ctx, srv.URL); err == nil {
+		panic("expected loading a metric catalog from a hanging host to fail")
+	}
+
+	if time.Since(start) > 5*time.Second {
+		panic("loading a metric catalog from a hanging host wasn't aborted")
+	}
+}
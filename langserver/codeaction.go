@@ -0,0 +1,43 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// CodeAction returns the code actions available for a range of a document
+// required by the protocol.Server interface
+func (s *server) CodeAction(_ context.Context, params *protocol.CodeActionParams) ([]protocol.CodeAction, error) {
+	doc, err := s.cache.GetDocument(params.TextDocument.URI)
+	if err != nil {
+		return nil, nil
+	}
+
+	var ret []protocol.CodeAction
+
+	ret = append(ret, s.quickFixCodeActions(doc, params.Range)...)
+	ret = append(ret, numberCodeActions(doc, params.Range)...)
+	ret = append(ret, counterCodeActions(doc, params.Range)...)
+	ret = append(ret, blockScalarCodeActions(doc, params.Range)...)
+	ret = escapeJSONCodeActions(doc, ret)
+
+	// The dashboard actions edit the raw JSON source
+	ret = append(ret, dashboardCodeActions(doc, params.Range)...)
+	ret = append(ret, s.organizeCodeActions(doc, params.Context.Only)...)
+
+	return ret, nil
+}
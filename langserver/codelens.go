@@ -0,0 +1,92 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"go/token"
+	"time"
+
+	"github.com/prometheus-community/promql-langserver/langserver/cache"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// codeLensTimeout limits the time spent on the Prometheus requests for the code lenses of a document
+const codeLensTimeout = 10 * time.Second
+
+// CodeLens shows the number of series every query selects and, if evaluate_queries is enabled,
+// its current result above it
+// required by the protocol.Server interface
+func (s *server) CodeLens(ctx context.Context, params *protocol.CodeLensParams) ([]protocol.CodeLens, error) {
+	api := s.getQueryAPIFor(params.TextDocument.URI)
+	if api == nil {
+		return nil, nil
+	}
+
+	evaluate := s.getConfig().EvaluateQueries && !s.queriesDisabled()
+
+	doc, err := s.cache.GetDocument(params.TextDocument.URI)
+	if err != nil {
+		return nil, nil
+	}
+
+	queries, err := doc.GetQueries()
+	if err != nil {
+		return nil, nil
+	}
+
+	// The lenses of rule expressions are shown above the whole rule
+	lensPos := make(map[*cache.CompiledQuery]token.Pos)
+
+	if groups, err := doc.GetRuleGroups(); err == nil {
+		for _, group := range groups {
+			for _, rule := range group.Rules {
+				if rule.Query != nil {
+					lensPos[rule.Query] = rule.Pos
+				}
+			}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, codeLensTimeout)
+	defer cancel()
+
+	lenses := []protocol.CodeLens{}
+
+	for _, query := range queries {
+		if query.Ast == nil || len(query.Err) != 0 {
+			continue
+		}
+
+		pos, ok := lensPos[query]
+		if !ok {
+			pos = query.Pos
+		}
+
+		rng, err := tokenRange(doc, pos, pos)
+		if err != nil {
+			continue
+		}
+
+		if lens, ok := s.cardinalityLens(ctx, api, params.TextDocument.URI, query, rng); ok {
+			lenses = append(lenses, lens)
+		}
+
+		if evaluate {
+			lenses = append(lenses, s.evaluationLens(ctx, api, query, rng))
+		}
+	}
+
+	return lenses, nil
+}
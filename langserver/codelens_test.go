@@ -0,0 +1,94 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// TestCodeLens checks that the cardinality and results of rule expressions are shown above the rules
+func TestCodeLens(*testing.T) {
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.URL.Path {
+		case "/api/v1/query":
+			if query := r.FormValue("query"); query != "up == 0" {
+				panic(fmt.Sprintf("unexpected query %q", query))
+			}
+
+			fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[
+				{"metric":{"job":"a"},"value":[1583020800,"0"]},
+				{"metric":{"job":"b"},"value":[1583020800,"0"]},
+				{"metric":{"job":"c"},"value":[1583020800,"0"]},
+				{"metric":{"job":"d"},"value":[1583020800,"0"]}]}}`)
+		case "/api/v1/series":
+			if match := r.FormValue("match[]"); match != "up" {
+				panic(fmt.Sprintf("unexpected series selector %q", match))
+			}
+
+			fmt.Fprint(w, `{"status":"success","data":[{"__name__":"up","job":"a"},{"__name__":"up","job":"b"}]}`)
+		default:
+			fmt.Fprint(w, `{"status":"success","data":{}}`)
+		}
+	}))
+	defer prom.Close()
+
+	h, err := NewHeadlessServer(context.Background(), &Config{PrometheusURL: prom.URL, EvaluateQueries: true}, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	rules := `groups:
+  - name: example
+    rules:
+      - alert: InstanceDown
+        expr: up == 0
+`
+
+	if err := h.AddDocument("rules.yml", "yaml", rules); err != nil {
+		panic(err)
+	}
+
+	lenses, err := h.server.CodeLens(context.Background(), &protocol.CodeLensParams{
+		TextDocument: protocol.TextDocumentIdentifier{URI: "rules.yml"},
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	if len(lenses) != 2 {
+		panic(fmt.Sprintf("expected two code lenses, got %v", lenses))
+	}
+
+	for i, expected := range []string{
+		"selects 2 series",
+		`4 series: {job="a"} => 0, {job="b"} => 0, {job="c"} => 0, …`,
+	} {
+		if lenses[i].Range.Start.Line != 3 {
+			panic(fmt.Sprintf("expected the code lenses above the rule, got line %v", lenses[i].Range.Start.Line))
+		}
+
+		if lenses[i].Command.Title != expected {
+			panic(fmt.Sprintf("expected %q, got %q", expected, lenses[i].Command.Title))
+		}
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/jsonrpc2"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// The commands supported by ExecuteCommand
+const (
+	commandPreviewAlertTemplates = "promql.previewAlertTemplates"
+	commandPreviewAlertRouting   = "promql.previewAlertRouting"
+)
+
+// supportedCommands is announced to the client during initialization
+var supportedCommands = []string{
+	commandPreviewAlertTemplates,
+	commandPreviewAlertRouting,
+	commandRecordCompletion,
+	commandRunQuery,
+	commandQueryHistory,
+	commandRerunQuery,
+	commandDeleteQueryHistory,
+	commandRecordSnapshot,
+	commandShareQuery,
+	commandImportQuery,
+	commandResolveQuery,
+}
+
+// queryCommands are the commands that execute queries on the Prometheus server, they are disabled in demo and read-only mode
+var queryCommands = map[string]bool{ // nolint: gochecknoglobals
+	commandPreviewAlertTemplates: true,
+	commandRunQuery:              true,
+	commandRerunQuery:            true,
+	commandRecordSnapshot:        true,
+}
+
+// queriesDisabled checks whether executing queries on behalf of the client is disabled
+func (s *server) queriesDisabled() bool {
+	return s.getConfig().DemoMode || s.getConfig().ReadOnly
+}
+
+// commands returns the commands available with the configuration of the server
+func (s *server) commands() []string {
+	if !s.queriesDisabled() {
+		return supportedCommands
+	}
+
+	var ret []string
+
+	for _, command := range supportedCommands {
+		if !queryCommands[command] {
+			ret = append(ret, command)
+		}
+	}
+
+	return ret
+}
+
+// ExecuteCommand runs one of the supportedCommands
+// required by the protocol.Server interface
+func (s *server) ExecuteCommand(ctx context.Context, params *protocol.ExecuteCommandParams) (interface{}, error) {
+	if s.queriesDisabled() && queryCommands[params.Command] {
+		return nil, jsonrpc2.NewErrorf(jsonrpc2.CodeInvalidRequest, "command %q is disabled in demo and read-only mode", params.Command)
+	}
+
+	switch params.Command {
+	case commandPreviewAlertTemplates:
+		var p alertTemplatePreviewParams
+		if err := decodeCommandArgument(params, &p); err != nil {
+			return nil, err
+		}
+
+		return s.previewAlertTemplates(ctx, &p)
+	case commandPreviewAlertRouting:
+		var p alertRoutingPreviewParams
+		if err := decodeCommandArgument(params, &p); err != nil {
+			return nil, err
+		}
+
+		return s.previewAlertRouting(&p)
+	case commandRecordCompletion:
+		return nil, s.recordCompletion(params)
+	case commandRunQuery:
+		var p runQueryParams
+		if err := decodeCommandArgument(params, &p); err != nil {
+			return nil, err
+		}
+
+		return s.runQuery(ctx, &p)
+	case commandQueryHistory:
+		return s.history.list(), nil
+	case commandRerunQuery:
+		var p historyEntryParams
+		if err := decodeCommandArgument(params, &p); err != nil {
+			return nil, err
+		}
+
+		return s.rerunQuery(ctx, &p)
+	case commandDeleteQueryHistory:
+		var p historyEntryParams
+		if err := decodeCommandArgument(params, &p); err != nil {
+			return nil, err
+		}
+
+		return nil, s.history.delete(p.ID)
+	case commandRecordSnapshot:
+		var p snapshotParams
+		if err := decodeCommandArgument(params, &p); err != nil {
+			return nil, err
+		}
+
+		return s.recordSnapshotCommand(ctx, &p)
+	case commandShareQuery:
+		var p shareQueryParams
+		if err := decodeCommandArgument(params, &p); err != nil {
+			return nil, err
+		}
+
+		return s.shareQuery(ctx, &p)
+	case commandImportQuery:
+		var p importQueryParams
+		if err := decodeCommandArgument(params, &p); err != nil {
+			return nil, err
+		}
+
+		return s.importQuery(ctx, &p)
+	case commandResolveQuery:
+		var p resolveQueryParams
+		if err := decodeCommandArgument(params, &p); err != nil {
+			return nil, err
+		}
+
+		return s.resolveQuery(ctx, &p)
+	default:
+		return nil, jsonrpc2.NewErrorf(jsonrpc2.CodeInvalidParams, "unknown command %q", params.Command)
+	}
+}
+
+// decodeCommandArgument decodes the first argument of a command, which all commands take
+// their parameters from
+func decodeCommandArgument(params *protocol.ExecuteCommandParams, v interface{}) error {
+	if len(params.Arguments) < 1 {
+		return jsonrpc2.NewErrorf(jsonrpc2.CodeInvalidParams, "command %q expects an argument", params.Command)
+	}
+
+	return decodeParams(params.Arguments[0], v)
+}
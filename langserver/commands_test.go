@@ -0,0 +1,75 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// TestDemoMode checks that commands executing queries and local metric catalogs are rejected in demo mode
+func TestDemoMode(*testing.T) {
+	h, err := NewHeadlessServer(context.Background(), &Config{DemoMode: true}, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	for _, command := range h.server.commands() {
+		if command == commandPreviewAlertTemplates {
+			panic(fmt.Sprintf("unexpected commands in demo mode: %v", h.server.commands()))
+		}
+	}
+
+	if _, err := h.server.ExecuteCommand(context.Background(), &protocol.ExecuteCommandParams{
+		Command:   commandPreviewAlertTemplates,
+		Arguments: []interface{}{map[string]interface{}{}},
+	}); err == nil {
+		panic("expected alert template previews to be disabled in demo mode")
+	}
+
+	if err := h.server.connectMetricCatalog("/etc/passwd"); err == nil {
+		panic("expected local metric catalogs to be rejected in demo mode")
+	}
+}
+
+// TestReadOnlyMode checks that queries aren't executed on behalf of clients in read-only mode
+func TestReadOnlyMode(*testing.T) {
+	h, err := NewHeadlessServer(context.Background(), &Config{ReadOnly: true, EvaluateQueries: true}, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	if _, err := h.server.ExecuteCommand(context.Background(), &protocol.ExecuteCommandParams{
+		Command:   commandPreviewAlertTemplates,
+		Arguments: []interface{}{map[string]interface{}{}},
+	}); err == nil {
+		panic("expected alert template previews to be disabled in read-only mode")
+	}
+
+	if err := h.AddDocument("query.promql", "promql", "up"); err != nil {
+		panic(err)
+	}
+
+	lenses, err := h.server.CodeLens(context.Background(), &protocol.CodeLensParams{
+		TextDocument: protocol.TextDocumentIdentifier{URI: "query.promql"},
+	})
+	if err != nil || lenses != nil {
+		panic(fmt.Sprintf("expected no query evaluation in read-only mode, got %v, %v", lenses, err))
+	}
+}
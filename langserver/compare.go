@@ -0,0 +1,302 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"fmt"
+	"go/token"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/prometheus-community/promql-langserver/langserver/cache"
+	"github.com/prometheus/prometheus/promql"
+	"gopkg.in/yaml.v3"
+)
+
+// RuleComparison is the semantic difference between two versions of a rule file
+type RuleComparison struct {
+	Added   []RuleSummary `json:"added"`
+	Removed []RuleSummary `json:"removed"`
+	Changed []RuleChange  `json:"changed"`
+}
+
+// RuleSummary identifies a rule in one version of a rule file
+type RuleSummary struct {
+	Group string `json:"group"`
+	// Kind is either "record" or "alert"
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	Expr string `json:"expr"`
+	// Line is the one based line the rule starts at
+	Line int `json:"line"`
+}
+
+// RuleChange describes a rule that exists in both versions of a rule file, but differs
+type RuleChange struct {
+	Old RuleSummary `json:"old"`
+	New RuleSummary `json:"new"`
+	// Fields are the fields of the rule that changed, e.g. expr or labels
+	Fields []string `json:"fields"`
+	// ExprChanges are the smallest subexpressions of the expression that changed
+	ExprChanges []ExprChange `json:"exprChanges,omitempty"`
+}
+
+// ExprChange is a subexpression that was replaced, both versions are in canonical form
+type ExprChange struct {
+	Old string `json:"old"`
+	New string `json:"new"`
+	// Line and Column are the one based position of the new subexpression
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// ruleKey identifies a rule across versions of a rule file. Rules with the same name in the
+// same group, e.g. alerts with different severities, are told apart by their order.
+type ruleKey struct {
+	group string
+	kind  string
+	name  string
+	n     int
+}
+
+// CompareRuleFiles compares the rules of two documents that have been added to the server.
+// Expressions are compared after parsing, so changes in formatting are ignored.
+func (h HeadlessServer) CompareRuleFiles(oldURI string, newURI string) (*RuleComparison, error) {
+	oldRules, err := h.server.documentRules(oldURI)
+	if err != nil {
+		return nil, err
+	}
+
+	newRules, err := h.server.documentRules(newURI)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &RuleComparison{
+		Added:   []RuleSummary{},
+		Removed: []RuleSummary{},
+		Changed: []RuleChange{},
+	}
+
+	for key, o := range oldRules {
+		n, ok := newRules[key]
+		if !ok {
+			ret.Removed = append(ret.Removed, o.summary)
+			continue
+		}
+
+		if change := compareRules(o, n); change != nil {
+			ret.Changed = append(ret.Changed, *change)
+		}
+	}
+
+	for key, n := range newRules {
+		if _, ok := oldRules[key]; !ok {
+			ret.Added = append(ret.Added, n.summary)
+		}
+	}
+
+	sort.Slice(ret.Added, func(i, j int) bool { return ret.Added[i].Line < ret.Added[j].Line })
+	sort.Slice(ret.Removed, func(i, j int) bool { return ret.Removed[i].Line < ret.Removed[j].Line })
+	sort.Slice(ret.Changed, func(i, j int) bool { return ret.Changed[i].New.Line < ret.Changed[j].New.Line })
+
+	return ret, nil
+}
+
+// comparedRule is a rule together with the document it was found in
+type comparedRule struct {
+	doc     *cache.DocumentHandle
+	rule    *cache.Rule
+	summary RuleSummary
+}
+
+// documentRules returns the rules of a document by their ruleKey
+func (s *server) documentRules(uri string) (map[ruleKey]*comparedRule, error) {
+	doc, err := s.cache.GetDocument(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	groups, err := doc.GetRuleGroups()
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make(map[ruleKey]*comparedRule)
+
+	for _, group := range groups {
+		for _, rule := range group.Rules {
+			key := ruleKey{group: group.Name, kind: "record", name: rule.Name()}
+			if rule.Alert != "" {
+				key.kind = "alert"
+			}
+
+			for ret[key] != nil {
+				key.n++
+			}
+
+			line := 0
+			if pos, err := doc.PosToProtocolPosition(rule.Pos); err == nil {
+				line = int(pos.Line) + 1
+			}
+
+			ret[key] = &comparedRule{
+				doc:  doc,
+				rule: rule,
+				summary: RuleSummary{
+					Group: group.Name,
+					Kind:  key.kind,
+					Name:  key.name,
+					Expr:  ruleExprText(rule),
+					Line:  line,
+				},
+			}
+		}
+	}
+
+	return ret, nil
+}
+
+// ruleExprText returns the expression of a rule as written in the rule file
+func ruleExprText(rule *cache.Rule) string {
+	if rule.Query != nil {
+		return strings.TrimSpace(rule.Query.Content)
+	}
+
+	if expr := cache.MappingValue(rule.Node, "expr"); expr != nil {
+		return strings.TrimSpace(expr.Value)
+	}
+
+	return ""
+}
+
+// stringMapping returns a yaml mapping of strings, e.g. the annotations of a rule, as map
+func stringMapping(node *yaml.Node) map[string]string {
+	ret := make(map[string]string)
+
+	if node == nil || node.Kind != yaml.MappingNode {
+		return ret
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		ret[node.Content[i].Value] = node.Content[i+1].Value
+	}
+
+	return ret
+}
+
+// compareRules returns how a rule changed, or nil if it didn't
+func compareRules(o *comparedRule, n *comparedRule) *RuleChange {
+	change := &RuleChange{Old: o.summary, New: n.summary}
+
+	oldQuery, newQuery := o.rule.Query, n.rule.Query
+
+	switch {
+	case oldQuery != nil && newQuery != nil && oldQuery.Ast != nil && newQuery.Ast != nil &&
+		len(oldQuery.Err) == 0 && len(newQuery.Err) == 0:
+		if oldQuery.Ast.String() != newQuery.Ast.String() {
+			change.Fields = append(change.Fields, "expr")
+			change.ExprChanges = exprChanges(n.doc, newQuery, oldQuery.Ast, newQuery.Ast)
+		}
+	case o.summary.Expr != n.summary.Expr:
+		change.Fields = append(change.Fields, "expr")
+	}
+
+	if o.rule.For != n.rule.For {
+		change.Fields = append(change.Fields, "for")
+	}
+
+	if !reflect.DeepEqual(stringMapping(cache.MappingValue(o.rule.Node, "labels")), stringMapping(cache.MappingValue(n.rule.Node, "labels"))) {
+		change.Fields = append(change.Fields, "labels")
+	}
+
+	if !reflect.DeepEqual(stringMapping(cache.MappingValue(o.rule.Node, "annotations")),
+		stringMapping(cache.MappingValue(n.rule.Node, "annotations"))) {
+		change.Fields = append(change.Fields, "annotations")
+	}
+
+	if len(change.Fields) == 0 {
+		return nil
+	}
+
+	return change
+}
+
+// exprChanges returns the smallest subexpressions that differ between two versions of an expression.
+// Nodes whose own attributes, e.g. the operator of a binary expression, are unchanged are
+// compared child by child.
+func exprChanges(newDoc *cache.DocumentHandle, newQuery *cache.CompiledQuery, o promql.Node, n promql.Node) []ExprChange {
+	if o.String() == n.String() {
+		return nil
+	}
+
+	oldChildren, newChildren := promql.Children(o), promql.Children(n)
+
+	if sameNodeAttributes(o, n) && len(oldChildren) == len(newChildren) {
+		var ret []ExprChange
+
+		for i := range oldChildren {
+			ret = append(ret, exprChanges(newDoc, newQuery, oldChildren[i], newChildren[i])...)
+		}
+
+		if len(ret) > 0 {
+			return ret
+		}
+	}
+
+	// The canonical form is used, since the position ranges of aggregations can include
+	// closing parentheses of enclosing functions
+	change := ExprChange{
+		Old: o.String(),
+		New: n.String(),
+	}
+
+	if pos, err := newDoc.PosToProtocolPosition(newQuery.Pos + token.Pos(n.PositionRange().Start)); err == nil {
+		change.Line, change.Column = int(pos.Line)+1, int(pos.Character)+1
+	}
+
+	return []ExprChange{change}
+}
+
+// sameNodeAttributes checks whether two nodes have the same type and only differ in their children
+func sameNodeAttributes(o promql.Node, n promql.Node) bool {
+	switch o := o.(type) {
+	case *promql.AggregateExpr:
+		n, ok := n.(*promql.AggregateExpr)
+		return ok && o.Op == n.Op && o.Without == n.Without &&
+			fmt.Sprint(o.Grouping) == fmt.Sprint(n.Grouping) && (o.Param == nil) == (n.Param == nil)
+	case *promql.BinaryExpr:
+		n, ok := n.(*promql.BinaryExpr)
+		return ok && o.Op == n.Op && o.ReturnBool == n.ReturnBool && reflect.DeepEqual(o.VectorMatching, n.VectorMatching)
+	case *promql.Call:
+		n, ok := n.(*promql.Call)
+		return ok && o.Func.Name == n.Func.Name
+	case *promql.MatrixSelector:
+		n, ok := n.(*promql.MatrixSelector)
+		return ok && o.Range == n.Range
+	case *promql.SubqueryExpr:
+		n, ok := n.(*promql.SubqueryExpr)
+		return ok && o.Range == n.Range && o.Step == n.Step && o.Offset == n.Offset
+	case *promql.ParenExpr:
+		_, ok := n.(*promql.ParenExpr)
+		return ok
+	case *promql.UnaryExpr:
+		n, ok := n.(*promql.UnaryExpr)
+		return ok && o.Op == n.Op
+	}
+
+	// Selectors and literals have no children
+	return false
+}
@@ -0,0 +1,94 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// TestCompareRuleFiles checks the semantic comparison of two versions of a rule file
+func TestCompareRuleFiles(*testing.T) {
+	h, err := NewHeadlessServer(context.Background(), &Config{}, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	const oldRules = `groups:
+- name: example
+  rules:
+  - record: job:errors:rate5m
+    expr: sum by (job) (rate(errors_total[5m]))
+  - record: job:requests:rate5m
+    expr: sum by(job)(rate(requests_total[5m]))
+  - alert: HighErrors
+    expr: job:errors:rate5m > 1
+  - alert: Removed
+    expr: up == 0
+`
+
+	const newRules = `groups:
+- name: example
+  rules:
+  - record: job:errors:rate5m
+    expr: sum by (job) (rate(errors_total[10m]))
+  - record: job:requests:rate5m
+    expr: sum by (job) (rate(requests_total[5m]))
+  - alert: HighErrors
+    expr: job:errors:rate5m > 1
+    for: 5m
+    labels:
+      severity: page
+  - alert: Added
+    expr: up == 0
+`
+
+	if err := h.AddDocument("old.yml", "yaml", oldRules); err != nil {
+		panic(err)
+	}
+
+	if err := h.AddDocument("new.yml", "yaml", newRules); err != nil {
+		panic(err)
+	}
+
+	c, err := h.CompareRuleFiles("old.yml", "new.yml")
+	if err != nil {
+		panic(err)
+	}
+
+	if len(c.Added) != 1 || c.Added[0].Name != "Added" || c.Added[0].Line != 13 {
+		panic(fmt.Sprintf("unexpected added rules: %+v", c.Added))
+	}
+
+	if len(c.Removed) != 1 || c.Removed[0].Name != "Removed" {
+		panic(fmt.Sprintf("unexpected removed rules: %+v", c.Removed))
+	}
+
+	// Formatting changes are ignored
+	if len(c.Changed) != 2 {
+		panic(fmt.Sprintf("unexpected changed rules: %+v", c.Changed))
+	}
+
+	expected := []ExprChange{{Old: "errors_total[5m]", New: "errors_total[10m]", Line: 5, Column: 30}}
+	if !reflect.DeepEqual(c.Changed[0].Fields, []string{"expr"}) || !reflect.DeepEqual(c.Changed[0].ExprChanges, expected) {
+		panic(fmt.Sprintf("unexpected expression change: %+v", c.Changed[0]))
+	}
+
+	if !reflect.DeepEqual(c.Changed[1].Fields, []string{"for", "labels"}) {
+		panic(fmt.Sprintf("unexpected rule change: %+v", c.Changed[1]))
+	}
+}
@@ -26,7 +26,9 @@ import (
 
 	"github.com/prometheus-community/promql-langserver/langserver/cache"
 	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
 	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
 	"github.com/prometheus/prometheus/promql"
 	"github.com/prometheus/prometheus/util/strutil"
 )
@@ -34,6 +36,24 @@ import (
 // Completion is required by the protocol.Server interface
 // nolint: wsl
 func (s *server) Completion(ctx context.Context, params *protocol.CompletionParams) (ret *protocol.CompletionList, err error) {
+	if doc, docErr := s.cache.GetDocument(params.TextDocument.URI); docErr == nil {
+		if items := durationCompletion(doc, params.Position); items != nil {
+			return &protocol.CompletionList{Items: items}, nil
+		}
+
+		if items := s.alertTemplateCompletion(doc, params.Position); items != nil {
+			return &protocol.CompletionList{Items: items}, nil
+		}
+
+		if items := subqueryStepCompletion(doc, params.Position); items != nil {
+			return &protocol.CompletionList{Items: items}, nil
+		}
+
+		if items := s.atModifierCompletion(doc, params.Position); items != nil {
+			return &protocol.CompletionList{Items: items}, nil
+		}
+	}
+
 	location, err := s.cache.Find(&params.TextDocumentPositionParams)
 	if err != nil {
 		return nil, nil
@@ -82,38 +102,55 @@ func (s *server) Completion(ctx context.Context, params *protocol.CompletionPara
 				return
 			}
 		} else {
-			if err = s.completeLabels(ctx, completions, location, metricName); err != nil {
+			if err = s.completeLabels(ctx, completions, location, n); err != nil {
 				return
 			}
 		}
 	case *promql.AggregateExpr, *promql.BinaryExpr:
-		if err = s.completeLabels(ctx, completions, location, ""); err != nil {
+		if err = s.completeLabels(ctx, completions, location, nil); err != nil {
 			return
 		}
 	}
 
+	if quote := embeddedStringQuote(location.Doc, location.Query.Pos); quote != 0 {
+		escapeJSONCompletions(location.Doc, quote, ret.Items)
+	}
+
 	return //nolint: nakedret
 }
 
-// nolint:funlen
-func (s *server) completeMetricName(ctx context.Context, completions *[]protocol.CompletionItem, location *cache.Location, metricName string) error {
-	api := s.getPrometheus()
+// escapeJSONCompletions adapts completions to queries embedded in string literals,
+// e.g. of Grafana dashboards
+func escapeJSONCompletions(doc *cache.DocumentHandle, quote byte, items []protocol.CompletionItem) {
+	for i := range items {
+		if items[i].InsertText != "" {
+			items[i].InsertText = escapeString(items[i].InsertText, quote)
+		}
 
-	var allNames model.LabelValues
+		if items[i].TextEdit != nil {
+			edits := []protocol.TextEdit{*items[i].TextEdit}
+			escapeJSONEdits(doc, edits)
+			items[i].TextEdit = &edits[0]
+		}
 
-	if api != nil {
-		var err error
+		escapeJSONEdits(doc, items[i].AdditionalTextEdits)
+	}
+}
 
-		allNames, _, err = api.LabelValues(ctx, "__name__")
-		if err != nil {
-			// nolint: errcheck
-			s.client.LogMessage(s.lifetime, &protocol.LogMessageParams{
-				Type:    protocol.Error,
-				Message: errors.Wrapf(err, "could not get metric data from Prometheus").Error(),
-			})
+// nolint:funlen
+func (s *server) completeMetricName(ctx context.Context, completions *[]protocol.CompletionItem, location *cache.Location, metricName string) error {
+	snapshot := s.metadataFor(location.Doc.GetURI())
 
-			allNames = nil
-		}
+	var (
+		allNames model.LabelValues
+		origins  map[model.LabelValue][]string
+	)
+
+	if snapshot != nil && len(s.getConfig().MergeMetadata) == 0 {
+		allNames = snapshot.metricNames
+	} else {
+		// Metric names are cached like label values, they are requested on every keystroke
+		allNames, origins = s.mergedLabelValues(ctx, location.Doc.GetURI(), location.Query, "", "__name__")
 	}
 
 	editRange, err := getEditRange(location, metricName)
@@ -125,17 +162,44 @@ func (s *server) completeMetricName(ctx context.Context, completions *[]protocol
 		if strings.HasPrefix(string(name), metricName) {
 			item := protocol.CompletionItem{
 				Label:    string(name),
-				SortText: "__3__" + string(name),
+				SortText: s.metricSortText(string(name)),
 				Kind:     12, //Value
 				TextEdit: &protocol.TextEdit{
 					Range:   editRange,
 					NewText: string(name),
 				},
+				Command: recordCompletionCommand(string(name)),
+			}
+
+			if snapshot != nil {
+				item.Detail = snapshot.help(string(name))
 			}
+
+			item.Detail = withOrigins(item.Detail, origins[name])
+
 			*completions = append(*completions, item)
 		}
 	}
 
+	for _, family := range s.localMetricFamilies() {
+		for _, name := range family.SampleNames {
+			if strings.HasPrefix(name, metricName) {
+				item := protocol.CompletionItem{
+					Label:    name,
+					SortText: s.metricSortText(name),
+					Kind:     12, //Value
+					Detail:   family.Help,
+					TextEdit: &protocol.TextEdit{
+						Range:   editRange,
+						NewText: name,
+					},
+					Command: recordCompletionCommand(name),
+				}
+				*completions = append(*completions, item)
+			}
+		}
+	}
+
 	queries, err := location.Doc.GetQueries()
 	if err != nil {
 		return err
@@ -145,13 +209,14 @@ func (s *server) completeMetricName(ctx context.Context, completions *[]protocol
 		if rec := q.Record; rec != "" && strings.HasPrefix(rec, metricName) {
 			item := protocol.CompletionItem{
 				Label:            rec,
-				SortText:         "__2__" + rec,
+				SortText:         "__2__" + s.frequencies.sortKey(rec) + rec,
 				Kind:             3, //Value
 				InsertTextFormat: 2, //Snippet
 				TextEdit: &protocol.TextEdit{
 					Range:   editRange,
 					NewText: rec,
 				},
+				Command: recordCompletionCommand(rec),
 			}
 			*completions = append(*completions, item)
 		}
@@ -222,8 +287,9 @@ var aggregators = map[string]string{ // nolint:gochecknoglobals
 	"quantile":     "calculate φ-quantile (0 ≤ φ ≤ 1) over dimensions",
 }
 
+// completeLabels completes label names and values. selector is the enclosing vector selector, if there is one.
 // nolint: funlen
-func (s *server) completeLabels(ctx context.Context, completions *[]protocol.CompletionItem, location *cache.Location, metricName string) error {
+func (s *server) completeLabels(ctx context.Context, completions *[]protocol.CompletionItem, location *cache.Location, selector *promql.VectorSelector) error {
 	offset := location.Node.PositionRange().Start
 	l := promql.Lex(location.Query.Content[offset:])
 
@@ -289,38 +355,63 @@ func (s *server) completeLabels(ctx context.Context, completions *[]protocol.Com
 
 	if isLabel {
 		loc.Node = &item
-		return s.completeLabel(ctx, completions, &loc, metricName)
+		return s.completeLabel(ctx, completions, &loc, selector)
 	}
 
 	if item.Typ == promql.COMMA || item.Typ == promql.LEFT_PAREN || item.Typ == promql.LEFT_BRACE {
 		loc.Node = &promql.Item{Pos: item.Pos + 1}
-		return s.completeLabel(ctx, completions, &loc, metricName)
+		return s.completeLabel(ctx, completions, &loc, selector)
 	}
 
 	if isValue && lastLabel != "" {
 		loc.Node = &item
-		return s.completeLabelValue(ctx, completions, &loc, lastLabel)
+		return s.completeLabelValue(ctx, completions, &loc, seriesMatcher(selector, lastLabel), lastLabel)
 	}
 
 	if item.Typ == promql.EQL || item.Typ == promql.NEQ {
 		loc.Node = &promql.Item{Pos: item.Pos + promql.Pos(len(item.Val))}
-		return s.completeLabelValue(ctx, completions, &loc, lastLabel)
+		return s.completeLabelValue(ctx, completions, &loc, seriesMatcher(selector, lastLabel), lastLabel)
 	}
 
 	return nil
 }
 
 // nolint:funlen, unparam
-func (s *server) completeLabel(ctx context.Context, completions *[]protocol.CompletionItem, location *cache.Location, metricName string) error {
-	api := s.getPrometheus()
+func (s *server) completeLabel(ctx context.Context, completions *[]protocol.CompletionItem, location *cache.Location, selector *promql.VectorSelector) error {
+	api := s.getQueryAPIFor(location.Doc.GetURI())
+
+	prefix := location.Node.(*promql.Item).Val
+
+	match := seriesMatcher(selector, prefix)
+
+	var (
+		allNames []string
+		origins  map[model.LabelValue][]string
+	)
+
+	snapshot := s.metadataFor(location.Doc.GetURI())
+
+	switch {
+	case len(s.getConfig().MergeMetadata) > 0:
+		var names model.LabelValues
+
+		names, origins = s.mergedLabelValues(ctx, location.Doc.GetURI(), location.Query, match, "")
 
-	var allNames []string
+		for _, name := range names {
+			allNames = append(allNames, string(name))
+		}
+	case snapshot != nil && match == "":
+		allNames = snapshot.labelNames
+	case api != nil:
+		var (
+			warnings v1.Warnings
+			err      error
+		)
 
-	if api != nil {
-		var err error
+		if match == "" {
+			allNames, warnings, err = api.LabelNames(ctx)
+			s.reportWarnings(warnings)
 
-		if metricName == "" {
-			allNames, _, err = api.LabelNames(ctx)
 			if err != nil {
 				// nolint: errcheck
 				s.client.LogMessage(s.lifetime, &protocol.LogMessageParams{
@@ -328,6 +419,8 @@ func (s *server) completeLabel(ctx context.Context, completions *[]protocol.Comp
 					Message: errors.Wrapf(err, "could not get label data from prometheus").Error(),
 				})
 
+				s.reportBackendError(err)
+
 				allNames = nil
 			}
 		} else {
@@ -341,7 +434,11 @@ func (s *server) completeLabel(ctx context.Context, completions *[]protocol.Comp
 
 				allNames = nil
 			}
-			results, _, err := api.Series(ctx, []string{metricName}, time.Now().Add(-duration), time.Now())
+			end := s.evaluationTimeOrNow(location.Query)
+
+			results, warnings, err := api.Series(ctx, []string{match}, end.Add(-duration), end)
+			s.reportWarnings(warnings)
+
 			if err != nil {
 				// nolint: errcheck
 				s.client.LogMessage(s.lifetime, &protocol.LogMessageParams{
@@ -349,6 +446,8 @@ func (s *server) completeLabel(ctx context.Context, completions *[]protocol.Comp
 					Message: errors.Wrapf(err, "could not get label data from prometheus").Error(),
 				})
 
+				s.reportBackendError(err)
+
 				allNames = nil
 			}
 
@@ -367,16 +466,28 @@ func (s *server) completeLabel(ctx context.Context, completions *[]protocol.Comp
 		return err
 	}
 
+	// Labels that are already matched by the selector aren't suggested again
+	used := map[string]bool{labels.MetricName: selector != nil}
+
+	if selector != nil {
+		for _, m := range selector.LabelMatchers {
+			if m != nil && m.Name != prefix {
+				used[m.Name] = true
+			}
+		}
+	}
+
 	for i, name := range allNames {
 		// Skip duplicates
-		if i > 0 && allNames[i-1] == name {
+		if (i > 0 && allNames[i-1] == name) || used[name] {
 			continue
 		}
 
-		if strings.HasPrefix(name, location.Node.(*promql.Item).Val) {
+		if strings.HasPrefix(name, prefix) {
 			item := protocol.CompletionItem{
-				Label: name,
-				Kind:  12, //Value
+				Label:  name,
+				Kind:   12, //Value
+				Detail: withOrigins("", origins[model.LabelValue(name)]),
 				TextEdit: &protocol.TextEdit{
 					Range:   editRange,
 					NewText: name,
@@ -391,23 +502,8 @@ func (s *server) completeLabel(ctx context.Context, completions *[]protocol.Comp
 }
 
 // nolint: funlen
-func (s *server) completeLabelValue(ctx context.Context, completions *[]protocol.CompletionItem, location *cache.Location, labelName string) error {
-	var allNames model.LabelValues
-
-	api := s.getPrometheus()
-
-	if api != nil {
-		var err error
-
-		allNames, _, err = api.LabelValues(ctx, labelName)
-		if err != nil {
-			// nolint: errcheck
-			s.client.LogMessage(s.lifetime, &protocol.LogMessageParams{
-				Type:    protocol.Error,
-				Message: errors.Wrapf(err, "could not get label value data from Prometheus").Error(),
-			})
-		}
-	}
+func (s *server) completeLabelValue(ctx context.Context, completions *[]protocol.CompletionItem, location *cache.Location, match string, labelName string) error {
+	allNames, origins := s.mergedLabelValues(ctx, location.Doc.GetURI(), location.Query, match, labelName)
 
 	editRange, err := getEditRange(location, "")
 	if err != nil {
@@ -456,8 +552,9 @@ func (s *server) completeLabelValue(ctx context.Context, completions *[]protocol
 			}
 
 			item := protocol.CompletionItem{
-				Label: quoted,
-				Kind:  12, //Value
+				Label:  quoted,
+				Kind:   12, //Value
+				Detail: withOrigins("", origins[name]),
 				TextEdit: &protocol.TextEdit{
 					Range:   editRange,
 					NewText: quoted,
@@ -470,6 +567,37 @@ func (s *server) completeLabelValue(ctx context.Context, completions *[]protocol
 	return nil
 }
 
+// seriesMatcher returns a selector for the series a vector selector matches, leaving out incomplete matchers
+// and those of the label that is being completed. It returns "" if the remaining matchers don't restrict the series.
+func seriesMatcher(vs *promql.VectorSelector, completed string) string {
+	if vs == nil {
+		return ""
+	}
+
+	ret := &promql.VectorSelector{Name: vs.Name}
+
+	restricted := false
+
+	for _, m := range vs.LabelMatchers {
+		if m == nil || (m.Name == completed && m.Name != labels.MetricName) {
+			continue
+		}
+
+		ret.LabelMatchers = append(ret.LabelMatchers, m)
+
+		// Prometheus rejects selectors that only consist of matchers matching the empty string
+		if !m.Matches("") {
+			restricted = true
+		}
+	}
+
+	if !restricted {
+		return ""
+	}
+
+	return ret.String()
+}
+
 // getEditRange computes the editRange for a completion. In case the completion area is shorter than
 // the node, the oldname of the token to be completed must be provided. The latter mechanism only
 // works if oldname is an ASCII string, which can be safely assumed for metric and function names.
@@ -0,0 +1,79 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// TestLabelNameCompletion checks that label names are scoped to the series of the enclosing selector
+func TestLabelNameCompletion(*testing.T) {
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.URL.Path {
+		case "/api/v1/series":
+			if match := r.FormValue("match[]"); match != `http_requests_total{job="api"}` {
+				panic("unexpected series selector " + match)
+			}
+
+			fmt.Fprint(w, `{"status":"success","data":[
+				{"__name__":"http_requests_total","job":"api","code":"500"},
+				{"__name__":"http_requests_total","job":"api","code":"200","method":"GET"}]}`)
+		case "/api/v1/labels":
+			panic("expected label names to be scoped to the selector")
+		default:
+			fmt.Fprint(w, `{"status":"success","data":{}}`)
+		}
+	}))
+	defer prom.Close()
+
+	h, err := NewHeadlessServer(context.Background(), &Config{PrometheusURL: prom.URL}, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	query := `http_requests_total{job="api", }`
+
+	if err := h.AddDocument("query.promql", "promql", query); err != nil {
+		panic(err)
+	}
+
+	list, err := h.server.Completion(context.Background(), &protocol.CompletionParams{
+		TextDocumentPositionParams: protocol.TextDocumentPositionParams{
+			TextDocument: protocol.TextDocumentIdentifier{URI: "query.promql"},
+			Position:     protocol.Position{Line: 0, Character: float64(len(query) - 1)},
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	var got []string
+
+	for _, item := range list.Items {
+		got = append(got, item.Label)
+	}
+
+	if fmt.Sprint(got) != "[code method]" {
+		panic(fmt.Sprintf("expected the labels of http_requests_total{job=\"api\"} that aren't matched yet, got %v", got))
+	}
+}
@@ -0,0 +1,230 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/api"
+)
+
+// Default limits of concurrent requests to a single datasource
+const (
+	defaultMetadataRequests = 8
+	defaultQueryRequests    = 4
+)
+
+// ConcurrencyConfig limits the number of concurrent requests to a datasource. The limits are shared by all
+// sessions of the process, i.e. all clients of a websocket server or all requests to the REST API.
+type ConcurrencyConfig struct {
+	// MetadataRequests limits requests for labels, series and metadata, 8 by default
+	MetadataRequests int `yaml:"metadata_requests"`
+	// QueryRequests limits the execution of queries, 4 by default
+	QueryRequests int `yaml:"query_requests"`
+}
+
+func (c *ConcurrencyConfig) limits() (metadata int, query int) {
+	metadata, query = defaultMetadataRequests, defaultQueryRequests
+
+	if c == nil {
+		return
+	}
+
+	if c.MetadataRequests > 0 {
+		metadata = c.MetadataRequests
+	}
+
+	if c.QueryRequests > 0 {
+		query = c.QueryRequests
+	}
+
+	return
+}
+
+// fairLimiter limits the number of concurrent holders. Waiting sessions are served in turns,
+// so that a session sending many requests can't starve the others.
+type fairLimiter struct {
+	mu     sync.Mutex
+	limit  int
+	active int
+	// waiting holds the queue of every session with waiting requests
+	waiting map[interface{}][]chan struct{}
+	// turns is the order waiting sessions are served in
+	turns []interface{}
+}
+
+func newFairLimiter(limit int) *fairLimiter {
+	return &fairLimiter{limit: limit, waiting: make(map[interface{}][]chan struct{})}
+}
+
+// acquire blocks until session may send a request or ctx expires
+func (l *fairLimiter) acquire(ctx context.Context, session interface{}) error {
+	l.mu.Lock()
+
+	if l.active < l.limit && len(l.turns) == 0 {
+		l.active++
+		l.mu.Unlock()
+
+		return nil
+	}
+
+	granted := make(chan struct{})
+
+	if len(l.waiting[session]) == 0 {
+		l.turns = append(l.turns, session)
+	}
+
+	l.waiting[session] = append(l.waiting[session], granted)
+
+	l.mu.Unlock()
+
+	select {
+	case <-granted:
+		return nil
+	case <-ctx.Done():
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	select {
+	case <-granted:
+		// The slot was handed over concurrently, pass it on
+		l.handOver()
+	default:
+		l.remove(session, granted)
+	}
+
+	return ctx.Err()
+}
+
+// release frees the slot of a finished request
+func (l *fairLimiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.handOver()
+}
+
+// handOver passes a freed slot to the next waiting session, l.mu must be held
+func (l *fairLimiter) handOver() {
+	if len(l.turns) == 0 {
+		l.active--
+		return
+	}
+
+	session := l.turns[0]
+	queue := l.waiting[session]
+
+	close(queue[0])
+
+	l.turns = l.turns[1:]
+
+	if len(queue) == 1 {
+		delete(l.waiting, session)
+	} else {
+		l.waiting[session] = queue[1:]
+		// The session waits for its next turn behind the others
+		l.turns = append(l.turns, session)
+	}
+}
+
+// remove drops a request that stopped waiting, l.mu must be held
+func (l *fairLimiter) remove(session interface{}, granted chan struct{}) {
+	queue := l.waiting[session]
+
+	for i, c := range queue {
+		if c == granted {
+			queue = append(queue[:i:i], queue[i+1:]...)
+			break
+		}
+	}
+
+	if len(queue) != 0 {
+		l.waiting[session] = queue
+		return
+	}
+
+	delete(l.waiting, session)
+
+	for i, s := range l.turns {
+		if s == session {
+			l.turns = append(l.turns[:i:i], l.turns[i+1:]...)
+			break
+		}
+	}
+}
+
+// datasourceLimiter holds the limiters of a single datasource
+type datasourceLimiter struct {
+	metadata *fairLimiter
+	query    *fairLimiter
+}
+
+type datasourceLimiterKey struct {
+	url      string
+	metadata int
+	query    int
+}
+
+// datasourceLimiters are shared by all servers of the process, so the limits hold across sessions
+var datasourceLimiters = struct { // nolint: gochecknoglobals
+	sync.Mutex
+	limiters map[datasourceLimiterKey]*datasourceLimiter
+}{limiters: make(map[datasourceLimiterKey]*datasourceLimiter)}
+
+// getDatasourceLimiter returns the limiter of the datasource at url
+func getDatasourceLimiter(url string, config *ConcurrencyConfig) *datasourceLimiter {
+	metadata, query := config.limits()
+	key := datasourceLimiterKey{url: url, metadata: metadata, query: query}
+
+	datasourceLimiters.Lock()
+	defer datasourceLimiters.Unlock()
+
+	ret, ok := datasourceLimiters.limiters[key]
+	if !ok {
+		ret = &datasourceLimiter{metadata: newFairLimiter(metadata), query: newFairLimiter(query)}
+		datasourceLimiters.limiters[key] = ret
+	}
+
+	return ret
+}
+
+// limitedClient is an api.Client that waits for a free slot of its datasource before sending a request
+type limitedClient struct {
+	api.Client
+	limiter *datasourceLimiter
+	// session identifies the server in the queues of the limiter
+	session interface{}
+}
+
+// Do is required by the api.Client interface
+func (c limitedClient) Do(ctx context.Context, req *http.Request) (*http.Response, []byte, error) {
+	limiter := c.limiter.metadata
+
+	// Query execution is much more expensive than metadata requests, so it gets its own pool
+	if strings.HasSuffix(req.URL.Path, "/api/v1/query") || strings.HasSuffix(req.URL.Path, "/api/v1/query_range") {
+		limiter = c.limiter.query
+	}
+
+	if err := limiter.acquire(ctx, c.session); err != nil {
+		return nil, nil, err
+	}
+	defer limiter.release()
+
+	return c.Client.Do(ctx, req)
+}
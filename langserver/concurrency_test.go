@@ -0,0 +1,87 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// TestFairLimiter checks that waiting sessions are served in turns
+func TestFairLimiter(*testing.T) {
+	l := newFairLimiter(1)
+
+	if err := l.acquire(context.Background(), "a"); err != nil {
+		panic(err)
+	}
+
+	served := make(chan string, 4)
+
+	enqueue := func(session string) {
+		l.mu.Lock()
+		queued := len(l.waiting[session])
+		l.mu.Unlock()
+
+		go func() {
+			if err := l.acquire(context.Background(), session); err != nil {
+				panic(err)
+			}
+
+			served <- session
+		}()
+
+		for {
+			l.mu.Lock()
+			done := len(l.waiting[session]) > queued
+			l.mu.Unlock()
+
+			if done {
+				return
+			}
+
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	enqueue("a")
+	enqueue("a")
+	enqueue("a")
+	enqueue("b")
+
+	var order string
+
+	for i := 0; i < 4; i++ {
+		l.release()
+		order += <-served
+	}
+
+	if order != "abaa" {
+		panic(fmt.Sprintf("expected the waiting sessions to be served in turns, got %s", order))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := l.acquire(ctx, "c"); err == nil {
+		panic("expected acquiring an exhausted limiter to fail once the context expires")
+	}
+
+	l.release()
+
+	if l.active != 0 || len(l.turns) != 0 {
+		panic(fmt.Sprintf("expected the limiter to be idle, got %d active requests", l.active))
+	}
+}
@@ -19,6 +19,7 @@ import (
 	"io/ioutil"
 
 	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+	config_util "github.com/prometheus/common/config"
 	"gopkg.in/yaml.v3"
 )
 
@@ -26,6 +27,86 @@ import (
 type Config struct {
 	RPCTrace      string `yaml:"rpc_trace"`
 	PrometheusURL string `yaml:"prometheus_url"`
+	// TenantID is sent as X-Scope-OrgID header with every request to the Prometheus servers,
+	// as required by multi-tenant Cortex, Mimir and Thanos setups
+	TenantID string `yaml:"tenant_id"`
+	// Headers are added to every request to the Prometheus servers, e.g. for authentication proxies
+	Headers map[string]string `yaml:"headers"`
+	// HTTPConfig configures the credentials and TLS settings of the requests to the Prometheus servers.
+	// It supports basic_auth, bearer_token, bearer_token_file and tls_config like the scrape configs of Prometheus.
+	HTTPConfig *config_util.HTTPClientConfig `yaml:"http_config"`
+	// PrometheusServers are further Prometheus servers by name, e.g. prod and staging. PrometheusMapping
+	// selects the workspace folders and files completions, hovers, code lenses and query commands use
+	// them for, all other documents and the diagnostics use PrometheusURL.
+	PrometheusServers map[string]string   `yaml:"prometheus_servers"`
+	PrometheusMapping []PrometheusMapping `yaml:"prometheus_mapping"`
+	// Transport overrides the network settings, e.g. the proxy and timeouts, of the Prometheus servers.
+	// The keys are names of prometheus_servers or URLs, e.g. of the prometheus_url.
+	Transport map[string]*TransportConfig `yaml:"transport"`
+	// MergeMetadata are names of prometheus_servers whose metric names, label names and label values are
+	// merged with those of the server a document uses, for metric namespaces split across several servers
+	MergeMetadata []string `yaml:"merge_metadata"`
+	// MetricCatalog is the path or http(s) URL of a JSON metric catalog
+	MetricCatalog string `yaml:"metric_catalog"`
+	// EvaluationTime is the time live checks are run against, as unix timestamp or in RFC3339 format.
+	// If it isn't set, the current time is used. Lists of metric names, label names and label values
+	// that aren't restricted by a selector, and metric metadata, are always fetched for the current time.
+	EvaluationTime string `yaml:"evaluation_time"`
+	// Retention is the retention time of the Prometheus server, e.g. 15d. It is used to
+	// find queries that select data which has already been deleted. If it isn't set,
+	// the retention is read from the flags of the connected Prometheus server.
+	Retention string `yaml:"retention"`
+	// LintUnits enables warnings about operations combining values of different units,
+	// e.g. adding seconds to bytes. The units are inferred from the metric names.
+	LintUnits bool `yaml:"lint_units"`
+	// DisablePrecedenceHints turns off the hints suggesting parentheses around the operands of and, or
+	// and unless whose precedence is commonly misread
+	DisablePrecedenceHints bool `yaml:"disable_precedence_hints"`
+	// DisableUnknownMetricHints turns off the hints about metric names the connected Prometheus server
+	// has no series of, e.g. if rules are written for metrics that aren't exported yet
+	DisableUnknownMetricHints bool `yaml:"disable_unknown_metric_hints"`
+	// CardinalityThreshold is the number of series a selector may match before a warning suggests to narrow it
+	// down with further matchers. Selectors aren't checked if it isn't set.
+	CardinalityThreshold int `yaml:"cardinality_threshold"`
+	// EvaluateQueries shows the current result of every query as a code lens above it.
+	// Every request for code lenses runs the queries of the document on the Prometheus server.
+	EvaluateQueries bool `yaml:"evaluate_queries"`
+	// MetadataRefreshInterval makes the server fetch metric names, label names and metric metadata
+	// from the Prometheus server in the background at this interval, e.g. 5m, instead of on demand
+	MetadataRefreshInterval string `yaml:"metadata_refresh_interval"`
+	// CompileDebounce delays compiling a document after it changed, e.g. 200ms, so that typing quickly
+	// in a large rule file doesn't compile every keystroke. Documents are compiled immediately if it isn't set.
+	CompileDebounce string `yaml:"compile_debounce"`
+	// DocumentStorage spills the content of documents to disk, e.g. for REST API deployments analyzing
+	// thousands of documents at once. Changes require a restart.
+	DocumentStorage *DocumentStorageConfig `yaml:"document_storage"`
+	// Concurrency limits the number of concurrent requests to the Prometheus server
+	Concurrency *ConcurrencyConfig `yaml:"concurrency"`
+	// Thanos enables checks for Thanos Query datasources
+	Thanos *ThanosConfig `yaml:"thanos"`
+	// Telemetry enables sending anonymous usage statistics
+	Telemetry *TelemetryConfig `yaml:"telemetry"`
+	// DiagnosticDocs configures the documentation diagnostics link to
+	DiagnosticDocs *DiagnosticDocsConfig `yaml:"diagnostic_docs"`
+	// ShareLinks configures the links the promql.shareQuery command generates for a query
+	ShareLinks *ShareLinksConfig `yaml:"share_links"`
+	// TemplateVariables are the values the promql.resolveQuery command replaces Grafana template variables by,
+	// e.g. `$job`. They override the current values of dashboard variables.
+	TemplateVariables map[string]string `yaml:"template_variables"`
+	// DemoMode hardens the server for public playgrounds: commands executing queries are disabled,
+	// no local files are read or written and clients can't change the Prometheus server metadata is taken from.
+	DemoMode bool `yaml:"demo_mode"`
+	// AllowedOrigins are the websites browser based editors may connect to the WebSocket server from,
+	// e.g. https://grafana.example.com, "*" allows all of them. Only same-origin connections are accepted by default.
+	AllowedOrigins []string `yaml:"allowed_origins"`
+	// RESTUI serves a web page for trying the REST API at /ui
+	RESTUI bool `yaml:"rest_ui"`
+	// ReadOnly is meant for instances shared by several users: queries aren't executed on behalf of clients
+	// and no local state is written, while all analysis features stay available.
+	ReadOnly bool `yaml:"read_only"`
+
+	// path is the file the configuration was read from, it is reloaded when the file changes
+	path string
 }
 
 // ParseConfig parses a yaml configuration.
@@ -34,9 +115,11 @@ type Config struct {
 func ParseConfig(in []byte) (*Config, error) {
 	var config Config
 
-	err := yaml.Unmarshal(in, &config)
+	if err := yaml.Unmarshal(in, &config); err != nil {
+		return &config, err
+	}
 
-	return &config, err
+	return &config, config.DiagnosticDocs.parse()
 }
 
 // ParseConfigFile parses a yaml configuration file.
@@ -48,13 +131,16 @@ func ParseConfigFile(path string) (*Config, error) {
 		return nil, err
 	}
 
-	return ParseConfig(data)
+	config, err := ParseConfig(data)
+	if config != nil {
+		config.path = path
+	}
+
+	return config, err
 }
 
 // DidChangeConfiguration is required by the protocol.Server interface
 func (s *server) DidChangeConfiguration(ctx context.Context, params *protocol.DidChangeConfigurationParams) error {
-	langserverAddressConfigPath := []string{"promql", "url"}
-
 	if params != nil {
 		// nolint: errcheck
 		s.client.LogMessage(
@@ -64,25 +150,26 @@ func (s *server) DidChangeConfiguration(ctx context.Context, params *protocol.Di
 				Message: fmt.Sprintf("Received notification change: %v\n", params),
 			})
 
-		setting := params.Settings
-
-		for _, e := range langserverAddressConfigPath {
-			m, ok := setting.(map[string]interface{})
-			if !ok {
-				break
+		// In demo mode, clients must not be able to make the server send requests to arbitrary hosts
+		if str, ok := getSetting(params.Settings, "promql", "url").(string); ok && !s.getConfig().DemoMode {
+			if err := s.connectPrometheus(str); err != nil {
+				// nolint: errcheck
+				s.client.LogMessage(ctx, &protocol.LogMessageParams{
+					Type:    protocol.Info,
+					Message: err.Error(),
+				})
 			}
+		}
 
-			setting, ok = m[e]
-			if !ok {
-				break
-			}
+		if servers, mapping, ok := endpointSettings(params.Settings); ok && !s.getConfig().DemoMode {
+			s.configureEndpoints(servers, mapping)
 		}
 
-		if str, ok := setting.(string); ok {
-			if err := s.connectPrometheus(str); err != nil {
+		if str, ok := getSetting(params.Settings, "promql", "evaluationTime").(string); ok {
+			if err := s.setEvaluationTime(str); err != nil {
 				// nolint: errcheck
 				s.client.LogMessage(ctx, &protocol.LogMessageParams{
-					Type:    protocol.Info,
+					Type:    protocol.Error,
 					Message: err.Error(),
 				})
 			}
@@ -91,3 +178,21 @@ func (s *server) DidChangeConfiguration(ctx context.Context, params *protocol.Di
 
 	return nil
 }
+
+// getSetting returns the value found at the given path of a settings object
+// or nil if there is none
+func getSetting(settings interface{}, path ...string) interface{} {
+	for _, e := range path {
+		m, ok := settings.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+
+		settings, ok = m[e]
+		if !ok {
+			return nil
+		}
+	}
+
+	return settings
+}
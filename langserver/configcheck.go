@@ -0,0 +1,645 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/prometheus-community/promql-langserver/langserver/cache"
+	"gopkg.in/yaml.v3"
+)
+
+// datasourceCheckTimeout limits how long CheckDatasources waits for a Prometheus server
+const datasourceCheckTimeout = 10 * time.Second
+
+// ConfigProblem is a mistake found by CheckConfig or CheckDatasources
+type ConfigProblem struct {
+	// Path is the key path of the option, e.g. concurrency.query_requests or prometheus_mapping[0].server
+	Path string
+	// Line is the line of the option in the configuration file, 0 if it isn't known
+	Line int
+	// Message describes the problem and how to fix it
+	Message string
+	// Warning is set for problems that don't stop the server from starting, e.g. unknown keys
+	Warning bool
+}
+
+func (p ConfigProblem) String() string {
+	ret := p.Message
+
+	if p.Path != "" {
+		ret = p.Path + ": " + ret
+	}
+
+	if p.Line > 0 {
+		ret = fmt.Sprintf("line %d: %s", p.Line, ret)
+	}
+
+	if p.Warning {
+		ret = "warning: " + ret
+	}
+
+	return ret
+}
+
+// HasConfigErrors checks whether any of the problems isn't just a warning
+func HasConfigErrors(problems []ConfigProblem) bool {
+	for _, p := range problems {
+		if !p.Warning {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CheckConfig validates a yaml configuration. Unlike ParseConfig it doesn't stop at the first mistake,
+// and it reports the key path and line of every option that has the wrong type, an invalid value or
+// an unknown name. Nothing is requested from the Prometheus servers, see CheckDatasources.
+func CheckConfig(in []byte) []ConfigProblem {
+	var root yaml.Node
+
+	if err := yaml.Unmarshal(in, &root); err != nil {
+		return []ConfigProblem{yamlProblem(err.Error())}
+	}
+
+	if len(root.Content) == 0 {
+		return nil
+	}
+
+	var problems []ConfigProblem
+
+	checkConfigNode(root.Content[0], reflect.TypeOf(Config{}), "", &problems)
+
+	if HasConfigErrors(problems) {
+		// Decoding a configuration with the wrong types only repeats them
+		return problems
+	}
+
+	var config Config
+
+	if err := root.Content[0].Decode(&config); err != nil {
+		if typeErr, ok := err.(*yaml.TypeError); ok {
+			for _, e := range typeErr.Errors {
+				problems = append(problems, yamlProblem(e))
+			}
+
+			return problems
+		}
+
+		return append(problems, optionProblem(root.Content[0], err))
+	}
+
+	for _, p := range config.valueProblems() {
+		if node := findConfigNode(root.Content[0], p.Path); node != nil {
+			p.Line = node.Line
+		}
+
+		problems = append(problems, p)
+	}
+
+	return problems
+}
+
+// optionProblem finds the option a decoding error, e.g. of the http_config validation, comes from
+// by decoding the options one by one
+func optionProblem(node *yaml.Node, err error) ConfigProblem {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		option := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Content: node.Content[i : i+2]}
+
+		var config Config
+
+		if optionErr := option.Decode(&config); optionErr != nil {
+			return ConfigProblem{Path: node.Content[i].Value, Line: node.Content[i].Line, Message: optionErr.Error()}
+		}
+	}
+
+	return yamlProblem(err.Error())
+}
+
+// yamlLinePrefix matches the line numbers in the errors of the yaml decoder
+var yamlLinePrefix = regexp.MustCompile(`^(?:yaml: )?line (\d+): `) // nolint: gochecknoglobals
+
+// yamlProblem converts an error of the yaml decoder
+func yamlProblem(message string) ConfigProblem {
+	var p ConfigProblem
+
+	if m := yamlLinePrefix.FindStringSubmatch(message); m != nil {
+		p.Line, _ = strconv.Atoi(m[1]) // nolint: errcheck
+		message = message[len(m[0]):]
+	}
+
+	p.Message = strings.TrimPrefix(message, "yaml: ")
+
+	return p
+}
+
+// yamlV1Bools are the boolean values of YAML 1.1, they are still accepted for boolean options
+var yamlV1Bools = map[string]bool{"yes": true, "no": true, "on": true, "off": true} // nolint: gochecknoglobals
+
+// v2Unmarshaler is implemented by the types of prometheus/common, e.g. the http_config
+type v2Unmarshaler interface {
+	UnmarshalYAML(unmarshal func(interface{}) error) error
+}
+
+// hasCustomUnmarshaler checks whether a type decodes itself, its yaml representation may then differ from its fields
+func hasCustomUnmarshaler(t reflect.Type) bool {
+	pt := reflect.PtrTo(t)
+
+	return pt.Implements(reflect.TypeOf((*yaml.Unmarshaler)(nil)).Elem()) ||
+		pt.Implements(reflect.TypeOf((*v2Unmarshaler)(nil)).Elem())
+}
+
+// yamlFields returns the struct fields by yaml key, including the fields of inlined structs
+func yamlFields(t reflect.Type, fields map[string]reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		tag := f.Tag.Get("yaml")
+
+		name := strings.Split(tag, ",")[0]
+		if tag == "-" || (f.PkgPath != "" && !f.Anonymous) {
+			continue
+		}
+
+		if strings.Contains(tag, ",inline") || (f.Anonymous && tag == "") {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+
+			if ft.Kind() == reflect.Struct {
+				yamlFields(ft, fields)
+			}
+
+			continue
+		}
+
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+
+		fields[name] = f.Type
+	}
+}
+
+// checkConfigNode reports unknown keys and values that don't match the type of an option
+func checkConfigNode(node *yaml.Node, t reflect.Type, path string, problems *[]ConfigProblem) {
+	for node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if node.ShortTag() == "!!null" {
+		return
+	}
+
+	report := func(format string, args ...interface{}) {
+		*problems = append(*problems, ConfigProblem{Path: path, Line: node.Line, Message: fmt.Sprintf(format, args...)})
+	}
+
+	got := func() string {
+		switch node.Kind {
+		case yaml.MappingNode:
+			return "a mapping"
+		case yaml.SequenceNode:
+			return "a list"
+		default:
+			return strconv.Quote(node.Value)
+		}
+	}
+
+	if hasCustomUnmarshaler(t) && (t.Kind() != reflect.Struct || node.Kind != yaml.MappingNode) {
+		// Only the types of the fields of structs like the http_config can be checked,
+		// decoding reports other mistakes
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			report("expected a mapping, got %s", got())
+			return
+		}
+
+		fields := make(map[string]reflect.Type)
+		yamlFields(t, fields)
+
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+
+			ft, ok := fields[key]
+			if !ok {
+				*problems = append(*problems, ConfigProblem{
+					Path:    joinConfigPath(path, key),
+					Line:    node.Content[i].Line,
+					Message: unknownKeyMessage(key, fields),
+					Warning: true,
+				})
+
+				continue
+			}
+
+			checkConfigNode(node.Content[i+1], ft, joinConfigPath(path, key), problems)
+		}
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			report("expected a mapping, got %s", got())
+			return
+		}
+
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			checkConfigNode(node.Content[i+1], t.Elem(), joinConfigPath(path, node.Content[i].Value), problems)
+		}
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			report("expected a list, got %s", got())
+			return
+		}
+
+		for i, item := range node.Content {
+			checkConfigNode(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i), problems)
+		}
+	case reflect.String:
+		if node.Kind != yaml.ScalarNode {
+			report("expected a string, got %s", got())
+		}
+	case reflect.Bool:
+		if node.Kind != yaml.ScalarNode || (node.ShortTag() != "!!bool" && !yamlV1Bools[strings.ToLower(node.Value)]) {
+			report("expected true or false, got %s", got())
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if node.Kind != yaml.ScalarNode || node.ShortTag() != "!!int" {
+			report("expected an integer, got %s", got())
+		}
+	case reflect.Float32, reflect.Float64:
+		if node.Kind != yaml.ScalarNode || (node.ShortTag() != "!!int" && node.ShortTag() != "!!float") {
+			report("expected a number, got %s", got())
+		}
+	}
+}
+
+func joinConfigPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
+
+// unknownKeyMessage suggests the known key that is most similar to a misspelled one
+func unknownKeyMessage(key string, fields map[string]reflect.Type) string {
+	best, bestDistance := "", 3
+
+	for name := range fields {
+		if d := editDistance(key, name); d < bestDistance || (d == bestDistance && name < best) {
+			best, bestDistance = name, d
+		}
+	}
+
+	if best == "" {
+		return "unknown key, it is ignored"
+	}
+
+	return fmt.Sprintf("unknown key, it is ignored. Did you mean %s?", best)
+}
+
+// editDistance is the Levenshtein distance of two strings
+func editDistance(a string, b string) int {
+	row := make([]int, len(b)+1)
+
+	for j := range row {
+		row[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		prev := row[0]
+		row[0] = i
+
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			next := row[j]
+			row[j] = min3(row[j]+1, row[j-1]+1, prev+cost)
+			prev = next
+		}
+	}
+
+	return row[len(b)]
+}
+
+func min3(a int, b int, c int) int {
+	if b < a {
+		a = b
+	}
+
+	if c < a {
+		a = c
+	}
+
+	return a
+}
+
+// findConfigNode returns the value at a key path, or nil if there is none
+func findConfigNode(node *yaml.Node, path string) *yaml.Node {
+	if path == "" {
+		return node
+	}
+
+	for _, part := range strings.Split(path, ".") {
+		key := part
+		index := -1
+
+		if i := strings.Index(part, "["); i >= 0 && strings.HasSuffix(part, "]") {
+			key = part[:i]
+			index, _ = strconv.Atoi(part[i+1 : len(part)-1]) // nolint: errcheck
+		}
+
+		var value *yaml.Node
+
+		for i := 0; node.Kind == yaml.MappingNode && i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == key {
+				value = node.Content[i+1]
+			}
+		}
+
+		if value == nil {
+			return nil
+		}
+
+		if index >= 0 {
+			if value.Kind != yaml.SequenceNode || index >= len(value.Content) {
+				return nil
+			}
+
+			value = value.Content[index]
+		}
+
+		node = value
+	}
+
+	return node
+}
+
+// valueProblems checks the values of options that are only interpreted when they are used,
+// e.g. durations and URLs
+func (c *Config) valueProblems() []ConfigProblem {
+	var problems []ConfigProblem
+
+	report := func(path string, format string, args ...interface{}) {
+		problems = append(problems, ConfigProblem{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	checkURL := func(path string, value string) {
+		if value == "" {
+			return
+		}
+
+		u, err := url.Parse(value)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			report(path, "expected an http(s) URL like http://localhost:9090, got %q", value)
+		}
+	}
+
+	checkDuration := func(path string, value string) {
+		if value == "" {
+			return
+		}
+
+		if d, err := cache.ParseDuration(value); err != nil || d <= 0 {
+			report(path, "expected a positive duration like 5m or 1h, got %q", value)
+		}
+	}
+
+	checkNonNegative := func(path string, value int) {
+		if value < 0 {
+			report(path, "must not be negative, got %d", value)
+		}
+	}
+
+	switch c.RPCTrace {
+	case "", "text", "json":
+	default:
+		report("rpc_trace", "expected text or json, got %q", c.RPCTrace)
+	}
+
+	checkURL("prometheus_url", c.PrometheusURL)
+
+	names := make([]string, 0, len(c.PrometheusServers))
+	for name := range c.PrometheusServers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		checkURL(joinConfigPath("prometheus_servers", name), c.PrometheusServers[name])
+	}
+
+	for i, m := range c.PrometheusMapping {
+		path := fmt.Sprintf("prometheus_mapping[%d]", i)
+
+		if m.Path == "" {
+			report(path+".path", "expected a directory, file or glob pattern")
+		}
+
+		if _, ok := c.PrometheusServers[m.Server]; !ok {
+			report(path+".server", "unknown server %q, expected one of the names in prometheus_servers: %s",
+				m.Server, strings.Join(names, ", "))
+		}
+	}
+
+	for i, name := range c.MergeMetadata {
+		if _, ok := c.PrometheusServers[name]; !ok {
+			report(fmt.Sprintf("merge_metadata[%d]", i), "unknown server %q, expected one of the names in prometheus_servers: %s",
+				name, strings.Join(names, ", "))
+		}
+	}
+
+	transports := make([]string, 0, len(c.Transport))
+	for key := range c.Transport {
+		transports = append(transports, key)
+	}
+
+	sort.Strings(transports)
+
+	for _, key := range transports {
+		path := joinConfigPath("transport", key)
+
+		if _, ok := c.PrometheusServers[key]; !ok && !c.isPrometheusURL(key) {
+			report(path, "expected the name of one of the prometheus_servers or the URL of a Prometheus server")
+		}
+
+		if transport := c.Transport[key]; transport != nil {
+			if _, err := transport.roundTripper(nil); err != nil {
+				report(path, "%s, expected a duration like 10s", err.Error())
+			}
+		}
+	}
+
+	if c.MetricCatalog != "" && !strings.HasPrefix(c.MetricCatalog, "http://") && !strings.HasPrefix(c.MetricCatalog, "https://") {
+		if _, err := os.Stat(c.MetricCatalog); err != nil {
+			report("metric_catalog", "expected an http(s) URL or the path of a file, %s", err.Error())
+		}
+	}
+
+	if _, err := parseEvaluationTime(c.EvaluationTime); err != nil {
+		report("evaluation_time", "expected a unix timestamp or a time in RFC3339 format, got %q", c.EvaluationTime)
+	}
+
+	checkDuration("retention", c.Retention)
+	checkDuration("metadata_refresh_interval", c.MetadataRefreshInterval)
+
+	if _, err := c.compileDebounce(); err != nil {
+		report("compile_debounce", "expected a duration like 200ms, got %q", c.CompileDebounce)
+	}
+
+	checkNonNegative("cardinality_threshold", c.CardinalityThreshold)
+
+	if c.DocumentStorage != nil {
+		checkNonNegative("document_storage.memory_limit", c.DocumentStorage.MemoryLimit)
+
+		if dir := c.DocumentStorage.SpillDir; dir != "" {
+			if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+				report("document_storage.spill_dir", "expected an existing directory, got %q", dir)
+			}
+		}
+	}
+
+	if c.Concurrency != nil {
+		checkNonNegative("concurrency.metadata_requests", c.Concurrency.MetadataRequests)
+		checkNonNegative("concurrency.query_requests", c.Concurrency.QueryRequests)
+	}
+
+	if _, _, err := c.Thanos.thanosResolution(); err != nil {
+		report("thanos", "%s, expected a duration like 5m", err.Error())
+	}
+
+	if c.Telemetry != nil {
+		checkURL("telemetry.endpoint", c.Telemetry.Endpoint)
+		checkDuration("telemetry.interval", c.Telemetry.Interval)
+	}
+
+	if c.ShareLinks != nil {
+		checkURL("share_links.prometheus_url", c.ShareLinks.PrometheusURL)
+		checkURL("share_links.grafana_url", c.ShareLinks.GrafanaURL)
+		checkDuration("share_links.range", c.ShareLinks.Range)
+
+		for name, text := range c.ShareLinks.URLTemplates {
+			if _, err := template.New(name).Parse(text); err != nil {
+				report(joinConfigPath("share_links.url_templates", name), "%s", err.Error())
+			}
+		}
+	}
+
+	if err := c.DiagnosticDocs.parse(); err != nil {
+		report("diagnostic_docs.url_template", "%s", err.Error())
+	}
+
+	if _, err := parseOrigins(c.AllowedOrigins); err != nil {
+		report("allowed_origins", "%s", err.Error())
+	}
+
+	if c.HTTPConfig != nil {
+		if err := c.HTTPConfig.Validate(); err != nil {
+			report("http_config", "%s", err.Error())
+		} else if _, err := c.roundTripper(""); err != nil {
+			report("http_config", "%s", err.Error())
+		}
+	}
+
+	return problems
+}
+
+// isPrometheusURL checks whether a URL is the prometheus_url or one of the prometheus_servers
+func (c *Config) isPrometheusURL(u string) bool {
+	if u == c.PrometheusURL {
+		return true
+	}
+
+	for _, server := range c.PrometheusServers {
+		if u == server {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CheckDatasources requests the build information of prometheus_url and the prometheus_servers
+// with the configured credentials, to report unreachable servers and rejected credentials
+func (c *Config) CheckDatasources(ctx context.Context) []ConfigProblem {
+	var problems []ConfigProblem
+
+	check := func(path string, u string) {
+		if u == "" {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, datasourceCheckTimeout)
+		defer cancel()
+
+		resp, err := c.getBuildInfo(ctx, u)
+		if err != nil {
+			problems = append(problems, ConfigProblem{Path: path, Message: fmt.Sprintf("failed to connect to %s: %s", u, err.Error())})
+			return
+		}
+
+		resp.Body.Close()
+
+		switch {
+		case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+			problems = append(problems, ConfigProblem{Path: path, Message: fmt.Sprintf(
+				"%s rejected the credentials (%s), check http_config, headers and tenant_id", u, resp.Status)})
+		case resp.StatusCode == http.StatusNotFound:
+			problems = append(problems, ConfigProblem{Path: path, Warning: true, Message: fmt.Sprintf(
+				"%s doesn't serve /api/v1/status/buildinfo, check that the URL points to the Prometheus API", u)})
+		case resp.StatusCode >= http.StatusBadRequest:
+			problems = append(problems, ConfigProblem{Path: path, Message: fmt.Sprintf("%s responded with %s", u, resp.Status)})
+		}
+	}
+
+	check("prometheus_url", c.PrometheusURL)
+
+	names := make([]string, 0, len(c.PrometheusServers))
+	for name := range c.PrometheusServers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		check(joinConfigPath("prometheus_servers", name), c.PrometheusServers[name])
+	}
+
+	return problems
+}
@@ -0,0 +1,83 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestCheckConfig checks that mistakes in the configuration are reported with their key path and line
+func TestCheckConfig(*testing.T) {
+	const config = `prometheus_url: localhost:9090
+evaluate_querys: true
+concurrency:
+  query_requests: many
+prometheus_servers:
+  prod: http://prod:9090
+prometheus_mapping:
+- path: rules/prod
+  server: production
+metadata_refresh_interval: soon
+`
+
+	problems := CheckConfig([]byte(config))
+
+	if len(problems) != 2 || problems[0].String() != `warning: line 2: evaluate_querys: unknown key, it is ignored. Did you mean evaluate_queries?` ||
+		problems[1].String() != `line 4: concurrency.query_requests: expected an integer, got "many"` {
+		panic(fmt.Sprintf("expected the unknown key and the type error to be reported, got %v", problems))
+	}
+
+	problems = CheckConfig([]byte(strings.Replace(config, "many", "2", 1)))
+
+	if len(problems) != 4 || problems[1].String() != `line 1: prometheus_url: expected an http(s) URL like http://localhost:9090, got "localhost:9090"` ||
+		problems[2].Path != "prometheus_mapping[0].server" || problems[2].Line != 9 ||
+		problems[3].String() != `line 10: metadata_refresh_interval: expected a positive duration like 5m or 1h, got "soon"` {
+		panic(fmt.Sprintf("expected the invalid values to be reported, got %v", problems))
+	}
+
+	if problems := CheckConfig([]byte("")); len(problems) != 0 {
+		panic(fmt.Sprintf("expected an empty configuration to be valid, got %v", problems))
+	}
+}
+
+// TestCheckDatasources checks that rejected credentials are reported
+func TestCheckDatasources(*testing.T) {
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(tenantHeader) != "team-a" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		fmt.Fprint(w, `{"status":"success","data":{}}`)
+	}))
+	defer prom.Close()
+
+	config := &Config{PrometheusURL: prom.URL, PrometheusServers: map[string]string{"prod": prom.URL}}
+
+	problems := config.CheckDatasources(context.Background())
+	if len(problems) != 2 || problems[1].Path != "prometheus_servers.prod" || !strings.Contains(problems[0].Message, "rejected the credentials") {
+		panic(fmt.Sprintf("expected both servers to reject the requests, got %v", problems))
+	}
+
+	config.TenantID = "team-a"
+
+	if problems := config.CheckDatasources(context.Background()); len(problems) != 0 {
+		panic(fmt.Sprintf("expected the tenant to be accepted, got %v", problems))
+	}
+}
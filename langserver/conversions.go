@@ -0,0 +1,214 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"go/token"
+	"strings"
+
+	"github.com/prometheus-community/promql-langserver/langserver/cache"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+	"github.com/prometheus/prometheus/promql"
+)
+
+// The rules of the quick fixes for conversions between scalars and vectors
+const (
+	// fixScalarSeries aggregates the arguments of scalar() that can have more than one series
+	fixScalarSeries = "scalar-multiple-series"
+	// fixConversionRoundTrip removes conversions that are undone right away, e.g. vector(scalar(...))
+	fixConversionRoundTrip = "conversion-round-trip"
+)
+
+// exprRange returns the position range of an expression inside a query. The parser extends aggregations
+// without grouping that are the last argument of a function call to the end of the call, e.g. to `sum(up))`
+// in `abs(sum(up))`, so unbalanced closing parentheses are left out.
+func exprRange(query string, expr promql.Expr) promql.PositionRange {
+	rng := expr.PositionRange()
+	text := query[rng.Start:rng.End]
+
+	literals := stringLiterals(text)
+	depth := 0
+	end := len(text)
+
+scan:
+	for i := 0; i < len(text); i++ {
+		if len(literals) > 0 && i == literals[0].Start {
+			i = literals[0].End - 1
+			literals = literals[1:]
+
+			continue
+		}
+
+		switch text[i] {
+		case '(':
+			depth++
+		case ')':
+			if depth == 0 {
+				end = i
+				break scan
+			}
+
+			depth--
+		}
+	}
+
+	rng.End = rng.Start + promql.Pos(len(strings.TrimRight(text[:end], " \t\r\n")))
+
+	return rng
+}
+
+// singleSeries checks whether an instant vector expression is known to return at most one series.
+// Selectors can always match several series.
+func singleSeries(expr promql.Expr) bool {
+	switch n := expr.(type) {
+	case *promql.ParenExpr:
+		return singleSeries(n.Expr)
+	case *promql.UnaryExpr:
+		return singleSeries(n.Expr)
+	case *promql.AggregateExpr:
+		switch n.Op {
+		case promql.TOPK, promql.BOTTOMK:
+			k, ok := unparen(n.Param).(*promql.NumberLiteral)
+			return ok && k.Val <= 1 && len(n.Grouping) == 0 && !n.Without
+		case promql.COUNT_VALUES:
+			return false
+		}
+
+		return len(n.Grouping) == 0 && !n.Without
+	case *promql.Call:
+		switch n.Func.Name {
+		case "vector", "time", "absent", "absent_over_time":
+			return true
+		case "histogram_quantile":
+			// histogram_quantile(0.9, sum by (le) (...)) removes the only grouping label
+			if len(n.Args) == 2 {
+				if agg, ok := unparen(n.Args[1]).(*promql.AggregateExpr); ok && !agg.Without &&
+					len(agg.Grouping) == 1 && agg.Grouping[0] == "le" {
+					return true
+				}
+			}
+		}
+
+		// The other functions work on every series on its own
+		for _, arg := range n.Args {
+			switch arg.Type() {
+			case promql.ValueTypeVector, promql.ValueTypeMatrix:
+				return singleSeries(arg)
+			}
+		}
+
+		return false
+	case *promql.SubqueryExpr:
+		return singleSeries(n.Expr)
+	case *promql.BinaryExpr:
+		switch {
+		case n.LHS.Type() == promql.ValueTypeScalar:
+			return singleSeries(n.RHS)
+		case n.RHS.Type() == promql.ValueTypeScalar:
+			return singleSeries(n.LHS)
+		case n.Op == promql.LAND || n.Op == promql.LUNLESS:
+			return singleSeries(n.LHS)
+		case n.Op == promql.LOR:
+			return singleSeries(n.LHS) && singleSeries(n.RHS)
+		case n.VectorMatching == nil || n.VectorMatching.Card == promql.CardOneToOne:
+			// Every result of a one-to-one match pairs series that aren't matched otherwise
+			return singleSeries(n.LHS) || singleSeries(n.RHS)
+		}
+	}
+
+	return false
+}
+
+// conversionFixes reports scalar() calls whose argument can have several series, which silently return NaN,
+// and conversions between scalars and vectors that are undone right away, e.g. vector(scalar(...)).
+func conversionFixes(doc *cache.DocumentHandle) []quickFix {
+	queries, err := doc.GetQueries()
+	if err != nil {
+		return nil
+	}
+
+	var ret []quickFix
+
+	for _, q := range queries {
+		if q.Ast == nil || len(q.Err) > 0 {
+			continue
+		}
+
+		pos := func(p promql.Pos) token.Pos {
+			return q.Pos + token.Pos(p)
+		}
+
+		add := func(expr promql.Expr, rule string, title string, severity protocol.DiagnosticSeverity, msg string, edits ...tokenEdit) {
+			exprRng := exprRange(q.Content, expr)
+
+			rng, err := tokenRange(doc, pos(exprRng.Start), pos(exprRng.End))
+			if err != nil {
+				return
+			}
+
+			ret = append(ret, quickFix{
+				Rule:  rule,
+				Title: title,
+				Diagnostic: protocol.Diagnostic{
+					Range:    rng,
+					Severity: severity,
+					Code:     rule,
+					Source:   "promql-lsp",
+					Message:  msg,
+				},
+				Edits: edits,
+			})
+		}
+
+		promql.Inspect(q.Ast, func(node promql.Node, _ []promql.Node) error {
+			call, ok := node.(*promql.Call)
+			if !ok || len(call.Args) != 1 {
+				return nil
+			}
+
+			arg := call.Args[0]
+			inner, _ := unparen(arg).(*promql.Call)
+
+			switch {
+			case call.Func.Name == "vector" && inner != nil && inner.Func.Name == "scalar" && singleSeries(inner.Args[0]):
+				expr := exprRange(q.Content, unparen(inner.Args[0]))
+				rng := exprRange(q.Content, call)
+
+				add(call, fixConversionRoundTrip, "Remove vector(scalar(...))", 3, // Information
+					"vector(scalar(...)) drops the labels and returns NaN instead of no result if there is no series; "+
+						"the expression already returns at most one series",
+					tokenEdit{Pos: pos(rng.Start), End: pos(rng.End), NewText: q.Content[expr.Start:expr.End]})
+			case call.Func.Name == "scalar" && inner != nil && inner.Func.Name == "vector":
+				expr := exprRange(q.Content, unparen(inner.Args[0]))
+				rng := exprRange(q.Content, call)
+
+				add(call, fixConversionRoundTrip, "Remove scalar(vector(...))", 3, // Information
+					"scalar(vector(...)) returns the scalar it is applied to",
+					tokenEdit{Pos: pos(rng.Start), End: pos(rng.End), NewText: q.Content[expr.Start:expr.End]})
+			case call.Func.Name == "scalar" && !singleSeries(arg):
+				rng := exprRange(q.Content, arg)
+
+				add(arg, fixScalarSeries, "Aggregate with sum", 2, // Warning
+					"scalar() returns NaN unless its argument has exactly one series; aggregate it first, "+
+						"e.g. with sum() or max(), to get a number for any number of series",
+					tokenEdit{Pos: pos(rng.Start), End: pos(rng.Start), NewText: "sum("},
+					tokenEdit{Pos: pos(rng.End), End: pos(rng.End), NewText: ")"})
+			}
+
+			return nil
+		})
+	}
+
+	return ret
+}
@@ -0,0 +1,79 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+// TestConversionFixes checks the guidance for scalar() and vector() conversions
+func TestConversionFixes(*testing.T) {
+	tests := []struct {
+		query    string
+		rule     string
+		expected string
+	}{
+		{query: `scalar(up{job="node"})`, rule: fixScalarSeries, expected: `scalar(sum(up{job="node"}))`},
+		{query: `scalar(rate(http_requests_total[5m]))`, rule: fixScalarSeries, expected: `scalar(sum(rate(http_requests_total[5m])))`},
+		{query: `scalar(sum by (job) (up))`, rule: fixScalarSeries, expected: `scalar(sum(sum by (job) (up)))`},
+		{query: `vector(scalar(sum(up)))`, rule: fixConversionRoundTrip, expected: `sum(up)`},
+		{query: `scalar(vector(time()))`, rule: fixConversionRoundTrip, expected: `time()`},
+		// At most one series
+		{query: `scalar(sum(up))`},
+		{query: `scalar(topk(1, up))`},
+		{query: `scalar(histogram_quantile(0.9, sum by (le) (rate(http_request_duration_seconds_bucket[5m]))))`},
+		{query: `scalar(sum(up) / count(up))`},
+		{query: `scalar(abs(sum(up) - 2))`},
+		{query: `vector(time())`},
+	}
+
+	for i, test := range tests {
+		h, err := NewHeadlessServer(context.Background(), &Config{}, nil)
+		if err != nil {
+			panic(err)
+		}
+
+		report, err := h.AnalyzeDocument(fmt.Sprintf("conversions_%d.promql", i), "promql", test.query)
+		if err != nil {
+			panic(err)
+		}
+
+		fixed := ""
+
+		for _, fix := range report.Fixes {
+			if fix.Rule != fixScalarSeries && fix.Rule != fixConversionRoundTrip {
+				continue
+			}
+
+			if fixed != "" || fix.Rule != test.rule {
+				panic(fmt.Sprintf("expected a single %s fix for %q, got %v", test.rule, test.query, report.Fixes))
+			}
+
+			fixed = test.query
+
+			for j := len(fix.Edits) - 1; j >= 0; j-- {
+				e := fix.Edits[j]
+				fixed = fixed[:e.Start] + e.NewText + fixed[e.End:]
+			}
+		}
+
+		if fixed != test.expected {
+			panic(fmt.Sprintf("expected %q to be fixed as %q, got %q", test.query, test.expected, fixed))
+		}
+
+		h.Close()
+	}
+}
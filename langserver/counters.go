@@ -0,0 +1,260 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"go/token"
+	"math"
+	"time"
+
+	"github.com/prometheus-community/promql-langserver/langserver/cache"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+	"github.com/prometheus/prometheus/promql"
+)
+
+// counterDocsURL explains how the functions on counters handle resets and extrapolation
+const counterDocsURL = "https://prometheus.io/docs/prometheus/latest/querying/functions/#increase"
+
+// counterNotes are shown when hovering functions that take counters as argument
+var counterNotes = map[string]string{ // nolint: gochecknoglobals
+	"rate": "Counter resets, e.g. after a restart, are detected and compensated. The result is extrapolated " +
+		"to the boundaries of the range, so it is an estimate, not an exact value.",
+	"increase": "Counter resets, e.g. after a restart, are detected and compensated. The result is extrapolated " +
+		"to the boundaries of the range, so it can be a non-integer value even if the counter only grows by whole numbers.",
+	"irate": "Only the last two samples of the range are used, so short spikes are missed unless the range " +
+		"covers few samples. Counter resets between the two samples are compensated.",
+}
+
+// counterHover returns the note on counter resets and extrapolation for a function, if it has one
+func counterHover(name string) string {
+	note, ok := counterNotes[name]
+	if !ok {
+		return ""
+	}
+
+	return fmt.Sprintf("\n\n__Note:__ %s See [counter resets and extrapolation](%s).\n", note, counterDocsURL)
+}
+
+// containsIncrease returns whether an expression computes an increase, possibly aggregated
+func containsIncrease(expr promql.Expr) bool {
+	switch n := unparen(expr).(type) {
+	case *promql.Call:
+		return n.Func.Name == "increase"
+	case *promql.AggregateExpr:
+		return n.Op != promql.COUNT && n.Op != promql.COUNT_VALUES && containsIncrease(n.Expr)
+	}
+
+	return false
+}
+
+// expectsInteger returns whether a comparison with the result of increase
+// only works as intended if the result is a whole number
+func expectsInteger(op promql.ItemType, lit *promql.NumberLiteral) bool {
+	if lit.Val != math.Trunc(lit.Val) {
+		return false
+	}
+
+	switch op {
+	case promql.EQL, promql.NEQ:
+		return true
+	case promql.GTE, promql.LSS:
+		return lit.Val >= 1
+	}
+
+	return false
+}
+
+// mirroredComparison returns the comparison operator that gives the same result when its operands are swapped
+func mirroredComparison(op promql.ItemType) promql.ItemType {
+	switch op {
+	case promql.GTR:
+		return promql.LSS
+	case promql.LSS:
+		return promql.GTR
+	case promql.GTE:
+		return promql.LTE
+	case promql.LTE:
+		return promql.GTE
+	}
+
+	return op
+}
+
+// increaseThresholdDiagnostics explains why comparisons of increase with whole numbers
+// in alerting rules might not fire as expected
+func increaseThresholdDiagnostics(doc *cache.DocumentHandle) []protocol.Diagnostic {
+	queries, err := doc.GetQueries()
+	if err != nil {
+		return nil
+	}
+
+	var ret []protocol.Diagnostic
+
+	for _, q := range queries {
+		if q.Ast == nil {
+			continue
+		}
+
+		if rule := queryRule(doc, q); rule == nil || rule.Alert == "" {
+			continue
+		}
+
+		promql.Inspect(q.Ast, func(node promql.Node, _ []promql.Node) error {
+			n, ok := node.(*promql.BinaryExpr)
+			if !ok || !isComparisonOperator(n.Op) {
+				return nil
+			}
+
+			increase, threshold, op := n.LHS, n.RHS, n.Op
+			if !containsIncrease(increase) {
+				increase, threshold, op = threshold, increase, mirroredComparison(op)
+			}
+
+			lit, ok := unparen(threshold).(*promql.NumberLiteral)
+			if !ok || !containsIncrease(increase) || !expectsInteger(op, lit) {
+				return nil
+			}
+
+			rng, err := tokenRange(doc, q.Pos+token.Pos(n.PositionRange().Start), q.Pos+token.Pos(n.PositionRange().End))
+			if err != nil {
+				return nil
+			}
+
+			ret = append(ret, protocol.Diagnostic{
+				Range:    rng,
+				Severity: 3, // Information
+				Code:     codeIncreaseThreshold,
+				Source:   "promql-lsp",
+				Message: fmt.Sprintf("increase extrapolates to the boundaries of its range, so the result is usually not a whole number "+
+					"even for counters that only grow by whole numbers; comparing it with %v might not work as expected", lit.Val),
+			})
+
+			return nil
+		})
+	}
+
+	return ret
+}
+
+// metricTypes looks up the types of metrics in the metadata of the connected Prometheus server
+type metricTypes struct {
+	s     *server
+	ctx   context.Context
+	types map[string]string
+}
+
+// get returns the type of a metric, or "" if it is unknown
+func (m *metricTypes) get(metric string) string {
+	if ret, ok := m.types[metric]; ok {
+		return ret
+	}
+
+	m.types[metric] = ""
+
+	api := m.s.getPrometheus()
+	if api == nil {
+		return ""
+	}
+
+	metadata, err := api.TargetsMetadata(m.ctx, "", metric, "1")
+	if err != nil {
+		m.s.reportBackendError(err)
+		return ""
+	}
+
+	if len(metadata) > 0 {
+		m.types[metric] = string(metadata[0].Type)
+	}
+
+	return m.types[metric]
+}
+
+// rawCounterDiagnostics warns about counters that are summed, averaged or graphed without rate() or increase().
+// The raw value of a counter depends on when the process started and drops on restarts, so it is rarely useful.
+func (s *server) rawCounterDiagnostics(doc *cache.DocumentHandle) []protocol.Diagnostic {
+	queries, err := doc.GetQueries()
+	if err != nil || s.getPrometheus() == nil {
+		return nil
+	}
+
+	var ret []protocol.Diagnostic
+
+	for _, q := range queries {
+		if q.Ast == nil || len(q.Err) > 0 {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(s.lifetime, 5*time.Second)
+
+		types := &metricTypes{s: s, ctx: ctx, types: make(map[string]string)}
+
+		promql.Inspect(q.Ast, func(node promql.Node, path []promql.Node) error {
+			vs, ok := node.(*promql.VectorSelector)
+			if !ok || vs.Name == "" {
+				return nil
+			}
+
+			var parent promql.Node
+
+			for i := len(path) - 1; i >= 0; i-- {
+				if _, ok := path[i].(*promql.ParenExpr); !ok {
+					parent = path[i]
+					break
+				}
+			}
+
+			var msg string
+
+			switch p := parent.(type) {
+			case nil:
+				msg = fmt.Sprintf("%s is a counter, its raw value only depends on when the process started; "+
+					"graph rate(%s[5m]) or increase(%s[1h]) instead", vs.Name, vs.Name, vs.Name)
+			case *promql.AggregateExpr:
+				if (p.Op != promql.SUM && p.Op != promql.AVG) || p.Param == node {
+					return nil
+				}
+
+				msg = fmt.Sprintf("%s is a counter, so %s(%s) changes whenever a process restarts; "+
+					"use %s(rate(%s[5m])) or %s(increase(%s[1h])) instead", vs.Name, p.Op, vs.Name, p.Op, vs.Name, p.Op, vs.Name)
+			default:
+				return nil
+			}
+
+			if types.get(vs.Name) != "counter" {
+				return nil
+			}
+
+			rng, err := tokenRange(doc, q.Pos+token.Pos(vs.PosRange.Start), q.Pos+token.Pos(vs.PosRange.End))
+			if err != nil {
+				return nil
+			}
+
+			ret = append(ret, protocol.Diagnostic{
+				Range:    rng,
+				Severity: 2, // Warning
+				Code:     codeRawCounter,
+				Source:   "promql-lsp",
+				Message:  msg,
+			})
+
+			return nil
+		})
+
+		cancel()
+	}
+
+	return ret
+}
@@ -0,0 +1,160 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// TestRawCounterDiagnostics checks that counters used without rate or increase are reported
+func TestRawCounterDiagnostics(*testing.T) {
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		switch {
+		case r.URL.Path == "/api/v1/targets/metadata" && r.FormValue("metric") == "http_requests_total":
+			fmt.Fprint(w, `{"status":"success","data":[{"target":{},"type":"counter","help":"","unit":""}]}`)
+		case r.URL.Path == "/api/v1/targets/metadata":
+			fmt.Fprint(w, `{"status":"success","data":[{"target":{},"type":"gauge","help":"","unit":""}]}`)
+		default:
+			fmt.Fprint(w, `{"status":"success","data":{}}`)
+		}
+	}))
+	defer prom.Close()
+
+	h, err := NewHeadlessServer(context.Background(), &Config{PrometheusURL: prom.URL}, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	tests := []struct {
+		query    string
+		expected int
+	}{
+		{`http_requests_total`, 1},
+		{`sum by (job) (http_requests_total)`, 1},
+		{`avg((http_requests_total))`, 1},
+		{`sum(rate(http_requests_total[5m]))`, 0},
+		{`max(http_requests_total)`, 0},
+		{`http_requests_total > 0`, 0},
+		{`sum(process_resident_memory_bytes)`, 0},
+	}
+
+	for i, test := range tests {
+		report, err := h.AnalyzeDocument(fmt.Sprintf("query%d.promql", i), "promql", test.query)
+		if err != nil {
+			panic(err)
+		}
+
+		found := 0
+
+		for _, d := range report.Diagnostics {
+			if d.Code == codeRawCounter {
+				found++
+			}
+		}
+
+		if found != test.expected {
+			panic(fmt.Sprintf("expected %d raw counter diagnostics for %q, got %d: %v", test.expected, test.query, found, report.Diagnostics))
+		}
+	}
+}
+
+// TestCounterHover checks that hovering functions on counters explains resets and extrapolation
+func TestCounterHover(*testing.T) {
+	h, err := NewHeadlessServer(context.Background(), &Config{}, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	const query = `rate(foo[5m]) + increase(foo[1h]) + irate(foo[5m]) + delta(foo[5m])`
+
+	if err := h.AddDocument("query.promql", "promql", query); err != nil {
+		panic(err)
+	}
+
+	for function, expected := range map[string]string{
+		"rate":     "extrapolated to the boundaries of the range",
+		"increase": "can be a non-integer value",
+		"irate":    "Only the last two samples",
+		"delta":    "",
+	} {
+		hover, err := h.server.Hover(context.Background(), &protocol.HoverParams{
+			TextDocumentPositionParams: protocol.TextDocumentPositionParams{
+				TextDocument: protocol.TextDocumentIdentifier{URI: "query.promql"},
+				Position:     protocol.Position{Line: 0, Character: float64(strings.Index(query, function+"(") + 1)},
+			},
+		})
+		if err != nil || hover == nil {
+			panic(fmt.Sprintf("expected a hover for %s, got %v, %v", function, hover, err))
+		}
+
+		hasNote := strings.Contains(hover.Contents.Value, counterDocsURL)
+		if hasNote != (expected != "") || !strings.Contains(hover.Contents.Value, expected) {
+			panic(fmt.Sprintf("unexpected counter note in the hover for %s: %s", function, hover.Contents.Value))
+		}
+	}
+}
+
+// TestIncreaseThresholdDiagnostics checks that alerts comparing increase with whole numbers are explained
+func TestIncreaseThresholdDiagnostics(*testing.T) {
+	h, err := NewHeadlessServer(context.Background(), &Config{}, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	report, err := h.AnalyzeDocument("rules.yml", "yaml", `groups:
+- name: example
+  rules:
+  - alert: A
+    expr: increase(errors_total[1h]) == 1
+  - alert: B
+    expr: sum(increase(errors_total[1h])) >= 3
+  - alert: C
+    expr: 1 > increase(errors_total[1h])
+  - alert: D
+    expr: increase(errors_total[1h]) > 0
+  - alert: E
+    expr: increase(errors_total[1h]) >= 0.5
+  - alert: F
+    expr: count(increase(errors_total[1h])) == 1
+  - record: G
+    expr: increase(errors_total[1h]) == 1
+`)
+	if err != nil {
+		panic(err)
+	}
+
+	var got []int
+
+	for _, d := range report.Diagnostics {
+		if d.Code == codeIncreaseThreshold {
+			got = append(got, int(d.Range.Start.Line))
+		}
+	}
+
+	if fmt.Sprint(got) != "[4 6 8]" {
+		panic(fmt.Sprintf("expected increase threshold diagnostics for the alerts A, B and C, got lines %v", got))
+	}
+}
@@ -0,0 +1,525 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/token"
+	"regexp"
+	"strings"
+
+	"github.com/prometheus-community/promql-langserver/langserver/cache"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// dashboardMatcher is a label matcher found in the query of a dashboard panel
+type dashboardMatcher struct {
+	Name  string
+	Op    string
+	Value string
+
+	Target cache.DashboardTarget
+
+	// Pos and End span the whole matcher, ValuePos and ValueEnd the label value
+	Pos      token.Pos
+	End      token.Pos
+	ValuePos token.Pos
+	ValueEnd token.Pos
+}
+
+// dashboardMatcherRegexp matches label matchers in the raw JSON source of a query.
+// Double quotes are escaped inside JSON strings. Values containing escape sequences are skipped.
+var dashboardMatcherRegexp = regexp.MustCompile(`([a-zA-Z_][a-zA-Z0-9_]*)\s*(=~|!~|!=|=)\s*(?:\\"([^"\\]*)\\"|'([^'\\]*)')`)
+
+// grafanaVariable is the definition of a dashboard template variable
+type grafanaVariable struct {
+	Name       string                 `json:"name"`
+	Label      string                 `json:"label"`
+	Type       string                 `json:"type"`
+	Datasource json.RawMessage        `json:"datasource"`
+	Query      string                 `json:"query"`
+	Definition string                 `json:"definition"`
+	Refresh    int                    `json:"refresh"`
+	Multi      bool                   `json:"multi"`
+	IncludeAll bool                   `json:"includeAll"`
+	Current    grafanaVariableValue   `json:"current"`
+	Options    []grafanaVariableValue `json:"options"`
+	Hide       int                    `json:"hide"`
+	Sort       int                    `json:"sort"`
+}
+
+type grafanaVariableValue struct {
+	Text  string `json:"text"`
+	Value string `json:"value"`
+}
+
+// embedsQueryStrings checks whether the queries of a document are string literals, i.e. it is a Grafana dashboard,
+// a Jsonnet document or a console template
+func embedsQueryStrings(doc *cache.DocumentHandle) bool {
+	switch doc.GetLanguageID() {
+	case "json", "jsonnet", "html":
+		return true
+	default:
+		return false
+	}
+}
+
+// embeddedStringQuote returns the quote of the string literal of a Grafana dashboard, Jsonnet document
+// or console template that contains the query at a position, where inserted text has to be escaped.
+// It returns 0 if the position isn't inside such a query.
+func embeddedStringQuote(doc *cache.DocumentHandle, pos token.Pos) byte {
+	if !embedsQueryStrings(doc) {
+		return 0
+	}
+
+	queries, err := doc.GetQueries()
+	if err != nil {
+		return 0
+	}
+
+	content, err := doc.GetContent()
+	if err != nil {
+		return 0
+	}
+
+	for _, q := range queries {
+		if q.InJSONString && q.Pos <= pos && int(pos-q.Pos) <= len(q.Content) {
+			if offset := doc.ByteOffset(q.Pos); offset > 0 {
+				return content[offset-1]
+			}
+		}
+	}
+
+	return 0
+}
+
+// escapeJSONString escapes text for insertion into a JSON string
+func escapeJSONString(text string) string {
+	var b strings.Builder
+
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+
+	if err := enc.Encode(text); err != nil {
+		return text
+	}
+
+	ret := strings.TrimSuffix(b.String(), "\n")
+
+	return ret[1 : len(ret)-1]
+}
+
+// escapeString escapes text for insertion into a string literal with the given quote.
+// Jsonnet strings in single quotes use the escape sequences of JSON, but don't need double quotes escaped.
+func escapeString(text string, quote byte) string {
+	if quote != '\'' {
+		return escapeJSONString(text)
+	}
+
+	return strings.NewReplacer(`\"`, `"`, `'`, `\'`).Replace(escapeJSONString(text))
+}
+
+// escapeJSONEdit adapts an edit computed for PromQL text to a query embedded in a string literal
+// of a Grafana dashboard or Jsonnet document: the new text is escaped and quotes at the ends of the
+// replaced range are extended to include their backslash, since masking moves escaped quotes to one
+// of their two characters. It returns false if the edit doesn't touch such a query.
+func escapeJSONEdit(doc *cache.DocumentHandle, pos *token.Pos, end *token.Pos, newText *string) bool {
+	quote := embeddedStringQuote(doc, *pos)
+	if quote == 0 {
+		return false
+	}
+
+	content, err := doc.GetContent()
+	if err != nil {
+		return false
+	}
+
+	escapedQuoteAt := func(offset int) bool {
+		return offset > 0 && offset < len(content) && content[offset-1] == '\\' &&
+			(content[offset] == '"' || content[offset] == '\'')
+	}
+
+	if escapedQuoteAt(doc.ByteOffset(*pos)) {
+		*pos--
+	}
+
+	if escapedQuoteAt(doc.ByteOffset(*end)) {
+		*end++
+	}
+
+	*newText = escapeString(*newText, quote)
+
+	return true
+}
+
+// escapeJSONEdits applies escapeJSONEdit to protocol edits
+func escapeJSONEdits(doc *cache.DocumentHandle, edits []protocol.TextEdit) {
+	for i := range edits {
+		pos, err := doc.ProtocolPositionToTokenPos(edits[i].Range.Start)
+		if err != nil {
+			continue
+		}
+
+		end, err := doc.ProtocolPositionToTokenPos(edits[i].Range.End)
+		if err != nil {
+			continue
+		}
+
+		if !escapeJSONEdit(doc, &pos, &end, &edits[i].NewText) {
+			continue
+		}
+
+		if rng, err := tokenRange(doc, pos, end); err == nil {
+			edits[i].Range = rng
+		}
+	}
+}
+
+// escapeJSONCodeActions escapes the edits of code actions that were computed for PromQL text,
+// so that they can be applied to the queries of a Grafana dashboard, Jsonnet document or console template
+func escapeJSONCodeActions(doc *cache.DocumentHandle, actions []protocol.CodeAction) []protocol.CodeAction {
+	if !embedsQueryStrings(doc) {
+		return actions
+	}
+
+	for _, action := range actions {
+		escapeJSONEdits(doc, action.Edit.Changes[doc.GetURI()])
+	}
+
+	return actions
+}
+
+// dashboardVariables returns the names of the template variables defined by a dashboard
+func dashboardVariables(root *cache.JSONNode) map[string]bool {
+	ret := make(map[string]bool)
+
+	list := root.Get("templating").Get("list")
+	if list == nil || list.Kind != cache.JSONArray {
+		return ret
+	}
+
+	for _, v := range list.Values {
+		if name := v.Get("name"); name != nil {
+			ret[name.Value] = true
+		}
+	}
+
+	return ret
+}
+
+// dashboardVariableValues returns the current values of the template variables of a dashboard. Like Grafana
+// does for Prometheus queries, several selected values are joined to a regular expression.
+func dashboardVariableValues(root *cache.JSONNode) map[string]string {
+	ret := make(map[string]string)
+
+	list := root.Get("templating").Get("list")
+	if list == nil || list.Kind != cache.JSONArray {
+		return ret
+	}
+
+	for _, v := range list.Values {
+		name, current := v.Get("name"), v.Get("current").Get("value")
+		if name == nil || current == nil {
+			continue
+		}
+
+		values := []string{current.Value}
+		if current.Kind == cache.JSONArray {
+			values = nil
+
+			for _, value := range current.Values {
+				values = append(values, value.Value)
+			}
+		}
+
+		if len(values) == 1 && values[0] == "$__all" {
+			if all := v.Get("allValue"); all != nil && all.Kind == cache.JSONString && all.Value != "" {
+				ret[name.Value] = all.Value
+				continue
+			}
+
+			values = nil
+
+			if options := v.Get("options"); options != nil {
+				for _, option := range options.Values {
+					if value := option.Get("value"); value != nil && value.Value != "$__all" {
+						values = append(values, value.Value)
+					}
+				}
+			}
+		}
+
+		switch len(values) {
+		case 0:
+		case 1:
+			ret[name.Value] = values[0]
+		default:
+			for i, value := range values {
+				values[i] = regexp.QuoteMeta(value)
+			}
+
+			ret[name.Value] = "(" + strings.Join(values, "|") + ")"
+		}
+	}
+
+	return ret
+}
+
+// getDashboardMatchers returns the label matchers used in the queries of a dashboard
+func getDashboardMatchers(doc *cache.DocumentHandle, root *cache.JSONNode) ([]dashboardMatcher, error) {
+	var ret []dashboardMatcher
+
+	for _, target := range cache.DashboardTargets(root) {
+		raw, err := doc.GetSubstring(target.Expr.Pos, target.Expr.End)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, m := range dashboardMatcherRegexp.FindAllStringSubmatchIndex(raw, -1) {
+			valueStart, valueEnd := m[6], m[7]
+			if valueStart < 0 {
+				valueStart, valueEnd = m[8], m[9]
+			}
+
+			ret = append(ret, dashboardMatcher{
+				Name:     raw[m[2]:m[3]],
+				Op:       raw[m[4]:m[5]],
+				Value:    raw[valueStart:valueEnd],
+				Target:   target,
+				Pos:      target.Expr.Pos + token.Pos(m[0]),
+				End:      target.Expr.Pos + token.Pos(m[1]),
+				ValuePos: target.Expr.Pos + token.Pos(valueStart),
+				ValueEnd: target.Expr.Pos + token.Pos(valueEnd),
+			})
+		}
+	}
+
+	return ret, nil
+}
+
+// isVariableReference checks whether a label value consists of a reference to the given dashboard variable
+func isVariableReference(value string, name string) bool {
+	return value == "$"+name || value == "${"+name+"}" || value == "[["+name+"]]"
+}
+
+// dashboardCodeActions offers to extract label matchers that are repeated across the panels of
+// a Grafana dashboard into a dashboard variable
+// nolint: funlen
+func dashboardCodeActions(doc *cache.DocumentHandle, rng protocol.Range) []protocol.CodeAction {
+	root := doc.GrafanaDashboard()
+	if root == nil {
+		return nil
+	}
+
+	start, err := doc.ProtocolPositionToTokenPos(rng.Start)
+	if err != nil {
+		return nil
+	}
+
+	end, err := doc.ProtocolPositionToTokenPos(rng.End)
+	if err != nil {
+		return nil
+	}
+
+	matchers, err := getDashboardMatchers(doc, root)
+	if err != nil {
+		return nil
+	}
+
+	variables := dashboardVariables(root)
+
+	var ret []protocol.CodeAction
+
+	done := make(map[string]bool)
+
+	for _, selected := range matchers {
+		if selected.End < start || selected.Pos > end {
+			continue
+		}
+
+		key := fmt.Sprint(selected.Name, selected.Op, selected.Value)
+		if done[key] {
+			continue
+		}
+
+		done[key] = true
+
+		var occurrences []dashboardMatcher
+
+		panels := make(map[*cache.JSONNode]bool)
+
+		for _, m := range matchers {
+			if m.Name == selected.Name && m.Op == selected.Op && m.Value == selected.Value {
+				occurrences = append(occurrences, m)
+				panels[m.Target.Panel] = true
+			}
+		}
+
+		// Only matchers repeated across panels are worth a variable
+		if len(panels) < 2 {
+			continue
+		}
+
+		name := selected.Name
+		matcherText := fmt.Sprintf("%s%s%q", selected.Name, selected.Op, selected.Value)
+
+		var edits []protocol.TextEdit
+
+		var title string
+
+		switch {
+		case isVariableReference(selected.Value, name) && variables[name]:
+			continue
+		case isVariableReference(selected.Value, name):
+			title = fmt.Sprintf("Define dashboard variable $%s", name)
+		case variables[name]:
+			title = fmt.Sprintf("Replace %s with dashboard variable $%s", matcherText, name)
+		default:
+			title = fmt.Sprintf("Extract %s into dashboard variable $%s", matcherText, name)
+		}
+
+		if !isVariableReference(selected.Value, name) {
+			for _, m := range occurrences {
+				valueRange, err := tokenRange(doc, m.ValuePos, m.ValueEnd)
+				if err != nil {
+					return nil
+				}
+
+				edits = append(edits, protocol.TextEdit{Range: valueRange, NewText: "$" + name})
+			}
+		}
+
+		if !variables[name] {
+			edit, err := dashboardVariableEdit(doc, root, newGrafanaVariable(doc, selected))
+			if err != nil {
+				return nil
+			}
+
+			edits = append(edits, edit)
+		}
+
+		ret = append(ret, protocol.CodeAction{
+			Title: title,
+			Kind:  protocol.RefactorExtract,
+			Edit: protocol.WorkspaceEdit{
+				Changes: map[string][]protocol.TextEdit{
+					doc.GetURI(): edits,
+				},
+			},
+		})
+	}
+
+	return ret
+}
+
+// newGrafanaVariable creates a query variable listing the values of the label of a matcher
+func newGrafanaVariable(doc *cache.DocumentHandle, m dashboardMatcher) *grafanaVariable {
+	datasource := json.RawMessage("null")
+
+	if ds := m.Target.Panel.Get("datasource"); ds != nil {
+		if raw, err := doc.GetSubstring(ds.Pos, ds.End); err == nil {
+			datasource = json.RawMessage(raw)
+		}
+	}
+
+	query := fmt.Sprintf("label_values(%s)", m.Name)
+
+	v := &grafanaVariable{
+		Name:       m.Name,
+		Label:      m.Name,
+		Type:       "query",
+		Datasource: datasource,
+		Query:      query,
+		Definition: query,
+		Refresh:    1,
+		// Regex matchers can match multiple values
+		Multi:   m.Op == "=~" || m.Op == "!~",
+		Options: []grafanaVariableValue{},
+		Sort:    1,
+	}
+
+	if !isVariableReference(m.Value, m.Name) {
+		v.Current = grafanaVariableValue{Text: m.Value, Value: m.Value}
+	}
+
+	return v
+}
+
+// dashboardVariableEdit inserts a variable definition into the templating section of a dashboard
+func dashboardVariableEdit(doc *cache.DocumentHandle, root *cache.JSONNode, v *grafanaVariable) (protocol.TextEdit, error) {
+	templating := root.Get("templating")
+	list := templating.Get("list")
+
+	var pos token.Pos
+
+	var indent string
+
+	var format string
+
+	switch {
+	case list != nil && list.Kind == cache.JSONArray && len(list.Values) > 0:
+		last := list.Values[len(list.Values)-1]
+		pos = last.End
+		indent = lineIndent(doc, last.Pos)
+		format = ",\n" + indent + "%s"
+	case list != nil && list.Kind == cache.JSONArray:
+		pos = list.Pos + 1
+		indent = lineIndent(doc, list.Pos) + "  "
+		format = "\n" + indent + "%s\n" + lineIndent(doc, list.Pos)
+	case templating != nil && templating.Kind == cache.JSONObject:
+		pos = templating.Pos + 1
+		indent = lineIndent(doc, templating.Pos) + "  "
+
+		format = "\n" + indent + `"list": [%s]`
+		if len(templating.Keys) > 0 {
+			format += ","
+		}
+	default:
+		pos = root.Pos + 1
+		indent = "  "
+
+		format = "\n" + indent + `"templating": {"list": [%s]}`
+		if len(root.Keys) > 0 {
+			format += ","
+		}
+	}
+
+	definition, err := json.MarshalIndent(v, indent, "  ")
+	if err != nil {
+		return protocol.TextEdit{}, err
+	}
+
+	rng, err := tokenRange(doc, pos, pos)
+	if err != nil {
+		return protocol.TextEdit{}, err
+	}
+
+	return protocol.TextEdit{Range: rng, NewText: fmt.Sprintf(format, definition)}, nil
+}
+
+// lineIndent returns the whitespace preceding a position on its line
+func lineIndent(doc *cache.DocumentHandle, pos token.Pos) string {
+	position, err := doc.TokenPosToTokenPosition(pos)
+	if err != nil {
+		return ""
+	}
+
+	line, err := doc.GetSubstring(pos-token.Pos(position.Column-1), pos)
+	if err != nil || strings.TrimSpace(line) != "" {
+		return ""
+	}
+
+	return line
+}
@@ -0,0 +1,101 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// TestDashboardDiagnostics checks that the queries of Grafana dashboards are analyzed,
+// with escaped quotes and template variables not causing errors
+func TestDashboardDiagnostics(*testing.T) {
+	h, err := NewHeadlessServer(context.Background(), &Config{}, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	dashboard := `{
+  "panels": [
+    {"targets": [{"expr": "sum by (job) (rate(http_requests_total{job=~\"$job\"}[$__rate_interval]))"}]},
+    {"targets": [{"expr": "rate(foo[5m]"}]}
+  ]
+}`
+
+	result, err := h.AnalyzeDocument("dashboard.json", "json", dashboard)
+	if err != nil {
+		panic(err)
+	}
+
+	if len(result.Diagnostics) == 0 {
+		panic("expected a diagnostic for the unclosed parenthesis")
+	}
+
+	for _, d := range result.Diagnostics {
+		if d.Range.Start.Line != 3 {
+			panic(fmt.Sprintf("expected diagnostics only in the second panel, got %v", d))
+		}
+	}
+}
+
+// TestEscapeJSONEdit checks that edits of dashboard queries replace escaped quotes completely
+func TestEscapeJSONEdit(*testing.T) {
+	h, err := NewHeadlessServer(context.Background(), &Config{}, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	dashboard := `{"panels": [{"targets": [{"expr": "up{job=\"ap\"}"}]}]}`
+
+	if err := h.AddDocument("dashboard.json", "json", dashboard); err != nil {
+		panic(err)
+	}
+
+	doc, err := h.server.cache.GetDocument("dashboard.json")
+	if err != nil {
+		panic(err)
+	}
+
+	// The label value as the parser sees it, i.e. without the backslashes
+	start := strings.Index(dashboard, `"ap\"`)
+	end := start + len(`"ap\"`)
+
+	pos, err := doc.ProtocolPositionToTokenPos(protocol.Position{Line: 0, Character: float64(start)})
+	if err != nil {
+		panic(err)
+	}
+
+	endPos, err := doc.ProtocolPositionToTokenPos(protocol.Position{Line: 0, Character: float64(end)})
+	if err != nil {
+		panic(err)
+	}
+
+	newText := `"api"`
+
+	if !escapeJSONEdit(doc, &pos, &endPos, &newText) {
+		panic("expected the edit to be inside a dashboard query")
+	}
+
+	edited := dashboard[:doc.ByteOffset(pos)] + newText + dashboard[doc.ByteOffset(endPos):]
+
+	if expected := `{"panels": [{"targets": [{"expr": "up{job=\"api\"}"}]}]}`; edited != expected {
+		panic(fmt.Sprintf("expected %s, got %s", expected, edited))
+	}
+}
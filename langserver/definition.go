@@ -20,8 +20,10 @@ import (
 	"github.com/prometheus/prometheus/promql"
 )
 
-// Definition is required by the protocol.Server interface
-func (s *server) Definition(ctx context.Context, params *protocol.DefinitionParams) ([]protocol.Location, error) {
+// Definition resolves a metric name to the record fields of the recording rules
+// in all open documents that define it
+// required by the protocol.Server interface
+func (s *server) Definition(_ context.Context, params *protocol.DefinitionParams) ([]protocol.Location, error) {
 	location, err := s.cache.Find(&params.TextDocumentPositionParams)
 	if err != nil {
 		return nil, nil
@@ -29,32 +31,25 @@ func (s *server) Definition(ctx context.Context, params *protocol.DefinitionPara
 
 	defs := []protocol.Location{}
 
-	switch n := location.Node.(type) { // nolint: gocritic
-	case *promql.VectorSelector:
-		queries, err := location.Doc.GetQueries()
-		if err != nil {
-			break
-		}
-
-		for _, q := range queries {
-			if q.Record == n.Name {
-				def := protocol.Location{
-					URI: params.TextDocument.URI,
-				}
-
-				defLocation := *location
-
-				defLocation.Node = q.Ast
-				defLocation.Query = q
+	vs, ok := location.Node.(*promql.VectorSelector)
+	if !ok || vs.Name == "" {
+		return defs, nil
+	}
 
-				def.Range, err = getEditRange(&defLocation, "")
-				if err != nil {
-					break
-				}
+	for _, ref := range s.cache.GetMetricIndex()[vs.Name] {
+		if !ref.Definition {
+			continue
+		}
 
-				defs = append(defs, def)
-			}
+		rng, err := tokenRange(ref.Doc, ref.Pos, ref.End)
+		if err != nil {
+			continue
 		}
+
+		defs = append(defs, protocol.Location{
+			URI:   ref.Doc.GetURI(),
+			Range: rng,
+		})
 	}
 
 	return defs, nil
@@ -0,0 +1,73 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// TestDefinition checks that metrics are resolved to the recording rules defining them
+func TestDefinition(*testing.T) {
+	h, err := NewHeadlessServer(context.Background(), &Config{}, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	const rules = `groups:
+- name: example
+  rules:
+  - record: job:http_errors:rate5m
+    expr: sum by (job) (rate(http_errors_total[5m]))
+`
+
+	if err := h.AddDocument("rules.yml", "yaml", rules); err != nil {
+		panic(err)
+	}
+
+	if err := h.AddDocument("query.promql", "promql", `job:http_errors:rate5m > http_errors_total`); err != nil {
+		panic(err)
+	}
+
+	tests := []struct {
+		character float64
+		expected  []protocol.Location
+	}{
+		{3, []protocol.Location{{
+			URI:   "rules.yml",
+			Range: protocol.Range{Start: protocol.Position{Line: 3, Character: 12}, End: protocol.Position{Line: 3, Character: 34}},
+		}}},
+		{30, []protocol.Location{}},
+	}
+
+	for _, test := range tests {
+		defs, err := h.server.Definition(context.Background(), &protocol.DefinitionParams{
+			TextDocumentPositionParams: protocol.TextDocumentPositionParams{
+				TextDocument: protocol.TextDocumentIdentifier{URI: "query.promql"},
+				Position:     protocol.Position{Line: 0, Character: test.character},
+			},
+		})
+		if err != nil {
+			panic(err)
+		}
+
+		if fmt.Sprint(defs) != fmt.Sprint(test.expected) {
+			panic(fmt.Sprintf("Expected %v at character %v, got %v", test.expected, test.character, defs))
+		}
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"go/token"
+
+	"github.com/prometheus-community/promql-langserver/langserver/cache"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+	"github.com/prometheus/prometheus/promql"
+)
+
+// deprecatedFunctions explains why PromQL functions shouldn't be used anymore
+var deprecatedFunctions = map[string]string{ // nolint: gochecknoglobals
+	"holt_winters": "holt_winters is removed in Prometheus 3, where it is called double_exponential_smoothing " +
+		"and requires the promql-experimental-functions feature flag",
+}
+
+// deprecatedFunctionDiagnostics reports calls of deprecated functions, they are tagged so editors strike them through
+func deprecatedFunctionDiagnostics(doc *cache.DocumentHandle) []protocol.Diagnostic {
+	queries, err := doc.GetQueries()
+	if err != nil {
+		return nil
+	}
+
+	var ret []protocol.Diagnostic
+
+	for _, q := range queries {
+		if q.Ast == nil {
+			continue
+		}
+
+		promql.Inspect(q.Ast, func(node promql.Node, _ []promql.Node) error {
+			n, ok := node.(*promql.Call)
+			if !ok {
+				return nil
+			}
+
+			msg, ok := deprecatedFunctions[n.Func.Name]
+			if !ok {
+				return nil
+			}
+
+			start := q.Pos + token.Pos(n.PosRange.Start)
+
+			rng, err := tokenRange(doc, start, start+token.Pos(len(n.Func.Name)))
+			if err != nil {
+				return nil
+			}
+
+			ret = append(ret, protocol.Diagnostic{
+				Range:    rng,
+				Severity: 2, // Warning
+				Code:     codeDeprecatedFunction,
+				Source:   "promql-lsp",
+				Message:  msg,
+			})
+
+			return nil
+		})
+	}
+
+	return ret
+}
@@ -0,0 +1,129 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// upstreamDiagnosticDocs links diagnostic codes to the upstream documentation of the checked feature
+var upstreamDiagnosticDocs = map[string]string{ // nolint: gochecknoglobals
+	fixMatcherNormalize:     "https://prometheus.io/docs/prometheus/latest/querying/basics/#instant-vector-selectors",
+	fixYamlEscaping:         "https://prometheus.io/docs/prometheus/latest/querying/basics/#string-literals",
+	fixPrecedence:           "https://prometheus.io/docs/prometheus/latest/querying/operators/#binary-operator-precedence",
+	fixScalarSeries:         "https://prometheus.io/docs/prometheus/latest/querying/functions/#scalar",
+	fixConversionRoundTrip:  "https://prometheus.io/docs/prometheus/latest/querying/functions/#vector",
+	codeRuleOrder:           "https://prometheus.io/docs/prometheus/latest/configuration/recording_rules/#rule_group",
+	codeDuplicateGroup:      "https://prometheus.io/docs/prometheus/latest/configuration/recording_rules/#rule_group",
+	codeDuplicateRecord:     "https://prometheus.io/docs/prometheus/latest/configuration/recording_rules/#recording_rules",
+	codeRuleCycle:           "https://prometheus.io/docs/prometheus/latest/configuration/recording_rules/#recording_rules",
+	codeThanosResolution:    "https://thanos.io/components/compact.md/#downsampling-resolution-and-retention",
+	codeUnroutedAlert:       "https://prometheus.io/docs/alerting/configuration/#route",
+	codeInvalidDuration:     "https://prometheus.io/docs/prometheus/latest/querying/basics/#time-durations",
+	codeSubqueryStep:        "https://prometheus.io/docs/prometheus/latest/querying/basics/#subquery",
+	codeAtModifierRetention: "https://prometheus.io/docs/prometheus/latest/storage/#operational-aspects",
+	codeRangeRetention:      "https://prometheus.io/docs/prometheus/latest/storage/#operational-aspects",
+	codeGroupLabelMissing:   "https://prometheus.io/docs/prometheus/latest/querying/operators/#many-to-one-and-one-to-many-vector-matches",
+	codeGroupLabelCollision: "https://prometheus.io/docs/prometheus/latest/querying/operators/#many-to-one-and-one-to-many-vector-matches",
+	codeUnitMismatch:        "https://prometheus.io/docs/practices/naming/#base-units",
+	codeHistogramQuantile:   "https://prometheus.io/docs/prometheus/latest/querying/functions/#histogram_quantile",
+	codeHistogramLe:         "https://prometheus.io/docs/prometheus/latest/querying/functions/#histogram_quantile",
+	codeHistogramBuckets:    "https://prometheus.io/docs/practices/histograms/#quantiles",
+	codeIncreaseThreshold:   counterDocsURL,
+	codeRawCounter:          "https://prometheus.io/docs/concepts/metric_types/#counter",
+	codeRuleGroupDuration:   "https://prometheus.io/docs/prometheus/latest/configuration/recording_rules/#rule_group",
+	codeDeprecatedFunction:  "https://prometheus.io/docs/prometheus/latest/migration/#promql",
+	codeUnusedTemplateVar:   "https://prometheus.io/docs/prometheus/latest/configuration/template_reference/",
+}
+
+// DiagnosticDocsConfig configures the documentation diagnostics link to,
+// e.g. the internal standards of an organization
+type DiagnosticDocsConfig struct {
+	// URLTemplate is a Go template that is expanded with the code of a diagnostic
+	// as {{ .Code }}, e.g. "https://wiki.example.com/promql/{{ .Code }}"
+	URLTemplate string `yaml:"url_template"`
+	// KeepUpstream keeps the links to the upstream documentation where there is one.
+	// The configured URL is appended to the diagnostic message instead.
+	KeepUpstream bool `yaml:"keep_upstream"`
+
+	template *template.Template
+}
+
+// parse compiles the URL template
+func (c *DiagnosticDocsConfig) parse() error {
+	if c == nil || c.URLTemplate == "" {
+		return nil
+	}
+
+	tmpl, err := template.New("url_template").Parse(c.URLTemplate)
+	if err != nil {
+		return errors.Wrap(err, "invalid diagnostic_docs.url_template")
+	}
+
+	c.template = tmpl
+
+	return nil
+}
+
+// docsURL returns the configured documentation URL for a diagnostic code,
+// or an empty string if none is configured
+func (c *DiagnosticDocsConfig) docsURL(code string) string {
+	if c == nil || c.template == nil {
+		return ""
+	}
+
+	var url strings.Builder
+
+	if err := c.template.Execute(&url, struct{ Code string }{code}); err != nil {
+		return ""
+	}
+
+	return url.String()
+}
+
+// addDiagnosticDocs links diagnostics to the documentation of the check they were found by
+func (s *server) addDiagnosticDocs(diagnostics []protocol.Diagnostic) {
+	var docs *DiagnosticDocsConfig
+	if s.getConfig() != nil {
+		docs = s.getConfig().DiagnosticDocs
+	}
+
+	for i := range diagnostics {
+		d := &diagnostics[i]
+
+		code, ok := d.Code.(string)
+		if !ok || d.CodeDescription != nil {
+			continue
+		}
+
+		upstream := upstreamDiagnosticDocs[code]
+		custom := docs.docsURL(code)
+
+		switch {
+		case custom != "" && docs.KeepUpstream && upstream != "":
+			d.CodeDescription = &protocol.CodeDescription{Href: upstream}
+			d.Message = fmt.Sprintf("%s (see %s)", d.Message, custom)
+		case custom != "":
+			d.CodeDescription = &protocol.CodeDescription{Href: custom}
+		case upstream != "":
+			d.CodeDescription = &protocol.CodeDescription{Href: upstream}
+		}
+	}
+}
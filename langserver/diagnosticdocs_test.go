@@ -0,0 +1,92 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// TestDiagnosticDocs checks that diagnostics link to the upstream documentation or to the configured URLs
+func TestDiagnosticDocs(*testing.T) {
+	const upstream = "https://prometheus.io/docs/prometheus/latest/configuration/recording_rules/#rule_group"
+
+	tests := []struct {
+		config  string
+		href    string
+		message string
+	}{
+		{``, upstream, ""},
+		{`diagnostic_docs: {url_template: "https://wiki.example.com/promql/{{ .Code }}"}`,
+			"https://wiki.example.com/promql/" + codeDuplicateGroup, ""},
+		{`diagnostic_docs: {url_template: "https://wiki.example.com/promql/{{ .Code }}", keep_upstream: true}`,
+			upstream, "(see https://wiki.example.com/promql/" + codeDuplicateGroup + ")"},
+	}
+
+	for i, test := range tests {
+		config, err := ParseConfig([]byte(test.config))
+		if err != nil {
+			panic(err)
+		}
+
+		h, err := NewHeadlessServer(context.Background(), config, nil)
+		if err != nil {
+			panic(err)
+		}
+
+		report, err := h.AnalyzeDocument(fmt.Sprintf("rules%d.yml", i), "yaml", `groups:
+- name: example
+  rules:
+  - record: a
+    expr: foo
+- name: example
+  rules:
+  - record: b
+    expr: bar
+`)
+		if err != nil {
+			panic(err)
+		}
+
+		h.Close()
+
+		found := false
+
+		for _, d := range report.Diagnostics {
+			if d.Code != codeDuplicateGroup {
+				continue
+			}
+
+			found = true
+
+			if d.CodeDescription == nil || d.CodeDescription.Href != test.href {
+				panic(fmt.Sprintf("expected the diagnostic to link to %s with config %q, got %v", test.href, test.config, d.CodeDescription))
+			}
+
+			if !strings.HasSuffix(d.Message, test.message) {
+				panic(fmt.Sprintf("expected the message %q to end with %q", d.Message, test.message))
+			}
+		}
+
+		if !found {
+			panic(fmt.Sprintf("expected a duplicate group diagnostic, got %v", report.Diagnostics))
+		}
+	}
+
+	if _, err := ParseConfig([]byte(`diagnostic_docs: {url_template: "{{ .Code"}`)); err == nil {
+		panic("expected an invalid url_template to be rejected")
+	}
+}
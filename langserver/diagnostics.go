@@ -14,15 +14,55 @@
 package langserver
 
 import (
-	"context"
+	"fmt"
+	"sort"
 
 	"github.com/pkg/errors"
 
+	"github.com/prometheus-community/promql-langserver/langserver/cache"
 	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
 )
 
+// The codes of the diagnostics found by the checks of the server. Diagnostics
+// that come with a quick fix use the rule of the quick fix as code.
+const (
+	codeRuleOrder           = "rule-order"
+	codeDuplicateGroup      = "duplicate-group"
+	codeDuplicateRecord     = "duplicate-record"
+	codeRuleCycle           = "rule-cycle"
+	codeThanosResolution    = "thanos-resolution"
+	codeUnroutedAlert       = "unrouted-alert"
+	codeInvalidDuration     = "invalid-duration"
+	codeSubqueryStep        = "subquery-step"
+	codeAtModifierRetention = "at-modifier-retention"
+	codeRangeRetention      = "range-retention"
+	codeGroupLabelMissing   = "group-label-missing"
+	codeGroupLabelCollision = "group-label-collision"
+	codeUnitMismatch        = "unit-mismatch"
+	codeHistogramQuantile   = "histogram-quantile"
+	codeHistogramLe         = "histogram-le"
+	codeHistogramBuckets    = "histogram-buckets"
+	codeIncreaseThreshold   = "increase-threshold"
+	codeRawCounter          = "raw-counter"
+	codeRuleGroupLimit      = "rule-group-limit"
+	codeRuleGroupDuration   = "rule-group-duration"
+	codeRuleSampleLimit     = "rule-sample-limit"
+	codeUnknownLabel        = "unknown-label"
+	codeUnknownMetric       = "unknown-metric"
+	codeDroppedLabel        = "dropped-label"
+	codeHighCardinality     = "high-cardinality"
+	codeRuleSchema          = "rule-schema"
+	codeDuplicateRule       = "duplicate-rule"
+	codeAlertTemplate       = "alert-template"
+	codeTemplateLabel       = "template-label"
+	codeUnusedTemplateVar   = "unused-template-variable"
+	codeDeprecatedFunction  = "deprecated-function"
+)
+
 // nolint:funlen
 func (s *server) diagnostics(uri string) {
+	defer s.startAnalysis()()
+
 	d, err := s.cache.GetDocument(uri)
 	if err != nil {
 		// nolint: errcheck
@@ -30,6 +70,8 @@ func (s *server) diagnostics(uri string) {
 			Type:    protocol.Error,
 			Message: errors.Wrapf(err, "document not found in cache").Error(),
 		})
+
+		return
 	}
 
 	version, expired := d.GetVersion()
@@ -42,34 +84,121 @@ func (s *server) diagnostics(uri string) {
 		Version: version,
 	}
 
-	diagnostics, err := d.GetDiagnostics()
+	reply.Diagnostics, err = s.getDiagnostics(d)
 	if err != nil {
 		return
 	}
 
-	reply.Diagnostics = diagnostics
+	if s.telemetry != nil {
+		s.telemetry.recordParseErrors(d)
+	}
 
-	if err = s.client.PublishDiagnostics(s.lifetime, reply); err != nil {
-		// nolint: errcheck
-		s.client.LogMessage(d.GetContext(), &protocol.LogMessageParams{
-			Type:    protocol.Error,
-			Message: errors.Wrapf(err, "failed to publish diagnostics").Error(),
-		})
+	s.publishDiagnostics(reply)
+
+	if s.hasAlertmanagerConfig(uri) {
+		s.diagnoseRoutedRules(uri)
 	}
 }
 
-func (s *server) clearDiagnostics(ctx context.Context, uri string, version float64) {
-	diagnostics := &protocol.PublishDiagnosticsParams{
-		URI:         uri,
-		Version:     version,
-		Diagnostics: []protocol.Diagnostic{},
+// publishDiagnostics queues the diagnostics of a document, they replace queued diagnostics of older versions
+func (s *server) publishDiagnostics(diagnostics *protocol.PublishDiagnosticsParams) {
+	s.notifications.notify("textDocument/publishDiagnostics "+diagnostics.URI, func() {
+		if err := s.client.PublishDiagnostics(s.lifetime, diagnostics); err != nil {
+			// nolint: errcheck
+			s.client.LogMessage(s.lifetime, &protocol.LogMessageParams{
+				Type:    protocol.Error,
+				Message: errors.Wrapf(err, "failed to publish diagnostics").Error(),
+			})
+		}
+	})
+}
+
+// getDiagnostics returns the diagnostics found by the cache together with
+// the results of the additional checks performed by the server
+func (s *server) getDiagnostics(d *cache.DocumentHandle) ([]protocol.Diagnostic, error) {
+	diagnostics, err := d.GetDiagnostics()
+	if err != nil {
+		return nil, err
 	}
 
-	if err := s.client.PublishDiagnostics(ctx, diagnostics); err != nil {
-		// nolint: errcheck
-		s.client.LogMessage(s.lifetime, &protocol.LogMessageParams{
-			Type:    protocol.Error,
-			Message: errors.Wrapf(err, "failed to publish diagnostics").Error(),
-		})
+	// The diagnostics slice is owned by the cache and must not be appended to
+	ret := append([]protocol.Diagnostic{}, diagnostics...)
+	ret = append(ret, s.catalogDiagnostics(d)...)
+	ret = append(ret, s.ruleOrderDiagnostics(d)...)
+	ret = append(ret, s.duplicateRuleDiagnostics(d)...)
+	ret = append(ret, ruleFileDiagnostics(d)...)
+	ret = append(ret, s.alertTemplateDiagnostics(d)...)
+	ret = append(ret, s.ruleCycleDiagnostics(d)...)
+	ret = append(ret, quickFixDiagnostics(s.getQuickFixes(d))...)
+	ret = append(ret, s.thanosDiagnostics(d)...)
+	ret = append(ret, s.alertRoutingDiagnostics(d)...)
+	ret = append(ret, durationDiagnostics(d)...)
+	ret = append(ret, subqueryDiagnostics(d)...)
+	ret = append(ret, s.atModifierDiagnostics(d)...)
+	ret = append(ret, s.rangeRetentionDiagnostics(d)...)
+	ret = append(ret, s.groupModifierDiagnostics(d)...)
+	ret = append(ret, s.unitDiagnostics(d)...)
+	ret = append(ret, histogramDiagnostics(d)...)
+	ret = append(ret, increaseThresholdDiagnostics(d)...)
+	ret = append(ret, s.rawCounterDiagnostics(d)...)
+	ret = append(ret, s.ruleLimitDiagnostics(d)...)
+	ret = append(ret, s.unknownLabelDiagnostics(d)...)
+	ret = append(ret, s.unknownMetricDiagnostics(d)...)
+	ret = append(ret, s.vectorMatchingDiagnostics(d)...)
+	ret = append(ret, s.droppedLabelDiagnostics(d)...)
+	ret = append(ret, s.cardinalityDiagnostics(d)...)
+	ret = append(ret, deprecatedFunctionDiagnostics(d)...)
+
+	s.addDiagnosticDocs(ret)
+	addDiagnosticTags(ret)
+	s.remapSeverities(ret)
+
+	return sortDiagnostics(ret), nil
+}
+
+// sortDiagnostics orders diagnostics by their range, then by code and message, and removes duplicates.
+// The checks run concurrently, so without sorting the order would change with every recompile
+// and clients would reorder their problem lists.
+func sortDiagnostics(diagnostics []protocol.Diagnostic) []protocol.Diagnostic {
+	less := func(a, b *protocol.Diagnostic) bool {
+		switch {
+		case a.Range.Start.Line != b.Range.Start.Line:
+			return a.Range.Start.Line < b.Range.Start.Line
+		case a.Range.Start.Character != b.Range.Start.Character:
+			return a.Range.Start.Character < b.Range.Start.Character
+		case a.Range.End.Line != b.Range.End.Line:
+			return a.Range.End.Line < b.Range.End.Line
+		case a.Range.End.Character != b.Range.End.Character:
+			return a.Range.End.Character < b.Range.End.Character
+		case fmt.Sprint(a.Code) != fmt.Sprint(b.Code):
+			return fmt.Sprint(a.Code) < fmt.Sprint(b.Code)
+		case a.Severity != b.Severity:
+			return a.Severity < b.Severity
+		default:
+			return a.Message < b.Message
+		}
+	}
+
+	sort.SliceStable(diagnostics, func(i, j int) bool { return less(&diagnostics[i], &diagnostics[j]) })
+
+	ret := diagnostics[:0]
+
+	for i := range diagnostics {
+		if len(ret) > 0 && !less(&ret[len(ret)-1], &diagnostics[i]) {
+			// Equal to the previous diagnostic, e.g. because two checks report the same problem
+			continue
+		}
+
+		ret = append(ret, diagnostics[i])
 	}
+
+	return ret
+}
+
+func (s *server) clearDiagnostics(uri string, version float64) {
+	s.publishDiagnostics(&protocol.PublishDiagnosticsParams{
+		URI:         uri,
+		Version:     version,
+		Diagnostics: []protocol.Diagnostic{},
+	})
 }
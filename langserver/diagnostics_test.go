@@ -0,0 +1,137 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// TestDiagnosticsOrder checks that diagnostics are reported in the order of their ranges, without duplicates,
+// no matter in which order the queries of a rule file are compiled
+func TestDiagnosticsOrder(*testing.T) {
+	at := func(line float64, code string) protocol.Diagnostic {
+		return protocol.Diagnostic{
+			Range: protocol.Range{Start: protocol.Position{Line: line}, End: protocol.Position{Line: line, Character: 3}},
+			Code:  code,
+		}
+	}
+
+	sorted := sortDiagnostics([]protocol.Diagnostic{at(2, "b"), at(1, "b"), at(2, "a"), at(1, "b")})
+
+	if expected := []protocol.Diagnostic{at(1, "b"), at(2, "a"), at(2, "b")}; !reflect.DeepEqual(sorted, expected) {
+		panic(fmt.Sprintf("expected %v, got %v", expected, sorted))
+	}
+
+	h, err := NewHeadlessServer(context.Background(), &Config{}, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	const rules = `groups:
+- name: example
+  rules:
+  - record: a
+    expr: rate(foo[1m:5s]
+  - record: b
+    expr: sum(bar
+  - record: c
+    expr: baz)
+`
+
+	var first []protocol.Diagnostic
+
+	for i := 0; i < 10; i++ {
+		report, err := h.AnalyzeDocument("rules.yml", "yaml", rules)
+		if err != nil {
+			panic(err)
+		}
+
+		if err := h.CloseDocument("rules.yml"); err != nil {
+			panic(err)
+		}
+
+		for j := 1; j < len(report.Diagnostics); j++ {
+			if report.Diagnostics[j].Range.Start.Line < report.Diagnostics[j-1].Range.Start.Line {
+				panic(fmt.Sprintf("expected the diagnostics to be sorted, got %v", report.Diagnostics))
+			}
+		}
+
+		if first == nil {
+			first = report.Diagnostics
+		} else if !reflect.DeepEqual(first, report.Diagnostics) {
+			panic(fmt.Sprintf("expected the same diagnostics on every compile, got %v and %v", first, report.Diagnostics))
+		}
+	}
+}
+
+// TestDiagnosticTags checks that diagnostics of unnecessary and deprecated code are tagged,
+// so editors render it faded out or struck through
+func TestDiagnosticTags(*testing.T) {
+	h, err := NewHeadlessServer(context.Background(), &Config{}, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	const rules = `groups:
+- name: example
+  rules:
+  - alert: HighLoad
+    expr: node_load1 > 10
+    annotations:
+      summary: "{{ $host := reReplaceAll \":.*\" \"\" $labels.instance }}{{ $load := $value }}load {{ $load }}"
+`
+
+	tests := []struct {
+		uri, languageID, content string
+		code                     string
+		message                  string
+		tag                      protocol.DiagnosticTag
+	}{
+		{"tags.promql", "promql", `vector(scalar(sum(up)))`, fixConversionRoundTrip, "", protocol.Unnecessary},
+		{"deprecated.promql", "promql", `holt_winters(foo[1h], 0.5, 0.5)`, codeDeprecatedFunction,
+			"holt_winters is removed in Prometheus 3", protocol.Deprecated},
+		{"rules.yml", "yaml", rules, codeUnusedTemplateVar, "template variable $host is declared but never used", protocol.Unnecessary},
+	}
+
+	for _, test := range tests {
+		report, err := h.AnalyzeDocument(test.uri, test.languageID, test.content)
+		if err != nil {
+			panic(err)
+		}
+
+		var found []protocol.Diagnostic
+
+		for _, d := range report.Diagnostics {
+			if d.Code == test.code {
+				found = append(found, d)
+			}
+		}
+
+		if len(found) != 1 || !strings.HasPrefix(found[0].Message, test.message) {
+			panic(fmt.Sprintf("expected one %s diagnostic in %s, got %v", test.code, test.uri, report.Diagnostics))
+		}
+
+		if !reflect.DeepEqual(found[0].Tags, []protocol.DiagnosticTag{test.tag}) {
+			panic(fmt.Sprintf("expected the %s diagnostic to be tagged %v, got %v", test.code, test.tag, found[0].Tags))
+		}
+	}
+}
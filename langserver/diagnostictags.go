@@ -0,0 +1,42 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// diagnosticTags are the tags the diagnostics with a code are published with. Editors render
+// unnecessary code faded out and deprecated code struck through.
+var diagnosticTags = map[string][]protocol.DiagnosticTag{ // nolint: gochecknoglobals
+	fixDeprecatedMetric:    {protocol.Deprecated},
+	codeDeprecatedFunction: {protocol.Deprecated},
+	fixConversionRoundTrip: {protocol.Unnecessary},
+	codeDuplicateRecord:    {protocol.Unnecessary},
+	codeUnusedTemplateVar:  {protocol.Unnecessary},
+}
+
+// addDiagnosticTags tags diagnostics that mark deprecated or unnecessary code
+func addDiagnosticTags(diagnostics []protocol.Diagnostic) {
+	for i := range diagnostics {
+		d := &diagnostics[i]
+
+		code, ok := d.Code.(string)
+		if !ok || len(d.Tags) != 0 {
+			continue
+		}
+
+		d.Tags = diagnosticTags[code]
+	}
+}
@@ -0,0 +1,185 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"go/token"
+	"time"
+
+	"github.com/prometheus/prometheus/promql"
+
+	"github.com/prometheus-community/promql-langserver/langserver/cache"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// droppedLabelDiagnostics reports labels that are used by an expression after an inner expression removed them,
+// e.g. `sum by (instance) (sum by (job) (rate(errors_total[5m])))`. Such labels are empty, so grouping by them
+// or matching on them has no effect. The removed labels are inferred from the aggregations, so this works without
+// a Prometheus server.
+// nolint: funlen
+func (s *server) droppedLabelDiagnostics(doc *cache.DocumentHandle) []protocol.Diagnostic {
+	queries, err := doc.GetQueries()
+	if err != nil {
+		return nil
+	}
+
+	var ret []protocol.Diagnostic
+
+	for _, q := range queries {
+		if q.Ast == nil || len(q.Err) > 0 {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(s.lifetime, 5*time.Second)
+
+		a := &labelAnalyzer{s: s, ctx: ctx, query: q, series: make(map[string]labelSet)}
+
+		warn := func(ref labelReference, msg string) {
+			rng, err := tokenRange(doc, ref.Pos, ref.End)
+			if err != nil {
+				return
+			}
+
+			ret = append(ret, protocol.Diagnostic{
+				Range:    rng,
+				Severity: 2, // Warning
+				Code:     codeDroppedLabel,
+				Source:   "promql-lsp",
+				Message:  msg,
+			})
+		}
+
+		promql.Inspect(q.Ast, func(node promql.Node, _ []promql.Node) error {
+			switch n := node.(type) {
+			case *promql.AggregateExpr:
+				if n.Without {
+					return nil
+				}
+
+				inner := a.exprLabels(n.Expr)
+
+				for _, ref := range aggregationGroupingLabels(q, n) {
+					if reason := inner.removedBy(ref.Name); reason != "" {
+						warn(ref, fmt.Sprintf("label %q was already removed by %s, so the result isn't grouped by it", ref.Name, reason))
+					}
+				}
+			case *promql.BinaryExpr:
+				if n.VectorMatching == nil || !n.VectorMatching.On || n.LHS.Type() != promql.ValueTypeVector || n.RHS.Type() != promql.ValueTypeVector {
+					return nil
+				}
+
+				lhs, rhs := a.exprLabels(n.LHS), a.exprLabels(n.RHS)
+
+				for _, ref := range modifierLabels(q, n, promql.ON) {
+					side, reason := droppedOperand(ref.Name, lhs, rhs)
+					if reason != "" {
+						warn(ref, fmt.Sprintf("label %q was removed from the %s operand by %s, so only series of the other operand without it can match",
+							ref.Name, side, reason))
+					}
+				}
+			case *promql.Call:
+				if n.Func.Name != "label_replace" && n.Func.Name != "label_join" || len(n.Args) < 4 {
+					return nil
+				}
+
+				inner := a.exprLabels(n.Args[0])
+
+				sources := n.Args[3:]
+				if n.Func.Name == "label_replace" {
+					sources = n.Args[3:4]
+				}
+
+				for _, arg := range sources {
+					// An empty source label is a common way to set a constant label
+					str, ok := arg.(*promql.StringLiteral)
+					if !ok || str.Val == "" {
+						continue
+					}
+
+					if reason := inner.removedBy(str.Val); reason != "" {
+						rng := str.PositionRange()
+
+						warn(labelReference{Name: str.Val, Pos: q.Pos + token.Pos(rng.Start), End: q.Pos + token.Pos(rng.End)},
+							fmt.Sprintf("label %q was already removed by %s, so %s reads an empty value", str.Val, reason, n.Func.Name))
+					}
+				}
+			}
+
+			return nil
+		})
+
+		cancel()
+	}
+
+	return ret
+}
+
+// droppedOperand returns the side of a binary operation that lost a matched label, and the expression that removed it.
+// Labels removed on both sides match.
+func droppedOperand(name string, lhs labelSet, rhs labelSet) (side string, reason string) {
+	lhsReason, rhsReason := lhs.removedBy(name), rhs.removedBy(name)
+
+	switch {
+	case lhsReason != "" && rhsReason == "" && (rhs.Names[name] || !rhs.Exact):
+		return "left-hand", lhsReason
+	case rhsReason != "" && lhsReason == "" && (lhs.Names[name] || !lhs.Exact):
+		return "right-hand", rhsReason
+	default:
+		return "", ""
+	}
+}
+
+// aggregationGroupingLabels returns the labels listed in the by or without clause of an aggregation. The clause can be
+// before or after the aggregated expression, the clauses of nested aggregations are left out.
+func aggregationGroupingLabels(q *cache.CompiledQuery, n *promql.AggregateExpr) []labelReference {
+	start, end := n.PosRange.Start, n.PosRange.End
+	if start > end || int(end) > len(q.Content) {
+		return nil
+	}
+
+	var ret []labelReference
+
+	l := promql.Lex(q.Content[start:end])
+
+	depth := 0
+	inGrouping := false
+
+	for {
+		var item promql.Item
+
+		l.NextItem(&item)
+
+		switch item.Typ {
+		case promql.EOF, promql.ERROR:
+			return ret
+		case promql.BY, promql.WITHOUT:
+			inGrouping = depth == 0
+		case promql.LEFT_PAREN:
+			depth++
+		case promql.RIGHT_PAREN:
+			depth--
+
+			if depth == 0 {
+				inGrouping = false
+			}
+		case promql.IDENTIFIER:
+			if inGrouping && depth == 1 {
+				item.Pos += start
+				ret = append(ret, itemLabelReference(q, item))
+			}
+		}
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// TestDroppedLabelDiagnostics checks that labels used after an inner aggregation removed them are reported
+func TestDroppedLabelDiagnostics(*testing.T) {
+	h, err := NewHeadlessServer(context.Background(), &Config{}, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	tests := []struct {
+		query string
+		// at is the last occurrence of a unique text in the query the diagnostic starts at
+		at      string
+		message string
+	}{
+		{`sum by (instance) (sum by (job) (rate(errors_total[5m])))`, "instance", `"instance" was already removed by sum by (job)`},
+		{`max(sum without (code) (errors_total)) by (code)`, "code", `"code" was already removed by sum without (code)`},
+		{`sum by (le) (histogram_quantile(0.9, rate(latency_bucket[5m])))`, "le)", "removed by histogram_quantile"},
+		{`sum(errors_total) / on(job) requests_total`, "job", "removed from the left-hand operand by sum,"},
+		{`sum by (job) (a) / on(instance) sum by (instance) (b)`, "instance) sum", "removed from the left-hand operand by sum by (job)"},
+		{`label_replace(sum by (job) (up), "host", "$1", "instance", "(.*)")`, `"instance"`, "label_replace reads an empty value"},
+		{`sum by (job) (sum by (job, instance) (errors_total))`, "", ""},
+		{`sum by (job) (label_replace(sum(up), "job", "api", "", ""))`, "", ""},
+		{`sum by (instance) (errors_total) / on(job) sum by (job) (requests_total)`, "job) sum", "removed from the left-hand operand"},
+		{`sum by (job) (a) / on(instance) sum by (job) (b)`, "", ""},
+	}
+
+	for i, test := range tests {
+		report, err := h.AnalyzeDocument(fmt.Sprintf("droppedlabels_%d.promql", i), "promql", test.query)
+		if err != nil {
+			panic(err)
+		}
+
+		var found []string
+
+		for _, d := range report.Diagnostics {
+			if d.Code == codeDroppedLabel {
+				found = append(found, d.Message)
+
+				if start := strings.LastIndex(test.query, test.at); int(d.Range.Start.Character) != start {
+					panic(fmt.Sprintf("Expected the diagnostic for %q to start at %d, got %v", test.query, start, d.Range))
+				}
+			}
+		}
+
+		if test.message == "" && len(found) != 0 || test.message != "" && (len(found) != 1 || !strings.Contains(found[0], test.message)) {
+			panic(fmt.Sprintf("Expected %q for %q, got %q", test.message, test.query, found))
+		}
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"fmt"
+	"go/token"
+	"strconv"
+
+	"github.com/prometheus-community/promql-langserver/langserver/cache"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+	"gopkg.in/yaml.v3"
+)
+
+// durationField is a field of a rule file that takes a duration
+type durationField struct {
+	Key   string
+	Value string
+	// Pos and End span the value of the field, excluding quotes
+	Pos token.Pos
+	End token.Pos
+}
+
+// getDurationFields returns all duration fields of the rule groups in a document
+func getDurationFields(doc *cache.DocumentHandle) []durationField {
+	groups, err := doc.GetRuleGroups()
+	if err != nil {
+		return nil
+	}
+
+	var ret []durationField
+
+	add := func(node *yaml.Node, key string, lineOffset int) {
+		value := cache.MappingValue(node, key)
+		if value == nil || value.Kind != yaml.ScalarNode {
+			return
+		}
+
+		pos, end, err := doc.YamlNodeRange(value, lineOffset)
+		if err != nil {
+			return
+		}
+
+		if value.Style == yaml.SingleQuotedStyle || value.Style == yaml.DoubleQuotedStyle {
+			pos++
+			end--
+		}
+
+		ret = append(ret, durationField{Key: key, Value: value.Value, Pos: pos, End: end})
+	}
+
+	for _, group := range groups {
+		add(group.Node, "interval", group.LineOffset)
+
+		for _, rule := range group.Rules {
+			add(rule.Node, "for", group.LineOffset)
+			add(rule.Node, "keep_firing_for", group.LineOffset)
+		}
+	}
+
+	return ret
+}
+
+// findDurationField returns the duration field at a position, if there is one
+func findDurationField(doc *cache.DocumentHandle, position protocol.Position) (*durationField, token.Pos) {
+	pos, err := doc.ProtocolPositionToTokenPos(position)
+	if err != nil {
+		return nil, token.NoPos
+	}
+
+	for _, field := range getDurationFields(doc) {
+		if field.Pos <= pos && pos <= field.End {
+			field := field
+			return &field, pos
+		}
+	}
+
+	return nil, token.NoPos
+}
+
+// durationDiagnostics reports duration fields Prometheus would reject
+func durationDiagnostics(doc *cache.DocumentHandle) []protocol.Diagnostic {
+	var ret []protocol.Diagnostic
+
+	for _, field := range getDurationFields(doc) {
+		_, parseErr := cache.ParseDuration(field.Value)
+		if parseErr == nil {
+			continue
+		}
+
+		rng, err := tokenRange(doc, field.Pos, field.End)
+		if err != nil {
+			continue
+		}
+
+		ret = append(ret, protocol.Diagnostic{
+			Range:    rng,
+			Severity: 1, // Error
+			Code:     codeInvalidDuration,
+			Source:   "promql-lsp",
+			Message:  fmt.Sprintf("%s: %s", field.Key, parseErr),
+		})
+	}
+
+	return ret
+}
+
+// durationHover shows the value of a duration field in seconds
+func durationHover(doc *cache.DocumentHandle, position protocol.Position) *protocol.Hover {
+	field, _ := findDurationField(doc, position)
+	if field == nil {
+		return nil
+	}
+
+	duration, err := cache.ParseDuration(field.Value)
+	if err != nil {
+		return nil
+	}
+
+	rng, err := tokenRange(doc, field.Pos, field.End)
+	if err != nil {
+		return nil
+	}
+
+	return &protocol.Hover{
+		Contents: protocol.MarkupContent{
+			Kind:  "markdown",
+			Value: fmt.Sprintf("`%s` = %s seconds", field.Value, strconv.FormatFloat(duration.Seconds(), 'f', -1, 64)),
+		},
+		Range: rng,
+	}
+}
+
+// durationCompletion completes the units of a duration field after a number
+func durationCompletion(doc *cache.DocumentHandle, position protocol.Position) []protocol.CompletionItem {
+	field, pos := findDurationField(doc, position)
+	if field == nil || int(pos-field.Pos) > len(field.Value) {
+		return nil
+	}
+
+	rng, err := tokenRange(doc, field.Pos, pos)
+	if err != nil {
+		return nil
+	}
+
+	return durationUnitCompletions(field.Value[:pos-field.Pos], rng)
+}
+
+// durationUnitCompletions completes the units that may follow a partial duration ending in a number.
+// The completions replace rng, which has to span the partial duration.
+func durationUnitCompletions(prefix string, rng protocol.Range) []protocol.CompletionItem {
+	// Find the number in front of the cursor and the last unit before it
+	i := len(prefix)
+	for i > 0 && '0' <= prefix[i-1] && prefix[i-1] <= '9' {
+		i--
+	}
+
+	if i == len(prefix) {
+		return nil
+	}
+
+	lastUnit := -1
+
+	if i > 0 {
+		for j, u := range cache.DurationUnits {
+			if len(prefix[:i]) >= len(u.Name) && prefix[i-len(u.Name):i] == u.Name && (lastUnit < 0 || len(u.Name) > len(cache.DurationUnits[lastUnit].Name)) {
+				lastUnit = j
+			}
+		}
+
+		if lastUnit < 0 {
+			return nil
+		}
+	}
+
+	var ret []protocol.CompletionItem
+
+	for _, u := range cache.DurationUnits[lastUnit+1:] {
+		ret = append(ret, protocol.CompletionItem{
+			Label:    prefix + u.Name,
+			Kind:     protocol.UnitCompletion,
+			Detail:   u.Description,
+			TextEdit: &protocol.TextEdit{Range: rng, NewText: prefix + u.Name},
+		})
+	}
+
+	return ret
+}
@@ -0,0 +1,282 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+// PrometheusMapping selects one of the prometheus_servers for the documents at a path
+type PrometheusMapping struct {
+	// Path is a directory, a file or a glob pattern. Relative paths are resolved against the workspace folders.
+	Path string `yaml:"path"`
+	// Server is the name of the Prometheus server in prometheus_servers
+	Server string `yaml:"server"`
+}
+
+// endpoint is a connection to one of the named Prometheus servers
+type endpoint struct {
+	// name is the key of the server in prometheus_servers
+	name   string
+	url    string
+	client api.Client
+	// mode is the kind of Prometheus server if it has no query API, see probeQueryAPI
+	mode string
+}
+
+// endpointProbeTimeout limits the time spent on finding out which APIs a named Prometheus server supports
+const endpointProbeTimeout = 5 * time.Second
+
+// configureEndpoints replaces the named Prometheus servers and the mapping of documents to them.
+// Unlike the default server, the named ones aren't part of the server status.
+func (s *server) configureEndpoints(servers map[string]string, mapping []PrometheusMapping) {
+	endpoints := make(map[string]*endpoint)
+
+	names := make([]string, 0, len(servers))
+	for name := range servers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		url := strings.TrimSpace(servers[name])
+		if url == "" {
+			continue
+		}
+
+		client, err := s.newPrometheusClient(url)
+		if err != nil {
+			// nolint: errcheck
+			s.client.LogMessage(s.lifetime, &protocol.LogMessageParams{
+				Type:    protocol.Error,
+				Message: fmt.Sprintf("Failed to connect to Prometheus %q at %s: %s", name, url, err.Error()),
+			})
+
+			continue
+		}
+
+		e := &endpoint{
+			name:   name,
+			url:    url,
+			client: client,
+		}
+
+		ctx, cancel := context.WithTimeout(s.lifetime, endpointProbeTimeout)
+		e.mode = probeQueryAPI(ctx, e.client)
+		cancel()
+
+		endpoints[name] = e
+
+		// nolint: errcheck
+		s.client.LogMessage(s.lifetime, &protocol.LogMessageParams{
+			Type:    protocol.Info,
+			Message: fmt.Sprintf("Prometheus %q: %s", name, url),
+		})
+	}
+
+	for _, m := range mapping {
+		if _, ok := servers[m.Server]; !ok {
+			// nolint: errcheck
+			s.client.LogMessage(s.lifetime, &protocol.LogMessageParams{
+				Type:    protocol.Warning,
+				Message: fmt.Sprintf("The Prometheus server %q mapped to %s is not configured", m.Server, m.Path),
+			})
+		}
+	}
+
+	s.endpointsMu.Lock()
+	defer s.endpointsMu.Unlock()
+
+	s.endpoints = endpoints
+	s.endpointMapping = mapping
+}
+
+// matchesMappingPath checks whether a file is at a mapped path, i.e. is the file, inside the directory
+// or matches the glob pattern
+func matchesMappingPath(pattern string, path string) bool {
+	pattern = filepath.Clean(pattern)
+
+	if pattern == path || strings.HasPrefix(path, pattern+string(filepath.Separator)) {
+		return true
+	}
+
+	ok, err := filepath.Match(pattern, path)
+
+	return err == nil && ok
+}
+
+// workspaceFolders returns the paths of the workspace folders
+func (s *server) workspaceFolders() []string {
+	if s.workspace == nil {
+		return nil
+	}
+
+	s.workspace.mu.Lock()
+	defer s.workspace.mu.Unlock()
+
+	return append([]string(nil), s.workspace.folders...)
+}
+
+// remoteWorkspacePath returns the path of a document inside the remote workspace folder it belongs to,
+// or "" if it isn't inside one
+func (s *server) remoteWorkspacePath(uri protocol.DocumentURI) string {
+	if s.workspace == nil {
+		return ""
+	}
+
+	s.workspace.mu.Lock()
+	defer s.workspace.mu.Unlock()
+
+	for _, folder := range s.workspace.remote {
+		if rel := remotePath(folder, uri); rel != "" {
+			return rel
+		}
+	}
+
+	return ""
+}
+
+// endpointFor returns the named Prometheus server a document is mapped to, or nil if the document uses
+// the default server. The first matching entry of the mapping wins.
+func (s *server) endpointFor(uri protocol.DocumentURI) *endpoint {
+	s.endpointsMu.Lock()
+	endpoints, mapping := s.endpoints, s.endpointMapping
+	s.endpointsMu.Unlock()
+
+	if len(mapping) == 0 {
+		return nil
+	}
+
+	path, folders, remote := uriPath(uri), s.workspaceFolders(), false
+
+	if path == "" {
+		// Documents of remote workspace folders, e.g. in web based editors, are matched by their path
+		// inside the folder, so only the relative paths of the mapping apply to them
+		rel := s.remoteWorkspacePath(uri)
+		if rel == "" {
+			return nil
+		}
+
+		root := string(filepath.Separator)
+		path, folders, remote = filepath.Join(root, filepath.FromSlash(rel)), []string{root}, true
+	}
+
+	for _, m := range mapping {
+		pattern := filepath.FromSlash(m.Path)
+
+		if remote && filepath.IsAbs(pattern) {
+			continue
+		}
+
+		patterns := []string{pattern}
+		if !filepath.IsAbs(pattern) {
+			patterns = patterns[:0]
+			for _, folder := range folders {
+				patterns = append(patterns, filepath.Join(folder, pattern))
+			}
+		}
+
+		for _, p := range patterns {
+			if matchesMappingPath(p, path) {
+				return endpoints[m.Server]
+			}
+		}
+	}
+
+	return nil
+}
+
+// getPrometheusFor is getPrometheus for the Prometheus server a document is mapped to
+func (s *server) getPrometheusFor(uri protocol.DocumentURI) v1.API {
+	if e := s.endpointFor(uri); e != nil {
+		if e.mode == prometheusFederateOnly {
+			return nil
+		}
+
+		return v1.NewAPI(e.client)
+	}
+
+	return s.getPrometheus()
+}
+
+// getQueryAPIFor is getQueryAPI for the Prometheus server a document is mapped to
+func (s *server) getQueryAPIFor(uri protocol.DocumentURI) v1.API {
+	if e := s.endpointFor(uri); e != nil {
+		if e.mode != "" {
+			return nil
+		}
+
+		return v1.NewAPI(e.client)
+	}
+
+	return s.getQueryAPI()
+}
+
+// getPrometheusURLFor is getPrometheusURL for the Prometheus server a document is mapped to
+func (s *server) getPrometheusURLFor(uri protocol.DocumentURI) string {
+	if e := s.endpointFor(uri); e != nil {
+		return e.url
+	}
+
+	return s.getPrometheusURL()
+}
+
+// queryAPILimitationFor is queryAPILimitation for the Prometheus server a document is mapped to
+func (s *server) queryAPILimitationFor(uri protocol.DocumentURI) string {
+	if e := s.endpointFor(uri); e != nil {
+		return limitationMessage(e.mode, e.url)
+	}
+
+	return s.queryAPILimitation()
+}
+
+// endpointSettings reads the named Prometheus servers and their mapping from the settings sent with
+// workspace/didChangeConfiguration. ok is false if the settings don't contain them.
+func endpointSettings(settings interface{}) (servers map[string]string, mapping []PrometheusMapping, ok bool) {
+	rawServers, hasServers := getSetting(settings, "promql", "servers").(map[string]interface{})
+	rawMapping, hasMapping := getSetting(settings, "promql", "mapping").([]interface{})
+
+	if !hasServers && !hasMapping {
+		return nil, nil, false
+	}
+
+	servers = make(map[string]string)
+
+	for name, url := range rawServers {
+		if str, ok := url.(string); ok {
+			servers[name] = str
+		}
+	}
+
+	for _, raw := range rawMapping {
+		path, _ := getSetting(raw, "path").(string)
+		server, _ := getSetting(raw, "server").(string)
+
+		if path != "" && server != "" {
+			mapping = append(mapping, PrometheusMapping{Path: path, Server: server})
+		}
+	}
+
+	return servers, mapping, true
+}
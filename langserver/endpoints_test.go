@@ -0,0 +1,135 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// TestEndpointMapping checks that documents use the Prometheus server they are mapped to
+// and that the mapping can be changed by the client
+func TestEndpointMapping(*testing.T) {
+	newProm := func(job string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+
+			if r.URL.Path == "/api/v1/series" {
+				fmt.Fprintf(w, `{"status":"success","data":[{"__name__":"up","job":%q}]}`, job)
+				return
+			}
+
+			fmt.Fprint(w, `{"status":"success","data":{}}`)
+		}))
+	}
+
+	prod, staging := newProm("prod"), newProm("staging")
+	defer prod.Close()
+	defer staging.Close()
+
+	h, err := NewHeadlessServer(context.Background(), &Config{
+		PrometheusURL:     prod.URL,
+		PrometheusServers: map[string]string{"staging": staging.URL},
+		PrometheusMapping: []PrometheusMapping{
+			{Path: "/rules/staging", Server: "staging"},
+			{Path: "/rules/*.staging.yml", Server: "staging"},
+		},
+	}, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	for uri, expected := range map[protocol.DocumentURI]string{
+		"file:///rules/staging/alerts.yml": staging.URL,
+		"file:///rules/node.staging.yml":   staging.URL,
+		"file:///rules/node.yml":           prod.URL,
+		"file:///rules/staging.yml":        prod.URL,
+		"inmemory://staging":               prod.URL,
+	} {
+		if url := h.server.getPrometheusURLFor(uri); url != expected {
+			panic(fmt.Sprintf("expected %s to use %s, got %s", uri, expected, url))
+		}
+	}
+
+	values := h.server.labelValues(context.Background(), "file:///rules/staging/alerts.yml", nil, "up", "job")
+	if fmt.Sprint(values) != "[staging]" {
+		panic(fmt.Sprintf("expected the label values of the staging server, got %v", values))
+	}
+
+	err = h.server.DidChangeConfiguration(context.Background(), &protocol.DidChangeConfigurationParams{
+		Settings: map[string]interface{}{
+			"promql": map[string]interface{}{
+				"servers": map[string]interface{}{"staging": staging.URL},
+				"mapping": []interface{}{
+					map[string]interface{}{"path": "/rules/node.yml", "server": "staging"},
+				},
+			},
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	if url := h.server.getPrometheusURLFor("file:///rules/node.yml"); url != staging.URL {
+		panic(fmt.Sprintf("expected the changed mapping to be used, got %s", url))
+	}
+
+	if url := h.server.getPrometheusURLFor("file:///rules/staging/alerts.yml"); url != prod.URL {
+		panic(fmt.Sprintf("expected the old mapping to be replaced, got %s", url))
+	}
+}
+
+// TestRemoteEndpointMapping checks that relative paths of the mapping apply to documents of remote workspace folders
+func TestRemoteEndpointMapping(*testing.T) {
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"status":"success","data":{}}`)
+	}))
+	defer prom.Close()
+
+	staging := prom.URL + "/staging"
+
+	params := &protocol.ParamInitialize{}
+	params.WorkspaceFolders = []protocol.WorkspaceFolder{{URI: "vscode-vfs://github/org/repo"}}
+
+	h, err := newHeadlessServer(context.Background(), &Config{
+		PrometheusURL:     prom.URL,
+		PrometheusServers: map[string]string{"staging": staging},
+		PrometheusMapping: []PrometheusMapping{
+			{Path: "/rules", Server: "staging"},
+			{Path: "rules/staging", Server: "staging"},
+		},
+	}, nil, params)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	for uri, expected := range map[protocol.DocumentURI]string{
+		"vscode-vfs://github/org/repo/rules/staging/alerts.yml": staging,
+		"vscode-vfs://github/org/repo/rules/prod/alerts.yml":    prom.URL,
+		"vscode-vfs://github/org/other/rules/staging/a.yml":     prom.URL,
+		"untitled:Untitled-1": prom.URL,
+	} {
+		if url := h.server.getPrometheusURLFor(uri); url != expected {
+			panic(fmt.Sprintf("expected %s to use %s, got %s", uri, expected, url))
+		}
+	}
+}
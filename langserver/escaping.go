@@ -0,0 +1,320 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"fmt"
+	"go/token"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/prometheus-community/promql-langserver/langserver/cache"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+	"github.com/prometheus/prometheus/promql"
+)
+
+// The rules of the quick fixes for broken escaping
+const (
+	// fixEscapeSequence escapes the backslashes of unknown escape sequences in PromQL strings
+	fixEscapeSequence = "escape-sequence"
+	// fixYamlEscaping turns quoted yaml expressions that are broken by yaml unescaping into block scalars
+	fixYamlEscaping = "yaml-escaping"
+)
+
+// unknownEscapeErr is the prefix of the parse error the lexer reports for unknown escape sequences
+const unknownEscapeErr = "unknown escape sequence"
+
+// promqlEscapes are the characters that can follow a backslash in PromQL strings, besides the quote
+const promqlEscapes = "abfnrtv\\01234567xuU"
+
+// stringLiteral is a quoted string of a query
+type stringLiteral struct {
+	// Start and End are the offsets of the literal, including its quotes
+	Start int
+	End   int
+	Quote byte
+	// Unknown are the offsets of the backslashes starting unknown escape sequences
+	Unknown []int
+	// Escapes is the number of valid escape sequences
+	Escapes int
+}
+
+// stringLiterals returns the string literals of a query. Strings in backticks are raw strings
+// without escape sequences. Unterminated strings extend to the end of the query.
+func stringLiterals(query string) []stringLiteral {
+	var (
+		ret     []stringLiteral
+		current *stringLiteral
+	)
+
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+
+		switch {
+		case current == nil && c == '#':
+			// Comments extend to the end of the line
+			for i < len(query) && query[i] != '\n' {
+				i++
+			}
+		case current == nil:
+			if c == '"' || c == '\'' || c == '`' {
+				current = &stringLiteral{Start: i, Quote: c}
+			}
+		case c == '\\' && current.Quote != '`':
+			if i+1 < len(query) {
+				if next := query[i+1]; next == current.Quote || strings.IndexByte(promqlEscapes, next) >= 0 {
+					current.Escapes++
+				} else {
+					current.Unknown = append(current.Unknown, i)
+				}
+			}
+
+			i++
+		case c == current.Quote:
+			current.End = i + 1
+			ret = append(ret, *current)
+			current = nil
+		}
+	}
+
+	if current != nil {
+		current.End = len(query)
+		ret = append(ret, *current)
+	}
+
+	return ret
+}
+
+// escapeUnknownEscapes doubles the backslashes of unknown escape sequences, e.g. turns "\d" into "\\d",
+// which is what regular expressions written for other tools usually mean
+func escapeUnknownEscapes(query string) string {
+	var b strings.Builder
+
+	last := 0
+
+	for _, s := range stringLiterals(query) {
+		for _, offset := range s.Unknown {
+			b.WriteString(query[last:offset])
+			b.WriteByte('\\')
+
+			last = offset
+		}
+	}
+
+	b.WriteString(query[last:])
+
+	return b.String()
+}
+
+// escapeSequenceFixes suggests fixes for the parse errors caused by unknown escape sequences:
+// escaping the backslashes or, for strings without other escape sequences, using a raw string.
+// The fixes belong to parse errors, which are published by the cache, so they are not part of getQuickFixes.
+func escapeSequenceFixes(doc *cache.DocumentHandle) []quickFix {
+	queries, err := doc.GetQueries()
+	if err != nil {
+		return nil
+	}
+
+	var ret []quickFix
+
+	for _, q := range queries {
+		for _, e := range q.Err {
+			if !strings.HasPrefix(e.Err.Error(), unknownEscapeErr) {
+				continue
+			}
+
+			// The diagnostic has to match the one published by the cache, which limits it to the query
+			end := e.PositionRange.End
+			if max := promql.Pos(len(strings.TrimRight(q.Content, " \t\r\n"))); end > max {
+				end = max
+			}
+
+			rng, err := tokenRange(doc, q.Pos+token.Pos(e.PositionRange.Start), q.Pos+token.Pos(end))
+			if err != nil {
+				continue
+			}
+
+			diagnostic := protocol.Diagnostic{
+				Range:    rng,
+				Severity: 1, // Error
+				Source:   "promql-lsp",
+				Message:  e.Err.Error(),
+			}
+
+			escape := quickFix{Rule: fixEscapeSequence, Title: "Escape backslashes", Diagnostic: diagnostic}
+
+			for _, s := range stringLiterals(q.Content) {
+				for _, offset := range s.Unknown {
+					pos := q.Pos + token.Pos(offset)
+					escape.Edits = append(escape.Edits, tokenEdit{Pos: pos, End: pos, NewText: `\`})
+				}
+
+				// Raw strings keep every backslash, so they only mean the same if there are no other escape sequences
+				if len(s.Unknown) == 0 || s.Escapes > 0 || s.End-s.Start < 2 || strings.ContainsAny(q.Content[s.Start+1:s.End-1], "`\n") {
+					continue
+				}
+
+				start, end := q.Pos+token.Pos(s.Start), q.Pos+token.Pos(s.End)
+
+				ret = append(ret, quickFix{
+					Rule:       fixEscapeSequence,
+					Title:      "Use a raw string",
+					Diagnostic: diagnostic,
+					Edits: []tokenEdit{
+						{Pos: start, End: start + 1, NewText: "`"},
+						{Pos: end - 1, End: end, NewText: "`"},
+					},
+				})
+			}
+
+			ret = append(ret, escape)
+		}
+	}
+
+	return ret
+}
+
+// quotedExpr is the quoted expr field of a rule. The language server doesn't compile quoted queries.
+type quotedExpr struct {
+	Node *yaml.Node
+	// Pos and End span the quoted scalar
+	Pos token.Pos
+	End token.Pos
+	// Indent is the indentation of the lines of a block scalar replacing the scalar
+	Indent string
+}
+
+// quotedExprs returns the quoted expr fields of the rules of a document
+func quotedExprs(doc *cache.DocumentHandle) []quotedExpr {
+	groups, err := doc.GetRuleGroups()
+	if err != nil {
+		return nil
+	}
+
+	var ret []quotedExpr
+
+	for _, group := range groups {
+		for _, rule := range group.Rules {
+			node := rule.Node
+
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				key, value := node.Content[i], node.Content[i+1]
+
+				if key.Value != "expr" || value.Kind != yaml.ScalarNode ||
+					(value.Style != yaml.DoubleQuotedStyle && value.Style != yaml.SingleQuotedStyle) {
+					continue
+				}
+
+				pos, end, err := doc.YamlNodeRange(value, group.LineOffset)
+				if err != nil {
+					continue
+				}
+
+				ret = append(ret, quotedExpr{
+					Node:   value,
+					Pos:    pos,
+					End:    end,
+					Indent: strings.Repeat(" ", key.Column+1),
+				})
+			}
+		}
+	}
+
+	return ret
+}
+
+// blockScalar returns the literal block scalar containing a query
+func blockScalar(query string, indent string) string {
+	return "|\n" + indent + indentLines(query, indent)
+}
+
+// yamlEscapingFixes reports quoted expressions that contain unknown escape sequences once yaml has
+// unquoted them, e.g. because backslashes in double quoted strings have to be escaped twice.
+// They are fixed by turning them into block scalars, which don't have escape sequences.
+func yamlEscapingFixes(doc *cache.DocumentHandle) []quickFix {
+	var ret []quickFix
+
+	for _, expr := range quotedExprs(doc) {
+		var unknown []string
+
+		for _, s := range stringLiterals(expr.Node.Value) {
+			for _, offset := range s.Unknown {
+				unknown = append(unknown, expr.Node.Value[offset:offset+2])
+			}
+		}
+
+		if len(unknown) == 0 || strings.HasPrefix(expr.Node.Value, " ") {
+			continue
+		}
+
+		rng, err := tokenRange(doc, expr.Pos, expr.End)
+		if err != nil {
+			continue
+		}
+
+		msg := fmt.Sprintf("the query contains the unknown escape sequence %s", unknown[0])
+		if expr.Node.Style == yaml.DoubleQuotedStyle {
+			msg += ", since yaml already removes one level of backslashes from double quoted strings"
+		}
+
+		ret = append(ret, quickFix{
+			Rule:  fixYamlEscaping,
+			Title: "Convert expr to block scalar",
+			Diagnostic: protocol.Diagnostic{
+				Range:    rng,
+				Severity: 1, // Error
+				Code:     fixYamlEscaping,
+				Source:   "promql-lsp",
+				Message:  msg,
+			},
+			Edits: []tokenEdit{{
+				Pos:     expr.Pos,
+				End:     expr.End,
+				NewText: blockScalar(escapeUnknownEscapes(expr.Node.Value), expr.Indent),
+			}},
+		})
+	}
+
+	return ret
+}
+
+// blockScalarCodeActions offers to turn quoted expressions into block scalars, which need no escaping
+// and are analyzed by the language server. Expressions with broken escaping are covered by yamlEscapingFixes.
+func blockScalarCodeActions(doc *cache.DocumentHandle, rng protocol.Range) []protocol.CodeAction {
+	var ret []protocol.CodeAction
+
+	for _, expr := range quotedExprs(doc) {
+		exprRng, err := tokenRange(doc, expr.Pos, expr.End)
+		if err != nil || !rangesOverlap(exprRng, rng) || strings.HasPrefix(expr.Node.Value, " ") {
+			continue
+		}
+
+		if escapeUnknownEscapes(expr.Node.Value) != expr.Node.Value {
+			continue
+		}
+
+		ret = append(ret, protocol.CodeAction{
+			Title: "Convert expr to block scalar",
+			Kind:  protocol.RefactorRewrite,
+			Edit: protocol.WorkspaceEdit{
+				Changes: map[string][]protocol.TextEdit{
+					doc.GetURI(): {{Range: exprRng, NewText: blockScalar(expr.Node.Value, expr.Indent)}},
+				},
+			},
+		})
+	}
+
+	return ret
+}
@@ -0,0 +1,120 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// TestEscapeSequenceFixes checks the fixes for unknown escape sequences in PromQL strings
+func TestEscapeSequenceFixes(*testing.T) {
+	h, err := NewHeadlessServer(context.Background(), &Config{}, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	if err := h.AddDocument("query.promql", "promql", `sum(rate(requests_total{path=~"/api/\d+", code!~"5.."}[5m]))`); err != nil {
+		panic(err)
+	}
+
+	doc, err := h.server.cache.GetDocument("query.promql")
+	if err != nil {
+		panic(err)
+	}
+
+	fixes := make(map[string]string)
+
+	for _, fix := range escapeSequenceFixes(doc) {
+		edits, err := protocolEdits(doc, fix.Edits)
+		if err != nil {
+			panic(err)
+		}
+
+		fixes[fix.Title] = fmt.Sprint(edits)
+	}
+
+	expected := map[string]string{
+		"Use a raw string":   "[{0:30-0:31 `} {0:39-0:40 `}]",
+		"Escape backslashes": `[{0:36-0:36 \}]`,
+	}
+
+	if fmt.Sprint(fixes) != fmt.Sprint(expected) {
+		panic(fmt.Sprintf("expected the fixes %v, got %v", expected, fixes))
+	}
+}
+
+// TestYamlEscapingFixes checks that quoted expressions broken by yaml unescaping are turned into block scalars
+func TestYamlEscapingFixes(*testing.T) {
+	h, err := NewHeadlessServer(context.Background(), &Config{}, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	rules := `groups:
+  - name: example
+    rules:
+      - record: api:requests:rate5m
+        expr: "sum(rate(requests_total{path=~\"/api/\\d+\"}[5m]))"
+      - record: job:up:sum
+        expr: 'sum by (job) (up)'
+`
+
+	report, err := h.AnalyzeDocument("rules.yml", "yaml", rules)
+	if err != nil {
+		panic(err)
+	}
+
+	if len(report.Fixes) != 1 || report.Fixes[0].Rule != fixYamlEscaping || len(report.Fixes[0].Edits) != 1 {
+		panic(fmt.Sprintf("expected a single yaml escaping fix, got %v", report.Fixes))
+	}
+
+	e := report.Fixes[0].Edits[0]
+
+	fixed := rules[:e.Start] + e.NewText + rules[e.End:]
+
+	expected := `groups:
+  - name: example
+    rules:
+      - record: api:requests:rate5m
+        expr: |
+          sum(rate(requests_total{path=~"/api/\\d+"}[5m]))
+      - record: job:up:sum
+        expr: 'sum by (job) (up)'
+`
+
+	if fixed != expected {
+		panic(fmt.Sprintf("expected the fixed rules\n%s\ngot\n%s", expected, fixed))
+	}
+
+	doc, err := h.server.cache.GetDocument("rules.yml")
+	if err != nil {
+		panic(err)
+	}
+
+	// The correctly escaped expression can be converted without the fix
+	actions := blockScalarCodeActions(doc, protocol.Range{
+		Start: protocol.Position{Line: 6, Character: 14},
+		End:   protocol.Position{Line: 6, Character: 14},
+	})
+
+	if len(actions) != 1 || actions[0].Edit.Changes["rules.yml"][0].NewText != "|\n          sum by (job) (up)" {
+		panic(fmt.Sprintf("expected a code action converting the second expression, got %v", actions))
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/prometheus-community/promql-langserver/langserver/cache"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+// evaluationSamples is the number of samples shown in the result of an evaluated query
+const evaluationSamples = 3
+
+// evaluationLens shows the current result of a query
+func (s *server) evaluationLens(ctx context.Context, api v1.API, query *cache.CompiledQuery, rng protocol.Range) protocol.CodeLens {
+	var title string
+
+	value, _, err := api.Query(ctx, strings.TrimSpace(query.Content), s.evaluationTimeOrNow(query))
+	if err != nil {
+		s.reportBackendError(err)
+
+		title = fmt.Sprintf("evaluation failed: %s", err)
+	} else {
+		title = evaluationSummary(value)
+	}
+
+	return protocol.CodeLens{
+		Range: rng,
+		// A lens without a command ID is shown as plain text
+		Command: protocol.Command{Title: title},
+	}
+}
+
+// evaluationSummary describes the result of an instant query in a single line,
+// listing at most evaluationSamples samples
+func evaluationSummary(value model.Value) string {
+	switch v := value.(type) {
+	case model.Vector:
+		if len(v) == 0 {
+			return "no series"
+		}
+
+		var samples []string
+
+		for i, sample := range v {
+			if i == evaluationSamples {
+				samples = append(samples, "…")
+				break
+			}
+
+			samples = append(samples, fmt.Sprintf("%s => %s", sample.Metric, sample.Value))
+		}
+
+		return fmt.Sprintf("%d series: %s", len(v), strings.Join(samples, ", "))
+	case *model.Scalar:
+		return fmt.Sprintf("scalar: %s", v.Value)
+	case *model.String:
+		return fmt.Sprintf("string: %q", v.Value)
+	default:
+		return fmt.Sprintf("%s result", value.Type())
+	}
+}
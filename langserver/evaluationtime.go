@@ -0,0 +1,124 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus-community/promql-langserver/langserver/cache"
+	"github.com/prometheus/prometheus/promql"
+)
+
+// evaluationTimeDirective marks a comment that sets the evaluation time of a query, e.g. `# @ 2020-02-10T14:00:00Z`
+const evaluationTimeDirective = "@"
+
+// parseEvaluationTime parses a timestamp in one of the formats accepted by the Prometheus API,
+// i.e. a unix timestamp or RFC3339. An empty string or "now" result in the zero time, which
+// stands for the current time.
+func parseEvaluationTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+
+	if s == "" || s == "now" {
+		return time.Time{}, nil
+	}
+
+	if t, err := strconv.ParseFloat(s, 64); err == nil {
+		sec, frac := math.Modf(t)
+		return time.Unix(int64(sec), int64(frac*float64(time.Second))).UTC(), nil
+	}
+
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+
+	return time.Time{}, errors.Errorf("cannot parse %q to a valid timestamp", s)
+}
+
+// setEvaluationTime changes the time all live checks are run against
+func (s *server) setEvaluationTime(str string) error {
+	t, err := parseEvaluationTime(str)
+	if err != nil {
+		return errors.Wrap(err, "invalid evaluation time")
+	}
+
+	s.evaluationTimeMu.Lock()
+	defer s.evaluationTimeMu.Unlock()
+
+	s.evaluationTime = t
+
+	return nil
+}
+
+// getEvaluationTime returns the time live checks for a query should be run against.
+//
+// A `# @ <time>` comment inside the query takes precedence over the configured evaluation time.
+// The zero time is returned if neither is set, which stands for the current time.
+// Requests without a time range, e.g. for all label names, can't follow it.
+func (s *server) getEvaluationTime(query *cache.CompiledQuery) time.Time {
+	if query != nil {
+		if t, ok := queryEvaluationTime(query.Content); ok {
+			return t
+		}
+	}
+
+	s.evaluationTimeMu.RLock()
+	defer s.evaluationTimeMu.RUnlock()
+
+	return s.evaluationTime
+}
+
+// evaluationTimeOrNow is like getEvaluationTime, but resolves the zero time to the current time
+func (s *server) evaluationTimeOrNow(query *cache.CompiledQuery) time.Time {
+	if t := s.getEvaluationTime(query); !t.IsZero() {
+		return t
+	}
+
+	return time.Now()
+}
+
+// queryEvaluationTime looks for a `# @ <time>` comment in a query
+func queryEvaluationTime(content string) (time.Time, bool) {
+	if !strings.Contains(content, "#") {
+		return time.Time{}, false
+	}
+
+	l := promql.Lex(content)
+
+	for {
+		var item promql.Item
+
+		l.NextItem(&item)
+
+		switch item.Typ {
+		case promql.EOF, promql.ERROR:
+			return time.Time{}, false
+		case promql.COMMENT:
+			text := strings.TrimSpace(strings.TrimPrefix(item.Val, "#"))
+			if !strings.HasPrefix(text, evaluationTimeDirective) {
+				continue
+			}
+
+			t, err := parseEvaluationTime(strings.TrimPrefix(text, evaluationTimeDirective))
+			if err != nil || t.IsZero() {
+				continue
+			}
+
+			return t, true
+		}
+	}
+}
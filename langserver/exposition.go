@@ -0,0 +1,82 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/prometheus-community/promql-langserver/langserver/cache"
+)
+
+// localMetricFamilies returns the metric families of all exposition files that are
+// currently open or indexed in the workspace folders
+func (s *server) localMetricFamilies() []*cache.MetricFamily {
+	var ret []*cache.MetricFamily
+
+	// The files indexed in the workspace folders are in the cache as well
+	for _, doc := range s.cache.GetDocuments() {
+		families, err := doc.GetMetricFamilies()
+		if err != nil {
+			continue
+		}
+
+		ret = append(ret, families...)
+	}
+
+	return ret
+}
+
+// findLocalMetricFamily returns the metric family a sample name belongs to,
+// if it has been found in an open exposition file
+func (s *server) findLocalMetricFamily(metric string) *cache.MetricFamily {
+	for _, family := range s.localMetricFamilies() {
+		for _, name := range family.SampleNames {
+			if name == metric {
+				return family
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *server) getLocalMetricDocs(metric string) string {
+	family := s.findLocalMetricFamily(metric)
+	if family == nil {
+		return ""
+	}
+
+	var ret strings.Builder
+
+	if family.Help != "" {
+		fmt.Fprintf(&ret, "__Metric Help:__ %s\n\n", family.Help)
+	}
+
+	if family.Type != "" {
+		fmt.Fprintf(&ret, "__Metric Type:__  %s\n\n", family.Type)
+	}
+
+	if family.Unit != "" {
+		fmt.Fprintf(&ret, "__Metric Unit:__  %s\n\n", family.Unit)
+	}
+
+	if len(family.LabelNames) > 0 {
+		fmt.Fprintf(&ret, "__Labels:__  %s\n\n", strings.Join(family.LabelNames, ", "))
+	}
+
+	fmt.Fprintf(&ret, "_Found in an open exposition file_\n\n")
+
+	return ret.String()
+}
@@ -0,0 +1,75 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+// The versions of the extensions of the language server. A version is increased whenever the parameters
+// or the result of an extension change in a way existing clients can't handle.
+
+// extensionRequests are the custom requests the server handles
+var extensionRequests = map[string]int{ // nolint: gochecknoglobals
+	statusMethod:              1,
+	reloadConfigurationMethod: 1,
+}
+
+// extensionNotifications are the custom notifications the server sends
+var extensionNotifications = map[string]int{ // nolint: gochecknoglobals
+	statusMethod:          1,
+	metadataChangedMethod: 1,
+}
+
+// commandVersions are the versions of the supportedCommands
+var commandVersions = map[string]int{ // nolint: gochecknoglobals
+	commandPreviewAlertTemplates: 1,
+	commandPreviewAlertRouting:   1,
+	commandRecordCompletion:      1,
+	commandRunQuery:              1,
+	commandQueryHistory:          1,
+	commandRerunQuery:            1,
+	commandDeleteQueryHistory:    1,
+	commandRecordSnapshot:        1,
+	commandShareQuery:            1,
+	commandImportQuery:           1,
+	commandResolveQuery:          1,
+}
+
+// experimentalCapabilities are announced in the experimental server capabilities, so that client
+// extensions can detect the features of the server instead of checking its version
+type experimentalCapabilities struct {
+	PromQL promQLExtensions `json:"promql"`
+}
+
+// promQLExtensions maps the methods of the custom requests and notifications and the
+// commands available with the configuration of the server to their versions
+type promQLExtensions struct {
+	Requests      map[string]int `json:"requests"`
+	Notifications map[string]int `json:"notifications"`
+	Commands      map[string]int `json:"commands"`
+}
+
+// experimentalCapabilities returns the extensions supported by the server
+func (s *server) experimentalCapabilities() *experimentalCapabilities {
+	commands := make(map[string]int)
+
+	for _, command := range s.commands() {
+		commands[command] = commandVersions[command]
+	}
+
+	return &experimentalCapabilities{
+		PromQL: promQLExtensions{
+			Requests:      extensionRequests,
+			Notifications: extensionNotifications,
+			Commands:      commands,
+		},
+	}
+}
@@ -0,0 +1,45 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+// TestExperimentalCapabilities checks that all commands have a version and that only available commands are announced
+func TestExperimentalCapabilities(*testing.T) {
+	for _, command := range supportedCommands {
+		if commandVersions[command] == 0 {
+			panic(fmt.Sprintf("command %s has no version", command))
+		}
+	}
+
+	h, err := NewHeadlessServer(context.Background(), &Config{DemoMode: true}, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	capabilities := h.server.experimentalCapabilities().PromQL
+
+	if _, ok := capabilities.Commands[commandPreviewAlertTemplates]; ok {
+		panic(fmt.Sprintf("unexpected commands in demo mode: %v", capabilities.Commands))
+	}
+
+	if capabilities.Requests[reloadConfigurationMethod] == 0 || capabilities.Notifications[statusMethod] == 0 {
+		panic(fmt.Sprintf("expected the custom methods to be announced, got %+v", capabilities))
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+// The kinds of Prometheus servers without a query API
+const (
+	// prometheusAgent is a Prometheus running with --enable-feature=agent, it still serves
+	// the metadata of its scrape targets
+	prometheusAgent = "agent"
+	// prometheusFederateOnly is an endpoint that only exposes /federate, e.g. behind a restrictive proxy
+	prometheusFederateOnly = "federate"
+)
+
+// agentModeErr is the error an agent answers requests for query endpoints with
+const agentModeErr = "unavailable with Prometheus Agent"
+
+// probeQueryAPI checks whether a Prometheus server can evaluate queries. It returns the kind of
+// server if it can't, or an empty string if it can or the probe was inconclusive.
+func probeQueryAPI(ctx context.Context, client api.Client) string {
+	u := client.URL("/api/v1/query", nil)
+	u.RawQuery = url.Values{"query": []string{"1"}}.Encode()
+
+	resp, body, err := doProbe(ctx, client, u)
+	if err != nil {
+		return ""
+	}
+
+	switch {
+	case resp.StatusCode/100 == 2:
+		return ""
+	case strings.Contains(string(body), agentModeErr):
+		return prometheusAgent
+	case resp.StatusCode != http.StatusNotFound && resp.StatusCode != http.StatusMethodNotAllowed:
+		return ""
+	}
+
+	u = client.URL("/federate", nil)
+	u.RawQuery = url.Values{"match[]": []string{`{__name__="up"}`}}.Encode()
+
+	if resp, _, err := doProbe(ctx, client, u); err == nil && resp.StatusCode/100 == 2 {
+		return prometheusFederateOnly
+	}
+
+	return ""
+}
+
+// doProbe sends a GET request to a Prometheus server
+func doProbe(ctx context.Context, client api.Client, u *url.URL) (*http.Response, []byte, error) {
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return client.Do(ctx, req)
+}
+
+// limitationMessage explains which features are unavailable with a kind of Prometheus server
+func limitationMessage(mode string, url string) string {
+	switch mode {
+	case prometheusAgent:
+		return fmt.Sprintf("Prometheus at %s runs in agent mode and can't evaluate queries, so label and series "+
+			"completions, code lenses and query commands are disabled. Metric metadata is still shown.", url)
+	case prometheusFederateOnly:
+		return fmt.Sprintf("Prometheus at %s only exposes the /federate endpoint, so metric data is limited to "+
+			"metric catalogs and OpenMetrics documents in the workspace.", url)
+	}
+
+	return ""
+}
+
+// getQueryAPI returns the API of the Prometheus server if it can evaluate queries and look up series,
+// or nil otherwise. Features only using metric metadata use getPrometheus instead.
+func (s *server) getQueryAPI() v1.API {
+	s.prometheusMu.Lock()
+	mode := s.prometheusMode
+	s.prometheusMu.Unlock()
+
+	if mode != "" {
+		return nil
+	}
+
+	return s.getPrometheus()
+}
+
+// queryAPILimitation describes why the Prometheus server can't evaluate queries, or is empty if it can
+func (s *server) queryAPILimitation() string {
+	s.prometheusMu.Lock()
+	defer s.prometheusMu.Unlock()
+
+	return limitationMessage(s.prometheusMode, s.PrometheusURL)
+}
@@ -0,0 +1,78 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestQueryAPIProbe checks that agents and federate-only endpoints are detected
+func TestQueryAPIProbe(*testing.T) {
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.URL.Path {
+		case "/api/v1/query":
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			fmt.Fprint(w, `{"status":"error","errorType":"unavailable","error":"unavailable with Prometheus Agent"}`)
+		default:
+			fmt.Fprint(w, `{"status":"success","data":{}}`)
+		}
+	}))
+	defer agent.Close()
+
+	federate := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/federate" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		fmt.Fprint(w, "# TYPE up untyped\nup{job=\"prometheus\"} 1\n")
+	}))
+	defer federate.Close()
+
+	tests := []struct {
+		url      string
+		mode     string
+		metadata bool
+	}{
+		{agent.URL, prometheusAgent, true},
+		{federate.URL, prometheusFederateOnly, false},
+	}
+
+	for _, test := range tests {
+		h, err := NewHeadlessServer(context.Background(), &Config{PrometheusURL: test.url}, nil)
+		if err != nil {
+			panic(err)
+		}
+
+		if h.server.prometheusMode != test.mode {
+			panic(fmt.Sprintf("expected %s to be detected as %q, got %q", test.url, test.mode, h.server.prometheusMode))
+		}
+
+		if h.server.getQueryAPI() != nil || (h.server.getPrometheus() != nil) != test.metadata {
+			panic(fmt.Sprintf("unexpected APIs for %q mode", test.mode))
+		}
+
+		if status := h.server.getStatus(); status.Limitation == "" {
+			panic(fmt.Sprintf("expected the status to report the limitation of %q mode, got %+v", test.mode, status))
+		}
+
+		h.Close()
+	}
+}
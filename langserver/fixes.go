@@ -0,0 +1,209 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"go/token"
+	"regexp"
+	"strconv"
+
+	"github.com/prometheus-community/promql-langserver/langserver/cache"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+	"github.com/prometheus/prometheus/promql"
+)
+
+// The rules quick fixes are grouped by, so they can be applied selectively
+const (
+	fixMatcherNormalize = "matcher-normalize"
+	fixDeprecatedMetric = "deprecated-metric"
+)
+
+// QuickFixRules lists the rules of all quick fixes
+func QuickFixRules() []string {
+	return []string{fixMatcherNormalize, fixDeprecatedMetric, fixYamlEscaping, fixPrecedence, fixScalarSeries, fixConversionRoundTrip}
+}
+
+// quickFix is a diagnostic together with a change that resolves it
+type quickFix struct {
+	// Rule identifies the check the quick fix belongs to
+	Rule       string
+	Title      string
+	Diagnostic protocol.Diagnostic
+	Edits      []tokenEdit
+}
+
+// tokenEdit replaces the text between two positions of a document
+type tokenEdit struct {
+	Pos     token.Pos
+	End     token.Pos
+	NewText string
+}
+
+// getQuickFixes returns all diagnostics of a document that can be fixed automatically
+func (s *server) getQuickFixes(doc *cache.DocumentHandle) []quickFix {
+	ret := append(matcherFixes(doc), s.catalogFixes(doc)...)
+	ret = append(ret, yamlEscapingFixes(doc)...)
+	ret = append(ret, conversionFixes(doc)...)
+
+	ret = append(ret, s.vectorMatchingFixes(doc)...)
+
+	return append(ret, s.precedenceFixes(doc)...)
+}
+
+// quickFixDiagnostics returns the diagnostics of a list of quick fixes
+func quickFixDiagnostics(fixes []quickFix) []protocol.Diagnostic {
+	ret := make([]protocol.Diagnostic, 0, len(fixes))
+
+	for _, fix := range fixes {
+		ret = append(ret, fix.Diagnostic)
+	}
+
+	return ret
+}
+
+// quickFixCodeActions returns the code actions fixing the diagnostics inside a range
+func (s *server) quickFixCodeActions(doc *cache.DocumentHandle, rng protocol.Range) []protocol.CodeAction {
+	var ret []protocol.CodeAction
+
+	fixes := append(s.getQuickFixes(doc), rangeSelectorFixes(doc)...)
+
+	for _, fix := range append(fixes, escapeSequenceFixes(doc)...) {
+		if !rangesOverlap(fix.Diagnostic.Range, rng) {
+			continue
+		}
+
+		edits, err := protocolEdits(doc, fix.Edits)
+		if err != nil {
+			continue
+		}
+
+		// The diagnostic has to match the published one
+		diagnostics := []protocol.Diagnostic{fix.Diagnostic}
+		s.addDiagnosticDocs(diagnostics)
+		addDiagnosticTags(diagnostics)
+		s.remapSeverities(diagnostics)
+
+		ret = append(ret, protocol.CodeAction{
+			Title:       fix.Title,
+			Kind:        protocol.QuickFix,
+			Diagnostics: diagnostics,
+			IsPreferred: true,
+			Edit: protocol.WorkspaceEdit{
+				Changes: map[string][]protocol.TextEdit{
+					doc.GetURI(): edits,
+				},
+			},
+		})
+	}
+
+	return ret
+}
+
+func protocolEdits(doc *cache.DocumentHandle, edits []tokenEdit) ([]protocol.TextEdit, error) {
+	ret := make([]protocol.TextEdit, 0, len(edits))
+
+	for _, e := range edits {
+		rng, err := tokenRange(doc, e.Pos, e.End)
+		if err != nil {
+			return nil, err
+		}
+
+		ret = append(ret, protocol.TextEdit{Range: rng, NewText: e.NewText})
+	}
+
+	return ret, nil
+}
+
+// rangesOverlap checks whether two ranges have at least one position in common
+func rangesOverlap(a protocol.Range, b protocol.Range) bool {
+	return positionInRange(a.Start, b) || positionInRange(a.End, b) || positionInRange(b.Start, a)
+}
+
+// matcherFixes suggests to replace regex matchers that don't use any regex features
+// with the equivalent equality matchers, which are easier to read and faster to evaluate
+func matcherFixes(doc *cache.DocumentHandle) []quickFix {
+	queries, err := doc.GetQueries()
+	if err != nil {
+		return nil
+	}
+
+	var ret []quickFix
+
+	for _, q := range queries {
+		var items []promql.Item
+
+		l := promql.Lex(q.Content)
+
+		for {
+			var item promql.Item
+
+			l.NextItem(&item)
+
+			if item.Typ == promql.EOF || item.Typ == promql.ERROR {
+				break
+			}
+
+			if item.Typ != promql.COMMENT {
+				items = append(items, item)
+			}
+		}
+
+		for i := 0; i+2 < len(items); i++ {
+			label, op, value := items[i], items[i+1], items[i+2]
+
+			if label.Typ != promql.IDENTIFIER || value.Typ != promql.STRING {
+				continue
+			}
+
+			var replacement string
+
+			switch op.Typ {
+			case promql.EQL_REGEX:
+				replacement = "="
+			case promql.NEQ_REGEX:
+				replacement = "!="
+			default:
+				continue
+			}
+
+			str, err := strconv.Unquote(value.Val)
+			if err != nil || regexp.QuoteMeta(str) != str {
+				continue
+			}
+
+			pos := q.Pos + token.Pos(op.Pos)
+			end := pos + token.Pos(len(op.Val))
+
+			rng, err := tokenRange(doc, pos, q.Pos+token.Pos(int(value.Pos)+len(value.Val)))
+			if err != nil {
+				continue
+			}
+
+			ret = append(ret, quickFix{
+				Rule:  fixMatcherNormalize,
+				Title: "Use " + replacement + " matcher",
+				Diagnostic: protocol.Diagnostic{
+					Range:    rng,
+					Severity: 3, // Information
+					Code:     fixMatcherNormalize,
+					Source:   "promql-lsp",
+					Message:  "regex matcher without special characters can be replaced by " + replacement,
+				},
+				Edits: []tokenEdit{{Pos: pos, End: end, NewText: replacement}},
+			})
+		}
+	}
+
+	return ret
+}
@@ -0,0 +1,432 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"go/token"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus-community/promql-langserver/langserver/cache"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql"
+)
+
+// formatLineWidth is the line length up to which expressions are kept on a single line
+const formatLineWidth = 80
+
+// Formatting formats all queries of a document
+// required by the protocol.Server interface
+func (s *server) Formatting(_ context.Context, params *protocol.DocumentFormattingParams) ([]protocol.TextEdit, error) {
+	doc, err := s.cache.GetDocument(params.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+
+	return formatDocument(doc, params.Options, nil)
+}
+
+// RangeFormatting formats the queries of a document that overlap a range
+// required by the protocol.Server interface
+func (s *server) RangeFormatting(_ context.Context, params *protocol.DocumentRangeFormattingParams) ([]protocol.TextEdit, error) {
+	doc, err := s.cache.GetDocument(params.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+
+	return formatDocument(doc, params.Options, &params.Range)
+}
+
+// FormatQuery formats a single query, indented with two spaces. Queries that can't be printed without
+// losing information, e.g. because they contain comments, are returned unchanged.
+func FormatQuery(query string) (string, error) {
+	if _, err := promql.ParseExpr(query); err != nil {
+		return "", err
+	}
+
+	return formatImportedQuery(strings.TrimSpace(query), "  ", 0, true), nil
+}
+
+// formatDocument returns the edits that format the queries of a document.
+// If rng is not nil, only the queries overlapping it are formatted.
+func formatDocument(doc *cache.DocumentHandle, options protocol.FormattingOptions, rng *protocol.Range) ([]protocol.TextEdit, error) {
+	queries, err := doc.GetQueries()
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := doc.GetContent()
+	if err != nil {
+		return nil, err
+	}
+
+	ret := []protocol.TextEdit{}
+
+	// Jsonnet queries are often format strings, formatting them could break their specifiers. The queries
+	// of console templates are arguments of template actions and JavaScript, which have their own layout.
+	if lang := doc.GetLanguageID(); lang == "jsonnet" || lang == "html" {
+		return ret, nil
+	}
+
+	for _, q := range queries {
+		// Formatted queries span multiple lines, which would have to be escaped in JSON strings
+		if q.InJSONString {
+			continue
+		}
+
+		edit, ok := formatQuery(doc, content, q, options)
+		if !ok || (rng != nil && !rangesOverlap(edit.Range, *rng)) {
+			continue
+		}
+
+		ret = append(ret, edit)
+	}
+
+	return ret, nil
+}
+
+// formatQuery returns the edit that formats a query. Queries that can't be printed without
+// losing information, e.g. because they contain comments, are left unchanged.
+// nolint: funlen
+func formatQuery(doc *cache.DocumentHandle, content string, q *cache.CompiledQuery, options protocol.FormattingOptions) (protocol.TextEdit, bool) {
+	if q.Ast == nil || len(q.Err) != 0 || len(q.AtModifiers) != 0 || hasComments(q.Content) {
+		return protocol.TextEdit{}, false
+	}
+
+	expr, ok := q.Ast.(promql.Expr)
+	if !ok {
+		return protocol.TextEdit{}, false
+	}
+
+	trimmed := strings.TrimSpace(q.Content)
+	start := q.Pos + token.Pos(strings.Index(q.Content, trimmed))
+	end := start + token.Pos(len(trimmed))
+
+	var newText string
+
+	switch doc.GetLanguageID() {
+	case "promql", "markdown":
+		indent := "\t"
+		if options.InsertSpaces {
+			indent = strings.Repeat(" ", int(options.TabSize))
+		}
+
+		f := &formatter{content: q.Content, indent: indent}
+
+		if doc.GetLanguageID() == "markdown" {
+			// Code blocks inside of Markdown lists are indented
+			offset := doc.ByteOffset(start)
+			blockIndent := content[strings.LastIndexByte(content[:offset], '\n')+1 : offset]
+			f.width = len(blockIndent)
+
+			newText = indentLines(f.format(expr, 0), blockIndent)
+		} else {
+			newText = f.format(expr, 0)
+		}
+	default:
+		f := &formatter{content: q.Content, indent: "  "}
+
+		offset := doc.ByteOffset(q.Pos)
+		linePrefix := content[strings.LastIndexByte(content[:offset], '\n')+1 : offset]
+
+		var blockIndent string
+
+		if linePrefix == "" {
+			// Block scalars start at the beginning of the line following the '|' or '>'
+			firstLine := strings.TrimLeft(q.Content, "\r\n")
+			blockIndent = firstLine[:len(firstLine)-len(strings.TrimLeft(firstLine, " "))]
+			start = q.Pos + token.Pos(len(q.Content)-len(firstLine))
+			f.width = len(blockIndent)
+
+			newText = blockIndent + indentLines(f.format(expr, 0), blockIndent)
+		} else {
+			// Plain scalars are turned into literal block scalars if they don't fit into a single line
+			keyIndent := len(linePrefix) - len(strings.TrimLeft(linePrefix, " -"))
+			blockIndent = strings.Repeat(" ", keyIndent+2)
+			f.width = len(blockIndent)
+
+			newText = f.format(expr, 0)
+			if strings.Contains(newText, "\n") {
+				newText = "|\n" + blockIndent + indentLines(newText, blockIndent)
+			}
+		}
+	}
+
+	if !sameExpr(expr, newText) {
+		return protocol.TextEdit{}, false
+	}
+
+	if newText == content[doc.ByteOffset(start):doc.ByteOffset(end)] {
+		return protocol.TextEdit{}, false
+	}
+
+	rng, err := tokenRange(doc, start, end)
+	if err != nil {
+		return protocol.TextEdit{}, false
+	}
+
+	return protocol.TextEdit{Range: rng, NewText: newText}, true
+}
+
+// hasComments returns whether a query contains comments, which are not part of the AST
+func hasComments(query string) bool {
+	l := promql.Lex(query)
+
+	for {
+		var item promql.Item
+
+		l.NextItem(&item)
+
+		switch item.Typ {
+		case promql.COMMENT:
+			return true
+		case promql.EOF, promql.ERROR:
+			return false
+		}
+	}
+}
+
+// sameExpr checks that formatted text, which may be a yaml block scalar, still parses to the same expression
+func sameExpr(expr promql.Expr, formatted string) bool {
+	formatted = strings.TrimPrefix(formatted, "|\n")
+
+	parsed, err := promql.ParseExpr(formatted)
+	if err != nil {
+		return false
+	}
+
+	return (&formatter{}).single(parsed) == (&formatter{}).single(expr)
+}
+
+// indentLines adds an indentation to all lines but the first
+func indentLines(text string, indent string) string {
+	return strings.Replace(text, "\n", "\n"+indent, -1)
+}
+
+// formatter pretty-prints PromQL expressions
+type formatter struct {
+	// content is the query text, used to preserve the notation of number literals
+	content string
+	// indent is the indentation of nested expressions
+	indent string
+	// width is the space on the left of the expression that doesn't count towards formatLineWidth
+	width int
+}
+
+// format prints an expression, splitting it over multiple lines if it doesn't fit into a single one.
+// All but the first line are indented by depth levels.
+// nolint: funlen
+func (f *formatter) format(expr promql.Expr, depth int) string {
+	s := f.single(expr)
+	if f.width+len(f.indent)*depth+len(s) <= formatLineWidth {
+		return s
+	}
+
+	prefix := strings.Repeat(f.indent, depth)
+	inner := prefix + f.indent
+
+	switch n := expr.(type) {
+	case *promql.AggregateExpr:
+		args := []promql.Expr{n.Expr}
+		if n.Param != nil {
+			args = []promql.Expr{n.Param, n.Expr}
+		}
+
+		return aggregateHead(n) + "(\n" + inner + f.formatList(args, depth+1) + "\n" + prefix + ")"
+	case *promql.Call:
+		if len(n.Args) == 0 {
+			return s
+		}
+
+		return n.Func.Name + "(\n" + inner + f.formatList(n.Args, depth+1) + "\n" + prefix + ")"
+	case *promql.ParenExpr:
+		return "(\n" + inner + f.format(n.Expr, depth+1) + "\n" + prefix + ")"
+	case *promql.BinaryExpr:
+		return f.format(n.LHS, depth) + "\n" + prefix + binaryOperator(n) + " " + f.format(n.RHS, depth)
+	case *promql.UnaryExpr:
+		return n.Op.String() + f.format(n.Expr, depth)
+	case *promql.VectorSelector:
+		return f.selector(n, depth, true) + offset(n.Offset)
+	case *promql.MatrixSelector:
+		vs, ok := n.VectorSelector.(*promql.VectorSelector)
+		if !ok {
+			return s
+		}
+
+		return f.selector(vs, depth, true) + "[" + model.Duration(n.Range).String() + "]" + offset(vs.Offset)
+	case *promql.SubqueryExpr:
+		return f.format(n.Expr, depth) + subqueryRange(n)
+	}
+
+	return s
+}
+
+// formatList prints the arguments of a function or aggregation, one per line
+func (f *formatter) formatList(args promql.Expressions, depth int) string {
+	ret := make([]string, 0, len(args))
+
+	for _, arg := range args {
+		ret = append(ret, f.format(arg, depth))
+	}
+
+	return strings.Join(ret, ",\n"+strings.Repeat(f.indent, depth))
+}
+
+// single prints an expression on a single line
+func (f *formatter) single(expr promql.Expr) string {
+	switch n := expr.(type) {
+	case *promql.AggregateExpr:
+		args := []promql.Expr{n.Expr}
+		if n.Param != nil {
+			args = []promql.Expr{n.Param, n.Expr}
+		}
+
+		return aggregateHead(n) + "(" + f.singleList(args) + ")"
+	case *promql.Call:
+		return n.Func.Name + "(" + f.singleList(n.Args) + ")"
+	case *promql.ParenExpr:
+		return "(" + f.single(n.Expr) + ")"
+	case *promql.BinaryExpr:
+		return f.single(n.LHS) + " " + binaryOperator(n) + " " + f.single(n.RHS)
+	case *promql.UnaryExpr:
+		return n.Op.String() + f.single(n.Expr)
+	case *promql.NumberLiteral:
+		// Keep the notation of the number, e.g. 1e9 or 0x10
+		if int(n.PosRange.End) <= len(f.content) && n.PosRange.Start < n.PosRange.End {
+			return f.content[n.PosRange.Start:n.PosRange.End]
+		}
+
+		return strconv.FormatFloat(n.Val, 'f', -1, 64)
+	case *promql.StringLiteral:
+		return strconv.Quote(n.Val)
+	case *promql.VectorSelector:
+		return f.selector(n, 0, false) + offset(n.Offset)
+	case *promql.MatrixSelector:
+		vs, ok := n.VectorSelector.(*promql.VectorSelector)
+		if !ok {
+			return n.String()
+		}
+
+		return f.selector(vs, 0, false) + "[" + model.Duration(n.Range).String() + "]" + offset(vs.Offset)
+	case *promql.SubqueryExpr:
+		return f.single(n.Expr) + subqueryRange(n)
+	}
+
+	return expr.String()
+}
+
+func (f *formatter) singleList(args promql.Expressions) string {
+	ret := make([]string, 0, len(args))
+
+	for _, arg := range args {
+		ret = append(ret, f.single(arg))
+	}
+
+	return strings.Join(ret, ", ")
+}
+
+// selector prints the metric name and label matchers of a selector.
+// If multiline is set, the matchers are printed one per line
+func (f *formatter) selector(vs *promql.VectorSelector, depth int, multiline bool) string {
+	var matchers []string
+
+	for _, m := range vs.LabelMatchers {
+		if vs.Name != "" && m.Name == labels.MetricName && m.Type == labels.MatchEqual {
+			continue
+		}
+
+		matchers = append(matchers, m.Name+m.Type.String()+strconv.Quote(m.Value))
+	}
+
+	switch {
+	case len(matchers) == 0:
+		if vs.Name == "" {
+			return "{}"
+		}
+
+		return vs.Name
+	case multiline && len(matchers) > 1:
+		prefix := strings.Repeat(f.indent, depth)
+
+		return vs.Name + "{\n" + prefix + f.indent + strings.Join(matchers, ",\n"+prefix+f.indent) + "\n" + prefix + "}"
+	}
+
+	return vs.Name + "{" + strings.Join(matchers, ", ") + "}"
+}
+
+// aggregateHead prints an aggregation operator with its grouping, e.g. `sum by (job) `
+func aggregateHead(n *promql.AggregateExpr) string {
+	ret := n.Op.String()
+
+	switch {
+	case n.Without:
+		ret += " without (" + strings.Join(n.Grouping, ", ") + ") "
+	case len(n.Grouping) > 0:
+		ret += " by (" + strings.Join(n.Grouping, ", ") + ") "
+	}
+
+	return ret
+}
+
+// binaryOperator prints the operator of a binary expression together with its modifiers
+func binaryOperator(n *promql.BinaryExpr) string {
+	ret := n.Op.String()
+
+	if n.ReturnBool {
+		ret += " bool"
+	}
+
+	if m := n.VectorMatching; m != nil {
+		switch {
+		case m.On:
+			ret += " on (" + strings.Join(m.MatchingLabels, ", ") + ")"
+		case len(m.MatchingLabels) > 0:
+			ret += " ignoring (" + strings.Join(m.MatchingLabels, ", ") + ")"
+		}
+
+		switch m.Card {
+		case promql.CardManyToOne:
+			ret += " group_left"
+		case promql.CardOneToMany:
+			ret += " group_right"
+		}
+
+		if len(m.Include) > 0 {
+			ret += " (" + strings.Join(m.Include, ", ") + ")"
+		}
+	}
+
+	return ret
+}
+
+func subqueryRange(n *promql.SubqueryExpr) string {
+	step := ""
+	if n.Step != 0 {
+		step = model.Duration(n.Step).String()
+	}
+
+	return "[" + model.Duration(n.Range).String() + ":" + step + "]" + offset(n.Offset)
+}
+
+func offset(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+
+	return " offset " + model.Duration(d).String()
+}
@@ -0,0 +1,80 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// TestFormatting checks the pretty-printing of queries
+func TestFormatting(*testing.T) {
+	h, err := NewHeadlessServer(context.Background(), &Config{}, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	tests := []struct {
+		languageID string
+		content    string
+		expected   string
+	}{
+		{"promql", `sum   by(job)(rate(foo{job='api'}[5m]offset 1h))/on(job)bar>bool 1e3`,
+			`sum by (job) (rate(foo{job="api"}[5m] offset 1h)) / on (job) bar > bool 1e3`},
+		{"promql", `sum by (job) (rate(foo{job="api", instance=~"a.*"}[5m])) / on (job) group_left (team) sum by (job, team) (bar)`,
+			"sum by (job) (rate(foo{job=\"api\", instance=~\"a.*\"}[5m]))\n/ on (job) group_left (team) sum by (job, team) (bar)"},
+		{"promql", `histogram_quantile(0.9, sum by (le, job) (rate(http_request_duration_seconds_bucket{job="api", handler="/api/v1/query"}[5m])))`,
+			"histogram_quantile(\n  0.9,\n  sum by (le, job) (\n    rate(\n      http_request_duration_seconds_bucket{\n" +
+				"        job=\"api\",\n        handler=\"/api/v1/query\"\n      }[5m]\n    )\n  )\n)"},
+		{"promql", "sum(foo) # a comment", ""},
+		{"promql", "sum(foo @ end())", ""},
+		{"yaml", "groups:\n- name: a\n  rules:\n  - record: a\n    expr: sum  (foo)\n", "sum(foo)"},
+		{"yaml", "groups:\n- name: a\n  rules:\n  - record: a\n    expr: " +
+			`sum by (job, instance, handler, code) (rate(http_requests_total{job="api"}[5m]))` + "\n",
+			"|\n      sum by (job, instance, handler, code) (\n        rate(http_requests_total{job=\"api\"}[5m])\n      )"},
+		{"markdown", "* Errors:\n\n  ```promql\n  " + `sum by (job, instance, handler, code) (rate(http_requests_total{job="api"}[5m]))` + "\n  ```\n",
+			"sum by (job, instance, handler, code) (\n    rate(http_requests_total{job=\"api\"}[5m])\n  )"},
+	}
+
+	for i, test := range tests {
+		uri := fmt.Sprintf("formatting_%d.%s", i, test.languageID)
+
+		if err := h.AddDocument(uri, test.languageID, test.content); err != nil {
+			panic(err)
+		}
+
+		doc, err := h.server.cache.GetDocument(uri)
+		if err != nil {
+			panic(err)
+		}
+
+		edits, err := formatDocument(doc, protocol.FormattingOptions{TabSize: 2, InsertSpaces: true}, nil)
+		if err != nil {
+			panic(err)
+		}
+
+		formatted := ""
+		if len(edits) == 1 {
+			formatted = edits[0].NewText
+		}
+
+		if len(edits) > 1 || formatted != test.expected {
+			panic(fmt.Sprintf("Expected %q for %q, got %v", test.expected, test.content, edits))
+		}
+	}
+}
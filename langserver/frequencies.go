@@ -0,0 +1,187 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// commandRecordCompletion is attached to metric completions, so the client reports which ones are inserted
+const commandRecordCompletion = "promql.recordCompletion"
+
+// maxFrequency bounds the counts used for ranking, so that they fit into the sort text
+const maxFrequency = 999999
+
+// metricFrequencies counts how often the metrics of a workspace have been inserted by completion.
+// The counts never leave the machine, they are persisted in the cache directory of the user.
+type metricFrequencies struct {
+	// path is the file the counts are persisted in, they are kept in memory only if it is empty
+	path   string
+	loaded bool
+	counts map[string]int
+	mu     sync.Mutex
+}
+
+// frequencyFile is the format the counts are persisted in
+type frequencyFile struct {
+	Metrics map[string]int `json:"metrics"`
+}
+
+// newMetricFrequencies creates the frequency store of a workspace.
+// The counts are read lazily, so servers that never complete don't touch the file system.
+func newMetricFrequencies(workspace string, persist bool) *metricFrequencies {
+	ret := &metricFrequencies{counts: make(map[string]int)}
+
+	if !persist {
+		return ret
+	}
+
+	ret.path = workspaceCacheFile("completions", workspace)
+
+	return ret
+}
+
+// workspaceCacheFile returns the path of a file storing data of a workspace in the cache directory of the user,
+// or an empty string if there is no cache directory
+func workspaceCacheFile(kind string, workspace string) string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+
+	hash := sha256.Sum256([]byte(workspace))
+
+	return filepath.Join(dir, "promql-langserver", kind, hex.EncodeToString(hash[:8])+".json")
+}
+
+// writeCacheFile stores v as JSON in a file created by workspaceCacheFile
+func writeCacheFile(path string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first, so concurrent servers never read incomplete files
+	tmp := path + ".tmp"
+
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// workspaceRoot returns the URI identifying the workspace of a client
+func workspaceRoot(params *protocol.ParamInitialize) string {
+	if len(params.WorkspaceFolders) > 0 {
+		return params.WorkspaceFolders[0].URI
+	}
+
+	return string(params.RootURI)
+}
+
+// load reads the persisted counts, the caller must hold the lock
+func (f *metricFrequencies) load() {
+	if f.loaded || f.path == "" {
+		return
+	}
+
+	f.loaded = true
+
+	data, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return
+	}
+
+	var file frequencyFile
+
+	if err := json.Unmarshal(data, &file); err == nil && file.Metrics != nil {
+		f.counts = file.Metrics
+	}
+}
+
+// get returns how often a metric has been inserted
+func (f *metricFrequencies) get(metric string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.load()
+
+	return f.counts[metric]
+}
+
+// record counts an insertion of a metric and persists the counts
+func (f *metricFrequencies) record(metric string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.load()
+
+	if f.counts[metric] < maxFrequency {
+		f.counts[metric]++
+	}
+
+	if f.path == "" {
+		return nil
+	}
+
+	return writeCacheFile(f.path, &frequencyFile{Metrics: f.counts})
+}
+
+// sortKey orders metrics by how often they have been inserted, most frequent first
+func (f *metricFrequencies) sortKey(metric string) string {
+	return fmt.Sprintf("%06d_", maxFrequency-f.get(metric))
+}
+
+// metricSortText ranks metrics that have been inserted before together with the recording rules of the
+// document, ahead of all other metrics
+func (s *server) metricSortText(metric string) string {
+	if s.frequencies.get(metric) > 0 {
+		return "__2__" + s.frequencies.sortKey(metric) + metric
+	}
+
+	return "__3__" + metric
+}
+
+// recordCompletionCommand returns the command that is run by the client when a metric completion is inserted
+func recordCompletionCommand(metric string) *protocol.Command {
+	return &protocol.Command{
+		Title:     "Record completion",
+		Command:   commandRecordCompletion,
+		Arguments: []interface{}{metric},
+	}
+}
+
+// recordCompletion implements the promql.recordCompletion command
+func (s *server) recordCompletion(params *protocol.ExecuteCommandParams) error {
+	var metric string
+	if err := decodeCommandArgument(params, &metric); err != nil {
+		return err
+	}
+
+	return s.frequencies.record(metric)
+}
@@ -0,0 +1,54 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestMetricFrequencies checks that inserted metrics are counted, persisted and ranked first
+func TestMetricFrequencies(*testing.T) {
+	dir, err := ioutil.TempDir("", "promql-langserver")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "completions", "workspace.json")
+
+	f := &metricFrequencies{path: path, counts: make(map[string]int)}
+
+	for _, metric := range []string{"up", "up", "node_load1"} {
+		if err := f.record(metric); err != nil {
+			panic(err)
+		}
+	}
+
+	// A new store for the same workspace sees the persisted counts
+	f = &metricFrequencies{path: path, counts: make(map[string]int)}
+
+	if f.get("up") != 2 || f.get("node_load1") != 1 || f.get("node_load5") != 0 {
+		panic("persisted counts were not loaded")
+	}
+
+	s := &server{frequencies: f}
+
+	up, load1, load5 := s.metricSortText("up"), s.metricSortText("node_load1"), s.metricSortText("node_load5")
+	if !(up < load1 && load1 < load5) {
+		panic("expected frequently inserted metrics to be ranked first, got " + up + ", " + load1 + ", " + load5)
+	}
+}
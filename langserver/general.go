@@ -34,7 +34,42 @@ func (s *server) Initialize(ctx context.Context, params *protocol.ParamInitializ
 
 	s.state = serverInitializing
 
-	s.cache.Init()
+	storage, err := s.getConfig().documentStorage()
+	if err != nil {
+		if storage == nil {
+			return nil, err
+		}
+
+		// nolint: errcheck
+		s.client.LogMessage(ctx, &protocol.LogMessageParams{
+			Type:    protocol.Error,
+			Message: err.Error(),
+		})
+	}
+
+	s.cache.InitWithStorage(storage)
+	s.applyCompileDebounce()
+
+	go func() {
+		<-s.lifetime.Done()
+		s.cache.Close() // nolint: errcheck
+	}()
+
+	s.setTrace(params.Trace)
+
+	s.workspace = newWorkspaceIndex(params)
+
+	// Demo and read-only mode don't allow writing local files
+	s.frequencies = newMetricFrequencies(workspaceRoot(params), !s.getConfig().DemoMode && !s.getConfig().ReadOnly)
+	s.history = newQueryHistory(workspaceRoot(params), !s.getConfig().DemoMode && !s.getConfig().ReadOnly)
+
+	if err := s.setSeverityMapping(params); err != nil {
+		// nolint: errcheck
+		s.client.LogMessage(ctx, &protocol.LogMessageParams{
+			Type:    protocol.Error,
+			Message: err.Error(),
+		})
+	}
 
 	return &protocol.InitializeResult{
 		Capabilities: protocol.ServerCapabilities{
@@ -46,13 +81,35 @@ func (s *server) Initialize(ctx context.Context, params *protocol.ParamInitializ
 			HoverProvider: true,
 			CompletionProvider: protocol.CompletionOptions{
 				TriggerCharacters: []string{
-					" ", "\n", "\t", "(", ")", "[", "]", "{", "}", "+", "-", "*", "/", "!", "=", "\"", ",", "'", "\"", "`", "a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l", "n", "m", "o", "p", "q", "r", "s", "t", "u", "v", "w", "x", "y", "z", "A", "B", "C", "D", "E", "F", "G", "H", "I", "J", "K", "L", "N", "M", "O", "P", "Q", "R", "S", "T", "U", "V", "W", "X", "Y", "Z",
+					" ", "\n", "\t", "(", ")", "[", "]", "{", "}", "+", "-", "*", "/", "!", "=", "\"", ",", "'", "\"", "`", "@", "a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l", "n", "m", "o", "p", "q", "r", "s", "t", "u", "v", "w", "x", "y", "z", "A", "B", "C", "D", "E", "F", "G", "H", "I", "J", "K", "L", "N", "M", "O", "P", "Q", "R", "S", "T", "U", "V", "W", "X", "Y", "Z",
+					"0", "1", "2", "3", "4", "5", "6", "7", "8", "9",
 				},
 			},
 			SignatureHelpProvider: protocol.SignatureHelpOptions{
 				TriggerCharacters: []string{"(", ","},
 			},
-			DefinitionProvider: true,
+			DefinitionProvider:              true,
+			ReferencesProvider:              true,
+			DocumentSymbolProvider:          true,
+			WorkspaceSymbolProvider:         true,
+			DocumentFormattingProvider:      true,
+			DocumentRangeFormattingProvider: true,
+			RenameProvider: protocol.RenameOptions{
+				PrepareProvider: true,
+			},
+			ExecuteCommandProvider: protocol.ExecuteCommandOptions{
+				Commands: s.commands(),
+			},
+			CodeActionProvider: protocol.CodeActionOptions{
+				CodeActionKinds: []protocol.CodeActionKind{protocol.QuickFix, protocol.RefactorExtract, protocol.RefactorRewrite, protocol.Source, protocol.SourceOrganizeImports},
+			},
+			Workspace: protocol.WorkspaceGn{
+				WorkspaceFolders: protocol.WorkspaceFoldersGn{
+					Supported:           true,
+					ChangeNotifications: "promql-lsp-workspace-folders",
+				},
+			},
+			Experimental: s.experimentalCapabilities(),
 		},
 	}, nil
 }
@@ -67,8 +124,8 @@ func (s *server) Initialized(ctx context.Context, params *protocol.InitializedPa
 		return errors.New("cannot initialize server: wrong server state")
 	}
 
-	if s.config.PrometheusURL != "" {
-		if err := s.connectPrometheus(s.config.PrometheusURL); err != nil {
+	if s.getConfig().PrometheusURL != "" {
+		if err := s.connectPrometheus(s.getConfig().PrometheusURL); err != nil {
 			// nolint: errcheck
 			s.client.LogMessage(ctx, &protocol.LogMessageParams{
 				Type:    protocol.Info,
@@ -83,6 +140,51 @@ func (s *server) Initialized(ctx context.Context, params *protocol.InitializedPa
 		})
 	}
 
+	if len(s.getConfig().PrometheusServers) > 0 {
+		s.configureEndpoints(s.getConfig().PrometheusServers, s.getConfig().PrometheusMapping)
+	}
+
+	if err := s.connectMetricCatalog(s.getConfig().MetricCatalog); err != nil {
+		// nolint: errcheck
+		s.client.LogMessage(ctx, &protocol.LogMessageParams{
+			Type:    protocol.Error,
+			Message: err.Error(),
+		})
+	}
+
+	if err := s.setEvaluationTime(s.getConfig().EvaluationTime); err != nil {
+		// nolint: errcheck
+		s.client.LogMessage(ctx, &protocol.LogMessageParams{
+			Type:    protocol.Error,
+			Message: err.Error(),
+		})
+	}
+
+	if err := s.startMetadataRefresh(); err != nil {
+		// nolint: errcheck
+		s.client.LogMessage(ctx, &protocol.LogMessageParams{
+			Type:    protocol.Error,
+			Message: err.Error(),
+		})
+	}
+
+	if err := s.startTelemetry(); err != nil {
+		// nolint: errcheck
+		s.client.LogMessage(ctx, &protocol.LogMessageParams{
+			Type:    protocol.Error,
+			Message: err.Error(),
+		})
+	}
+
+	go s.registerCapabilities(s.lifetime)
+	go s.watchConfigFile()
+
+	// Demo mode doesn't allow reading local files. A HeadlessServer indexes the workspace
+	// before it is returned, instead of in the background.
+	if !s.getConfig().DemoMode && s.Conn != nil {
+		go s.indexWorkspace()
+	}
+
 	s.state = serverInitialized
 
 	return nil
@@ -0,0 +1,481 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/prometheus-community/promql-langserver/langserver/cache"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql"
+)
+
+// seriesLookback is the time range series metadata is requested for
+const seriesLookback = time.Hour
+
+// labelSet describes the label names the series returned by an expression can have
+type labelSet struct {
+	// Names are labels that exist on at least some of the series
+	Names map[string]bool
+	// Exact is set if no other labels than Names can exist
+	Exact bool
+	// Dropped maps labels that were removed by an inner expression to a description of it, e.g. sum without (code)
+	Dropped map[string]string
+	// Reducer describes the inner expression that removed all labels but Names, e.g. sum by (job)
+	Reducer string
+}
+
+func newLabelSet(exact bool, names ...string) labelSet {
+	ret := labelSet{Names: make(map[string]bool), Exact: exact, Dropped: make(map[string]string)}
+
+	for _, name := range names {
+		ret.Names[name] = true
+	}
+
+	return ret
+}
+
+func (l labelSet) without(names ...string) labelSet {
+	ret := newLabelSet(l.Exact)
+	ret.Reducer = l.Reducer
+
+	for name := range l.Names {
+		ret.Names[name] = true
+	}
+
+	for name, reason := range l.Dropped {
+		ret.Dropped[name] = reason
+	}
+
+	for _, name := range names {
+		delete(ret.Names, name)
+	}
+
+	return ret
+}
+
+// dropping is without, remembering that the labels were removed by the expression described by reason
+func (l labelSet) dropping(reason string, names ...string) labelSet {
+	ret := l.without(names...)
+
+	for _, name := range names {
+		if name != labels.MetricName {
+			ret.Dropped[name] = reason
+		}
+	}
+
+	return ret
+}
+
+// adding returns the label set with further labels, e.g. those set by label_replace
+func (l labelSet) adding(names ...string) labelSet {
+	ret := l.without()
+
+	for _, name := range names {
+		ret.Names[name] = true
+		delete(ret.Dropped, name)
+	}
+
+	return ret
+}
+
+// removedBy describes the inner expression that removed a label, or returns "" if it wasn't removed
+func (l labelSet) removedBy(name string) string {
+	if reason, ok := l.Dropped[name]; ok {
+		return reason
+	}
+
+	if l.Reducer != "" && !l.Names[name] {
+		return l.Reducer
+	}
+
+	return ""
+}
+
+// labelAnalyzer determines the labels of PromQL expressions, using series metadata
+// from the connected Prometheus server when available
+type labelAnalyzer struct {
+	s     *server
+	ctx   context.Context
+	query *cache.CompiledQuery
+	// series caches the labels of the series matching a selector
+	series map[string]labelSet
+}
+
+// selectorLabels returns the labels of the series a selector matches
+func (a *labelAnalyzer) selectorLabels(vs *promql.VectorSelector) labelSet {
+	// Without metadata, only the labels that are matched by an equality matcher are known to exist
+	static := newLabelSet(false)
+
+	for _, m := range vs.LabelMatchers {
+		if m.Type == labels.MatchEqual && m.Value != "" {
+			static.Names[m.Name] = true
+		}
+	}
+
+	api := a.s.getQueryAPI()
+	if api == nil {
+		return static
+	}
+
+	selector := *vs
+	selector.Offset = 0
+
+	key := selector.String()
+
+	if ret, ok := a.series[key]; ok {
+		return ret
+	}
+
+	// The label names are cached like label values, the analysis runs on every change of the document
+	names := a.s.labelValuesFrom(a.ctx, api, a.s.getPrometheusURL(), a.query, key, "")
+	if len(names) == 0 {
+		a.series[key] = static
+
+		return static
+	}
+
+	ret := newLabelSet(true)
+
+	for _, name := range names {
+		ret.Names[string(name)] = true
+	}
+
+	a.series[key] = ret
+
+	return ret
+}
+
+// exprLabels returns the labels of the series returned by an expression
+// nolint: funlen, gocyclo
+func (a *labelAnalyzer) exprLabels(expr promql.Expr) labelSet {
+	switch n := expr.(type) {
+	case *promql.VectorSelector:
+		return a.selectorLabels(n)
+	case *promql.MatrixSelector:
+		return a.exprLabels(n.VectorSelector)
+	case *promql.SubqueryExpr:
+		return a.exprLabels(n.Expr)
+	case *promql.ParenExpr:
+		return a.exprLabels(n.Expr)
+	case *promql.UnaryExpr:
+		return a.exprLabels(n.Expr).without(labels.MetricName)
+	case *promql.NumberLiteral, *promql.StringLiteral:
+		return newLabelSet(true)
+	case *promql.AggregateExpr:
+		inner := a.exprLabels(n.Expr)
+
+		var ret labelSet
+
+		switch {
+		case n.Without:
+			ret = inner.dropping(aggregationDescription(n), append(n.Grouping, labels.MetricName)...)
+		default:
+			ret = newLabelSet(true)
+			ret.Reducer = aggregationDescription(n)
+
+			for _, name := range n.Grouping {
+				if !inner.Exact || inner.Names[name] {
+					ret.Names[name] = true
+				}
+			}
+		}
+
+		switch n.Op {
+		case promql.TOPK, promql.BOTTOMK:
+			// topk and bottomk return the input series unchanged
+			return inner
+		case promql.COUNT_VALUES:
+			if str, ok := n.Param.(*promql.StringLiteral); ok {
+				ret = ret.adding(str.Val)
+			}
+		}
+
+		return ret
+	case *promql.Call:
+		return a.callLabels(n)
+	case *promql.BinaryExpr:
+		return a.binaryLabels(n)
+	default:
+		return newLabelSet(false)
+	}
+}
+
+func (a *labelAnalyzer) callLabels(n *promql.Call) labelSet {
+	if n.Func.ReturnType != promql.ValueTypeVector {
+		return newLabelSet(true)
+	}
+
+	var inner *labelSet
+
+	for _, arg := range n.Args {
+		if t := arg.Type(); t == promql.ValueTypeVector || t == promql.ValueTypeMatrix {
+			l := a.exprLabels(arg)
+			inner = &l
+
+			break
+		}
+	}
+
+	switch n.Func.Name {
+	case "vector", "time":
+		return newLabelSet(true)
+	case "absent", "absent_over_time":
+		// Depends on the selector, only knowable from the equality matchers
+		return newLabelSet(false)
+	}
+
+	if inner == nil {
+		return newLabelSet(false)
+	}
+
+	ret := inner.without(labels.MetricName)
+
+	switch n.Func.Name {
+	case "label_replace", "label_join":
+		if len(n.Args) > 1 {
+			if str, ok := n.Args[1].(*promql.StringLiteral); ok {
+				ret = ret.adding(str.Val)
+			}
+		}
+	case "histogram_quantile":
+		ret = ret.dropping("histogram_quantile", "le")
+	}
+
+	return ret
+}
+
+func (a *labelAnalyzer) binaryLabels(n *promql.BinaryExpr) labelSet {
+	lhsType, rhsType := n.LHS.Type(), n.RHS.Type()
+
+	switch {
+	case lhsType == promql.ValueTypeScalar && rhsType == promql.ValueTypeScalar:
+		return newLabelSet(true)
+	case lhsType == promql.ValueTypeScalar:
+		return a.exprLabels(n.RHS).without(labels.MetricName)
+	case rhsType == promql.ValueTypeScalar:
+		return a.exprLabels(n.LHS).without(labels.MetricName)
+	}
+
+	matching := n.VectorMatching
+	if matching == nil {
+		matching = &promql.VectorMatching{Card: promql.CardOneToOne}
+	}
+
+	switch n.Op {
+	case promql.LAND, promql.LUNLESS:
+		return a.exprLabels(n.LHS)
+	case promql.LOR:
+		lhs, rhs := a.exprLabels(n.LHS), a.exprLabels(n.RHS)
+
+		// Labels removed on one side may come from the other one, so nothing is known to be removed
+		ret := newLabelSet(lhs.Exact && rhs.Exact)
+
+		for name := range lhs.Names {
+			ret.Names[name] = true
+		}
+
+		for name := range rhs.Names {
+			ret.Names[name] = true
+		}
+
+		return ret
+	}
+
+	many, one := n.LHS, n.RHS
+	if matching.Card == promql.CardOneToMany {
+		many, one = one, many
+	}
+
+	ret := a.exprLabels(many)
+	if !isComparisonOperator(n.Op) || n.ReturnBool {
+		ret = ret.without(labels.MetricName)
+	}
+
+	switch {
+	case matching.Card == promql.CardOneToOne && matching.On:
+		kept := newLabelSet(true)
+		kept.Reducer = fmt.Sprintf("on(%s)", strings.Join(matching.MatchingLabels, ", "))
+
+		for _, name := range matching.MatchingLabels {
+			if !ret.Exact || ret.Names[name] {
+				kept.Names[name] = true
+			}
+		}
+
+		return kept
+	case matching.Card == promql.CardOneToOne:
+		return ret.dropping(fmt.Sprintf("ignoring(%s)", strings.Join(matching.MatchingLabels, ", ")), matching.MatchingLabels...)
+	}
+
+	oneLabels := a.exprLabels(one)
+
+	for _, name := range matching.Include {
+		if !oneLabels.Exact || oneLabels.Names[name] {
+			ret = ret.adding(name)
+		} else {
+			delete(ret.Names, name)
+		}
+	}
+
+	return ret
+}
+
+// aggregationDescription describes an aggregation in messages, e.g. sum by (job)
+func aggregationDescription(n *promql.AggregateExpr) string {
+	switch {
+	case len(n.Grouping) == 0 && !n.Without:
+		return n.Op.String()
+	case n.Without:
+		return fmt.Sprintf("%s without (%s)", n.Op, strings.Join(n.Grouping, ", "))
+	default:
+		return fmt.Sprintf("%s by (%s)", n.Op, strings.Join(n.Grouping, ", "))
+	}
+}
+
+func isComparisonOperator(op promql.ItemType) bool {
+	switch op {
+	case promql.EQL, promql.NEQ, promql.LTE, promql.LSS, promql.GTE, promql.GTR:
+		return true
+	default:
+		return false
+	}
+}
+
+// groupModifierLabels returns the labels listed in the group_left or group_right modifier of a binary expression
+func groupModifierLabels(q *cache.CompiledQuery, n *promql.BinaryExpr) []labelReference {
+	return modifierLabels(q, n, promql.GROUP_LEFT, promql.GROUP_RIGHT)
+}
+
+// modifierLabels returns the labels listed in the modifiers of a binary expression with one of the given keywords
+func modifierLabels(q *cache.CompiledQuery, n *promql.BinaryExpr, keywords ...promql.ItemType) []labelReference {
+	start, end := n.LHS.PositionRange().End, n.RHS.PositionRange().Start
+	if start > end || int(end) > len(q.Content) {
+		return nil
+	}
+
+	var ret []labelReference
+
+	l := promql.Lex(q.Content[start:end])
+
+	inModifier := false
+
+	for {
+		var item promql.Item
+
+		l.NextItem(&item)
+
+		switch item.Typ {
+		case promql.EOF, promql.ERROR:
+			return ret
+		case promql.RIGHT_PAREN:
+			inModifier = false
+		case promql.IDENTIFIER:
+			if inModifier {
+				item.Pos += start
+				ret = append(ret, itemLabelReference(q, item))
+			}
+		default:
+			for _, keyword := range keywords {
+				if item.Typ == keyword {
+					inModifier = true
+				}
+			}
+		}
+	}
+}
+
+// groupModifierDiagnostics checks the labels listed in group_left and group_right modifiers.
+// They are copied from the "one" side of the match, so they should exist there, and
+// they replace the labels of the same name on the "many" side.
+// nolint: funlen
+func (s *server) groupModifierDiagnostics(doc *cache.DocumentHandle) []protocol.Diagnostic {
+	queries, err := doc.GetQueries()
+	if err != nil {
+		return nil
+	}
+
+	var ret []protocol.Diagnostic
+
+	for _, q := range queries {
+		if q.Ast == nil {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(s.lifetime, 5*time.Second)
+
+		a := &labelAnalyzer{s: s, ctx: ctx, query: q, series: make(map[string]labelSet)}
+
+		promql.Inspect(q.Ast, func(node promql.Node, _ []promql.Node) error {
+			n, ok := node.(*promql.BinaryExpr)
+			if !ok || n.VectorMatching == nil || len(n.VectorMatching.Include) == 0 {
+				return nil
+			}
+
+			modifier := "group_left"
+			many, one := n.LHS, n.RHS
+
+			switch n.VectorMatching.Card {
+			case promql.CardManyToOne:
+			case promql.CardOneToMany:
+				modifier = "group_right"
+				many, one = one, many
+			default:
+				return nil
+			}
+
+			manyLabels, oneLabels := a.exprLabels(many), a.exprLabels(one)
+
+			for _, ref := range groupModifierLabels(q, n) {
+				var msg, code string
+
+				switch {
+				case oneLabels.Exact && !oneLabels.Names[ref.Name]:
+					code = codeGroupLabelMissing
+					msg = fmt.Sprintf("label %q does not exist on the \"one\" side of %s, which it is copied from; the result will not have this label",
+						ref.Name, modifier)
+				case manyLabels.Names[ref.Name]:
+					code = codeGroupLabelCollision
+					msg = fmt.Sprintf("label %q exists on both sides of %s; its value on the \"many\" side is replaced by the one from the \"one\" side",
+						ref.Name, modifier)
+				default:
+					continue
+				}
+
+				rng, err := tokenRange(doc, ref.Pos, ref.End)
+				if err != nil {
+					continue
+				}
+
+				ret = append(ret, protocol.Diagnostic{
+					Range:    rng,
+					Severity: 2, // Warning
+					Code:     code,
+					Source:   "promql-lsp",
+					Message:  msg,
+				})
+			}
+
+			return nil
+		})
+
+		cancel()
+	}
+
+	return ret
+}
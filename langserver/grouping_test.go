@@ -0,0 +1,60 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+// TestGroupModifierDiagnostics checks the static analysis of group_left and group_right label lists
+func TestGroupModifierDiagnostics(*testing.T) {
+	h, err := NewHeadlessServer(context.Background(), &Config{}, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	tests := []struct {
+		query string
+		code  string
+	}{
+		{`foo * on (instance) group_left (team) sum by (instance, team) (owners)`, ""},
+		{`foo * on (instance) group_left (team) owners`, ""},
+		{`foo * on (instance) group_left (team) sum by (instance) (owners)`, codeGroupLabelMissing},
+		{`foo{team="a"} * on (instance) group_left (team) owners`, codeGroupLabelCollision},
+		{`sum by (instance) (owners) * on (instance) group_right (version) build_info`, codeGroupLabelMissing},
+		{`foo * on (job) group_left (region) label_replace(sum by (job) (x), "region", "eu", "", "")`, ""},
+	}
+
+	for i, test := range tests {
+		report, err := h.AnalyzeDocument(fmt.Sprintf("grouping_%d.promql", i), "promql", test.query)
+		if err != nil {
+			panic(err)
+		}
+
+		code := ""
+
+		for _, d := range report.Diagnostics {
+			if d.Code == codeGroupLabelMissing || d.Code == codeGroupLabelCollision {
+				code = d.Code.(string)
+			}
+		}
+
+		if code != test.code {
+			panic(fmt.Sprintf("Expected %q for %q, got %q: %v", test.code, test.query, code, report.Diagnostics))
+		}
+	}
+}
@@ -0,0 +1,442 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/prometheus/prometheus/promql"
+
+	"github.com/prometheus-community/promql-langserver/langserver/cache"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+	"github.com/prometheus/common/model"
+)
+
+// headlessClient implements the protocol.Client interface for servers that are
+// not connected to a language client. Messages are written to a log writer,
+// requests are answered with empty results.
+type headlessClient struct {
+	log io.Writer
+	mu  sync.Mutex
+}
+
+func (c *headlessClient) logf(format string, args ...interface{}) {
+	if c.log == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprintf(c.log, format, args...)
+}
+
+// ShowMessage is required by the protocol.Client interface
+func (c *headlessClient) ShowMessage(_ context.Context, params *protocol.ShowMessageParams) error {
+	c.logf("%s\n", params.Message)
+	return nil
+}
+
+// LogMessage is required by the protocol.Client interface
+func (c *headlessClient) LogMessage(_ context.Context, params *protocol.LogMessageParams) error {
+	if params.Type == protocol.Error {
+		c.logf("%s\n", params.Message)
+	}
+
+	return nil
+}
+
+// Event is required by the protocol.Client interface
+func (c *headlessClient) Event(_ context.Context, _ *interface{}) error {
+	return nil
+}
+
+// PublishDiagnostics is required by the protocol.Client interface
+func (c *headlessClient) PublishDiagnostics(_ context.Context, _ *protocol.PublishDiagnosticsParams) error {
+	return nil
+}
+
+// WorkspaceFolders is required by the protocol.Client interface
+func (c *headlessClient) WorkspaceFolders(_ context.Context) ([]protocol.WorkspaceFolder, error) {
+	return nil, nil
+}
+
+// Configuration is required by the protocol.Client interface
+func (c *headlessClient) Configuration(_ context.Context, _ *protocol.ParamConfiguration) ([]interface{}, error) {
+	return nil, nil
+}
+
+// RegisterCapability is required by the protocol.Client interface
+func (c *headlessClient) RegisterCapability(_ context.Context, _ *protocol.RegistrationParams) error {
+	return nil
+}
+
+// UnregisterCapability is required by the protocol.Client interface
+func (c *headlessClient) UnregisterCapability(_ context.Context, _ *protocol.UnregistrationParams) error {
+	return nil
+}
+
+// ShowMessageRequest is required by the protocol.Client interface
+func (c *headlessClient) ShowMessageRequest(_ context.Context, params *protocol.ShowMessageRequestParams) (*protocol.MessageActionItem, error) {
+	c.logf("%s\n", params.Message)
+	return nil, nil
+}
+
+// ApplyEdit is required by the protocol.Client interface
+func (c *headlessClient) ApplyEdit(_ context.Context, _ *protocol.ApplyWorkspaceEditParams) (*protocol.ApplyWorkspaceEditResponse, error) {
+	return &protocol.ApplyWorkspaceEditResponse{Applied: false}, nil
+}
+
+// HeadlessServer is a language server instance that is not connected to a language client.
+// It allows other tools, e.g. command line utilities, to use the analyzers of the language server.
+type HeadlessServer struct {
+	server *server
+}
+
+// DocumentReport contains the results of analyzing a document
+type DocumentReport struct {
+	URI         string
+	Diagnostics []protocol.Diagnostic
+	// The number of queries found in the document
+	Queries int
+	// Fixes are the changes that resolve some of the diagnostics automatically
+	Fixes []Fix
+}
+
+// Fix is a suggested change that resolves a diagnostic.
+// It contains the same edits as the corresponding quick fix code action.
+type Fix struct {
+	// Rule identifies the check the fix belongs to, see QuickFixRules
+	Rule       string
+	Title      string
+	Diagnostic protocol.Diagnostic
+	Edits      []FixEdit
+}
+
+// FixEdit replaces the bytes between the offsets Start and End of a document with NewText
+type FixEdit struct {
+	Start   int
+	End     int
+	NewText string
+}
+
+// NewHeadlessServer creates and initializes a HeadlessServer.
+// Messages that would normally be shown to the user are written to log, which may be nil.
+func NewHeadlessServer(ctx context.Context, config *Config, log io.Writer) (HeadlessServer, error) {
+	return newHeadlessServer(ctx, config, log, &protocol.ParamInitialize{})
+}
+
+// newHeadlessServer creates a HeadlessServer initialized with params, e.g. with workspace folders.
+// The rule files of the workspace folders are indexed before it returns.
+func newHeadlessServer(ctx context.Context, config *Config, log io.Writer, params *protocol.ParamInitialize) (HeadlessServer, error) {
+	s := &server{
+		client: &headlessClient{log: log},
+		config: config,
+	}
+
+	s.lifetime, s.exit = context.WithCancel(ctx)
+
+	if _, err := s.Initialize(ctx, params); err != nil {
+		return HeadlessServer{}, err
+	}
+
+	if err := s.Initialized(ctx, &protocol.InitializedParams{}); err != nil {
+		return HeadlessServer{}, err
+	}
+
+	if !config.DemoMode {
+		s.indexWorkspace()
+	}
+
+	return HeadlessServer{s}, nil
+}
+
+// NewSession creates a HeadlessServer with its own documents that reuses the connections of h, i.e. the
+// Prometheus servers with what was found out about them when connecting, the metric catalog and the evaluation time.
+// Unlike NewHeadlessServer, it doesn't send any requests to Prometheus, so it is cheap enough to be created for every
+// request of a REST API. The session ends when ctx is done or it is closed.
+func (h HeadlessServer) NewSession(ctx context.Context) (HeadlessServer, error) {
+	base := h.server
+
+	s := &server{
+		client: base.client,
+		config: base.getConfig(),
+	}
+
+	s.lifetime, s.exit = context.WithCancel(ctx)
+
+	if _, err := s.Initialize(ctx, &protocol.ParamInitialize{}); err != nil {
+		s.exit()
+		return HeadlessServer{}, err
+	}
+
+	base.prometheusMu.Lock()
+	s.prometheus, s.PrometheusURL = base.prometheus, base.PrometheusURL
+	s.prometheusRetention, s.prometheusLimits, s.prometheusMode = base.prometheusRetention, base.prometheusLimits, base.prometheusMode
+	base.prometheusMu.Unlock()
+
+	// The endpoints are replaced as a whole when they are reconfigured, so they can be shared
+	base.endpointsMu.Lock()
+	s.endpoints, s.endpointMapping = base.endpoints, base.endpointMapping
+	base.endpointsMu.Unlock()
+
+	base.catalogMu.RLock()
+	s.catalog = base.catalog
+	base.catalogMu.RUnlock()
+
+	base.evaluationTimeMu.RLock()
+	s.evaluationTime = base.evaluationTime
+	base.evaluationTimeMu.RUnlock()
+
+	base.metadataMu.RLock()
+	s.metadata = base.metadata
+	base.metadataMu.RUnlock()
+
+	base.statusMu.Lock()
+	s.datasource, s.datasourceURL, s.limitation = base.datasource, base.datasourceURL, base.limitation
+	base.statusMu.Unlock()
+
+	s.stateMu.Lock()
+	s.state = serverInitialized
+	s.stateMu.Unlock()
+
+	return HeadlessServer{s}, nil
+}
+
+// Connected returns whether the server is connected to the Prometheus server of the prometheus_url option
+func (h HeadlessServer) Connected() bool {
+	return h.server.getPrometheusURL() != ""
+}
+
+// AnalyzeDocument adds a document to the server and returns the results of analyzing it.
+// The document stays open until it is closed with CloseDocument, so it can be
+// referenced by documents that are analyzed later.
+func (h HeadlessServer) AnalyzeDocument(uri string, languageID string, content string) (*DocumentReport, error) {
+	if err := h.AddDocument(uri, languageID, content); err != nil {
+		return nil, err
+	}
+
+	return h.GetReport(uri)
+}
+
+// AddDocument adds a document to the server without reporting on it. This allows
+// to add several related documents before analyzing them.
+func (h HeadlessServer) AddDocument(uri string, languageID string, content string) error {
+	_, err := h.server.cache.AddDocument(h.server.lifetime, &protocol.TextDocumentItem{
+		URI:        uri,
+		LanguageID: languageID,
+		Text:       content,
+	})
+
+	return err
+}
+
+// GetReport returns the results of analyzing a document that has been added to the server
+func (h HeadlessServer) GetReport(uri string) (*DocumentReport, error) {
+	doc, err := h.server.cache.GetDocument(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	diagnostics, err := h.server.getDiagnostics(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	queries, err := doc.GetQueries()
+	if err != nil {
+		return nil, err
+	}
+
+	return &DocumentReport{
+		URI:         uri,
+		Diagnostics: diagnostics,
+		Queries:     len(queries),
+		Fixes:       h.getFixes(doc),
+	}, nil
+}
+
+func (h HeadlessServer) getFixes(doc *cache.DocumentHandle) []Fix {
+	var ret []Fix
+
+	for _, quickFix := range h.server.getQuickFixes(doc) {
+		fix := Fix{
+			Rule:       quickFix.Rule,
+			Title:      quickFix.Title,
+			Diagnostic: quickFix.Diagnostic,
+		}
+
+		for _, e := range quickFix.Edits {
+			pos, end, newText := e.Pos, e.End, e.NewText
+			escapeJSONEdit(doc, &pos, &end, &newText)
+
+			fix.Edits = append(fix.Edits, FixEdit{
+				Start:   doc.ByteOffset(pos),
+				End:     doc.ByteOffset(end),
+				NewText: newText,
+			})
+		}
+
+		ret = append(ret, fix)
+	}
+
+	return ret
+}
+
+// FunctionDoc is the documentation of a PromQL function
+type FunctionDoc struct {
+	Name string `json:"name"`
+	// Docs is the markdown documentation shown in hovers
+	Docs string `json:"docs"`
+}
+
+// MetricNames returns the names of the metrics known to the Prometheus server,
+// or an empty list if no Prometheus server is connected
+func (h HeadlessServer) MetricNames(ctx context.Context) ([]string, error) {
+	return h.LabelValues(ctx, "__name__")
+}
+
+// LabelValues returns the values of a label on all series of the Prometheus server,
+// or an empty list if no Prometheus server is connected
+func (h HeadlessServer) LabelValues(ctx context.Context, label string) ([]string, error) {
+	ret := []string{}
+
+	api := h.server.getPrometheus()
+	if api == nil {
+		return ret, nil
+	}
+
+	values, _, err := api.LabelValues(ctx, label)
+	if err != nil {
+		h.server.reportBackendError(err)
+		return nil, err
+	}
+
+	for _, value := range values {
+		ret = append(ret, string(value))
+	}
+
+	return ret, nil
+}
+
+// FunctionDocs returns the documentation of all PromQL functions, sorted by name
+func (h HeadlessServer) FunctionDocs() []FunctionDoc {
+	ret := make([]FunctionDoc, 0, len(promql.Functions))
+
+	for name := range promql.Functions {
+		ret = append(ret, FunctionDoc{Name: name, Docs: funcDocStrings(name)})
+	}
+
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+
+	return ret
+}
+
+// positionParams converts a byte offset into a document that has been added to the server to the
+// parameters of LSP requests
+func (h HeadlessServer) positionParams(uri string, offset int) (protocol.TextDocumentPositionParams, error) {
+	doc, err := h.server.cache.GetDocument(uri)
+	if err != nil {
+		return protocol.TextDocumentPositionParams{}, err
+	}
+
+	pos, err := doc.OffsetToPos(offset)
+	if err != nil {
+		return protocol.TextDocumentPositionParams{}, err
+	}
+
+	position, err := doc.PosToProtocolPosition(pos)
+
+	return protocol.TextDocumentPositionParams{
+		TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+		Position:     position,
+	}, err
+}
+
+// Completion returns the completions at a byte offset of a document that has been added to the server
+func (h HeadlessServer) Completion(uri string, offset int) (*protocol.CompletionList, error) {
+	params, err := h.positionParams(uri, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	ret, err := h.server.Completion(h.server.lifetime, &protocol.CompletionParams{TextDocumentPositionParams: params})
+	if ret == nil && err == nil {
+		ret = &protocol.CompletionList{}
+	}
+
+	if ret != nil && ret.Items == nil {
+		ret.Items = []protocol.CompletionItem{}
+	}
+
+	return ret, err
+}
+
+// Hover returns the hover at a byte offset of a document that has been added to the server,
+// or nil if there is nothing to show
+func (h HeadlessServer) Hover(uri string, offset int) (*protocol.Hover, error) {
+	params, err := h.positionParams(uri, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return h.server.Hover(h.server.lifetime, &protocol.HoverParams{TextDocumentPositionParams: params})
+}
+
+// UseQueryHistory makes the queries run with Query persist in the history of a workspace, like the queries
+// run by the promql.runQuery command. name identifies the workspace, e.g. "repl" for the queries of the REPL.
+func (h HeadlessServer) UseQueryHistory(name string) {
+	config := h.server.getConfig()
+	h.server.history = newQueryHistory(name, !config.DemoMode && !config.ReadOnly)
+}
+
+// Query evaluates a query on the Prometheus server at the configured evaluation time and records it in the history
+func (h HeadlessServer) Query(query string) (model.Value, error) {
+	if h.server.queriesDisabled() {
+		return nil, errors.New("query execution is disabled in demo and read-only mode")
+	}
+
+	result, err := h.server.executeQuery(h.server.lifetime, &cache.CompiledQuery{Content: query}, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return result.Result, nil
+}
+
+// QueryHistory returns the queries that have been run, newest first
+func (h HeadlessServer) QueryHistory() []string {
+	var ret []string
+
+	for _, entry := range h.server.history.list() {
+		ret = append(ret, entry.Query)
+	}
+
+	return ret
+}
+
+// CloseDocument removes a document from the server
+func (h HeadlessServer) CloseDocument(uri string) error {
+	return h.server.cache.RemoveDocument(uri)
+}
+
+// Close stops the server
+func (h HeadlessServer) Close() {
+	h.server.exit()
+}
@@ -0,0 +1,92 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestHeadlessRequests checks that completion and hover are answered for byte offsets into a document
+func TestHeadlessRequests(*testing.T) {
+	h, err := NewHeadlessServer(context.Background(), &Config{}, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	const query = `sum(rate(up[5m]))`
+
+	if err := h.AddDocument("query.promql", "promql", query); err != nil {
+		panic(err)
+	}
+
+	completions, err := h.Completion("query.promql", strings.Index(query, "rate")+2)
+	if err != nil {
+		panic(err)
+	}
+
+	found := false
+
+	for _, item := range completions.Items {
+		found = found || item.Label == "rate"
+	}
+
+	if !found {
+		panic(fmt.Sprintf("expected rate among the completions, got %v", completions.Items))
+	}
+
+	hover, err := h.Hover("query.promql", strings.Index(query, "rate")+1)
+	if err != nil || hover == nil || !strings.Contains(hover.Contents.Value, "rate") {
+		panic(fmt.Sprintf("expected the documentation of rate, got %v, %v", hover, err))
+	}
+
+	if _, err := h.Completion("query.promql", len(query)+1); err == nil {
+		panic("expected offsets outside of the document to be rejected")
+	}
+}
+
+// TestHeadlessQuery checks that queries run by headless servers are recorded in the history
+func TestHeadlessQuery(*testing.T) {
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.URL.Path != "/api/v1/query" {
+			fmt.Fprint(w, `{"status":"success","data":{}}`)
+			return
+		}
+
+		fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{"job":"api"},"value":[0,"1"]}]}}`)
+	}))
+	defer prom.Close()
+
+	h, err := NewHeadlessServer(context.Background(), &Config{PrometheusURL: prom.URL}, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	value, err := h.Query("sum by (job) (up)")
+	if err != nil || !strings.Contains(value.String(), `job="api"`) {
+		panic(fmt.Sprintf("expected the result of the query, got %v, %v", value, err))
+	}
+
+	if history := h.QueryHistory(); len(history) != 1 || history[0] != "sum by (job) (up)" {
+		panic(fmt.Sprintf("expected the query to be recorded, got %v", history))
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"fmt"
+	"go/token"
+	"strings"
+
+	"github.com/prometheus-community/promql-langserver/langserver/cache"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+	"github.com/prometheus/prometheus/promql"
+)
+
+// histogramProblem is a mistake in the usage of histogram_quantile
+type histogramProblem struct {
+	node promql.Node
+	code string
+	msg  string
+}
+
+// histogramLeDropped returns the aggregation inside the bucket argument of histogram_quantile
+// that removes the le label, or nil if there is none
+func histogramLeDropped(expr promql.Expr) *promql.AggregateExpr {
+	agg, ok := unparen(expr).(*promql.AggregateExpr)
+	if !ok {
+		return nil
+	}
+
+	hasLe := false
+
+	for _, label := range agg.Grouping {
+		if label == "le" {
+			hasLe = true
+		}
+	}
+
+	if hasLe == agg.Without {
+		return agg
+	}
+
+	return histogramLeDropped(agg.Expr)
+}
+
+// histogramBucketProblems checks that the bucket argument of histogram_quantile selects
+// the rate of the buckets of a histogram
+func histogramBucketProblems(expr promql.Expr) []histogramProblem {
+	var ret []histogramProblem
+
+	promql.Inspect(expr, func(node promql.Node, path []promql.Node) error {
+		vs, ok := node.(*promql.VectorSelector)
+		if !ok || vs.Name == "" {
+			return nil
+		}
+
+		if !strings.Contains(vs.Name, "_bucket") {
+			ret = append(ret, histogramProblem{
+				node: vs,
+				msg:  fmt.Sprintf("histogram_quantile expects the buckets of a histogram, but %s is not a _bucket metric", vs.Name),
+			})
+
+			return nil
+		}
+
+		// Recording rules usually store the rate already
+		if strings.Contains(vs.Name, ":") {
+			return nil
+		}
+
+		for _, p := range path {
+			if call, ok := p.(*promql.Call); ok {
+				switch call.Func.Name {
+				case "rate", "irate", "increase":
+					return nil
+				}
+			}
+		}
+
+		ret = append(ret, histogramProblem{
+			node: vs,
+			msg: fmt.Sprintf("the buckets of %s are counters that only ever increase; apply rate() before computing quantiles",
+				vs.Name),
+		})
+
+		return nil
+	})
+
+	return ret
+}
+
+// histogramProblems checks a call of histogram_quantile for the most common mistakes
+func histogramProblems(call *promql.Call) []histogramProblem {
+	if len(call.Args) != 2 {
+		return nil
+	}
+
+	var ret []histogramProblem
+
+	if lit, ok := unparen(call.Args[0]).(*promql.NumberLiteral); ok && (lit.Val < 0 || lit.Val > 1) {
+		ret = append(ret, histogramProblem{
+			node: call.Args[0],
+			code: codeHistogramQuantile,
+			msg:  fmt.Sprintf("quantile %v is outside of [0, 1], so histogram_quantile returns an infinite value", lit.Val),
+		})
+	}
+
+	if agg := histogramLeDropped(call.Args[1]); agg != nil {
+		ret = append(ret, histogramProblem{
+			node: agg,
+			code: codeHistogramLe,
+			msg:  "aggregation removes the le label, which histogram_quantile needs to find the buckets; aggregate by (le) instead",
+		})
+	}
+
+	for _, p := range histogramBucketProblems(call.Args[1]) {
+		p.code = codeHistogramBuckets
+		ret = append(ret, p)
+	}
+
+	return ret
+}
+
+// histogramDiagnostics warns about the most common mistakes when computing quantiles from histograms
+func histogramDiagnostics(doc *cache.DocumentHandle) []protocol.Diagnostic {
+	queries, err := doc.GetQueries()
+	if err != nil {
+		return nil
+	}
+
+	var ret []protocol.Diagnostic
+
+	for _, q := range queries {
+		if q.Ast == nil {
+			continue
+		}
+
+		promql.Inspect(q.Ast, func(node promql.Node, _ []promql.Node) error {
+			call, ok := node.(*promql.Call)
+			if !ok || call.Func.Name != "histogram_quantile" {
+				return nil
+			}
+
+			for _, p := range histogramProblems(call) {
+				posRange := p.node.PositionRange()
+
+				rng, err := tokenRange(doc, q.Pos+token.Pos(posRange.Start), q.Pos+token.Pos(posRange.End))
+				if err != nil {
+					continue
+				}
+
+				ret = append(ret, protocol.Diagnostic{
+					Range:    rng,
+					Severity: 2, // Warning
+					Code:     p.code,
+					Source:   "promql-lsp",
+					Message:  p.msg,
+				})
+			}
+
+			return nil
+		})
+	}
+
+	return ret
+}
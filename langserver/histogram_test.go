@@ -0,0 +1,65 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+// TestHistogramDiagnostics checks the lints for histogram_quantile
+func TestHistogramDiagnostics(*testing.T) {
+	h, err := NewHeadlessServer(context.Background(), &Config{}, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	tests := []struct {
+		query string
+		code  string
+	}{
+		{`histogram_quantile(0.9, sum by (le, job) (rate(http_request_duration_seconds_bucket[5m])))`, ""},
+		{`histogram_quantile(0.9, rate(http_request_duration_seconds_bucket[5m]))`, ""},
+		{`histogram_quantile(0.9, sum without (instance) (rate(http_request_duration_seconds_bucket[5m])))`, ""},
+		{`histogram_quantile(0.9, sum(rate(http_request_duration_seconds_bucket[5m])))`, codeHistogramLe},
+		{`histogram_quantile(0.9, sum without (le) (rate(http_request_duration_seconds_bucket[5m])))`, codeHistogramLe},
+		{`histogram_quantile(0.9, sum by (le) (http_request_duration_seconds_bucket))`, codeHistogramBuckets},
+		{`histogram_quantile(0.9, sum by (le) (rate(http_request_duration_seconds_count[5m])))`, codeHistogramBuckets},
+		{`histogram_quantile(0.9, job:http_request_duration_seconds_bucket:rate5m)`, ""},
+		{`histogram_quantile(99, sum by (le) (rate(http_request_duration_seconds_bucket[5m])))`, codeHistogramQuantile},
+		{`histogram_quantile(-0.5, sum by (le) (rate(http_request_duration_seconds_bucket[5m])))`, codeHistogramQuantile},
+	}
+
+	for i, test := range tests {
+		report, err := h.AnalyzeDocument(fmt.Sprintf("histogram_%d.promql", i), "promql", test.query)
+		if err != nil {
+			panic(err)
+		}
+
+		code := ""
+
+		for _, d := range report.Diagnostics {
+			switch d.Code {
+			case codeHistogramQuantile, codeHistogramLe, codeHistogramBuckets:
+				code = d.Code.(string)
+			}
+		}
+
+		if code != test.code {
+			panic(fmt.Sprintf("Expected %q for %q, got %q: %v", test.code, test.query, code, report.Diagnostics))
+		}
+	}
+}
@@ -0,0 +1,268 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus-community/promql-langserver/langserver/cache"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/jsonrpc2"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+	"github.com/prometheus/common/model"
+)
+
+// The commands for running queries and recalling them from the history
+const (
+	commandRunQuery           = "promql.runQuery"
+	commandQueryHistory       = "promql.queryHistory"
+	commandRerunQuery         = "promql.rerunQuery"
+	commandDeleteQueryHistory = "promql.deleteQueryHistory"
+)
+
+// maxQueryHistory is the number of queries kept in the history of a workspace
+const maxQueryHistory = 100
+
+// queryHistoryEntry is a query run with the promql.runQuery or promql.rerunQuery command
+type queryHistoryEntry struct {
+	ID    string `json:"id"`
+	Query string `json:"query"`
+	// Document is the document the query was taken from, if any
+	Document protocol.DocumentURI `json:"document,omitempty"`
+	// EvaluationTime is the time the query was evaluated at, Executed the time it was run
+	EvaluationTime time.Time `json:"evaluationTime"`
+	Executed       time.Time `json:"executed"`
+	// Error is set if the query failed
+	Error string `json:"error,omitempty"`
+}
+
+// queryHistory holds the queries run in a workspace, newest first.
+// Like metricFrequencies, it is persisted in the cache directory of the user.
+type queryHistory struct {
+	// path is the file the history is persisted in, it is kept in memory only if it is empty
+	path    string
+	loaded  bool
+	entries []queryHistoryEntry
+	mu      sync.Mutex
+}
+
+// historyFile is the format the history is persisted in
+type historyFile struct {
+	Queries []queryHistoryEntry `json:"queries"`
+}
+
+// newQueryHistory creates the query history of a workspace. Like metric frequencies,
+// it is read lazily. Servers without a workspace, e.g. headless ones, don't persist the history.
+func newQueryHistory(workspace string, persist bool) *queryHistory {
+	ret := &queryHistory{}
+
+	if persist && workspace != "" {
+		ret.path = workspaceCacheFile("history", workspace)
+	}
+
+	return ret
+}
+
+// load reads the persisted history, the caller must hold the lock
+func (h *queryHistory) load() {
+	if h.loaded || h.path == "" {
+		return
+	}
+
+	h.loaded = true
+
+	data, err := ioutil.ReadFile(h.path)
+	if err != nil {
+		return
+	}
+
+	var file historyFile
+
+	if err := json.Unmarshal(data, &file); err == nil {
+		h.entries = file.Queries
+	}
+}
+
+// save persists the history, the caller must hold the lock
+func (h *queryHistory) save() error {
+	if h.path == "" {
+		return nil
+	}
+
+	return writeCacheFile(h.path, &historyFile{Queries: h.entries})
+}
+
+// list returns the history, newest first
+func (h *queryHistory) list() []queryHistoryEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.load()
+
+	return append([]queryHistoryEntry{}, h.entries...)
+}
+
+// get returns the entry with the given ID
+func (h *queryHistory) get(id string) (queryHistoryEntry, bool) {
+	for _, entry := range h.list() {
+		if entry.ID == id {
+			return entry, true
+		}
+	}
+
+	return queryHistoryEntry{}, false
+}
+
+// add records a query, dropping the oldest ones if the history is full
+func (h *queryHistory) add(entry queryHistoryEntry) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.load()
+
+	h.entries = append([]queryHistoryEntry{entry}, h.entries...)
+	if len(h.entries) > maxQueryHistory {
+		h.entries = h.entries[:maxQueryHistory]
+	}
+
+	return h.save()
+}
+
+// delete removes an entry from the history, or clears the history if id is empty
+func (h *queryHistory) delete(id string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.load()
+
+	var kept []queryHistoryEntry
+
+	for _, entry := range h.entries {
+		if id != "" && entry.ID != id {
+			kept = append(kept, entry)
+		}
+	}
+
+	h.entries = kept
+
+	return h.save()
+}
+
+// runQueryParams are the parameters of the promql.runQuery command. Either Query or
+// a position inside a query of a document is given.
+type runQueryParams struct {
+	Query        string                          `json:"query,omitempty"`
+	TextDocument protocol.TextDocumentIdentifier `json:"textDocument"`
+	Position     protocol.Position               `json:"position"`
+}
+
+// historyEntryParams identify an entry of the query history
+type historyEntryParams struct {
+	ID string `json:"id"`
+}
+
+// runQueryResult is the result of the promql.runQuery and promql.rerunQuery commands
+type runQueryResult struct {
+	Entry      queryHistoryEntry `json:"entry"`
+	ResultType string            `json:"resultType,omitempty"`
+	Result     model.Value       `json:"result,omitempty"`
+}
+
+// runQuery implements the promql.runQuery command
+func (s *server) runQuery(ctx context.Context, params *runQueryParams) (*runQueryResult, error) {
+	if params.Query != "" {
+		return s.executeQuery(ctx, &cache.CompiledQuery{Content: params.Query}, "")
+	}
+
+	doc, err := s.cache.GetDocument(params.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+
+	pos, err := doc.ProtocolPositionToTokenPos(params.Position)
+	if err != nil {
+		return nil, err
+	}
+
+	query, err := doc.GetQuery(pos)
+	if err != nil {
+		return nil, jsonrpc2.NewErrorf(jsonrpc2.CodeInvalidParams, "there is no query at this position")
+	}
+
+	return s.executeQuery(ctx, query, params.TextDocument.URI)
+}
+
+// rerunQuery implements the promql.rerunQuery command. The query is evaluated at the
+// current evaluation time, not the one it was run at before.
+func (s *server) rerunQuery(ctx context.Context, params *historyEntryParams) (*runQueryResult, error) {
+	entry, ok := s.history.get(params.ID)
+	if !ok {
+		return nil, jsonrpc2.NewErrorf(jsonrpc2.CodeInvalidParams, "there is no query %q in the history", params.ID)
+	}
+
+	return s.executeQuery(ctx, &cache.CompiledQuery{Content: entry.Query}, entry.Document)
+}
+
+// executeQuery evaluates a query on the Prometheus server and records it in the history,
+// even if it fails
+func (s *server) executeQuery(ctx context.Context, query *cache.CompiledQuery, document protocol.DocumentURI) (*runQueryResult, error) {
+	api := s.getQueryAPIFor(document)
+	if api == nil {
+		if limitation := s.queryAPILimitationFor(document); limitation != "" {
+			return nil, jsonrpc2.NewErrorf(jsonrpc2.CodeInvalidRequest, "%s", limitation)
+		}
+
+		return nil, jsonrpc2.NewErrorf(jsonrpc2.CodeInvalidRequest, "no Prometheus server configured")
+	}
+
+	now := time.Now()
+
+	entry := queryHistoryEntry{
+		ID:             strconv.FormatInt(now.UnixNano(), 36),
+		Query:          strings.TrimSpace(query.Content),
+		Document:       document,
+		EvaluationTime: s.evaluationTimeOrNow(query),
+		Executed:       now,
+	}
+
+	value, _, err := api.Query(ctx, entry.Query, entry.EvaluationTime)
+	if err != nil {
+		s.reportBackendError(err)
+
+		entry.Error = err.Error()
+	}
+
+	if err := s.history.add(entry); err != nil {
+		// nolint: errcheck
+		s.client.LogMessage(s.lifetime, &protocol.LogMessageParams{
+			Type:    protocol.Error,
+			Message: "Failed to save the query history: " + err.Error(),
+		})
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &runQueryResult{
+		Entry:      entry,
+		ResultType: value.Type().String(),
+		Result:     value,
+	}, nil
+}
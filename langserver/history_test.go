@@ -0,0 +1,116 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// TestQueryHistory checks that queries run by commands can be listed, rerun and deleted
+func TestQueryHistory(*testing.T) {
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.URL.Path {
+		case "/api/v1/query":
+			fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{"job":"a"},"value":[1583020800,"1"]}]}}`)
+		default:
+			fmt.Fprint(w, `{"status":"success","data":{}}`)
+		}
+	}))
+	defer prom.Close()
+
+	h, err := NewHeadlessServer(context.Background(), &Config{PrometheusURL: prom.URL}, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	if err := h.AddDocument("query.promql", "promql", "up == 1"); err != nil {
+		panic(err)
+	}
+
+	run := func(command string, argument interface{}) interface{} {
+		ret, err := h.server.ExecuteCommand(context.Background(), &protocol.ExecuteCommandParams{
+			Command:   command,
+			Arguments: []interface{}{argument},
+		})
+		if err != nil {
+			panic(err)
+		}
+
+		return ret
+	}
+
+	result := run(commandRunQuery, map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": "query.promql"},
+		"position":     map[string]interface{}{"line": 0, "character": 1},
+	}).(*runQueryResult)
+
+	if result.Entry.Query != "up == 1" || result.ResultType != "vector" {
+		panic(fmt.Sprintf("unexpected result %v", result))
+	}
+
+	run(commandRerunQuery, map[string]interface{}{"id": result.Entry.ID})
+
+	history := run(commandQueryHistory, struct{}{}).([]queryHistoryEntry)
+	if len(history) != 2 || history[0].Query != "up == 1" || history[0].Document != "query.promql" {
+		panic(fmt.Sprintf("expected the query to be recorded twice, got %v", history))
+	}
+
+	run(commandDeleteQueryHistory, map[string]interface{}{"id": history[0].ID})
+
+	if history := h.server.history.list(); len(history) != 1 || history[0].ID != result.Entry.ID {
+		panic(fmt.Sprintf("expected only the first run to remain, got %v", history))
+	}
+
+	run(commandDeleteQueryHistory, struct{}{})
+
+	if history := h.server.history.list(); len(history) != 0 {
+		panic(fmt.Sprintf("expected the history to be cleared, got %v", history))
+	}
+}
+
+// TestQueryHistoryPersistence checks that the history survives restarts and is size-limited
+func TestQueryHistoryPersistence(*testing.T) {
+	dir, err := ioutil.TempDir("", "promql-langserver")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "history.json")
+
+	history := &queryHistory{path: path}
+
+	for i := 0; i <= maxQueryHistory; i++ {
+		if err := history.add(queryHistoryEntry{ID: fmt.Sprint(i), Query: "up"}); err != nil {
+			panic(err)
+		}
+	}
+
+	entries := (&queryHistory{path: path}).list()
+	if len(entries) != maxQueryHistory || entries[0].ID != fmt.Sprint(maxQueryHistory) {
+		panic(fmt.Sprintf("expected the newest %d queries to be persisted, got %d", maxQueryHistory, len(entries)))
+	}
+}
@@ -22,6 +22,7 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
@@ -32,7 +33,6 @@ import (
 	// Do not remove! Side effects of init() needed
 	_ "github.com/prometheus-community/promql-langserver/langserver/documentation/functions_statik"
 
-	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
 )
 
 //nolint: gochecknoglobals
@@ -50,6 +50,20 @@ func initializeFunctionDocumentation() http.FileSystem {
 // Hover shows documentation on hover
 // required by the protocol.Server interface
 func (s *server) Hover(ctx context.Context, params *protocol.HoverParams) (*protocol.Hover, error) {
+	if doc, err := s.cache.GetDocument(params.TextDocument.URI); err == nil {
+		if hover := durationHover(doc, params.Position); hover != nil {
+			return hover, nil
+		}
+
+		if hover := templateFunctionHover(doc, params.Position); hover != nil {
+			return hover, nil
+		}
+
+		if hover := s.atModifierHover(doc, params.Position); hover != nil {
+			return hover, nil
+		}
+	}
+
 	location, err := s.cache.Find(&params.TextDocumentPositionParams)
 	if err != nil || location.Node == nil {
 		return nil, nil
@@ -100,7 +114,7 @@ func (s *server) nodeToDocMarkdown(ctx context.Context, location *cache.Location
 		}
 
 	case *promql.Call:
-		doc := funcDocStrings(n.Func.Name)
+		doc := funcDocStrings(n.Func.Name) + counterHover(n.Func.Name)
 
 		if _, err := ret.WriteString(doc); err != nil {
 			return ""
@@ -119,19 +133,29 @@ func (s *server) nodeToDocMarkdown(ctx context.Context, location *cache.Location
 		}
 
 		if doc == "" {
-			doc, err = s.getMetricDocs(ctx, metric)
+			doc, err = s.getMetricDocs(ctx, location.Doc.GetURI(), metric)
 			if err != nil {
 				// nolint: errcheck
 				s.client.LogMessage(s.lifetime, &protocol.LogMessageParams{
 					Type:    protocol.Error,
 					Message: errors.Wrapf(err, "failed to get metric data").Error(),
 				})
+
+				s.reportBackendError(err)
 			}
 		}
 
 		if _, err := ret.WriteString(doc); err != nil {
 			return ""
 		}
+
+		if _, err := ret.WriteString(s.getCatalogDocs(metric)); err != nil {
+			return ""
+		}
+	case *promql.NumberLiteral:
+		if _, err := ret.WriteString(numberDocs(n.Val)); err != nil {
+			return ""
+		}
 	default:
 	}
 
@@ -142,9 +166,10 @@ func (s *server) nodeToDocMarkdown(ctx context.Context, location *cache.Location
 		}
 	}
 
-	promURL := s.getPrometheusURL()
+	promURL := s.getPrometheusURLFor(location.Doc.GetURI())
 
-	if promURL != "" {
+	// The query link is useless if the server can't evaluate queries
+	if promURL != "" && s.queryAPILimitationFor(location.Doc.GetURI()) == "" {
 		loc := *location
 
 		loc.Node = loc.Query.Ast
@@ -158,7 +183,19 @@ func (s *server) nodeToDocMarkdown(ctx context.Context, location *cache.Location
 
 		target := fmt.Sprint(promURL, "/graph?g0.expr=", qTextEncoded)
 
-		linkText := fmt.Sprintf("---\n[evaluate query](%s)\n\n", target)
+		if thanos := s.getConfig().Thanos; thanos != nil && thanos.Downsampling && thanos.MaxSourceResolution != "" {
+			target = fmt.Sprint(target, "&g0.max_source_resolution=", url.QueryEscape(thanos.MaxSourceResolution))
+		}
+
+		linkText := fmt.Sprintf("---\n%s[evaluate query](%s)\n\n", s.getThanosDocs(), target)
+
+		if t := s.getEvaluationTime(location.Query); !t.IsZero() {
+			// The Prometheus UI expects the moment in UTC
+			moment := url.QueryEscape(t.UTC().Format("2006-01-02 15:04:05"))
+			target = fmt.Sprint(target, "&g0.tab=1&g0.moment_input=", moment)
+
+			linkText = fmt.Sprintf("---\n%s[evaluate query at %s](%s)\n\n", s.getThanosDocs(), t.UTC().Format(time.RFC3339), target)
+		}
 
 		_, err = ret.WriteString(linkText)
 		if err != nil {
@@ -195,21 +232,22 @@ func funcDocStrings(name string) string {
 	return string(ret)
 }
 
-func (s *server) getMetricDocs(ctx context.Context, metric string) (string, error) {
+func (s *server) getMetricDocs(ctx context.Context, uri protocol.DocumentURI, metric string) (string, error) {
 	var ret strings.Builder
 
 	fmt.Fprintf(&ret, "### %s\n\n", metric)
 
-	if s.prometheus == nil {
+	api := s.getPrometheusFor(uri)
+	if api == nil {
+		ret.WriteString(s.getLocalMetricDocs(metric))
 		return ret.String(), nil
 	}
 
-	api := v1.NewAPI(s.prometheus)
-
 	metadata, err := api.TargetsMetadata(ctx, "", metric, "1")
 	if err != nil {
 		return ret.String(), err
 	} else if len(metadata) == 0 {
+		ret.WriteString(s.getLocalMetricDocs(metric))
 		return ret.String(), nil
 	}
 
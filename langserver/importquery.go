@@ -0,0 +1,275 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/promql"
+
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/jsonrpc2"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// commandImportQuery inserts the expressions of a Prometheus graph or Grafana Explore URL at a position
+const commandImportQuery = "promql.importQuery"
+
+// prometheusExprParam matches the parameters of the graph page of the Prometheus UI holding the expressions
+var prometheusExprParam = regexp.MustCompile(`^g(\d+)\.expr$`) // nolint: gochecknoglobals
+
+// importQueryParams are the parameters of the promql.importQuery command
+type importQueryParams struct {
+	URL          string                          `json:"url"`
+	TextDocument protocol.TextDocumentIdentifier `json:"textDocument"`
+	Position     protocol.Position               `json:"position"`
+	// Options are the formatting options of the editor, formatted queries are indented with them
+	Options protocol.FormattingOptions `json:"options"`
+}
+
+// importQueryResult is the result of the promql.importQuery command
+type importQueryResult struct {
+	Queries []string          `json:"queries"`
+	Edit    protocol.TextEdit `json:"edit"`
+}
+
+// grafanaExplorePane is the state of a pane of Grafana Explore, as found in the left, right and panes parameters
+type grafanaExplorePane struct {
+	Queries []struct {
+		Expr string `json:"expr"`
+	} `json:"queries"`
+}
+
+// ImportQueries extracts the expressions of a Prometheus graph URL, e.g. the generator URL of an alert,
+// or of a Grafana Explore URL and formats them
+func ImportQueries(rawURL string) ([]string, error) {
+	queries, err := queryURLExprs(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]string, 0, len(queries))
+
+	for _, query := range queries {
+		ret = append(ret, formatImportedQuery(query, "  ", 0, true))
+	}
+
+	return ret, nil
+}
+
+// queryURLExprs returns the expressions found in the parameters of a URL
+func queryURLExprs(rawURL string) ([]string, error) {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid URL")
+	}
+
+	values := u.Query()
+
+	// Older versions of the Prometheus UI keep the state of the graph page in the fragment
+	if fragment, err := url.ParseQuery(u.Fragment); err == nil {
+		for key, value := range fragment {
+			if _, ok := values[key]; !ok {
+				values[key] = value
+			}
+		}
+	}
+
+	var ret []string
+
+	type indexed struct {
+		index int
+		expr  string
+	}
+
+	var graphs []indexed
+
+	for key := range values {
+		if m := prometheusExprParam.FindStringSubmatch(key); m != nil {
+			index, _ := strconv.Atoi(m[1]) // nolint: errcheck
+			graphs = append(graphs, indexed{index, values.Get(key)})
+		}
+	}
+
+	sort.Slice(graphs, func(i, j int) bool { return graphs[i].index < graphs[j].index })
+
+	for _, g := range graphs {
+		ret = append(ret, g.expr)
+	}
+
+	for _, key := range []string{"left", "right"} {
+		if value := values.Get(key); value != "" {
+			ret = append(ret, grafanaPaneExprs([]byte(value))...)
+		}
+	}
+
+	if value := values.Get("panes"); value != "" {
+		var panes map[string]json.RawMessage
+
+		if err := json.Unmarshal([]byte(value), &panes); err == nil {
+			names := make([]string, 0, len(panes))
+			for name := range panes {
+				names = append(names, name)
+			}
+
+			sort.Strings(names)
+
+			for _, name := range names {
+				ret = append(ret, grafanaPaneExprs(panes[name])...)
+			}
+		}
+	}
+
+	// The HTTP API, e.g. links to /api/v1/query
+	if len(ret) == 0 {
+		for _, key := range []string{"query", "expr"} {
+			if value := values.Get(key); value != "" {
+				ret = append(ret, value)
+			}
+		}
+	}
+
+	var nonEmpty []string
+
+	for _, expr := range ret {
+		if strings.TrimSpace(expr) != "" {
+			nonEmpty = append(nonEmpty, strings.TrimSpace(expr))
+		}
+	}
+
+	if len(nonEmpty) == 0 {
+		return nil, errors.New("no PromQL expression found in the URL, expected a Prometheus graph or Grafana Explore URL")
+	}
+
+	return nonEmpty, nil
+}
+
+// grafanaPaneExprs returns the expressions of a pane of Grafana Explore. Panes are either objects with
+// a list of queries, or, in the compact format of older versions, arrays of the time range, the datasource
+// and the queries.
+func grafanaPaneExprs(data []byte) []string {
+	var ret []string
+
+	var pane grafanaExplorePane
+
+	if err := json.Unmarshal(data, &pane); err == nil {
+		for _, q := range pane.Queries {
+			ret = append(ret, q.Expr)
+		}
+
+		return ret
+	}
+
+	var compact []json.RawMessage
+
+	if err := json.Unmarshal(data, &compact); err != nil {
+		return nil
+	}
+
+	for _, element := range compact {
+		var q struct {
+			Expr string `json:"expr"`
+		}
+
+		if err := json.Unmarshal(element, &q); err == nil {
+			ret = append(ret, q.Expr)
+		}
+	}
+
+	return ret
+}
+
+// formatImportedQuery formats a query for inserting it at a column, multiline queries are indented with indent.
+// Queries that can't be formatted without losing information, e.g. because they contain comments, are left unchanged.
+func formatImportedQuery(query string, indent string, width int, multiline bool) string {
+	expr, err := promql.ParseExpr(query)
+	if err != nil || hasComments(query) {
+		return query
+	}
+
+	f := &formatter{content: query, indent: indent, width: width}
+
+	formatted := f.single(expr)
+	if multiline {
+		formatted = f.format(expr, 0)
+	}
+
+	if !sameExpr(expr, formatted) {
+		return query
+	}
+
+	return formatted
+}
+
+// importQuery implements the promql.importQuery command. The expressions are inserted at the position,
+// formatted with the indentation of its line in PromQL and Markdown files and on a single line otherwise.
+func (s *server) importQuery(ctx context.Context, params *importQueryParams) (*importQueryResult, error) {
+	queries, err := queryURLExprs(params.URL)
+	if err != nil {
+		return nil, jsonrpc2.NewErrorf(jsonrpc2.CodeInvalidParams, "%s", err.Error())
+	}
+
+	doc, err := s.cache.GetDocument(params.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := doc.GetContent()
+	if err != nil {
+		return nil, err
+	}
+
+	pos, err := doc.ProtocolPositionToTokenPos(params.Position)
+	if err != nil {
+		return nil, err
+	}
+
+	offset := doc.ByteOffset(pos)
+	linePrefix := content[strings.LastIndexByte(content[:offset], '\n')+1 : offset]
+	lineIndent := linePrefix[:len(linePrefix)-len(strings.TrimLeft(linePrefix, " \t"))]
+
+	indent := "\t"
+	if params.Options.InsertSpaces {
+		indent = strings.Repeat(" ", int(params.Options.TabSize))
+	}
+
+	multiline := doc.GetLanguageID() == "promql" || doc.GetLanguageID() == "markdown"
+
+	ret := &importQueryResult{}
+
+	for _, query := range queries {
+		ret.Queries = append(ret.Queries, indentLines(formatImportedQuery(query, indent, len(linePrefix), multiline), lineIndent))
+	}
+
+	ret.Edit = protocol.TextEdit{
+		Range:   protocol.Range{Start: params.Position, End: params.Position},
+		NewText: strings.Join(ret.Queries, "\n\n"+lineIndent),
+	}
+
+	// nolint: errcheck
+	s.client.ApplyEdit(ctx, &protocol.ApplyWorkspaceEditParams{
+		Label: "Import query",
+		Edit: protocol.WorkspaceEdit{
+			Changes: map[string][]protocol.TextEdit{string(params.TextDocument.URI): {ret.Edit}},
+		},
+	})
+
+	return ret, nil
+}
@@ -0,0 +1,96 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// TestImportQueries checks that the expressions of Prometheus and Grafana links are found
+func TestImportQueries(*testing.T) {
+	for _, test := range []struct {
+		url      string
+		expected string
+	}{
+		{
+			url:      "http://prometheus:9090/graph?g0.expr=" + url.QueryEscape(`up{job="node"}==0`) + "&g0.tab=1&g1.expr=rate(x%5B5m%5D)",
+			expected: `[up{job="node"} == 0 rate(x[5m])]`,
+		},
+		{
+			url:      "https://grafana.example.com/explore?left=" + url.QueryEscape(`["now-1h","now","Prometheus",{"expr":"sum by(job)(up)"}]`),
+			expected: `[sum by (job) (up)]`,
+		},
+		{
+			url: "https://grafana.example.com/explore?panes=" +
+				url.QueryEscape(`{"b":{"datasource":"x","queries":[{"refId":"A","expr":"b"}]},"a":{"queries":[{"expr":"a"}]}}`),
+			expected: `[a b]`,
+		},
+		{
+			url:      "http://prometheus:9090/api/v1/query?query=up%20%23%20comment",
+			expected: `[up # comment]`,
+		},
+	} {
+		queries, err := ImportQueries(test.url)
+		if err != nil || fmt.Sprint(queries) != test.expected {
+			panic(fmt.Sprintf("expected %s for %s, got %v, %v", test.expected, test.url, queries, err))
+		}
+	}
+
+	if _, err := ImportQueries("https://grafana.example.com/d/abc"); err == nil {
+		panic("expected a URL without expression to be rejected")
+	}
+}
+
+// TestImportQueryCommand checks that imported queries are indented like the line they are inserted at
+func TestImportQueryCommand(*testing.T) {
+	h, err := NewHeadlessServer(context.Background(), &Config{}, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	if err := h.AddDocument("notes.md", "markdown", "- Outage\n\n  ```promql\n  \n  ```\n"); err != nil {
+		panic(err)
+	}
+
+	long := `sum by (job) (rate(http_requests_total{code=~"5..", handler!="/metrics", method="GET"}[5m]))`
+
+	ret, err := h.server.ExecuteCommand(context.Background(), &protocol.ExecuteCommandParams{
+		Command: commandImportQuery,
+		Arguments: []interface{}{map[string]interface{}{
+			"url":          "http://prometheus:9090/graph?g0.expr=" + url.QueryEscape(long),
+			"textDocument": map[string]interface{}{"uri": "notes.md"},
+			"position":     map[string]interface{}{"line": 3, "character": 2},
+			"options":      map[string]interface{}{"insertSpaces": true, "tabSize": 2},
+		}},
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	const expected = `sum by (job) (
+    rate(
+      http_requests_total{code=~"5..", handler!="/metrics", method="GET"}[5m]
+    )
+  )`
+
+	if edit := ret.(*importQueryResult).Edit; edit.NewText != expected || edit.Range.Start.Line != 3 || edit.Range.Start.Character != 2 {
+		panic(fmt.Sprintf("expected the query to be formatted with the indentation of the code block, got %+v", edit))
+	}
+}
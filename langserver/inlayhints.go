@@ -0,0 +1,125 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/prometheus-community/promql-langserver/langserver/cache"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+	"github.com/prometheus/common/model"
+)
+
+// inlayHintParams are the parameters of a textDocument/inlayHint request.
+// Inlay hints were added in version 3.17 of the protocol, which is newer than
+// what the protocol package implements.
+type inlayHintParams struct {
+	TextDocument protocol.TextDocumentIdentifier `json:"textDocument"`
+	Range        protocol.Range                  `json:"range"`
+}
+
+// inlayHint is a single inlay hint as defined by version 3.17 of the protocol
+type inlayHint struct {
+	Position    protocol.Position `json:"position"`
+	Label       string            `json:"label"`
+	Tooltip     string            `json:"tooltip,omitempty"`
+	PaddingLeft bool              `json:"paddingLeft,omitempty"`
+}
+
+// InlayHint shows the timing behavior of alerting rules next to their definition
+func (s *server) InlayHint(_ context.Context, params *inlayHintParams) ([]inlayHint, error) {
+	doc, err := s.cache.GetDocument(params.TextDocument.URI)
+	if err != nil {
+		return nil, nil
+	}
+
+	groups, err := doc.GetRuleGroups()
+	if err != nil {
+		return nil, nil
+	}
+
+	hints := []inlayHint{}
+
+	for _, group := range groups {
+		for _, rule := range group.Rules {
+			if rule.Alert == "" {
+				continue
+			}
+
+			hint, err := alertTimingHint(doc, rule)
+			if err != nil {
+				continue
+			}
+
+			if positionInRange(hint.Position, params.Range) {
+				hints = append(hints, hint)
+			}
+		}
+	}
+
+	return hints, nil
+}
+
+// alertTimingHint summarizes the detection latency of an alerting rule, i.e. the time between
+// the alert condition becoming true and the alert firing.
+func alertTimingHint(doc *cache.DocumentHandle, rule *cache.Rule) (inlayHint, error) {
+	interval := groupInterval(rule.Group)
+
+	// The condition is noticed at the next evaluation, which happens at most one
+	// interval later. Then it has to hold for the duration given in the for field.
+	minLatency := rule.For
+	maxLatency := rule.For + interval
+
+	var label, tooltip string
+
+	if rule.For == 0 {
+		label = fmt.Sprintf("fires within %s", model.Duration(maxLatency))
+		tooltip = fmt.Sprintf("The rule is evaluated every %s and fires as soon as the condition is observed.", model.Duration(interval))
+	} else {
+		label = fmt.Sprintf("fires after %s–%s", model.Duration(minLatency), model.Duration(maxLatency))
+		tooltip = fmt.Sprintf("The rule is evaluated every %s; the condition must then hold for %s before the alert fires.",
+			model.Duration(interval), model.Duration(rule.For))
+	}
+
+	pos := rule.NameEnd
+	if rule.ForEnd != 0 {
+		pos = rule.ForEnd
+	}
+
+	position, err := doc.PosToProtocolPosition(pos)
+	if err != nil {
+		return inlayHint{}, err
+	}
+
+	return inlayHint{
+		Position:    position,
+		Label:       label,
+		Tooltip:     tooltip,
+		PaddingLeft: true,
+	}, nil
+}
+
+// positionInRange checks whether a position lies inside a range. The end of the range is included.
+func positionInRange(pos protocol.Position, rng protocol.Range) bool {
+	if pos.Line < rng.Start.Line || pos.Line == rng.Start.Line && pos.Character < rng.Start.Character {
+		return false
+	}
+
+	if pos.Line > rng.End.Line || pos.Line == rng.End.Line && pos.Character > rng.End.Character {
+		return false
+	}
+
+	return true
+}
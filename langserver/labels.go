@@ -0,0 +1,245 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"go/token"
+	"regexp"
+
+	"github.com/prometheus-community/promql-langserver/langserver/cache"
+	"github.com/prometheus/prometheus/promql"
+	"gopkg.in/yaml.v3"
+)
+
+// labelReference is an occurrence of a label name in a document
+type labelReference struct {
+	Name string
+	Pos  token.Pos
+	End  token.Pos
+}
+
+// labelTemplateRegexp matches label references in alerting templates,
+// e.g. `$labels.instance`, `.Labels.instance` or `index $labels "instance"`
+var labelTemplateRegexp = regexp.MustCompile(`(?:\$labels|\.Labels)\.([a-zA-Z_][a-zA-Z0-9_]*)|index\s+(?:\$labels|\.Labels)\s+"([a-zA-Z_][a-zA-Z0-9_]*)"`)
+
+// labelArguments lists the arguments of functions that are label names
+var labelArguments = map[string][]int{
+	"label_replace": {1, 3},
+	"label_join":    {1, 3, 4, 5, 6, 7, 8, 9},
+}
+
+// getLabelReferences returns all occurrences of label names in a document
+func getLabelReferences(doc *cache.DocumentHandle) ([]labelReference, error) {
+	queries, err := doc.GetQueries()
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []labelReference
+
+	for _, q := range queries {
+		ret = append(ret, queryLabelReferences(q)...)
+	}
+
+	templateRefs, err := ruleLabelReferences(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(ret, templateRefs...), nil
+}
+
+// queryLabelReferences finds the label names used in matchers, grouping clauses
+// and arguments of label manipulating functions of a query
+func queryLabelReferences(q *cache.CompiledQuery) []labelReference {
+	var ret []labelReference
+
+	l := promql.Lex(q.Content)
+
+	var prev promql.Item
+
+	inBraces := false
+	// inGrouping is set inside the label list of a by, without, on, ignoring, group_left or group_right clause
+	inGrouping := false
+	groupingNext := false
+
+	for {
+		var item promql.Item
+
+		l.NextItem(&item)
+
+		if item.Typ == promql.EOF || item.Typ == promql.ERROR {
+			break
+		}
+
+		switch item.Typ {
+		case promql.COMMENT:
+			continue
+		case promql.LEFT_BRACE:
+			inBraces = true
+		case promql.RIGHT_BRACE:
+			inBraces = false
+		case promql.BY, promql.WITHOUT, promql.ON, promql.IGNORING, promql.GROUP_LEFT, promql.GROUP_RIGHT:
+			groupingNext = true
+			prev = item
+
+			continue
+		case promql.LEFT_PAREN:
+			inGrouping = groupingNext
+		case promql.RIGHT_PAREN:
+			inGrouping = false
+		case promql.EQL, promql.NEQ, promql.EQL_REGEX, promql.NEQ_REGEX:
+			if inBraces && prev.Typ == promql.IDENTIFIER {
+				ret = append(ret, itemLabelReference(q, prev))
+			}
+		case promql.IDENTIFIER:
+			if inGrouping {
+				ret = append(ret, itemLabelReference(q, item))
+			}
+		}
+
+		groupingNext = false
+		prev = item
+	}
+
+	if q.Ast == nil {
+		return ret
+	}
+
+	promql.Inspect(q.Ast, func(node promql.Node, _ []promql.Node) error {
+		call, ok := node.(*promql.Call)
+		if !ok {
+			return nil
+		}
+
+		for _, i := range labelArguments[call.Func.Name] {
+			if i >= len(call.Args) {
+				break
+			}
+
+			str, ok := call.Args[i].(*promql.StringLiteral)
+			// Skip strings containing escape sequences, the position of the label name would not be known
+			if !ok || int(str.PosRange.End-str.PosRange.Start) != len(str.Val)+2 || str.Val == "" {
+				continue
+			}
+
+			ret = append(ret, labelReference{
+				Name: str.Val,
+				Pos:  q.Pos + token.Pos(str.PosRange.Start) + 1,
+				End:  q.Pos + token.Pos(str.PosRange.End) - 1,
+			})
+		}
+
+		return nil
+	})
+
+	return ret
+}
+
+func itemLabelReference(q *cache.CompiledQuery, item promql.Item) labelReference {
+	return labelReference{
+		Name: item.Val,
+		Pos:  q.Pos + token.Pos(item.Pos),
+		End:  q.Pos + token.Pos(int(item.Pos)+len(item.Val)),
+	}
+}
+
+// ruleLabelReferences finds the labels set by rules as well as label references in
+// the templates of their labels and annotations
+func ruleLabelReferences(doc *cache.DocumentHandle) ([]labelReference, error) {
+	groups, err := doc.GetRuleGroups()
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []labelReference
+
+	for _, group := range groups {
+		for _, rule := range group.Rules {
+			for _, key := range []string{"labels", "annotations"} {
+				mapping := cache.MappingValue(rule.Node, key)
+				if mapping == nil || mapping.Kind != yaml.MappingNode {
+					continue
+				}
+
+				for i := 0; i+1 < len(mapping.Content); i += 2 {
+					if key == "labels" {
+						refs, err := yamlKeyLabelReference(doc, mapping.Content[i], group.LineOffset)
+						if err != nil {
+							return nil, err
+						}
+
+						ret = append(ret, refs...)
+					}
+
+					refs, err := templateLabelReferences(doc, mapping.Content[i+1], group.LineOffset)
+					if err != nil {
+						return nil, err
+					}
+
+					ret = append(ret, refs...)
+				}
+			}
+		}
+	}
+
+	return ret, nil
+}
+
+func yamlKeyLabelReference(doc *cache.DocumentHandle, node *yaml.Node, lineOffset int) ([]labelReference, error) {
+	if node.Kind != yaml.ScalarNode || node.Style != 0 {
+		return nil, nil
+	}
+
+	pos, end, err := doc.YamlNodeRange(node, lineOffset)
+	if err != nil {
+		return nil, err
+	}
+
+	return []labelReference{{Name: node.Value, Pos: pos, End: end}}, nil
+}
+
+func templateLabelReferences(doc *cache.DocumentHandle, node *yaml.Node, lineOffset int) ([]labelReference, error) {
+	if node.Kind != yaml.ScalarNode {
+		return nil, nil
+	}
+
+	pos, end, err := doc.YamlNodeRange(node, lineOffset)
+	if err != nil {
+		return nil, err
+	}
+
+	// The raw source is searched, so the positions of the matches are known
+	content, err := doc.GetSubstring(pos, end)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []labelReference
+
+	for _, match := range labelTemplateRegexp.FindAllStringSubmatchIndex(content, -1) {
+		start, stop := match[2], match[3]
+		if start < 0 {
+			start, stop = match[4], match[5]
+		}
+
+		ret = append(ret, labelReference{
+			Name: content[start:stop],
+			Pos:  pos + token.Pos(start),
+			End:  pos + token.Pos(stop),
+		})
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,189 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/prometheus-community/promql-langserver/langserver/cache"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	config_util "github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+)
+
+// labelValuesTTL is how long label values fetched from Prometheus are reused for completion
+const labelValuesTTL = time.Minute
+
+// datasourceKey identifies a Prometheus server together with the way it is requested. Sessions
+// only share data fetched with the same key, so they never see data of other credentials.
+type datasourceKey struct {
+	url string
+	// headers and httpConfig are the credentials of the requests
+	headers    string
+	httpConfig *config_util.HTTPClientConfig
+	// partialResponse is the partial_response parameter of metadata requests to Thanos Query
+	partialResponse string
+}
+
+// datasourceKey returns the key of the data fetched from the Prometheus server at url
+func (s *server) datasourceKey(url string) datasourceKey {
+	config := s.getConfig()
+
+	return datasourceKey{
+		url:             url,
+		headers:         fmt.Sprint(config.requestHeaders()),
+		httpConfig:      config.HTTPConfig,
+		partialResponse: config.Thanos.partialResponse(),
+	}
+}
+
+// labelValuesKey identifies a label value request to the Prometheus server
+type labelValuesKey struct {
+	datasourceKey
+	// selector selects the series the values are scoped to, or is empty for all series
+	selector string
+	label    string
+	// evaluationTime is the configured evaluation time, the zero time stands for now
+	evaluationTime time.Time
+}
+
+type labelValuesEntry struct {
+	values  model.LabelValues
+	fetched time.Time
+}
+
+// labelValuesCache holds recently fetched label values, to avoid a request for every keystroke.
+// It is shared by all sessions of the process, e.g. all clients of a TCP server.
+var labelValuesCache = struct { // nolint: gochecknoglobals
+	sync.Mutex
+	entries map[labelValuesKey]labelValuesEntry
+}{entries: make(map[labelValuesKey]labelValuesEntry)}
+
+// labelValues returns the values of a label on the series matching a selector, or on all series if
+// selector is empty. With an empty labelName, the label names of the series are returned.
+// Results are cached for labelValuesTTL.
+func (s *server) labelValues(ctx context.Context, uri protocol.DocumentURI, query *cache.CompiledQuery, selector string, labelName string) model.LabelValues {
+	api := s.getQueryAPIFor(uri)
+	if api == nil {
+		return nil
+	}
+
+	return s.labelValuesFrom(ctx, api, s.getPrometheusURLFor(uri), query, selector, labelName)
+}
+
+// labelValuesFrom is labelValues for the Prometheus server at url
+func (s *server) labelValuesFrom(ctx context.Context, api v1.API, url string, query *cache.CompiledQuery, selector string, labelName string) model.LabelValues {
+	key := labelValuesKey{
+		datasourceKey:  s.datasourceKey(url),
+		selector:       selector,
+		label:          labelName,
+		evaluationTime: s.getEvaluationTime(query),
+	}
+
+	labelValuesCache.Lock()
+	entry, ok := labelValuesCache.entries[key]
+	labelValuesCache.Unlock()
+
+	if ok && time.Since(entry.fetched) < labelValuesTTL {
+		return entry.values
+	}
+
+	var (
+		values   model.LabelValues
+		warnings v1.Warnings
+		err      error
+	)
+
+	switch {
+	case selector == "" && labelName == "":
+		var names []string
+
+		names, warnings, err = api.LabelNames(ctx)
+
+		for _, name := range names {
+			values = append(values, model.LabelValue(name))
+		}
+	case selector == "":
+		values, warnings, err = api.LabelValues(ctx, labelName)
+	default:
+		end := s.evaluationTimeOrNow(query)
+
+		var series []model.LabelSet
+
+		series, warnings, err = api.Series(ctx, []string{selector}, end.Add(-seriesLookback), end)
+
+		seen := make(map[model.LabelValue]bool)
+
+		for _, ls := range series {
+			if labelName == "" {
+				for name := range ls {
+					if !seen[model.LabelValue(name)] {
+						seen[model.LabelValue(name)] = true
+
+						values = append(values, model.LabelValue(name))
+					}
+				}
+
+				continue
+			}
+
+			if value, ok := ls[model.LabelName(labelName)]; ok && !seen[value] {
+				seen[value] = true
+
+				values = append(values, value)
+			}
+		}
+
+		sort.Sort(values)
+	}
+
+	if err != nil {
+		// nolint: errcheck
+		s.client.LogMessage(s.lifetime, &protocol.LogMessageParams{
+			Type:    protocol.Error,
+			Message: errors.Wrapf(err, "could not get label value data from Prometheus").Error(),
+		})
+
+		s.reportBackendError(err)
+
+		return nil
+	}
+
+	// Partial responses aren't cached, the missing values are likely to be available soon
+	if len(warnings) > 0 {
+		s.reportWarnings(warnings)
+		return values
+	}
+
+	labelValuesCache.Lock()
+	defer labelValuesCache.Unlock()
+
+	// Drop expired entries, so that the cache doesn't grow while the user types
+	for k, e := range labelValuesCache.entries {
+		if time.Since(e.fetched) >= labelValuesTTL {
+			delete(labelValuesCache.entries, k)
+		}
+	}
+
+	labelValuesCache.entries[key] = labelValuesEntry{values: values, fetched: time.Now()}
+
+	return values
+}
@@ -0,0 +1,95 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestLabelValues checks that label values are scoped to the metric and cached
+func TestLabelValues(*testing.T) {
+	requests := 0
+
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.URL.Path {
+		case "/api/v1/series":
+			requests++
+
+			if r.FormValue("match[]") != "http_requests_total" {
+				panic("unexpected series selector " + r.FormValue("match[]"))
+			}
+
+			fmt.Fprint(w, `{"status":"success","data":[
+				{"__name__":"http_requests_total","job":"node"},
+				{"__name__":"http_requests_total","job":"api"},
+				{"__name__":"http_requests_total","job":"api","code":"500"}]}`)
+		default:
+			fmt.Fprint(w, `{"status":"success","data":{}}`)
+		}
+	}))
+	defer prom.Close()
+
+	h, err := NewHeadlessServer(context.Background(), &Config{PrometheusURL: prom.URL}, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	for i := 0; i < 2; i++ {
+		values := h.server.labelValues(context.Background(), "", nil, "http_requests_total", "job")
+
+		if fmt.Sprint(values) != "[api node]" {
+			panic(fmt.Sprintf("expected the job values of http_requests_total, got %v", values))
+		}
+	}
+
+	if requests != 1 {
+		panic(fmt.Sprintf("expected label values to be cached, got %d requests", requests))
+	}
+}
+
+// TestDatasourceKey checks that sessions only share cached data if they request Prometheus the same way
+func TestDatasourceKey(*testing.T) {
+	enabled, disabled := true, false
+
+	key := func(config *Config) datasourceKey {
+		return (&server{config: config}).datasourceKey("http://prometheus:9090")
+	}
+
+	base := key(&Config{})
+
+	if key(&Config{}) != base {
+		panic("expected sessions with the same configuration to share cached data")
+	}
+
+	for _, config := range []*Config{
+		{Thanos: &ThanosConfig{PartialResponse: &enabled}},
+		{Thanos: &ThanosConfig{PartialResponse: &disabled}},
+		{Headers: map[string]string{"X-Scope-OrgID": "team-a"}},
+	} {
+		if key(config) == base {
+			panic(fmt.Sprintf("expected %+v not to share cached data with the default configuration", config))
+		}
+	}
+
+	if key(&Config{Thanos: &ThanosConfig{PartialResponse: &enabled}}) == key(&Config{Thanos: &ThanosConfig{PartialResponse: &disabled}}) {
+		panic("expected sessions with different partial_response settings not to share cached data")
+	}
+}
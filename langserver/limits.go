@@ -0,0 +1,248 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"go/token"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/prometheus-community/promql-langserver/langserver/cache"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+// tooManySamplesErr is the error rule evaluations fail with if they exceed query.max-samples
+const tooManySamplesErr = "query processing would load too many samples into memory"
+
+// serverLimits are the limits configured on the Prometheus server that rule files can run into.
+// Zero values stand for unknown limits.
+type serverLimits struct {
+	// MaxSamples is the maximum number of samples a query may load into memory, set by query.max-samples
+	MaxSamples int64
+	// MaxRulesPerGroup is the maximum number of rules in a group. It is only enforced by some rulers,
+	// e.g. the one of Cortex.
+	MaxRulesPerGroup int
+}
+
+// limits returns the limits of the connected Prometheus server
+func (s *server) limits() serverLimits {
+	s.prometheusMu.Lock()
+	defer s.prometheusMu.Unlock()
+
+	return s.prometheusLimits
+}
+
+// fetchLimits reads the limits from the command line flags of a Prometheus server
+func fetchLimits(ctx context.Context, api v1.API) serverLimits {
+	var ret serverLimits
+
+	flags, err := api.Flags(ctx)
+	if err != nil {
+		return ret
+	}
+
+	if maxSamples, err := strconv.ParseInt(flags["query.max-samples"], 10, 64); err == nil {
+		ret.MaxSamples = maxSamples
+	}
+
+	if maxRules, err := strconv.Atoi(flags["ruler.max-rules-per-rule-group"]); err == nil {
+		ret.MaxRulesPerGroup = maxRules
+	}
+
+	return ret
+}
+
+// deployedGroup is a rule group as returned by the rules API of the Prometheus server.
+// It includes the evaluation times, which aren't part of v1.RuleGroup.
+type deployedGroup struct {
+	Name string `json:"name"`
+	// EvaluationTime is the duration of the last evaluation of the group in seconds
+	EvaluationTime float64 `json:"evaluationTime"`
+	Rules          []struct {
+		Name      string `json:"name"`
+		LastError string `json:"lastError"`
+	} `json:"rules"`
+}
+
+// getPrometheusClient returns the client of the Prometheus server, or nil if there is none
+func (s *server) getPrometheusClient() api.Client {
+	s.prometheusMu.Lock()
+	defer s.prometheusMu.Unlock()
+
+	return s.prometheus
+}
+
+// fetchDeployedGroups returns the rule groups loaded by the Prometheus server, indexed by name
+func (s *server) fetchDeployedGroups(ctx context.Context) (map[string][]*deployedGroup, error) {
+	client := s.getPrometheusClient()
+	if client == nil {
+		return nil, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, client.URL("/api/v1/rules", nil).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, body, err := client.Do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		// Not all datasources have a rules API, e.g. Thanos Query
+		return nil, nil
+	case resp.StatusCode/100 != 2:
+		// Reported like the errors of the API client, so that reportBackendError can classify it
+		return nil, &v1.Error{Type: v1.ErrClient, Msg: fmt.Sprintf("client error: %d", resp.StatusCode)}
+	}
+
+	var result struct {
+		Data struct {
+			Groups []*deployedGroup `json:"groups"`
+		} `json:"data"`
+	}
+
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, errors.Wrap(err, "invalid rules API response")
+	}
+
+	ret := make(map[string][]*deployedGroup)
+
+	for _, group := range result.Data.Groups {
+		ret[group.Name] = append(ret[group.Name], group)
+	}
+
+	return ret, nil
+}
+
+// estimatedEvaluationTime estimates how long evaluating a group takes, based on the last evaluation
+// of the deployed group with the same name. Rules that aren't deployed yet are assumed to take as long
+// as the average deployed rule. It returns 0 if there is no matching deployed group.
+func estimatedEvaluationTime(group *cache.RuleGroup, deployed []*deployedGroup) time.Duration {
+	// Groups of the same name in different files can't be told apart
+	if len(deployed) != 1 || len(deployed[0].Rules) == 0 {
+		return 0
+	}
+
+	d := deployed[0]
+
+	known := make(map[string]bool)
+	for _, rule := range d.Rules {
+		known[rule.Name] = true
+	}
+
+	total := d.EvaluationTime
+	average := d.EvaluationTime / float64(len(d.Rules))
+
+	for _, rule := range group.Rules {
+		if !known[rule.Name()] {
+			total += average
+		}
+	}
+
+	return time.Duration(total * float64(time.Second))
+}
+
+// ruleLimitDiagnostics warns about rule groups that exceed the limits of the Prometheus server:
+// groups with more rules than the ruler accepts, groups that take longer to evaluate than their
+// interval and rules that fail because they load more samples than query.max-samples allows.
+// nolint: funlen
+func (s *server) ruleLimitDiagnostics(doc *cache.DocumentHandle) []protocol.Diagnostic {
+	groups, err := doc.GetRuleGroups()
+	if err != nil || len(groups) == 0 || s.getQueryAPI() == nil {
+		return nil
+	}
+
+	limits := s.limits()
+
+	ctx, cancel := context.WithTimeout(s.lifetime, 5*time.Second)
+	defer cancel()
+
+	deployed, err := s.fetchDeployedGroups(ctx)
+	if err != nil {
+		s.reportBackendError(err)
+	}
+
+	var ret []protocol.Diagnostic
+
+	warn := func(pos token.Pos, end token.Pos, code string, msg string) {
+		rng, err := tokenRange(doc, pos, end)
+		if err != nil {
+			return
+		}
+
+		ret = append(ret, protocol.Diagnostic{
+			Range:    rng,
+			Severity: 2, // Warning
+			Code:     code,
+			Source:   "promql-lsp",
+			Message:  msg,
+		})
+	}
+
+	for _, group := range groups {
+		if limits.MaxRulesPerGroup > 0 && len(group.Rules) > limits.MaxRulesPerGroup {
+			warn(group.NamePos, group.NameEnd, codeRuleGroupLimit,
+				fmt.Sprintf("group %q has %d rules, more than the %d rules per group the ruler accepts",
+					group.Name, len(group.Rules), limits.MaxRulesPerGroup))
+		}
+
+		if estimate := estimatedEvaluationTime(group, deployed[group.Name]); estimate != 0 && estimate >= groupInterval(group) {
+			warn(group.NamePos, group.NameEnd, codeRuleGroupDuration,
+				fmt.Sprintf("evaluating group %q is estimated to take %s based on its last evaluation on the server, "+
+					"not less than its interval of %s; evaluations are skipped while the previous one is still running",
+					group.Name, estimate.Round(time.Millisecond), model.Duration(groupInterval(group))))
+		}
+
+		if len(deployed[group.Name]) != 1 {
+			continue
+		}
+
+		failing := make(map[string]bool)
+
+		for _, rule := range deployed[group.Name][0].Rules {
+			if strings.Contains(rule.LastError, tooManySamplesErr) {
+				failing[rule.Name] = true
+			}
+		}
+
+		for _, rule := range group.Rules {
+			if !failing[rule.Name()] {
+				continue
+			}
+
+			msg := "the deployed version of this rule fails because its query loads more samples than query.max-samples allows"
+			if limits.MaxSamples > 0 {
+				msg = fmt.Sprintf("the deployed version of this rule fails because its query loads more than %d samples, the query.max-samples limit",
+					limits.MaxSamples)
+			}
+
+			warn(rule.NamePos, rule.NameEnd, codeRuleSampleLimit, msg)
+		}
+	}
+
+	return ret
+}
@@ -0,0 +1,75 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+)
+
+// TestRuleLimitDiagnostics checks that rule groups exceeding the limits of the server are reported
+func TestRuleLimitDiagnostics(*testing.T) {
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.URL.Path {
+		case "/api/v1/status/flags":
+			fmt.Fprint(w, `{"status":"success","data":{"query.max-samples":"50000000","ruler.max-rules-per-rule-group":"1"}}`)
+		case "/api/v1/rules":
+			fmt.Fprint(w, `{"status":"success","data":{"groups":[{"name":"slow","evaluationTime":45,"rules":[
+				{"name":"job:errors:rate5m","lastError":"query processing would load too many samples into memory in query execution"}]}]}}`)
+		default:
+			fmt.Fprint(w, `{"status":"success","data":{}}`)
+		}
+	}))
+	defer prom.Close()
+
+	h, err := NewHeadlessServer(context.Background(), &Config{PrometheusURL: prom.URL}, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	report, err := h.AnalyzeDocument("rules.yml", "yaml", `groups:
+  - name: slow
+    rules:
+      - record: job:errors:rate5m
+        expr: sum by (job) (rate(errors_total[5m]))
+      - record: job:requests:rate5m
+        expr: sum by (job) (rate(requests_total[5m]))
+`)
+	if err != nil {
+		panic(err)
+	}
+
+	var codes []string
+
+	for _, d := range report.Diagnostics {
+		switch d.Code {
+		case codeRuleGroupLimit, codeRuleGroupDuration, codeRuleSampleLimit:
+			codes = append(codes, fmt.Sprint(d.Code))
+		}
+	}
+
+	sort.Strings(codes)
+
+	// The second rule isn't deployed yet, so the group is estimated to take 90s
+	if fmt.Sprint(codes) != "[rule-group-duration rule-group-limit rule-sample-limit]" {
+		panic(fmt.Sprintf("expected all limits to be reported, got %v", report.Diagnostics))
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/common/model"
+
+	"github.com/prometheus-community/promql-langserver/langserver/cache"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+// defaultOrigin names the Prometheus server of the prometheus_url option in completion details
+const defaultOrigin = "default"
+
+// metadataSource is a Prometheus server whose metadata is merged
+type metadataSource struct {
+	name string
+	url  string
+	api  v1.API
+}
+
+// metadataSources returns the server a document uses followed by the servers of the merge_metadata option,
+// or nil if no metadata is merged. Servers without query API and duplicate URLs are left out.
+func (s *server) metadataSources(uri protocol.DocumentURI) []metadataSource {
+	merged := s.getConfig().MergeMetadata
+	if len(merged) == 0 {
+		return nil
+	}
+
+	var ret []metadataSource
+
+	seen := make(map[string]bool)
+
+	add := func(name string, url string, api v1.API) {
+		if api == nil || seen[url] {
+			return
+		}
+
+		seen[url] = true
+
+		ret = append(ret, metadataSource{name: name, url: url, api: api})
+	}
+
+	if e := s.endpointFor(uri); e != nil {
+		if e.mode == "" {
+			add(e.name, e.url, v1.NewAPI(e.client))
+		}
+	} else {
+		add(defaultOrigin, s.getPrometheusURL(), s.getQueryAPI())
+	}
+
+	s.endpointsMu.Lock()
+	endpoints := s.endpoints
+	s.endpointsMu.Unlock()
+
+	for _, name := range merged {
+		if e, ok := endpoints[name]; ok && e.mode == "" {
+			add(name, e.url, v1.NewAPI(e.client))
+		}
+	}
+
+	return ret
+}
+
+// mergedLabelValues is labelValues for the server of a document and the servers of the merge_metadata option,
+// which are queried in parallel. origins maps every value to the names of the servers that have it,
+// it is nil if no metadata is merged.
+func (s *server) mergedLabelValues(ctx context.Context, uri protocol.DocumentURI, query *cache.CompiledQuery, selector string, labelName string) (values model.LabelValues, origins map[model.LabelValue][]string) {
+	sources := s.metadataSources(uri)
+	if len(sources) == 0 {
+		return s.labelValues(ctx, uri, query, selector, labelName), nil
+	}
+
+	results := make([]model.LabelValues, len(sources))
+
+	var wg sync.WaitGroup
+
+	for i, source := range sources {
+		wg.Add(1)
+
+		go func(i int, source metadataSource) {
+			defer wg.Done()
+
+			results[i] = s.labelValuesFrom(ctx, source.api, source.url, query, selector, labelName)
+		}(i, source)
+	}
+
+	wg.Wait()
+
+	origins = make(map[model.LabelValue][]string)
+
+	for i, result := range results {
+		for _, value := range result {
+			if _, ok := origins[value]; !ok {
+				values = append(values, value)
+			}
+
+			origins[value] = append(origins[value], sources[i].name)
+		}
+	}
+
+	sort.Sort(values)
+
+	return values, origins
+}
+
+// withOrigins adds the servers a completion item was found on to its detail
+func withOrigins(detail string, origins []string) string {
+	if len(origins) == 0 {
+		return detail
+	}
+
+	from := "from " + strings.Join(origins, ", ")
+
+	if detail == "" {
+		return from
+	}
+
+	return detail + " (" + from + ")"
+}
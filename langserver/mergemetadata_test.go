@@ -0,0 +1,77 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestMergeMetadata checks that the metric names of several servers are merged and tagged with their origin
+func TestMergeMetadata(*testing.T) {
+	newProm := func(names string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+
+			if r.URL.Path == "/api/v1/label/__name__/values" {
+				fmt.Fprintf(w, `{"status":"success","data":[%s]}`, names)
+				return
+			}
+
+			fmt.Fprint(w, `{"status":"success","data":{}}`)
+		}))
+	}
+
+	prom, shard := newProm(`"http_requests_total","up"`), newProm(`"http_request_duration_seconds_bucket","up"`)
+	defer prom.Close()
+	defer shard.Close()
+
+	h, err := NewHeadlessServer(context.Background(), &Config{
+		PrometheusURL:     prom.URL,
+		PrometheusServers: map[string]string{"shard-b": shard.URL},
+		MergeMetadata:     []string{"shard-b"},
+	}, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	const query = `http_`
+
+	if err := h.AddDocument("merged.promql", "promql", query); err != nil {
+		panic(err)
+	}
+
+	completions, err := h.Completion("merged.promql", len(query))
+	if err != nil {
+		panic(err)
+	}
+
+	details := make(map[string]string)
+	for _, item := range completions.Items {
+		details[item.Label] = item.Detail
+	}
+
+	if details["http_requests_total"] != "from default" || details["http_request_duration_seconds_bucket"] != "from shard-b" {
+		panic(fmt.Sprintf("expected the metric names of both servers with their origin, got %v", details))
+	}
+
+	values, origins := h.server.mergedLabelValues(context.Background(), "merged.promql", nil, "", "__name__")
+	if fmt.Sprint(values) != "[http_request_duration_seconds_bucket http_requests_total up]" || fmt.Sprint(origins["up"]) != "[default shard-b]" {
+		panic(fmt.Sprintf("expected the merged metric names, got %v, %v", values, origins))
+	}
+}
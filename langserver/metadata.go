@@ -0,0 +1,370 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/prometheus-community/promql-langserver/langserver/cache"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+// metadataChangedMethod is the custom notification that tells the client that the metadata
+// refreshed in the background changed, e.g. to refresh completion lists it shows
+const metadataChangedMethod = "promql/metadataChanged"
+
+const (
+	// metadataRefreshJitter is the fraction the refresh interval varies by, so that the servers
+	// of many clients don't poll the Prometheus server at the same time
+	metadataRefreshJitter = 0.1
+	// metadataMaxBackoff limits how long refreshing is delayed after repeated failures
+	metadataMaxBackoff = 15 * time.Minute
+)
+
+// metricMetadata is an entry of the /api/v1/metadata response
+type metricMetadata struct {
+	Type string `json:"type"`
+	Help string `json:"help"`
+	Unit string `json:"unit"`
+}
+
+// metadataSnapshot is the metadata of the Prometheus server fetched by the background refresher
+type metadataSnapshot struct {
+	url         string
+	metricNames model.LabelValues
+	labelNames  []string
+	// metadata is empty if the Prometheus server doesn't serve /api/v1/metadata
+	metadata map[string][]metricMetadata
+}
+
+// metadataChangedParams are the params of the promql/metadataChanged notification
+type metadataChangedParams struct {
+	PrometheusURL string `json:"prometheusURL"`
+	Metrics       int    `json:"metrics"`
+	Labels        int    `json:"labels"`
+}
+
+// metadataRefreshInterval returns the interval of the metadata_refresh_interval option,
+// or 0 if metadata is fetched on demand
+func (c *Config) metadataRefreshInterval() (time.Duration, error) {
+	if c.MetadataRefreshInterval == "" {
+		return 0, nil
+	}
+
+	interval, err := cache.ParseDuration(c.MetadataRefreshInterval)
+	if err != nil {
+		return 0, errors.Wrap(err, "invalid metadata_refresh_interval")
+	}
+
+	return interval, nil
+}
+
+// metadataKey identifies the metadata refreshed in the background
+type metadataKey struct {
+	datasourceKey
+	interval time.Duration
+}
+
+// metadataRefresher refreshes the metadata of a Prometheus server in the background. It is shared by
+// all sessions requesting the same server with the same credentials and interval, so that they don't
+// poll the server separately and hold the same snapshot instead of a copy each.
+type metadataRefresher struct {
+	key    metadataKey
+	client api.Client
+	cancel func()
+
+	mu          sync.Mutex
+	snapshot    *metadataSnapshot
+	subscribers map[*metadataSubscription]bool
+}
+
+// metadataSubscription is the use of a metadataRefresher by a session
+type metadataSubscription struct {
+	s *server
+}
+
+// metadataRefreshers holds the running refreshers by key.
+// It is shared by all sessions of the process, e.g. all clients of a TCP server.
+var metadataRefreshers = struct { // nolint: gochecknoglobals
+	sync.Mutex
+	entries map[metadataKey]*metadataRefresher
+}{entries: make(map[metadataKey]*metadataRefresher)}
+
+// startMetadataRefresh (re)starts refreshing the metadata of the Prometheus server in the background,
+// if metadata_refresh_interval is set. The previous snapshot is dropped.
+func (s *server) startMetadataRefresh() error {
+	interval, err := s.getConfig().metadataRefreshInterval()
+
+	s.metadataMu.Lock()
+	defer s.metadataMu.Unlock()
+
+	if s.stopMetadataRefresh != nil {
+		s.stopMetadataRefresh()
+		s.stopMetadataRefresh = nil
+	}
+
+	s.metadata = nil
+
+	if err != nil || interval <= 0 {
+		return err
+	}
+
+	s.prometheusMu.Lock()
+	client, url, mode := s.prometheus, s.PrometheusURL, s.prometheusMode
+	s.prometheusMu.Unlock()
+
+	if client == nil || mode != "" {
+		return nil
+	}
+
+	// The refresher can outlive this session, so it doesn't record its requests in the status of the session
+	if sc, ok := client.(statusClient); ok {
+		client = sc.Client
+	}
+
+	sub := &metadataSubscription{s: s}
+	r := subscribeMetadata(metadataKey{s.datasourceKey(url), interval}, client, sub)
+
+	r.mu.Lock()
+	s.metadata = r.snapshot
+	r.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(s.lifetime)
+
+	go func() {
+		<-ctx.Done()
+		r.unsubscribe(sub)
+	}()
+
+	s.stopMetadataRefresh = func() {
+		cancel()
+		r.unsubscribe(sub)
+	}
+
+	return nil
+}
+
+// subscribeMetadata adds a subscription to the refresher of key, which is started if there is none yet
+func subscribeMetadata(key metadataKey, client api.Client, sub *metadataSubscription) *metadataRefresher {
+	metadataRefreshers.Lock()
+	defer metadataRefreshers.Unlock()
+
+	r, ok := metadataRefreshers.entries[key]
+	if !ok {
+		ctx, cancel := context.WithCancel(context.Background())
+
+		r = &metadataRefresher{
+			key:         key,
+			client:      client,
+			cancel:      cancel,
+			subscribers: make(map[*metadataSubscription]bool),
+		}
+
+		metadataRefreshers.entries[key] = r
+
+		go r.run(ctx)
+	}
+
+	r.mu.Lock()
+	r.subscribers[sub] = true
+	r.mu.Unlock()
+
+	return r
+}
+
+// unsubscribe removes a subscription, the refresher is stopped when its last subscription is removed
+func (r *metadataRefresher) unsubscribe(sub *metadataSubscription) {
+	metadataRefreshers.Lock()
+	defer metadataRefreshers.Unlock()
+
+	r.mu.Lock()
+	delete(r.subscribers, sub)
+	unused := len(r.subscribers) == 0
+	r.mu.Unlock()
+
+	if !unused {
+		return
+	}
+
+	r.cancel()
+
+	if metadataRefreshers.entries[r.key] == r {
+		delete(metadataRefreshers.entries, r.key)
+	}
+}
+
+// sessions returns the sessions subscribed to the refresher
+func (r *metadataRefresher) sessions() []*server {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ret := make([]*server, 0, len(r.subscribers))
+
+	for sub := range r.subscribers {
+		ret = append(ret, sub.s)
+	}
+
+	return ret
+}
+
+// run fetches the metadata every interval until ctx is done.
+// Failed refreshes are retried with exponential backoff.
+func (r *metadataRefresher) run(ctx context.Context) {
+	failures := 0
+
+	for {
+		snapshot, warnings, err := fetchMetadata(ctx, r.client, r.key.url)
+
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err != nil:
+			failures++
+
+			for _, s := range r.sessions() {
+				s.reportBackendError(err)
+			}
+		default:
+			failures = 0
+
+			r.mu.Lock()
+			r.snapshot = snapshot
+			r.mu.Unlock()
+
+			for _, s := range r.sessions() {
+				s.reportWarnings(warnings)
+				s.setMetadata(snapshot)
+			}
+		}
+
+		timer := time.NewTimer(refreshDelay(r.key.interval, failures))
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// refreshDelay returns the time until the next refresh, doubling the interval for every failure
+// up to metadataMaxBackoff, or the interval if it is longer
+func refreshDelay(interval time.Duration, failures int) time.Duration {
+	delay := interval
+
+	for i := 0; i < failures && delay < metadataMaxBackoff; i++ {
+		delay *= 2
+	}
+
+	if failures > 0 && delay > metadataMaxBackoff && interval < metadataMaxBackoff {
+		delay = metadataMaxBackoff
+	}
+
+	jitter := (rand.Float64()*2 - 1) * metadataRefreshJitter // nolint: gosec
+
+	return delay + time.Duration(jitter*float64(delay))
+}
+
+// fetchMetadata requests the metric names, label names and metric metadata from the Prometheus server at url
+func fetchMetadata(ctx context.Context, client api.Client, url string) (*metadataSnapshot, v1.Warnings, error) {
+	promAPI := v1.NewAPI(client)
+
+	metricNames, warnings, err := promAPI.LabelValues(ctx, "__name__")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	labelNames, labelWarnings, err := promAPI.LabelNames(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	warnings = append(warnings, labelWarnings...)
+
+	ret := &metadataSnapshot{url: url, metricNames: metricNames, labelNames: labelNames}
+
+	// Older Prometheus servers don't have the metadata API, the names are still useful without it
+	resp, body, err := doProbe(ctx, client, client.URL("/api/v1/metadata", nil))
+	if err == nil && resp.StatusCode == http.StatusOK {
+		var response struct {
+			Data map[string][]metricMetadata `json:"data"`
+		}
+
+		if json.Unmarshal(body, &response) == nil {
+			ret.metadata = response.Data
+		}
+	}
+
+	return ret, warnings, nil
+}
+
+// setMetadata replaces the metadata snapshot and notifies the client if it changed
+func (s *server) setMetadata(snapshot *metadataSnapshot) {
+	s.metadataMu.Lock()
+	changed := !reflect.DeepEqual(s.metadata, snapshot)
+	s.metadata = snapshot
+	s.metadataMu.Unlock()
+
+	if !changed || snapshot == nil || s.Conn == nil {
+		return
+	}
+
+	params := &metadataChangedParams{
+		PrometheusURL: snapshot.url,
+		Metrics:       len(snapshot.metricNames),
+		Labels:        len(snapshot.labelNames),
+	}
+
+	s.notifications.notify(metadataChangedMethod, func() {
+		// nolint: errcheck
+		s.Conn.Notify(s.lifetime, metadataChangedMethod, params)
+	})
+}
+
+// metadataFor returns the metadata snapshot if it belongs to the Prometheus server a document is mapped to,
+// or nil if metadata has to be fetched on demand
+func (s *server) metadataFor(uri protocol.DocumentURI) *metadataSnapshot {
+	s.metadataMu.RLock()
+	snapshot := s.metadata
+	s.metadataMu.RUnlock()
+
+	if snapshot == nil || snapshot.url != s.getPrometheusURLFor(uri) {
+		return nil
+	}
+
+	return snapshot
+}
+
+// help returns the help text of a metric, or an empty string if there is no metadata for it
+func (m *metadataSnapshot) help(metric string) string {
+	for _, entry := range m.metadata[metric] {
+		if entry.Help != "" {
+			return entry.Help
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,202 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestMetadataRefresh checks that completion uses the metadata refreshed in the background
+// and that failed refreshes are retried with backoff
+func TestMetadataRefresh(*testing.T) {
+	var nameRequests int32
+
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.URL.Path {
+		case "/api/v1/label/__name__/values":
+			atomic.AddInt32(&nameRequests, 1)
+			fmt.Fprint(w, `{"status":"success","data":["http_requests_total"]}`)
+		case "/api/v1/labels":
+			fmt.Fprint(w, `{"status":"success","data":["__name__","handler"]}`)
+		case "/api/v1/metadata":
+			fmt.Fprint(w, `{"status":"success","data":{"http_requests_total":[{"type":"counter","help":"Total HTTP requests.","unit":""}]}}`)
+		default:
+			fmt.Fprint(w, `{"status":"success","data":{}}`)
+		}
+	}))
+	defer prom.Close()
+
+	h, err := NewHeadlessServer(context.Background(), &Config{PrometheusURL: prom.URL, MetadataRefreshInterval: "1h"}, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	for i := 0; h.server.metadataFor("query.promql") == nil; i++ {
+		if i == 100 {
+			panic("expected the metadata to be fetched in the background")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	const query = `http_req`
+
+	if err := h.AddDocument("query.promql", "promql", query); err != nil {
+		panic(err)
+	}
+
+	completions, err := h.Completion("query.promql", len(query))
+	if err != nil {
+		panic(err)
+	}
+
+	found := false
+
+	for _, item := range completions.Items {
+		found = found || (item.Label == "http_requests_total" && item.Detail == "Total HTTP requests.")
+	}
+
+	if !found {
+		panic(fmt.Sprintf("expected the metric with its help text among the completions, got %v", completions.Items))
+	}
+
+	if n := atomic.LoadInt32(&nameRequests); n != 1 {
+		panic(fmt.Sprintf("expected completion not to request the metric names again, got %d requests", n))
+	}
+
+	for _, tc := range []struct {
+		interval time.Duration
+		failures int
+		expected time.Duration
+	}{
+		{interval: time.Minute, expected: time.Minute},
+		{interval: time.Minute, failures: 2, expected: 4 * time.Minute},
+		{interval: time.Minute, failures: 10, expected: metadataMaxBackoff},
+		{interval: time.Hour, failures: 3, expected: time.Hour},
+	} {
+		delay := refreshDelay(tc.interval, tc.failures)
+
+		if delay < time.Duration(float64(tc.expected)*0.9) || delay > time.Duration(float64(tc.expected)*1.1) {
+			panic(fmt.Sprintf("expected a delay of about %v for %d failures, got %v", tc.expected, tc.failures, delay))
+		}
+	}
+
+	if !strings.Contains(fmt.Sprint((&Config{MetadataRefreshInterval: "often"}).metadataRefreshInterval()), "invalid") {
+		panic("expected invalid intervals to be rejected")
+	}
+}
+
+// TestSharedMetadataRefresh checks that sessions connecting to the same Prometheus server share the refresher
+// and its snapshot, and that the refresher stops with the last session
+func TestSharedMetadataRefresh(*testing.T) {
+	var nameRequests int32
+
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.URL.Path {
+		case "/api/v1/label/__name__/values":
+			atomic.AddInt32(&nameRequests, 1)
+			fmt.Fprint(w, `{"status":"success","data":["http_requests_total"]}`)
+		case "/api/v1/labels":
+			fmt.Fprint(w, `{"status":"success","data":["__name__"]}`)
+		default:
+			fmt.Fprint(w, `{"status":"success","data":{}}`)
+		}
+	}))
+	defer prom.Close()
+
+	config := &Config{PrometheusURL: prom.URL, MetadataRefreshInterval: "1h"}
+
+	var sessions []HeadlessServer
+
+	for i := 0; i < 3; i++ {
+		h, err := NewHeadlessServer(context.Background(), config, nil)
+		if err != nil {
+			panic(err)
+		}
+
+		sessions = append(sessions, h)
+	}
+
+	for i := 0; sessions[0].server.metadataFor("query.promql") == nil; i++ {
+		if i == 100 {
+			panic("expected the metadata to be fetched in the background")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// A session started after the first refresh gets the snapshot right away
+	late, err := NewHeadlessServer(context.Background(), config, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	sessions = append(sessions, late)
+
+	for i := 0; i < 100; i++ {
+		shared := true
+
+		for _, h := range sessions {
+			shared = shared && h.server.metadataFor("query.promql") == sessions[0].server.metadataFor("query.promql")
+		}
+
+		if shared {
+			break
+		}
+
+		if i == 99 {
+			panic("expected all sessions to share the metadata snapshot")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if n := atomic.LoadInt32(&nameRequests); n != 1 {
+		panic(fmt.Sprintf("expected a single refresher for all sessions, got %d requests for the metric names", n))
+	}
+
+	key := metadataKey{sessions[0].server.datasourceKey(prom.URL), time.Hour}
+
+	for _, h := range sessions {
+		h.Close()
+	}
+
+	for i := 0; ; i++ {
+		metadataRefreshers.Lock()
+		_, running := metadataRefreshers.entries[key]
+		metadataRefreshers.Unlock()
+
+		if !running {
+			break
+		}
+
+		if i == 100 {
+			panic("expected the refresher to stop with the last session")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/jsonrpc2"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// NonstandardRequest is required by the protocol.Server interface
+//
+// It handles requests that are not part of the protocol version implemented by
+// the protocol package, i.e. newer protocol features and custom extensions.
+func (s *server) NonstandardRequest(ctx context.Context, method string, params interface{}) (interface{}, error) {
+	switch method {
+	case "textDocument/inlayHint":
+		var p inlayHintParams
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+
+		return s.InlayHint(ctx, &p)
+	case "textDocument/semanticTokens/full":
+		var p semanticTokensParams
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+
+		return s.SemanticTokensFull(ctx, &p)
+	case "textDocument/semanticTokens/range":
+		var p semanticTokensRangeParams
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+
+		return s.SemanticTokensRange(ctx, &p)
+	case statusMethod:
+		return s.getStatus(), nil
+	case setTraceMethod:
+		var p protocol.SetTraceParams
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+
+		return nil, s.SetTraceNotification(ctx, &p)
+	case reloadConfigurationMethod:
+		return nil, s.reloadConfig()
+	default:
+		return nil, notImplemented(method)
+	}
+}
+
+// decodeParams converts the generic params of a NonstandardRequest into the given type
+func decodeParams(params interface{}, v interface{}) error {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return jsonrpc2.NewErrorf(jsonrpc2.CodeInvalidParams, "%v", err)
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return jsonrpc2.NewErrorf(jsonrpc2.CodeInvalidParams, "%v", err)
+	}
+
+	return nil
+}
+
+// registerCapabilities uses dynamic registration to announce capabilities that
+// cannot be expressed in the protocol.ServerCapabilities struct.
+// Clients that don't support them will reject the registration, which is ignored.
+func (s *server) registerCapabilities(ctx context.Context) {
+	documentSelector := []protocol.DocumentFilter{
+		{Language: "yaml"},
+		{Language: "promql"},
+	}
+
+	err := s.client.RegisterCapability(ctx, &protocol.RegistrationParams{
+		Registrations: []protocol.Registration{
+			{
+				ID:     "promql-lsp-inlay-hints",
+				Method: "textDocument/inlayHint",
+				RegisterOptions: map[string]interface{}{
+					"documentSelector": documentSelector,
+				},
+			},
+			{
+				ID:     "promql-lsp-semantic-tokens",
+				Method: "textDocument/semanticTokens",
+				RegisterOptions: map[string]interface{}{
+					"documentSelector": documentSelector,
+					"legend": map[string]interface{}{
+						"tokenTypes":     semanticTokenTypes,
+						"tokenModifiers": semanticTokenModifiers,
+					},
+					"full":  true,
+					"range": true,
+				},
+			},
+		},
+	})
+	if err != nil {
+		// nolint: errcheck
+		s.client.LogMessage(ctx, &protocol.LogMessageParams{
+			Type:    protocol.Info,
+			Message: "Dynamic capability registration failed: " + err.Error(),
+		})
+	}
+
+	if s.getConfig().DemoMode {
+		return
+	}
+
+	// Registered separately, so that clients which reject the registrations above still report changes of rule files
+	err = s.client.RegisterCapability(ctx, &protocol.RegistrationParams{
+		Registrations: []protocol.Registration{
+			{
+				ID:     "promql-lsp-watched-files",
+				Method: "workspace/didChangeWatchedFiles",
+				RegisterOptions: protocol.DidChangeWatchedFilesRegistrationOptions{
+					Watchers: []protocol.FileSystemWatcher{{GlobPattern: "**/*.{yml,yaml,prom}"}},
+				},
+			},
+		},
+	})
+	if err != nil {
+		// nolint: errcheck
+		s.client.LogMessage(ctx, &protocol.LogMessageParams{
+			Type:    protocol.Info,
+			Message: "Registering file watchers failed: " + err.Error(),
+		})
+	}
+}
@@ -26,11 +26,6 @@ func notImplemented(method string) *jsonrpc2.Error {
 	return err
 }
 
-// DidChangeWorkspaceFolders is required by the protocol.Server interface
-func (s *server) DidChangeWorkspaceFolders(_ context.Context, _ *protocol.DidChangeWorkspaceFoldersParams) error {
-	return notImplemented("DidChangeWorkspaceFolders")
-}
-
 // DidSave is required by the protocol.Server interface
 func (s *server) DidSave(_ context.Context, _ *protocol.DidSaveTextDocumentParams) error {
 	return notImplemented("DidSave")
@@ -41,11 +36,6 @@ func (s *server) WillSave(_ context.Context, _ *protocol.WillSaveTextDocumentPar
 	return notImplemented("WillSave")
 }
 
-// DidChangeWatchedFiles is required by the protocol.Server interface
-func (s *server) DidChangeWatchedFiles(_ context.Context, _ *protocol.DidChangeWatchedFilesParams) error {
-	return notImplemented("DidChangeWatchedFiles")
-}
-
 // Progress is required by the protocol.Server interface
 func (s *server) Progress(_ context.Context, _ *protocol.ProgressParams) error {
 	return notImplemented("Progress")
@@ -56,11 +46,6 @@ func (s *server) SelectionRange(_ context.Context, _ *protocol.SelectionRangePar
 	return nil, notImplemented("SelectionRange")
 }
 
-// SetTraceNotification is required by the protocol.Server interface
-func (s *server) SetTraceNotification(_ context.Context, _ *protocol.SetTraceParams) error {
-	return notImplemented("SetTraceNotification")
-}
-
 // LogTraceNotification is required by the protocol.Server interface
 func (s *server) LogTraceNotification(_ context.Context, _ *protocol.LogTraceParams) error {
 	return notImplemented("LogTraceNotification")
@@ -106,71 +91,21 @@ func (s *server) Resolve(_ context.Context, _ *protocol.CompletionItem) (*protoc
 	return nil, notImplemented("Resolve")
 }
 
-// References is required by the protocol.Server interface
-func (s *server) References(_ context.Context, _ *protocol.ReferenceParams) ([]protocol.Location, error) {
-	return nil, notImplemented("References")
-}
-
 // DocumentHighlight is required by the protocol.Server interface
 func (s *server) DocumentHighlight(_ context.Context, _ *protocol.DocumentHighlightParams) ([]protocol.DocumentHighlight, error) {
 	return nil, notImplemented("DocumentHighlight")
 }
 
-// DocumentSymbol is required by the protocol.Server interface
-func (s *server) DocumentSymbol(_ context.Context, _ *protocol.DocumentSymbolParams) ([]protocol.DocumentSymbol, error) {
-	return nil, notImplemented("DocumentSymbol")
-}
-
-// CodeAction is required by the protocol.Server interface
-func (s *server) CodeAction(_ context.Context, _ *protocol.CodeActionParams) ([]protocol.CodeAction, error) {
-	return nil, notImplemented("CodeAction")
-}
-
-// NonstandardRequest is required by the protocol.Server interface
-func (s *server) NonstandardRequest(_ context.Context, _ string, _ interface{}) (interface{}, error) {
-	return nil, notImplemented("NonstandardRequest")
-}
-
-// PrepareRename is required by the protocol.Server interface
-func (s *server) PrepareRename(_ context.Context, _ *protocol.PrepareRenameParams) (interface{}, error) {
-	return nil, notImplemented("PrepareRename")
-}
-
-// Symbol is required by the protocol.Server interface
-func (s *server) Symbol(_ context.Context, _ *protocol.WorkspaceSymbolParams) ([]protocol.SymbolInformation, error) {
-	return nil, notImplemented("Symbol")
-}
-
-// CodeLens is required by the protocol.Server interface
-func (s *server) CodeLens(_ context.Context, _ *protocol.CodeLensParams) ([]protocol.CodeLens, error) {
-	return nil, notImplemented("CodeLens")
-}
-
 // ResolveCodeLens is required by the protocol.Server interface
 func (s *server) ResolveCodeLens(_ context.Context, _ *protocol.CodeLens) (*protocol.CodeLens, error) {
 	return nil, notImplemented("ResolveCodeLens")
 }
 
-// Formatting is required by the protocol.Server interface
-func (s *server) Formatting(_ context.Context, _ *protocol.DocumentFormattingParams) ([]protocol.TextEdit, error) {
-	return nil, notImplemented("Formatting")
-}
-
-// RangeFormatting is required by the protocol.Server interface
-func (s *server) RangeFormatting(_ context.Context, _ *protocol.DocumentRangeFormattingParams) ([]protocol.TextEdit, error) {
-	return nil, notImplemented("RangeFormatting")
-}
-
 // OnTypeFormatting is required by the protocol.Server interface
 func (s *server) OnTypeFormatting(_ context.Context, _ *protocol.DocumentOnTypeFormattingParams) ([]protocol.TextEdit, error) {
 	return nil, notImplemented("OnTypeFormatting")
 }
 
-// Rename is required by the protocol.Server interface
-func (s *server) Rename(_ context.Context, _ *protocol.RenameParams) (*protocol.WorkspaceEdit, error) {
-	return nil, notImplemented("Rename")
-}
-
 // DocumentLink is required by the protocol.Server interface
 func (s *server) DocumentLink(_ context.Context, _ *protocol.DocumentLinkParams) ([]protocol.DocumentLink, error) {
 	return nil, notImplemented("DocumentLink")
@@ -180,8 +115,3 @@ func (s *server) DocumentLink(_ context.Context, _ *protocol.DocumentLinkParams)
 func (s *server) ResolveDocumentLink(_ context.Context, _ *protocol.DocumentLink) (*protocol.DocumentLink, error) {
 	return nil, notImplemented("ResolveDocumentLink")
 }
-
-// ExecuteCommand is required by the protocol.Server interface
-func (s *server) ExecuteCommand(_ context.Context, _ *protocol.ExecuteCommandParams) (interface{}, error) {
-	return nil, notImplemented("ExecuteCommand")
-}
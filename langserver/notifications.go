@@ -0,0 +1,79 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import "sync"
+
+// notificationQueue sends diagnostics and custom notifications to the client from a single goroutine.
+//
+// jsonrpc2.Conn.Notify only returns once a message has been written to the stream, so a client that is
+// slow to read, e.g. a suspended editor, blocks the sender. Notifications that are sent in the meantime
+// are queued, and a newer notification replaces a queued one with the same key, e.g. the diagnostics of
+// an older version of the same document. The queue therefore never holds more than one notification per
+// document and custom method, instead of a growing number of goroutines waiting for the stream.
+type notificationQueue struct {
+	// pending maps the keys of the queued notifications to the functions sending them, order is the
+	// order they were first queued in
+	pending map[string]func()
+	order   []string
+	// sending is set while a goroutine sends the queued notifications
+	sending bool
+	mu      sync.Mutex
+}
+
+// notify queues a notification. send replaces the queued notification with the same key, if there is one.
+func (q *notificationQueue) notify(key string, send func()) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.pending == nil {
+		q.pending = make(map[string]func())
+	}
+
+	if _, ok := q.pending[key]; !ok {
+		q.order = append(q.order, key)
+	}
+
+	q.pending[key] = send
+
+	if !q.sending {
+		q.sending = true
+
+		go q.drain()
+	}
+}
+
+// drain sends the queued notifications until the queue is empty
+func (q *notificationQueue) drain() {
+	for {
+		q.mu.Lock()
+
+		if len(q.order) == 0 {
+			q.sending = false
+			q.mu.Unlock()
+
+			return
+		}
+
+		key := q.order[0]
+		send := q.pending[key]
+
+		q.order = q.order[1:]
+		delete(q.pending, key)
+
+		q.mu.Unlock()
+
+		send()
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"fmt"
+	"testing"
+)
+
+// TestNotificationQueue checks that notifications queued while the client is slow to read are collapsed per key
+func TestNotificationQueue(*testing.T) {
+	var q notificationQueue
+
+	sent := make(chan string, 10)
+	started := make(chan struct{})
+	blocked := make(chan struct{})
+
+	q.notify("a", func() {
+		close(started)
+		<-blocked
+		sent <- "a1"
+	})
+
+	// The first notification is being written, the others have to wait
+	<-started
+
+	for _, n := range []struct{ key, name string }{{"a", "a2"}, {"b", "b1"}, {"a", "a3"}} {
+		name := n.name
+		q.notify(n.key, func() { sent <- name })
+	}
+
+	q.notify("c", func() {
+		sent <- "c1"
+		close(sent)
+	})
+
+	close(blocked)
+
+	var result []string
+	for name := range sent {
+		result = append(result, name)
+	}
+
+	if fmt.Sprint(result) != "[a1 a3 b1 c1]" {
+		panic(fmt.Sprintf("expected the superseded notification to be dropped, got %v", result))
+	}
+}
@@ -0,0 +1,276 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"fmt"
+	"go/token"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus-community/promql-langserver/langserver/cache"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/promql"
+)
+
+// timeFactors are the products numbers of seconds are written as, from the largest unit to the smallest
+var timeFactors = []struct { // nolint: gochecknoglobals
+	seconds float64
+	product string
+}{
+	{7 * 24 * 60 * 60, "7 * 24 * 60 * 60"},
+	{24 * 60 * 60, "24 * 60 * 60"},
+	{60 * 60, "60 * 60"},
+	{60, "60"},
+}
+
+// binaryPrefixes are the IEC prefixes for powers of 1024
+var binaryPrefixes = []string{"", "Ki", "Mi", "Gi", "Ti", "Pi", "Ei"} // nolint: gochecknoglobals
+
+// siPrefixes are the SI prefixes for powers of 1000
+var siPrefixes = []string{"", "k", "M", "G", "T", "P", "E"} // nolint: gochecknoglobals
+
+// numberRewrite is a more readable way to write a number literal
+type numberRewrite struct {
+	Title   string
+	NewText string
+	// Product is set if NewText is a multiplication, which might need to be parenthesized
+	Product bool
+}
+
+// isIntegral returns whether a float is a whole number that can be represented exactly
+func isIntegral(val float64) bool {
+	return val == math.Trunc(val) && math.Abs(val) < 1<<53
+}
+
+// numberRewrites returns the alternative representations of a number literal. Decompositions into
+// units of time are offered for numbers of seconds, or if the number is a multiple of an hour.
+func numberRewrites(val float64, text string, seconds bool) []numberRewrite {
+	var ret []numberRewrite
+
+	product := func(factor float64, unit string) string {
+		if n := val / factor; n != 1 {
+			return fmt.Sprintf("%s * %s", strconv.FormatFloat(n, 'f', -1, 64), unit)
+		}
+
+		return unit
+	}
+
+	if isIntegral(val) && val >= 60 && math.Mod(val, 60) == 0 && (seconds || math.Mod(val, 60*60) == 0) {
+		for _, f := range timeFactors {
+			if math.Mod(val, f.seconds) == 0 {
+				newText := product(f.seconds, f.product)
+
+				ret = append(ret, numberRewrite{
+					Title:   fmt.Sprintf("Write %s as %s (%s)", text, newText, model.Duration(time.Duration(val)*time.Second)),
+					NewText: newText,
+					Product: true,
+				})
+
+				break
+			}
+		}
+	}
+
+	if isIntegral(val) && val >= 1024 && !seconds {
+		for k := len(binaryPrefixes) - 1; k > 0; k-- {
+			factor := math.Pow(1024, float64(k))
+			if math.Mod(val, factor) != 0 {
+				continue
+			}
+
+			newText := product(factor, strings.TrimSuffix(strings.Repeat("1024 * ", k), " * "))
+
+			ret = append(ret, numberRewrite{
+				Title:   fmt.Sprintf("Write %s as %s (%s)", text, newText, formatWithPrefix(val, 1024, binaryPrefixes)+"B"),
+				NewText: newText,
+				Product: true,
+			})
+
+			break
+		}
+	}
+
+	if scientific := scientificNotation(val); math.Abs(val) >= 1e6 && len(scientific) < len(text) {
+		ret = append(ret, numberRewrite{
+			Title:   fmt.Sprintf("Write %s as %s", text, scientific),
+			NewText: scientific,
+		})
+	}
+
+	if decimal := strconv.FormatFloat(val, 'f', -1, 64); decimal != text && math.Abs(val) < 1e15 && !math.IsInf(val, 0) && !math.IsNaN(val) {
+		ret = append(ret, numberRewrite{
+			Title:   fmt.Sprintf("Write %s as %s", text, decimal),
+			NewText: decimal,
+		})
+	}
+
+	return ret
+}
+
+// scientificNotation formats a number in the scientific notation, e.g. 1e9
+func scientificNotation(val float64) string {
+	s := strconv.FormatFloat(val, 'e', -1, 64)
+
+	mantissa, exponent := s, ""
+	if i := strings.IndexByte(s, 'e'); i >= 0 {
+		mantissa, exponent = s[:i], s[i+1:]
+	}
+
+	exponent = strings.TrimPrefix(exponent, "+")
+
+	negative := strings.HasPrefix(exponent, "-")
+	exponent = strings.TrimLeft(strings.TrimPrefix(exponent, "-"), "0")
+
+	if exponent == "" {
+		return mantissa
+	}
+
+	if negative {
+		exponent = "-" + exponent
+	}
+
+	return mantissa + "e" + exponent
+}
+
+// formatWithPrefix formats a number with the largest fitting unit prefix, e.g. 1.5Ki
+func formatWithPrefix(val float64, base float64, prefixes []string) string {
+	i := 0
+	for math.Abs(val) >= base && i < len(prefixes)-1 {
+		val /= base
+		i++
+	}
+
+	return strconv.FormatFloat(val, 'g', 4, 64) + prefixes[i]
+}
+
+// numberDocs shows a number literal interpreted in different units
+func numberDocs(val float64) string {
+	var ret strings.Builder
+
+	fmt.Fprintf(&ret, "## %s\n\n", strconv.FormatFloat(val, 'f', -1, 64))
+
+	if val >= 0.001 && val < 1e12 {
+		d := time.Duration(val * float64(time.Second)).Round(time.Millisecond)
+		fmt.Fprintf(&ret, "* as seconds: %s\n", model.Duration(d))
+	}
+
+	fmt.Fprintf(&ret, "* as bytes: %sB\n", formatWithPrefix(val, 1024, binaryPrefixes))
+	fmt.Fprintf(&ret, "* with SI prefix: %s\n", formatWithPrefix(val, 1000, siPrefixes))
+
+	// Plausible unix timestamps between 2001 and 2128
+	if val >= 1e9 && val < 5e9 {
+		sec, frac := math.Modf(val)
+		fmt.Fprintf(&ret, "* as unix timestamp: %s\n", time.Unix(int64(sec), int64(frac*float64(time.Second))).UTC().Format(time.RFC3339))
+	}
+
+	return ret.String()
+}
+
+// isSecondsContext returns whether a number literal is likely a number of seconds,
+// i.e. it is combined with timestamps or metrics measured in seconds
+func isSecondsContext(path []promql.Node) bool {
+	for i := len(path) - 1; i >= 0; i-- {
+		bin, ok := path[i].(*promql.BinaryExpr)
+		if !ok {
+			continue
+		}
+
+		seconds := false
+
+		promql.Inspect(bin, func(node promql.Node, _ []promql.Node) error {
+			switch n := node.(type) {
+			case *promql.Call:
+				if n.Func.Name == "time" || n.Func.Name == "timestamp" {
+					seconds = true
+				}
+			case *promql.VectorSelector:
+				if strings.HasSuffix(n.Name, "_seconds") || strings.HasSuffix(n.Name, "_timestamp") {
+					seconds = true
+				}
+			}
+
+			return nil
+		})
+
+		return seconds
+	}
+
+	return false
+}
+
+// needsParentheses returns whether a product replacing a literal has to be parenthesized
+func needsParentheses(path []promql.Node) bool {
+	if len(path) == 0 {
+		return false
+	}
+
+	bin, ok := path[len(path)-1].(*promql.BinaryExpr)
+
+	return ok && (bin.Op == promql.DIV || bin.Op == promql.MOD || bin.Op == promql.POW)
+}
+
+// numberCodeActions offers to rewrite the number literals inside a range in more readable forms
+func numberCodeActions(doc *cache.DocumentHandle, rng protocol.Range) []protocol.CodeAction {
+	queries, err := doc.GetQueries()
+	if err != nil {
+		return nil
+	}
+
+	var ret []protocol.CodeAction
+
+	for _, q := range queries {
+		if q.Ast == nil {
+			continue
+		}
+
+		promql.Inspect(q.Ast, func(node promql.Node, path []promql.Node) error {
+			n, ok := node.(*promql.NumberLiteral)
+			if !ok || int(n.PosRange.End) > len(q.Content) {
+				return nil
+			}
+
+			litRange, err := tokenRange(doc, q.Pos+token.Pos(n.PosRange.Start), q.Pos+token.Pos(n.PosRange.End))
+			if err != nil || !rangesOverlap(litRange, rng) {
+				return nil
+			}
+
+			text := q.Content[n.PosRange.Start:n.PosRange.End]
+
+			for _, rewrite := range numberRewrites(n.Val, text, isSecondsContext(path)) {
+				newText := rewrite.NewText
+				if rewrite.Product && needsParentheses(path) {
+					newText = "(" + newText + ")"
+				}
+
+				ret = append(ret, protocol.CodeAction{
+					Title: rewrite.Title,
+					Kind:  protocol.RefactorRewrite,
+					Edit: protocol.WorkspaceEdit{
+						Changes: map[string][]protocol.TextEdit{
+							doc.GetURI(): {{Range: litRange, NewText: newText}},
+						},
+					},
+				})
+			}
+
+			return nil
+		})
+	}
+
+	return ret
+}
@@ -0,0 +1,105 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// TestNumberCodeActions checks the rewrites offered for number literals
+func TestNumberCodeActions(*testing.T) {
+	h, err := NewHeadlessServer(context.Background(), &Config{}, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{`time() - foo_timestamp > 86400`, "[24 * 60 * 60]"},
+		{`foo / 3600`, "[(60 * 60)]"},
+		{`foo > 7200`, "[2 * 60 * 60]"},
+		{`foo > 120`, "[]"},
+		{`foo > 1073741824`, "[1024 * 1024 * 1024]"},
+		{`foo > 1000000000`, "[1e9]"},
+		{`foo > 1e3`, "[1000]"},
+	}
+
+	for i, test := range tests {
+		uri := fmt.Sprintf("query%d.promql", i)
+
+		if err := h.AddDocument(uri, "promql", test.query); err != nil {
+			panic(err)
+		}
+
+		actions, err := h.server.CodeAction(context.Background(), &protocol.CodeActionParams{
+			TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+			Range: protocol.Range{
+				Start: protocol.Position{Line: 0, Character: 0},
+				End:   protocol.Position{Line: 0, Character: float64(len(test.query))},
+			},
+		})
+		if err != nil {
+			panic(err)
+		}
+
+		got := []string{}
+
+		for _, action := range actions {
+			if strings.HasPrefix(action.Title, "Write ") {
+				got = append(got, action.Edit.Changes[uri][0].NewText)
+			}
+		}
+
+		if fmt.Sprint(got) != test.expected {
+			panic(fmt.Sprintf("expected the rewrites %s for %q, got %v", test.expected, test.query, got))
+		}
+	}
+}
+
+// TestNumberHover checks that hovering a number literal shows it interpreted in different units
+func TestNumberHover(*testing.T) {
+	h, err := NewHeadlessServer(context.Background(), &Config{}, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	if err := h.AddDocument("query.promql", "promql", `foo > 1600000000`); err != nil {
+		panic(err)
+	}
+
+	hover, err := h.server.Hover(context.Background(), &protocol.HoverParams{
+		TextDocumentPositionParams: protocol.TextDocumentPositionParams{
+			TextDocument: protocol.TextDocumentIdentifier{URI: "query.promql"},
+			Position:     protocol.Position{Line: 0, Character: 8},
+		},
+	})
+	if err != nil || hover == nil {
+		panic(fmt.Sprintf("expected a hover for the number literal, got %v, %v", hover, err))
+	}
+
+	for _, expected := range []string{"as bytes: 1.49GiB", "with SI prefix: 1.6G", "as unix timestamp: 2020-09-13T12:26:40Z"} {
+		if !strings.Contains(hover.Contents.Value, expected) {
+			panic(fmt.Sprintf("expected the hover to contain %q, got %s", expected, hover.Contents.Value))
+		}
+	}
+}
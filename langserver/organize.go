@@ -0,0 +1,489 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"go/token"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/prometheus-community/promql-langserver/langserver/cache"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// ruleKeyOrder is the order the keys of a rule are sorted in, unknown keys are kept after them
+var ruleKeyOrder = map[string]int{
+	"record":      0,
+	"alert":       0,
+	"expr":        1,
+	"for":         2,
+	"labels":      3,
+	"annotations": 4,
+}
+
+// organizeCodeActions returns the source actions that organize the rules of the document
+// or of all rule files in the workspace. They are only computed if the client asks for source actions.
+func (s *server) organizeCodeActions(doc *cache.DocumentHandle, only []protocol.CodeActionKind) []protocol.CodeAction {
+	var ret []protocol.CodeAction
+
+	if requestedKind(only, protocol.SourceOrganizeImports) {
+		if edits := organizeRules(doc); len(edits) > 0 {
+			ret = append(ret, protocol.CodeAction{
+				Title: "Organize rules",
+				Kind:  protocol.SourceOrganizeImports,
+				Edit: protocol.WorkspaceEdit{
+					Changes: map[string][]protocol.TextEdit{
+						doc.GetURI(): edits,
+					},
+				},
+			})
+		}
+	}
+
+	if requestedKind(only, protocol.Source) {
+		changes := make(map[string][]protocol.TextEdit)
+
+		for _, uri := range append(s.workspace.ruleFiles(), protocol.DocumentURI(doc.GetURI())) {
+			if _, ok := changes[string(uri)]; ok {
+				continue
+			}
+
+			other, err := s.cache.GetDocument(uri)
+			if err != nil {
+				continue
+			}
+
+			if edits := organizeRules(other); len(edits) > 0 {
+				changes[string(uri)] = edits
+			}
+		}
+
+		if len(changes) > 0 {
+			ret = append(ret, protocol.CodeAction{
+				Title: "Organize rules in workspace",
+				Kind:  protocol.Source,
+				Edit:  protocol.WorkspaceEdit{Changes: changes},
+			})
+		}
+	}
+
+	return ret
+}
+
+// requestedKind checks whether the client explicitly asked for code actions of a kind
+func requestedKind(only []protocol.CodeActionKind, kind protocol.CodeActionKind) bool {
+	for _, k := range only {
+		if k == kind || strings.HasPrefix(string(kind), string(k)+".") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// offsetEdit is a change of the document content, given as byte offsets
+type offsetEdit struct {
+	start, end int
+	text       string
+}
+
+// organizeRules returns the edits that sort the rules of every group of a rule file by name,
+// order their keys like ruleKeyOrder and format their expressions. A rule is never moved
+// before a recording rule of the same group it depends on.
+// Comments directly above a rule move with it, blank lines between rules stay in place.
+func organizeRules(doc *cache.DocumentHandle) []protocol.TextEdit {
+	groups, err := doc.GetRuleGroups()
+	if err != nil || len(groups) == 0 {
+		return nil
+	}
+
+	content, err := doc.GetContent()
+	if err != nil {
+		return nil
+	}
+
+	base, err := doc.LineStartSafe(1)
+	if err != nil {
+		return nil
+	}
+
+	o := &ruleOrganizer{doc: doc, content: content, lineStarts: lineStarts(content)}
+
+	o.formatEdits = o.expressionEdits(groups)
+
+	var ret []protocol.TextEdit
+
+	for _, group := range groups {
+		edit, ok := o.organizeGroup(group)
+		if !ok || edit.text == content[edit.start:edit.end] {
+			continue
+		}
+
+		rng, err := tokenRange(doc, base+token.Pos(edit.start), base+token.Pos(edit.end))
+		if err != nil {
+			continue
+		}
+
+		ret = append(ret, protocol.TextEdit{Range: rng, NewText: edit.text})
+	}
+
+	return ret
+}
+
+// ruleOrganizer holds the state shared by the groups of a document while organizing it
+type ruleOrganizer struct {
+	doc     *cache.DocumentHandle
+	content string
+	// lineStarts are the byte offsets of all lines, followed by the length of the content
+	lineStarts []int
+	// formatEdits are the changes made by formatting the expressions of the rules
+	formatEdits []offsetEdit
+}
+
+// ruleBlock is the text of a rule, including the comments preceding it
+type ruleBlock struct {
+	rule *cache.Rule
+	// start and end are line indices, end is exclusive
+	start, end int
+	// dash is the column of the '-' starting the rule
+	dash int
+}
+
+// lineStarts returns the byte offsets of all lines of a text, followed by its length
+func lineStarts(content string) []int {
+	ret := []int{0}
+
+	for i, c := range content {
+		if c == '\n' && i+1 < len(content) {
+			ret = append(ret, i+1)
+		}
+	}
+
+	return append(ret, len(content))
+}
+
+// expressionEdits returns the formatting edits of the rule expressions, as byte offsets
+func (o *ruleOrganizer) expressionEdits(groups []*cache.RuleGroup) []offsetEdit {
+	var ret []offsetEdit
+
+	for _, group := range groups {
+		for _, rule := range group.Rules {
+			if rule.Query == nil {
+				continue
+			}
+
+			edit, ok := formatQuery(o.doc, o.content, rule.Query, protocol.FormattingOptions{})
+			if !ok {
+				continue
+			}
+
+			start, err := o.doc.ProtocolPositionToTokenPos(edit.Range.Start)
+			if err != nil {
+				continue
+			}
+
+			end, err := o.doc.ProtocolPositionToTokenPos(edit.Range.End)
+			if err != nil {
+				continue
+			}
+
+			ret = append(ret, offsetEdit{start: o.doc.ByteOffset(start), end: o.doc.ByteOffset(end), text: edit.NewText})
+		}
+	}
+
+	return ret
+}
+
+// line returns a line of the content, without the line break
+func (o *ruleOrganizer) line(i int) string {
+	return strings.TrimRight(o.content[o.lineStarts[i]:o.lineStarts[i+1]], "\r\n")
+}
+
+func (o *ruleOrganizer) numLines() int {
+	return len(o.lineStarts) - 1
+}
+
+// text returns the lines [start, end) with the formatting edits applied
+func (o *ruleOrganizer) text(start, end int) string {
+	from, to := o.lineStarts[start], o.lineStarts[end]
+
+	var b strings.Builder
+
+	for _, e := range o.formatEdits {
+		if e.start < from || e.end > to {
+			continue
+		}
+
+		b.WriteString(o.content[from:e.start])
+		b.WriteString(e.text)
+
+		from = e.end
+	}
+
+	b.WriteString(o.content[from:to])
+
+	ret := b.String()
+	if ret != "" && !strings.HasSuffix(ret, "\n") {
+		ret += "\n"
+	}
+
+	return ret
+}
+
+// organizeGroup returns the edit that organizes the rules of a group.
+// Groups whose rules aren't written as a block sequence of block mappings are left unchanged.
+func (o *ruleOrganizer) organizeGroup(group *cache.RuleGroup) (offsetEdit, bool) {
+	seq := cache.MappingValue(group.Node, "rules")
+	if seq == nil || seq.Style&yaml.FlowStyle != 0 || len(seq.Content) < 1 || len(seq.Content) != len(group.Rules) {
+		return offsetEdit{}, false
+	}
+
+	blocks := make([]*ruleBlock, len(group.Rules))
+
+	for i, rule := range group.Rules {
+		if rule.Node.Style&yaml.FlowStyle != 0 {
+			return offsetEdit{}, false
+		}
+
+		line := rule.Node.Line + group.LineOffset - 1
+		if line < 0 || line >= o.numLines() {
+			return offsetEdit{}, false
+		}
+
+		prefix := o.line(line)
+		if rule.Node.Column-1 > len(prefix) {
+			return offsetEdit{}, false
+		}
+
+		prefix = strings.TrimRight(prefix[:rule.Node.Column-1], " ")
+		if strings.TrimLeft(prefix, " ") != "-" {
+			return offsetEdit{}, false
+		}
+
+		block := &ruleBlock{rule: rule, start: line, dash: len(prefix) - 1}
+
+		// Comments directly above the rule belong to it
+		for block.start > 0 && (i == 0 || block.start-1 > blocks[i-1].start) {
+			above := o.line(block.start - 1)
+			if trimmed := strings.TrimLeft(above, " "); !strings.HasPrefix(trimmed, "#") || len(above)-len(trimmed) > block.dash {
+				break
+			}
+
+			block.start--
+		}
+
+		blocks[i] = block
+	}
+
+	for i, block := range blocks {
+		if i+1 < len(blocks) {
+			block.end = blocks[i+1].start
+		} else {
+			// The last rule ends at the first line that is indented less than its items
+			block.end = block.start + 1
+			for block.end < o.numLines() {
+				l := o.line(block.end)
+				if trimmed := strings.TrimLeft(l, " "); trimmed != "" && len(l)-len(trimmed) <= block.dash {
+					break
+				}
+
+				block.end++
+			}
+		}
+
+		// Trailing blank lines separate the rule from the next one and stay in place
+		for block.end > block.start+1 && strings.TrimSpace(o.line(block.end-1)) == "" {
+			block.end--
+		}
+	}
+
+	var b strings.Builder
+
+	order := sortRules(blocks)
+
+	for i, j := range order {
+		b.WriteString(o.blockText(blocks[j]))
+
+		if i+1 < len(blocks) {
+			b.WriteString(o.text(blocks[i].end, blocks[i+1].start))
+		}
+	}
+
+	last := blocks[len(blocks)-1]
+
+	text := b.String()
+	if end := o.lineStarts[last.end]; !strings.HasSuffix(o.content[:end], "\n") {
+		text = strings.TrimSuffix(text, "\n")
+	}
+
+	return offsetEdit{start: o.lineStarts[blocks[0].start], end: o.lineStarts[last.end], text: text}, true
+}
+
+// blockText returns the text of a rule with its keys ordered like ruleKeyOrder
+func (o *ruleOrganizer) blockText(block *ruleBlock) string {
+	node := block.rule.Node
+	lineOffset := block.rule.Group.LineOffset
+
+	type key struct {
+		name string
+		// line is the line of the key, start can be before it if the key is preceded by comments
+		line       int
+		start, end int
+	}
+
+	var keys []key
+
+	first := node.Line + lineOffset - 1
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		k := node.Content[i]
+
+		line := k.Line + lineOffset - 1
+		if k.Column != node.Column || (len(keys) > 0 && line <= keys[len(keys)-1].line) || line >= block.end {
+			// Keys that aren't on lines of their own can't be reordered
+			return o.text(block.start, block.end)
+		}
+
+		start := line
+
+		if len(keys) > 0 {
+			for start-1 > keys[len(keys)-1].line && strings.HasPrefix(strings.TrimLeft(o.line(start-1), " "), "#") {
+				start--
+			}
+
+			keys[len(keys)-1].end = start
+		}
+
+		keys = append(keys, key{name: k.Value, line: line, start: start})
+	}
+
+	if len(keys) == 0 {
+		return o.text(block.start, block.end)
+	}
+
+	keys[len(keys)-1].end = block.end
+
+	sort.SliceStable(keys, func(i, j int) bool {
+		return keyRank(keys[i].name) < keyRank(keys[j].name)
+	})
+
+	var b strings.Builder
+
+	b.WriteString(o.text(block.start, first))
+
+	for i, k := range keys {
+		text := o.text(k.start, k.end)
+
+		for strings.HasSuffix(text, "\n\n") {
+			text = strings.TrimSuffix(text, "\n")
+		}
+
+		// Move the '-' of the sequence item to the new first key
+		switch {
+		case i == 0 && k.line != first:
+			text = replaceColumn(text, k.line-k.start, block.dash, "-")
+		case i != 0 && k.line == first:
+			text = replaceColumn(text, 0, block.dash, " ")
+		}
+
+		b.WriteString(text)
+	}
+
+	return b.String()
+}
+
+// replaceColumn replaces the character at a column of a line of a text
+func replaceColumn(text string, line int, column int, replacement string) string {
+	offset := 0
+
+	for ; line > 0; line-- {
+		offset += strings.IndexByte(text[offset:], '\n') + 1
+	}
+
+	return text[:offset+column] + replacement + text[offset+column+1:]
+}
+
+func keyRank(name string) int {
+	if rank, ok := ruleKeyOrder[name]; ok {
+		return rank
+	}
+
+	return len(ruleKeyOrder)
+}
+
+// sortRules returns the order of the rules of a group sorted by name,
+// moving dependencies on recording rules of the same group before the rules using them
+func sortRules(blocks []*ruleBlock) []int {
+	recordedBy := make(map[string][]int)
+
+	for i, block := range blocks {
+		if block.rule.Record != "" {
+			recordedBy[block.rule.Record] = append(recordedBy[block.rule.Record], i)
+		}
+	}
+
+	placed := make([]bool, len(blocks))
+
+	ready := func(i int) bool {
+		for _, dep := range ruleDependencies(blocks[i].rule) {
+			for _, j := range recordedBy[dep] {
+				if j != i && !placed[j] {
+					return false
+				}
+			}
+		}
+
+		return true
+	}
+
+	less := func(i, j int) bool {
+		if a, b := blocks[i].rule.Name(), blocks[j].rule.Name(); a != b {
+			return a < b
+		}
+
+		return i < j
+	}
+
+	var ret []int
+
+	for len(ret) < len(blocks) {
+		next, fallback := -1, -1
+
+		for i := range blocks {
+			if placed[i] {
+				continue
+			}
+
+			if fallback == -1 || less(i, fallback) {
+				fallback = i
+			}
+
+			if ready(i) && (next == -1 || less(i, next)) {
+				next = i
+			}
+		}
+
+		// Rules in a cycle keep their relative order
+		if next == -1 {
+			next = fallback
+		}
+
+		placed[next] = true
+		ret = append(ret, next)
+	}
+
+	return ret
+}
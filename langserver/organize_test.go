@@ -0,0 +1,98 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// TestOrganizeRules checks that rules are sorted, their keys ordered and their expressions formatted
+func TestOrganizeRules(*testing.T) {
+	h, err := NewHeadlessServer(context.Background(), &Config{}, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	content := `groups:
+  - name: example
+    rules:
+      # Fires if the job is down
+      - expr: up{job="api"}==0
+        annotations:
+          summary: down
+        alert: JobDown
+        for: 5m
+
+      - record: job:errors:rate5m
+        expr: sum by (job) (rate(errors_total[5m]))
+      - alert: ErrorsHigh
+        expr: job:errors:rate5m > 1
+`
+
+	// ErrorsHigh has to stay after the recording rule it uses
+	expected := `groups:
+  - name: example
+    rules:
+      # Fires if the job is down
+      - alert: JobDown
+        expr: up{job="api"} == 0
+        for: 5m
+        annotations:
+          summary: down
+
+      - record: job:errors:rate5m
+        expr: sum by (job) (rate(errors_total[5m]))
+      - alert: ErrorsHigh
+        expr: job:errors:rate5m > 1
+`
+
+	uri := "file:///rules.yml"
+
+	if err := h.AddDocument(uri, "yaml", content); err != nil {
+		panic(err)
+	}
+
+	actions, err := h.server.CodeAction(context.Background(), &protocol.CodeActionParams{
+		TextDocument: protocol.TextDocumentIdentifier{URI: protocol.DocumentURI(uri)},
+		Context:      protocol.CodeActionContext{Only: []protocol.CodeActionKind{protocol.Source}},
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	if len(actions) != 2 {
+		panic(fmt.Sprintf("expected organize actions for the document and the workspace, got %v", actions))
+	}
+
+	for _, action := range actions {
+		edits := action.Edit.Changes[uri]
+		if len(edits) != 1 {
+			panic(fmt.Sprintf("expected a single edit from %q, got %v", action.Title, edits))
+		}
+
+		lines := strings.SplitAfter(content, "\n")
+		e := edits[0]
+
+		got := strings.Join(lines[:int(e.Range.Start.Line)], "") + e.NewText + strings.Join(lines[int(e.Range.End.Line):], "")
+		if got != expected {
+			panic(fmt.Sprintf("%q resulted in\n%s\nexpected\n%s", action.Title, got, expected))
+		}
+	}
+}
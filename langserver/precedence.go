@@ -0,0 +1,114 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"fmt"
+	"go/token"
+
+	"github.com/prometheus-community/promql-langserver/langserver/cache"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+	"github.com/prometheus/prometheus/promql"
+)
+
+// fixPrecedence identifies the quick fixes that add parentheses to the operands of set operators
+const fixPrecedence = "operator-precedence"
+
+// isSetOperator checks whether an operator is one of the set operators and, or and unless
+func isSetOperator(op promql.ItemType) bool {
+	return op == promql.LAND || op == promql.LOR || op == promql.LUNLESS
+}
+
+// misreadOperand returns the right operand of a set operation if it is likely to be misread.
+// Binary expressions on the right of and, or and unless bind more tightly, but are often read
+// from left to right, e.g. `a or b > 5` as `(a or b) > 5`. Comparisons on both sides, as in
+// `a > 1 and b < 2`, are read as intended.
+func misreadOperand(n *promql.BinaryExpr) *promql.BinaryExpr {
+	if !isSetOperator(n.Op) {
+		return nil
+	}
+
+	rhs, ok := n.RHS.(*promql.BinaryExpr)
+	if !ok {
+		return nil
+	}
+
+	if lhs, ok := unparen(n.LHS).(*promql.BinaryExpr); ok && isComparisonOperator(lhs.Op) && isComparisonOperator(rhs.Op) {
+		return nil
+	}
+
+	return rhs
+}
+
+// precedenceFixes suggests parentheses for the operands of set operators whose precedence is
+// commonly misread. The hints can be turned off with the disable_precedence_hints option.
+func (s *server) precedenceFixes(doc *cache.DocumentHandle) []quickFix {
+	if s.getConfig() != nil && s.getConfig().DisablePrecedenceHints {
+		return nil
+	}
+
+	queries, err := doc.GetQueries()
+	if err != nil {
+		return nil
+	}
+
+	var ret []quickFix
+
+	for _, q := range queries {
+		if q.Ast == nil || len(q.Err) > 0 {
+			continue
+		}
+
+		promql.Inspect(q.Ast, func(node promql.Node, _ []promql.Node) error {
+			n, ok := node.(*promql.BinaryExpr)
+			if !ok {
+				return nil
+			}
+
+			operand := misreadOperand(n)
+			if operand == nil {
+				return nil
+			}
+
+			pos := q.Pos + token.Pos(operand.PositionRange().Start)
+			end := q.Pos + token.Pos(operand.PositionRange().End)
+
+			rng, err := tokenRange(doc, pos, end)
+			if err != nil {
+				return nil
+			}
+
+			ret = append(ret, quickFix{
+				Rule:  fixPrecedence,
+				Title: "Add parentheses",
+				Diagnostic: protocol.Diagnostic{
+					Range:    rng,
+					Severity: 3, // Information
+					Code:     fixPrecedence,
+					Source:   "promql-lsp",
+					Message: fmt.Sprintf("%s binds more tightly than %s, so this operand is evaluated before %s is applied",
+						operand.Op, n.Op, n.Op),
+				},
+				Edits: []tokenEdit{
+					{Pos: pos, End: pos, NewText: "("},
+					{Pos: end, End: end, NewText: ")"},
+				},
+			})
+
+			return nil
+		})
+	}
+
+	return ret
+}
@@ -0,0 +1,72 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+// TestPrecedenceFixes checks that operands of set operators that are commonly misread get parentheses
+func TestPrecedenceFixes(*testing.T) {
+	tests := []struct {
+		query    string
+		disabled bool
+		expected string
+	}{
+		{query: "up or foo > 5", expected: "up or (foo > 5)"},
+		{query: "a or b and c", expected: "a or (b and c)"},
+		{query: "a unless b * 2", expected: "a unless (b * 2)"},
+		// Read from left to right as intended
+		{query: "foo > 5 unless bar"},
+		{query: "a and b or c"},
+		{query: "foo > 1 and bar < 2"},
+		{query: "up or (foo > 5)"},
+		{query: "up or foo > 5", disabled: true},
+	}
+
+	for i, test := range tests {
+		h, err := NewHeadlessServer(context.Background(), &Config{DisablePrecedenceHints: test.disabled}, nil)
+		if err != nil {
+			panic(err)
+		}
+
+		report, err := h.AnalyzeDocument(fmt.Sprintf("precedence_%d.promql", i), "promql", test.query)
+		if err != nil {
+			panic(err)
+		}
+
+		fixed := ""
+
+		for _, fix := range report.Fixes {
+			if fix.Rule != fixPrecedence {
+				continue
+			}
+
+			if fixed != "" || len(fix.Edits) != 2 {
+				panic(fmt.Sprintf("expected a single fix inserting parentheses for %q, got %v", test.query, report.Fixes))
+			}
+
+			open, closing := fix.Edits[0], fix.Edits[1]
+			fixed = test.query[:open.Start] + open.NewText + test.query[open.End:closing.Start] + closing.NewText + test.query[closing.End:]
+		}
+
+		if fixed != test.expected {
+			panic(fmt.Sprintf("expected %q to be fixed as %q, got %q", test.query, test.expected, fixed))
+		}
+
+		h.Close()
+	}
+}
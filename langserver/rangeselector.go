@@ -0,0 +1,214 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"go/token"
+	"regexp"
+	"strings"
+
+	"github.com/prometheus-community/promql-langserver/langserver/cache"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+	"github.com/prometheus/prometheus/promql"
+)
+
+// defaultRange is the range inserted by the code actions that turn instant vectors into range vectors
+const defaultRange = "[5m]"
+
+// fixRangeSelector identifies the quick fixes that add a missing range selector
+const fixRangeSelector = "range-selector"
+
+// missingRangeErr matches the parse error reported for instant vectors passed to functions expecting a range vector
+var missingRangeErr = regexp.MustCompile(`^expected type range vector in call to function "(\w+)", got instant vector$`) // nolint: gochecknoglobals
+
+// counterWrappers are the functions counter selectors can be wrapped in
+var counterWrappers = []string{"rate", "increase"} // nolint: gochecknoglobals
+
+// selectorRangePos returns the position a range has to be inserted at to turn a vector selector into a matrix selector,
+// i.e. after the label matchers and before the offset modifier. content is the text of the selector.
+func selectorRangePos(content string) int {
+	l := promql.Lex(content)
+
+	for {
+		var item promql.Item
+
+		l.NextItem(&item)
+
+		switch item.Typ {
+		case promql.EOF, promql.ERROR:
+			return len(strings.TrimRight(content, " \t\r\n"))
+		case promql.OFFSET:
+			return len(strings.TrimRight(content[:item.Pos], " \t\r\n"))
+		}
+	}
+}
+
+// parseArgument parses the text of a function argument reported in a parse error.
+// The position range of aggregations passed to functions includes the closing parentheses
+// of the function call, so they are removed until the text can be parsed.
+func parseArgument(text string) (string, promql.Expr) {
+	for {
+		text = strings.TrimRight(text, " \t\r\n")
+
+		if expr, err := promql.ParseExpr(text); err == nil {
+			return text, expr
+		}
+
+		if !strings.HasSuffix(text, ")") {
+			return text, nil
+		}
+
+		text = text[:len(text)-1]
+	}
+}
+
+// rangeSelectorFixes suggests to add a range to the arguments of function calls that are
+// rejected by the parser because they are instant vectors.
+// The fixes belong to parse errors, which are published by the cache, so they are not part of getQuickFixes.
+func rangeSelectorFixes(doc *cache.DocumentHandle) []quickFix {
+	queries, err := doc.GetQueries()
+	if err != nil {
+		return nil
+	}
+
+	var ret []quickFix
+
+	for _, q := range queries {
+		for i := range q.Err {
+			e := &q.Err[i]
+
+			m := missingRangeErr.FindStringSubmatch(e.Err.Error())
+			if m == nil || int(e.PositionRange.End) > len(q.Content) {
+				continue
+			}
+
+			diagnosticRng, err := tokenRange(doc, q.Pos+token.Pos(e.PositionRange.Start), q.Pos+token.Pos(e.PositionRange.End))
+			if err != nil {
+				continue
+			}
+
+			arg, expr := parseArgument(q.Content[e.PositionRange.Start:e.PositionRange.End])
+			if expr == nil {
+				continue
+			}
+
+			// The diagnostic has to match the one published by the cache
+			fix := quickFix{
+				Rule: fixRangeSelector,
+				Diagnostic: protocol.Diagnostic{
+					Range:    diagnosticRng,
+					Severity: 1, // Error
+					Source:   "promql-lsp",
+					Message:  e.Err.Error(),
+				},
+			}
+
+			if _, ok := expr.(*promql.VectorSelector); ok {
+				pos := q.Pos + token.Pos(int(e.PositionRange.Start)+selectorRangePos(arg))
+
+				fix.Title = "Add range selector " + defaultRange
+				fix.Edits = []tokenEdit{{Pos: pos, End: pos, NewText: defaultRange}}
+			} else {
+				// Other expressions have to be turned into a subquery
+				subquery := strings.TrimSuffix(defaultRange, "]") + ":]"
+				pos := q.Pos + token.Pos(int(e.PositionRange.Start)+len(arg))
+
+				fix.Title = "Use subquery " + subquery
+				fix.Edits = []tokenEdit{{Pos: pos, End: pos, NewText: subquery}}
+			}
+
+			ret = append(ret, fix)
+		}
+	}
+
+	return ret
+}
+
+// counterCodeActions offers to wrap the selectors of counters inside a range in rate() or increase()
+func counterCodeActions(doc *cache.DocumentHandle, rng protocol.Range) []protocol.CodeAction {
+	queries, err := doc.GetQueries()
+	if err != nil {
+		return nil
+	}
+
+	var ret []protocol.CodeAction
+
+	for _, q := range queries {
+		// Queries with parse errors are covered by rangeSelectorFixes
+		if q.Ast == nil || len(q.Err) > 0 {
+			continue
+		}
+
+		promql.Inspect(q.Ast, func(node promql.Node, path []promql.Node) error {
+			vs, ok := node.(*promql.VectorSelector)
+			if !ok || !strings.HasSuffix(vs.Name, "_total") {
+				return nil
+			}
+
+			// Selectors inside range vectors are already processed by a function
+			if len(path) > 0 {
+				switch p := path[len(path)-1].(type) {
+				case *promql.MatrixSelector:
+					return nil
+				case *promql.Call:
+					if p.Func.Name == "absent" {
+						return nil
+					}
+				}
+			}
+
+			pos := q.Pos + token.Pos(vs.PosRange.Start)
+			end := q.Pos + token.Pos(vs.PosRange.End)
+
+			selectorRng, err := tokenRange(doc, pos, end)
+			if err != nil || !rangesOverlap(selectorRng, rng) {
+				return nil
+			}
+
+			rangePos := pos + token.Pos(selectorRangePos(q.Content[vs.PosRange.Start:vs.PosRange.End]))
+
+			for _, function := range counterWrappers {
+				edits := []tokenEdit{{Pos: pos, End: pos, NewText: function + "("}}
+
+				// Insertions at the same position are merged, since clients apply them in different orders
+				if rangePos == end {
+					edits = append(edits, tokenEdit{Pos: end, End: end, NewText: defaultRange + ")"})
+				} else {
+					edits = append(edits,
+						tokenEdit{Pos: rangePos, End: rangePos, NewText: defaultRange},
+						tokenEdit{Pos: end, End: end, NewText: ")"})
+				}
+
+				textEdits, err := protocolEdits(doc, edits)
+				if err != nil {
+					return nil
+				}
+
+				ret = append(ret, protocol.CodeAction{
+					Title: "Wrap in " + function + "(" + vs.Name + defaultRange + ")",
+					Kind:  protocol.RefactorRewrite,
+					Edit: protocol.WorkspaceEdit{
+						Changes: map[string][]protocol.TextEdit{
+							doc.GetURI(): textEdits,
+						},
+					},
+				})
+			}
+
+			return nil
+		})
+	}
+
+	return ret
+}
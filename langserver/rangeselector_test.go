@@ -0,0 +1,103 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// applyLineEdits applies text edits to a single line of text
+func applyLineEdits(text string, edits []protocol.TextEdit) string {
+	sort.SliceStable(edits, func(i, j int) bool { return edits[i].Range.Start.Character > edits[j].Range.Start.Character })
+
+	for _, e := range edits {
+		text = text[:int(e.Range.Start.Character)] + e.NewText + text[int(e.Range.End.Character):]
+	}
+
+	return text
+}
+
+// TestRangeCodeActions checks the code actions adding ranges and wrapping counters in rate() and increase()
+func TestRangeCodeActions(*testing.T) {
+	h, err := NewHeadlessServer(context.Background(), &Config{}, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	tests := []struct {
+		query    string
+		expected map[string]string
+	}{
+		{
+			query:    `rate(http_requests_total{code="500"} offset 1h)`,
+			expected: map[string]string{"Add range selector [5m]": `rate(http_requests_total{code="500"}[5m] offset 1h)`},
+		},
+		{
+			query:    `rate(sum(http_requests_total))`,
+			expected: map[string]string{"Use subquery [5m:]": `rate(sum(http_requests_total)[5m:])`},
+		},
+		{
+			query: `http_requests_total > 0`,
+			expected: map[string]string{
+				"Wrap in rate(http_requests_total[5m])":     `rate(http_requests_total[5m]) > 0`,
+				"Wrap in increase(http_requests_total[5m])": `increase(http_requests_total[5m]) > 0`,
+			},
+		},
+		{
+			query:    `rate(http_requests_total[5m])`,
+			expected: map[string]string{},
+		},
+	}
+
+	for i, test := range tests {
+		uri := fmt.Sprintf("query%d.promql", i)
+
+		if err := h.AddDocument(uri, "promql", test.query); err != nil {
+			panic(err)
+		}
+
+		actions, err := h.server.CodeAction(context.Background(), &protocol.CodeActionParams{
+			TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+			Range: protocol.Range{
+				Start: protocol.Position{Line: 0, Character: 10},
+				End:   protocol.Position{Line: 0, Character: 10},
+			},
+		})
+		if err != nil {
+			panic(err)
+		}
+
+		got := make(map[string]string)
+
+		for _, action := range actions {
+			got[action.Title] = applyLineEdits(test.query, action.Edit.Changes[uri])
+		}
+
+		if len(got) != len(test.expected) {
+			panic(fmt.Sprintf("expected code actions %v for %q, got %v", test.expected, test.query, got))
+		}
+
+		for title, expected := range test.expected {
+			if got[title] != expected {
+				panic(fmt.Sprintf("expected %q to result in %q, got %q", title, expected, got[title]))
+			}
+		}
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+
+	"github.com/prometheus-community/promql-langserver/langserver/cache"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// References returns all occurrences of the metric or label at a position in all open documents
+// required by the protocol.Server interface
+func (s *server) References(_ context.Context, params *protocol.ReferenceParams) ([]protocol.Location, error) {
+	_, label, err := s.findLabelReference(&params.TextDocumentPositionParams)
+	if err != nil {
+		return nil, err
+	}
+
+	if label != nil {
+		return s.labelLocations(label.Name), nil
+	}
+
+	ret := []protocol.Location{}
+
+	metric := s.findMetricReference(&params.TextDocumentPositionParams)
+	if metric == nil {
+		return ret, nil
+	}
+
+	for _, ref := range s.cache.GetMetricIndex()[metric.Name] {
+		if ref.Definition && !params.Context.IncludeDeclaration {
+			continue
+		}
+
+		rng, err := tokenRange(ref.Doc, ref.Pos, ref.End)
+		if err != nil {
+			continue
+		}
+
+		ret = append(ret, protocol.Location{URI: ref.Doc.GetURI(), Range: rng})
+	}
+
+	return ret, nil
+}
+
+// labelLocations returns the occurrences of a label name in all open documents
+func (s *server) labelLocations(name string) []protocol.Location {
+	ret := []protocol.Location{}
+
+	for _, doc := range s.cache.GetDocuments() {
+		refs, err := getLabelReferences(doc)
+		if err != nil {
+			continue
+		}
+
+		for _, ref := range refs {
+			if ref.Name != name {
+				continue
+			}
+
+			rng, err := tokenRange(doc, ref.Pos, ref.End)
+			if err != nil {
+				continue
+			}
+
+			ret = append(ret, protocol.Location{URI: doc.GetURI(), Range: rng})
+		}
+	}
+
+	return ret
+}
+
+// findMetricReference returns the occurrence of a metric name at a position, or nil if there is none
+func (s *server) findMetricReference(where *protocol.TextDocumentPositionParams) *cache.MetricReference {
+	doc, err := s.cache.GetDocument(where.TextDocument.URI)
+	if err != nil {
+		return nil
+	}
+
+	pos, err := doc.ProtocolPositionToTokenPos(where.Position)
+	if err != nil {
+		return nil
+	}
+
+	refs, err := doc.GetMetricReferences()
+	if err != nil {
+		return nil
+	}
+
+	for i := range refs {
+		if refs[i].Pos <= pos && pos <= refs[i].End {
+			return &refs[i]
+		}
+	}
+
+	return nil
+}
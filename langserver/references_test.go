@@ -0,0 +1,91 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// TestReferences checks that all occurrences of metrics and labels are found across documents
+func TestReferences(*testing.T) {
+	h, err := NewHeadlessServer(context.Background(), &Config{}, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	const rules = `groups:
+- name: example
+  rules:
+  - record: job:http_errors:rate5m
+    expr: sum by (job) (rate(http_errors_total[5m]))
+  - alert: HighErrorRate
+    expr: job:http_errors:rate5m{job="api"} > 1
+`
+
+	if err := h.AddDocument("rules.yml", "yaml", rules); err != nil {
+		panic(err)
+	}
+
+	if err := h.AddDocument("query.promql", "promql", `sum by (job) (job:http_errors:rate5m)`); err != nil {
+		panic(err)
+	}
+
+	tests := []struct {
+		position           protocol.Position
+		includeDeclaration bool
+		expected           int
+	}{
+		// The recorded metric in the promql file
+		{protocol.Position{Line: 0, Character: 20}, false, 2},
+		{protocol.Position{Line: 0, Character: 20}, true, 3},
+		// The job label in the promql file
+		{protocol.Position{Line: 0, Character: 9}, false, 3},
+	}
+
+	for _, test := range tests {
+		refs, err := h.server.References(context.Background(), &protocol.ReferenceParams{
+			TextDocumentPositionParams: protocol.TextDocumentPositionParams{
+				TextDocument: protocol.TextDocumentIdentifier{URI: "query.promql"},
+				Position:     test.position,
+			},
+			Context: protocol.ReferenceContext{IncludeDeclaration: test.includeDeclaration},
+		})
+		if err != nil {
+			panic(err)
+		}
+
+		if len(refs) != test.expected {
+			panic(fmt.Sprintf("Expected %d references at %v, got %v", test.expected, test.position, refs))
+		}
+	}
+
+	refs, err := h.server.References(context.Background(), &protocol.ReferenceParams{
+		TextDocumentPositionParams: protocol.TextDocumentPositionParams{
+			TextDocument: protocol.TextDocumentIdentifier{URI: "rules.yml"},
+			Position:     protocol.Position{Line: 4, Character: 30},
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	if len(refs) != 1 || refs[0].URI != "rules.yml" {
+		panic(fmt.Sprintf("Expected a single reference to http_errors_total, got %v", refs))
+	}
+}
@@ -0,0 +1,232 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"time"
+
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/jsonrpc2"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// reloadConfigurationMethod is the custom request that makes the server read its configuration file again
+const reloadConfigurationMethod = "promql/reloadConfiguration"
+
+// configReloadInterval is how often the configuration file is checked for changes
+const configReloadInterval = 2 * time.Second
+
+// watchConfigFile reloads the configuration whenever the content of the configuration file changes
+func (s *server) watchConfigFile() {
+	path := s.getConfig().path
+	if path == "" {
+		return
+	}
+
+	last, _ := ioutil.ReadFile(path) // nolint: errcheck
+
+	ticker := time.NewTicker(configReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.lifetime.Done():
+			return
+		case <-ticker.C:
+			content, err := ioutil.ReadFile(path)
+			if err != nil || bytes.Equal(content, last) {
+				// A missing file is usually being replaced by an editor, the old configuration is kept
+				continue
+			}
+
+			last = content
+
+			if err := s.reloadConfig(); err != nil {
+				// nolint: errcheck
+				s.client.ShowMessage(s.lifetime, &protocol.ShowMessageParams{
+					Type:    protocol.Error,
+					Message: fmt.Sprintf("Failed to reload the configuration, the previous one is still used: %s", err.Error()),
+				})
+			}
+		}
+	}
+}
+
+// reloadConfig reads the configuration file again and applies it
+func (s *server) reloadConfig() error {
+	path := s.getConfig().path
+	if path == "" {
+		return jsonrpc2.NewErrorf(jsonrpc2.CodeInvalidRequest, "the server wasn't started with a configuration file")
+	}
+
+	config, err := ParseConfigFile(path)
+	if err != nil {
+		return jsonrpc2.NewErrorf(jsonrpc2.CodeInvalidParams, "invalid configuration %s: %s", path, err.Error())
+	}
+
+	s.applyConfig(config)
+
+	return nil
+}
+
+// applyConfig replaces the configuration of a running server. It reconnects to the Prometheus servers
+// if their settings changed and analyzes the open documents again, since most lint options are read
+// when diagnostics are computed. Demo and read-only mode can't be turned off without a restart,
+// as they may have been enabled by command line flags.
+func (s *server) applyConfig(config *Config) {
+	old := s.getConfig()
+
+	config.DemoMode = config.DemoMode || old.DemoMode
+	config.ReadOnly = config.ReadOnly || old.ReadOnly
+
+	s.configMu.Lock()
+	s.config = config
+	s.configMu.Unlock()
+
+	if connectionChanged(old, config) {
+		if err := s.connectPrometheus(config.PrometheusURL); err != nil {
+			// nolint: errcheck
+			s.client.LogMessage(s.lifetime, &protocol.LogMessageParams{
+				Type:    protocol.Info,
+				Message: err.Error(),
+			})
+		}
+
+		s.configureEndpoints(config.PrometheusServers, config.PrometheusMapping)
+	}
+
+	if connectionChanged(old, config) || config.MetadataRefreshInterval != old.MetadataRefreshInterval {
+		if err := s.startMetadataRefresh(); err != nil {
+			// nolint: errcheck
+			s.client.LogMessage(s.lifetime, &protocol.LogMessageParams{
+				Type:    protocol.Error,
+				Message: err.Error(),
+			})
+		}
+	}
+
+	if config.MetricCatalog != old.MetricCatalog {
+		if err := s.connectMetricCatalog(config.MetricCatalog); err != nil {
+			// nolint: errcheck
+			s.client.LogMessage(s.lifetime, &protocol.LogMessageParams{
+				Type:    protocol.Error,
+				Message: err.Error(),
+			})
+		}
+	}
+
+	if config.CompileDebounce != old.CompileDebounce {
+		s.applyCompileDebounce()
+	}
+
+	if config.EvaluationTime != old.EvaluationTime {
+		if err := s.setEvaluationTime(config.EvaluationTime); err != nil {
+			// nolint: errcheck
+			s.client.LogMessage(s.lifetime, &protocol.LogMessageParams{
+				Type:    protocol.Error,
+				Message: err.Error(),
+			})
+		}
+	}
+
+	// nolint: errcheck
+	s.client.LogMessage(s.lifetime, &protocol.LogMessageParams{
+		Type:    protocol.Info,
+		Message: fmt.Sprint("Reloaded configuration: ", config.path),
+	})
+
+	for _, uri := range s.openDocuments() {
+		go s.diagnostics(uri)
+	}
+}
+
+// connectionChanged checks whether the settings used to connect to the Prometheus servers differ
+func connectionChanged(old *Config, config *Config) bool {
+	return old.PrometheusURL != config.PrometheusURL ||
+		old.TenantID != config.TenantID ||
+		!reflect.DeepEqual(old.Headers, config.Headers) ||
+		!reflect.DeepEqual(old.HTTPConfig, config.HTTPConfig) ||
+		!reflect.DeepEqual(old.Transport, config.Transport) ||
+		!reflect.DeepEqual(old.Thanos, config.Thanos) ||
+		!reflect.DeepEqual(old.Concurrency, config.Concurrency) ||
+		!reflect.DeepEqual(old.PrometheusServers, config.PrometheusServers) ||
+		!reflect.DeepEqual(old.PrometheusMapping, config.PrometheusMapping)
+}
+
+// openDocuments returns the documents opened by the client, i.e. the cached documents that
+// weren't loaded from the workspace folders
+func (s *server) openDocuments() []protocol.DocumentURI {
+	indexed := make(map[protocol.DocumentURI]bool)
+
+	if w := s.workspace; w != nil {
+		w.mu.Lock()
+		for _, uri := range w.indexed {
+			indexed[uri] = true
+		}
+		w.mu.Unlock()
+	}
+
+	var ret []protocol.DocumentURI
+
+	for _, doc := range s.cache.GetDocuments() {
+		if uri := doc.GetURI(); !indexed[uri] {
+			ret = append(ret, uri)
+		}
+	}
+
+	return ret
+}
+
+// rpcTraceStream logs the communication with the client in the format of the rpc_trace option
+// that is currently configured, so that tracing can be changed by reloading the configuration
+type rpcTraceStream struct {
+	jsonrpc2.Stream
+	text jsonrpc2.Stream
+	json jsonrpc2.Stream
+	s    *server
+}
+
+func newRPCTraceStream(stream jsonrpc2.Stream, s *server) jsonrpc2.Stream {
+	return &rpcTraceStream{
+		Stream: stream,
+		text:   protocol.LoggingStream(stream, os.Stderr),
+		json:   JSONLogStream(stream, os.Stderr),
+		s:      s,
+	}
+}
+
+// current returns the stream matching the rpc_trace option
+func (t *rpcTraceStream) current() jsonrpc2.Stream {
+	switch t.s.getConfig().RPCTrace {
+	case "text":
+		return t.text
+	case "json":
+		return t.json
+	default:
+		return t.Stream
+	}
+}
+
+func (t *rpcTraceStream) Read(ctx context.Context) ([]byte, int64, error) {
+	return t.current().Read(ctx)
+}
+
+func (t *rpcTraceStream) Write(ctx context.Context, data []byte) (int64, error) {
+	return t.current().Write(ctx, data)
+}
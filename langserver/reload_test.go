@@ -0,0 +1,71 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestReloadConfig checks that changes of the configuration file are applied to a running server
+func TestReloadConfig(*testing.T) {
+	dir, err := ioutil.TempDir("", "promql-langserver")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "promql-lsp.yaml")
+	if err := ioutil.WriteFile(path, []byte("read_only: true\n"), 0600); err != nil {
+		panic(err)
+	}
+
+	config, err := ParseConfigFile(path)
+	if err != nil {
+		panic(err)
+	}
+
+	h, err := NewHeadlessServer(context.Background(), config, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	if err := ioutil.WriteFile(path, []byte("lint_units: true\nrpc_trace: json\nevaluation_time: 1580000000\n"), 0600); err != nil {
+		panic(err)
+	}
+
+	if err := h.server.reloadConfig(); err != nil {
+		panic(err)
+	}
+
+	if c := h.server.getConfig(); !c.LintUnits || c.RPCTrace != "json" || !c.ReadOnly {
+		panic("expected the new options to be applied without turning off read-only mode")
+	}
+
+	if h.server.getEvaluationTime(nil).Unix() != 1580000000 {
+		panic("expected the evaluation time to be updated")
+	}
+
+	if err := ioutil.WriteFile(path, []byte("lint_units: [\n"), 0600); err != nil {
+		panic(err)
+	}
+
+	if err := h.server.reloadConfig(); err == nil || !h.server.getConfig().LintUnits {
+		panic("expected an invalid configuration to be rejected and the previous one to be kept")
+	}
+}
@@ -0,0 +1,196 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"go/token"
+
+	"github.com/prometheus-community/promql-langserver/langserver/cache"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/jsonrpc2"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+	"github.com/prometheus/common/model"
+)
+
+// PrepareRename checks whether there is something that can be renamed at a position
+// required by the protocol.Server interface
+func (s *server) PrepareRename(_ context.Context, params *protocol.PrepareRenameParams) (interface{}, error) {
+	doc, ref, err := s.findLabelReference(&params.TextDocumentPositionParams)
+	if err != nil {
+		return nil, nil
+	}
+
+	pos, end := token.NoPos, token.NoPos
+
+	if ref != nil {
+		pos, end = ref.Pos, ref.End
+	} else if metric := s.findRecordingRuleReference(&params.TextDocumentPositionParams); metric != nil {
+		pos, end = metric.Pos, metric.End
+	} else {
+		return nil, nil
+	}
+
+	rng, err := tokenRange(doc, pos, end)
+	if err != nil {
+		return nil, nil
+	}
+
+	return &rng, nil
+}
+
+// Rename renames the label or recording rule at a position in all open documents
+// required by the protocol.Server interface
+func (s *server) Rename(_ context.Context, params *protocol.RenameParams) (*protocol.WorkspaceEdit, error) {
+	where := &protocol.TextDocumentPositionParams{
+		TextDocument: params.TextDocument,
+		Position:     params.Position,
+	}
+
+	_, ref, err := s.findLabelReference(where)
+	if err != nil {
+		return nil, err
+	}
+
+	if ref == nil {
+		metric := s.findRecordingRuleReference(where)
+		if metric == nil {
+			return nil, jsonrpc2.NewErrorf(jsonrpc2.CodeInvalidParams, "there is no label or recording rule to rename at this position")
+		}
+
+		if !model.IsValidMetricName(model.LabelValue(params.NewName)) {
+			return nil, jsonrpc2.NewErrorf(jsonrpc2.CodeInvalidParams, "%q is not a valid metric name", params.NewName)
+		}
+
+		return s.renameMetric(metric.Name, params.NewName)
+	}
+
+	if !model.LabelName(params.NewName).IsValid() {
+		return nil, jsonrpc2.NewErrorf(jsonrpc2.CodeInvalidParams, "%q is not a valid label name", params.NewName)
+	}
+
+	return s.renameLabel(ref.Name, params.NewName)
+}
+
+// findRecordingRuleReference returns the reference to a metric at a position, if the metric
+// is recorded by a recording rule in one of the open documents
+func (s *server) findRecordingRuleReference(where *protocol.TextDocumentPositionParams) *cache.MetricReference {
+	ref := s.findMetricReference(where)
+	if ref == nil {
+		return nil
+	}
+
+	for _, other := range s.cache.GetMetricIndex()[ref.Name] {
+		if other.Definition {
+			return ref
+		}
+	}
+
+	return nil
+}
+
+// renameMetric creates a WorkspaceEdit replacing the definitions and all usages of a recorded metric
+func (s *server) renameMetric(oldName string, newName string) (*protocol.WorkspaceEdit, error) {
+	edit := &protocol.WorkspaceEdit{
+		Changes: make(map[string][]protocol.TextEdit),
+	}
+
+	for _, ref := range s.cache.GetMetricIndex()[oldName] {
+		rng, err := tokenRange(ref.Doc, ref.Pos, ref.End)
+		if err != nil {
+			return nil, err
+		}
+
+		uri := ref.Doc.GetURI()
+
+		edit.Changes[uri] = append(edit.Changes[uri], protocol.TextEdit{
+			Range:   rng,
+			NewText: newName,
+		})
+	}
+
+	return edit, nil
+}
+
+// renameLabel creates a WorkspaceEdit replacing all occurrences of a label name
+func (s *server) renameLabel(oldName string, newName string) (*protocol.WorkspaceEdit, error) {
+	edit := &protocol.WorkspaceEdit{
+		Changes: make(map[string][]protocol.TextEdit),
+	}
+
+	for _, doc := range s.cache.GetDocuments() {
+		refs, err := getLabelReferences(doc)
+		if err != nil {
+			continue
+		}
+
+		for i := range refs {
+			if refs[i].Name != oldName {
+				continue
+			}
+
+			rng, err := tokenRange(doc, refs[i].Pos, refs[i].End)
+			if err != nil {
+				return nil, err
+			}
+
+			edit.Changes[doc.GetURI()] = append(edit.Changes[doc.GetURI()], protocol.TextEdit{
+				Range:   rng,
+				NewText: newName,
+			})
+		}
+	}
+
+	return edit, nil
+}
+
+// findLabelReference returns the label reference at a position, or nil if there is none
+func (s *server) findLabelReference(where *protocol.TextDocumentPositionParams) (*cache.DocumentHandle, *labelReference, error) {
+	doc, err := s.cache.GetDocument(where.TextDocument.URI)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pos, err := doc.ProtocolPositionToTokenPos(where.Position)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	refs, err := getLabelReferences(doc)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for i := range refs {
+		if refs[i].Pos <= pos && pos <= refs[i].End {
+			return doc, &refs[i], nil
+		}
+	}
+
+	return doc, nil, nil
+}
+
+// tokenRange converts the start and end of a range to a protocol.Range
+func tokenRange(doc *cache.DocumentHandle, pos token.Pos, end token.Pos) (protocol.Range, error) {
+	start, err := doc.PosToProtocolPosition(pos)
+	if err != nil {
+		return protocol.Range{}, err
+	}
+
+	stop, err := doc.PosToProtocolPosition(end)
+	if err != nil {
+		return protocol.Range{}, err
+	}
+
+	return protocol.Range{Start: start, End: stop}, nil
+}
@@ -0,0 +1,134 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// TestRenameLabel checks that renaming a label finds all its occurrences
+func TestRenameLabel(*testing.T) {
+	h, err := NewHeadlessServer(context.Background(), &Config{}, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	const rules = `groups:
+- name: example
+  rules:
+  - alert: HighErrorRate
+    expr: sum by (job, instance) (rate(errors{job="api", instance!=""}[5m])) > on (instance) group_left (team) owners
+    labels:
+      instance: '{{ $labels.instance }}'
+    annotations:
+      summary: '{{ .Labels.instance }} of {{ $labels.job }} has a high error rate'
+      other: '{{ index $labels "instance" }}'
+  - record: with_host
+    expr: label_replace(up, "host", "$1", "instance", "(.*):.*")
+`
+
+	_, err = h.AnalyzeDocument("rules.yml", "yaml", rules)
+	if err != nil {
+		panic(err)
+	}
+
+	_, err = h.AnalyzeDocument("query.promql", "promql", `count without (instance) (up)`)
+	if err != nil {
+		panic(err)
+	}
+
+	edit, err := h.server.renameLabel("instance", "node")
+	if err != nil {
+		panic(err)
+	}
+
+	// by, matcher, on, labels key, 3 templates and label_replace
+	if n := len(edit.Changes["rules.yml"]); n != 8 {
+		panic(fmt.Sprintf("expected 8 edits in rules.yml, got %d: %v", n, edit.Changes["rules.yml"]))
+	}
+
+	if n := len(edit.Changes["query.promql"]); n != 1 {
+		panic(fmt.Sprintf("expected 1 edit in query.promql, got %d", n))
+	}
+}
+
+// TestRenameRecordingRule checks that renaming a recording rule updates its definition and all usages
+func TestRenameRecordingRule(*testing.T) {
+	h, err := NewHeadlessServer(context.Background(), &Config{}, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	const rules = `groups:
+- name: example
+  rules:
+  - record: "job:http_errors:rate5m"
+    expr: sum by (job) (rate(http_errors_total[5m]))
+  - alert: HighErrorRate
+    expr: job:http_errors:rate5m > 10 or job:http_errors:rate5m{job="api"} > 1
+`
+
+	const alerts = `groups:
+- name: other
+  rules:
+  - alert: Errors
+    expr: job:http_errors:rate5m > 0
+`
+
+	for uri, content := range map[string]string{"rules.yml": rules, "alerts.yml": alerts} {
+		if err := h.AddDocument(uri, "yaml", content); err != nil {
+			panic(err)
+		}
+	}
+
+	if err := h.AddDocument("query.promql", "promql", `sum(job:http_errors:rate5m)`); err != nil {
+		panic(err)
+	}
+
+	// Rename at the usage in the promql file
+	edit, err := h.server.Rename(context.Background(), &protocol.RenameParams{
+		TextDocument: protocol.TextDocumentIdentifier{URI: "query.promql"},
+		Position:     protocol.Position{Line: 0, Character: 6},
+		NewName:      "job:http_errors:rate1m",
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	for uri, expected := range map[string]int{"rules.yml": 3, "alerts.yml": 1, "query.promql": 1} {
+		if n := len(edit.Changes[uri]); n != expected {
+			panic(fmt.Sprintf("expected %d edits in %s, got %d: %v", expected, uri, n, edit.Changes[uri]))
+		}
+	}
+
+	// The quotes of the record field are kept
+	if start := edit.Changes["rules.yml"][0].Range.Start; start.Line != 3 || start.Character != 13 {
+		panic(fmt.Sprintf("unexpected position of the record field edit: %v", start))
+	}
+
+	// Metrics that aren't recorded by a rule can't be renamed
+	if _, err := h.server.Rename(context.Background(), &protocol.RenameParams{
+		TextDocument: protocol.TextDocumentIdentifier{URI: "rules.yml"},
+		Position:     protocol.Position{Line: 4, Character: 30},
+		NewName:      "foo",
+	}); err == nil {
+		panic("expected an error when renaming a metric that isn't recorded by a rule")
+	}
+}
@@ -0,0 +1,440 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"go/token"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql"
+
+	"github.com/prometheus-community/promql-langserver/langserver/cache"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/jsonrpc2"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// commandResolveQuery returns the query at a position with the recording rules of the workspace inlined
+// and template variables replaced, so that it can be pasted into the Prometheus UI
+const commandResolveQuery = "promql.resolveQuery"
+
+// defaultTemplateVariables are the values of the global Grafana variables if neither the dashboard
+// nor the template_variables option sets them
+var defaultTemplateVariables = map[string]string{ // nolint: gochecknoglobals
+	"__interval":      "1m",
+	"__interval_ms":   "60000",
+	"__rate_interval": "5m",
+	"__range":         "1h",
+	"__range_s":       "3600",
+	"__range_ms":      "3600000",
+}
+
+// resolveQueryParams are the parameters of the promql.resolveQuery command
+type resolveQueryParams struct {
+	TextDocument protocol.TextDocumentIdentifier `json:"textDocument"`
+	Position     protocol.Position               `json:"position"`
+}
+
+// resolveQueryResult is the result of the promql.resolveQuery command
+type resolveQueryResult struct {
+	Query string `json:"query"`
+	// Inlined are the names of the inlined recording rules
+	Inlined []string `json:"inlined,omitempty"`
+	// Warnings describe where the result of the resolved query may differ from the original one
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// resolveQuery implements the promql.resolveQuery command
+func (s *server) resolveQuery(ctx context.Context, params *resolveQueryParams) (*resolveQueryResult, error) {
+	doc, err := s.cache.GetDocument(params.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+
+	pos, err := doc.ProtocolPositionToTokenPos(params.Position)
+	if err != nil {
+		return nil, err
+	}
+
+	query, err := doc.GetQuery(pos)
+	if err != nil {
+		return nil, jsonrpc2.NewErrorf(jsonrpc2.CodeInvalidParams, "there is no query at this position")
+	}
+
+	text, err := rawQueryText(doc, query)
+	if err != nil {
+		return nil, err
+	}
+
+	values := s.templateVariables(doc)
+
+	text, unresolved := cache.ExpandGrafanaVariables(text, func(name string) (string, bool) {
+		value, ok := values[name]
+		return value, ok
+	})
+	if len(unresolved) != 0 {
+		return nil, jsonrpc2.NewErrorf(jsonrpc2.CodeInvalidParams,
+			"no value is known for the template variables %s, set them with the template_variables option", strings.Join(unresolved, ", "))
+	}
+
+	expr, err := promql.ParseExpr(text)
+	if err != nil {
+		return nil, jsonrpc2.NewErrorf(jsonrpc2.CodeInvalidParams, "the query can't be parsed: %s", err.Error())
+	}
+
+	r := &queryResolver{s: s, ctx: ctx, rules: s.recordingRules(), inlined: make(map[string]bool)}
+
+	if expr, err = r.resolve(expr); err != nil {
+		return nil, jsonrpc2.NewErrorf(jsonrpc2.CodeInvalidParams, "%s", err.Error())
+	}
+
+	ret := &resolveQueryResult{Query: expr.String(), Warnings: r.warnings}
+
+	for name := range r.inlined {
+		ret.Inlined = append(ret.Inlined, name)
+	}
+
+	sort.Strings(ret.Inlined)
+
+	return ret, nil
+}
+
+// rawQueryText returns a query as it is typed into other tools. Unlike the content of
+// compiled queries in JSON strings, it still contains the template variables.
+func rawQueryText(doc *cache.DocumentHandle, query *cache.CompiledQuery) (string, error) {
+	if !query.InJSONString {
+		return sharedQueryText(query), nil
+	}
+
+	// Masking keeps the length of the query
+	raw, err := doc.GetSubstring(query.Pos, query.Pos+token.Pos(len(query.Content)))
+	if err != nil {
+		return "", err
+	}
+
+	return sharedQueryText(&cache.CompiledQuery{Content: raw, InJSONString: true}), nil
+}
+
+// templateVariables returns the values of the template variables a query can use: those of the template_variables
+// option, the current values of the variables of a Grafana dashboard and the defaults of the global variables
+func (s *server) templateVariables(doc *cache.DocumentHandle) map[string]string {
+	ret := make(map[string]string)
+
+	for name, value := range defaultTemplateVariables {
+		ret[name] = value
+	}
+
+	if root := doc.GrafanaDashboard(); root != nil {
+		for name, value := range dashboardVariableValues(root) {
+			ret[name] = value
+		}
+	}
+
+	for name, value := range s.getConfig().TemplateVariables {
+		ret[name] = value
+	}
+
+	return ret
+}
+
+// queryResolver inlines the recording rules of the workspace into a query
+type queryResolver struct {
+	s     *server
+	ctx   context.Context
+	rules map[string][]*cache.Rule
+	// inlining are the recording rules that are currently inlined, to detect cycles
+	inlining []string
+	inlined  map[string]bool
+	warnings []string
+}
+
+// resolve inlines the recording rules an expression uses. The expression is modified.
+// nolint: gocyclo
+func (r *queryResolver) resolve(expr promql.Expr) (promql.Expr, error) {
+	var err error
+
+	switch n := expr.(type) {
+	case *promql.VectorSelector:
+		if len(r.rules[n.Name]) != 0 {
+			expr, _, err = r.inline(n)
+		}
+	case *promql.MatrixSelector:
+		vs, ok := n.VectorSelector.(*promql.VectorSelector)
+		if !ok || len(r.rules[vs.Name]) == 0 {
+			return n, nil
+		}
+
+		// A range of a recorded metric becomes a subquery, its offset applies to the whole subquery
+		offset := vs.Offset
+		vs.Offset = 0
+
+		inlined, step, err := r.inline(vs)
+		if err != nil {
+			return nil, err
+		}
+
+		return &promql.SubqueryExpr{Expr: inlined, Range: n.Range, Offset: offset, Step: step}, nil
+	case *promql.AggregateExpr:
+		if n.Param != nil {
+			if n.Param, err = r.resolve(n.Param); err != nil {
+				return nil, err
+			}
+		}
+
+		n.Expr, err = r.resolve(n.Expr)
+	case *promql.BinaryExpr:
+		if n.LHS, err = r.resolve(n.LHS); err != nil {
+			return nil, err
+		}
+
+		n.RHS, err = r.resolve(n.RHS)
+	case *promql.Call:
+		for i := range n.Args {
+			if n.Args[i], err = r.resolve(n.Args[i]); err != nil {
+				return nil, err
+			}
+		}
+	case *promql.ParenExpr:
+		n.Expr, err = r.resolve(n.Expr)
+	case *promql.UnaryExpr:
+		n.Expr, err = r.resolve(n.Expr)
+	case *promql.SubqueryExpr:
+		n.Expr, err = r.resolve(n.Expr)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return expr, nil
+}
+
+// inline replaces a selector of a recorded metric by the expressions of the rules recording it, combined with or.
+// The matchers and the offset of the selector are moved to the selectors of the expressions. The evaluation
+// interval of the rules is returned as well, it is the resolution of ranges of the recorded metric.
+func (r *queryResolver) inline(vs *promql.VectorSelector) (promql.Expr, time.Duration, error) {
+	for _, name := range r.inlining {
+		if name == vs.Name {
+			return nil, 0, errors.Errorf("the recording rules %s form a cycle", strings.Join(append(r.inlining, vs.Name), " -> "))
+		}
+	}
+
+	r.inlining = append(r.inlining, vs.Name)
+	defer func() { r.inlining = r.inlining[:len(r.inlining)-1] }()
+
+	r.inlined[vs.Name] = true
+
+	var (
+		ret  promql.Expr
+		step time.Duration
+	)
+
+	for _, rule := range r.rules[vs.Name] {
+		if rule.Query == nil || rule.Query.Ast == nil || len(rule.Query.Err) != 0 {
+			return nil, 0, errors.Errorf("the expression of the recording rule %s can't be parsed", vs.Name)
+		}
+
+		// The expression is parsed again, since the compiled one is shared
+		expr, err := promql.ParseExpr(rule.Query.Content)
+		if err != nil {
+			return nil, 0, errors.Errorf("the expression of the recording rule %s can't be parsed", vs.Name)
+		}
+
+		matchers, ok := r.ruleMatchers(vs, rule)
+		if !ok {
+			continue
+		}
+
+		pushDown(expr, matchers, vs.Offset)
+
+		if expr, err = r.resolve(expr); err != nil {
+			return nil, 0, err
+		}
+
+		expr = withRuleLabels(expr, rule.Labels)
+
+		if ret == nil {
+			ret, step = expr, rule.Group.Interval
+			continue
+		}
+
+		ret = &promql.BinaryExpr{
+			Op:             promql.LOR,
+			LHS:            parenthesize(ret),
+			RHS:            parenthesize(expr),
+			VectorMatching: &promql.VectorMatching{Card: promql.CardManyToMany},
+		}
+	}
+
+	if ret == nil {
+		return nil, 0, errors.Errorf("the labels of the recording rules of %s never match %s", vs.Name, vs)
+	}
+
+	return parenthesize(ret), step, nil
+}
+
+// ruleMatchers returns the matchers of a selector of a recorded metric that have to be applied to the selectors
+// of a rule recording it. It returns false if the labels the rule sets or removes never match the selector.
+func (r *queryResolver) ruleMatchers(vs *promql.VectorSelector, rule *cache.Rule) ([]*labels.Matcher, bool) {
+	a := &labelAnalyzer{s: r.s, ctx: r.ctx, query: rule.Query, series: make(map[string]labelSet)}
+
+	var output labelSet
+	if expr, ok := rule.Query.Ast.(promql.Expr); ok {
+		output = a.exprLabels(expr)
+	}
+
+	created := createdLabels(rule.Query.Ast)
+
+	var ret []*labels.Matcher
+
+	for _, m := range vs.LabelMatchers {
+		if m.Name == labels.MetricName {
+			continue
+		}
+
+		if value, ok := rule.Labels[m.Name]; ok {
+			if !m.Matches(value) {
+				return nil, false
+			}
+
+			continue
+		}
+
+		if output.removedBy(m.Name) != "" {
+			if !m.Matches("") {
+				return nil, false
+			}
+
+			continue
+		}
+
+		if fn, ok := created[m.Name]; ok {
+			r.warnings = append(r.warnings, fmt.Sprintf(
+				"the matcher %s can't be applied to the inlined rule %s, since %s sets the label; the resolved query may return more series",
+				m, vs.Name, fn))
+
+			continue
+		}
+
+		ret = append(ret, m)
+	}
+
+	return ret, true
+}
+
+// createdLabels returns the labels that label_replace and label_join set in an expression, with the function setting them
+func createdLabels(node promql.Node) map[string]string {
+	ret := make(map[string]string)
+
+	promql.Inspect(node, func(node promql.Node, _ []promql.Node) error {
+		if call, ok := node.(*promql.Call); ok && (call.Func.Name == "label_replace" || call.Func.Name == "label_join") && len(call.Args) > 1 {
+			if dst, ok := call.Args[1].(*promql.StringLiteral); ok {
+				ret[dst.Val] = call.Func.Name
+			}
+		}
+
+		return nil
+	})
+
+	return ret
+}
+
+// pushDown adds matchers to the selectors of an expression and shifts its selectors and subqueries by an offset.
+// Selectors inside of subqueries keep their offset, since it is relative to the subquery.
+func pushDown(expr promql.Expr, matchers []*labels.Matcher, offset time.Duration) {
+	promql.Inspect(expr, func(node promql.Node, path []promql.Node) error {
+		switch n := node.(type) {
+		case *promql.VectorSelector:
+			for _, m := range matchers {
+				if !hasMatcher(n, m) {
+					n.LabelMatchers = append(n.LabelMatchers, m)
+				}
+			}
+
+			if !insideSubquery(path) {
+				n.Offset += offset
+			}
+		case *promql.SubqueryExpr:
+			if !insideSubquery(path) {
+				n.Offset += offset
+			}
+		}
+
+		return nil
+	})
+}
+
+// hasMatcher checks whether a selector already has a matcher
+func hasMatcher(vs *promql.VectorSelector, m *labels.Matcher) bool {
+	for _, other := range vs.LabelMatchers {
+		if other.Name == m.Name && other.Type == m.Type && other.Value == m.Value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// insideSubquery checks whether a path of the syntax tree passes a subquery
+func insideSubquery(path []promql.Node) bool {
+	for _, node := range path {
+		if _, ok := node.(*promql.SubqueryExpr); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// withRuleLabels sets the labels a recording rule adds on the result of its expression
+func withRuleLabels(expr promql.Expr, ruleLabels map[string]string) promql.Expr {
+	names := make([]string, 0, len(ruleLabels))
+	for name := range ruleLabels {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		// An empty regular expression matches the empty value of the empty source label, i.e. every series
+		expr = &promql.Call{
+			Func: promql.Functions["label_replace"],
+			Args: promql.Expressions{
+				expr,
+				&promql.StringLiteral{Val: name},
+				&promql.StringLiteral{Val: ruleLabels[name]},
+				&promql.StringLiteral{Val: ""},
+				&promql.StringLiteral{Val: ""},
+			},
+		}
+	}
+
+	return expr
+}
+
+// parenthesize wraps an inlined expression in parentheses unless it is evaluated
+// first anyway, e.g. a function call, and can be used as subquery
+func parenthesize(expr promql.Expr) promql.Expr {
+	switch expr.(type) {
+	case *promql.ParenExpr, *promql.Call, *promql.AggregateExpr, *promql.VectorSelector, *promql.NumberLiteral:
+		return expr
+	default:
+		return &promql.ParenExpr{Expr: expr}
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// TestResolveQuery checks that recording rules are inlined with the matchers and offsets of their selectors
+// and that template variables are replaced by the current values of the dashboard
+func TestResolveQuery(*testing.T) {
+	h, err := NewHeadlessServer(context.Background(), &Config{TemplateVariables: map[string]string{"env": "prod"}}, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	const rules = `groups:
+- name: example
+  interval: 30s
+  rules:
+  - record: job:requests:rate5m
+    expr: sum by (job) (rate(http_requests_total[5m]))
+  - record: job:errors:ratio
+    expr: sum by (job) (rate(http_requests_total{code=~"5.."}[5m])) / job:requests:rate5m
+    labels:
+      team: web
+  - record: loop:a
+    expr: loop:b
+  - record: loop:b
+    expr: loop:a + 1
+`
+
+	const dashboard = `{
+  "templating": {"list": [{"name": "job", "current": {"text": "api + web", "value": ["api", "web"]}}]},
+  "panels": [
+    {"targets": [{"expr": "max_over_time(job:requests:rate5m{job=~\"$job\"}[1h] offset 1d)"}]},
+    {"targets": [{"expr": "rate(http_requests_total{env=\"$env\"}[$__rate_interval])"}]},
+    {"targets": [{"expr": "up{instance=\"$instance\"}"}]}
+  ]
+}`
+
+	for uri, content := range map[string]string{
+		"rules.yml":      rules,
+		"query.promql":   `job:errors:ratio{job="api", team="web"} > 0.1`,
+		"cycle.promql":   `loop:a`,
+		"dashboard.json": dashboard,
+	} {
+		language := map[string]string{".yml": "yaml", "json": "json"}[uri[len(uri)-4:]]
+		if language == "" {
+			language = "promql"
+		}
+
+		if err := h.AddDocument(uri, language, content); err != nil {
+			panic(err)
+		}
+	}
+
+	for _, test := range []struct {
+		uri       string
+		line      float64
+		character float64
+		expected  string
+	}{
+		{
+			uri:       "query.promql",
+			character: 3,
+			expected: `label_replace(sum by(job) (rate(http_requests_total{code=~"5..",job="api"}[5m])) / ` +
+				`sum by(job) (rate(http_requests_total{job="api"}[5m])), "team", "web", "", "") > 0.1`,
+		},
+		{
+			uri:       "dashboard.json",
+			line:      3,
+			character: 40,
+			expected:  `max_over_time(sum by(job) (rate(http_requests_total{job=~"(api|web)"}[5m]))[1h:30s] offset 1d)`,
+		},
+		{
+			uri:       "dashboard.json",
+			line:      4,
+			character: 40,
+			expected:  `rate(http_requests_total{env="prod"}[5m])`,
+		},
+		{uri: "dashboard.json", line: 5, character: 40},
+		{uri: "cycle.promql", character: 3},
+	} {
+		ret, err := h.server.ExecuteCommand(context.Background(), &protocol.ExecuteCommandParams{
+			Command: commandResolveQuery,
+			Arguments: []interface{}{map[string]interface{}{
+				"textDocument": map[string]interface{}{"uri": test.uri},
+				"position":     map[string]interface{}{"line": test.line, "character": test.character},
+			}},
+		})
+
+		if test.expected == "" {
+			if err == nil {
+				panic(fmt.Sprintf("expected line %v of %s not to be resolved, got %+v", test.line, test.uri, ret))
+			}
+
+			continue
+		}
+
+		if err != nil || ret.(*resolveQueryResult).Query != test.expected {
+			panic(fmt.Sprintf("expected %s for line %v of %s, got %+v, %v", test.expected, test.line, test.uri, ret, err))
+		}
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"go/token"
+	"time"
+
+	"github.com/prometheus-community/promql-langserver/langserver/cache"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/promql"
+)
+
+// defaultRetention is the retention Prometheus uses if neither a retention time nor size is configured
+const defaultRetention = 15 * 24 * time.Hour
+
+// retention returns the retention of the Prometheus server, or 0 if it is unknown.
+// A configured retention takes precedence over the one reported by the server.
+func (s *server) retention() time.Duration {
+	if s.getConfig() != nil && s.getConfig().Retention != "" {
+		if retention, err := cache.ParseDuration(s.getConfig().Retention); err == nil {
+			return retention
+		}
+	}
+
+	s.prometheusMu.Lock()
+	defer s.prometheusMu.Unlock()
+
+	return s.prometheusRetention
+}
+
+// fetchRetention reads the retention from the command line flags of a Prometheus server.
+// It returns 0 if the retention is unknown, e.g. because only a size based retention is configured
+// or the datasource doesn't expose its flags.
+func fetchRetention(ctx context.Context, api v1.API) time.Duration {
+	flags, err := api.Flags(ctx)
+	if err != nil {
+		return 0
+	}
+
+	// storage.tsdb.retention is the deprecated name of storage.tsdb.retention.time
+	for _, flag := range []string{"storage.tsdb.retention.time", "storage.tsdb.retention"} {
+		if retention, err := cache.ParseDuration(flags[flag]); err == nil && retention != 0 {
+			return retention
+		}
+	}
+
+	if size, ok := flags["storage.tsdb.retention.size"]; ok && size != "" && size != "0B" {
+		return 0
+	}
+
+	return defaultRetention
+}
+
+// rangeRetentionDiagnostics warns about selectors that look back further than the retention
+// of the Prometheus server, which silently returns truncated data
+func (s *server) rangeRetentionDiagnostics(doc *cache.DocumentHandle) []protocol.Diagnostic {
+	retention := s.retention()
+	if retention == 0 {
+		return nil
+	}
+
+	queries, err := doc.GetQueries()
+	if err != nil {
+		return nil
+	}
+
+	var ret []protocol.Diagnostic
+
+	for _, q := range queries {
+		if q.Ast == nil {
+			continue
+		}
+
+		promql.Inspect(q.Ast, func(node promql.Node, path []promql.Node) error {
+			var lookback time.Duration
+
+			switch n := node.(type) {
+			case *promql.MatrixSelector:
+				lookback = n.Range
+
+				if vs, ok := n.VectorSelector.(*promql.VectorSelector); ok {
+					lookback += vs.Offset
+				}
+			case *promql.SubqueryExpr:
+				lookback = n.Range + n.Offset
+			case *promql.VectorSelector:
+				// Selectors inside of range selectors are handled with them
+				if len(path) > 0 {
+					if _, ok := path[len(path)-1].(*promql.MatrixSelector); ok {
+						return nil
+					}
+				}
+
+				lookback = n.Offset
+			default:
+				return nil
+			}
+
+			// Subqueries evaluate their inner expression at earlier times
+			for _, parent := range path {
+				if sq, ok := parent.(*promql.SubqueryExpr); ok {
+					lookback += sq.Range + sq.Offset
+				}
+			}
+
+			if lookback <= retention {
+				return nil
+			}
+
+			// Only report the outermost subquery that exceeds the retention
+			for _, parent := range path {
+				if sq, ok := parent.(*promql.SubqueryExpr); ok && sq.Range+sq.Offset > retention {
+					return nil
+				}
+			}
+
+			rng, err := tokenRange(doc, q.Pos+token.Pos(node.PositionRange().Start), q.Pos+token.Pos(node.PositionRange().End))
+			if err != nil {
+				return nil
+			}
+
+			ret = append(ret, protocol.Diagnostic{
+				Range:    rng,
+				Severity: 2, // Warning
+				Code:     codeRangeRetention,
+				Source:   "promql-lsp",
+				Message: fmt.Sprintf("this selects data up to %s in the past, but the Prometheus server only keeps %s; older data is silently missing from the result",
+					model.Duration(lookback), model.Duration(retention)),
+			})
+
+			return nil
+		})
+	}
+
+	return ret
+}
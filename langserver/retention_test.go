@@ -0,0 +1,86 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestRetentionDiagnostics checks that ranges, offsets and @ modifiers reaching back further than the retention are reported
+func TestRetentionDiagnostics(*testing.T) {
+	var flags string
+
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.URL.Path == "/api/v1/status/flags" {
+			fmt.Fprintf(w, `{"status":"success","data":%s}`, flags)
+			return
+		}
+
+		fmt.Fprint(w, `{"status":"success","data":{}}`)
+	}))
+	defer prom.Close()
+
+	tests := []struct {
+		flags     string
+		retention string
+		query     string
+		expected  int
+	}{
+		{`{"storage.tsdb.retention.time":"2d"}`, "", `rate(foo[3d])`, 1},
+		{`{"storage.tsdb.retention.time":"2d"}`, "", `foo offset 1d`, 0},
+		{`{"storage.tsdb.retention.time":"2d"}`, "", `rate(foo[1d] offset 1d)`, 0},
+		{`{"storage.tsdb.retention.time":"2d"}`, "", `rate(foo[1d] offset 2d)`, 1},
+		{`{"storage.tsdb.retention.time":"2d"}`, "", `max_over_time(rate(foo[5m])[3d:1m])`, 1},
+		{`{"storage.tsdb.retention.time":"2d"}`, "", `foo @ 0`, 1},
+		{`{"storage.tsdb.retention":"2d"}`, "", `rate(foo[3d])`, 1},
+		{`{"storage.tsdb.retention.time":"2d"}`, "30d", `rate(foo[3d])`, 0},
+		{`{}`, "", `rate(foo[16d])`, 1},
+		{`{"storage.tsdb.retention.size":"10GB"}`, "", `rate(foo[16d])`, 0},
+	}
+
+	for i, test := range tests {
+		flags = test.flags
+
+		h, err := NewHeadlessServer(context.Background(), &Config{PrometheusURL: prom.URL, Retention: test.retention}, nil)
+		if err != nil {
+			panic(err)
+		}
+
+		report, err := h.AnalyzeDocument(fmt.Sprintf("query%d.promql", i), "promql", test.query)
+		if err != nil {
+			panic(err)
+		}
+
+		h.Close()
+
+		found := 0
+
+		for _, d := range report.Diagnostics {
+			if d.Code == codeRangeRetention || d.Code == codeAtModifierRetention {
+				found++
+			}
+		}
+
+		if found != test.expected {
+			panic(fmt.Sprintf("expected %d retention diagnostics for %q with flags %s, got %d: %v",
+				test.expected, test.query, test.flags, found, report.Diagnostics))
+		}
+	}
+}
@@ -0,0 +1,217 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v3"
+
+	"github.com/prometheus-community/promql-langserver/langserver/cache"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// ruleGroupFields are the fields of a rule group. partial_response_strategy is read by the Thanos ruler.
+var ruleGroupFields = []string{"name", "interval", "limit", "partial_response_strategy", "rules"} // nolint: gochecknoglobals
+
+// ruleFields are the fields of a rule
+var ruleFields = []string{"record", "alert", "expr", "for", "keep_firing_for", "labels", "annotations"} // nolint: gochecknoglobals
+
+// ruleFileDiagnostics validates the rule files the way promtool check rules does, the expressions,
+// durations and templates are checked separately. Problems are reported at the yaml node causing them.
+// nolint: funlen, gocyclo
+func ruleFileDiagnostics(doc *cache.DocumentHandle) []protocol.Diagnostic {
+	groups, err := doc.GetRuleGroups()
+	if err != nil || !isRuleFile(groups) {
+		return nil
+	}
+
+	var ret []protocol.Diagnostic
+
+	report := func(node *yaml.Node, lineOffset int, severity protocol.DiagnosticSeverity, code string, format string, args ...interface{}) {
+		pos, end, err := doc.YamlNodeRange(node, lineOffset)
+		if err != nil {
+			return
+		}
+
+		rng, err := tokenRange(doc, pos, end)
+		if err != nil {
+			return
+		}
+
+		ret = append(ret, protocol.Diagnostic{
+			Range:    rng,
+			Severity: severity,
+			Code:     code,
+			Source:   "promql-lsp",
+			Message:  fmt.Sprintf(format, args...),
+		})
+	}
+
+	for _, group := range groups {
+		offset := group.LineOffset
+
+		checkFields(group.Node, ruleGroupFields, "rule group", func(node *yaml.Node, format string, args ...interface{}) {
+			report(node, offset, 1, codeRuleSchema, format, args...)
+		})
+
+		if name := cache.MappingValue(group.Node, "name"); name == nil || name.Value == "" {
+			report(firstKey(group.Node, "name"), offset, 1, codeRuleSchema, "Groupname should not be empty")
+		}
+
+		if rules := cache.MappingValue(group.Node, "rules"); rules != nil && rules.Kind != yaml.SequenceNode {
+			report(rules, offset, 1, codeRuleSchema, "rules must be a list of rules")
+		}
+
+		names := make(map[string]*cache.Rule)
+
+		for _, rule := range group.Rules {
+			checkFields(rule.Node, ruleFields, "rule", func(node *yaml.Node, format string, args ...interface{}) {
+				report(node, offset, 1, codeRuleSchema, format, args...)
+			})
+
+			record, alert := cache.MappingValue(rule.Node, "record"), cache.MappingValue(rule.Node, "alert")
+
+			switch {
+			case record != nil && alert != nil:
+				report(alert, offset, 1, codeRuleSchema, "only one of 'record' and 'alert' must be set")
+			case record == nil && alert == nil:
+				report(firstKey(rule.Node, ""), offset, 1, codeRuleSchema, "one of 'record' or 'alert' must be set")
+			case record != nil && !model.IsValidMetricName(model.LabelValue(record.Value)):
+				report(record, offset, 1, codeRuleSchema, "invalid recording rule name: %s", record.Value)
+			}
+
+			if expr := cache.MappingValue(rule.Node, "expr"); expr == nil {
+				report(firstKey(rule.Node, ""), offset, 1, codeRuleSchema, "field 'expr' must be set in rule")
+			} else if strings.TrimSpace(expr.Value) == "" {
+				report(expr, offset, 1, codeRuleSchema, "field 'expr' must be set in rule")
+			}
+
+			if rule.Record != "" {
+				for _, key := range []string{"for", "keep_firing_for", "annotations"} {
+					if k := mappingKey(rule.Node, key); k != nil {
+						report(k, offset, 1, codeRuleSchema, "invalid field '%s' in recording rule", key)
+					}
+				}
+			}
+
+			for _, key := range []string{"labels", "annotations"} {
+				mapping := cache.MappingValue(rule.Node, key)
+				if mapping == nil {
+					continue
+				}
+
+				if mapping.Kind != yaml.MappingNode {
+					report(mapping, offset, 1, codeRuleSchema, "%s must be a mapping of names to values", key)
+					continue
+				}
+
+				for i := 0; i+1 < len(mapping.Content); i += 2 {
+					name, value := mapping.Content[i], mapping.Content[i+1]
+
+					if !model.LabelName(name.Value).IsValid() {
+						report(name, offset, 1, codeRuleSchema, "invalid %s name: %s", strings.TrimSuffix(key, "s"), name.Value)
+					}
+
+					if key == "labels" && !model.LabelValue(value.Value).IsValid() {
+						report(value, offset, 1, codeRuleSchema, "invalid label value: %s", value.Value)
+					}
+				}
+			}
+
+			if name := rule.Name(); name != "" {
+				// Recording rules with the same labels are reported as duplicate records
+				if other, ok := names[name]; ok && (rule.Alert != "" || other.Alert != "" || !reflect.DeepEqual(rule.Labels, other.Labels)) {
+					nameNode := record
+					if rule.Alert != "" {
+						nameNode = alert
+					}
+
+					report(nameNode, offset, 2, codeDuplicateRule, "the rule %s is already defined in this group", name)
+				}
+
+				names[name] = rule
+			}
+		}
+	}
+
+	return ret
+}
+
+// isRuleFile checks whether the rule groups of a document come from a Prometheus rule file,
+// rather than another yaml file with a groups field
+func isRuleFile(groups []*cache.RuleGroup) bool {
+	for _, group := range groups {
+		if cache.MappingValue(group.Node, "rules") != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkFields reports the keys of a yaml mapping that aren't one of the known fields.
+// Misspelled fields are ignored by some tools, but rejected by Prometheus.
+func checkFields(node *yaml.Node, fields []string, kind string, report func(node *yaml.Node, format string, args ...interface{})) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key := node.Content[i].Value
+
+		known := false
+
+		for _, field := range fields {
+			if key == field {
+				known = true
+				break
+			}
+		}
+
+		if known {
+			continue
+		}
+
+		if suggestion := closestName(key, fields, maxLabelSuggestionDistance); suggestion != "" {
+			report(node.Content[i], "unknown field %q in %s, did you mean %q?", key, kind, suggestion)
+		} else {
+			report(node.Content[i], "unknown field %q in %s, expected one of %s", key, kind, strings.Join(fields, ", "))
+		}
+	}
+}
+
+// mappingKey returns the key node of a field of a yaml mapping, or nil if there is none
+func mappingKey(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i]
+		}
+	}
+
+	return nil
+}
+
+// firstKey returns the key node of a field of a mapping, or the first key if the field doesn't exist,
+// which is where problems with missing fields are reported
+func firstKey(node *yaml.Node, key string) *yaml.Node {
+	if k := mappingKey(node, key); k != nil {
+		return k
+	}
+
+	if len(node.Content) > 0 {
+		return node.Content[0]
+	}
+
+	return node
+}
@@ -0,0 +1,83 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// TestRuleFileDiagnostics checks that the schema errors promtool reports are found at the fields causing them
+func TestRuleFileDiagnostics(*testing.T) {
+	h, err := NewHeadlessServer(context.Background(), &Config{}, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	const rules = `groups:
+- name: example
+  rules:
+  - record: job:up:sum
+    expr: sum by (job) (up)
+    annotations:
+      summary: recorded
+  - alert: Down
+    exp: up == 0
+  - alert: Down
+    expr: up == 0
+    labels:
+      team-name: web
+    annotations:
+      summary: "{{ $labels.job"
+  - record: bad-name
+    alert: Both
+    expr: up
+- interval: 1m
+  rules: []
+`
+
+	result, err := h.AnalyzeDocument("rules.yml", "yaml", rules)
+	if err != nil {
+		panic(err)
+	}
+
+	var problems []string
+
+	for _, d := range result.Diagnostics {
+		if d.Code == codeRuleSchema || d.Code == codeDuplicateRule {
+			problems = append(problems, fmt.Sprintf("%v:%s", d.Range.Start.Line, d.Message))
+		}
+	}
+
+	for i, expected := range []string{
+		"5:invalid field 'annotations' in recording rule",
+		"7:field 'expr' must be set in rule",
+		`8:unknown field "exp" in rule, did you mean "expr"?`,
+		"9:the rule Down is already defined in this group",
+		"12:invalid label name: team-name",
+		"16:only one of 'record' and 'alert' must be set",
+		"18:Groupname should not be empty",
+	} {
+		if i >= len(problems) || !strings.HasPrefix(problems[i], expected) {
+			panic(fmt.Sprintf("expected %s, got %v", expected, problems))
+		}
+	}
+
+	if len(problems) != 7 {
+		panic(fmt.Sprintf("expected 7 problems, got %v", problems))
+	}
+}
@@ -0,0 +1,289 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/prometheus-community/promql-langserver/langserver/cache"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/promql"
+)
+
+// defaultEvaluationInterval is the evaluation interval Prometheus uses for
+// groups that don't specify one, unless configured otherwise
+const defaultEvaluationInterval = time.Minute
+
+// groupInterval returns the effective evaluation interval of a rule group
+func groupInterval(group *cache.RuleGroup) time.Duration {
+	if group.Interval != 0 {
+		return group.Interval
+	}
+
+	return defaultEvaluationInterval
+}
+
+// recordingRules returns all recording rules defined in open documents, indexed by the name of the recorded metric
+func (s *server) recordingRules() map[string][]*cache.Rule {
+	ret := make(map[string][]*cache.Rule)
+
+	for _, doc := range s.cache.GetDocuments() {
+		groups, err := doc.GetRuleGroups()
+		if err != nil {
+			continue
+		}
+
+		for _, group := range groups {
+			for _, rule := range group.Rules {
+				if rule.Record != "" {
+					ret[rule.Record] = append(ret[rule.Record], rule)
+				}
+			}
+		}
+	}
+
+	return ret
+}
+
+// ruleOrderDiagnostics warns about rules that depend on recording rules which
+// are evaluated after them in the same group or less often than them in another group.
+// In both cases the rule is evaluated based on stale data.
+// nolint: funlen
+func (s *server) ruleOrderDiagnostics(doc *cache.DocumentHandle) []protocol.Diagnostic {
+	groups, err := doc.GetRuleGroups()
+	if err != nil || len(groups) == 0 {
+		return nil
+	}
+
+	records := s.recordingRules()
+
+	var ret []protocol.Diagnostic
+
+	for _, group := range groups {
+		for _, rule := range group.Rules {
+			if rule.Query == nil || rule.Query.Ast == nil {
+				continue
+			}
+
+			q := rule.Query
+
+			promql.Inspect(q.Ast, func(node promql.Node, _ []promql.Node) error {
+				vs, ok := node.(*promql.VectorSelector)
+				if !ok {
+					return nil
+				}
+
+				for _, def := range records[vs.Name] {
+					var msg string
+
+					switch {
+					case def.Group == group && def.Index > rule.Index:
+						msg = fmt.Sprintf("%s is recorded later in group %q; this rule will always use the result of the previous evaluation", vs.Name, group.Name)
+					case def.Group != group && groupInterval(def.Group) > groupInterval(group):
+						msg = fmt.Sprintf("%s is recorded every %s in group %q, less often than this group is evaluated (every %s); its input may be stale",
+							vs.Name, model.Duration(groupInterval(def.Group)), def.Group.Name, model.Duration(groupInterval(group)))
+					default:
+						continue
+					}
+
+					rng, err := getEditRange(&cache.Location{Doc: doc, Query: q, Node: vs}, vs.Name)
+					if err != nil {
+						return nil
+					}
+
+					ret = append(ret, protocol.Diagnostic{
+						Range:    rng,
+						Severity: 2, // Warning
+						Code:     codeRuleOrder,
+						Source:   "promql-lsp",
+						Message:  msg,
+					})
+
+					break
+				}
+
+				return nil
+			})
+		}
+	}
+
+	return ret
+}
+
+// duplicateRuleDiagnostics reports group names that are repeated in the same file, which
+// Prometheus rejects, and recording rules that record the same series as another rule.
+func (s *server) duplicateRuleDiagnostics(doc *cache.DocumentHandle) []protocol.Diagnostic {
+	groups, err := doc.GetRuleGroups()
+	if err != nil || len(groups) == 0 {
+		return nil
+	}
+
+	records := s.recordingRules()
+
+	var ret []protocol.Diagnostic
+
+	seen := make(map[string]bool)
+
+	for _, group := range groups {
+		if seen[group.Name] {
+			if rng, err := tokenRange(doc, group.NamePos, group.NameEnd); err == nil {
+				ret = append(ret, protocol.Diagnostic{
+					Range:    rng,
+					Severity: 1, // Error
+					Code:     codeDuplicateGroup,
+					Source:   "promql-lsp",
+					Message:  fmt.Sprintf("groupname: %q is repeated in the same file", group.Name),
+				})
+			}
+		}
+
+		seen[group.Name] = true
+
+		for _, rule := range group.Rules {
+			if rule.Record == "" {
+				continue
+			}
+
+			for _, other := range records[rule.Record] {
+				if other == rule || !reflect.DeepEqual(other.Labels, rule.Labels) {
+					continue
+				}
+
+				rng, err := tokenRange(doc, rule.NamePos, rule.NameEnd)
+				if err != nil {
+					break
+				}
+
+				ret = append(ret, protocol.Diagnostic{
+					Range:    rng,
+					Severity: 2, // Warning
+					Code:     codeDuplicateRecord,
+					Source:   "promql-lsp",
+					Message:  fmt.Sprintf("%s is also recorded with the same labels in group %q", rule.Record, other.Group.Name),
+				})
+
+				break
+			}
+		}
+	}
+
+	return ret
+}
+
+// ruleCycleDiagnostics warns about recording rules that depend on their own output,
+// directly or through other recording rules
+func (s *server) ruleCycleDiagnostics(doc *cache.DocumentHandle) []protocol.Diagnostic {
+	groups, err := doc.GetRuleGroups()
+	if err != nil || len(groups) == 0 {
+		return nil
+	}
+
+	records := s.recordingRules()
+
+	// dependencies maps the name of a recorded metric to the recorded metrics used to compute it
+	dependencies := make(map[string][]string)
+
+	for name, defs := range records {
+		for _, def := range defs {
+			for _, dep := range ruleDependencies(def) {
+				if _, ok := records[dep]; ok {
+					dependencies[name] = append(dependencies[name], dep)
+				}
+			}
+		}
+	}
+
+	var ret []protocol.Diagnostic
+
+	for _, group := range groups {
+		for _, rule := range group.Rules {
+			if rule.Record == "" {
+				continue
+			}
+
+			cycle := findCycle(dependencies, rule.Record)
+			if cycle == nil {
+				continue
+			}
+
+			rng, err := tokenRange(doc, rule.NamePos, rule.NameEnd)
+			if err != nil {
+				continue
+			}
+
+			ret = append(ret, protocol.Diagnostic{
+				Range:    rng,
+				Severity: 2, // Warning
+				Code:     codeRuleCycle,
+				Source:   "promql-lsp",
+				Message:  fmt.Sprintf("recording rule depends on its own output: %s", strings.Join(cycle, " -> ")),
+			})
+		}
+	}
+
+	return ret
+}
+
+// ruleDependencies returns the names of the metrics used by the expression of a rule
+func ruleDependencies(rule *cache.Rule) []string {
+	if rule.Query == nil || rule.Query.Ast == nil {
+		return nil
+	}
+
+	var ret []string
+
+	promql.Inspect(rule.Query.Ast, func(node promql.Node, _ []promql.Node) error {
+		if vs, ok := node.(*promql.VectorSelector); ok && vs.Name != "" {
+			ret = append(ret, vs.Name)
+		}
+
+		return nil
+	})
+
+	return ret
+}
+
+// findCycle returns a path in the dependency graph that leads from start back to itself,
+// or nil if there is none
+func findCycle(dependencies map[string][]string, start string) []string {
+	visited := make(map[string]bool)
+
+	var visit func(path []string) []string
+
+	visit = func(path []string) []string {
+		for _, dep := range dependencies[path[len(path)-1]] {
+			if dep == start {
+				return append(path, dep)
+			}
+
+			if visited[dep] {
+				continue
+			}
+
+			visited[dep] = true
+
+			if cycle := visit(append(path, dep)); cycle != nil {
+				return cycle
+			}
+		}
+
+		return nil
+	}
+
+	return visit([]string{start})
+}
@@ -0,0 +1,288 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"go/token"
+	"sort"
+	"strings"
+
+	"github.com/prometheus-community/promql-langserver/langserver/cache"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+	"github.com/prometheus/prometheus/promql"
+)
+
+// The token types of the semantic tokens legend. The values are the indices into semanticTokenTypes.
+const (
+	tokenMetric = iota
+	tokenLabel
+	tokenString
+	tokenRegexp
+	tokenFunction
+	tokenKeyword
+	tokenOperator
+	tokenNumber
+	tokenComment
+)
+
+// semanticTokenTypes are the token types announced to the client, in the order of the constants above
+var semanticTokenTypes = []string{"variable", "property", "string", "regexp", "function", "keyword", "operator", "number", "comment"} // nolint: gochecknoglobals
+
+// modifierDuration marks numbers that are durations, e.g. 5m
+const modifierDuration = 1 << 0
+
+// semanticTokenModifiers are the token modifiers announced to the client, in the order of their bits
+var semanticTokenModifiers = []string{"duration"} // nolint: gochecknoglobals
+
+// semanticTokensParams are the parameters of a textDocument/semanticTokens/full request.
+// Semantic tokens were added in version 3.16 of the protocol, which is newer than
+// what the protocol package implements.
+type semanticTokensParams struct {
+	TextDocument protocol.TextDocumentIdentifier `json:"textDocument"`
+}
+
+// semanticTokensRangeParams are the parameters of a textDocument/semanticTokens/range request
+type semanticTokensRangeParams struct {
+	TextDocument protocol.TextDocumentIdentifier `json:"textDocument"`
+	Range        protocol.Range                  `json:"range"`
+}
+
+// semanticTokens are the encoded tokens of a document as defined by version 3.16 of the protocol
+type semanticTokens struct {
+	Data []uint32 `json:"data"`
+}
+
+// semanticToken is a classified part of a query
+type semanticToken struct {
+	Pos       token.Pos
+	End       token.Pos
+	Type      int
+	Modifiers int
+}
+
+// SemanticTokensFull classifies the parts of all queries of a document for syntax highlighting
+func (s *server) SemanticTokensFull(_ context.Context, params *semanticTokensParams) (*semanticTokens, error) {
+	doc, err := s.cache.GetDocument(params.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+
+	return encodeSemanticTokens(doc, documentSemanticTokens(doc), nil), nil
+}
+
+// SemanticTokensRange classifies the parts of the queries inside a range for syntax highlighting
+func (s *server) SemanticTokensRange(_ context.Context, params *semanticTokensRangeParams) (*semanticTokens, error) {
+	doc, err := s.cache.GetDocument(params.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+
+	return encodeSemanticTokens(doc, documentSemanticTokens(doc), &params.Range), nil
+}
+
+// documentSemanticTokens returns the semantic tokens of all queries of a document, ordered by position
+func documentSemanticTokens(doc *cache.DocumentHandle) []semanticToken {
+	queries, err := doc.GetQueries()
+	if err != nil {
+		return nil
+	}
+
+	var ret []semanticToken
+
+	for _, q := range queries {
+		ret = append(ret, querySemanticTokens(q)...)
+	}
+
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Pos < ret[j].Pos })
+
+	return ret
+}
+
+// querySemanticTokens classifies the tokens of a query using the PromQL lexer
+// nolint: funlen, gocyclo
+func querySemanticTokens(q *cache.CompiledQuery) []semanticToken {
+	var ret []semanticToken
+
+	add := func(pos promql.Pos, length int, typ int, modifiers int) {
+		ret = append(ret, semanticToken{
+			Pos:       q.Pos + token.Pos(pos),
+			End:       q.Pos + token.Pos(int(pos)+length),
+			Type:      typ,
+			Modifiers: modifiers,
+		})
+	}
+
+	// The lexer doesn't know @ modifiers, they are classified separately
+	content := []byte(q.Content)
+
+	for _, at := range q.AtModifiers {
+		text := q.Content[at.PosRange.Start:at.PosRange.End]
+
+		add(at.PosRange.Start, 1, tokenOperator, 0)
+
+		arg := strings.TrimLeft(text[1:], " \t\r\n")
+		argPos := at.PosRange.End - promql.Pos(len(arg))
+
+		if at.Preprocessor != "" {
+			add(argPos, len(at.Preprocessor), tokenFunction, 0)
+		} else {
+			add(argPos, len(arg), tokenNumber, 0)
+		}
+
+		for i := at.PosRange.Start; i < at.PosRange.End; i++ {
+			if content[i] != '\n' {
+				content[i] = ' '
+			}
+		}
+	}
+
+	var items []promql.Item
+
+	l := promql.Lex(string(content))
+
+	for {
+		var item promql.Item
+
+		l.NextItem(&item)
+
+		if item.Typ == promql.EOF || item.Typ == promql.ERROR {
+			break
+		}
+
+		items = append(items, item)
+	}
+
+	braces := 0
+	// grouping is set while inside the label list of by, without, on, ignoring, group_left and group_right
+	grouping := false
+	afterGroupingKeyword := false
+	// matcherOp is the operator of the label matcher that is being lexed inside braces
+	var matcherOp promql.ItemType
+
+	for i, item := range items {
+		typ := -1
+		modifiers := 0
+
+		switch item.Typ {
+		case promql.COMMENT:
+			typ = tokenComment
+		case promql.NUMBER:
+			typ = tokenNumber
+		case promql.DURATION:
+			typ, modifiers = tokenNumber, modifierDuration
+		case promql.STRING:
+			typ = tokenString
+			if braces > 0 && (matcherOp == promql.EQL_REGEX || matcherOp == promql.NEQ_REGEX) {
+				typ = tokenRegexp
+			}
+		case promql.METRIC_IDENTIFIER:
+			typ = tokenMetric
+		case promql.IDENTIFIER:
+			switch {
+			case braces > 0 || grouping:
+				typ = tokenLabel
+			case i+1 < len(items) && items[i+1].Typ == promql.LEFT_PAREN && promql.Functions[item.Val] != nil:
+				typ = tokenFunction
+			default:
+				typ = tokenMetric
+			}
+		case promql.SUM, promql.AVG, promql.COUNT, promql.MIN, promql.MAX, promql.STDDEV, promql.STDVAR,
+			promql.TOPK, promql.BOTTOMK, promql.COUNT_VALUES, promql.QUANTILE:
+			typ = tokenKeyword
+			if grouping {
+				typ = tokenLabel
+			}
+		case promql.BY, promql.WITHOUT, promql.ON, promql.IGNORING, promql.GROUP_LEFT, promql.GROUP_RIGHT:
+			typ = tokenKeyword
+			if grouping {
+				typ = tokenLabel
+			} else {
+				afterGroupingKeyword = true
+			}
+		case promql.OFFSET, promql.BOOL:
+			typ = tokenKeyword
+			if grouping {
+				typ = tokenLabel
+			}
+		case promql.LAND, promql.LOR, promql.LUNLESS:
+			typ = tokenOperator
+			if grouping {
+				typ = tokenLabel
+			}
+		case promql.ADD, promql.SUB, promql.MUL, promql.DIV, promql.MOD, promql.POW,
+			promql.EQL, promql.NEQ, promql.LTE, promql.LSS, promql.GTE, promql.GTR, promql.EQL_REGEX, promql.NEQ_REGEX:
+			typ = tokenOperator
+			matcherOp = item.Typ
+		case promql.ASSIGN:
+			matcherOp = item.Typ
+		case promql.LEFT_BRACE:
+			braces++
+		case promql.RIGHT_BRACE:
+			braces--
+		case promql.LEFT_PAREN:
+			grouping = afterGroupingKeyword
+		case promql.RIGHT_PAREN:
+			grouping = false
+		}
+
+		if item.Typ != promql.BY && item.Typ != promql.WITHOUT && item.Typ != promql.ON && item.Typ != promql.IGNORING &&
+			item.Typ != promql.GROUP_LEFT && item.Typ != promql.GROUP_RIGHT {
+			afterGroupingKeyword = false
+		}
+
+		if typ >= 0 {
+			add(item.Pos, len(item.Val), typ, modifiers)
+		}
+	}
+
+	return ret
+}
+
+// encodeSemanticTokens converts semantic tokens to the relative encoding of the protocol.
+// If rng is not nil, only the tokens starting inside of it are included.
+func encodeSemanticTokens(doc *cache.DocumentHandle, tokens []semanticToken, rng *protocol.Range) *semanticTokens {
+	ret := &semanticTokens{Data: []uint32{}}
+
+	var line, char float64
+
+	for _, t := range tokens {
+		start, err := doc.PosToProtocolPosition(t.Pos)
+		if err != nil {
+			continue
+		}
+
+		end, err := doc.PosToProtocolPosition(t.End)
+		if err != nil {
+			continue
+		}
+
+		// Tokens spanning multiple lines, e.g. multiline strings, are not supported by all clients
+		if start.Line != end.Line || (rng != nil && !positionInRange(start, *rng)) {
+			continue
+		}
+
+		if start.Line != line {
+			char = 0
+		}
+
+		ret.Data = append(ret.Data,
+			uint32(start.Line-line), uint32(start.Character-char), uint32(end.Character-start.Character),
+			uint32(t.Type), uint32(t.Modifiers))
+
+		line, char = start.Line, start.Character
+	}
+
+	return ret
+}
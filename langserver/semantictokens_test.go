@@ -0,0 +1,77 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// TestSemanticTokens checks the classification and encoding of semantic tokens
+func TestSemanticTokens(*testing.T) {
+	h, err := NewHeadlessServer(context.Background(), &Config{}, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	const query = `sum by (job) (rate(http_requests_total{code=~"5.."}[5m])) > 0.1`
+
+	if err := h.AddDocument("query.promql", "promql", query); err != nil {
+		panic(err)
+	}
+
+	uri := protocol.TextDocumentIdentifier{URI: "query.promql"}
+
+	full, err := h.server.SemanticTokensFull(context.Background(), &semanticTokensParams{TextDocument: uri})
+	if err != nil {
+		panic(err)
+	}
+
+	expected := []uint32{
+		0, 0, 3, tokenKeyword, 0, // sum
+		0, 4, 2, tokenKeyword, 0, // by
+		0, 4, 3, tokenLabel, 0, // job
+		0, 6, 4, tokenFunction, 0, // rate
+		0, 5, 19, tokenMetric, 0, // http_requests_total
+		0, 20, 4, tokenLabel, 0, // code
+		0, 4, 2, tokenOperator, 0, // =~
+		0, 2, 5, tokenRegexp, 0, // "5.."
+		0, 7, 2, tokenNumber, modifierDuration, // 5m
+		0, 6, 1, tokenOperator, 0, // >
+		0, 2, 3, tokenNumber, 0, // 0.1
+	}
+
+	if !reflect.DeepEqual(full.Data, expected) {
+		panic(fmt.Sprintf("unexpected semantic tokens, expected %v, got %v", expected, full.Data))
+	}
+
+	rng := protocol.Range{
+		Start: protocol.Position{Line: 0, Character: 14},
+		End:   protocol.Position{Line: 0, Character: 18},
+	}
+
+	ranged, err := h.server.SemanticTokensRange(context.Background(), &semanticTokensRangeParams{TextDocument: uri, Range: rng})
+	if err != nil {
+		panic(err)
+	}
+
+	if !reflect.DeepEqual(ranged.Data, []uint32{0, 14, 4, tokenFunction, 0}) {
+		panic(fmt.Sprintf("unexpected semantic tokens in range, got %v", ranged.Data))
+	}
+}
@@ -26,6 +26,7 @@ import (
 	"os"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 
@@ -51,11 +52,78 @@ type server struct {
 
 	cache cache.DocumentCache
 
-	config *Config
+	// config can be replaced when the configuration file is reloaded, it must be read with getConfig
+	config   *Config
+	configMu sync.RWMutex
 
 	prometheus    api.Client
 	PrometheusURL string
-	prometheusMu  sync.Mutex
+	// prometheusRetention is the retention reported by the connected Prometheus server, or 0 if it is unknown
+	prometheusRetention time.Duration
+	// prometheusLimits are the limits configured on the connected Prometheus server
+	prometheusLimits serverLimits
+	// prometheusMode is the kind of Prometheus server if it has no query API, e.g. an agent
+	prometheusMode string
+	prometheusMu   sync.Mutex
+
+	// endpoints are the named Prometheus servers of the prometheus_servers option and endpointMapping
+	// selects the documents they are used for, the other documents use the server above
+	endpoints       map[string]*endpoint
+	endpointMapping []PrometheusMapping
+	endpointsMu     sync.Mutex
+
+	catalog   *metricCatalog
+	catalogMu sync.RWMutex
+
+	// evaluationTime is the time live checks are run against, the zero time stands for now
+	evaluationTime   time.Time
+	evaluationTimeMu sync.RWMutex
+
+	// backendMessages are the times the kinds of problems with the Prometheus server were last shown to the user
+	backendMessages   map[string]time.Time
+	backendMessagesMu sync.Mutex
+
+	// telemetry collects usage statistics, it is nil unless telemetry is enabled
+	telemetry *usageStats
+
+	// datasource is the state of the connection to Prometheus, limitation explains the features it doesn't
+	// support, metadataUpdated is the last time data was fetched from it and analyzing the number of
+	// documents that are being analyzed
+	datasource      string
+	datasourceURL   string
+	limitation      string
+	metadataUpdated time.Time
+	analyzing       int
+	statusMu        sync.Mutex
+
+	// workspace keeps track of the rule files in the workspace folders
+	workspace *workspaceIndex
+
+	// metadata is the metadata of the Prometheus server refreshed in the background, it is nil if it is
+	// fetched on demand. stopMetadataRefresh ends the subscription to the refresher shared with other sessions.
+	metadata            *metadataSnapshot
+	stopMetadataRefresh func()
+	metadataMu          sync.RWMutex
+
+	// seriesCountCache holds recently counted series, since code lenses are requested after every change
+	seriesCountCache map[seriesCountKey]seriesCountEntry
+	seriesCountMu    sync.Mutex
+
+	// frequencies counts the metrics inserted by completion, to rank them higher
+	frequencies *metricFrequencies
+
+	// history holds the queries run by promql.runQuery and promql.rerunQuery
+	history *queryHistory
+
+	// trace is the level of the $/logTrace notifications requested by the client
+	trace   string
+	traceMu sync.Mutex
+
+	// notifications queues diagnostics and custom notifications while the client is slow to read
+	notifications notificationQueue
+
+	// severities maps diagnostic codes to the severity the client wants them to be reported with
+	severities map[string]protocol.DiagnosticSeverity
 
 	lifetime context.Context
 	exit     func()
@@ -77,40 +145,53 @@ func (s Server) Run() error {
 
 // ServerFromStream generates a Server from a jsonrpc2.Stream
 func ServerFromStream(ctx context.Context, stream jsonrpc2.Stream, config *Config) (context.Context, Server) {
-	s := &server{}
+	s := &server{config: config}
 
-	switch config.RPCTrace {
-	case "text":
-		stream = protocol.LoggingStream(stream, os.Stderr)
-	case "json":
-		stream = JSONLogStream(stream, os.Stderr)
-	}
+	ctx, s.Conn, s.client = protocol.NewServer(ctx, newRPCTraceStream(stream, s), s)
+
+	s.Conn.AddHandler(&traceHandler{s: s})
 
-	ctx, s.Conn, s.client = protocol.NewServer(ctx, stream, s)
-	s.config = config
+	if config.Telemetry != nil && config.Telemetry.Endpoint != "" {
+		s.telemetry = newUsageStats()
+		s.Conn.AddHandler(&telemetryHandler{stats: s.telemetry})
+	}
 
 	s.lifetime, s.exit = context.WithCancel(ctx)
 
 	return ctx, Server{s}
 }
 
+// getConfig returns the current configuration of the server
+func (s *server) getConfig() *Config {
+	s.configMu.RLock()
+	defer s.configMu.RUnlock()
+
+	return s.config
+}
+
 func (s *server) connectPrometheus(url string) error {
 	s.prometheusMu.Lock()
 	defer s.prometheusMu.Unlock()
 
 	s.PrometheusURL = ""
 	s.prometheus = nil
+	s.prometheusRetention = 0
+	s.prometheusLimits = serverLimits{}
+	s.prometheusMode = ""
+
+	s.setLimitation("")
 
 	if strings.TrimSpace(url) == "" {
+		s.setDatasource(datasourceOffline, "")
 		return nil
 	}
 
-	var err error
-
-	s.prometheus, err = api.NewClient(api.Config{Address: url})
+	client, err := s.newPrometheusClient(url)
 	err = errors.Wrapf(err, "Failed to connect to prometheus: %s\n", url)
 
 	if err == nil {
+		s.prometheus = statusClient{client, s, url}
+
 		// nolint: errcheck
 		s.client.LogMessage(s.lifetime, &protocol.LogMessageParams{
 			Type:    protocol.Info,
@@ -118,9 +199,7 @@ func (s *server) connectPrometheus(url string) error {
 		})
 	}
 
-	testurl := fmt.Sprint(url, "/api/v1/status/buildinfo")
-
-	resp, err := http.Get(testurl) // nolint: gosec
+	resp, err := s.getConfig().getBuildInfo(s.lifetime, url)
 	if err != nil {
 		// nolint: errcheck
 		s.client.ShowMessage(s.lifetime, &protocol.ShowMessageParams{
@@ -130,6 +209,8 @@ func (s *server) connectPrometheus(url string) error {
 
 		s.prometheus = nil
 
+		s.setDatasource(datasourceOffline, url)
+
 		return err
 	}
 
@@ -137,11 +218,52 @@ func (s *server) connectPrometheus(url string) error {
 
 	if err == nil {
 		s.PrometheusURL = url
+		s.setDatasource(datasourceConnected, url)
+
+		s.prometheusMode = probeQueryAPI(s.lifetime, s.prometheus)
+
+		switch s.prometheusMode {
+		case "":
+			s.prometheusRetention = fetchRetention(s.lifetime, v1.NewAPI(s.prometheus))
+			s.prometheusLimits = fetchLimits(s.lifetime, v1.NewAPI(s.prometheus))
+		case prometheusFederateOnly:
+			// There are no other APIs to request metadata from
+			s.prometheus = nil
+		}
+		// Agents don't store data for queries, so the retention and query limits don't apply to them
+
+		if limitation := limitationMessage(s.prometheusMode, url); limitation != "" {
+			s.setLimitation(limitation)
+
+			// nolint: errcheck
+			s.client.ShowMessage(s.lifetime, &protocol.ShowMessageParams{
+				Type:    protocol.Warning,
+				Message: limitation,
+			})
+		}
 	}
 
 	return err
 }
 
+// getBuildInfo requests the build information of a Prometheus server with the configured credentials
+// and headers, multi-tenant servers reject requests without a tenant
+func (c *Config) getBuildInfo(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprint(url, "/api/v1/status/buildinfo"), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header = c.requestHeaders()
+
+	rt, err := c.roundTripper(url)
+	if err != nil {
+		return nil, err
+	}
+
+	return (&http.Client{Transport: rt}).Do(req) // nolint: gosec
+}
+
 func (s *server) getPrometheus() v1.API {
 	s.prometheusMu.Lock()
 	defer s.prometheusMu.Unlock()
@@ -160,22 +282,15 @@ func (s *server) getPrometheusURL() string {
 	return s.PrometheusURL
 }
 
-// TCPServer generates a Server listening on the provided TCP Address, creating a new language Server
-// instance for every connection
+// RunTCPServers listens on the provided TCP Address and serves a separate language server session
+// on every connection, see ServeTCP
 func RunTCPServers(ctx context.Context, addr string, config *Config) error {
 	ln, err := net.Listen("tcp", addr)
 	if err != nil {
 		return err
 	}
 
-	for {
-		conn, err := ln.Accept()
-		if err != nil {
-			return err
-		}
-
-		go ServerFromStream(ctx, jsonrpc2.NewHeaderStream(conn, conn), config)
-	}
+	return ServeTCP(ctx, ln, config)
 }
 
 // StdioServer generates a Server talking to stdio
@@ -0,0 +1,119 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// allDiagnostics is the code that selects all diagnostics in severity mappings
+const allDiagnostics = "*"
+
+// severityNames are the names severities can be given by in severity mappings
+var severityNames = map[string]protocol.DiagnosticSeverity{ // nolint: gochecknoglobals
+	"error":       protocol.SeverityError,
+	"warning":     protocol.SeverityWarning,
+	"information": protocol.SeverityInformation,
+	"info":        protocol.SeverityInformation,
+	"hint":        protocol.SeverityHint,
+}
+
+// initializationOptions are the options a client can send with the initialize request
+type initializationOptions struct {
+	// Severities maps diagnostic codes to the severity diagnostics with that code are reported with,
+	// e.g. {"matcher-normalize": "hint"}. The code "*" applies to all diagnostics without a mapping of their own.
+	Severities map[string]string `json:"severities"`
+	// Clients contains severity mappings that only apply to clients whose name contains the key,
+	// ignoring case, e.g. "vim". They take precedence over Severities.
+	Clients map[string]struct {
+		Severities map[string]string `json:"severities"`
+	} `json:"clients"`
+}
+
+// severityMapping returns the severity mapping that applies to a client
+func (o *initializationOptions) severityMapping(clientName string) (map[string]protocol.DiagnosticSeverity, error) {
+	ret := make(map[string]protocol.DiagnosticSeverity)
+
+	add := func(mapping map[string]string) error {
+		for code, name := range mapping {
+			severity, ok := severityNames[strings.ToLower(name)]
+			if !ok {
+				return fmt.Errorf("invalid severity %q for diagnostic code %q, expected error, warning, information or hint", name, code)
+			}
+
+			ret[code] = severity
+		}
+
+		return nil
+	}
+
+	if err := add(o.Severities); err != nil {
+		return nil, err
+	}
+
+	for client, options := range o.Clients {
+		if client != "" && strings.Contains(strings.ToLower(clientName), strings.ToLower(client)) {
+			if err := add(options.Severities); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return ret, nil
+}
+
+// setSeverityMapping reads the severity mapping from the initialization options of a client
+func (s *server) setSeverityMapping(params *protocol.ParamInitialize) error {
+	if params.InitializationOptions == nil {
+		return nil
+	}
+
+	var options initializationOptions
+
+	if err := decodeParams(params.InitializationOptions, &options); err != nil {
+		return err
+	}
+
+	mapping, err := options.severityMapping(params.ClientInfo.Name)
+	if err != nil {
+		return err
+	}
+
+	s.severities = mapping
+
+	return nil
+}
+
+// remapSeverities changes the severities of diagnostics according to the mapping configured by the client
+func (s *server) remapSeverities(diagnostics []protocol.Diagnostic) {
+	if len(s.severities) == 0 {
+		return
+	}
+
+	for i := range diagnostics {
+		code, _ := diagnostics[i].Code.(string)
+
+		severity, ok := s.severities[code]
+		if !ok {
+			severity, ok = s.severities[allDiagnostics]
+		}
+
+		if ok {
+			diagnostics[i].Severity = severity
+		}
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// TestSeverityMapping checks that severities are remapped according to the initialization options
+func TestSeverityMapping(*testing.T) {
+	const options = `{
+		"severities": {"matcher-normalize": "hint", "*": "warning"},
+		"clients": {"vim": {"severities": {"matcher-normalize": "information"}}}
+	}`
+
+	tests := []struct {
+		client   string
+		code     string
+		expected protocol.DiagnosticSeverity
+	}{
+		{"Visual Studio Code", fixMatcherNormalize, protocol.SeverityHint},
+		{"Neovim", fixMatcherNormalize, protocol.SeverityInformation},
+		{"Neovim", codeRuleOrder, protocol.SeverityWarning},
+	}
+
+	for _, test := range tests {
+		params := &protocol.ParamInitialize{}
+		params.ClientInfo.Name = test.client
+
+		if err := json.Unmarshal([]byte(options), &params.InitializationOptions); err != nil {
+			panic(err)
+		}
+
+		s := &server{}
+		if err := s.setSeverityMapping(params); err != nil {
+			panic(err)
+		}
+
+		diagnostics := []protocol.Diagnostic{{Code: test.code, Severity: protocol.SeverityError}}
+		s.remapSeverities(diagnostics)
+
+		if diagnostics[0].Severity != test.expected {
+			panic(fmt.Sprintf("Expected severity %v for %s in %s, got %v", test.expected, test.code, test.client, diagnostics[0].Severity))
+		}
+	}
+
+	params := &protocol.ParamInitialize{}
+	params.InitializationOptions = map[string]interface{}{
+		"severities": map[string]interface{}{"rule-order": "fatal"},
+	}
+
+	if err := (&server{}).setSeverityMapping(params); err == nil {
+		panic("Expected an error for an invalid severity")
+	}
+}
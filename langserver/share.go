@@ -0,0 +1,234 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/prometheus-community/promql-langserver/langserver/cache"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/jsonrpc2"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// commandShareQuery returns links that open the query at a position in the Prometheus UI, Grafana Explore
+// or the configured tools
+const commandShareQuery = "promql.shareQuery"
+
+// defaultShareRange is the time range of shared queries if neither the command nor the configuration sets one
+const defaultShareRange = "1h"
+
+// prometheusUITimeFormat is the format of the end time of the graph page of the Prometheus UI
+const prometheusUITimeFormat = "2006-01-02 15:04:05"
+
+// ShareLinksConfig configures the links generated by the promql.shareQuery command
+type ShareLinksConfig struct {
+	// PrometheusURL is the URL of the Prometheus web UI. The Prometheus server of the document is used if it isn't set.
+	PrometheusURL string `yaml:"prometheus_url"`
+	// GrafanaURL is the URL of a Grafana instance, links to Grafana Explore are only generated if it is set
+	GrafanaURL string `yaml:"grafana_url"`
+	// GrafanaDatasource is the name of the Prometheus datasource in Grafana, the default datasource is used if it isn't set
+	GrafanaDatasource string `yaml:"grafana_datasource"`
+	// URLTemplates are further links by name. They are Go templates expanded with the .Query, the .Range,
+	// e.g. 1h, and the .Start and .End of the time range as unix timestamps, e.g.
+	// "https://wiki.example.com/query?expr={{ .Query | urlquery }}&range={{ .Range }}"
+	URLTemplates map[string]string `yaml:"url_templates"`
+	// Range is the time range of the links, 1h by default
+	Range string `yaml:"range"`
+}
+
+// shareQueryParams are the parameters of the promql.shareQuery command. Either Query or
+// a position inside a query of a document is given.
+type shareQueryParams struct {
+	Query        string                          `json:"query,omitempty"`
+	TextDocument protocol.TextDocumentIdentifier `json:"textDocument"`
+	Position     protocol.Position               `json:"position"`
+	// Range overrides the time range of the share_links option, e.g. 6h
+	Range string `json:"range,omitempty"`
+}
+
+// shareLink is a link generated by the promql.shareQuery command
+type shareLink struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// shareQueryResult is the result of the promql.shareQuery command
+type shareQueryResult struct {
+	Query string      `json:"query"`
+	Links []shareLink `json:"links"`
+}
+
+// shareTemplateData is the data the url_templates are expanded with
+type shareTemplateData struct {
+	Query string
+	Range string
+	Start int64
+	End   int64
+}
+
+// shareQuery implements the promql.shareQuery command
+func (s *server) shareQuery(_ context.Context, params *shareQueryParams) (*shareQueryResult, error) {
+	query := &cache.CompiledQuery{Content: params.Query}
+
+	if params.Query == "" {
+		doc, err := s.cache.GetDocument(params.TextDocument.URI)
+		if err != nil {
+			return nil, err
+		}
+
+		pos, err := doc.ProtocolPositionToTokenPos(params.Position)
+		if err != nil {
+			return nil, err
+		}
+
+		if query, err = doc.GetQuery(pos); err != nil {
+			return nil, jsonrpc2.NewErrorf(jsonrpc2.CodeInvalidParams, "there is no query at this position")
+		}
+	}
+
+	config := s.getConfig().ShareLinks
+	if config == nil {
+		config = &ShareLinksConfig{}
+	}
+
+	rng := params.Range
+	if rng == "" {
+		rng = config.Range
+	}
+
+	prometheusURL := config.PrometheusURL
+	if prometheusURL == "" {
+		prometheusURL = s.getPrometheusURLFor(params.TextDocument.URI)
+	}
+
+	if prometheusURL == "" {
+		// The web UI may be reachable for teammates even if the server isn't connected
+		prometheusURL = s.getConfig().PrometheusURL
+	}
+
+	text := sharedQueryText(query)
+
+	// The zero end time stands for the current time, so the links stay relative
+	links, err := config.links(text, rng, s.getEvaluationTime(query), prometheusURL)
+	if err != nil {
+		return nil, jsonrpc2.NewErrorf(jsonrpc2.CodeInvalidParams, "%s", err.Error())
+	}
+
+	return &shareQueryResult{Query: text, Links: links}, nil
+}
+
+// sharedQueryText returns the query as it is typed into other tools, i.e. without the escaping of JSON strings
+func sharedQueryText(query *cache.CompiledQuery) string {
+	text := strings.TrimSpace(query.Content)
+
+	if query.InJSONString {
+		if unquoted, err := strconv.Unquote(`"` + text + `"`); err == nil {
+			return unquoted
+		}
+	}
+
+	return text
+}
+
+// links generates the share links of a query, end is the end of the time range, the zero time stands for now
+func (c *ShareLinksConfig) links(query string, rng string, end time.Time, prometheusURL string) ([]shareLink, error) {
+	if rng == "" {
+		rng = defaultShareRange
+	}
+
+	duration, err := cache.ParseDuration(rng)
+	if err != nil || duration <= 0 {
+		return nil, errors.Errorf("invalid range %q, expected a duration like 1h", rng)
+	}
+
+	var links []shareLink
+
+	if prometheusURL != "" {
+		values := url.Values{
+			"g0.expr":        {query},
+			"g0.tab":         {"0"},
+			"g0.range_input": {rng},
+		}
+
+		if !end.IsZero() {
+			values.Set("g0.end_input", end.UTC().Format(prometheusUITimeFormat))
+		}
+
+		links = append(links, shareLink{
+			Name: "Prometheus",
+			URL:  strings.TrimSuffix(prometheusURL, "/") + "/graph?" + values.Encode(),
+		})
+	}
+
+	if c.GrafanaURL != "" {
+		from, to := "now-"+rng, "now"
+		if !end.IsZero() {
+			from = strconv.FormatInt(end.Add(-duration).UnixNano()/int64(time.Millisecond), 10)
+			to = strconv.FormatInt(end.UnixNano()/int64(time.Millisecond), 10)
+		}
+
+		left, err := json.Marshal([]interface{}{from, to, c.GrafanaDatasource, map[string]string{"expr": query}})
+		if err != nil {
+			return nil, err
+		}
+
+		links = append(links, shareLink{
+			Name: "Grafana Explore",
+			URL:  strings.TrimSuffix(c.GrafanaURL, "/") + "/explore?" + url.Values{"left": {string(left)}}.Encode(),
+		})
+	}
+
+	names := make([]string, 0, len(c.URLTemplates))
+	for name := range c.URLTemplates {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	if end.IsZero() {
+		end = time.Now()
+	}
+
+	data := shareTemplateData{Query: query, Range: rng, Start: end.Add(-duration).Unix(), End: end.Unix()}
+
+	for _, name := range names {
+		tmpl, err := template.New(name).Parse(c.URLTemplates[name])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid share_links.url_templates.%s", name)
+		}
+
+		var link strings.Builder
+
+		if err := tmpl.Execute(&link, data); err != nil {
+			return nil, errors.Wrapf(err, "invalid share_links.url_templates.%s", name)
+		}
+
+		links = append(links, shareLink{Name: name, URL: link.String()})
+	}
+
+	if len(links) == 0 {
+		return nil, errors.New("no Prometheus server or share_links configured")
+	}
+
+	return links, nil
+}
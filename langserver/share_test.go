@@ -0,0 +1,85 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// TestShareQuery checks the links generated for the query at a position
+func TestShareQuery(*testing.T) {
+	config := &Config{
+		PrometheusURL: "http://prometheus:9090",
+		ShareLinks: &ShareLinksConfig{
+			GrafanaURL:        "https://grafana.example.com/",
+			GrafanaDatasource: "Prometheus",
+			URLTemplates:      map[string]string{"wiki": "https://wiki.example.com/?q={{ .Query | urlquery }}&from={{ .Start }}"},
+		},
+	}
+
+	h, err := NewHeadlessServer(context.Background(), config, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	const rules = `groups:
+- name: example
+  rules:
+  - alert: Down
+    expr: up{job="node"} == 0
+`
+
+	if err := h.AddDocument("rules.yml", "yaml", rules); err != nil {
+		panic(err)
+	}
+
+	ret, err := h.server.ExecuteCommand(context.Background(), &protocol.ExecuteCommandParams{
+		Command: commandShareQuery,
+		Arguments: []interface{}{map[string]interface{}{
+			"textDocument": map[string]interface{}{"uri": "rules.yml"},
+			"position":     map[string]interface{}{"line": 4, "character": 12},
+			"range":        "6h",
+		}},
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	result := ret.(*shareQueryResult)
+	if result.Query != `up{job="node"} == 0` || len(result.Links) != 3 {
+		panic(fmt.Sprintf("expected three links for the alert expression, got %+v", result))
+	}
+
+	prometheus, err := url.Parse(result.Links[0].URL)
+	if err != nil || prometheus.Host != "prometheus:9090" || prometheus.Path != "/graph" ||
+		prometheus.Query().Get("g0.expr") != result.Query || prometheus.Query().Get("g0.range_input") != "6h" {
+		panic(fmt.Sprintf("unexpected Prometheus link %s", result.Links[0].URL))
+	}
+
+	grafana, err := url.Parse(result.Links[1].URL)
+	if err != nil || grafana.Path != "/explore" ||
+		grafana.Query().Get("left") != `["now-6h","now","Prometheus",{"expr":"up{job=\"node\"} == 0"}]` {
+		panic(fmt.Sprintf("unexpected Grafana link %s", result.Links[1].URL))
+	}
+
+	if wiki := result.Links[2]; wiki.Name != "wiki" || wiki.URL[:48] != "https://wiki.example.com/?q=up%7Bjob%3D%22node%2" {
+		panic(fmt.Sprintf("unexpected templated link %+v", wiki))
+	}
+}
@@ -17,34 +17,124 @@ import (
 	"context"
 	"errors"
 
+	"github.com/prometheus-community/promql-langserver/langserver/cache"
 	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
 	"github.com/prometheus/prometheus/promql"
 )
 
 // SignatureHelp is required by the protocol.Server interface
 func (s *server) SignatureHelp(ctx context.Context, params *protocol.SignatureHelpParams) (*protocol.SignatureHelp, error) {
-	location, err := s.cache.Find(&params.TextDocumentPositionParams)
+	doc, err := s.cache.GetDocument(params.TextDocument.URI)
 	if err != nil {
 		return nil, nil
 	}
 
-	call, ok := location.Node.(*promql.Call)
+	pos, err := doc.ProtocolPositionToTokenPos(params.Position)
+	if err != nil {
+		return nil, nil
+	}
+
+	// Calls are usually incomplete while typing, so queries that failed to parse are searched, too
+	queries, err := doc.GetQueries()
+	if err != nil {
+		return nil, nil
+	}
+
+	var query *cache.CompiledQuery
+
+	for _, q := range queries {
+		if q.Pos <= pos && int(pos-q.Pos) <= len(q.Content) {
+			query = q
+			break
+		}
+	}
+
+	if query == nil {
+		return nil, nil
+	}
+
+	name, argument, ok := enclosingCall(query.Content[:pos-query.Pos])
 	if !ok {
 		return nil, nil
 	}
 
-	signature, err := getSignature(call.Func.Name)
+	signature, err := getSignature(name)
 	if err != nil {
 		return nil, nil
 	}
 
+	signature.Documentation = funcDocStrings(name)
+
+	// Variadic functions end with a "..." parameter, which stands for all further arguments
+	if argument >= len(signature.Parameters) {
+		argument = len(signature.Parameters) - 1
+	}
+
 	response := &protocol.SignatureHelp{
-		Signatures: []protocol.SignatureInformation{signature},
+		Signatures:      []protocol.SignatureInformation{signature},
+		ActiveParameter: float64(argument),
 	}
 
 	return response, nil
 }
 
+// enclosingCall finds the innermost function call whose argument list contains the end of a
+// query prefix. It returns the name of the function and the index of the argument the prefix ends in.
+// The lexer is used instead of the AST, so that incomplete calls are found, too.
+func enclosingCall(prefix string) (string, int, bool) {
+	// function is empty for parentheses and braces that don't belong to a function call
+	type group struct {
+		function string
+		argument int
+	}
+
+	var (
+		groups []group
+		last   promql.Item
+	)
+
+	l := promql.Lex(prefix)
+
+lexing:
+	for {
+		var item promql.Item
+
+		l.NextItem(&item)
+
+		switch item.Typ {
+		case promql.EOF, promql.ERROR:
+			break lexing
+		case promql.LEFT_PAREN:
+			g := group{}
+			if last.Typ == promql.IDENTIFIER {
+				g.function = last.Val
+			}
+
+			groups = append(groups, g)
+		case promql.LEFT_BRACE:
+			groups = append(groups, group{})
+		case promql.RIGHT_PAREN, promql.RIGHT_BRACE:
+			if len(groups) > 0 {
+				groups = groups[:len(groups)-1]
+			}
+		case promql.COMMA:
+			if len(groups) > 0 {
+				groups[len(groups)-1].argument++
+			}
+		}
+
+		last = item
+	}
+
+	if len(groups) == 0 || groups[len(groups)-1].function == "" {
+		return "", 0, false
+	}
+
+	g := groups[len(groups)-1]
+
+	return g.function, g.argument, true
+}
+
 // nolint: funlen
 func getSignature(name string) (protocol.SignatureInformation, error) {
 	var signatures = map[string]protocol.SignatureInformation{
@@ -60,12 +150,24 @@ func getSignature(name string) (protocol.SignatureInformation, error) {
 				{Label: "v instant-vector"},
 			},
 		},
+		"absent_over_time": {
+			Label: "absent_over_time(v range-vector)",
+			Parameters: []protocol.ParameterInformation{
+				{Label: "v range-vector"},
+			},
+		},
 		"ceil": {
 			Label: "ceil(v instant-vector)",
 			Parameters: []protocol.ParameterInformation{
 				{Label: "v instant-vector"},
 			},
 		},
+		"changes": {
+			Label: "changes(v range-vector)",
+			Parameters: []protocol.ParameterInformation{
+				{Label: "v range-vector"},
+			},
+		},
 		"clamp_max": {
 			Label: "clamp_max(v instant-vector, max scalar)",
 			Parameters: []protocol.ParameterInformation{
@@ -92,8 +194,8 @@ func getSignature(name string) (protocol.SignatureInformation, error) {
 				{Label: "v=vector(time()) instant-vector"},
 			},
 		},
-		"day_in_month": {
-			Label: "day_in_month(v=vector(time()) instant-vector)",
+		"days_in_month": {
+			Label: "days_in_month(v=vector(time()) instant-vector)",
 			Parameters: []protocol.ParameterInformation{
 				{Label: "v=vector(time()) instant-vector"},
 			},
@@ -256,6 +358,12 @@ func getSignature(name string) (protocol.SignatureInformation, error) {
 				{Label: "v instant-vector"},
 			},
 		},
+		"sqrt": {
+			Label: "sqrt(v instant-vector)",
+			Parameters: []protocol.ParameterInformation{
+				{Label: "v instant-vector"},
+			},
+		},
 		"time": {
 			Label:      "time()",
 			Parameters: []protocol.ParameterInformation{},
@@ -320,7 +428,7 @@ func getSignature(name string) (protocol.SignatureInformation, error) {
 				{Label: "v range-vector"},
 			},
 		},
-		"qunatile_over_time": {
+		"quantile_over_time": {
 			Label: "quantile_over_time(s scalar, v range-vector)",
 			Parameters: []protocol.ParameterInformation{
 				{Label: "s scalar"},
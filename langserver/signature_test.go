@@ -0,0 +1,88 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// TestSignatureHelp checks that the innermost call around the cursor is found in incomplete queries
+func TestSignatureHelp(*testing.T) {
+	h, err := NewHeadlessServer(context.Background(), &Config{}, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	tests := []struct {
+		query     string
+		signature string
+		parameter float64
+	}{
+		{`rate(`, "rate(v range-vector)", 0},
+		{`histogram_quantile(0.9, `, "histogram_quantile(φ float, b instant-vector)", 1},
+		{`histogram_quantile(0.9, rate(foo{a="b", c="d"}[5m]`, "rate(v range-vector)", 0},
+		{`label_join(foo, "a", ",", "b", "c", "d", `, "", 5},
+		{`sum(`, "", -1},
+	}
+
+	for i, test := range tests {
+		uri := protocol.DocumentURI(fmt.Sprintf("query%d.promql", i))
+
+		if err := h.AddDocument(uri, "promql", test.query); err != nil {
+			panic(err)
+		}
+
+		help, err := h.server.SignatureHelp(context.Background(), &protocol.SignatureHelpParams{
+			TextDocumentPositionParams: protocol.TextDocumentPositionParams{
+				TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+				Position:     protocol.Position{Line: 0, Character: float64(len([]rune(test.query)))},
+			},
+		})
+		if err != nil {
+			panic(err)
+		}
+
+		if test.parameter < 0 {
+			if help != nil {
+				panic(fmt.Sprintf("expected no signature for %q, got %v", test.query, help.Signatures))
+			}
+
+			continue
+		}
+
+		if help == nil || len(help.Signatures) != 1 {
+			panic(fmt.Sprintf("expected a signature for %q", test.query))
+		}
+
+		signature := help.Signatures[0]
+
+		if test.signature != "" && signature.Label != test.signature {
+			panic(fmt.Sprintf("expected signature %q for %q, got %q", test.signature, test.query, signature.Label))
+		}
+
+		if help.ActiveParameter != test.parameter {
+			panic(fmt.Sprintf("expected parameter %v to be active in %q, got %v", test.parameter, test.query, help.ActiveParameter))
+		}
+
+		if !strings.Contains(signature.Documentation, "##") {
+			panic(fmt.Sprintf("expected the function documentation for %q, got %q", test.query, signature.Documentation))
+		}
+	}
+}
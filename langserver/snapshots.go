@@ -0,0 +1,300 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/jsonrpc2"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+// commandRecordSnapshot records the result shapes of the rules of a document in its snapshot file
+const commandRecordSnapshot = "promql.recordSnapshot"
+
+// codeSnapshotShape is the code of the diagnostics about rules whose result shape differs from the snapshot
+const codeSnapshotShape = "snapshot-shape"
+
+// snapshotSeriesFactor is how much the number of series of a rule may grow or shrink compared to the
+// snapshot before it is reported, smaller changes are expected as targets come and go
+const snapshotSeriesFactor = 2
+
+// QueryShape is the shape of the result of a query
+type QueryShape struct {
+	// Type is the type of the result, e.g. vector or scalar
+	Type string `json:"type"`
+	// Series is the number of series of a vector result
+	Series int `json:"series"`
+	// Labels are the names of the labels of the series of a vector result
+	Labels []string `json:"labels"`
+}
+
+// RuleSnapshot is the shape of the result of a rule when the snapshot was recorded
+type RuleSnapshot struct {
+	Group string     `json:"group"`
+	Kind  string     `json:"kind"`
+	Name  string     `json:"name"`
+	Expr  string     `json:"expr"`
+	Shape QueryShape `json:"shape"`
+}
+
+// Snapshot holds the result shapes of the rules of a rule file, as a lightweight regression check
+// for rules without unit tests
+type Snapshot struct {
+	Recorded time.Time      `json:"recorded"`
+	Rules    []RuleSnapshot `json:"rules"`
+}
+
+// SnapshotPath returns the path of the snapshot file of a rule file
+func SnapshotPath(filename string) string {
+	return filename + ".snapshot.json"
+}
+
+// ReadSnapshot reads a snapshot file
+func ReadSnapshot(path string) (*Snapshot, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret Snapshot
+
+	return &ret, errors.Wrapf(json.Unmarshal(data, &ret), "invalid snapshot %s", path)
+}
+
+// WriteSnapshot writes a snapshot file
+func WriteSnapshot(path string, snapshot *Snapshot) error {
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, append(data, '\n'), 0644) // nolint: gosec
+}
+
+// queryShape evaluates a query and returns the shape of its result
+func queryShape(ctx context.Context, api v1.API, query string, ts time.Time) (QueryShape, error) {
+	value, _, err := api.Query(ctx, query, ts)
+	if err != nil {
+		return QueryShape{}, err
+	}
+
+	ret := QueryShape{Type: value.Type().String(), Labels: []string{}}
+
+	if vector, ok := value.(model.Vector); ok {
+		ret.Series = len(vector)
+
+		seen := make(map[model.LabelName]bool)
+
+		for _, sample := range vector {
+			for name := range sample.Metric {
+				if !seen[name] {
+					seen[name] = true
+
+					ret.Labels = append(ret.Labels, string(name))
+				}
+			}
+		}
+
+		sort.Strings(ret.Labels)
+	}
+
+	return ret, nil
+}
+
+// snapshotRules returns the rules of a document that have a query, in the order of the document
+func (s *server) snapshotRules(uri string) ([]ruleKey, map[ruleKey]*comparedRule, error) {
+	rules, err := s.documentRules(uri)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keys := make([]ruleKey, 0, len(rules))
+
+	for key, rule := range rules {
+		if rule.rule.Query != nil && rule.rule.Query.Ast != nil && len(rule.rule.Query.Err) == 0 {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return rules[keys[i]].summary.Line < rules[keys[j]].summary.Line })
+
+	return keys, rules, nil
+}
+
+// recordSnapshot evaluates the rules of a document and records the shapes of their results
+func (s *server) recordSnapshot(ctx context.Context, uri string) (*Snapshot, error) {
+	api := s.getQueryAPIFor(uri)
+	if api == nil {
+		return nil, errors.New("recording a snapshot requires a Prometheus server that can evaluate queries")
+	}
+
+	keys, rules, err := s.snapshotRules(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &Snapshot{Recorded: s.evaluationTimeOrNow(nil).UTC(), Rules: []RuleSnapshot{}}
+
+	for _, key := range keys {
+		rule := rules[key]
+
+		shape, err := queryShape(ctx, api, rule.summary.Expr, s.evaluationTimeOrNow(rule.rule.Query))
+		if err != nil {
+			s.reportBackendError(err)
+			return nil, errors.Wrapf(err, "failed to evaluate %s", rule.summary.Name)
+		}
+
+		ret.Rules = append(ret.Rules, RuleSnapshot{
+			Group: key.group,
+			Kind:  key.kind,
+			Name:  key.name,
+			Expr:  rule.summary.Expr,
+			Shape: shape,
+		})
+	}
+
+	return ret, nil
+}
+
+// shapeChange describes how the result shape of a rule changed compared to the snapshot,
+// or returns "" if the change is within what is expected over time
+func shapeChange(old QueryShape, current QueryShape) string {
+	switch {
+	case old.Type != current.Type:
+		return fmt.Sprintf("returns a %s instead of a %s", current.Type, old.Type)
+	case strings.Join(old.Labels, ",") != strings.Join(current.Labels, ","):
+		return fmt.Sprintf("returns series with the labels [%s] instead of [%s]",
+			strings.Join(current.Labels, " "), strings.Join(old.Labels, " "))
+	case old.Series > 0 && current.Series == 0:
+		return fmt.Sprintf("returns no series instead of %d", old.Series)
+	case old.Series == 0 && current.Series > 0:
+		return fmt.Sprintf("returns %d series instead of none", current.Series)
+	case current.Series > old.Series*snapshotSeriesFactor || current.Series*snapshotSeriesFactor < old.Series:
+		return fmt.Sprintf("returns %d series instead of %d", current.Series, old.Series)
+	}
+
+	return ""
+}
+
+// snapshotDiagnostics reports rules whose expression was edited since the snapshot and whose result
+// shape changed unexpectedly with it. Rules that weren't edited are not evaluated.
+func (s *server) snapshotDiagnostics(ctx context.Context, uri string, snapshot *Snapshot) ([]protocol.Diagnostic, error) {
+	api := s.getQueryAPIFor(uri)
+	if api == nil {
+		return nil, errors.New("comparing with a snapshot requires a Prometheus server that can evaluate queries")
+	}
+
+	keys, rules, err := s.snapshotRules(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	recorded := make(map[ruleKey]RuleSnapshot)
+
+	for _, r := range snapshot.Rules {
+		key := ruleKey{group: r.Group, kind: r.Kind, name: r.Name}
+
+		for _, ok := recorded[key]; ok; _, ok = recorded[key] {
+			key.n++
+		}
+
+		recorded[key] = r
+	}
+
+	var ret []protocol.Diagnostic
+
+	for _, key := range keys {
+		rule := rules[key]
+
+		old, ok := recorded[key]
+		if !ok || old.Expr == rule.summary.Expr {
+			continue
+		}
+
+		shape, err := queryShape(ctx, api, rule.summary.Expr, s.evaluationTimeOrNow(rule.rule.Query))
+		if err != nil {
+			s.reportBackendError(err)
+			return nil, errors.Wrapf(err, "failed to evaluate %s", rule.summary.Name)
+		}
+
+		change := shapeChange(old.Shape, shape)
+		if change == "" {
+			continue
+		}
+
+		rng, err := tokenRange(rule.doc, rule.rule.NamePos, rule.rule.NameEnd)
+		if err != nil {
+			continue
+		}
+
+		ret = append(ret, protocol.Diagnostic{
+			Range:    rng,
+			Severity: 2, // Warning
+			Code:     codeSnapshotShape,
+			Source:   "promql-lsp",
+			Message: fmt.Sprintf("since the snapshot of %s, the edited expression %s",
+				snapshot.Recorded.Format(time.RFC3339), change),
+		})
+	}
+
+	return ret, nil
+}
+
+// RecordSnapshot evaluates the rules of a document that has been added to the server
+// and records the shapes of their results
+func (h HeadlessServer) RecordSnapshot(uri string) (*Snapshot, error) {
+	return h.server.recordSnapshot(h.server.lifetime, uri)
+}
+
+// SnapshotDiagnostics compares the rules of a document that has been added to the server with a snapshot.
+// Rules whose expression was edited are evaluated and reported if the shape of their result changed.
+func (h HeadlessServer) SnapshotDiagnostics(uri string, snapshot *Snapshot) ([]protocol.Diagnostic, error) {
+	return h.server.snapshotDiagnostics(h.server.lifetime, uri, snapshot)
+}
+
+// snapshotParams are the parameters of the promql.recordSnapshot command
+type snapshotParams struct {
+	TextDocument protocol.TextDocumentIdentifier `json:"textDocument"`
+}
+
+// recordSnapshotCommand implements the promql.recordSnapshot command. The snapshot is written next to
+// the rule file and returned.
+func (s *server) recordSnapshotCommand(ctx context.Context, params *snapshotParams) (*Snapshot, error) {
+	path := uriPath(params.TextDocument.URI)
+	if path == "" {
+		return nil, jsonrpc2.NewErrorf(jsonrpc2.CodeInvalidParams, "snapshots can only be recorded for files")
+	}
+
+	snapshot, err := s.recordSnapshot(ctx, params.TextDocument.URI)
+	if err != nil {
+		return nil, jsonrpc2.NewErrorf(jsonrpc2.CodeInternalError, "%s", err.Error())
+	}
+
+	if err := WriteSnapshot(SnapshotPath(path), snapshot); err != nil {
+		return nil, err
+	}
+
+	return snapshot, nil
+}
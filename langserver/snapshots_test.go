@@ -0,0 +1,109 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestSnapshots checks that edited rules are reported if the shape of their result changes
+func TestSnapshots(*testing.T) {
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.URL.Path != "/api/v1/query" {
+			fmt.Fprint(w, `{"status":"success","data":{}}`)
+			return
+		}
+
+		var result string
+
+		switch query := r.FormValue("query"); {
+		case strings.Contains(query, "by (job)"):
+			result = `{"metric":{"job":"api"},"value":[0,"1"]},{"metric":{"job":"node"},"value":[0,"1"]}`
+		case strings.Contains(query, "by (instance)"):
+			result = `{"metric":{"instance":"a:9100"},"value":[0,"1"]},{"metric":{"instance":"b:9100"},"value":[0,"1"]}`
+		case strings.Contains(query, "missing"):
+		default:
+			result = `{"metric":{},"value":[0,"1"]}`
+		}
+
+		fmt.Fprintf(w, `{"status":"success","data":{"resultType":"vector","result":[%s]}}`, result)
+	}))
+	defer prom.Close()
+
+	h, err := NewHeadlessServer(context.Background(), &Config{PrometheusURL: prom.URL}, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	const recorded = `groups:
+- name: example
+  rules:
+  - record: job:up:sum
+    expr: sum by (job) (up)
+  - record: up:sum
+    expr: sum(up)
+  - record: up:count
+    expr: count(up)
+`
+
+	if err := h.AddDocument("recorded.yml", "yaml", recorded); err != nil {
+		panic(err)
+	}
+
+	snapshot, err := h.RecordSnapshot("recorded.yml")
+	if err != nil {
+		panic(err)
+	}
+
+	if len(snapshot.Rules) != 3 || fmt.Sprint(snapshot.Rules[0].Shape.Labels) != "[job]" || snapshot.Rules[0].Shape.Series != 2 {
+		panic(fmt.Sprintf("unexpected snapshot %+v", snapshot))
+	}
+
+	const edited = `groups:
+- name: example
+  rules:
+  - record: job:up:sum
+    expr: sum by (instance) (up)
+  - record: up:sum
+    expr: sum(up{job="missing"})
+  - record: up:count
+    expr: count(up{job="node"})
+`
+
+	if err := h.AddDocument("edited.yml", "yaml", edited); err != nil {
+		panic(err)
+	}
+
+	diagnostics, err := h.SnapshotDiagnostics("edited.yml", snapshot)
+	if err != nil {
+		panic(err)
+	}
+
+	if len(diagnostics) != 2 || !strings.Contains(diagnostics[0].Message, "[instance] instead of [job]") ||
+		!strings.Contains(diagnostics[1].Message, "no series instead of 1") || diagnostics[1].Range.Start.Line != 5 {
+		panic(fmt.Sprintf("expected the changed label set and the empty result to be reported, got %v", diagnostics))
+	}
+
+	if diagnostics, err := h.SnapshotDiagnostics("recorded.yml", snapshot); err != nil || len(diagnostics) != 0 {
+		panic(fmt.Sprintf("expected unchanged rules not to be reported, got %v, %v", diagnostics, err))
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/prometheus/client_golang/api"
+)
+
+// statusMethod is the custom notification that tells the client what the server currently knows.
+// Clients can also send a request with this method to get the current status.
+const statusMethod = "promql/status"
+
+// The states of the connection to the Prometheus server
+const (
+	datasourceConnected = "connected"
+	// datasourceDegraded means that Prometheus answers, but rejects some of the requests
+	datasourceDegraded = "degraded"
+	datasourceOffline  = "offline"
+)
+
+// serverStatus are the params of the promql/status notification
+type serverStatus struct {
+	Datasource    string `json:"datasource"`
+	PrometheusURL string `json:"prometheusURL,omitempty"`
+	// Limitation explains which features are unavailable because of the kind of Prometheus server,
+	// e.g. an agent that can't evaluate queries
+	Limitation string `json:"limitation,omitempty"`
+	// MetadataUpdated is the last time metric data was fetched from Prometheus successfully,
+	// clients can derive the age of the data shown in completions and hovers from it.
+	MetadataUpdated *time.Time `json:"metadataUpdated,omitempty"`
+	Indexing        struct {
+		// Pending is the number of documents that are being analyzed
+		Pending int `json:"pending"`
+		Total   int `json:"total"`
+	} `json:"indexing"`
+}
+
+// statusClient records the outcome of all requests to the Prometheus server in the server status
+type statusClient struct {
+	api.Client
+	s   *server
+	url string
+}
+
+// Do is required by the api.Client interface
+func (c statusClient) Do(ctx context.Context, req *http.Request) (*http.Response, []byte, error) {
+	resp, body, err := c.Client.Do(ctx, req)
+
+	switch {
+	case err != nil && errors.Cause(err) == context.Canceled:
+	case err != nil:
+		c.s.setDatasource(datasourceOffline, c.url)
+	case resp.StatusCode/100 == 2:
+		c.s.statusMu.Lock()
+		c.s.metadataUpdated = time.Now()
+		c.s.statusMu.Unlock()
+
+		c.s.setDatasource(datasourceConnected, c.url)
+	case resp.StatusCode == http.StatusBadGateway || resp.StatusCode == http.StatusServiceUnavailable ||
+		resp.StatusCode == http.StatusGatewayTimeout:
+		c.s.setDatasource(datasourceOffline, c.url)
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden ||
+		resp.StatusCode == http.StatusProxyAuthRequired || resp.StatusCode == http.StatusTooManyRequests ||
+		resp.StatusCode == http.StatusInternalServerError:
+		c.s.setDatasource(datasourceDegraded, c.url)
+	}
+	// Other errors, e.g. bad requests caused by invalid queries, say nothing about the state of Prometheus
+
+	return resp, body, err
+}
+
+// setDatasource updates the state of the connection to Prometheus and notifies the client if it changed
+func (s *server) setDatasource(state string, url string) {
+	s.statusMu.Lock()
+	changed := s.datasource != state || s.datasourceURL != url
+	s.datasource, s.datasourceURL = state, url
+	s.statusMu.Unlock()
+
+	if changed {
+		s.sendStatus()
+	}
+}
+
+// setLimitation updates the explanation of the features the Prometheus server doesn't support
+// and notifies the client if it changed
+func (s *server) setLimitation(limitation string) {
+	s.statusMu.Lock()
+	changed := s.limitation != limitation
+	s.limitation = limitation
+	s.statusMu.Unlock()
+
+	if changed {
+		s.sendStatus()
+	}
+}
+
+// startAnalysis marks a document as being analyzed, the returned function ends the analysis
+func (s *server) startAnalysis() func() {
+	s.statusMu.Lock()
+	s.analyzing++
+	s.statusMu.Unlock()
+
+	s.sendStatus()
+
+	return func() {
+		s.statusMu.Lock()
+		s.analyzing--
+		s.statusMu.Unlock()
+
+		s.sendStatus()
+	}
+}
+
+// getStatus returns the current status of the server
+func (s *server) getStatus() *serverStatus {
+	ret := &serverStatus{}
+
+	s.statusMu.Lock()
+
+	ret.Datasource, ret.PrometheusURL, ret.Limitation = s.datasource, s.datasourceURL, s.limitation
+	if ret.Datasource == "" {
+		ret.Datasource = datasourceOffline
+	}
+
+	if !s.metadataUpdated.IsZero() {
+		updated := s.metadataUpdated
+		ret.MetadataUpdated = &updated
+	}
+
+	ret.Indexing.Pending = s.analyzing
+
+	s.statusMu.Unlock()
+
+	ret.Indexing.Total = len(s.cache.GetDocuments())
+
+	return ret
+}
+
+// sendStatus sends the promql/status notification. The status is read when the notification is sent,
+// so a client that is slow to read only receives the latest one.
+// Servers that aren't connected to a client, e.g. a HeadlessServer, don't send it.
+func (s *server) sendStatus() {
+	if s.Conn == nil {
+		return
+	}
+
+	s.notifications.notify(statusMethod, func() {
+		// nolint: errcheck
+		s.Conn.Notify(s.lifetime, statusMethod, s.getStatus())
+	})
+}
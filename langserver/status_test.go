@@ -0,0 +1,96 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/api"
+)
+
+// TestStatus checks that the status reflects the responses of the Prometheus server
+func TestStatus(*testing.T) {
+	h, err := NewHeadlessServer(context.Background(), &Config{}, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	if status := h.server.getStatus(); status.Datasource != datasourceOffline || status.MetadataUpdated != nil {
+		panic(fmt.Sprintf("unexpected status without Prometheus: %+v", status))
+	}
+
+	code := http.StatusOK
+
+	prometheus := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(code)
+	}))
+	defer prometheus.Close()
+
+	client, err := api.NewClient(api.Config{Address: prometheus.URL})
+	if err != nil {
+		panic(err)
+	}
+
+	c := statusClient{client, h.server, prometheus.URL}
+
+	tests := []struct {
+		code     int
+		expected string
+	}{
+		{http.StatusOK, datasourceConnected},
+		{http.StatusTooManyRequests, datasourceDegraded},
+		// Bad requests don't change the state
+		{http.StatusBadRequest, datasourceDegraded},
+		{http.StatusServiceUnavailable, datasourceOffline},
+	}
+
+	for _, test := range tests {
+		code = test.code
+
+		req, err := http.NewRequest(http.MethodGet, c.URL("/api/v1/query", nil).String(), nil)
+		if err != nil {
+			panic(err)
+		}
+
+		if _, _, err := c.Do(context.Background(), req); err != nil {
+			panic(err)
+		}
+
+		status := h.server.getStatus()
+		if status.Datasource != test.expected || status.PrometheusURL != prometheus.URL || status.MetadataUpdated == nil {
+			panic(fmt.Sprintf("unexpected status after response with code %d: %+v", test.code, status))
+		}
+	}
+
+	if err := h.AddDocument("query.promql", "promql", "up"); err != nil {
+		panic(err)
+	}
+
+	done := h.server.startAnalysis()
+
+	if status := h.server.getStatus(); status.Indexing.Pending != 1 || status.Indexing.Total != 1 {
+		panic(fmt.Sprintf("unexpected indexing progress: %+v", status.Indexing))
+	}
+
+	done()
+
+	if status := h.server.getStatus(); status.Indexing.Pending != 0 {
+		panic(fmt.Sprintf("unexpected indexing progress after analysis: %+v", status.Indexing))
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/prometheus-community/promql-langserver/langserver/cache"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// DocumentStorageConfig configures where the content of the documents is kept
+type DocumentStorageConfig struct {
+	// SpillDir is the directory documents are written to once MemoryLimit is reached.
+	// If it is empty, the default directory for temporary files is used.
+	SpillDir string `yaml:"spill_dir"`
+	// MemoryLimit is the number of bytes of document content kept in memory
+	MemoryLimit int `yaml:"memory_limit"`
+}
+
+// documentStorage returns the storage of the document cache. Without a document_storage option,
+// all documents are kept in memory.
+func (c *Config) documentStorage() (cache.Storage, error) {
+	if c.DocumentStorage == nil {
+		return cache.NewMemoryStorage(), nil
+	}
+
+	if c.DemoMode {
+		return cache.NewMemoryStorage(), errors.New("document_storage is ignored in demo mode, which doesn't allow writing local files")
+	}
+
+	return cache.NewSpillStorage(c.DocumentStorage.SpillDir, c.DocumentStorage.MemoryLimit)
+}
+
+// compileDebounce returns the delay of the compile_debounce option, compiling isn't delayed if it isn't set
+func (c *Config) compileDebounce() (time.Duration, error) {
+	if c.CompileDebounce == "" {
+		return 0, nil
+	}
+
+	debounce, err := cache.ParseDuration(c.CompileDebounce)
+	if err != nil {
+		return 0, fmt.Errorf("invalid compile_debounce: %s", err.Error())
+	}
+
+	return debounce, nil
+}
+
+// applyCompileDebounce configures the document cache with the compile_debounce option
+func (s *server) applyCompileDebounce() {
+	debounce, err := s.getConfig().compileDebounce()
+	if err != nil {
+		// nolint: errcheck
+		s.client.LogMessage(s.lifetime, &protocol.LogMessageParams{
+			Type:    protocol.Error,
+			Message: err.Error(),
+		})
+	}
+
+	s.cache.SetCompileDebounce(debounce)
+}
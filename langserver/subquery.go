@@ -0,0 +1,208 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"fmt"
+	"go/token"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/prometheus-community/promql-langserver/langserver/cache"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/promql"
+)
+
+// subqueryStepRE matches the text in front of the cursor when it
+// is placed behind the colon of a subquery, e.g. `rate(foo[5m])[1h:`
+var subqueryStepRE = regexp.MustCompile(`\[\s*[0-9a-z]+\s*:\s*([0-9a-z]*)$`) // nolint: gochecknoglobals
+
+// queryRule returns the rule a query belongs to, or nil if it isn't part of a rule file
+func queryRule(doc *cache.DocumentHandle, query *cache.CompiledQuery) *cache.Rule {
+	groups, err := doc.GetRuleGroups()
+	if err != nil {
+		return nil
+	}
+
+	for _, group := range groups {
+		for _, rule := range group.Rules {
+			if rule.Query == query {
+				return rule
+			}
+		}
+	}
+
+	return nil
+}
+
+// subqueryBrackets returns the positions of the brackets of a subquery, relative to the query
+func subqueryBrackets(query *cache.CompiledQuery, n *promql.SubqueryExpr) (promql.Pos, promql.Pos, bool) {
+	start := n.Expr.PositionRange().End
+	if int(n.EndPos) > len(query.Content) || start > n.EndPos {
+		return 0, 0, false
+	}
+
+	open := strings.IndexByte(query.Content[start:n.EndPos], '[')
+	if open < 0 {
+		return 0, 0, false
+	}
+
+	closing := strings.IndexByte(query.Content[start+promql.Pos(open):n.EndPos], ']')
+	if closing < 0 {
+		return 0, 0, false
+	}
+
+	return start + promql.Pos(open), start + promql.Pos(open+closing+1), true
+}
+
+// subqueryDiagnostics warns about subqueries whose step is likely not what was intended
+// nolint: funlen
+func subqueryDiagnostics(doc *cache.DocumentHandle) []protocol.Diagnostic {
+	queries, err := doc.GetQueries()
+	if err != nil {
+		return nil
+	}
+
+	var ret []protocol.Diagnostic
+
+	for _, q := range queries {
+		if q.Ast == nil {
+			continue
+		}
+
+		rule := queryRule(doc, q)
+
+		promql.Inspect(q.Ast, func(node promql.Node, _ []promql.Node) error {
+			n, ok := node.(*promql.SubqueryExpr)
+			if !ok {
+				return nil
+			}
+
+			var msg string
+
+			switch {
+			case n.Step == 0 && rule != nil:
+				msg = fmt.Sprintf("subquery without step is evaluated at the global evaluation interval of Prometheus, not the interval of group %q (every %s); set the step explicitly",
+					rule.Group.Name, model.Duration(groupInterval(rule.Group)))
+			case n.Step == 0:
+				msg = fmt.Sprintf("subquery without step is evaluated at the global evaluation interval of Prometheus (%s by default); set the step explicitly",
+					model.Duration(defaultEvaluationInterval))
+			case n.Step >= n.Range:
+				msg = fmt.Sprintf("subquery step %s is not smaller than its range %s, so it selects at most one sample per series",
+					model.Duration(n.Step), model.Duration(n.Range))
+			default:
+				return nil
+			}
+
+			start, end, ok := subqueryBrackets(q, n)
+			if !ok {
+				return nil
+			}
+
+			rng, err := tokenRange(doc, q.Pos+token.Pos(start), q.Pos+token.Pos(end))
+			if err != nil {
+				return nil
+			}
+
+			ret = append(ret, protocol.Diagnostic{
+				Range:    rng,
+				Severity: 2, // Warning
+				Code:     codeSubqueryStep,
+				Source:   "promql-lsp",
+				Message:  msg,
+			})
+
+			return nil
+		})
+	}
+
+	return ret
+}
+
+// subqueryStepCompletion completes the step of a subquery when the cursor is placed behind its colon
+func subqueryStepCompletion(doc *cache.DocumentHandle, position protocol.Position) []protocol.CompletionItem {
+	pos, err := doc.ProtocolPositionToTokenPos(position)
+	if err != nil {
+		return nil
+	}
+
+	queries, err := doc.GetQueries()
+	if err != nil {
+		return nil
+	}
+
+	for _, q := range queries {
+		if pos < q.Pos || int(pos-q.Pos) > len(q.Content) {
+			continue
+		}
+
+		match := subqueryStepRE.FindStringSubmatch(q.Content[:pos-q.Pos])
+		if match == nil {
+			return nil
+		}
+
+		step := match[1]
+
+		rng, err := tokenRange(doc, pos-token.Pos(len(step)), pos)
+		if err != nil {
+			return nil
+		}
+
+		if step != "" {
+			return durationUnitCompletions(step, rng)
+		}
+
+		return stepSuggestions(queryRule(doc, q), rng)
+	}
+
+	return nil
+}
+
+// stepSuggestions proposes steps for a subquery, preferring the evaluation interval of the rule group
+func stepSuggestions(rule *cache.Rule, rng protocol.Range) []protocol.CompletionItem {
+	var ret []protocol.CompletionItem
+
+	seen := make(map[time.Duration]bool)
+
+	add := func(step time.Duration, detail string, preselect bool) {
+		if seen[step] {
+			return
+		}
+
+		seen[step] = true
+
+		ret = append(ret, protocol.CompletionItem{
+			Label:     model.Duration(step).String(),
+			Kind:      protocol.UnitCompletion,
+			Detail:    detail,
+			Preselect: preselect,
+			SortText:  fmt.Sprintf("%02d", len(ret)),
+			TextEdit:  &protocol.TextEdit{Range: rng, NewText: model.Duration(step).String()},
+		})
+	}
+
+	if rule != nil {
+		add(groupInterval(rule.Group), fmt.Sprintf("evaluation interval of group %q", rule.Group.Name), true)
+	} else {
+		add(defaultEvaluationInterval, "default evaluation interval", true)
+	}
+
+	for _, step := range []time.Duration{30 * time.Second, time.Minute, 5 * time.Minute} {
+		add(step, "", false)
+	}
+
+	return ret
+}
@@ -0,0 +1,111 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// TestSubqueryStepDiagnostics checks that subqueries without step or with a step not smaller than their range are reported
+func TestSubqueryStepDiagnostics(*testing.T) {
+	h, err := NewHeadlessServer(context.Background(), &Config{}, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	report, err := h.AnalyzeDocument("rules.yml", "yaml", `groups:
+- name: fast
+  interval: 30s
+  rules:
+  - record: a
+    expr: max_over_time(rate(foo[5m])[1h:])
+  - record: b
+    expr: max_over_time(rate(foo[5m])[1h:1h])
+  - record: c
+    expr: max_over_time(rate(foo[5m])[1h:30s])
+`)
+	if err != nil {
+		panic(err)
+	}
+
+	var got []string
+
+	for _, d := range report.Diagnostics {
+		if d.Code == codeSubqueryStep {
+			got = append(got, fmt.Sprintf("%d:%d-%d", int(d.Range.Start.Line), int(d.Range.Start.Character), int(d.Range.End.Character)))
+
+			if d.Range.Start.Line == 5 && !strings.Contains(d.Message, `group "fast" (every 30s)`) {
+				panic("expected the default step warning to name the interval of the group, got " + d.Message)
+			}
+		}
+	}
+
+	if fmt.Sprint(got) != "[5:37-42 7:37-44]" {
+		panic(fmt.Sprintf("expected warnings for the subqueries without step and with a step of 1h, got %v", got))
+	}
+
+	report, err = h.AnalyzeDocument("query.promql", "promql", `max_over_time(rate(foo[5m])[1h:])`)
+	if err != nil {
+		panic(err)
+	}
+
+	if len(report.Diagnostics) != 1 || !strings.Contains(report.Diagnostics[0].Message, "(1m by default)") {
+		panic(fmt.Sprintf("expected a warning about the global evaluation interval, got %v", report.Diagnostics))
+	}
+}
+
+// TestSubqueryStepCompletion checks that the evaluation interval of the rule group is proposed as subquery step
+func TestSubqueryStepCompletion(*testing.T) {
+	h, err := NewHeadlessServer(context.Background(), &Config{}, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	if err := h.AddDocument("rules.yml", "yaml", `groups:
+- name: slow
+  interval: 2m
+  rules:
+  - record: a
+    expr: max_over_time(rate(foo[5m])[1h:])
+`); err != nil {
+		panic(err)
+	}
+
+	list, err := h.server.Completion(context.Background(), &protocol.CompletionParams{
+		TextDocumentPositionParams: protocol.TextDocumentPositionParams{
+			TextDocument: protocol.TextDocumentIdentifier{URI: "rules.yml"},
+			Position:     protocol.Position{Line: 5, Character: 41},
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	var got []string
+
+	for _, item := range list.Items {
+		got = append(got, item.Label)
+	}
+
+	if fmt.Sprint(got) != "[2m 30s 1m 5m]" || !list.Items[0].Preselect {
+		panic(fmt.Sprintf("expected the interval of the group to be preselected, got %v", got))
+	}
+}
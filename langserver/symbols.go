@@ -0,0 +1,230 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"go/token"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/prometheus-community/promql-langserver/langserver/cache"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+	"github.com/prometheus/common/model"
+)
+
+// maxSymbolDetail is the length expressions are shortened to in the outline
+const maxSymbolDetail = 80
+
+// maxWorkspaceSymbols limits the number of results of a workspace symbol search
+const maxWorkspaceSymbols = 100
+
+// DocumentSymbol returns the outline of a rule file: the rule groups with their recording and alerting rules
+// required by the protocol.Server interface
+func (s *server) DocumentSymbol(_ context.Context, params *protocol.DocumentSymbolParams) ([]protocol.DocumentSymbol, error) {
+	doc, err := s.cache.GetDocument(params.TextDocument.URI)
+	if err != nil {
+		return nil, nil
+	}
+
+	groups, err := doc.GetRuleGroups()
+	if err != nil {
+		return nil, nil
+	}
+
+	ret := []protocol.DocumentSymbol{}
+
+	for _, group := range groups {
+		symbol, err := documentSymbol(doc, group.Name, group.Pos, group.End, group.NamePos, group.NameEnd)
+		if err != nil {
+			continue
+		}
+
+		symbol.Kind = protocol.Namespace
+
+		if group.Interval != 0 {
+			symbol.Detail = "every " + model.Duration(group.Interval).String()
+		}
+
+		for _, rule := range group.Rules {
+			child, err := documentSymbol(doc, rule.Name(), rule.Pos, rule.End, rule.NamePos, rule.NameEnd)
+			if err != nil {
+				continue
+			}
+
+			child.Kind = protocol.Variable
+			if rule.Alert != "" {
+				child.Kind = protocol.Event
+			}
+
+			if rule.Query != nil {
+				child.Detail = symbolDetail(rule.Query.Content)
+			}
+
+			symbol.Children = append(symbol.Children, child)
+		}
+
+		ret = append(ret, symbol)
+	}
+
+	return ret, nil
+}
+
+// documentSymbol creates a symbol spanning pos to end, that is selected by its name
+func documentSymbol(doc *cache.DocumentHandle, name string, pos, end, namePos, nameEnd token.Pos) (protocol.DocumentSymbol, error) {
+	rng, err := tokenRange(doc, pos, end)
+	if err != nil {
+		return protocol.DocumentSymbol{}, err
+	}
+
+	selection := protocol.Range{Start: rng.Start, End: rng.Start}
+
+	if namePos != 0 {
+		if selection, err = tokenRange(doc, namePos, nameEnd); err != nil {
+			return protocol.DocumentSymbol{}, err
+		}
+	}
+
+	// Clients reject symbols without a name
+	if name == "" {
+		name = "<unnamed>"
+	}
+
+	return protocol.DocumentSymbol{
+		Name:           name,
+		Range:          rng,
+		SelectionRange: selection,
+	}, nil
+}
+
+// symbolDetail shortens an expression to a single line
+func symbolDetail(expr string) string {
+	ret := strings.Join(strings.Fields(expr), " ")
+
+	if len([]rune(ret)) > maxSymbolDetail {
+		ret = string([]rune(ret)[:maxSymbolDetail-1]) + "…"
+	}
+
+	return ret
+}
+
+// Symbol finds the recording and alerting rules of all open documents and rule files in the workspace
+// whose names match a query, best matches first
+// required by the protocol.Server interface
+func (s *server) Symbol(_ context.Context, params *protocol.WorkspaceSymbolParams) ([]protocol.SymbolInformation, error) {
+	type match struct {
+		symbol protocol.SymbolInformation
+		score  int
+	}
+
+	var matches []match
+
+	for _, doc := range s.cache.GetDocuments() {
+		groups, err := doc.GetRuleGroups()
+		if err != nil {
+			continue
+		}
+
+		for _, group := range groups {
+			for _, rule := range group.Rules {
+				score := fuzzyScore(params.Query, rule.Name())
+				if score < 0 || rule.NamePos == 0 {
+					continue
+				}
+
+				rng, err := tokenRange(doc, rule.NamePos, rule.NameEnd)
+				if err != nil {
+					continue
+				}
+
+				kind := protocol.Variable
+				if rule.Alert != "" {
+					kind = protocol.Event
+				}
+
+				matches = append(matches, match{
+					symbol: protocol.SymbolInformation{
+						Name:          rule.Name(),
+						Kind:          kind,
+						Location:      protocol.Location{URI: doc.GetURI(), Range: rng},
+						ContainerName: group.Name,
+					},
+					score: score,
+				})
+			}
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score > matches[j].score
+		}
+
+		if matches[i].symbol.Name != matches[j].symbol.Name {
+			return matches[i].symbol.Name < matches[j].symbol.Name
+		}
+
+		return matches[i].symbol.Location.URI < matches[j].symbol.Location.URI
+	})
+
+	ret := []protocol.SymbolInformation{}
+
+	for i, m := range matches {
+		if i == maxWorkspaceSymbols {
+			break
+		}
+
+		ret = append(ret, m.symbol)
+	}
+
+	return ret, nil
+}
+
+// fuzzyScore checks whether the characters of pattern appear in name in the same order, ignoring case.
+// Consecutive characters and characters at the start of a word, e.g. after a colon, score higher.
+// It returns -1 if name doesn't match.
+func fuzzyScore(pattern string, name string) int {
+	p := []rune(strings.ToLower(pattern))
+	n := []rune(name)
+
+	score := 0
+	matched := 0
+	last := -2
+
+	for i := 0; i < len(n) && matched < len(p); i++ {
+		if unicode.ToLower(n[i]) != p[matched] {
+			continue
+		}
+
+		score++
+
+		if i == last+1 {
+			score += 2
+		}
+
+		if i == 0 || strings.ContainsRune(":_.-", n[i-1]) || unicode.IsUpper(n[i]) && unicode.IsLower(n[i-1]) {
+			score += 3
+		}
+
+		last = i
+		matched++
+	}
+
+	if matched < len(p) {
+		return -1
+	}
+
+	return score
+}
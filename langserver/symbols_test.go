@@ -0,0 +1,123 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// TestDocumentSymbol checks that rule groups contain their rules in the outline
+func TestDocumentSymbol(*testing.T) {
+	h, err := NewHeadlessServer(context.Background(), &Config{}, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	rules := `groups:
+  - name: example
+    interval: 1m
+    rules:
+      - record: job:errors:rate5m
+        expr: |
+          sum by (job) (
+            rate(errors_total[5m])
+          )
+      - alert: ErrorsHigh
+        expr: job:errors:rate5m > 1
+`
+
+	if err := h.AddDocument("rules.yml", "yaml", rules); err != nil {
+		panic(err)
+	}
+
+	symbols, err := h.server.DocumentSymbol(context.Background(), &protocol.DocumentSymbolParams{
+		TextDocument: protocol.TextDocumentIdentifier{URI: "rules.yml"},
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	if len(symbols) != 1 || symbols[0].Name != "example" || symbols[0].Kind != protocol.Namespace || symbols[0].Detail != "every 1m" {
+		panic(fmt.Sprintf("expected the group example, got %v", symbols))
+	}
+
+	children := symbols[0].Children
+
+	if len(children) != 2 {
+		panic(fmt.Sprintf("expected the group to contain two rules, got %v", children))
+	}
+
+	if children[0].Name != "job:errors:rate5m" || children[0].Kind != protocol.Variable ||
+		children[0].Detail != "sum by (job) ( rate(errors_total[5m]) )" {
+		panic(fmt.Sprintf("unexpected recording rule symbol %v", children[0]))
+	}
+
+	if children[1].Name != "ErrorsHigh" || children[1].Kind != protocol.Event ||
+		children[1].Range.Start.Line != 9 || children[1].SelectionRange.Start.Line != 9 {
+		panic(fmt.Sprintf("unexpected alert symbol %v", children[1]))
+	}
+}
+
+// TestWorkspaceSymbol checks that rules of all documents are found by fuzzy matching their names
+func TestWorkspaceSymbol(*testing.T) {
+	h, err := NewHeadlessServer(context.Background(), &Config{}, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	documents := map[string]string{
+		"recording.yml": `groups:
+  - name: recording
+    rules:
+      - record: job:http_errors:rate5m
+        expr: sum by (job) (rate(http_errors_total[5m]))
+      - record: job:http_requests:rate5m
+        expr: sum by (job) (rate(http_requests_total[5m]))
+`,
+		"alerts.yml": `groups:
+  - name: alerts
+    rules:
+      - alert: HighErrorRate
+        expr: job:http_errors:rate5m > 1
+`,
+	}
+
+	for uri, content := range documents {
+		if err := h.AddDocument(uri, "yaml", content); err != nil {
+			panic(err)
+		}
+	}
+
+	symbols, err := h.server.Symbol(context.Background(), &protocol.WorkspaceSymbolParams{Query: "jher"})
+	if err != nil {
+		panic(err)
+	}
+
+	var got []string
+
+	for _, symbol := range symbols {
+		got = append(got, fmt.Sprintf("%s/%s:%v", symbol.ContainerName, symbol.Name, symbol.Location.Range.Start.Line))
+	}
+
+	// Matching the start of "errors" ranks higher than matching the middle of "requests"
+	if fmt.Sprint(got) != "[recording/job:http_errors:rate5m:3 recording/job:http_requests:rate5m:5]" {
+		panic(fmt.Sprintf("unexpected symbols %v", got))
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"net"
+
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/jsonrpc2"
+)
+
+// ServeTCP serves a language server on every connection accepted by l, until ctx is done.
+// Every connection is a separate session with its own documents. Sessions only share the cached
+// label values and the concurrency limits of the Prometheus servers.
+func ServeTCP(ctx context.Context, l net.Listener, config *Config) error {
+	go func() {
+		<-ctx.Done()
+		l.Close() // nolint: errcheck
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			return err
+		}
+
+		go serveConn(ctx, conn, config)
+	}
+}
+
+// serveConn runs a language server session on conn. The session ends when the client sends
+// the exit notification or closes the connection.
+func serveConn(ctx context.Context, conn net.Conn, config *Config) {
+	defer conn.Close()
+
+	// Cancelling the context stops the background work of the session, e.g. the configuration
+	// file watcher, if the client disconnects without shutting the server down
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	_, s := ServerFromStream(ctx, jsonrpc2.NewHeaderStream(conn, conn), config)
+
+	// Reading from the connection doesn't stop on exit, closing it ends Run
+	go func() {
+		<-s.server.lifetime.Done()
+		conn.Close() // nolint: errcheck
+	}()
+
+	s.Run() // nolint: errcheck
+}
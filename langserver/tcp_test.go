@@ -0,0 +1,101 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/jsonrpc2"
+)
+
+// TestServeTCP checks that a TCP server serves several sessions concurrently and that
+// one session exiting doesn't end the others
+func TestServeTCP(*testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go ServeTCP(ctx, l, &Config{}) // nolint: errcheck
+
+	dial := func() (net.Conn, jsonrpc2.Stream) {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			panic(err)
+		}
+
+		return conn, jsonrpc2.NewHeaderStream(conn, conn)
+	}
+
+	request := func(stream jsonrpc2.Stream, id int, method string, params string) string {
+		msg := fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"method":%q%s}`, id, method, params)
+		if _, err := stream.Write(ctx, []byte(msg)); err != nil {
+			panic(err)
+		}
+
+		for {
+			response, _, err := stream.Read(ctx)
+			if err != nil {
+				panic(err)
+			}
+
+			// Skip notifications and requests sent by the server
+			if strings.Contains(string(response), fmt.Sprintf(`"id":%d`, id)) && !strings.Contains(string(response), `"method"`) {
+				return string(response)
+			}
+		}
+	}
+
+	first, firstStream := dial()
+	defer first.Close()
+
+	second, secondStream := dial()
+	defer second.Close()
+
+	for _, stream := range []jsonrpc2.Stream{firstStream, secondStream} {
+		if response := request(stream, 1, "initialize", `,"params":{"capabilities":{}}`); !strings.Contains(response, "capabilities") {
+			panic(fmt.Sprintf("expected the result of initialize, got %s", response))
+		}
+
+		if _, err := stream.Write(ctx, []byte(`{"jsonrpc":"2.0","method":"initialized","params":{}}`)); err != nil {
+			panic(err)
+		}
+	}
+
+	if response := request(firstStream, 2, "shutdown", ""); strings.Contains(response, "error") {
+		panic(fmt.Sprintf("expected the first session to shut down, got %s", response))
+	}
+
+	if _, err := firstStream.Write(ctx, []byte(`{"jsonrpc":"2.0","method":"exit"}`)); err != nil {
+		panic(err)
+	}
+
+	// The server closes the connection of the session that exited
+	for {
+		if _, _, err := firstStream.Read(ctx); err != nil {
+			break
+		}
+	}
+
+	if response := request(secondStream, 2, "shutdown", ""); strings.Contains(response, "error") {
+		panic(fmt.Sprintf("expected the second session to be unaffected, got %s", response))
+	}
+}
@@ -0,0 +1,258 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/prometheus-community/promql-langserver/langserver/cache"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/jsonrpc2"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// defaultTelemetryInterval is how often usage statistics are sent if no interval is configured
+const defaultTelemetryInterval = time.Hour
+
+// latencyBuckets are the upper bounds of the buckets of the latency histograms
+var latencyBuckets = []time.Duration{ // nolint: gochecknoglobals
+	time.Millisecond, 5 * time.Millisecond, 10 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 500 * time.Millisecond, time.Second, 5 * time.Second,
+}
+
+// TelemetryConfig configures the collection of anonymous usage statistics. It is disabled by default.
+// The statistics contain the number of calls and the latency of each LSP method and the kinds of
+// parse errors encountered, but no document content, file names or metric names.
+type TelemetryConfig struct {
+	// Endpoint is the http(s) URL the statistics are posted to as JSON
+	Endpoint string `yaml:"endpoint"`
+	// Interval is the time between two reports, e.g. 1h
+	Interval string `yaml:"interval"`
+}
+
+// usageStats are the statistics collected since the last report
+type usageStats struct {
+	Start       time.Time               `json:"start"`
+	End         time.Time               `json:"end"`
+	Methods     map[string]*methodStats `json:"methods"`
+	ParseErrors map[string]int          `json:"parse_errors"`
+
+	mu sync.Mutex
+}
+
+// methodStats contains the number of calls of an LSP method and how long they took
+type methodStats struct {
+	Calls  int `json:"calls"`
+	Errors int `json:"errors"`
+	// Latency is a cumulative histogram of the handling times, indexed by the upper bound of the buckets
+	Latency map[string]int `json:"latency"`
+}
+
+func newUsageStats() *usageStats {
+	return &usageStats{
+		Start:       time.Now(),
+		Methods:     make(map[string]*methodStats),
+		ParseErrors: make(map[string]int),
+	}
+}
+
+// recordCall adds a handled call of an LSP method to the statistics
+func (u *usageStats) recordCall(method string, elapsed time.Duration, failed bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	stats, ok := u.Methods[method]
+	if !ok {
+		stats = &methodStats{Latency: make(map[string]int)}
+		u.Methods[method] = stats
+	}
+
+	stats.Calls++
+
+	if failed {
+		stats.Errors++
+	}
+
+	for _, bucket := range latencyBuckets {
+		if elapsed <= bucket {
+			stats.Latency[bucket.String()]++
+		}
+	}
+
+	stats.Latency["+Inf"]++
+}
+
+// recordParseErrors adds the parse errors of the queries of a document to the statistics
+func (u *usageStats) recordParseErrors(doc *cache.DocumentHandle) {
+	queries, err := doc.GetQueries()
+	if err != nil {
+		return
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	for _, q := range queries {
+		for _, e := range q.Err {
+			if e.Err != nil {
+				u.ParseErrors[parseErrorCategory(e.Err.Error())]++
+			}
+		}
+	}
+}
+
+// parseErrorCategory removes the parts of a parse error message that come from
+// the query, e.g. `unexpected identifier "foo"` becomes `unexpected identifier`
+func parseErrorCategory(msg string) string {
+	if i := strings.IndexAny(msg, "\":`'"); i >= 0 {
+		msg = msg[:i]
+	}
+
+	return strings.TrimSpace(msg)
+}
+
+// reset returns the collected statistics and starts a new collection period
+func (u *usageStats) reset() *usageStats {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	ret := &usageStats{
+		Start:       u.Start,
+		End:         time.Now(),
+		Methods:     u.Methods,
+		ParseErrors: u.ParseErrors,
+	}
+
+	u.Start = ret.End
+	u.Methods = make(map[string]*methodStats)
+	u.ParseErrors = make(map[string]int)
+
+	return ret
+}
+
+// telemetryHandler collects usage statistics about the requests handled by a jsonrpc2.Conn
+type telemetryHandler struct {
+	jsonrpc2.EmptyHandler
+	stats *usageStats
+}
+
+type telemetryKey int
+
+const (
+	telemetryMethodKey = telemetryKey(iota)
+	telemetryStartKey
+)
+
+// Request is required by the jsonrpc2.Handler interface
+func (h *telemetryHandler) Request(ctx context.Context, _ *jsonrpc2.Conn, direction jsonrpc2.Direction, r *jsonrpc2.WireRequest) context.Context {
+	if direction != jsonrpc2.Receive {
+		return ctx
+	}
+
+	ctx = context.WithValue(ctx, telemetryMethodKey, r.Method)
+
+	return context.WithValue(ctx, telemetryStartKey, time.Now())
+}
+
+// Done is required by the jsonrpc2.Handler interface
+func (h *telemetryHandler) Done(ctx context.Context, err error) {
+	method, ok := ctx.Value(telemetryMethodKey).(string)
+	if !ok {
+		return
+	}
+
+	start, ok := ctx.Value(telemetryStartKey).(time.Time)
+	if !ok {
+		return
+	}
+
+	h.stats.recordCall(method, time.Since(start), err != nil)
+}
+
+// startTelemetry sends the collected usage statistics to the configured endpoint periodically
+func (s *server) startTelemetry() error {
+	if s.telemetry == nil {
+		return nil
+	}
+
+	interval := defaultTelemetryInterval
+
+	if s.getConfig().Telemetry.Interval != "" {
+		var err error
+
+		if interval, err = cache.ParseDuration(s.getConfig().Telemetry.Interval); err != nil || interval <= 0 {
+			return errors.Errorf("invalid telemetry interval %q", s.getConfig().Telemetry.Interval)
+		}
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.lifetime.Done():
+				return
+			case <-ticker.C:
+				if err := s.sendTelemetry(s.telemetry.reset()); err != nil {
+					// nolint: errcheck
+					s.client.LogMessage(s.lifetime, &protocol.LogMessageParams{
+						Type:    protocol.Error,
+						Message: err.Error(),
+					})
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+// sendTelemetry posts usage statistics to the configured endpoint
+func (s *server) sendTelemetry(stats *usageStats) error {
+	body, err := json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(s.lifetime, 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequest(http.MethodPost, s.getConfig().Telemetry.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "failed to send usage statistics")
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Wrap(err, "failed to send usage statistics")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("failed to send usage statistics: %s", resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,47 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// TestUsageStats checks the collection of usage statistics
+func TestUsageStats(*testing.T) {
+	u := newUsageStats()
+
+	u.recordCall("textDocument/hover", 3*time.Millisecond, false)
+	u.recordCall("textDocument/hover", 2*time.Second, true)
+
+	stats := u.reset()
+
+	hover := stats.Methods["textDocument/hover"]
+	if hover == nil || hover.Calls != 2 || hover.Errors != 1 {
+		panic(fmt.Sprintf("unexpected statistics: %v", hover))
+	}
+
+	if hover.Latency["1ms"] != 0 || hover.Latency["5ms"] != 1 || hover.Latency["5s"] != 2 || hover.Latency["+Inf"] != 2 {
+		panic(fmt.Sprintf("unexpected latency histogram: %v", hover.Latency))
+	}
+
+	if len(u.Methods) != 0 {
+		panic("expected the statistics to be reset")
+	}
+
+	if category := parseErrorCategory(`unexpected identifier "secret_metric" in aggregation`); category != "unexpected identifier" {
+		panic(fmt.Sprintf("parse error category contains query content: %q", category))
+	}
+}
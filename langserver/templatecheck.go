@@ -0,0 +1,359 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"go/token"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/template"
+	"gopkg.in/yaml.v3"
+
+	"github.com/prometheus-community/promql-langserver/langserver/cache"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// templateFunctions are the functions Prometheus provides to the templates of alerting rules
+var templateFunctions = []struct{ name, doc string }{ // nolint: gochecknoglobals
+	{"query", "`query \"expr\"` evaluates a PromQL expression at the time of the alert and returns its result as a list of samples"},
+	{"first", "`first samples` returns the first sample of a query result"},
+	{"label", "`label \"name\" sample` returns the value of a label of a sample"},
+	{"value", "`value sample` returns the value of a sample"},
+	{"strvalue", "`strvalue sample` returns the value of the `__value__` label of a sample"},
+	{"sortByLabel", "`sortByLabel \"name\" samples` sorts samples by the value of a label"},
+	{"args", "`args values...` converts a list of values to a map with the keys `arg0`, `arg1`, ... to pass them to another template"},
+	{"reReplaceAll", "`reReplaceAll \"regex\" \"replacement\" text` replaces all matches of a regular expression, `$1` refers to groups"},
+	{"match", "`match \"regex\" text` checks whether a text contains a match of a regular expression"},
+	{"safeHtml", "`safeHtml text` marks a text as HTML that doesn't need to be escaped"},
+	{"title", "`title text` capitalizes the first letter of each word"},
+	{"toUpper", "`toUpper text` converts a text to upper case"},
+	{"toLower", "`toLower text` converts a text to lower case"},
+	{"graphLink", "`graphLink \"expr\"` returns the path of the graph page of the Prometheus UI for an expression"},
+	{"tableLink", "`tableLink \"expr\"` returns the path of the table view of the Prometheus UI for an expression"},
+	{"humanize", "`humanize number` formats a number with metric prefixes, e.g. `1.234k`"},
+	{"humanize1024", "`humanize1024 number` formats a number with binary prefixes, e.g. `1.5Ki`"},
+	{"humanizeDuration", "`humanizeDuration seconds` formats a number of seconds as duration, e.g. `1h 2m 3s`"},
+	{"humanizePercentage", "`humanizePercentage ratio` formats a ratio as percentage, e.g. `12.5%` for `0.125`"},
+	{"humanizeTimestamp", "`humanizeTimestamp seconds` formats a unix timestamp in seconds as date and time"},
+	{"pathPrefix", "`pathPrefix` returns the path prefix of the external URL of the Prometheus server"},
+	{"externalURL", "`externalURL` returns the external URL of the Prometheus server"},
+}
+
+// templateVariableDeclRegexp matches the declarations of template variables, e.g. `{{ $host := reReplaceAll ... }}`
+var templateVariableDeclRegexp = regexp.MustCompile(`\{\{-?\s*(\$[a-zA-Z_][a-zA-Z0-9_]*)\s*:=`) // nolint: gochecknoglobals
+
+// templateVariableRegexp matches the uses and declarations of template variables
+var templateVariableRegexp = regexp.MustCompile(`\$[a-zA-Z_][a-zA-Z0-9_]*`) // nolint: gochecknoglobals
+
+// parseAlertTemplate checks that a label or annotation of an alerting rule is a valid template,
+// with the variables the Prometheus rule manager defines
+func parseAlertTemplate(alert string, text string) error {
+	defs := []string{
+		"{{$labels := .Labels}}",
+		"{{$externalLabels := .ExternalLabels}}",
+		"{{$value := .Value}}",
+	}
+
+	expander := template.NewTemplateExpander(
+		context.Background(),
+		strings.Join(append(defs, text), ""),
+		"__alert_"+alert,
+		template.AlertTemplateData(map[string]string{}, map[string]string{}, 0),
+		model.Time(timestamp.FromTime(time.Now())),
+		nil,
+		nil,
+	)
+
+	return expander.ParseTest()
+}
+
+// alertTemplateDiagnostics reports labels and annotations of alerting rules that Prometheus can't parse as template,
+// unused template variables and references to labels that the series of the alert expression can't have,
+// which always expand to ""
+// nolint: funlen
+func (s *server) alertTemplateDiagnostics(doc *cache.DocumentHandle) []protocol.Diagnostic {
+	groups, err := doc.GetRuleGroups()
+	if err != nil {
+		return nil
+	}
+
+	var ret []protocol.Diagnostic
+
+	for _, group := range groups {
+		for _, rule := range group.Rules {
+			if rule.Alert == "" {
+				continue
+			}
+
+			var exprLabels *labelSet
+
+			if rule.Query != nil && len(rule.Query.Err) == 0 {
+				if expr, ok := rule.Query.Ast.(promql.Expr); ok {
+					a := &labelAnalyzer{s: s, ctx: doc.GetContext(), query: rule.Query, series: make(map[string]labelSet)}
+					l := a.exprLabels(expr)
+					exprLabels = &l
+				}
+			}
+
+			for _, key := range []string{"labels", "annotations"} {
+				mapping := cache.MappingValue(rule.Node, key)
+				if mapping == nil || mapping.Kind != yaml.MappingNode {
+					continue
+				}
+
+				for i := 0; i+1 < len(mapping.Content); i += 2 {
+					name, value := mapping.Content[i], mapping.Content[i+1]
+					if value.Kind != yaml.ScalarNode {
+						continue
+					}
+
+					if parseErr := parseAlertTemplate(rule.Alert, value.Value); parseErr != nil {
+						pos, end, err := doc.YamlNodeRange(value, group.LineOffset)
+						if err != nil {
+							continue
+						}
+
+						if rng, err := tokenRange(doc, pos, end); err == nil {
+							ret = append(ret, protocol.Diagnostic{
+								Range:    rng,
+								Severity: 1, // Error
+								Code:     codeAlertTemplate,
+								Source:   "promql-lsp",
+								Message:  fmt.Sprintf("%s %q: %s", strings.TrimSuffix(key, "s"), name.Value, parseErr),
+							})
+						}
+
+						continue
+					}
+
+					ret = append(ret, unusedTemplateVariableDiagnostics(doc, value, group.LineOffset)...)
+
+					if exprLabels == nil {
+						continue
+					}
+
+					refs, err := templateLabelReferences(doc, value, group.LineOffset)
+					if err != nil {
+						continue
+					}
+
+					for _, ref := range refs {
+						message := missingLabelMessage(*exprLabels, ref.Name)
+						if message == "" {
+							continue
+						}
+
+						if rng, err := tokenRange(doc, ref.Pos, ref.End); err == nil {
+							ret = append(ret, protocol.Diagnostic{
+								Range:    rng,
+								Severity: 2, // Warning
+								Code:     codeTemplateLabel,
+								Source:   "promql-lsp",
+								Message:  message,
+							})
+						}
+					}
+				}
+			}
+		}
+	}
+
+	return ret
+}
+
+// unusedTemplateVariableDiagnostics reports variables that are declared in a label or annotation of an alerting
+// rule but never used. They are tagged as unnecessary, so editors render them faded out.
+func unusedTemplateVariableDiagnostics(doc *cache.DocumentHandle, node *yaml.Node, lineOffset int) []protocol.Diagnostic {
+	pos, end, err := doc.YamlNodeRange(node, lineOffset)
+	if err != nil {
+		return nil
+	}
+
+	// The raw source is searched, so the positions of the matches are known
+	content, err := doc.GetSubstring(pos, end)
+	if err != nil {
+		return nil
+	}
+
+	uses := make(map[string]int)
+
+	for _, name := range templateVariableRegexp.FindAllString(content, -1) {
+		uses[name]++
+	}
+
+	var ret []protocol.Diagnostic
+
+	for _, match := range templateVariableDeclRegexp.FindAllStringSubmatchIndex(content, -1) {
+		name := content[match[2]:match[3]]
+
+		// The declaration is the only match
+		if uses[name] > 1 {
+			continue
+		}
+
+		rng, err := tokenRange(doc, pos+token.Pos(match[2]), pos+token.Pos(match[3]))
+		if err != nil {
+			continue
+		}
+
+		ret = append(ret, protocol.Diagnostic{
+			Range:    rng,
+			Severity: 4, // Hint
+			Code:     codeUnusedTemplateVar,
+			Source:   "promql-lsp",
+			Message:  fmt.Sprintf("template variable %s is declared but never used", name),
+		})
+	}
+
+	return ret
+}
+
+// missingLabelMessage explains why the series of an alert expression can't have a label,
+// or returns "" if they may have it
+func missingLabelMessage(l labelSet, name string) string {
+	if reason := l.removedBy(name); reason != "" {
+		return fmt.Sprintf("%s removes the label %s from the series of the alert expression, so it is always empty here", reason, name)
+	}
+
+	if l.Exact && !l.Names[name] {
+		return fmt.Sprintf("the series of the alert expression have no label %s, so it is always empty here", name)
+	}
+
+	return ""
+}
+
+// alertTemplate is the label or annotation of an alerting rule a position is inside of
+type alertTemplate struct {
+	rule *cache.Rule
+	// content is the raw source of the value, it starts at pos
+	content string
+	pos     token.Pos
+	// offset is the position inside of content
+	offset int
+}
+
+// alertTemplateAt returns the label or annotation of an alerting rule at a position, or nil if there is none
+func alertTemplateAt(doc *cache.DocumentHandle, position protocol.Position) *alertTemplate {
+	pos, err := doc.ProtocolPositionToTokenPos(position)
+	if err != nil {
+		return nil
+	}
+
+	groups, err := doc.GetRuleGroups()
+	if err != nil {
+		return nil
+	}
+
+	for _, group := range groups {
+		for _, rule := range group.Rules {
+			if rule.Alert == "" || pos < rule.Pos || pos > rule.End {
+				continue
+			}
+
+			for _, key := range []string{"labels", "annotations"} {
+				mapping := cache.MappingValue(rule.Node, key)
+				if mapping == nil || mapping.Kind != yaml.MappingNode {
+					continue
+				}
+
+				for i := 1; i < len(mapping.Content); i += 2 {
+					start, end, err := doc.YamlNodeRange(mapping.Content[i], group.LineOffset)
+					if err != nil || pos < start || pos > end {
+						continue
+					}
+
+					// The raw source is used, so that offsets can be translated into positions
+					content, err := doc.GetSubstring(start, end)
+					if err != nil {
+						return nil
+					}
+
+					return &alertTemplate{rule: rule, content: content, pos: start, offset: int(pos - start)}
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// templateFunctionHover documents the template function at a position in the labels or annotations of an alerting rule
+func templateFunctionHover(doc *cache.DocumentHandle, position protocol.Position) *protocol.Hover {
+	t := alertTemplateAt(doc, position)
+	if t == nil {
+		return nil
+	}
+
+	return templateFunctionHoverAt(doc, t.content, t.pos, t.offset)
+}
+
+// templateFunctionHoverAt documents the template function at an offset of a template starting at pos
+func templateFunctionHoverAt(doc *cache.DocumentHandle, content string, pos token.Pos, offset int) *protocol.Hover {
+	if !insideTemplateAction(content, offset) {
+		return nil
+	}
+
+	start, end := offset, offset
+
+	for start > 0 && isTemplateIdentifier(content[start-1]) {
+		start--
+	}
+
+	for end < len(content) && isTemplateIdentifier(content[end]) {
+		end++
+	}
+
+	// Fields like .Labels and variables like $value aren't functions
+	if start == end || start > 0 && (content[start-1] == '.' || content[start-1] == '$') {
+		return nil
+	}
+
+	for _, fn := range templateFunctions {
+		if fn.name != content[start:end] {
+			continue
+		}
+
+		rng, err := tokenRange(doc, pos+token.Pos(start), pos+token.Pos(end))
+		if err != nil {
+			return nil
+		}
+
+		return &protocol.Hover{
+			Contents: protocol.MarkupContent{
+				Kind:  "markdown",
+				Value: fmt.Sprintf("`%s`\n\n%s", fn.name, fn.doc),
+			},
+			Range: rng,
+		}
+	}
+
+	return nil
+}
+
+// insideTemplateAction checks whether an offset of a template is between {{ and }}
+func insideTemplateAction(content string, offset int) bool {
+	return offset <= len(content) && strings.LastIndex(content[:offset], "{{") > strings.LastIndex(content[:offset], "}}")
+}
+
+// isTemplateIdentifier checks whether a character can be part of the name of a template function, field or variable
+func isTemplateIdentifier(c byte) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
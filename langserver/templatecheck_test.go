@@ -0,0 +1,70 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// TestAlertTemplateDiagnostics checks that broken templates and labels removed by the alert expression are reported
+func TestAlertTemplateDiagnostics(*testing.T) {
+	h, err := NewHeadlessServer(context.Background(), &Config{}, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	const rules = `groups:
+- name: example
+  rules:
+  - alert: HighErrorRate
+    expr: sum by (job) (rate(errors_total[5m])) > 0.1
+    annotations:
+      summary: "{{ $labels.job }} on {{ $labels.instance }} fails {{ $value | humanizePercentage }}"
+  - alert: Broken
+    expr: up == 0
+    labels:
+      severity: "{{ if $labels.critical }}page"
+`
+
+	result, err := h.AnalyzeDocument("rules.yml", "yaml", rules)
+	if err != nil {
+		panic(err)
+	}
+
+	var problems []string
+
+	for _, d := range result.Diagnostics {
+		if d.Code == codeAlertTemplate || d.Code == codeTemplateLabel {
+			problems = append(problems, fmt.Sprintf("%v:%v:%s", d.Range.Start.Line, d.Range.Start.Character, d.Message))
+		}
+	}
+
+	if len(problems) != 2 || !strings.HasPrefix(problems[0], "6:48:sum by (job) removes the label instance") ||
+		!strings.HasPrefix(problems[1], `10:16:label "severity": template: __alert_Broken`) {
+		panic(fmt.Sprintf("expected the removed instance label and the unclosed if to be reported, got %v", problems))
+	}
+
+	hover, err := h.Hover("rules.yml", strings.Index(rules, "humanizePercentage")+3)
+	if err != nil || hover == nil || !strings.Contains(hover.Contents.Value, "formats a ratio as percentage") {
+		panic(fmt.Sprintf("expected the documentation of humanizePercentage, got %v, %v", hover, err))
+	}
+
+	if hover, err := h.Hover("rules.yml", strings.Index(rules, "$labels.job")+3); err != nil || hover != nil {
+		panic(fmt.Sprintf("expected no documentation for variables, got %v, %v", hover, err))
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"go/token"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/prometheus/promql"
+
+	"github.com/prometheus-community/promql-langserver/langserver/cache"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// alertTemplateVariables are the variables the Prometheus rule manager defines for the templates of alerting rules
+var alertTemplateVariables = []struct{ name, doc string }{ // nolint: gochecknoglobals
+	{"$labels", "the labels of the series the alert fires for, e.g. `$labels.instance`"},
+	{"$externalLabels", "the external labels of the Prometheus server"},
+	{"$value", "the value of the series the alert fires for"},
+}
+
+// alertTemplateCompletion completes the labels of the alert expression after $labels., the variables
+// and the template functions inside of {{ ... }} in the labels and annotations of an alerting rule
+func (s *server) alertTemplateCompletion(doc *cache.DocumentHandle, position protocol.Position) []protocol.CompletionItem {
+	t := alertTemplateAt(doc, position)
+	if t == nil || !insideTemplateAction(t.content, t.offset) {
+		return nil
+	}
+
+	prefix := t.content[:t.offset]
+
+	start := len(prefix)
+	for start > 0 && isTemplateIdentifier(prefix[start-1]) {
+		start--
+	}
+
+	switch {
+	case strings.HasSuffix(prefix[:start], "$labels."):
+		return s.templateLabelCompletions(doc, t, start)
+	case strings.HasSuffix(prefix[:start], "$"):
+		// The range includes the $, so that clients match it against the variable names
+		start--
+	case strings.HasSuffix(prefix[:start], "."):
+		// Fields aren't completed
+		return nil
+	}
+
+	rng, err := tokenRange(doc, t.pos+token.Pos(start), t.pos+token.Pos(t.offset))
+	if err != nil {
+		return nil
+	}
+
+	var ret []protocol.CompletionItem
+
+	if strings.HasPrefix(prefix[start:], "$") {
+		for _, v := range alertTemplateVariables {
+			ret = append(ret, protocol.CompletionItem{
+				Label:         v.name,
+				Kind:          protocol.VariableCompletion,
+				Documentation: v.doc,
+				TextEdit:      &protocol.TextEdit{Range: rng, NewText: v.name},
+			})
+		}
+
+		return ret
+	}
+
+	for _, fn := range templateFunctions {
+		ret = append(ret, protocol.CompletionItem{
+			Label:         fn.name,
+			Kind:          protocol.FunctionCompletion,
+			Documentation: fn.doc,
+			TextEdit:      &protocol.TextEdit{Range: rng, NewText: fn.name},
+		})
+	}
+
+	return ret
+}
+
+// templateLabelCompletions completes the labels the series of the alert expression may have.
+// The completions replace the template from start to the cursor.
+func (s *server) templateLabelCompletions(doc *cache.DocumentHandle, t *alertTemplate, start int) []protocol.CompletionItem {
+	query := t.rule.Query
+	if query == nil || len(query.Err) != 0 {
+		return nil
+	}
+
+	expr, ok := query.Ast.(promql.Expr)
+	if !ok {
+		return nil
+	}
+
+	rng, err := tokenRange(doc, t.pos+token.Pos(start), t.pos+token.Pos(t.offset))
+	if err != nil {
+		return nil
+	}
+
+	a := &labelAnalyzer{s: s, ctx: doc.GetContext(), query: query, series: make(map[string]labelSet)}
+	l := a.exprLabels(expr)
+
+	names := make([]string, 0, len(l.Names))
+
+	for name := range l.Names {
+		if name != "__name__" {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	ret := []protocol.CompletionItem{}
+
+	for _, name := range names {
+		ret = append(ret, protocol.CompletionItem{
+			Label:    name,
+			Kind:     protocol.FieldCompletion,
+			Detail:   "label of the alert expression",
+			TextEdit: &protocol.TextEdit{Range: rng, NewText: name},
+		})
+	}
+
+	return ret
+}
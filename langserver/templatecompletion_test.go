@@ -0,0 +1,70 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// TestAlertTemplateCompletion checks that labels, variables and functions are completed in the annotations of alerting rules
+func TestAlertTemplateCompletion(*testing.T) {
+	h, err := NewHeadlessServer(context.Background(), &Config{}, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	const rules = `groups:
+- name: example
+  rules:
+  - alert: HighErrorRate
+    expr: sum by (job, instance) (rate(errors_total[5m])) > 0.1
+    annotations:
+      summary: "{{ $labels.j }} fails {{ $va | hum }}"
+`
+
+	if err := h.AddDocument("rules.yml", "yaml", rules); err != nil {
+		panic(err)
+	}
+
+	for _, c := range []struct {
+		after    string
+		expected string
+	}{
+		{"$labels.j", "[instance job]"},
+		{"$va", "[$labels $externalLabels $value]"},
+		{"| hum", "humanize"},
+	} {
+		completions, err := h.Completion("rules.yml", strings.Index(rules, c.after)+len(c.after))
+		if err != nil || completions == nil {
+			panic(fmt.Sprintf("expected completions after %s, got %v", c.after, err))
+		}
+
+		var labels []string
+		for _, item := range completions.Items {
+			labels = append(labels, item.Label)
+		}
+
+		if !strings.Contains(fmt.Sprint(labels), c.expected) {
+			panic(fmt.Sprintf("expected %s to be completed after %s, got %v", c.expected, c.after, labels))
+		}
+	}
+
+	if completions, err := h.Completion("rules.yml", strings.Index(rules, "fails")); err != nil || len(completions.Items) != 0 {
+		panic(fmt.Sprintf("expected no completions outside of template actions, got %v, %v", completions, err))
+	}
+}
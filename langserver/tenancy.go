@@ -0,0 +1,120 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+// tenantHeader is the header multi-tenant Cortex, Mimir and Thanos setups read the tenant from
+const tenantHeader = "X-Scope-OrgID"
+
+// requestHeaders returns the headers that are added to every request to the Prometheus servers
+func (c *Config) requestHeaders() http.Header {
+	ret := make(http.Header)
+
+	for name, value := range c.Headers {
+		ret.Set(name, value)
+	}
+
+	if c.TenantID != "" {
+		ret.Set(tenantHeader, c.TenantID)
+	}
+
+	return ret
+}
+
+// partialResponse returns the value of the partial_response parameter of metadata requests to Thanos Query,
+// or "" if the parameter isn't sent
+func (c *ThanosConfig) partialResponse() string {
+	if c == nil || c.PartialResponse == nil {
+		return ""
+	}
+
+	return strconv.FormatBool(*c.PartialResponse)
+}
+
+// isMetadataPath checks whether a request path belongs to one of the APIs serving metric metadata and label values
+func isMetadataPath(path string) bool {
+	for _, endpoint := range []string{"/api/v1/labels", "/api/v1/series", "/api/v1/metadata", "/api/v1/targets/metadata"} {
+		if strings.HasSuffix(path, endpoint) {
+			return true
+		}
+	}
+
+	return strings.Contains(path, "/api/v1/label/")
+}
+
+// headerClient adds custom headers, e.g. the tenant, to the requests to a Prometheus server
+// and sets the partial_response parameter of metadata requests
+type headerClient struct {
+	api.Client
+	headers http.Header
+	// partialResponse is the value of the partial_response parameter, or "" to leave it to the server
+	partialResponse string
+}
+
+// Do is required by the api.Client interface
+func (c headerClient) Do(ctx context.Context, req *http.Request) (*http.Response, []byte, error) {
+	for name, values := range c.headers {
+		req.Header[name] = values
+	}
+
+	if c.partialResponse != "" && isMetadataPath(req.URL.Path) {
+		q := req.URL.Query()
+		q.Set("partial_response", c.partialResponse)
+		req.URL.RawQuery = q.Encode()
+	}
+
+	return c.Client.Do(ctx, req)
+}
+
+// newPrometheusClient creates the client for a Prometheus server, which sends the configured credentials
+// and headers and shares the concurrency limits of the server with all sessions
+func (s *server) newPrometheusClient(url string) (api.Client, error) {
+	config := s.getConfig()
+
+	rt, err := config.roundTripper(url)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := api.NewClient(api.Config{Address: url, RoundTripper: rt})
+	if err != nil {
+		return nil, err
+	}
+
+	client = headerClient{client, config.requestHeaders(), config.Thanos.partialResponse()}
+
+	return limitedClient{client, getDatasourceLimiter(url, config.Concurrency), s}, nil
+}
+
+// reportWarnings notifies the user about partial responses, e.g. from Thanos Query if some store APIs
+// are unavailable. Like errors, they are reported at most once per backendMessageInterval.
+func (s *server) reportWarnings(warnings v1.Warnings) {
+	if len(warnings) == 0 {
+		return
+	}
+
+	s.showBackendMessage(backendPartialResponse,
+		fmt.Sprintf("Prometheus at %s returned partial data (%s), so completions and hovers may be incomplete.",
+			s.getPrometheusURL(), strings.Join(warnings, "; ")))
+}
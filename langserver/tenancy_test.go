@@ -0,0 +1,82 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestTenantHeaders checks that the tenant and custom headers are sent, that metadata requests
+// carry the partial_response parameter and that partial responses aren't cached
+func TestTenantHeaders(*testing.T) {
+	requests := 0
+
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.Header.Get("X-Scope-OrgID") != "team-a" || r.Header.Get("X-Custom") != "value" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, "no org id")
+
+			return
+		}
+
+		if r.URL.Path != "/api/v1/label/job/values" {
+			fmt.Fprint(w, `{"status":"success","data":{}}`)
+			return
+		}
+
+		requests++
+
+		if r.FormValue("partial_response") != "true" {
+			panic("expected partial_response to be set, got " + r.URL.RawQuery)
+		}
+
+		fmt.Fprint(w, `{"status":"success","data":["api"],"warnings":["store 10.0.0.1:10901 unavailable"]}`)
+	}))
+	defer prom.Close()
+
+	partialResponse := true
+
+	h, err := NewHeadlessServer(context.Background(), &Config{
+		PrometheusURL: prom.URL,
+		TenantID:      "team-a",
+		Headers:       map[string]string{"X-Custom": "value"},
+		Thanos:        &ThanosConfig{PartialResponse: &partialResponse},
+	}, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	if h.server.getPrometheus() == nil {
+		panic("expected the server to connect with the tenant header")
+	}
+
+	for i := 0; i < 2; i++ {
+		values := h.server.labelValues(context.Background(), "", nil, "", "job")
+
+		if fmt.Sprint(values) != "[api]" {
+			panic(fmt.Sprintf("expected the partial label values, got %v", values))
+		}
+	}
+
+	if requests != 2 {
+		panic(fmt.Sprintf("expected partial responses not to be cached, got %d requests", requests))
+	}
+}
@@ -28,6 +28,8 @@ import (
 // DidOpen receives a call from the Client, telling that a files has been opened
 // required by the protocol.Server interface
 func (s *server) DidOpen(ctx context.Context, params *protocol.DidOpenTextDocumentParams) error {
+	s.openWorkspaceFile(params.TextDocument.URI)
+
 	_, err := s.cache.AddDocument(s.lifetime, &params.TextDocument)
 	if err != nil {
 		return err
@@ -41,8 +43,21 @@ func (s *server) DidOpen(ctx context.Context, params *protocol.DidOpenTextDocume
 // DidClose receives a call from the Client, telling that a files has been closed
 // required by the protocol.Server interface
 func (s *server) DidClose(_ context.Context, params *protocol.DidCloseTextDocumentParams) error {
-	s.clearDiagnostics(s.lifetime, params.TextDocument.URI, 0)
-	return s.cache.RemoveDocument(params.TextDocument.URI)
+	s.clearDiagnostics(params.TextDocument.URI, 0)
+
+	routing := s.hasAlertmanagerConfig(params.TextDocument.URI)
+
+	if err := s.cache.RemoveDocument(params.TextDocument.URI); err != nil {
+		return err
+	}
+
+	s.closeWorkspaceFile(params.TextDocument.URI)
+
+	if routing {
+		s.diagnoseRoutedRules(params.TextDocument.URI)
+	}
+
+	return nil
 }
 
 // DidChange receives a call from the Client, telling that a files has been changed
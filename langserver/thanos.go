@@ -0,0 +1,178 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus-community/promql-langserver/langserver/cache"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/promql"
+)
+
+// ThanosConfig describes a Thanos Query datasource that serves downsampled data
+type ThanosConfig struct {
+	// Downsampling enables the checks for downsampled data
+	Downsampling bool `yaml:"downsampling"`
+	// MaxSourceResolution is the max_source_resolution parameter queries are run with,
+	// either "auto" or a duration. Defaults to "auto".
+	MaxSourceResolution string `yaml:"max_source_resolution"`
+	// Step is the query resolution step of range queries, e.g. on dashboards.
+	// It determines the resolution Thanos selects if max_source_resolution is "auto".
+	Step string `yaml:"step"`
+	// PartialResponse is the partial_response parameter of metadata and label requests. If it is false,
+	// Thanos Query fails requests when some stores are unavailable instead of returning partial data with
+	// a warning, which is reported to the user. The default of the Thanos Query server is used if it isn't set.
+	PartialResponse *bool `yaml:"partial_response"`
+}
+
+// thanosResolutions are the resolutions the Thanos compactor downsamples to
+var thanosResolutions = []time.Duration{0, 5 * time.Minute, time.Hour}
+
+// thanosAutoStepDivisor is the factor Thanos divides the step by to determine
+// the resolution if max_source_resolution is "auto"
+const thanosAutoStepDivisor = 5
+
+// samplesRequired lists the functions that need at least two samples in
+// their range argument to return a result
+var samplesRequired = map[string]bool{
+	"rate":           true,
+	"irate":          true,
+	"increase":       true,
+	"delta":          true,
+	"idelta":         true,
+	"deriv":          true,
+	"predict_linear": true,
+}
+
+// thanosResolution returns the coarsest resolution a query might be answered with, as well as
+// a description of why it is chosen. It returns 0 if downsampled data is not in use.
+func (c *ThanosConfig) thanosResolution() (time.Duration, string, error) {
+	if c == nil || !c.Downsampling {
+		return 0, "", nil
+	}
+
+	maxResolution := strings.TrimSpace(c.MaxSourceResolution)
+
+	var limit time.Duration
+
+	var reason string
+
+	if maxResolution == "" || maxResolution == "auto" {
+		if c.Step == "" {
+			// Without a known step, assume a dashboard zoomed out far enough
+			// for the first downsampling level.
+			return thanosResolutions[1], "max_source_resolution=auto", nil
+		}
+
+		step, err := model.ParseDuration(c.Step)
+		if err != nil {
+			return 0, "", errors.Wrap(err, "invalid thanos step")
+		}
+
+		limit = time.Duration(step) / thanosAutoStepDivisor
+		reason = fmt.Sprintf("max_source_resolution=auto, step %s", c.Step)
+	} else {
+		d, err := model.ParseDuration(maxResolution)
+		if err != nil {
+			return 0, "", errors.Wrap(err, "invalid thanos max_source_resolution")
+		}
+
+		limit = time.Duration(d)
+		reason = fmt.Sprintf("max_source_resolution=%s", maxResolution)
+	}
+
+	var ret time.Duration
+
+	for _, r := range thanosResolutions {
+		if r <= limit {
+			ret = r
+		}
+	}
+
+	return ret, reason, nil
+}
+
+// thanosDiagnostics warns about range vector selectors which cover less than two samples
+// once Thanos serves downsampled data, in which case functions like rate() silently
+// return no result.
+func (s *server) thanosDiagnostics(doc *cache.DocumentHandle) []protocol.Diagnostic {
+	resolution, reason, err := s.getConfig().Thanos.thanosResolution()
+	if err != nil || resolution == 0 {
+		return nil
+	}
+
+	queries, err := doc.GetQueries()
+	if err != nil {
+		return nil
+	}
+
+	var ret []protocol.Diagnostic
+
+	for _, q := range queries {
+		if q.Ast == nil {
+			continue
+		}
+
+		promql.Inspect(q.Ast, func(node promql.Node, _ []promql.Node) error {
+			call, ok := node.(*promql.Call)
+			if !ok || !samplesRequired[call.Func.Name] {
+				return nil
+			}
+
+			for _, arg := range call.Args {
+				ms, ok := arg.(*promql.MatrixSelector)
+				if !ok || ms.Range >= 2*resolution {
+					continue
+				}
+
+				rng, err := getEditRange(&cache.Location{Doc: doc, Query: q, Node: ms}, "")
+				if err != nil {
+					continue
+				}
+
+				ret = append(ret, protocol.Diagnostic{
+					Range:    rng,
+					Severity: 2, // Warning
+					Code:     codeThanosResolution,
+					Source:   "promql-lsp",
+					Message: fmt.Sprintf("%s() over [%s] returns no results on %s downsampled data (%s); use a range of at least %s",
+						call.Func.Name, model.Duration(ms.Range), model.Duration(resolution), reason, model.Duration(2*resolution)),
+				})
+			}
+
+			return nil
+		})
+	}
+
+	return ret
+}
+
+// getThanosDocs describes the resolution Thanos selects for a query
+func (s *server) getThanosDocs() string {
+	resolution, reason, err := s.getConfig().Thanos.thanosResolution()
+	if err != nil || reason == "" {
+		return ""
+	}
+
+	if resolution == 0 {
+		return fmt.Sprintf("__Thanos Resolution:__ raw data (%s)\n\n", reason)
+	}
+
+	return fmt.Sprintf("__Thanos Resolution:__ %s downsampled data (%s)\n\n", model.Duration(resolution), reason)
+}
@@ -0,0 +1,159 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/jsonrpc2"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// The trace levels a client can request
+const (
+	traceOff      = "off"
+	traceMessages = "messages"
+	traceVerbose  = "verbose"
+)
+
+// setTraceMethod and logTraceMethod are the methods of the trace notifications.
+// The protocol package uses outdated names for them.
+const (
+	setTraceMethod = "$/setTrace"
+	logTraceMethod = "$/logTrace"
+)
+
+// setTrace changes the trace level, unknown levels turn tracing off
+func (s *server) setTrace(level protocol.TraceValues) {
+	s.traceMu.Lock()
+	defer s.traceMu.Unlock()
+
+	switch level {
+	case traceMessages, traceVerbose:
+		s.trace = level
+	default:
+		s.trace = traceOff
+	}
+}
+
+// getTrace returns the trace level requested by the client
+func (s *server) getTrace() string {
+	s.traceMu.Lock()
+	defer s.traceMu.Unlock()
+
+	if s.trace == "" {
+		return traceOff
+	}
+
+	return s.trace
+}
+
+// SetTraceNotification is required by the protocol.Server interface
+func (s *server) SetTraceNotification(_ context.Context, params *protocol.SetTraceParams) error {
+	s.setTrace(params.Value)
+	return nil
+}
+
+// traceHandler sends $/logTrace notifications about the requests and notifications
+// handled by a jsonrpc2.Conn, at the trace level requested by the client
+type traceHandler struct {
+	jsonrpc2.EmptyHandler
+	s *server
+}
+
+type traceKey int
+
+const (
+	traceMethodKey = traceKey(iota)
+	traceStartKey
+)
+
+// Request is required by the jsonrpc2.Handler interface
+func (h *traceHandler) Request(ctx context.Context, conn *jsonrpc2.Conn, direction jsonrpc2.Direction, r *jsonrpc2.WireRequest) context.Context {
+	if direction != jsonrpc2.Receive {
+		return ctx
+	}
+
+	ctx = context.WithValue(ctx, traceMethodKey, r.Method)
+	ctx = context.WithValue(ctx, traceStartKey, time.Now())
+
+	var message string
+
+	if r.ID == nil {
+		message = fmt.Sprintf("Received notification '%s'.", r.Method)
+	} else {
+		message = fmt.Sprintf("Received request '%s - (%s)'.", r.Method, r.ID)
+	}
+
+	var verbose string
+	if r.Params != nil {
+		verbose = "Params: " + string(*r.Params)
+	}
+
+	h.logTrace(conn, message, verbose)
+
+	return ctx
+}
+
+// Response is required by the jsonrpc2.Handler interface
+func (h *traceHandler) Response(ctx context.Context, conn *jsonrpc2.Conn, direction jsonrpc2.Direction, r *jsonrpc2.WireResponse) context.Context {
+	if direction != jsonrpc2.Send {
+		return ctx
+	}
+
+	method, ok := ctx.Value(traceMethodKey).(string)
+	if !ok {
+		return ctx
+	}
+
+	message := fmt.Sprintf("Sending response '%s - (%s)'.", method, r.ID)
+
+	if start, ok := ctx.Value(traceStartKey).(time.Time); ok {
+		message += fmt.Sprintf(" Processing request took %dms.", time.Since(start).Milliseconds())
+	}
+
+	var verbose string
+
+	switch {
+	case r.Error != nil:
+		message += fmt.Sprintf(" Request failed: %s (%d).", r.Error.Message, r.Error.Code)
+	case r.Result != nil:
+		verbose = "Result: " + string(*r.Result)
+	default:
+		verbose = "No result returned."
+	}
+
+	h.logTrace(conn, message, verbose)
+
+	return ctx
+}
+
+// logTrace sends a trace message to the client, if tracing is enabled.
+// verbose is only sent if the client asked for verbose traces.
+func (h *traceHandler) logTrace(conn *jsonrpc2.Conn, message string, verbose string) {
+	level := h.s.getTrace()
+	if level == traceOff {
+		return
+	}
+
+	params := &protocol.LogTraceParams{Message: message}
+	if level == traceVerbose {
+		params.Verbose = verbose
+	}
+
+	// nolint: errcheck
+	conn.Notify(h.s.lifetime, logTraceMethod, params)
+}
@@ -0,0 +1,115 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/jsonrpc2"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// traceRecorder collects the $/logTrace notifications received by a client
+type traceRecorder struct {
+	jsonrpc2.EmptyHandler
+	traces chan protocol.LogTraceParams
+}
+
+func (r *traceRecorder) Deliver(_ context.Context, req *jsonrpc2.Request, delivered bool) bool {
+	if delivered || req.Method != logTraceMethod {
+		return false
+	}
+
+	var params protocol.LogTraceParams
+	if err := json.Unmarshal(*req.Params, &params); err != nil {
+		panic(err)
+	}
+
+	r.traces <- params
+
+	return true
+}
+
+// TestTrace checks that requests are traced at the level set by the client
+func TestTrace(*testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	serverEnd, clientEnd := net.Pipe()
+
+	_, s := ServerFromStream(ctx, jsonrpc2.NewHeaderStream(serverEnd, serverEnd), &Config{})
+	go s.Run() // nolint: errcheck
+
+	recorder := &traceRecorder{traces: make(chan protocol.LogTraceParams, 100)}
+
+	client := jsonrpc2.NewConn(jsonrpc2.NewHeaderStream(clientEnd, clientEnd))
+	client.AddHandler(recorder)
+
+	go client.Run(ctx) // nolint: errcheck
+
+	var result json.RawMessage
+
+	if err := client.Call(ctx, "initialize", map[string]string{"trace": traceVerbose}, &result); err != nil {
+		panic(err)
+	}
+
+	if err := client.Call(ctx, statusMethod, struct{}{}, &result); err != nil {
+		panic(err)
+	}
+
+	expected := []string{
+		"Sending response 'initialize - (#1)'.",
+		"Received request 'promql/status - (#2)'.",
+		"Sending response 'promql/status - (#2)'.",
+	}
+
+	for _, prefix := range expected {
+		select {
+		case trace := <-recorder.traces:
+			if !strings.HasPrefix(trace.Message, prefix) || trace.Verbose == "" {
+				panic(fmt.Sprintf("expected a verbose trace starting with %q, got %+v", prefix, trace))
+			}
+		case <-time.After(5 * time.Second):
+			panic("expected a trace starting with " + prefix)
+		}
+	}
+
+	if err := client.Notify(ctx, setTraceMethod, protocol.SetTraceParams{Value: traceOff}); err != nil {
+		panic(err)
+	}
+
+	// The notification disabling traces is still traced
+	<-recorder.traces
+
+	// Notifications are handled concurrently with later requests
+	for s.server.getTrace() != traceOff {
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := client.Call(ctx, statusMethod, struct{}{}, &result); err != nil {
+		panic(err)
+	}
+
+	select {
+	case trace := <-recorder.traces:
+		panic(fmt.Sprintf("expected no traces after they were turned off, got %+v", trace))
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"net"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	config_util "github.com/prometheus/common/config"
+
+	"github.com/prometheus-community/promql-langserver/langserver/cache"
+)
+
+// The defaults of the transport options match the transport used without them
+const (
+	defaultDialTimeout         = 30 * time.Second
+	defaultKeepAlive           = 30 * time.Second
+	defaultTLSHandshakeTimeout = 10 * time.Second
+)
+
+// TransportConfig overrides the network settings of the connections to a Prometheus server,
+// e.g. to connect to an in-cluster Prometheus directly while an external Thanos is reached through a proxy
+type TransportConfig struct {
+	// DisableProxy connects directly, ignoring the HTTP_PROXY and HTTPS_PROXY environment variables
+	// and the proxy_url of the http_config
+	DisableProxy bool `yaml:"disable_proxy"`
+	// DialTimeout limits the time establishing a connection may take, 30s by default
+	DialTimeout string `yaml:"dial_timeout"`
+	// KeepAlive is the interval of TCP keep-alive probes, 30s by default
+	KeepAlive string `yaml:"keep_alive"`
+	// IdleConnTimeout closes connections that have been idle for this time, e.g. before a load balancer
+	// drops them. Idle connections are kept open by default.
+	IdleConnTimeout string `yaml:"idle_conn_timeout"`
+	// DisableKeepAlives opens a new connection for every request
+	DisableKeepAlives bool `yaml:"disable_keep_alives"`
+}
+
+// transportFor returns the transport option of a Prometheus server, which is configured either by its URL
+// or by its name in prometheus_servers. It returns nil if there is none.
+func (c *Config) transportFor(url string) *TransportConfig {
+	if transport, ok := c.Transport[url]; ok {
+		return transport
+	}
+
+	names := make([]string, 0, len(c.PrometheusServers))
+
+	for name, u := range c.PrometheusServers {
+		if u == url {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		if transport, ok := c.Transport[name]; ok {
+			return transport
+		}
+	}
+
+	return nil
+}
+
+// parseTransportDuration parses one of the durations of the transport option, it returns def if it isn't set
+func parseTransportDuration(option string, value string, def time.Duration) (time.Duration, error) {
+	if value == "" {
+		return def, nil
+	}
+
+	d, err := cache.ParseDuration(value)
+
+	return d, errors.Wrapf(err, "invalid %s", option)
+}
+
+// roundTripper creates a transport with the settings of the option. Like the transport of the http_config,
+// it adds the credentials and client certificates of the http_config if there is one.
+func (t *TransportConfig) roundTripper(httpConfig *config_util.HTTPClientConfig) (http.RoundTripper, error) {
+	dialTimeout, err := parseTransportDuration("dial_timeout", t.DialTimeout, defaultDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	keepAlive, err := parseTransportDuration("keep_alive", t.KeepAlive, defaultKeepAlive)
+	if err != nil {
+		return nil, err
+	}
+
+	idleConnTimeout, err := parseTransportDuration("idle_conn_timeout", t.IdleConnTimeout, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   dialTimeout,
+			KeepAlive: keepAlive,
+		}).DialContext,
+		TLSHandshakeTimeout: defaultTLSHandshakeTimeout,
+		IdleConnTimeout:     idleConnTimeout,
+		DisableKeepAlives:   t.DisableKeepAlives,
+	}
+
+	if t.DisableProxy {
+		transport.Proxy = nil
+	}
+
+	if httpConfig == nil {
+		return transport, nil
+	}
+
+	// Like the transport of the http_config, only its proxy_url is used
+	if !t.DisableProxy {
+		transport.Proxy = http.ProxyURL(httpConfig.ProxyURL.URL)
+	}
+
+	transport.TLSClientConfig, err = config_util.NewTLSConfig(&httpConfig.TLSConfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid http_config")
+	}
+
+	var rt http.RoundTripper = transport
+
+	if len(httpConfig.BearerToken) > 0 {
+		rt = config_util.NewBearerAuthRoundTripper(httpConfig.BearerToken, rt)
+	} else if len(httpConfig.BearerTokenFile) > 0 {
+		rt = config_util.NewBearerAuthFileRoundTripper(httpConfig.BearerTokenFile, rt)
+	}
+
+	if httpConfig.BasicAuth != nil {
+		rt = config_util.NewBasicAuthRoundTripper(httpConfig.BasicAuth.Username, httpConfig.BasicAuth.Password, httpConfig.BasicAuth.PasswordFile, rt)
+	}
+
+	return rt, nil
+}
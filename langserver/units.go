@@ -0,0 +1,253 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"fmt"
+	"go/token"
+	"strings"
+
+	"github.com/prometheus-community/promql-langserver/langserver/cache"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+	"github.com/prometheus/prometheus/promql"
+)
+
+// The units inferred from metric names. An empty unit means it is not known.
+const (
+	unitSeconds        = "seconds"
+	unitBytes          = "bytes"
+	unitRatio          = "ratio"
+	unitCount          = "count"
+	unitPerSecond      = "per second"
+	unitBytesPerSecond = "bytes per second"
+)
+
+// perSecondUnits are the units of the rate of counters of a unit
+var perSecondUnits = map[string]string{ // nolint: gochecknoglobals
+	unitSeconds: unitRatio,
+	unitBytes:   unitBytesPerSecond,
+	unitCount:   unitPerSecond,
+}
+
+// metricUnit infers the unit of a metric from the suffix of its name,
+// following the Prometheus naming conventions
+func metricUnit(name string) string {
+	for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+		if strings.HasSuffix(name, suffix) {
+			if suffix != "_sum" {
+				return unitCount
+			}
+
+			name = strings.TrimSuffix(name, suffix)
+
+			break
+		}
+	}
+
+	name = strings.TrimSuffix(name, "_total")
+
+	switch {
+	case strings.HasSuffix(name, "_seconds"), strings.HasSuffix(name, "_timestamp"):
+		return unitSeconds
+	case strings.HasSuffix(name, "_bytes"):
+		return unitBytes
+	case strings.HasSuffix(name, "_ratio"):
+		return unitRatio
+	}
+
+	return ""
+}
+
+// exprUnit infers the unit of the result of an expression
+// nolint: gocyclo
+func exprUnit(node promql.Node) string {
+	switch n := node.(type) {
+	case *promql.VectorSelector:
+		if strings.HasSuffix(n.Name, "_total") && metricUnit(n.Name) == "" {
+			return unitCount
+		}
+
+		return metricUnit(n.Name)
+	case *promql.MatrixSelector:
+		return exprUnit(n.VectorSelector)
+	case *promql.SubqueryExpr:
+		return exprUnit(n.Expr)
+	case *promql.ParenExpr:
+		return exprUnit(n.Expr)
+	case *promql.UnaryExpr:
+		return exprUnit(n.Expr)
+	case *promql.AggregateExpr:
+		switch n.Op {
+		case promql.COUNT, promql.COUNT_VALUES:
+			return unitCount
+		default:
+			return exprUnit(n.Expr)
+		}
+	case *promql.Call:
+		return callUnit(n)
+	case *promql.BinaryExpr:
+		lhs, rhs := exprUnit(n.LHS), exprUnit(n.RHS)
+
+		switch {
+		case n.Op == promql.DIV && lhs != "" && lhs == rhs:
+			return unitRatio
+		case n.Op == promql.MUL || n.Op == promql.DIV || n.Op == promql.MOD || n.Op == promql.POW:
+			return ""
+		case n.ReturnBool:
+			return ""
+		case lhs == "":
+			return rhs
+		}
+
+		return lhs
+	}
+
+	return ""
+}
+
+// callUnit infers the unit of the result of a function call
+func callUnit(n *promql.Call) string {
+	switch n.Func.Name {
+	case "time", "timestamp":
+		return unitSeconds
+	case "count_over_time", "changes", "resets":
+		return unitCount
+	case "rate", "irate", "deriv":
+		if len(n.Args) > 0 {
+			return perSecondUnits[exprUnit(n.Args[0])]
+		}
+	case "histogram_quantile":
+		if len(n.Args) > 1 {
+			return bucketUnit(n.Args[1])
+		}
+	case "abs", "ceil", "floor", "round", "clamp_max", "clamp_min", "sort", "sort_desc",
+		"label_replace", "label_join", "increase", "delta", "idelta",
+		"avg_over_time", "min_over_time", "max_over_time", "sum_over_time", "quantile_over_time", "stddev_over_time":
+		for _, arg := range n.Args {
+			if arg.Type() == promql.ValueTypeVector || arg.Type() == promql.ValueTypeMatrix {
+				return exprUnit(arg)
+			}
+		}
+	}
+
+	return ""
+}
+
+// bucketUnit returns the unit of the observations of the histogram buckets an expression selects
+func bucketUnit(node promql.Node) string {
+	var ret string
+
+	promql.Inspect(node, func(node promql.Node, _ []promql.Node) error {
+		if vs, ok := node.(*promql.VectorSelector); ok && strings.HasSuffix(vs.Name, "_bucket") {
+			ret = metricUnit(strings.TrimSuffix(vs.Name, "_bucket"))
+		}
+
+		return nil
+	})
+
+	return ret
+}
+
+// unitMismatch describes a binary operation whose operands have a suspicious combination of units
+func unitMismatch(n *promql.BinaryExpr) string {
+	lhs, rhs := exprUnit(n.LHS), exprUnit(n.RHS)
+
+	if isComparisonOperator(n.Op) {
+		for _, side := range []struct {
+			unit  string
+			other promql.Expr
+		}{{lhs, n.RHS}, {rhs, n.LHS}} {
+			if lit, ok := unparen(side.other).(*promql.NumberLiteral); ok && side.unit == unitRatio && lit.Val > 1 {
+				return fmt.Sprintf("comparing a ratio with %v, but ratios are between 0 and 1", lit.Val)
+			}
+		}
+	}
+
+	if lhs == "" || rhs == "" || lhs == rhs {
+		return ""
+	}
+
+	switch {
+	case n.Op == promql.ADD:
+		return fmt.Sprintf("adding %s to %s", rhs, lhs)
+	case n.Op == promql.SUB:
+		return fmt.Sprintf("subtracting %s from %s", rhs, lhs)
+	case isComparisonOperator(n.Op):
+		return fmt.Sprintf("comparing %s with %s", lhs, rhs)
+	}
+
+	return ""
+}
+
+// unparen removes the parentheses around an expression
+func unparen(expr promql.Expr) promql.Expr {
+	for {
+		p, ok := expr.(*promql.ParenExpr)
+		if !ok {
+			return expr
+		}
+
+		expr = p.Expr
+	}
+}
+
+// unitDiagnostics warns about operations combining values of incompatible units.
+// The units are guessed from the metric names, so the check has to be enabled with the lint_units option.
+func (s *server) unitDiagnostics(doc *cache.DocumentHandle) []protocol.Diagnostic {
+	if s.getConfig() == nil || !s.getConfig().LintUnits {
+		return nil
+	}
+
+	queries, err := doc.GetQueries()
+	if err != nil {
+		return nil
+	}
+
+	var ret []protocol.Diagnostic
+
+	for _, q := range queries {
+		if q.Ast == nil {
+			continue
+		}
+
+		promql.Inspect(q.Ast, func(node promql.Node, _ []promql.Node) error {
+			n, ok := node.(*promql.BinaryExpr)
+			if !ok {
+				return nil
+			}
+
+			msg := unitMismatch(n)
+			if msg == "" {
+				return nil
+			}
+
+			rng, err := tokenRange(doc, q.Pos+token.Pos(n.PositionRange().Start), q.Pos+token.Pos(n.PositionRange().End))
+			if err != nil {
+				return nil
+			}
+
+			ret = append(ret, protocol.Diagnostic{
+				Range:    rng,
+				Severity: 2, // Warning
+				Code:     codeUnitMismatch,
+				Source:   "promql-lsp",
+				Message:  "suspicious operation: " + msg + " (units are inferred from the metric names)",
+			})
+
+			return nil
+		})
+	}
+
+	return ret
+}
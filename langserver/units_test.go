@@ -0,0 +1,63 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+// TestUnitDiagnostics checks the unit consistency lint
+func TestUnitDiagnostics(*testing.T) {
+	h, err := NewHeadlessServer(context.Background(), &Config{LintUnits: true}, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	tests := []struct {
+		query    string
+		mismatch bool
+	}{
+		{`process_resident_memory_bytes + process_cpu_seconds_total`, true},
+		{`node_filesystem_avail_bytes / node_filesystem_size_bytes < 10`, true},
+		{`node_filesystem_avail_bytes / node_filesystem_size_bytes < 0.1`, false},
+		{`cache_hit_ratio > 1000`, true},
+		{`time() - process_start_time_seconds > 3600`, false},
+		{`histogram_quantile(0.9, rate(http_request_duration_seconds_bucket[5m])) > node_memory_bytes`, true},
+		{`rate(http_requests_total[5m]) + rate(http_errors_total[5m])`, false},
+		{`sum(rate(node_network_receive_bytes_total[5m])) > node_memory_bytes`, true},
+		{`foo + bar_bytes`, false},
+	}
+
+	for i, test := range tests {
+		report, err := h.AnalyzeDocument(fmt.Sprintf("units_%d.promql", i), "promql", test.query)
+		if err != nil {
+			panic(err)
+		}
+
+		mismatch := false
+
+		for _, d := range report.Diagnostics {
+			if d.Code == codeUnitMismatch {
+				mismatch = true
+			}
+		}
+
+		if mismatch != test.mismatch {
+			panic(fmt.Sprintf("Expected mismatch %v for %q, got %v", test.mismatch, test.query, report.Diagnostics))
+		}
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"go/token"
+	"time"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql"
+
+	"github.com/prometheus-community/promql-langserver/langserver/cache"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// maxLabelSuggestionDistance is the largest edit distance of a label name suggested for a misspelled one
+const maxLabelSuggestionDistance = 2
+
+// unknownLabelDiagnostics warns about label matchers on labels that no series of the selected metric has.
+// Matchers that don't match the empty value then make the selector match nothing, which is usually a typo,
+// e.g. {jbo="api"}. The label names are taken from the series of the metric on the Prometheus server
+// and the servers of the merge_metadata option.
+func (s *server) unknownLabelDiagnostics(doc *cache.DocumentHandle) []protocol.Diagnostic {
+	if s.getQueryAPIFor(doc.GetURI()) == nil {
+		return nil
+	}
+
+	queries, err := doc.GetQueries()
+	if err != nil {
+		return nil
+	}
+
+	var ret []protocol.Diagnostic
+
+	for _, q := range queries {
+		if q.Ast == nil {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(s.lifetime, 5*time.Second)
+
+		promql.Inspect(q.Ast, func(node promql.Node, _ []promql.Node) error {
+			vs, ok := node.(*promql.VectorSelector)
+			if !ok || vs.Name == "" {
+				return nil
+			}
+
+			var names []string
+
+			known := make(map[string]bool)
+
+			values, _ := s.mergedLabelValues(ctx, doc.GetURI(), q, vs.Name, "")
+
+			for _, name := range values {
+				names = append(names, string(name))
+				known[string(name)] = true
+			}
+
+			// Without series, e.g. for new metrics, the labels are unknown
+			if len(names) == 0 {
+				return nil
+			}
+
+			for _, m := range vs.LabelMatchers {
+				if m.Name == labels.MetricName || known[m.Name] || m.Matches("") {
+					continue
+				}
+
+				ref, ok := matcherLabelReference(q, vs, m.Name)
+				if !ok {
+					continue
+				}
+
+				rng, err := tokenRange(doc, ref.Pos, ref.End)
+				if err != nil {
+					continue
+				}
+
+				msg := fmt.Sprintf("no series of %s has a label %q, so the selector matches nothing", vs.Name, m.Name)
+
+				if suggestion := closestName(m.Name, names, maxLabelSuggestionDistance); suggestion != "" {
+					msg += fmt.Sprintf("; did you mean %q?", suggestion)
+				}
+
+				ret = append(ret, protocol.Diagnostic{
+					Range:    rng,
+					Severity: 2, // Warning
+					Code:     codeUnknownLabel,
+					Source:   "promql-lsp",
+					Message:  msg,
+				})
+			}
+
+			return nil
+		})
+
+		cancel()
+	}
+
+	return ret
+}
+
+// matcherLabelReference finds the label name of a matcher of a selector in the query text
+func matcherLabelReference(q *cache.CompiledQuery, vs *promql.VectorSelector, name string) (labelReference, bool) {
+	start, end := vs.PosRange.Start, vs.PosRange.End
+	if start > end || int(end) > len(q.Content) {
+		return labelReference{}, false
+	}
+
+	l := promql.Lex(q.Content[start:end])
+
+	var prev promql.Item
+
+	inBraces := false
+
+	for {
+		var item promql.Item
+
+		l.NextItem(&item)
+
+		switch item.Typ {
+		case promql.EOF, promql.ERROR:
+			return labelReference{}, false
+		case promql.LEFT_BRACE:
+			inBraces = true
+		case promql.RIGHT_BRACE:
+			inBraces = false
+		case promql.EQL, promql.NEQ, promql.EQL_REGEX, promql.NEQ_REGEX:
+			if inBraces && prev.Typ == promql.IDENTIFIER && prev.Val == name {
+				return labelReference{
+					Name: name,
+					Pos:  q.Pos + token.Pos(int(start)+int(prev.Pos)),
+					End:  q.Pos + token.Pos(int(start)+int(prev.Pos)+len(name)),
+				}, true
+			}
+		}
+
+		if item.Typ != promql.COMMENT {
+			prev = item
+		}
+	}
+}
+
+// closestName returns the most similar of names, or "" if none is within maxDistance edits
+func closestName(name string, names []string, maxDistance int) string {
+	best, bestDistance := "", maxDistance+1
+
+	for _, candidate := range names {
+		if d := editDistance(name, candidate); d < bestDistance || (d == bestDistance && candidate < best) {
+			best, bestDistance = candidate, d
+		}
+	}
+
+	return best
+}
@@ -0,0 +1,79 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestUnknownLabelDiagnostics checks that matchers on labels no series of the metric has are reported
+func TestUnknownLabelDiagnostics(*testing.T) {
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.URL.Path != "/api/v1/series" || r.FormValue("match[]") != "up" {
+			fmt.Fprint(w, `{"status":"success","data":[]}`)
+			return
+		}
+
+		fmt.Fprint(w, `{"status":"success","data":[{"__name__":"up","job":"api","instance":"a:9100"}]}`)
+	}))
+	defer prom.Close()
+
+	h, err := NewHeadlessServer(context.Background(), &Config{PrometheusURL: prom.URL}, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	tests := []struct {
+		query   string
+		message string
+	}{
+		{`up{job="api"}`, ""},
+		{`up{jbo="api"}`, `has a label "jbo", so the selector matches nothing; did you mean "job"?`},
+		{`sum(rate(up{instance="a:9100", zone=~"eu.+"}[5m]))`, `has a label "zone"`},
+		{`up{zone=""}`, ""},
+		{`up{zone!="eu"}`, ""},
+		{`new_metric{jbo="api"}`, ""},
+	}
+
+	for i, test := range tests {
+		report, err := h.AnalyzeDocument(fmt.Sprintf("unknownlabels_%d.promql", i), "promql", test.query)
+		if err != nil {
+			panic(err)
+		}
+
+		message := ""
+
+		for _, d := range report.Diagnostics {
+			if d.Code == codeUnknownLabel {
+				message = d.Message
+
+				if start := strings.Index(test.query, "jbo"); start >= 0 && int(d.Range.Start.Character) != start {
+					panic(fmt.Sprintf("Expected the diagnostic for %q to start at %d, got %v", test.query, start, d.Range))
+				}
+			}
+		}
+
+		if test.message == "" && message != "" || !strings.Contains(message, test.message) {
+			panic(fmt.Sprintf("Expected %q for %q, got %q", test.message, test.query, message))
+		}
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/promql"
+
+	"github.com/prometheus-community/promql-langserver/langserver/cache"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// unknownMetricDiagnostics reports selectors of metric names the connected Prometheus server doesn't know,
+// with a suggestion of a similar metric name. Metrics recorded by rules in open documents are known,
+// since the rules might not be deployed yet. Nothing is reported without a Prometheus server, so that
+// offline use isn't flooded with hints, or if disable_unknown_metric_hints is set.
+func (s *server) unknownMetricDiagnostics(doc *cache.DocumentHandle) []protocol.Diagnostic {
+	if s.getConfig() != nil && s.getConfig().DisableUnknownMetricHints || s.getQueryAPIFor(doc.GetURI()) == nil {
+		return nil
+	}
+
+	queries, err := doc.GetQueries()
+	if err != nil {
+		return nil
+	}
+
+	index := s.cache.GetMetricIndex()
+
+	var ret []protocol.Diagnostic
+
+	for _, q := range queries {
+		if q.Ast == nil {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(s.lifetime, 5*time.Second)
+		names := s.metricNames(ctx, doc.GetURI(), q)
+
+		cancel()
+
+		// An empty list means the Prometheus server is unreachable or has no data at all
+		if len(names) == 0 {
+			continue
+		}
+
+		known := make(map[string]bool, len(names))
+
+		var candidates []string
+
+		for _, name := range names {
+			known[string(name)] = true
+			candidates = append(candidates, string(name))
+		}
+
+		promql.Inspect(q.Ast, func(node promql.Node, _ []promql.Node) error {
+			vs, ok := node.(*promql.VectorSelector)
+			if !ok || vs.Name == "" || known[vs.Name] || isRecorded(index[vs.Name]) {
+				return nil
+			}
+
+			rng, err := getEditRange(&cache.Location{Doc: doc, Query: q, Node: vs}, vs.Name)
+			if err != nil {
+				return nil
+			}
+
+			msg := fmt.Sprintf("the Prometheus server has no metric %s", vs.Name)
+
+			if suggestion := closestName(vs.Name, candidates, maxMetricSuggestionDistance(vs.Name)); suggestion != "" {
+				msg += fmt.Sprintf("; did you mean %s?", suggestion)
+			}
+
+			ret = append(ret, protocol.Diagnostic{
+				Range:    rng,
+				Severity: 3, // Information
+				Code:     codeUnknownMetric,
+				Source:   "promql-lsp",
+				Message:  msg,
+			})
+
+			return nil
+		})
+	}
+
+	return ret
+}
+
+// metricNames returns the metric names of the Prometheus server a document is mapped to and the servers
+// of the merge_metadata option, from the metadata snapshot if it is refreshed in the background
+func (s *server) metricNames(ctx context.Context, uri string, q *cache.CompiledQuery) model.LabelValues {
+	if snapshot := s.metadataFor(uri); snapshot != nil && len(s.getConfig().MergeMetadata) == 0 {
+		return snapshot.metricNames
+	}
+
+	names, _ := s.mergedLabelValues(ctx, uri, q, "", "__name__")
+
+	return names
+}
+
+// isRecorded checks whether one of the references to a metric is the record field of a rule
+func isRecorded(refs []cache.MetricReference) bool {
+	for _, ref := range refs {
+		if ref.Definition {
+			return true
+		}
+	}
+
+	return false
+}
+
+// maxMetricSuggestionDistance allows about one edit per five characters, since metric names are long
+func maxMetricSuggestionDistance(name string) int {
+	if d := len(name) / 5; d > maxLabelSuggestionDistance {
+		return d
+	}
+
+	return maxLabelSuggestionDistance
+}
@@ -0,0 +1,103 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestUnknownMetricDiagnostics checks that metric names the Prometheus server doesn't know are reported
+func TestUnknownMetricDiagnostics(*testing.T) {
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.URL.Path != "/api/v1/label/__name__/values" {
+			fmt.Fprint(w, `{"status":"success","data":[]}`)
+			return
+		}
+
+		fmt.Fprint(w, `{"status":"success","data":["up","http_requests_total","node_cpu_seconds_total"]}`)
+	}))
+	defer prom.Close()
+
+	for _, disabled := range []bool{false, true} {
+		h, err := NewHeadlessServer(context.Background(), &Config{PrometheusURL: prom.URL, DisableUnknownMetricHints: disabled}, nil)
+		if err != nil {
+			panic(err)
+		}
+
+		tests := []struct {
+			query   string
+			message string
+		}{
+			{`sum(rate(http_requests_total[5m]))`, ""},
+			{`sum(rate(http_request_total[5m]))`, "has no metric http_request_total; did you mean http_requests_total?"},
+			{`completely_different`, "has no metric completely_different"},
+		}
+
+		for i, test := range tests {
+			report, err := h.AnalyzeDocument(fmt.Sprintf("unknownmetrics_%d.promql", i), "promql", test.query)
+			if err != nil {
+				panic(err)
+			}
+
+			message := ""
+
+			for _, d := range report.Diagnostics {
+				if d.Code == codeUnknownMetric {
+					message = d.Message
+				}
+			}
+
+			if disabled || test.message == "" {
+				if message != "" {
+					panic(fmt.Sprintf("Expected no hint for %q, got %q", test.query, message))
+				}
+
+				continue
+			}
+
+			if !strings.Contains(message, test.message) || strings.Contains(test.query, "different") && strings.Contains(message, "did you mean") {
+				panic(fmt.Sprintf("Expected %q for %q, got %q", test.message, test.query, message))
+			}
+		}
+
+		const rules = `groups:
+- name: example
+  rules:
+  - record: job:http_requests:rate5m
+    expr: sum by (job) (rate(http_requests_total[5m]))
+  - alert: HighRate
+    expr: job:http_requests:rate5m > 10
+`
+
+		report, err := h.AnalyzeDocument("unknownmetrics.yml", "yaml", rules)
+		if err != nil {
+			panic(err)
+		}
+
+		for _, d := range report.Diagnostics {
+			if d.Code == codeUnknownMetric {
+				panic(fmt.Sprintf("Expected recorded metrics to be known, got %v", d))
+			}
+		}
+
+		h.Close()
+	}
+}
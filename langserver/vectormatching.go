@@ -0,0 +1,266 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"go/token"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql"
+
+	"github.com/prometheus-community/promql-langserver/langserver/cache"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// fixVectorMatching identifies the quick fixes that match binary operations on the labels both operands have
+const fixVectorMatching = "vector-matching"
+
+// vectorMatchingChecks finds binary operations between vectors whose label sets can't match, e.g.
+// `errors_total / requests_total` if only the errors have a code label. The series of one side that
+// have labels the other side doesn't have silently have no match. Operations with labels in common
+// get a quick fix matching only on those, the others are returned as plain diagnostics.
+// The label sets are only known with a Prometheus server connected.
+// nolint: funlen
+func (s *server) vectorMatchingChecks(doc *cache.DocumentHandle) ([]quickFix, []protocol.Diagnostic) {
+	if s.getQueryAPI() == nil {
+		return nil, nil
+	}
+
+	queries, err := doc.GetQueries()
+	if err != nil {
+		return nil, nil
+	}
+
+	var (
+		fixes       []quickFix
+		diagnostics []protocol.Diagnostic
+	)
+
+	for _, q := range queries {
+		if q.Ast == nil || len(q.Err) > 0 {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(s.lifetime, 5*time.Second)
+
+		a := &labelAnalyzer{s: s, ctx: ctx, query: q, series: make(map[string]labelSet)}
+
+		promql.Inspect(q.Ast, func(node promql.Node, _ []promql.Node) error {
+			n, ok := node.(*promql.BinaryExpr)
+			if !ok || n.Op == promql.LOR || n.LHS.Type() != promql.ValueTypeVector || n.RHS.Type() != promql.ValueTypeVector {
+				return nil
+			}
+
+			lhs, rhs := a.exprLabels(n.LHS), a.exprLabels(n.RHS)
+			if !lhs.Exact || !rhs.Exact {
+				return nil
+			}
+
+			// Labels of on(...) that an aggregation removed are reported as dropped-label
+			if n.VectorMatching != nil && n.VectorMatching.On {
+				for _, name := range n.VectorMatching.MatchingLabels {
+					if side, _ := droppedOperand(name, lhs, rhs); side != "" {
+						return nil
+					}
+				}
+			}
+
+			lhsOnly, rhsOnly := unmatchedLabels(n.VectorMatching, lhs, rhs)
+			if len(lhsOnly) == 0 && len(rhsOnly) == 0 {
+				return nil
+			}
+
+			op, clause, ok := matchingClause(q, n)
+			if !ok {
+				return nil
+			}
+
+			rng, err := tokenRange(doc, op.Pos, op.End)
+			if err != nil {
+				return nil
+			}
+
+			var differences []string
+
+			if len(lhsOnly) > 0 {
+				differences = append(differences, fmt.Sprintf("%s only on the left-hand side", strings.Join(lhsOnly, ", ")))
+			}
+
+			if len(rhsOnly) > 0 {
+				differences = append(differences, fmt.Sprintf("%s only on the right-hand side", strings.Join(rhsOnly, ", ")))
+			}
+
+			msg := fmt.Sprintf("the operands of %s can't match on all their labels, there is %s; series with these labels have no match",
+				n.Op, strings.Join(differences, " and "))
+
+			common := commonLabels(lhs, rhs)
+			if len(common) == 0 {
+				diagnostics = append(diagnostics, protocol.Diagnostic{
+					Range:    rng,
+					Severity: 2, // Warning
+					Code:     fixVectorMatching,
+					Source:   "promql-lsp",
+					Message:  msg + ". The operands have no labels in common, aggregate them, e.g. with sum(), to match them",
+				})
+
+				return nil
+			}
+
+			on := fmt.Sprintf("on(%s)", strings.Join(common, ", "))
+
+			newText := on
+			if clause.Pos == clause.End {
+				newText = " " + on
+			}
+
+			fixes = append(fixes, quickFix{
+				Rule:  fixVectorMatching,
+				Title: "Match " + on,
+				Diagnostic: protocol.Diagnostic{
+					Range:    rng,
+					Severity: 2, // Warning
+					Code:     fixVectorMatching,
+					Source:   "promql-lsp",
+					Message:  fmt.Sprintf("%s. Use %s to match on the labels both operands have", msg, on),
+				},
+				Edits: []tokenEdit{{Pos: clause.Pos, End: clause.End, NewText: newText}},
+			})
+
+			return nil
+		})
+
+		cancel()
+	}
+
+	return fixes, diagnostics
+}
+
+// vectorMatchingFixes are the quick fixes of vectorMatchingChecks
+func (s *server) vectorMatchingFixes(doc *cache.DocumentHandle) []quickFix {
+	fixes, _ := s.vectorMatchingChecks(doc)
+	return fixes
+}
+
+// vectorMatchingDiagnostics are the diagnostics of vectorMatchingChecks that have no quick fix
+func (s *server) vectorMatchingDiagnostics(doc *cache.DocumentHandle) []protocol.Diagnostic {
+	_, diagnostics := s.vectorMatchingChecks(doc)
+	return diagnostics
+}
+
+// unmatchedLabels returns the labels used for matching that exist on only one side of a binary operation.
+// Without on(...), all labels but the ignored ones and the metric name are matched.
+func unmatchedLabels(matching *promql.VectorMatching, lhs labelSet, rhs labelSet) (lhsOnly []string, rhsOnly []string) {
+	matched := make(map[string]bool)
+
+	switch {
+	case matching != nil && matching.On:
+		for _, name := range matching.MatchingLabels {
+			matched[name] = true
+		}
+	default:
+		for name := range lhs.Names {
+			matched[name] = true
+		}
+
+		for name := range rhs.Names {
+			matched[name] = true
+		}
+
+		if matching != nil {
+			for _, name := range matching.MatchingLabels {
+				delete(matched, name)
+			}
+		}
+
+		delete(matched, labels.MetricName)
+	}
+
+	for name := range matched {
+		switch {
+		case lhs.Names[name] && !rhs.Names[name]:
+			lhsOnly = append(lhsOnly, name)
+		case rhs.Names[name] && !lhs.Names[name]:
+			rhsOnly = append(rhsOnly, name)
+		}
+	}
+
+	sort.Strings(lhsOnly)
+	sort.Strings(rhsOnly)
+
+	return lhsOnly, rhsOnly
+}
+
+// commonLabels returns the sorted labels both sides of a binary operation have, except for the metric name
+func commonLabels(lhs labelSet, rhs labelSet) []string {
+	var ret []string
+
+	for name := range lhs.Names {
+		if rhs.Names[name] && name != labels.MetricName {
+			ret = append(ret, name)
+		}
+	}
+
+	sort.Strings(ret)
+
+	return ret
+}
+
+// matchingClause finds the operator of a binary expression and the on(...) or ignoring(...) clause after it.
+// Without such a clause, the returned clause is empty and positioned where one would be inserted.
+func matchingClause(q *cache.CompiledQuery, n *promql.BinaryExpr) (op tokenEdit, clause tokenEdit, ok bool) {
+	start, end := n.LHS.PositionRange().End, n.RHS.PositionRange().Start
+	if start > end || int(end) > len(q.Content) {
+		return tokenEdit{}, tokenEdit{}, false
+	}
+
+	pos := func(p promql.Pos) token.Pos {
+		return q.Pos + token.Pos(start+p)
+	}
+
+	l := promql.Lex(q.Content[start:end])
+
+	foundOp, inClause := false, false
+
+	for {
+		var item promql.Item
+
+		l.NextItem(&item)
+
+		itemEnd := item.Pos + promql.Pos(len(item.Val))
+
+		switch {
+		case item.Typ == promql.EOF || item.Typ == promql.ERROR:
+			return op, clause, foundOp
+		case !foundOp:
+			if item.Typ == n.Op {
+				foundOp = true
+				op = tokenEdit{Pos: pos(item.Pos), End: pos(itemEnd)}
+				clause = tokenEdit{Pos: op.End, End: op.End}
+			}
+		case item.Typ == promql.BOOL:
+			clause = tokenEdit{Pos: pos(itemEnd), End: pos(itemEnd)}
+		case item.Typ == promql.ON || item.Typ == promql.IGNORING:
+			inClause = true
+			clause.Pos = pos(item.Pos)
+		case inClause && item.Typ == promql.RIGHT_PAREN:
+			clause.End = pos(itemEnd)
+			return op, clause, true
+		}
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestVectorMatching checks that binary operations between operands with different label sets are reported
+func TestVectorMatching(*testing.T) {
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.URL.Path != "/api/v1/series" {
+			fmt.Fprint(w, `{"status":"success","data":{}}`)
+			return
+		}
+
+		switch r.FormValue("match[]") {
+		case "errors_total":
+			fmt.Fprint(w, `{"status":"success","data":[{"__name__":"errors_total","job":"api","instance":"a","code":"500"}]}`)
+		case "requests_total":
+			fmt.Fprint(w, `{"status":"success","data":[{"__name__":"requests_total","job":"api","instance":"a"}]}`)
+		default:
+			fmt.Fprint(w, `{"status":"success","data":[]}`)
+		}
+	}))
+	defer prom.Close()
+
+	h, err := NewHeadlessServer(context.Background(), &Config{PrometheusURL: prom.URL}, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	tests := []struct {
+		query   string
+		message string
+		fixed   string
+	}{
+		{`errors_total / requests_total`, "code only on the left-hand side", `errors_total / on(instance, job) requests_total`},
+		{`errors_total > bool on(code) requests_total`, "code only on the left-hand side", `errors_total > bool on(instance, job) requests_total`},
+		{`requests_total / ignoring (job) (errors_total)`, "code only on the right-hand side", `requests_total / on(instance, job) (errors_total)`},
+		{`sum(errors_total) / requests_total`, "no labels in common", ""},
+		{`errors_total / ignoring(code) requests_total`, "", ""},
+		{`sum by (job) (errors_total) / sum by (job) (requests_total)`, "", ""},
+		{`errors_total or requests_total`, "", ""},
+		{`unknown_total / requests_total`, "", ""},
+	}
+
+	for i, test := range tests {
+		uri := fmt.Sprintf("vectormatching_%d.promql", i)
+
+		report, err := h.AnalyzeDocument(uri, "promql", test.query)
+		if err != nil {
+			panic(err)
+		}
+
+		message := ""
+
+		for _, d := range report.Diagnostics {
+			if d.Code == fixVectorMatching {
+				message = d.Message
+			}
+		}
+
+		if test.message == "" && message != "" || !strings.Contains(message, test.message) {
+			panic(fmt.Sprintf("Expected %q for %q, got %q", test.message, test.query, message))
+		}
+
+		doc, err := h.server.cache.GetDocument(uri)
+		if err != nil {
+			panic(err)
+		}
+
+		base, err := doc.OffsetToPos(0)
+		if err != nil {
+			panic(err)
+		}
+
+		fixed := ""
+
+		for _, fix := range h.server.vectorMatchingFixes(doc) {
+			edit := fix.Edits[0]
+			fixed = test.query[:edit.Pos-base] + edit.NewText + test.query[edit.End-base:]
+		}
+
+		if fixed != test.fixed {
+			panic(fmt.Sprintf("Expected %q to be fixed as %q, got %q", test.query, test.fixed, fixed))
+		}
+	}
+}
@@ -13,22 +13,30 @@
 
 package langserver
 
-//nolint
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
 
 	"github.com/gorilla/websocket"
-	//"github.com/prometheus-community/promql-langserver/vendored/go-tools/jsonrpc2"
 )
 
+// maxWebSocketMessageSize limits the size of the messages clients can send over a WebSocket,
+// it leaves room for a document of the maximum size and its JSON encoding
+const maxWebSocketMessageSize = 8 << 20
+
 // Implements the jsonrpc2.Stream interface
 type wsConn struct {
 	*websocket.Conn
+	// writeMu serializes writes, since websocket connections support only one concurrent writer
+	writeMu sync.Mutex
 }
 
-func (c wsConn) Read(ctx context.Context) ([]byte, int64, error) {
+func (c *wsConn) Read(ctx context.Context) ([]byte, int64, error) {
 	// Returning an error on an expired context is important here.
 	// If that isn't done, the server won't stop after the connection
 	// has been closed.
@@ -50,13 +58,16 @@ func (c wsConn) Read(ctx context.Context) ([]byte, int64, error) {
 	return ret, int64(len(ret)), nil
 }
 
-func (c wsConn) Write(ctx context.Context, msg []byte) (int64, error) {
+func (c *wsConn) Write(ctx context.Context, msg []byte) (int64, error) {
 	select {
 	case <-ctx.Done():
 		return 0, ctx.Err()
 	default:
 	}
 
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
 	err := c.WriteMessage(websocket.TextMessage, msg)
 	if err != nil {
 		return 0, err
@@ -65,10 +76,59 @@ func (c wsConn) Write(ctx context.Context, msg []byte) (int64, error) {
 	return int64(len(msg)), nil
 }
 
-func WebSocketHandler(addr string) (func(http.ResponseWriter, *http.Request), error) {
+// parseOrigins normalizes the allowed_origins option to scheme://host, "*" allows all origins
+func parseOrigins(origins []string) (map[string]bool, error) {
+	ret := make(map[string]bool)
+
+	for _, origin := range origins {
+		if origin == "*" {
+			ret[origin] = true
+			continue
+		}
+
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("invalid allowed origin %q, expected e.g. https://grafana.example.com", origin)
+		}
+
+		ret[strings.ToLower(u.Scheme+"://"+u.Host)] = true
+	}
+
+	return ret, nil
+}
+
+// checkOrigin allows requests without Origin header, which don't come from browsers, requests from
+// the origin the server is served on and requests from the allowed origins. Other websites must
+// not be able to use the language server of a user from the browser.
+func checkOrigin(allowed map[string]bool) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" || allowed["*"] {
+			return true
+		}
+
+		u, err := url.Parse(origin)
+		if err != nil {
+			return false
+		}
+
+		return strings.EqualFold(u.Host, r.Host) || allowed[strings.ToLower(u.Scheme+"://"+u.Host)]
+	}
+}
+
+// WebSocketHandler returns a handler that serves a language server over WebSocket, e.g. for browser based
+// editors. Every connection gets its own language server instance, cross-origin connections are only
+// accepted from the origins of the allowed_origins option.
+func WebSocketHandler(ctx context.Context, config *Config) (func(http.ResponseWriter, *http.Request), error) {
+	allowed, err := parseOrigins(config.AllowedOrigins)
+	if err != nil {
+		return nil, err
+	}
+
 	upgrader := websocket.Upgrader{
 		ReadBufferSize:  2048,
 		WriteBufferSize: 2048,
+		CheckOrigin:     checkOrigin(allowed),
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -79,8 +139,12 @@ func WebSocketHandler(addr string) (func(http.ResponseWriter, *http.Request), er
 			// by the upgraded call.
 			return
 		}
+		defer ws.Close()
+
+		ws.SetReadLimit(maxWebSocketMessageSize)
 
-		ctx, cancel := context.WithCancel(context.TODO())
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
 
 		ch := func(_ int, _ string) error {
 			cancel()
@@ -91,7 +155,7 @@ func WebSocketHandler(addr string) (func(http.ResponseWriter, *http.Request), er
 
 		var s Server
 
-		_, s = ServerFromStream(ctx, wsConn{ws}, &Config{})
+		_, s = ServerFromStream(ctx, &wsConn{Conn: ws}, config)
 
 		if err := s.Run(); err != nil {
 			// If the client disconnects, the above will fail
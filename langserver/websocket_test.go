@@ -0,0 +1,78 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// TestWebSocket checks that the language server answers requests over WebSocket and that
+// connections from other origins are only accepted if they are allowed
+func TestWebSocket(*testing.T) {
+	handler, err := WebSocketHandler(context.Background(), &Config{AllowedOrigins: []string{"https://grafana.example.com"}})
+	if err != nil {
+		panic(err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(handler))
+	defer srv.Close()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	dial := func(origin string) (*websocket.Conn, error) {
+		header := http.Header{}
+		if origin != "" {
+			header.Set("Origin", origin)
+		}
+
+		conn, _, err := websocket.DefaultDialer.Dial(url, header)
+
+		return conn, err
+	}
+
+	if _, err := dial("https://evil.example.com"); err == nil {
+		panic("expected connections from other origins to be rejected")
+	}
+
+	conn, err := dial("https://grafana.example.com")
+	if err != nil {
+		panic(err)
+	}
+	defer conn.Close()
+
+	if err := conn.WriteMessage(websocket.TextMessage,
+		[]byte(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"capabilities":{}}}`)); err != nil {
+		panic(err)
+	}
+
+	_, response, err := conn.ReadMessage()
+	if err != nil {
+		panic(err)
+	}
+
+	if !strings.Contains(string(response), `"id":1`) || !strings.Contains(string(response), "capabilities") {
+		panic(fmt.Sprintf("expected the result of initialize, got %s", response))
+	}
+
+	if _, err := WebSocketHandler(context.Background(), &Config{AllowedOrigins: []string{"grafana.example.com"}}); err == nil {
+		panic("expected origins without scheme to be rejected")
+	}
+}
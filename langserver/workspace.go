@@ -0,0 +1,477 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus-community/promql-langserver/langserver/cache"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// maxWorkspaceFileSize is the size of the largest file that is indexed, larger files are most likely not rule files
+const maxWorkspaceFileSize = 1000000
+
+// workspaceIndex keeps track of the rule and exposition files found in the workspace folders of the client.
+// Documents opened by the client take precedence over the files on disk.
+type workspaceIndex struct {
+	// folders are the paths of the workspace folders
+	folders []string
+	// remote are the URIs of the workspace folders that aren't on the file system, e.g.
+	// vscode-vfs://github/org/repo. They can't be indexed, only the documents opened in them are analyzed.
+	remote []protocol.DocumentURI
+	// indexed maps the paths of the files loaded from disk to their URIs in the cache
+	indexed map[string]protocol.DocumentURI
+	// open maps the paths of the documents opened by the client to their URIs
+	open map[string]protocol.DocumentURI
+	mu   sync.Mutex
+}
+
+func newWorkspaceIndex(params *protocol.ParamInitialize) *workspaceIndex {
+	ret := &workspaceIndex{
+		indexed: make(map[string]protocol.DocumentURI),
+		open:    make(map[string]protocol.DocumentURI),
+	}
+
+	folders := params.WorkspaceFolders
+	if len(folders) == 0 && params.RootURI != "" {
+		folders = []protocol.WorkspaceFolder{{URI: string(params.RootURI)}}
+	}
+
+	for _, folder := range folders {
+		ret.addFolder(protocol.DocumentURI(folder.URI))
+	}
+
+	return ret
+}
+
+// addFolder adds a workspace folder and returns its path, or "" if it isn't on the file system
+func (w *workspaceIndex) addFolder(uri protocol.DocumentURI) string {
+	if path := uriPath(uri); path != "" {
+		w.folders = append(w.folders, path)
+		return path
+	}
+
+	if isRemoteURI(uri) {
+		w.remote = append(w.remote, uri)
+	}
+
+	return ""
+}
+
+// isRemoteURI checks whether a URI has a scheme other than file, e.g. vscode-vfs or untitled
+func isRemoteURI(uri protocol.DocumentURI) bool {
+	u, err := url.Parse(string(uri))
+	return err == nil && u.Scheme != "" && u.Scheme != "file"
+}
+
+// remotePath returns the slash separated path of a document inside a remote workspace folder,
+// or "" if it isn't inside it. Scheme and authority must be the same, e.g. the repository of
+// vscode-vfs://github/org/repo.
+func remotePath(folder protocol.DocumentURI, uri protocol.DocumentURI) string {
+	f, err := url.Parse(string(folder))
+	if err != nil {
+		return ""
+	}
+
+	u, err := url.Parse(string(uri))
+	if err != nil || u.Scheme != f.Scheme || u.Host != f.Host {
+		return ""
+	}
+
+	prefix := strings.TrimSuffix(f.Path, "/") + "/"
+	if !strings.HasPrefix(u.Path, prefix) {
+		return ""
+	}
+
+	return u.Path[len(prefix):]
+}
+
+// uriPath returns the cleaned file system path of a file URI, or "" if it is no file URI
+func uriPath(uri protocol.DocumentURI) string {
+	u, err := url.Parse(string(uri))
+	if err != nil || u.Scheme != "file" {
+		return ""
+	}
+
+	path := u.Path
+	// Windows paths look like /C:/...
+	if len(path) > 2 && path[0] == '/' && path[2] == ':' {
+		path = path[1:]
+	}
+
+	return filepath.Clean(filepath.FromSlash(path))
+}
+
+// pathURI returns the file URI of a path
+func pathURI(path string) protocol.DocumentURI {
+	path = filepath.ToSlash(path)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	return protocol.DocumentURI((&url.URL{Scheme: "file", Path: path}).String())
+}
+
+// isRuleFileCandidate checks whether a file could be a rule file judging by its name
+func isRuleFileCandidate(path string) bool {
+	switch filepath.Ext(path) {
+	case ".yml", ".yaml":
+		return true
+	default:
+		return false
+	}
+}
+
+// isExpositionFileCandidate checks whether a file might be a Prometheus text format exposition file, whose metrics
+// are used for completion and hover
+func isExpositionFileCandidate(path string) bool {
+	return filepath.Ext(path) == ".prom"
+}
+
+// isIndexCandidate checks whether a file in a workspace folder might be indexed
+func isIndexCandidate(path string) bool {
+	return isRuleFileCandidate(path) || isExpositionFileCandidate(path)
+}
+
+// skipDirectory checks whether a directory is left out when indexing, e.g. version control metadata
+func skipDirectory(name string) bool {
+	return strings.HasPrefix(name, ".") || name == "node_modules" || name == "vendor"
+}
+
+// inWorkspace checks whether a path is inside one of the workspace folders. The caller must hold the lock.
+func (w *workspaceIndex) inWorkspace(path string) bool {
+	for _, folder := range w.folders {
+		if rel, err := filepath.Rel(folder, path); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// indexWorkspace loads the rule files of all workspace folders into the cache.
+// The folders are copied, since the client can change them while indexing.
+func (s *server) indexWorkspace() {
+	w := s.workspace
+
+	w.mu.Lock()
+	folders := append([]string{}, w.folders...)
+	w.mu.Unlock()
+
+	for _, folder := range folders {
+		s.indexFolder(folder)
+	}
+}
+
+// indexFolder loads the rule files of a directory and its subdirectories into the cache
+func (s *server) indexFolder(folder string) {
+	// nolint: errcheck
+	filepath.Walk(folder, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+
+		if info.IsDir() {
+			if path != folder && skipDirectory(info.Name()) {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if !isIndexCandidate(path) || info.Size() > maxWorkspaceFileSize {
+			return nil
+		}
+
+		if err := s.indexFile(path); err != nil {
+			// nolint: errcheck
+			s.client.LogMessage(s.lifetime, &protocol.LogMessageParams{
+				Type:    protocol.Info,
+				Message: fmt.Sprintf("Failed to index %s: %v", path, err),
+			})
+		}
+
+		return s.lifetime.Err()
+	})
+}
+
+// indexFile loads a file from disk into the cache if it is a rule or exposition file, replacing the previous version.
+// Files that are opened by the client are skipped. The file is read and compiled without holding the lock,
+// so indexing a large workspace doesn't block opening documents.
+func (s *server) indexFile(path string) error {
+	defer s.startAnalysis()()
+
+	w := s.workspace
+
+	w.mu.Lock()
+	_, open := w.open[path]
+	w.mu.Unlock()
+
+	if open {
+		return nil
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		w.mu.Lock()
+		w.removeIndexed(s, path)
+		w.mu.Unlock()
+
+		return err
+	}
+
+	w.mu.Lock()
+
+	// The client might have opened the file while it was read
+	if _, ok := w.open[path]; ok {
+		w.mu.Unlock()
+		return nil
+	}
+
+	w.removeIndexed(s, path)
+
+	exposition := isExpositionFileCandidate(path)
+
+	// Cheap check to avoid compiling all other yaml files
+	if !exposition && !bytes.Contains(content, []byte("groups:")) {
+		w.mu.Unlock()
+		return nil
+	}
+
+	uri := pathURI(path)
+
+	languageID := "yaml"
+	if exposition {
+		languageID = "prometheus-exposition"
+	}
+
+	doc, err := s.cache.AddDocument(s.lifetime, &protocol.TextDocumentItem{
+		URI:        uri,
+		LanguageID: languageID,
+		Text:       string(content),
+	})
+	if err == nil {
+		w.indexed[path] = uri
+	}
+
+	w.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	found, err := hasIndexedContent(doc, exposition)
+	if err != nil && doc.GetContext().Err() != nil {
+		// Replaced by a newer version or opened by the client in the meantime
+		return nil
+	}
+
+	if err != nil || !found {
+		w.mu.Lock()
+
+		if doc.GetContext().Err() == nil {
+			w.removeIndexed(s, path)
+		}
+
+		w.mu.Unlock()
+	}
+
+	return err
+}
+
+// hasIndexedContent checks whether a file loaded from disk is kept in the cache:
+// rule files need rule groups and exposition files metric families
+func hasIndexedContent(doc *cache.DocumentHandle, exposition bool) (bool, error) {
+	if exposition {
+		families, err := doc.GetMetricFamilies()
+		return len(families) > 0, err
+	}
+
+	groups, err := doc.GetRuleGroups()
+
+	return len(groups) > 0, err
+}
+
+// ruleFiles returns the URIs of the rule files in the workspace folders, including the opened ones
+func (w *workspaceIndex) ruleFiles() []protocol.DocumentURI {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var ret []protocol.DocumentURI
+
+	for path, uri := range w.indexed {
+		if isRuleFileCandidate(path) {
+			ret = append(ret, uri)
+		}
+	}
+
+	for path, uri := range w.open {
+		if w.inWorkspace(path) && isRuleFileCandidate(path) {
+			ret = append(ret, uri)
+		}
+	}
+
+	sort.Slice(ret, func(i, j int) bool { return ret[i] < ret[j] })
+
+	return ret
+}
+
+// removeIndexed removes the version of a file loaded from disk from the cache. The caller must hold the lock.
+func (w *workspaceIndex) removeIndexed(s *server, path string) {
+	if uri, ok := w.indexed[path]; ok {
+		// nolint: errcheck
+		s.cache.RemoveDocument(uri)
+		delete(w.indexed, path)
+	}
+}
+
+// openWorkspaceFile is called before a document is opened by the client, it replaces the version loaded from disk
+func (s *server) openWorkspaceFile(uri protocol.DocumentURI) {
+	path := uriPath(uri)
+	if path == "" {
+		return
+	}
+
+	w := s.workspace
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.open[path] = uri
+	w.removeIndexed(s, path)
+}
+
+// closeWorkspaceFile is called after a document has been closed by the client, it loads the version on disk again
+func (s *server) closeWorkspaceFile(uri protocol.DocumentURI) {
+	path := uriPath(uri)
+	if path == "" {
+		return
+	}
+
+	w := s.workspace
+
+	w.mu.Lock()
+	delete(w.open, path)
+	reindex := w.inWorkspace(path) && isIndexCandidate(path) && !s.getConfig().DemoMode
+	w.mu.Unlock()
+
+	if reindex {
+		go s.indexFile(path) // nolint: errcheck
+	}
+}
+
+// DidChangeWatchedFiles receives a notification from the client about changes of files on disk
+// required by the protocol.Server interface
+func (s *server) DidChangeWatchedFiles(_ context.Context, params *protocol.DidChangeWatchedFilesParams) error {
+	if s.getConfig().DemoMode {
+		return nil
+	}
+
+	for _, change := range params.Changes {
+		path := uriPath(change.URI)
+		if path == "" || !isIndexCandidate(path) {
+			continue
+		}
+
+		s.workspace.mu.Lock()
+		inWorkspace := s.workspace.inWorkspace(path)
+		s.workspace.mu.Unlock()
+
+		if !inWorkspace {
+			continue
+		}
+
+		switch change.Type {
+		case protocol.Created, protocol.Changed:
+			if err := s.indexFile(path); err != nil {
+				// nolint: errcheck
+				s.client.LogMessage(s.lifetime, &protocol.LogMessageParams{
+					Type:    protocol.Info,
+					Message: fmt.Sprintf("Failed to index %s: %v", path, err),
+				})
+			}
+		case protocol.Deleted:
+			s.workspace.mu.Lock()
+			s.workspace.removeIndexed(s, path)
+			s.workspace.mu.Unlock()
+		}
+	}
+
+	return nil
+}
+
+// DidChangeWorkspaceFolders receives a notification from the client about added and removed workspace folders
+// required by the protocol.Server interface
+func (s *server) DidChangeWorkspaceFolders(_ context.Context, params *protocol.DidChangeWorkspaceFoldersParams) error {
+	w := s.workspace
+
+	w.mu.Lock()
+
+	for _, folder := range params.Event.Removed {
+		for i := 0; i < len(w.remote); i++ {
+			if w.remote[i] == protocol.DocumentURI(folder.URI) {
+				w.remote = append(w.remote[:i], w.remote[i+1:]...)
+				i--
+			}
+		}
+
+		removed := uriPath(protocol.DocumentURI(folder.URI))
+		if removed == "" {
+			continue
+		}
+
+		for i := 0; i < len(w.folders); i++ {
+			if w.folders[i] == removed {
+				w.folders = append(w.folders[:i], w.folders[i+1:]...)
+				i--
+			}
+		}
+
+		for path := range w.indexed {
+			if !w.inWorkspace(path) {
+				w.removeIndexed(s, path)
+			}
+		}
+	}
+
+	var added []string
+
+	for _, folder := range params.Event.Added {
+		if path := w.addFolder(protocol.DocumentURI(folder.URI)); path != "" {
+			added = append(added, path)
+		}
+	}
+
+	w.mu.Unlock()
+
+	if !s.getConfig().DemoMode {
+		go func() {
+			for _, folder := range added {
+				s.indexFolder(folder)
+			}
+		}()
+	}
+
+	return nil
+}
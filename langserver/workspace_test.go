@@ -0,0 +1,252 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// TestWorkspaceIndex checks that rule files on disk are indexed and replaced by documents opened by the client
+func TestWorkspaceIndex(*testing.T) {
+	dir, err := ioutil.TempDir("", "promql-langserver")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"rules/api.rules.yml": "groups:\n- name: api\n  rules:\n  - record: job:errors:rate5m\n    expr: rate(errors_total[5m])\n",
+		"deployment.yaml":     "kind: Deployment\n",
+		".git/rules.yml":      "groups:\n- name: hidden\n  rules:\n  - record: hidden\n    expr: up\n",
+	}
+
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			panic(err)
+		}
+
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			panic(err)
+		}
+	}
+
+	params := &protocol.ParamInitialize{}
+	params.WorkspaceFolders = []protocol.WorkspaceFolder{{URI: string(pathURI(dir))}}
+
+	h, err := newHeadlessServer(context.Background(), &Config{}, nil, params)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	if docs := h.server.cache.GetDocuments(); len(docs) != 1 {
+		panic(fmt.Sprintf("expected exactly one indexed rule file, got %d", len(docs)))
+	}
+
+	if refs := h.server.cache.GetMetricIndex()["job:errors:rate5m"]; len(refs) != 1 {
+		panic(fmt.Sprintf("expected the recording rule on disk to be indexed, got %v", refs))
+	}
+
+	rulesPath := filepath.Join(dir, "rules", "api.rules.yml")
+
+	// Opening the file replaces the version on disk
+	if err := h.server.DidOpen(context.Background(), &protocol.DidOpenTextDocumentParams{
+		TextDocument: protocol.TextDocumentItem{URI: pathURI(rulesPath), LanguageID: "yaml", Text: files["rules/api.rules.yml"]},
+	}); err != nil {
+		panic(err)
+	}
+
+	if docs := h.server.cache.GetDocuments(); len(docs) != 1 {
+		panic(fmt.Sprintf("expected the opened document to replace the indexed one, got %d documents", len(docs)))
+	}
+
+	if err := h.server.cache.RemoveDocument(pathURI(rulesPath)); err != nil {
+		panic(err)
+	}
+
+	delete(h.server.workspace.open, rulesPath)
+
+	if err := h.server.DidChangeWatchedFiles(context.Background(), &protocol.DidChangeWatchedFilesParams{
+		Changes: []protocol.FileEvent{{URI: pathURI(rulesPath), Type: protocol.Created}},
+	}); err != nil {
+		panic(err)
+	}
+
+	if docs := h.server.cache.GetDocuments(); len(docs) != 1 {
+		panic(fmt.Sprintf("expected the created file to be indexed, got %d documents", len(docs)))
+	}
+
+	if err := h.server.DidChangeWatchedFiles(context.Background(), &protocol.DidChangeWatchedFilesParams{
+		Changes: []protocol.FileEvent{{URI: pathURI(rulesPath), Type: protocol.Deleted}},
+	}); err != nil {
+		panic(err)
+	}
+
+	if docs := h.server.cache.GetDocuments(); len(docs) != 0 {
+		panic(fmt.Sprintf("expected the deleted file to be removed, got %d documents", len(docs)))
+	}
+}
+
+// TestRemoteDocuments checks that documents that aren't on the file system are analyzed, even if the
+// editor doesn't know their language
+func TestRemoteDocuments(*testing.T) {
+	h, err := NewHeadlessServer(context.Background(), &Config{}, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	for _, test := range []struct {
+		uri        string
+		languageID string
+		content    string
+	}{
+		{"vscode-vfs://github/org/repo/rules/api.yml", "plaintext", "groups:\n- name: api\n  rules:\n  - record: a\n    expr: sum(\n"},
+		{"git:/rules/api.yml?%7B%22ref%22%3A%22HEAD%22%7D", "plaintext", "groups:\n- name: api\n  rules:\n  - record: a\n    expr: sum(\n"},
+		{"untitled:Untitled-1", "promql", "sum("},
+	} {
+		report, err := h.AnalyzeDocument(test.uri, test.languageID, test.content)
+		if err != nil {
+			panic(err)
+		}
+
+		if len(report.Diagnostics) == 0 {
+			panic(fmt.Sprintf("expected the syntax error in %s to be reported", test.uri))
+		}
+	}
+}
+
+// TestConcurrentWorkspaceChanges changes files, folders and open documents at the same time, for the race detector,
+// and checks that a file ends up in the cache once
+func TestConcurrentWorkspaceChanges(*testing.T) { // nolint: funlen
+	dir, err := ioutil.TempDir("", "promql-langserver")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rulesPath := filepath.Join(dir, "api.rules.yml")
+	rules := "groups:\n- name: api\n  rules:\n  - record: job:errors:rate5m\n    expr: rate(errors_total[5m])\n"
+
+	if err := ioutil.WriteFile(rulesPath, []byte(rules), 0600); err != nil {
+		panic(err)
+	}
+
+	params := &protocol.ParamInitialize{}
+	params.WorkspaceFolders = []protocol.WorkspaceFolder{{URI: string(pathURI(dir))}}
+
+	h, err := newHeadlessServer(context.Background(), &Config{}, nil, params)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	other := protocol.WorkspaceFolder{URI: string(pathURI(filepath.Join(dir, "other")))}
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+
+		go func() {
+			defer wg.Done()
+
+			// nolint: errcheck
+			h.server.DidChangeWatchedFiles(context.Background(), &protocol.DidChangeWatchedFilesParams{
+				Changes: []protocol.FileEvent{{URI: pathURI(rulesPath), Type: protocol.Changed}},
+			})
+		}()
+
+		go func() {
+			defer wg.Done()
+
+			params := &protocol.DidChangeWorkspaceFoldersParams{}
+			params.Event.Added = []protocol.WorkspaceFolder{other}
+
+			// nolint: errcheck
+			h.server.DidChangeWorkspaceFolders(context.Background(), params)
+
+			params.Event.Added, params.Event.Removed = nil, []protocol.WorkspaceFolder{other}
+
+			// nolint: errcheck
+			h.server.DidChangeWorkspaceFolders(context.Background(), params)
+		}()
+	}
+
+	wg.Wait()
+
+	if docs := h.server.cache.GetDocuments(); len(docs) != 1 {
+		panic(fmt.Sprintf("expected the changed file to be indexed once, got %d documents", len(docs)))
+	}
+
+	if files := h.server.workspace.ruleFiles(); len(files) != 1 || files[0] != pathURI(rulesPath) {
+		panic(fmt.Sprintf("expected the changed file to be indexed, got %v", files))
+	}
+}
+
+// TestWorkspaceExpositionFiles checks that the metrics of exposition files in the workspace folders are completed
+// without opening the files
+func TestWorkspaceExpositionFiles(*testing.T) {
+	dir, err := ioutil.TempDir("", "promql-langserver")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	exposition := "# HELP app_requests_total Requests handled by the app.\n# TYPE app_requests_total counter\napp_requests_total 3\n"
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "app.prom"), []byte(exposition), 0600); err != nil {
+		panic(err)
+	}
+
+	params := &protocol.ParamInitialize{}
+	params.WorkspaceFolders = []protocol.WorkspaceFolder{{URI: string(pathURI(dir))}}
+
+	h, err := newHeadlessServer(context.Background(), &Config{}, nil, params)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	if files := h.server.workspace.ruleFiles(); len(files) != 0 {
+		panic(fmt.Sprintf("expected the exposition file not to be a rule file, got %v", files))
+	}
+
+	if err := h.AddDocument("query.promql", "promql", "app_req"); err != nil {
+		panic(err)
+	}
+
+	completions, err := h.Completion("query.promql", len("app_req"))
+	if err != nil {
+		panic(err)
+	}
+
+	for _, item := range completions.Items {
+		if item.Label == "app_requests_total" && item.Detail == "Requests handled by the app." {
+			return
+		}
+	}
+
+	panic(fmt.Sprintf("expected the metric of the exposition file to be completed, got %v", completions.Items))
+}
@@ -0,0 +1,133 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package rest provides a REST API to the analyzers of the language server
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus-community/promql-langserver/langserver"
+)
+
+// maxRequestSize limits the size of request bodies
+const maxRequestSize = 32 << 20
+
+// maxDemoRequestSize limits the size of request bodies in demo mode, where requests come from the public
+const maxDemoRequestSize = 1 << 20
+
+// reconnectInterval is how often connecting to the Prometheus server is retried if it failed
+const reconnectInterval = 30 * time.Second
+
+// api serves the REST API. Every request is handled by a separate headless
+// language server, so documents of different requests don't interfere.
+type api struct {
+	ctx    context.Context
+	config *langserver.Config
+
+	// base connects to Prometheus once, the servers of the requests are sessions sharing its connection
+	base      *langserver.HeadlessServer
+	connected time.Time
+	baseMu    sync.Mutex
+}
+
+// errorResponse is returned to the client if a request fails
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// CreateHandler creates a http.Handler serving the REST API
+func CreateHandler(ctx context.Context, config *langserver.Config) http.Handler {
+	a := &api{
+		ctx:    ctx,
+		config: config,
+	}
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/validate/rules", a.handleValidateRules)
+	mux.HandleFunc("/metadata/metrics", a.handleMetricNames)
+	mux.HandleFunc("/metadata/label_values", a.handleLabelValues)
+	mux.HandleFunc("/metadata/functions", a.handleFunctions)
+	mux.HandleFunc("/diagnostics", a.handleDiagnostics)
+	mux.HandleFunc("/completion", a.handleCompletion)
+	mux.HandleFunc("/hover", a.handleHover)
+	mux.HandleFunc("/format", a.handleFormat)
+	mux.HandleFunc("/import", a.handleImport)
+
+	if config.RESTUI {
+		mux.HandleFunc("/ui", handleUI)
+	}
+
+	return compress(mux)
+}
+
+// newServer creates a headless language server for a single request
+func (a *api) newServer(r *http.Request) (langserver.HeadlessServer, error) {
+	base, err := a.getBase()
+	if err != nil {
+		return langserver.HeadlessServer{}, err
+	}
+
+	return base.NewSession(r.Context())
+}
+
+// getBase returns the server holding the connection to Prometheus. It is created on the first request,
+// and created again if connecting to Prometheus failed and reconnectInterval has passed since.
+func (a *api) getBase() (*langserver.HeadlessServer, error) {
+	a.baseMu.Lock()
+	defer a.baseMu.Unlock()
+
+	if a.base != nil && (a.base.Connected() || a.config.PrometheusURL == "" || time.Since(a.connected) < reconnectInterval) {
+		return a.base, nil
+	}
+
+	base, err := langserver.NewHeadlessServer(a.ctx, a.config, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if a.base != nil {
+		a.base.Close()
+	}
+
+	a.base, a.connected = &base, time.Now()
+
+	return a.base, nil
+}
+
+// maxRequestSize returns the maximum size of request bodies
+func (a *api) maxRequestSize() int64 {
+	if a.config.DemoMode {
+		return maxDemoRequestSize
+	}
+
+	return maxRequestSize
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	// nolint: errcheck
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, format string, args ...interface{}) {
+	writeJSON(w, status, &errorResponse{Error: fmt.Sprintf(format, args...)})
+}
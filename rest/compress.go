@@ -0,0 +1,153 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rest
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// supportedEncodings are the content codings responses can be compressed with, in order of preference
+var supportedEncodings = []string{"gzip", "deflate"} // nolint: gochecknoglobals
+
+// negotiateEncoding picks the supported content coding with the highest weight in an Accept-Encoding header.
+// It returns an empty string if the response should not be compressed.
+func negotiateEncoding(header string) string {
+	weights := make(map[string]float64)
+
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+
+		coding := strings.ToLower(strings.TrimSpace(fields[0]))
+		if coding == "" {
+			continue
+		}
+
+		weight := 1.0
+
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					weight = q
+				}
+			}
+		}
+
+		weights[coding] = weight
+	}
+
+	best, bestWeight := "", 0.0
+
+	for _, coding := range supportedEncodings {
+		weight, ok := weights[coding]
+		if !ok {
+			weight, ok = weights["*"]
+		}
+
+		if ok && weight > bestWeight {
+			best, bestWeight = coding, weight
+		}
+	}
+
+	return best
+}
+
+// compressedResponseWriter compresses the body of a response. The compression only starts
+// once the status is known, as responses to HEAD requests and some statuses have no body.
+type compressedResponseWriter struct {
+	http.ResponseWriter
+	encoding string
+	method   string
+	// w compresses the body, it is nil as long as no body has been started
+	w           io.WriteCloser
+	wroteHeader bool
+}
+
+// hasBody returns whether the response to a request with the given method and status has a body
+func hasBody(method string, status int) bool {
+	return method != http.MethodHead && status >= http.StatusOK &&
+		status != http.StatusNoContent && status != http.StatusNotModified
+}
+
+// WriteHeader is required by the http.ResponseWriter interface
+func (c *compressedResponseWriter) WriteHeader(status int) {
+	if c.wroteHeader {
+		return
+	}
+
+	c.wroteHeader = true
+
+	if hasBody(c.method, status) {
+		// The length of the uncompressed body doesn't apply anymore
+		c.Header().Del("Content-Length")
+		c.Header().Set("Content-Encoding", c.encoding)
+
+		if c.encoding == "gzip" {
+			c.w = gzip.NewWriter(c.ResponseWriter)
+		} else {
+			// The deflate content coding is the zlib format, not raw deflate
+			c.w = zlib.NewWriter(c.ResponseWriter)
+		}
+	}
+
+	c.ResponseWriter.WriteHeader(status)
+}
+
+// Write is required by the http.ResponseWriter interface
+func (c *compressedResponseWriter) Write(b []byte) (int, error) {
+	if !c.wroteHeader {
+		c.WriteHeader(http.StatusOK)
+	}
+
+	if c.w == nil {
+		return c.ResponseWriter.Write(b)
+	}
+
+	return c.w.Write(b)
+}
+
+// close flushes the compressed body
+func (c *compressedResponseWriter) close() error {
+	if c.w == nil {
+		return nil
+	}
+
+	return c.w.Close()
+}
+
+// compress compresses the responses of a handler with the content coding negotiated with the client
+func compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressedResponseWriter{ResponseWriter: w, encoding: encoding, method: r.Method}
+
+		// nolint: errcheck
+		defer cw.close()
+
+		next.ServeHTTP(cw, r)
+	})
+}
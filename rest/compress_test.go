@@ -0,0 +1,124 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rest
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestNegotiateEncoding checks that the content coding with the highest weight is picked
+func TestNegotiateEncoding(*testing.T) {
+	for header, expected := range map[string]string{
+		"":                      "",
+		"identity":              "",
+		"gzip":                  "gzip",
+		"GZip, Deflate":         "gzip",
+		"deflate":               "deflate",
+		"gzip;q=0":              "",
+		"gzip;q=0, deflate":     "deflate",
+		"gzip;q=0.5, deflate":   "deflate",
+		"*":                     "gzip",
+		"gzip;q=0, *":           "deflate",
+		"br, gzip ; q=0.8":      "gzip",
+		"deflate;q=0, gzip;q=0": "",
+	} {
+		if got := negotiateEncoding(header); got != expected {
+			panic(fmt.Sprintf("expected %q for Accept-Encoding %q, got %q", expected, header, got))
+		}
+	}
+}
+
+// TestCompress checks that bodies are compressed with the negotiated coding and that responses without body stay empty
+func TestCompress(*testing.T) {
+	const body = "the quick brown fox jumps over the lazy dog"
+
+	handler := compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", fmt.Sprint(len(body)))
+
+		switch r.URL.Path {
+		case "/no-content":
+			w.WriteHeader(http.StatusNoContent)
+		case "/not-modified":
+			w.WriteHeader(http.StatusNotModified)
+		default:
+			fmt.Fprint(w, body)
+		}
+	}))
+
+	serve := func(method string, target string, acceptEncoding string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, nil)
+		r.Header.Set("Accept-Encoding", acceptEncoding)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		return w
+	}
+
+	decoders := map[string]func(io.Reader) (io.Reader, error){
+		"gzip":    func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		"deflate": func(r io.Reader) (io.Reader, error) { return zlib.NewReader(r) },
+	}
+
+	for encoding, decode := range decoders {
+		w := serve(http.MethodGet, "/", encoding)
+
+		if w.Header().Get("Content-Encoding") != encoding || w.Header().Get("Content-Length") != "" {
+			panic(fmt.Sprintf("expected a %s encoded response without Content-Length, got %v", encoding, w.Header()))
+		}
+
+		r, err := decode(w.Body)
+		if err != nil {
+			panic(err)
+		}
+
+		decoded, err := ioutil.ReadAll(r)
+		if err != nil {
+			panic(err)
+		}
+
+		if string(decoded) != body {
+			panic(fmt.Sprintf("expected %q after decoding the %s response, got %q", body, encoding, decoded))
+		}
+	}
+
+	if w := serve(http.MethodGet, "/", ""); w.Header().Get("Content-Encoding") != "" || w.Body.String() != body {
+		panic(fmt.Sprintf("expected an uncompressed response, got %v %q", w.Header(), w.Body.String()))
+	}
+
+	for _, test := range []struct{ method, target string }{
+		{http.MethodHead, "/"},
+		{http.MethodGet, "/no-content"},
+		{http.MethodGet, "/not-modified"},
+	} {
+		// The body written for a HEAD request is discarded by the http.Server, but it must not be compressed
+		w := serve(test.method, test.target, "gzip")
+		if w.Header().Get("Content-Encoding") != "" || (w.Body.Len() != 0 && w.Body.String() != body) {
+			panic(fmt.Sprintf("expected %s %s to have neither Content-Encoding nor compressed body, got %v %q",
+				test.method, test.target, w.Header(), w.Body.String()))
+		}
+
+		if !strings.Contains(w.Header().Get("Vary"), "Accept-Encoding") {
+			panic("expected the response to vary by Accept-Encoding")
+		}
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/prometheus-community/promql-langserver/langserver"
+)
+
+// importRequest is the body of a request to /import
+type importRequest struct {
+	URL string `json:"url"`
+}
+
+// importResponse contains the formatted expressions of an imported URL
+type importResponse struct {
+	Queries []string `json:"queries"`
+}
+
+// handleImport extracts the expressions of a Prometheus graph or Grafana Explore URL
+func (a *api) handleImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, "method %s not allowed", r.Method)
+
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, a.maxRequestSize())
+
+	var request importRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request: %s", err.Error())
+		return
+	}
+
+	queries, err := langserver.ImportQueries(request.URL)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%s", err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, &importResponse{Queries: queries})
+}
@@ -0,0 +1,163 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus-community/promql-langserver/langserver"
+)
+
+// metricNamesResponse lists the metrics known to the Prometheus server
+type metricNamesResponse struct {
+	Metrics []string `json:"metrics"`
+}
+
+// labelValuesResponse lists the values of a label
+type labelValuesResponse struct {
+	Label  string   `json:"label"`
+	Values []string `json:"values"`
+}
+
+// functionsResponse lists the PromQL functions with their documentation
+type functionsResponse struct {
+	Functions []langserver.FunctionDoc `json:"functions"`
+}
+
+// allowGet rejects requests that are neither GET nor HEAD requests
+func allowGet(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeError(w, http.StatusMethodNotAllowed, "method %s not allowed", r.Method)
+
+		return false
+	}
+
+	return true
+}
+
+// handleMetadata responds to a GET request with the result of handle. Responses carry an ETag,
+// so that clients polling for changes can send If-None-Match and don't download unchanged lists again.
+func (a *api) handleMetadata(w http.ResponseWriter, r *http.Request,
+	handle func(s langserver.HeadlessServer) (interface{}, error)) {
+	s, err := a.newServer(r)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to create language server: %s", err.Error())
+		return
+	}
+	defer s.Close()
+
+	response, err := handle(s)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, "%s", err.Error())
+		return
+	}
+
+	writeCacheable(w, r, response)
+}
+
+// handleMetricNames returns the names of the metrics known to the Prometheus server
+func (a *api) handleMetricNames(w http.ResponseWriter, r *http.Request) {
+	if !allowGet(w, r) {
+		return
+	}
+
+	a.handleMetadata(w, r, func(s langserver.HeadlessServer) (interface{}, error) {
+		names, err := s.MetricNames(r.Context())
+		if err != nil {
+			return nil, err
+		}
+
+		return &metricNamesResponse{Metrics: names}, nil
+	})
+}
+
+// handleLabelValues returns the values of the label given by the label parameter
+func (a *api) handleLabelValues(w http.ResponseWriter, r *http.Request) {
+	if !allowGet(w, r) {
+		return
+	}
+
+	label := r.URL.Query().Get("label")
+	if label == "" {
+		writeError(w, http.StatusBadRequest, "missing label parameter")
+		return
+	}
+
+	a.handleMetadata(w, r, func(s langserver.HeadlessServer) (interface{}, error) {
+		values, err := s.LabelValues(r.Context(), label)
+		if err != nil {
+			return nil, err
+		}
+
+		return &labelValuesResponse{Label: label, Values: values}, nil
+	})
+}
+
+// handleFunctions returns the documentation of the PromQL functions
+func (a *api) handleFunctions(w http.ResponseWriter, r *http.Request) {
+	if !allowGet(w, r) {
+		return
+	}
+
+	a.handleMetadata(w, r, func(s langserver.HeadlessServer) (interface{}, error) {
+		return &functionsResponse{Functions: s.FunctionDocs()}, nil
+	})
+}
+
+// writeCacheable writes a JSON response with a strong ETag derived from its body.
+// If the client already has the same response, only the status 304 is sent.
+func writeCacheable(w http.ResponseWriter, r *http.Request, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to encode response: %s", err.Error())
+		return
+	}
+
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:]) + `"`
+
+	w.Header().Set("ETag", etag)
+	// Clients have to revalidate, metadata can change any time
+	w.Header().Set("Cache-Control", "no-cache")
+
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	// nolint: errcheck
+	w.Write(body)
+}
+
+// etagMatches returns whether an If-None-Match header matches an ETag.
+// If-None-Match uses the weak comparison, so W/ prefixes are ignored.
+func etagMatches(ifNoneMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+
+	return false
+}
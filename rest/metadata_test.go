@@ -0,0 +1,105 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus-community/promql-langserver/langserver"
+)
+
+// TestMetadataETags checks that the metadata endpoints answer conditional requests for unchanged responses with 304
+func TestMetadataETags(*testing.T) { // nolint: funlen
+	names := `["up"]`
+
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.URL.Path {
+		case "/api/v1/label/__name__/values":
+			fmt.Fprintf(w, `{"status":"success","data":%s}`, names)
+		case "/api/v1/label/job/values":
+			fmt.Fprint(w, `{"status":"success","data":["api","node"]}`)
+		default:
+			fmt.Fprint(w, `{"status":"success","data":{}}`)
+		}
+	}))
+	defer prom.Close()
+
+	handler := CreateHandler(context.Background(), &langserver.Config{PrometheusURL: prom.URL})
+
+	get := func(method string, target string, ifNoneMatch string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, nil)
+		if ifNoneMatch != "" {
+			r.Header.Set("If-None-Match", ifNoneMatch)
+		}
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		return w
+	}
+
+	first := get(http.MethodGet, "/metadata/metrics", "")
+	etag := first.Header().Get("ETag")
+
+	if first.Code != http.StatusOK || first.Body.String() != `{"metrics":["up"]}` || !strings.HasPrefix(etag, `"`) {
+		panic(fmt.Sprintf("unexpected response %d %q with ETag %q", first.Code, first.Body.String(), etag))
+	}
+
+	for _, ifNoneMatch := range []string{etag, "W/" + etag, `"other", ` + etag, "*"} {
+		if w := get(http.MethodGet, "/metadata/metrics", ifNoneMatch); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+			panic(fmt.Sprintf("expected 304 without body for If-None-Match %s, got %d %q", ifNoneMatch, w.Code, w.Body.String()))
+		}
+	}
+
+	names = `["up","process_cpu_seconds_total"]`
+
+	changed := get(http.MethodGet, "/metadata/metrics", etag)
+	if changed.Code != http.StatusOK || changed.Header().Get("ETag") == etag {
+		panic(fmt.Sprintf("expected the changed metric list with a new ETag, got %d %q", changed.Code, changed.Header().Get("ETag")))
+	}
+
+	if w := get(http.MethodGet, "/metadata/label_values?label=job", ""); w.Body.String() != `{"label":"job","values":["api","node"]}` {
+		panic(fmt.Sprintf("unexpected label values %d %q", w.Code, w.Body.String()))
+	}
+
+	if w := get(http.MethodGet, "/metadata/label_values", ""); w.Code != http.StatusBadRequest {
+		panic(fmt.Sprintf("expected a missing label to be rejected, got %d", w.Code))
+	}
+
+	if w := get(http.MethodPost, "/metadata/functions", ""); w.Code != http.StatusMethodNotAllowed {
+		panic(fmt.Sprintf("expected POST to be rejected, got %d", w.Code))
+	}
+
+	var functions functionsResponse
+
+	if err := json.Unmarshal(get(http.MethodGet, "/metadata/functions", "").Body.Bytes(), &functions); err != nil {
+		panic(err)
+	}
+
+	for _, f := range functions.Functions {
+		if f.Name == "rate" && f.Docs != "" {
+			return
+		}
+	}
+
+	panic(fmt.Sprintf("expected the documentation of rate, got %v", functions.Functions))
+}
@@ -0,0 +1,136 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/prometheus-community/promql-langserver/langserver"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// queryURI is the URI the query of a request is analyzed under
+const queryURI = "query.promql"
+
+// queryRequest is a query sent to one of the query endpoints
+type queryRequest struct {
+	Query string `json:"query"`
+	// Offset is the position of the cursor in bytes from the start of the query, it is ignored by /diagnostics and /format
+	Offset int `json:"offset"`
+}
+
+// diagnosticsResponse is the result of analyzing a query
+type diagnosticsResponse struct {
+	Diagnostics []protocol.Diagnostic `json:"diagnostics"`
+}
+
+// handleQuery decodes a query request, analyzes the query in a new session of the shared headless language server
+// and responds with the result of handle
+func (a *api) handleQuery(w http.ResponseWriter, r *http.Request,
+	handle func(s langserver.HeadlessServer, query *queryRequest) (interface{}, error)) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, "method %s not allowed", r.Method)
+
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, a.maxRequestSize())
+
+	var query queryRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&query); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request: %s", err.Error())
+		return
+	}
+
+	s, err := a.newServer(r)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to create language server: %s", err.Error())
+		return
+	}
+	defer s.Close()
+
+	if err := s.AddDocument(queryURI, "promql", query.Query); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid query: %s", err.Error())
+		return
+	}
+
+	response, err := handle(s, &query)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%s", err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// handleDiagnostics returns the diagnostics of a query
+func (a *api) handleDiagnostics(w http.ResponseWriter, r *http.Request) {
+	a.handleQuery(w, r, func(s langserver.HeadlessServer, _ *queryRequest) (interface{}, error) {
+		report, err := s.GetReport(queryURI)
+		if err != nil {
+			return nil, err
+		}
+
+		return &diagnosticsResponse{Diagnostics: append([]protocol.Diagnostic{}, report.Diagnostics...)}, nil
+	})
+}
+
+// handleCompletion returns the completions at the cursor, as LSP completion list
+func (a *api) handleCompletion(w http.ResponseWriter, r *http.Request) {
+	a.handleQuery(w, r, func(s langserver.HeadlessServer, query *queryRequest) (interface{}, error) {
+		return s.Completion(queryURI, query.Offset)
+	})
+}
+
+// handleHover returns the hover at the cursor, as LSP hover or null if there is nothing to show
+func (a *api) handleHover(w http.ResponseWriter, r *http.Request) {
+	a.handleQuery(w, r, func(s langserver.HeadlessServer, query *queryRequest) (interface{}, error) {
+		return s.Hover(queryURI, query.Offset)
+	})
+}
+
+// formatResponse is the formatted query
+type formatResponse struct {
+	Query string `json:"query"`
+}
+
+// handleFormat returns the formatted query
+func (a *api) handleFormat(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, "method %s not allowed", r.Method)
+
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, a.maxRequestSize())
+
+	var query queryRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&query); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request: %s", err.Error())
+		return
+	}
+
+	formatted, err := langserver.FormatQuery(query.Query)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid query: %s", err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, &formatResponse{Query: formatted})
+}
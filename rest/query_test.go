@@ -0,0 +1,150 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/prometheus-community/promql-langserver/langserver"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// TestQueryEndpoints checks the diagnostics, completion and hover endpoints and that requests share the connection to Prometheus
+func TestQueryEndpoints(*testing.T) { // nolint: funlen
+	var (
+		requests   = make(map[string]int)
+		requestsMu sync.Mutex
+	)
+
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestsMu.Lock()
+		requests[r.URL.Path]++
+		requestsMu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.URL.Path {
+		case "/api/v1/label/__name__/values":
+			fmt.Fprint(w, `{"status":"success","data":["http_requests_total","up"]}`)
+		default:
+			fmt.Fprint(w, `{"status":"success","data":{}}`)
+		}
+	}))
+	defer prom.Close()
+
+	handler := CreateHandler(context.Background(), &langserver.Config{PrometheusURL: prom.URL})
+
+	post := func(target string, query string, offset int, response interface{}) {
+		body := fmt.Sprintf(`{"query": %q, "offset": %d}`, query, offset)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, target, strings.NewReader(body)))
+
+		if w.Code != http.StatusOK {
+			panic(fmt.Sprintf("%s responded with %d: %s", target, w.Code, w.Body.String()))
+		}
+
+		if err := json.Unmarshal(w.Body.Bytes(), response); err != nil {
+			panic(err)
+		}
+	}
+
+	var diagnostics diagnosticsResponse
+
+	post("/diagnostics", `sum(rate(http_requests_total[5m])`, 0, &diagnostics)
+
+	if len(diagnostics.Diagnostics) == 0 || diagnostics.Diagnostics[0].Severity != protocol.SeverityError {
+		panic(fmt.Sprintf("expected a syntax error, got %v", diagnostics.Diagnostics))
+	}
+
+	requestsMu.Lock()
+	connecting := requests["/api/v1/status/flags"]
+	requestsMu.Unlock()
+
+	var completions protocol.CompletionList
+
+	post("/completion", `http_req`, len(`http_req`), &completions)
+
+	if len(completions.Items) == 0 || completions.Items[0].Label != "http_requests_total" {
+		panic(fmt.Sprintf("expected http_requests_total to be completed, got %v", completions.Items))
+	}
+
+	for i := 0; i < 3; i++ {
+		var hover protocol.Hover
+
+		post("/hover", `sum(rate(http_requests_total[5m]))`, len(`sum(ra`), &hover)
+
+		if !strings.Contains(hover.Contents.Value, "rate") {
+			panic(fmt.Sprintf("expected the documentation of rate, got %q", hover.Contents.Value))
+		}
+	}
+
+	requestsMu.Lock()
+	defer requestsMu.Unlock()
+
+	if requests["/api/v1/status/buildinfo"] != 1 {
+		panic(fmt.Sprintf("expected to connect to Prometheus once, got %v", requests))
+	}
+
+	if requests["/api/v1/status/flags"] != connecting {
+		panic(fmt.Sprintf("expected the flags only to be requested when connecting, got %v", requests))
+	}
+}
+
+// TestFormatEndpoint checks the formatting of queries
+func TestFormatEndpoint(*testing.T) {
+	handler := CreateHandler(context.Background(), &langserver.Config{})
+
+	tests := []struct {
+		query    string
+		status   int
+		expected string
+	}{
+		{`sum   by(job)(rate(foo[5m]))`, http.StatusOK, `sum by (job) (rate(foo[5m]))`},
+		{`sum(foo) # a comment`, http.StatusOK, `sum(foo) # a comment`},
+		{`sum(foo`, http.StatusBadRequest, ""},
+	}
+
+	for _, test := range tests {
+		body := fmt.Sprintf(`{"query": %q}`, test.query)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/format", strings.NewReader(body)))
+
+		if w.Code != test.status {
+			panic(fmt.Sprintf("expected %q to respond with %d, got %d: %s", test.query, test.status, w.Code, w.Body.String()))
+		}
+
+		if test.status != http.StatusOK {
+			continue
+		}
+
+		var response formatResponse
+
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			panic(err)
+		}
+
+		if response.Query != test.expected {
+			panic(fmt.Sprintf("expected %q to be formatted as %q, got %q", test.query, test.expected, response.Query))
+		}
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rest
+
+import (
+	"net/http"
+)
+
+// uiPage lets operators try the REST API from a browser. It is served from the same origin as the API,
+// so no CORS configuration is required.
+const uiPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>PromQL language server</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+textarea { width: 100%; height: 20em; font-family: monospace; }
+#query { height: 5em; }
+pre { background: #f4f4f4; padding: 1em; }
+.error { color: #b00020; }
+.warning { color: #a06000; }
+</style>
+</head>
+<body>
+<h1>PromQL language server</h1>
+<p>Paste a rule file and validate it with <code>POST /validate/rules</code>.</p>
+<textarea id="content">groups:
+  - name: example
+    rules:
+      - alert: InstanceDown
+        expr: up == 0
+        for: 5m
+</textarea>
+<p><button id="validate">Validate</button> <span id="status"></span></p>
+<pre id="result"></pre>
+<p>Type a query to lint it with <code>POST /diagnostics</code>, format it with <code>POST /format</code>
+or complete it at the cursor with <code>POST /completion</code>.</p>
+<textarea id="query">sum by (job) (rate(http_requests_total[5m]))</textarea>
+<p>
+<button id="lint">Lint</button> <button id="format">Format</button> <button id="complete">Complete</button>
+<span id="query-status"></span>
+</p>
+<pre id="query-result"></pre>
+<script>
+const severities = {1: "error", 2: "warning", 3: "information", 4: "hint"};
+
+document.getElementById("validate").addEventListener("click", async () => {
+  const status = document.getElementById("status");
+  const result = document.getElementById("result");
+
+  status.textContent = "validating…";
+  result.textContent = "";
+
+  try {
+    const resp = await fetch("validate/rules", {
+      method: "POST",
+      headers: {"Content-Type": "application/json"},
+      body: JSON.stringify([{name: "rules.yml", content: document.getElementById("content").value}]),
+    });
+    const body = await resp.json();
+
+    if (!resp.ok) {
+      status.textContent = "request failed: " + body.error;
+      return;
+    }
+
+    status.textContent = body.valid ? "valid" : "invalid";
+
+    for (const file of body.files) {
+      if (file.error) {
+        result.appendChild(document.createTextNode(file.error + "\n"));
+      }
+
+      for (const d of file.diagnostics) {
+        const line = document.createElement("span");
+        line.className = severities[d.severity];
+        line.textContent = (d.range.start.line + 1) + ":" + (d.range.start.character + 1) + " " +
+          severities[d.severity] + ": " + d.message + (d.code ? " [" + d.code + "]" : "") + "\n";
+        result.appendChild(line);
+      }
+    }
+  } catch (e) {
+    status.textContent = "request failed: " + e;
+  }
+});
+
+const query = document.getElementById("query");
+const queryStatus = document.getElementById("query-status");
+const queryResult = document.getElementById("query-result");
+
+// post sends the query and the byte offset of the cursor to a query endpoint
+async function post(endpoint) {
+  queryStatus.textContent = "";
+  queryResult.textContent = "";
+
+  const offset = new TextEncoder().encode(query.value.slice(0, query.selectionStart)).length;
+
+  try {
+    const resp = await fetch(endpoint, {
+      method: "POST",
+      headers: {"Content-Type": "application/json"},
+      body: JSON.stringify({query: query.value, offset: offset}),
+    });
+    const body = await resp.json();
+
+    if (!resp.ok) {
+      queryStatus.textContent = "request failed: " + body.error;
+      return null;
+    }
+
+    return body;
+  } catch (e) {
+    queryStatus.textContent = "request failed: " + e;
+    return null;
+  }
+}
+
+document.getElementById("lint").addEventListener("click", async () => {
+  const body = await post("diagnostics");
+  if (!body) {
+    return;
+  }
+
+  queryStatus.textContent = body.diagnostics.length ? "" : "no problems found";
+
+  for (const d of body.diagnostics) {
+    const line = document.createElement("span");
+    line.className = severities[d.severity];
+    line.textContent = (d.range.start.line + 1) + ":" + (d.range.start.character + 1) + " " +
+      severities[d.severity] + ": " + d.message + (d.code ? " [" + d.code + "]" : "") + "\n";
+    queryResult.appendChild(line);
+  }
+});
+
+document.getElementById("format").addEventListener("click", async () => {
+  const body = await post("format");
+  if (body) {
+    query.value = body.query;
+  }
+});
+
+document.getElementById("complete").addEventListener("click", async () => {
+  const body = await post("completion");
+  if (!body) {
+    return;
+  }
+
+  queryStatus.textContent = body.items.length ? "" : "no completions";
+  queryResult.textContent = body.items.map(item => item.label + (item.detail ? "  " + item.detail : "")).join("\n");
+});
+</script>
+</body>
+</html>
+`
+
+// handleUI serves uiPage
+func handleUI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeError(w, http.StatusMethodNotAllowed, "method %s not allowed", r.Method)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	// nolint: errcheck
+	w.Write([]byte(uiPage))
+}
@@ -0,0 +1,53 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rest
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus-community/promql-langserver/langserver"
+)
+
+// TestUI checks that the web page is only served if it is enabled and uses the query endpoints
+func TestUI(*testing.T) {
+	for _, enabled := range []bool{false, true} {
+		handler := CreateHandler(context.Background(), &langserver.Config{RESTUI: enabled})
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ui", nil))
+
+		if !enabled {
+			if w.Code != http.StatusNotFound {
+				panic(fmt.Sprintf("expected /ui not to be served without rest_ui, got %d", w.Code))
+			}
+
+			continue
+		}
+
+		if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+			panic(fmt.Sprintf("expected the page to be served with rest_ui, got %d %q", w.Code, w.Header().Get("Content-Type")))
+		}
+
+		for _, endpoint := range []string{`"validate/rules"`, `post("diagnostics")`, `post("format")`, `post("completion")`} {
+			if !strings.Contains(w.Body.String(), endpoint) {
+				panic(fmt.Sprintf("expected the page to use %s", endpoint))
+			}
+		}
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+
+	"github.com/prometheus-community/promql-langserver/langserver"
+	"github.com/prometheus-community/promql-langserver/vendored/go-tools/lsp/protocol"
+)
+
+// ruleFile is a rule file submitted for validation
+type ruleFile struct {
+	Name    string `json:"name"`
+	Content string `json:"content"`
+}
+
+// validateRulesResponse is the result of validating a set of rule files
+type validateRulesResponse struct {
+	// Valid is set if none of the files contains errors
+	Valid bool               `json:"valid"`
+	Files []ruleFileResponse `json:"files"`
+}
+
+type ruleFileResponse struct {
+	Name  string `json:"name"`
+	Valid bool   `json:"valid"`
+	// Rules is the number of rules found in the file
+	Rules       int                   `json:"rules"`
+	Error       string                `json:"error,omitempty"`
+	Diagnostics []protocol.Diagnostic `json:"diagnostics"`
+}
+
+// handleValidateRules validates a set of rule files. All files are analyzed together,
+// so the response includes checks spanning multiple files, e.g. for duplicate or
+// cyclic recording rules.
+//
+// The files are either sent as JSON array of objects with a name and a content field
+// or as multipart form.
+func (a *api) handleValidateRules(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, "method %s not allowed", r.Method)
+
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, a.maxRequestSize())
+
+	files, err := readRuleFiles(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request: %s", err.Error())
+		return
+	}
+
+	s, err := a.newServer(r)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to create language server: %s", err.Error())
+		return
+	}
+	defer s.Close()
+
+	addErrs := make([]error, len(files))
+
+	for i, f := range files {
+		addErrs[i] = s.AddDocument(f.Name, "yaml", f.Content)
+	}
+
+	response := &validateRulesResponse{
+		Valid: true,
+		Files: []ruleFileResponse{},
+	}
+
+	for i, f := range files {
+		result := ruleFileResponse{
+			Name:        f.Name,
+			Valid:       true,
+			Diagnostics: []protocol.Diagnostic{},
+		}
+
+		err := addErrs[i]
+		if err == nil {
+			var report *langserver.DocumentReport
+
+			if report, err = s.GetReport(f.Name); err == nil {
+				result.Rules = report.Queries
+				result.Diagnostics = append(result.Diagnostics, report.Diagnostics...)
+			}
+		}
+
+		if err != nil {
+			result.Valid = false
+			result.Error = err.Error()
+		}
+
+		for _, d := range result.Diagnostics {
+			if d.Severity == protocol.SeverityError {
+				result.Valid = false
+			}
+		}
+
+		if !result.Valid {
+			response.Valid = false
+		}
+
+		response.Files = append(response.Files, result)
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// readRuleFiles extracts the submitted rule files from a request
+func readRuleFiles(r *http.Request) ([]ruleFile, error) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		mediaType = ""
+	}
+
+	var files []ruleFile
+
+	if mediaType == "multipart/form-data" {
+		reader, err := r.MultipartReader()
+		if err != nil {
+			return nil, err
+		}
+
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				break
+			}
+
+			if err != nil {
+				return nil, err
+			}
+
+			name := part.FileName()
+			if name == "" {
+				name = part.FormName()
+			}
+
+			content, err := ioutil.ReadAll(part)
+			if err != nil {
+				return nil, err
+			}
+
+			files = append(files, ruleFile{Name: name, Content: string(content)})
+		}
+	} else {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := json.Unmarshal(body, &files); err != nil {
+			return nil, err
+		}
+	}
+
+	seen := make(map[string]bool)
+
+	for _, f := range files {
+		if f.Name == "" {
+			return nil, fmt.Errorf("rule file without name")
+		}
+
+		if seen[f.Name] {
+			return nil, fmt.Errorf("rule file %q submitted twice", f.Name)
+		}
+
+		seen[f.Name] = true
+	}
+
+	return files, nil
+}
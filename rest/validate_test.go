@@ -0,0 +1,121 @@
+// Copyright 2020 Tobias Guggenmos
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus-community/promql-langserver/langserver"
+)
+
+const (
+	validRules = `groups:
+- name: example
+  rules:
+  - record: job:up:sum
+    expr: sum by (job) (up)
+`
+	invalidRules = `groups:
+- name: example
+  rules:
+  - record: job:up:sum
+    expr: sum by (job) (up
+`
+)
+
+// multipartRuleFiles encodes rule files as multipart form
+func multipartRuleFiles(files map[string]string, names ...string) (*bytes.Buffer, string) {
+	body := &bytes.Buffer{}
+	w := multipart.NewWriter(body)
+
+	for _, name := range names {
+		part, err := w.CreateFormFile("file", name)
+		if err != nil {
+			panic(err)
+		}
+
+		fmt.Fprint(part, files[name])
+	}
+
+	if err := w.Close(); err != nil {
+		panic(err)
+	}
+
+	return body, w.FormDataContentType()
+}
+
+// validate posts a request body to /validate/rules
+func validate(body *bytes.Buffer, contentType string) (int, *validateRulesResponse) {
+	handler := CreateHandler(context.Background(), &langserver.Config{})
+
+	r := httptest.NewRequest(http.MethodPost, "/validate/rules", body)
+	r.Header.Set("Content-Type", contentType)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		return w.Code, nil
+	}
+
+	response := &validateRulesResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), response); err != nil {
+		panic(err)
+	}
+
+	return w.Code, response
+}
+
+// TestValidateRules checks that uploaded rule files are validated and that malformed uploads are rejected
+func TestValidateRules(*testing.T) {
+	files := map[string]string{"valid.yml": validRules, "invalid.yml": invalidRules}
+
+	body, contentType := multipartRuleFiles(files, "valid.yml")
+	if code, response := validate(body, contentType); code != http.StatusOK || !response.Valid ||
+		len(response.Files) != 1 || response.Files[0].Rules != 1 {
+		panic(fmt.Sprintf("expected the rule file to be valid, got %d, %+v", code, response))
+	}
+
+	body, contentType = multipartRuleFiles(files, "valid.yml", "invalid.yml")
+	if code, response := validate(body, contentType); code != http.StatusOK || response.Valid ||
+		len(response.Files) != 2 || !response.Files[0].Valid || response.Files[1].Valid {
+		panic(fmt.Sprintf("expected the second rule file to be invalid, got %d, %+v", code, response))
+	}
+
+	jsonBody, err := json.Marshal([]ruleFile{{Name: "invalid.yml", Content: invalidRules}})
+	if err != nil {
+		panic(err)
+	}
+
+	if code, response := validate(bytes.NewBuffer(jsonBody), "application/json"); code != http.StatusOK || response.Valid {
+		panic(fmt.Sprintf("expected the rule file sent as JSON to be invalid, got %d, %+v", code, response))
+	}
+
+	// The body ends inside the headers of the second file, the first file alone is valid
+	body, contentType = multipartRuleFiles(files, "valid.yml", "invalid.yml")
+	truncated := body.String()
+	truncated = truncated[:strings.LastIndex(truncated, "Content-Disposition")+len("Content")]
+
+	if code, response := validate(bytes.NewBufferString(truncated), contentType); code != http.StatusBadRequest {
+		panic(fmt.Sprintf("expected a truncated body to be rejected, got %d, %+v", code, response))
+	}
+}